@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/config"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+)
+
+func runAnalyze(args []string) {
+	flags := newCommandFlags("analyze", "[move...]")
+	loadConfig := flags.config()
+	enginePath := flags.String("engine", "", "Path to the engine to analyze with, instead of the configured engine")
+	fen := flags.String("fen", "", "Position to analyze, before any moves given as arguments, instead of the starting position")
+	pgnPath := flags.String("pgn", "", "PGN file whose first game's final position to analyze, before any moves given as arguments")
+	moveTime := flags.Duration("movetime", 5*time.Second, "How long to search")
+	depth := flags.Int("depth", 0, "Search to this depth, however long it takes, instead of for -movetime")
+	nodes := flags.Int64("nodes", 0, "Search this many nodes, however long it takes, instead of for -movetime")
+	infinite := flags.Bool("infinite", false, "Search until interrupted, printing each new depth as the engine reaches it")
+	searchMoves := flags.String("searchmoves", "", "Only consider these moves, in UCI notation, separated by commas")
+	debug := flags.Bool("debug", false, "Switch on the engine's debug mode and print its info strings (UCI engines only)")
+	flags.parse(args)
+
+	limits := engine.Limits{MoveTime: *moveTime, Depth: *depth, Nodes: *nodes, Infinite: *infinite}
+	if *searchMoves != "" {
+		limits.SearchMoves = strings.Split(*searchMoves, ",")
+	}
+	position, moves := *fen, flags.Args()
+	if *pgnPath != "" {
+		if *fen != "" {
+			log.Fatalln("-fen and -pgn can't be used together")
+		}
+		var err error
+		if position, moves, err = loadGame(*pgnPath, moves); err != nil {
+			log.WithError(err).Fatalln("failed to load game")
+		}
+	}
+
+	client := launchConfiguredEngine(loadConfig(), *enginePath)
+	defer shutdownEngine(client)
+	if *debug {
+		printInfoStrings(client)
+	}
+
+	if err := client.NewGame(false); err != nil {
+		log.WithError(err).Fatalln("failed to reset engine")
+	}
+	if err := client.SetPosition(position, moves); err != nil {
+		log.WithError(err).Fatalln("failed to set engine position")
+	}
+	if limits.Infinite {
+		followSearch(client)
+	}
+	bestmove, err := client.Search(limits)
+	if engine.IsNoMove(err) {
+		fmt.Println("bestmove (none), the game is over")
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatalln("engine failed to search")
+	}
+
+	info := client.Info()
+	fmt.Printf("bestmove %s\n", bestmove)
+	if info.HasScore {
+		fmt.Printf("score    %s\n", formatScore(info.Score))
+	}
+	fmt.Printf("depth    %d\n", info.Depth)
+	fmt.Printf("nodes    %d\n", info.Nodes)
+	if info.NPS > 0 {
+		fmt.Printf("nps      %d\n", info.NPS)
+	}
+	if len(info.PV) > 0 {
+		fmt.Printf("pv       %s\n", strings.Join(info.PV, " "))
+	}
+}
+
+// debugEngine is implemented by engines with a debug mode, whose diagnostics can be read.
+type debugEngine interface {
+	SetDebug(enabled bool) error
+	InfoStrings() []string
+	OnInfoString(func(string))
+}
+
+// printInfoStrings switches on the engine's debug mode and prints its diagnostics, both those it has already sent and
+// those it sends from now on.
+func printInfoStrings(client engine.Engine) {
+	debugger, ok := client.(debugEngine)
+	if !ok {
+		log.Fatalln("engine has no debug mode")
+	}
+	for _, text := range debugger.InfoStrings() {
+		fmt.Printf("info string %s\n", text)
+	}
+	debugger.OnInfoString(func(text string) {
+		fmt.Printf("info string %s\n", text)
+	})
+	if err := debugger.SetDebug(true); err != nil {
+		log.WithError(err).Fatalln("failed to switch on engine's debug mode")
+	}
+}
+
+// followSearch prints what the engine reports each time its search gets deeper, and stops it when interrupted.
+func followSearch(client engine.Engine) {
+	depth := 0
+	client.OnInfo(func(info engine.Info) {
+		if info.Depth <= depth || !info.HasScore {
+			return
+		}
+		depth = info.Depth
+		fmt.Printf("depth %d score %s pv %s\n", info.Depth, formatScore(info.Score), strings.Join(info.PV, " "))
+	})
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		if err := client.Stop(); err != nil {
+			log.WithError(err).Error("failed to stop engine")
+		}
+	}()
+}
+
+// loadGame reads the first game in a PGN file, returning the position it starts from and its moves in UCI notation,
+// followed by more moves.
+func loadGame(path string, more []string) (string, []string, error) {
+	game, err := readGame(path)
+	if err != nil {
+		return "", nil, err
+	}
+	moves, err := game.UCI()
+	if err != nil {
+		return "", nil, err
+	}
+	return game.FEN(), append(moves, more...), nil
+}
+
+func runBench(args []string) {
+	flags := newCommandFlags("bench", "")
+	loadConfig := flags.config()
+	enginePath := flags.String("engine", "", "Path to the engine to measure, instead of the configured engine")
+	moveTime := flags.Duration("movetime", time.Second, "How long to search each position")
+	depth := flags.Int("depth", 0, "Search each position to this depth, however long it takes, instead of for -movetime")
+	flags.parse(args)
+
+	client := launchConfiguredEngine(loadConfig(), *enginePath)
+	defer shutdownEngine(client)
+
+	limits := engine.Limits{MoveTime: *moveTime}
+	if *depth > 0 {
+		limits = engine.Limits{Depth: *depth}
+	}
+	result, err := engine.Bench(client, limits)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to bench engine")
+	}
+	fmt.Printf("%-3s %8s %12s %10s\n", "#", "depth", "nodes", "knps")
+	for i, position := range result.Positions {
+		fmt.Printf("%-3d %8d %12d %10d\n", i+1, position.Depth, position.Nodes, engine.NodesPerSecond(position.Nodes, position.Time)/1000)
+	}
+	fmt.Printf("total %18d %10d\n", result.Nodes, result.NPS()/1000)
+}
+
+// launchConfiguredEngine starts the engine at path, or else the configured engine, with the configured protocol and
+// options.
+func launchConfiguredEngine(cfg *config.Config, path string) engine.Engine {
+	profile := cfg.Engine.EngineProfile
+	if path != "" {
+		profile.Path = path
+	}
+	client, err := launchEngine(profile)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to start engine")
+	}
+	return client
+}
+
+// launchEngine starts the engine described by a profile, readies it, and applies the profile's options to it. If the
+// profile has no path, apollo is looked up on the PATH, and failing that, next to apollod.
+func launchEngine(profile server.EngineProfile) (engine.Engine, error) {
+	path := profile.Path
+	if path == "" {
+		path = "./apollo"
+		if apollo, err := exec.LookPath("apollo"); err == nil {
+			path = apollo
+		}
+	}
+	client, err := engine.Launch(path, profile.Protocol, nil, profile.Program())
+	if err != nil {
+		return nil, err
+	}
+
+	skipped, err := engine.Configure(client, profile.Options)
+	for _, skip := range skipped {
+		log.WithError(skip.Reason).WithField("option", skip.Name).Warn("skipping configured engine option")
+	}
+	if err != nil {
+		shutdownEngine(client)
+		return nil, err
+	}
+	return client, nil
+}
+
+func shutdownEngine(client engine.Engine) {
+	if err := client.Quit(); err != nil {
+		log.WithError(err).Warn("failed to send quit to engine")
+	}
+	if err := client.Close(); err != nil {
+		log.WithError(err).Warn("engine did not exit cleanly")
+	}
+}
+
+func formatScore(score engine.Score) string {
+	if score.Mate != 0 {
+		return fmt.Sprintf("mate %d", score.Mate)
+	}
+	return fmt.Sprintf("cp %d", score.Centipawns)
+}
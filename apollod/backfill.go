@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/archive"
+	"github.com/swgillespie/apollo/apollod/pkg/backfill"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/config"
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+)
+
+// runBackfill fills the configured archive, results, and database with the bot's past games from its lichess history,
+// so that reports cover everything the account has ever played.
+func runBackfill(args []string) {
+	flags := newCommandFlags("backfill", "")
+	loadConfig := flags.config()
+	account := flags.String("account", "", "Username of the configured account to backfill, instead of the main account")
+	since := flags.String("since", "", "Only backfill games started on or after this date, as YYYY-MM-DD")
+	pageSize := flags.Int("pageSize", backfill.DefaultPageSize, "Number of games to export from lichess at a time")
+	flags.parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := loadConfig()
+	options := backfill.Options{PageSize: *pageSize}
+	if *since != "" {
+		date, err := time.Parse("2006-01-02", *since)
+		if err != nil {
+			log.WithError(err).Fatalln("invalid -since")
+		}
+		options.Since = date
+	}
+	if archived := cfg.ArchiveStore(); archived != nil {
+		options.Archive = &archive.Archive{Store: archived}
+	}
+	switch {
+	case cfg.Database.Driver != "":
+		db, err := store.Open(cfg.Database.Driver, cfg.Database.DSN)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to open database")
+		}
+		defer db.Close()
+		options.Store, options.Results = db, db
+	case cfg.Results.Path != "":
+		options.Results = &results.Store{Path: cfg.Results.Path}
+	case options.Archive == nil:
+		log.Fatalln("no archive, results file, or database configured to backfill")
+	}
+
+	// Interrupting a backfill leaves everything consistent, and running it again picks up where it left off.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	client, username := backfillAccount(ctx, cfg.Token, cfg.Accounts, *account)
+	options.Username = username
+	summary, err := backfill.Backfill(ctx, client.Games, options)
+	fields := log.Fields{
+		"username":   username,
+		"exported":   summary.Exported,
+		"backfilled": summary.Backfilled,
+		"skipped":    summary.Skipped,
+	}
+	if err != nil {
+		log.WithError(err).WithFields(fields).Fatalln("backfill failed")
+	}
+	log.WithFields(fields).Info("backfill finished")
+}
+
+// backfillAccount finds the lichess client and username of the account to backfill: the main account, unless another
+// configured account is named.
+func backfillAccount(ctx context.Context, token string, accounts []config.AccountConfig, name string) (*blitz.Client, string) {
+	tokens := []string{token}
+	if name != "" {
+		for _, account := range accounts {
+			tokens = append(tokens, account.Token)
+		}
+	}
+	for _, token := range tokens {
+		client := blitz.New(token)
+		profile, err := client.Account.GetProfile(ctx)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to look up account")
+		}
+		if name == "" || strings.EqualFold(profile.Username, name) {
+			return client, profile.Username
+		}
+	}
+	log.Fatalf("no configured account is named %q", name)
+	return nil, ""
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+)
+
+// runBuildBook builds a Polyglot opening book from PGN files, such as archived games or lichess exports, for the
+// server's book or selfplay openings.
+func runBuildBook(args []string) {
+	flags := newCommandFlags("build-book", "games.pgn [games.pgn...]")
+	out := flags.String("out", "book.bin", "Path to write the Polyglot book to")
+	maxPly := flags.Int("maxPly", 20, "Number of plies of every game to put in the book, or 0 for every move")
+	minGames := flags.Int("minGames", 1, "Leave out moves played in fewer games than this")
+	weighting := flags.String("weight", string(book.WeightFrequency), "Weight moves by how often they were played (frequency) or how well they scored (score)")
+	flags.parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// The files are read as one stream of games.
+	var games []io.Reader
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to open games")
+		}
+		defer file.Close()
+		games = append(games, file)
+	}
+	openings, err := book.Build(io.MultiReader(games...), book.BuildOptions{
+		MaxPly:    *maxPly,
+		MinGames:  *minGames,
+		Weighting: book.Weighting(*weighting),
+	})
+	if err != nil {
+		log.WithError(err).Fatalln("failed to build book")
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to create book")
+	}
+	if err := openings.WritePolyglot(file); err != nil {
+		file.Close()
+		log.WithError(err).Fatalln("failed to write book")
+	}
+	if err := file.Close(); err != nil {
+		log.WithError(err).Fatalln("failed to write book")
+	}
+	log.WithFields(log.Fields{
+		"path":      *out,
+		"positions": openings.Len(),
+	}).Info("wrote opening book")
+}
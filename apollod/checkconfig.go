@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/config"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+)
+
+// checkPosition is a tablebase position, used to see whether the tablebase answers at all.
+const checkPosition = "4k3/8/8/8/8/8/8/4K2R w K - 0 1"
+
+// checker runs check-config's checks, printing each result and counting the problems so that all of them can be
+// reported at once.
+type checker struct {
+	problems int
+}
+
+func (c *checker) report(what string, err error) {
+	if err != nil {
+		c.problems++
+		fmt.Printf("FAIL %s: %v\n", what, err)
+		return
+	}
+	fmt.Printf("ok   %s\n", what)
+}
+
+// runCheckConfig checks everything the configuration refers to, exiting with a nonzero status if anything is wrong.
+func runCheckConfig(args []string) {
+	flags := newCommandFlags("check-config", "")
+	path := flags.String("config", "", "Path to the server's YAML configuration file")
+	timeout := flags.Duration("timeout", 30*time.Second, "Longest to wait for lichess and the tablebase")
+	flags.parse(args)
+
+	cfg, err := config.Load(*path)
+	if err != nil {
+		fmt.Printf("FAIL configuration: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("ok   configuration")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := &checker{}
+
+	tokens := []string{cfg.Token}
+	for _, account := range cfg.Accounts {
+		tokens = append(tokens, account.Token)
+	}
+	for i, token := range tokens {
+		c.checkAccount(ctx, cfg, i, token)
+	}
+
+	c.checkEngine("engine", cfg.Engine.EngineProfile)
+	c.checkProfiles("engine", cfg.Engine.Profiles)
+	c.checkProfiles("variant engine", cfg.Engine.Variants)
+	for i, account := range cfg.Accounts {
+		if account.Engine != nil {
+			what := fmt.Sprintf("account %d engine", i+1)
+			c.checkEngine(what, account.Engine.EngineProfile)
+			c.checkProfiles(what, account.Engine.Profiles)
+			c.checkProfiles(fmt.Sprintf("account %d variant engine", i+1), account.Engine.Variants)
+		}
+	}
+
+	if cfg.Book.Path != "" {
+		openings, err := book.Open(cfg.Book.Path, 0)
+		what := "book " + cfg.Book.Path
+		if err == nil {
+			what += fmt.Sprintf(" (%d positions)", openings.Len())
+		}
+		c.report(what, err)
+	}
+	if cfg.Tablebase.Enabled {
+		_, err := blitz.New("").Tablebase.Standard(ctx, checkPosition)
+		c.report("tablebase", err)
+	}
+	if cfg.Database.Driver != "" {
+		db, err := store.Open(cfg.Database.Driver, cfg.Database.DSN)
+		if err == nil {
+			err = db.Close()
+		}
+		c.report("database", err)
+	}
+
+	c.checkDir("logging.gameDir", cfg.Logging.GameDir)
+	c.checkDir("logging.files.dir", cfg.Logging.Files.Dir)
+	c.checkDir("archive.dir", cfg.Archive.Dir)
+	if cfg.Results.Path != "" {
+		c.checkDir("results.path", filepath.Dir(cfg.Results.Path))
+	}
+	if cfg.Blocklist.Path != "" {
+		c.checkDir("blocklist.path", filepath.Dir(cfg.Blocklist.Path))
+	}
+
+	if c.problems > 0 {
+		fmt.Printf("\n%d problem(s) found\n", c.problems)
+		os.Exit(1)
+	}
+	fmt.Println("\nno problems found")
+}
+
+// checkAccount checks that an account's token works, belongs to a BOT account, and grants every scope the
+// configuration needs. Account 0 is the main account.
+func (c *checker) checkAccount(ctx context.Context, cfg *config.Config, i int, token string) {
+	what := "token"
+	if i > 0 {
+		what = fmt.Sprintf("account %d token", i)
+	}
+	if token == "" {
+		c.report(what, errors.New("not set"))
+		return
+	}
+
+	client := blitz.New(token)
+	profile, err := client.Account.GetProfile(ctx)
+	if err != nil {
+		c.report(what, err)
+		return
+	}
+	what += " for " + profile.Username
+	if profile.Title != "BOT" {
+		c.report(what, errors.New("account is not a BOT account"))
+		return
+	}
+
+	scopes, err := client.Account.GetScopes(ctx)
+	if err != nil {
+		c.report(what, err)
+		return
+	}
+	granted := make(map[string]bool)
+	for _, scope := range scopes {
+		granted[scope] = true
+	}
+	var missing []string
+	for _, scope := range requiredScopes(cfg) {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		err = errors.Errorf("missing scopes %s", strings.Join(missing, ", "))
+	}
+	c.report(what, err)
+}
+
+// requiredScopes lists the lichess OAuth scopes that the configured features need.
+func requiredScopes(cfg *config.Config) []string {
+	scopes := []string{"bot:play"}
+	if cfg.Matchmaking.Enabled || cfg.Games.Rematch {
+		scopes = append(scopes, "challenge:write")
+	}
+	if cfg.Blocklist.BlockOnLichess {
+		scopes = append(scopes, "follow:write")
+	}
+	return scopes
+}
+
+// checkEngine checks that an engine starts, completes its protocol's handshake, and supports every configured option
+// with a value that it takes.
+func (c *checker) checkEngine(what string, profile server.EngineProfile) {
+	options := profile.Options
+	profile.Options = nil
+	client, err := launchEngine(profile)
+	if err != nil {
+		c.report(what, err)
+		return
+	}
+	defer shutdownEngine(client)
+
+	what += " " + client.Name()
+	var unsupported, invalid []string
+	for name, value := range options {
+		declared, ok := engine.LookupOption(client.Options(), name)
+		if !ok {
+			unsupported = append(unsupported, name)
+		} else if err := declared.Validate(value); err != nil {
+			invalid = append(invalid, err.Error())
+		}
+	}
+	sort.Strings(unsupported)
+	sort.Strings(invalid)
+	if len(unsupported) > 0 {
+		err = errors.Errorf("unsupported options %s", strings.Join(unsupported, ", "))
+	} else if len(invalid) > 0 {
+		err = errors.New(strings.Join(invalid, "; "))
+	}
+	c.report(what, err)
+}
+
+// checkProfiles checks every engine profile, keyed by the speed or variant it's played for.
+func (c *checker) checkProfiles(what string, profiles map[string]server.EngineProfile) {
+	keys := make([]string, 0, len(profiles))
+	for key := range profiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		c.checkEngine(fmt.Sprintf("%s (%s)", what, key), profiles[key])
+	}
+}
+
+// checkDir checks that files can be written to dir. A directory that doesn't exist yet is fine, as long as it can be
+// created.
+func (c *checker) checkDir(what, dir string) {
+	if dir == "" {
+		return
+	}
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				c.report(what, errors.Errorf("%s is not a directory", existing))
+				return
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(existing) == existing {
+			c.report(what, err)
+			return
+		}
+		existing = filepath.Dir(existing)
+	}
+
+	file, err := ioutil.TempFile(existing, ".apollod-check")
+	if err == nil {
+		file.Close()
+		err = os.Remove(file.Name())
+	}
+	c.report(what+" "+dir, err)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+// runConvert converts games between game records, PGN, and lichess's JSON export. Which of them the input is, is
+// worked out from what it looks like.
+func runConvert(args []string) {
+	flags := newCommandFlags("convert", "games.pgn|games.json")
+	to := flags.String("to", "record", "Format to convert to: record, pgn, or lichess")
+	out := flags.String("out", "", "File to write the converted games to, instead of standard output")
+	flags.parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	switch *to {
+	case "record", "pgn", "lichess":
+	default:
+		log.Fatalf("unknown format %q, expected record, pgn, or lichess", *to)
+	}
+
+	input, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		log.WithError(err).Fatalln("failed to read games")
+	}
+	records, err := readRecords(input)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to read games")
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to create converted games")
+		}
+		defer file.Close()
+		w = file
+	}
+	for i, record := range records {
+		if err := writeRecord(w, record, *to); err != nil {
+			log.WithError(err).Fatalf("failed to convert game %d", i+1)
+		}
+	}
+	log.WithField("games", len(records)).Info("converted games")
+}
+
+// readRecords reads games in any of the formats that convert understands. JSON is either game records, which always
+// have a version, or lichess's export, which doesn't; anything else is PGN.
+func readRecords(input []byte) ([]*gamerecord.Record, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(input), []byte("{")) {
+		games, err := pgn.ReadAll(bytes.NewReader(input))
+		if err != nil {
+			return nil, err
+		}
+		var records []*gamerecord.Record
+		for _, game := range games {
+			record, err := gamerecord.FromPGN(game)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	var records []*gamerecord.Record
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read game %d", len(records)+1)
+		}
+
+		var probe struct {
+			Version *int `json:"version"`
+		}
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return nil, err
+		}
+		var record *gamerecord.Record
+		if probe.Version != nil {
+			record, err = gamerecord.Read(bytes.NewReader(raw))
+		} else {
+			var summary blitz.GameSummary
+			if err = json.Unmarshal(raw, &summary); err == nil {
+				record, err = gamerecord.FromLichess(summary)
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read game %d", len(records)+1)
+		}
+		records = append(records, record)
+	}
+}
+
+func writeRecord(w io.Writer, record *gamerecord.Record, format string) error {
+	switch format {
+	case "pgn":
+		game, err := record.PGN()
+		if err != nil {
+			return err
+		}
+		return game.Write(w)
+	case "lichess":
+		summary, err := record.Lichess()
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(summary)
+	default:
+		return record.Write(w)
+	}
+}
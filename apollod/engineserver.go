@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/enginerpc"
+)
+
+// runEngineServer serves the configured engine over gRPC, starting a copy of it for every client, so that apollod and
+// selfplay workers elsewhere can play with it using the grpc protocol. The server doesn't authenticate its clients, so
+// it listens on loopback unless told otherwise, and must only be exposed on a trusted network.
+func runEngineServer(args []string) {
+	flags := newCommandFlags("engine-server", "")
+	loadConfig := flags.config()
+	listen := flags.String("listen", "127.0.0.1:9090",
+		"Address to serve the engine on; clients aren't authenticated, so only listen on trusted networks")
+	maxSessions := flags.Int("maxSessions", runtime.NumCPU(), "Most engines to run for clients at once, or 0 for no limit")
+	idleTimeout := flags.Duration("idleTimeout", 10*time.Minute,
+		"How long a client can leave its engine unused before it's closed, or 0 to keep it until the client closes it")
+	enginePath := flags.String("engine", "", "Path to the engine to serve, instead of the configured engine")
+	protocol := flags.String("protocol", "", "Protocol the engine speaks, instead of the configured protocol")
+	flags.parse(args)
+
+	cfg := loadConfig()
+	profile := cfg.Engine.EngineProfile
+	if *enginePath != "" {
+		profile.Path = *enginePath
+	}
+	if *protocol != "" {
+		profile.Protocol = *protocol
+	}
+	if profile.Protocol == "grpc" {
+		log.Fatalln("an engine server can't serve a remote engine")
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to listen")
+	}
+	engines := enginerpc.NewServer(func() (engine.Engine, error) {
+		return launchEngine(profile)
+	}, enginerpc.WithMaxSessions(*maxSessions), enginerpc.WithIdleTimeout(*idleTimeout))
+	server := grpc.NewServer()
+	engines.Register(server)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Info("shutting down engine server")
+		server.Stop()
+	}()
+
+	log.WithField("addr", listener.Addr().String()).Info("serving engine")
+	if err := server.Serve(listener); err != nil {
+		log.WithError(err).Error("engine server failed")
+	}
+	engines.Shutdown()
+}
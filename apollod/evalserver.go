@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/evalserver"
+)
+
+// runEvalServer serves position evaluations over HTTP from a pool of the configured engine, for local tools that want
+// apollo's opinion of a position without speaking UCI.
+func runEvalServer(args []string) {
+	defaults := evalserver.DefaultConfig()
+	flags := newCommandFlags("evalserver", "")
+	loadConfig := flags.config()
+	listen := flags.String("listen", "127.0.0.1:9092", "Address to serve evaluations on")
+	enginePath := flags.String("engine", "", "Path to the engine to evaluate with, instead of the configured engine")
+	engines := flags.Int("engines", defaults.Engines, "Number of engines searching at once")
+	queue := flags.Int("queue", defaults.Queue, "Number of requests that may wait for an engine before more are turned away")
+	perClient := flags.Int("perClient", defaults.PerClient, "Number of requests any one client may have in progress at once, or 0 for no limit")
+	moveTime := flags.Duration("movetime", defaults.MoveTime, "How long to search when a request doesn't say")
+	maxMoveTime := flags.Duration("maxMovetime", defaults.MaxMoveTime, "Longest a request may ask to search for")
+	maxDepth := flags.Int("maxDepth", defaults.MaxDepth, "Deepest a request may ask to search to, or 0 for no limit")
+	flags.parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	profile := loadConfig().Engine.EngineProfile
+	if *enginePath != "" {
+		profile.Path = *enginePath
+	}
+	evaluator, err := evalserver.New(func() (engine.Engine, error) {
+		return launchEngine(profile)
+	}, evalserver.Config{
+		Engines:     *engines,
+		Queue:       *queue,
+		PerClient:   *perClient,
+		MoveTime:    *moveTime,
+		MaxMoveTime: *maxMoveTime,
+		MaxDepth:    *maxDepth,
+	})
+	if err != nil {
+		log.WithError(err).Fatalln("invalid evaluation server settings")
+	}
+
+	server := &http.Server{Addr: *listen, Handler: evaluator.Handler()}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Info("shutting down evaluation server")
+		server.Shutdown(context.Background())
+	}()
+
+	log.WithField("addr", *listen).Info("serving evaluations")
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.WithError(err).Error("evaluation server failed")
+	}
+	evaluator.Shutdown()
+}
@@ -1,75 +1,149 @@
 package main // import "github.com/swgillespie/apollo/apollod"
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"os"
-	"runtime"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/swgillespie/apollo/apollod/pkg/selfplay"
-	"github.com/swgillespie/apollo/apollod/pkg/server"
+	"github.com/swgillespie/apollo/apollod/pkg/config"
 )
 
-var doSelfplay = flag.Bool("selfplay", false, "Run in selfplay mode")
-var baselineEngine = flag.String("baseline", "", "Path to baseline selfplay engine")
-var candidateEngine = flag.String("candidate", "", "Path to candidate selfplay engine")
-var numGames = flag.Int("numGames", 40, "Number of games to play")
-var parallelGames = flag.Int("parallel", runtime.NumCPU(), "Number of games to play in parallel")
-var debug = flag.Bool("debug", false, "Enable debug logging")
+// command is one of apollod's modes, chosen by the first argument. Each parses its own flags from the arguments
+// after it.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands = []command{
+	{"serve", "Play on lichess as the configured bot accounts", runServe},
+	{"check-config", "Check the configuration and everything it refers to before going live", runCheckConfig},
+	{"smoketest", "Play a single game against the lichess AI to check that a deployment works", runSmokeTest},
+	{"stresstest", "Replay recorded games against the engine on a simulated lichess with latency, clock skew, and stalls", runStressTest},
+	{"backfill", "Fill the archive, results, and database with the account's past games from lichess", runBackfill},
+	{"report", "Print a daily or weekly performance report from the configured results", runReport},
+	{"selfplay", "Play a match between a baseline and a candidate engine", runSelfplay},
+	{"tournament", "Play a round robin between several engines", runTournament},
+	{"engine-server", "Serve the configured engine to remote clients over gRPC", runEngineServer},
+	{"uci", "Speak UCI on stdin and stdout, passing everything on to the configured engine", runUCIProxy},
+	{"evalserver", "Serve position evaluations from the configured engine over HTTP", runEvalServer},
+	{"analyze", "Analyze a position with the configured engine", runAnalyze},
+	{"replay", "Replay a game through the configured engine and annotate its mistakes and blunders", runReplay},
+	{"bench", "Measure the configured engine's search speed", runBench},
+	{"build-book", "Build a Polyglot opening book from PGN games", runBuildBook},
+	{"convert", "Convert games between game records, PGN, and lichess's JSON export", runConvert},
+	{"version", "Print version information", runVersion},
+}
 
 func main() {
-	flag.Parse()
 	log.SetLevel(log.InfoLevel)
-	if *debug {
-		log.SetLevel(log.DebugLevel)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
 	}
 
-	if *doSelfplay {
-		runSelfplay()
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
 		return
 	}
+	fmt.Fprintf(os.Stderr, "apollod: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	lichessToken := os.Getenv("LICHESS_TOKEN")
-	if lichessToken == "" {
-		log.Fatalln("failed to read LICHESS_TOKEN")
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apollod <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'apollod <command> -h' for a command's flags.")
+}
 
-	svr, err := server.NewServer(lichessToken)
-	if err != nil {
-		log.WithError(err).Fatalln("failed to assume lichess account role")
+// commandFlags are a command's flags, which always include -debug and -log-format.
+type commandFlags struct {
+	*flag.FlagSet
+	debug     *bool
+	logFormat *string
+	// configPath is set if the command takes -config.
+	configPath *string
+}
+
+// newCommandFlags creates the flags for a command. synopsis describes the command's arguments after its flags.
+func newCommandFlags(name, synopsis string) *commandFlags {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: apollod %s [flags] %s\n\n", name, synopsis)
+		flags.PrintDefaults()
 	}
+	return &commandFlags{
+		FlagSet:   flags,
+		debug:     flags.Bool("debug", false, "Enable debug logging"),
+		logFormat: flags.String("log-format", "", "Write logs as text or json, instead of the configured format"),
+	}
+}
 
-	if err = svr.Run(); err != nil {
-		log.WithError(err).Fatalln("failed to launch server")
+// parse parses a command's arguments and sets up logging accordingly.
+func (f *commandFlags) parse(args []string) {
+	// Flags are parsed with ExitOnError, so this can't fail.
+	_ = f.Parse(args)
+	if *f.debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	if *f.logFormat != "" {
+		setLogFormat(*f.logFormat)
 	}
 }
 
-func runSelfplay() {
-	session := &selfplay.Session{
-		BaselineProgram:  *baselineEngine,
-		CandidateProgram: *candidateEngine,
-		NumGames:         *numGames,
-		NumParallelGames: *parallelGames,
+// config adds -config to a command's flags, returning a function that loads the configuration once they're parsed.
+func (f *commandFlags) config() func() *config.Config {
+	f.configPath = f.String("config", "", "Path to the server's YAML configuration file")
+	return func() *config.Config {
+		cfg, err := f.loadConfig()
+		if err != nil {
+			log.WithError(err).Fatalln("failed to load configuration")
+		}
+		return cfg
 	}
+}
 
-	if session.BaselineProgram == "" {
-		log.Fatalln("baseline engine not provided")
+// loadConfig loads the configuration given by -config, which can be done again later to reload it. The configuration's
+// log level and format apply unless -debug or -log-format were given.
+func (f *commandFlags) loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(*f.configPath)
+	if err != nil {
+		return nil, err
 	}
-	if session.CandidateProgram == "" {
-		log.Fatalln("candidate engine not provided")
+	if !*f.debug {
+		level, _ := log.ParseLevel(cfg.Logging.Level)
+		log.SetLevel(level)
 	}
-	ctx := context.Background()
-	res, err := session.Run(ctx)
-	if err != nil {
-		log.WithError(err).Fatalln("failed to run selfplay")
+	if *f.logFormat == "" {
+		setLogFormat(cfg.Logging.Format)
 	}
+	return cfg, nil
+}
 
-	candidateScore := float64(res.Wins)
-	baselineScore := float64(res.Losses)
-	candidateScore += float64(res.Draws) / float64(2)
-	baselineScore += float64(res.Draws) / float64(2)
-	fmt.Printf("final score: %f-%f\n", candidateScore, baselineScore)
+// setLogFormat switches log output to text or JSON.
+func setLogFormat(format string) {
+	switch format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatalf("unknown log format %q, expected text or json", format)
+	}
 }
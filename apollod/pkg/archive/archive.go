@@ -0,0 +1,179 @@
+// Package archive stores the PGN of every game that apollod plays, alongside a JSON sidecar describing the game and the
+// game's portable record.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+// Metadata describes an archived game. It is written next to the game's PGN as <id>.json.
+type Metadata struct {
+	ID          string    `json:"id"`
+	White       string    `json:"white"`
+	Black       string    `json:"black"`
+	WhiteRating int       `json:"whiteRating"`
+	BlackRating int       `json:"blackRating"`
+	ApolloColor string    `json:"apolloColor"`
+	Result      string    `json:"result"`
+	Status      string    `json:"status"`
+	Variant     string    `json:"variant"`
+	Speed       string    `json:"speed"`
+	Rated       bool      `json:"rated"`
+	ArchivedAt  time.Time `json:"archivedAt"`
+	// Build is the engine build that played the game, if a canary was splitting games between two builds.
+	Build string `json:"build,omitempty"`
+	// Searches describes the engine's search for each of apollo's moves that it searched for.
+	Searches []Search `json:"searches,omitempty"`
+}
+
+// Search is the engine's final report from its search for one move.
+type Search struct {
+	// Ply is the number of half-moves played before the move.
+	Ply      int    `json:"ply"`
+	Move     string `json:"move"`
+	Depth    int    `json:"depth"`
+	SelDepth int    `json:"seldepth,omitempty"`
+	Nodes    int64  `json:"nodes"`
+	NPS      int64  `json:"nps"`
+	// TimeMs is how long the search took, as measured by apollod.
+	TimeMs int `json:"timeMs"`
+	// Centipawns and Mate are the engine's evaluation from apollo's side. Mate, if nonzero, takes precedence.
+	Centipawns int `json:"cp"`
+	Mate       int `json:"mate,omitempty"`
+}
+
+// Store is somewhere that archived objects can be written.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+}
+
+// Archive writes games to a Store.
+type Archive struct {
+	Store Store
+}
+
+// Save writes a game's PGN as <id>.pgn, its metadata as <id>.json, and its record as <id>.record.json. A game whose
+// PGN can't be converted into a record is still archived, without one.
+func (a *Archive) Save(ctx context.Context, metadata Metadata, pgn string) error {
+	if metadata.ArchivedAt.IsZero() {
+		metadata.ArchivedAt = time.Now().UTC()
+	}
+
+	if err := a.Store.Put(ctx, metadata.ID+".pgn", []byte(pgn), "application/x-chess-pgn"); err != nil {
+		return errors.Wrap(err, "failed to archive pgn")
+	}
+
+	sidecar, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := a.Store.Put(ctx, metadata.ID+".json", sidecar, "application/json"); err != nil {
+		return errors.Wrap(err, "failed to archive metadata")
+	}
+
+	record, err := Record(metadata, pgn)
+	if err != nil {
+		log.WithError(err).WithField("game", metadata.ID).Warn("failed to convert archived game into a record")
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := record.Write(&buf); err != nil {
+		return err
+	}
+	if err := a.Store.Put(ctx, metadata.ID+".record.json", buf.Bytes(), "application/json"); err != nil {
+		return errors.Wrap(err, "failed to archive record")
+	}
+	return nil
+}
+
+// Record converts an archived game into a record, filling in what its PGN doesn't say from its metadata, including
+// the engine's evaluation after each of apollo's moves.
+func Record(metadata Metadata, game string) (*gamerecord.Record, error) {
+	parsed, err := pgn.Parse(game)
+	if err != nil {
+		return nil, err
+	}
+	record, err := gamerecord.FromPGN(parsed)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.ID != "" {
+		record.ID = metadata.ID
+	}
+	if metadata.Variant != "" {
+		record.Variant = metadata.Variant
+	}
+	if metadata.Speed != "" {
+		record.Speed = metadata.Speed
+	}
+	if metadata.Status != "" {
+		record.Termination = metadata.Status
+	}
+	record.Rated = record.Rated || metadata.Rated
+	if record.White.Name == "" {
+		record.White.Name = metadata.White
+	}
+	if record.Black.Name == "" {
+		record.Black.Name = metadata.Black
+	}
+	if metadata.WhiteRating > 0 {
+		record.White.Rating = metadata.WhiteRating
+	}
+	if metadata.BlackRating > 0 {
+		record.Black.Rating = metadata.BlackRating
+	}
+	if metadata.Build != "" {
+		if record.Tags == nil {
+			record.Tags = make(map[string]string)
+		}
+		record.Tags["Build"] = metadata.Build
+	}
+
+	// Searches are from apollo's side, and records are from white's.
+	sign := 1
+	if metadata.ApolloColor == "black" {
+		sign = -1
+	}
+	for _, search := range metadata.Searches {
+		if search.Ply < 0 || search.Ply >= len(record.Moves) {
+			continue
+		}
+		record.Moves[search.Ply].Eval = &gamerecord.Eval{
+			Centipawns: sign * search.Centipawns,
+			Mate:       sign * search.Mate,
+			Depth:      search.Depth,
+		}
+	}
+	return record, nil
+}
+
+// DirectoryStore writes objects as files in a local directory.
+type DirectoryStore struct {
+	Dir string
+}
+
+func (d DirectoryStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash never leaves a truncated game in the archive.
+	path := filepath.Join(d.Dir, filepath.Base(key))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
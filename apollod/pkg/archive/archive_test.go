@@ -0,0 +1,78 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+)
+
+func TestDirectoryArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apollod-archive")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	archive := &Archive{Store: DirectoryStore{Dir: dir}}
+	metadata := Metadata{
+		ID:          "abcd1234",
+		Result:      "win",
+		ApolloColor: "black",
+		Searches:    []Search{{Ply: 1, Move: "e7e5", Depth: 12, Centipawns: 40}},
+	}
+	err = archive.Save(context.Background(), metadata, "1. e4 e5 *")
+	assert.NoError(t, err)
+
+	pgn, err := ioutil.ReadFile(filepath.Join(dir, "abcd1234.pgn"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1. e4 e5 *", string(pgn))
+
+	sidecar, err := ioutil.ReadFile(filepath.Join(dir, "abcd1234.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(sidecar), `"result": "win"`)
+
+	file, err := os.Open(filepath.Join(dir, "abcd1234.record.json"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer file.Close()
+	record, err := gamerecord.Read(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "abcd1234", record.ID)
+		assert.Equal(t, []string{"e2e4", "e7e5"}, record.UCI())
+		assert.Nil(t, record.Moves[0].Eval)
+		assert.Equal(t, &gamerecord.Eval{Centipawns: -40, Depth: 12}, record.Moves[1].Eval, "evals are from white's side")
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	var paths []string
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/"))
+		assert.NotEmpty(t, r.Header.Get("X-Amz-Content-Sha256"))
+		paths = append(paths, r.URL.Path)
+	}))
+	defer s3.Close()
+
+	store := &S3Store{
+		Endpoint:  s3.URL,
+		Region:    "us-east-1",
+		Bucket:    "games",
+		Prefix:    "apollo/",
+		AccessKey: "key",
+		SecretKey: "secret",
+	}
+	archive := &Archive{Store: store}
+	assert.NoError(t, archive.Save(context.Background(), Metadata{ID: "abcd1234"}, "*"))
+	assert.Equal(t, []string{"/games/apollo/abcd1234.pgn", "/games/apollo/abcd1234.json", "/games/apollo/abcd1234.record.json"}, paths)
+}
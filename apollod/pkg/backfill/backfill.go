@@ -0,0 +1,248 @@
+// Package backfill fills in the archive, results, and game database with games played before apollod kept them, such
+// as those of a long-running account, from the account's lichess game history.
+package backfill
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/archive"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+)
+
+// DefaultPageSize is how many games are exported from lichess at a time, unless told otherwise.
+const DefaultPageSize = 200
+
+// Options says whose games to backfill and where to put them. Any of Archive, Results, and Store may be nil, and games
+// are only written to the ones that aren't.
+type Options struct {
+	// Username is the account whose history is backfilled.
+	Username string
+	// PageSize is how many games are exported from lichess at a time.
+	PageSize int
+	// Since, if set, stops the backfill at games created before it.
+	Since time.Time
+
+	Archive *archive.Archive
+	Results results.Recorder
+	Store   store.Repository
+}
+
+// Summary counts what a backfill did.
+type Summary struct {
+	// Exported is the number of games lichess exported.
+	Exported int
+	// Backfilled is the number of games written.
+	Backfilled int
+	// Skipped is the number of games that were already recorded, were never played, or are still in progress.
+	Skipped int
+}
+
+// Backfill pages through an account's lichess games, newest first, and writes every finished game that isn't already
+// in the results to the archive, results, and game database. Since games already in the results are skipped, a
+// backfill that stopped part way through can simply be run again.
+func Backfill(ctx context.Context, games blitz.GamesService, options Options) (Summary, error) {
+	var summary Summary
+	if options.Username == "" {
+		return summary, errors.New("backfill needs an account")
+	}
+	if options.PageSize <= 0 {
+		options.PageSize = DefaultPageSize
+	}
+	known := make(map[string]bool)
+	if options.Results != nil {
+		records, err := options.Results.Since(time.Time{})
+		if err != nil {
+			return summary, errors.Wrap(err, "failed to read existing results")
+		}
+		for _, record := range records {
+			known[record.GameID] = true
+		}
+	}
+
+	var until time.Time
+	for {
+		page, err := games.ExportUserGames(ctx, options.Username, blitz.ExportOptions{
+			Max:    options.PageSize,
+			Since:  options.Since,
+			Until:  until,
+			PGN:    true,
+			Clocks: true,
+		})
+		if err != nil {
+			return summary, errors.Wrap(err, "failed to export games")
+		}
+		for _, game := range page {
+			summary.Exported++
+			if known[game.ID] || !gamerecord.Finished(game.Status) {
+				summary.Skipped++
+				continue
+			}
+			if err := backfillGame(ctx, options, game); err != nil {
+				return summary, errors.Wrapf(err, "failed to backfill game %s", game.ID)
+			}
+			known[game.ID] = true
+			summary.Backfilled++
+		}
+		log.WithFields(log.Fields{
+			"exported":   summary.Exported,
+			"backfilled": summary.Backfilled,
+		}).Info("backfilled page of games")
+
+		if len(page) < options.PageSize {
+			return summary, nil
+		}
+		// Games come newest first, so the next page is everything created before the oldest game of this one.
+		oldest := millis(page[len(page)-1].CreatedAt)
+		if !until.IsZero() && !oldest.Before(until) {
+			return summary, errors.New("lichess exported the same page of games twice")
+		}
+		until = oldest.Add(-time.Millisecond)
+	}
+}
+
+// backfillGame writes one game everywhere it's wanted.
+func backfillGame(ctx context.Context, options Options, game blitz.GameSummary) error {
+	isWhite := strings.EqualFold(game.Players.White.User.ID, options.Username)
+	if !isWhite && !strings.EqualFold(game.Players.Black.User.ID, options.Username) {
+		return errors.Errorf("%s didn't play in it", options.Username)
+	}
+	color, opponent := "white", game.Players.Black
+	if !isWhite {
+		color, opponent = "black", game.Players.White
+	}
+	result := outcome(isWhite, game.Winner)
+
+	if options.Archive != nil {
+		err := options.Archive.Save(ctx, archive.Metadata{
+			ID:          game.ID,
+			White:       game.Players.White.User.Name,
+			Black:       game.Players.Black.User.Name,
+			WhiteRating: game.Players.White.Rating,
+			BlackRating: game.Players.Black.Rating,
+			ApolloColor: color,
+			Result:      result,
+			Status:      game.Status,
+			Variant:     game.Variant,
+			Speed:       game.Speed,
+			Rated:       game.Rated,
+		}, game.PGN)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Store != nil {
+		err := options.Store.StartGame(ctx, store.Game{
+			ID:             game.ID,
+			Account:        options.Username,
+			Started:        millis(game.CreatedAt),
+			Opponent:       opponent.User.Name,
+			OpponentID:     opponent.User.ID,
+			OpponentRating: opponent.Rating,
+			OpponentTitle:  opponent.User.Title,
+			Color:          color,
+			Speed:          game.Speed,
+			Variant:        game.Variant,
+			Rated:          game.Rated,
+		})
+		if err != nil {
+			return err
+		}
+		moves, err := gameMoves(game)
+		if err != nil {
+			// The result is still worth having without the moves.
+			log.WithError(err).WithField("game_id", game.ID).Warn("failed to read game's moves, backfilling it without them")
+		}
+		for _, move := range moves {
+			if err := options.Store.RecordMove(ctx, move); err != nil {
+				return err
+			}
+		}
+	}
+
+	if options.Results != nil {
+		err := options.Results.Append(results.Record{
+			Time:           millis(game.LastMoveAt),
+			GameID:         game.ID,
+			Opponent:       opponent.User.Name,
+			OpponentRating: opponent.Rating,
+			OpponentTitle:  opponent.User.Title,
+			Color:          color,
+			Result:         result,
+			Termination:    game.Status,
+			Speed:          game.Speed,
+			Rated:          game.Rated,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gameMoves reads a game's moves, and the clocks after each of them, from its PGN.
+func gameMoves(game blitz.GameSummary) ([]store.Move, error) {
+	parsed, err := pgn.Parse(game.PGN)
+	if err != nil {
+		return nil, err
+	}
+	uci, err := parsed.UCI()
+	if err != nil {
+		return nil, err
+	}
+
+	// Until a player has moved, their clock is what the time control started them with.
+	var white, black time.Duration
+	if control := strings.SplitN(parsed.Tags.Get("TimeControl"), "+", 2); len(control) == 2 {
+		if seconds, err := strconv.Atoi(control[0]); err == nil {
+			white = time.Duration(seconds) * time.Second
+			black = white
+		}
+	}
+	whiteToMove := !strings.Contains(parsed.FEN(), " b ")
+	moves := make([]store.Move, len(uci))
+	for ply, move := range parsed.Moves {
+		if move.HasClock {
+			if whiteToMove {
+				white = move.Clock
+			} else {
+				black = move.Clock
+			}
+		}
+		whiteToMove = !whiteToMove
+		moves[ply] = store.Move{
+			GameID:      game.ID,
+			Ply:         ply,
+			Move:        uci[ply],
+			WhiteTimeMs: int(white / time.Millisecond),
+			BlackTimeMs: int(black / time.Millisecond),
+		}
+	}
+	return moves, nil
+}
+
+// outcome classifies how a finished game went for us.
+func outcome(isWhite bool, winner string) string {
+	switch {
+	case winner == "":
+		return "draw"
+	case (winner == "white") == isWhite:
+		return "win"
+	default:
+		return "loss"
+	}
+}
+
+func millis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
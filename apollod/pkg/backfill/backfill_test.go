@@ -0,0 +1,121 @@
+package backfill
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swgillespie/apollo/apollod/pkg/archive"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+)
+
+const scholarsMate = `[Event "Rated Blitz game"]
+[White "my_bot"]
+[Black "someone"]
+[Result "1-0"]
+[TimeControl "180+2"]
+
+1. e4 { [%clk 0:03:00] } 1... e5 { [%clk 0:02:58] } 2. Qh5 { [%clk 0:02:59] } 2... Nc6 { [%clk 0:02:50] } 3. Bc4 { [%clk 0:02:58] } 3... Nf6 { [%clk 0:02:41] } 4. Qxf7# { [%clk 0:02:57] } 1-0
+`
+
+// historyService exports a fixed history of games, newest first, a page at a time.
+type historyService struct {
+	blitz.GamesService
+	games []blitz.GameSummary
+	pages int
+}
+
+func (h *historyService) ExportUserGames(ctx context.Context, username string, options blitz.ExportOptions) ([]blitz.GameSummary, error) {
+	h.pages++
+	var page []blitz.GameSummary
+	for _, game := range h.games {
+		created := millis(game.CreatedAt)
+		if (!options.Until.IsZero() && created.After(options.Until)) || created.Before(options.Since) {
+			continue
+		}
+		if len(page) == options.Max {
+			break
+		}
+		page = append(page, game)
+	}
+	return page, nil
+}
+
+func historyGame(id string, created int64, status, winner string) blitz.GameSummary {
+	game := blitz.GameSummary{
+		ID:         id,
+		Rated:      true,
+		Variant:    "standard",
+		Speed:      "blitz",
+		Status:     status,
+		Winner:     winner,
+		PGN:        scholarsMate,
+		CreatedAt:  created,
+		LastMoveAt: created + 60000,
+	}
+	game.Players.White.User = blitz.SummaryUser{ID: "my_bot", Name: "my_bot", Title: "BOT"}
+	game.Players.Black.User = blitz.SummaryUser{ID: "someone", Name: "someone"}
+	game.Players.Black.Rating = 1500
+	return game
+}
+
+func TestBackfill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apollod-backfill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := store.Open("sqlite3", filepath.Join(dir, "apollod.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	history := &historyService{games: []blitz.GameSummary{
+		historyGame("e", 5000000, "started", ""),
+		historyGame("d", 4000000, "mate", "white"),
+		historyGame("c", 3000000, "aborted", ""),
+		historyGame("b", 2000000, "draw", ""),
+		historyGame("a", 1000000, "resign", "black"),
+	}}
+	options := Options{
+		Username: "My_Bot",
+		PageSize: 2,
+		Archive:  &archive.Archive{Store: archive.DirectoryStore{Dir: filepath.Join(dir, "archive")}},
+		Results:  db,
+		Store:    db,
+	}
+	summary, err := Backfill(context.Background(), history, options)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Exported: 5, Backfilled: 3, Skipped: 2}, summary)
+	assert.Equal(t, 3, history.pages)
+
+	records, err := db.Since(time.Time{})
+	require.NoError(t, err)
+	var outcomes []string
+	for _, record := range records {
+		outcomes = append(outcomes, record.GameID+":"+record.Result)
+	}
+	assert.Equal(t, []string{"a:loss", "b:draw", "d:win"}, outcomes)
+	_, err = os.Stat(filepath.Join(dir, "archive", "d.pgn"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "archive", "c.pgn"))
+	assert.True(t, os.IsNotExist(err), "aborted games aren't archived")
+
+	// Running it again finds nothing new.
+	summary, err = Backfill(context.Background(), history, options)
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.Backfilled)
+}
+
+func TestGameMoves(t *testing.T) {
+	moves, err := gameMoves(historyGame("a", 0, "mate", "white"))
+	require.NoError(t, err)
+	require.Len(t, moves, 7)
+	assert.Equal(t, store.Move{GameID: "a", Ply: 0, Move: "e2e4", WhiteTimeMs: 180000, BlackTimeMs: 180000}, moves[0])
+	assert.Equal(t, store.Move{GameID: "a", Ply: 3, Move: "b8c6", WhiteTimeMs: 179000, BlackTimeMs: 170000}, moves[3])
+	assert.Equal(t, "h5f7", moves[6].Move)
+}
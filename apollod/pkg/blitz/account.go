@@ -1,6 +1,9 @@
 package blitz
 
-import "context"
+import (
+	"context"
+	"strings"
+)
 
 type AccountResponse struct {
 	ID             string   `json:"id"`
@@ -146,10 +149,25 @@ type Preferences struct {
 	Zen           int    `json:"zen"`
 }
 
+// OngoingGame is a game that the account is currently playing.
+type OngoingGame struct {
+	GameID      string `json:"gameId"`
+	FullID      string `json:"fullId"`
+	Color       string `json:"color"`
+	Fen         string `json:"fen"`
+	IsMyTurn    bool   `json:"isMyTurn"`
+	SecondsLeft int    `json:"secondsLeft"`
+	LastMove    string `json:"lastMove"`
+}
+
 type AccountService interface {
 	GetProfile(ctx context.Context) (*AccountResponse, error)
 	GetEmail(ctx context.Context) (string, error)
 	GetPreferences(ctx context.Context) (*PreferencesResponse, error)
+	// GetOngoingGames lists the games that the account is currently playing.
+	GetOngoingGames(ctx context.Context) ([]OngoingGame, error)
+	// GetScopes lists the OAuth scopes that the token grants, e.g. "bot:play".
+	GetScopes(ctx context.Context) ([]string, error)
 }
 
 type accountServiceImpl struct {
@@ -181,3 +199,29 @@ func (a *accountServiceImpl) GetPreferences(ctx context.Context) (*PreferencesRe
 	}
 	return &prefsResp, nil
 }
+
+func (a *accountServiceImpl) GetOngoingGames(ctx context.Context) ([]OngoingGame, error) {
+	var playingResp struct {
+		NowPlaying []OngoingGame `json:"nowPlaying"`
+	}
+	if err := a.client.get(ctx, "api/account/playing", &playingResp); err != nil {
+		return nil, err
+	}
+	return playingResp.NowPlaying, nil
+}
+
+func (a *accountServiceImpl) GetScopes(ctx context.Context) ([]string, error) {
+	// Lichess reports a token's scopes in a header on every authenticated response.
+	var accountResp AccountResponse
+	header, err := a.client.getWithHeader(ctx, "api/account", &accountResp)
+	if err != nil {
+		return nil, err
+	}
+	var scopes []string
+	for _, scope := range strings.Split(header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
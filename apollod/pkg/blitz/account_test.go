@@ -121,3 +121,41 @@ func TestGetProfile(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, resp.ID, "swgillespie")
 }
+
+func TestGetOngoingGames(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, req.URL.String(), defaultBaseURL+"api/account/playing")
+		return &http.Response{
+			StatusCode: 200,
+			Body: ioutil.NopCloser(bytes.NewBufferString(
+				`{"nowPlaying": [{"gameId": "abcdefgh", "fullId": "abcdefghijkl", "color": "white", "isMyTurn": true, "secondsLeft": 60}]}`)),
+			Header: make(http.Header),
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	games, err := client.Account.GetOngoingGames(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, games, 1) {
+		assert.Equal(t, "abcdefgh", games[0].GameID)
+		assert.True(t, games[0].IsMyTurn)
+	}
+}
+
+func TestGetScopes(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, req.URL.String(), defaultBaseURL+"api/account")
+		header := make(http.Header)
+		header.Set("X-OAuth-Scopes", "bot:play, challenge:write")
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(profileJSONResult)),
+			Header:     header,
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	scopes, err := client.Account.GetScopes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bot:play", "challenge:write"}, scopes)
+}
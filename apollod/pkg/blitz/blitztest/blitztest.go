@@ -0,0 +1,669 @@
+// Package blitztest serves a fake lichess over HTTP, for testing code that plays games through blitz without talking
+// to the real thing. Tests drive the fake from the other side of the board: they send challenges, start games, play
+// the opponent's moves, and watch what the bot does in response.
+package blitztest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+// maxPendingEvents is how many events the fake holds on to while nobody is streaming them.
+const maxPendingEvents = 64
+
+// Server is a fake lichess. The zero value isn't usable; create one with NewServer.
+type Server struct {
+	// URL is the base URL of the fake, suitable for blitz.WithBaseURL.
+	URL string
+
+	http   *httptest.Server
+	events chan string
+	closed chan struct{}
+
+	lock       sync.Mutex
+	account    blitz.AccountResponse
+	conditions *Conditions
+	games      map[string]*Game
+	challenges map[string]blitz.GameFull
+	accepted   []string
+	declined   map[string]string
+}
+
+// NewServer starts a fake lichess on which account is the authenticated user.
+func NewServer(account blitz.AccountResponse) *Server {
+	s := &Server{
+		account:    account,
+		events:     make(chan string, maxPendingEvents),
+		closed:     make(chan struct{}),
+		games:      make(map[string]*Game),
+		challenges: make(map[string]blitz.GameFull),
+		declined:   make(map[string]string),
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.http.URL + "/"
+	return s
+}
+
+// Close ends every open stream and shuts the fake down.
+func (s *Server) Close() {
+	close(s.closed)
+	s.http.CloseClientConnections()
+	s.http.Close()
+}
+
+// Client returns a blitz client that talks to the fake.
+func (s *Server) Client(options ...blitz.ClientOption) *blitz.Client {
+	return blitz.New("token", append([]blitz.ClientOption{blitz.WithBaseURL(s.URL)}, options...)...)
+}
+
+// SetAccount changes the authenticated user's profile, such as their ratings.
+func (s *Server) SetAccount(account blitz.AccountResponse) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.account = account
+}
+
+// Conditions are the ways that the fake can make playing on it harder than playing on lichess usually is, for
+// testing how the bot copes with them.
+type Conditions struct {
+	// Latency holds every move the bot sends for this long before the fake plays it, while the bot's clock runs.
+	Latency time.Duration
+	// Jitter adds up to this much more latency to each move, at random.
+	Jitter time.Duration
+	// ClockSkew is added to the bot's clock in everything the fake tells it, so that it thinks it has more time than
+	// it really does, or less if negative.
+	ClockSkew time.Duration
+	// StallEvery stalls the game's stream for StallFor before every StallEvery-th event sent down it, as if the
+	// connection had hung. Zero never stalls.
+	StallEvery int
+	StallFor   time.Duration
+}
+
+// Simulate makes every game started from now on keep real clocks, which run while each side thinks and flag whoever
+// runs out of time, and play under the given conditions. Without it, clocks stand still at whatever they were when
+// the game started.
+func (s *Server) Simulate(conditions Conditions) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conditions = &conditions
+}
+
+// SendChallenge challenges the bot. If the bot accepts, game starts with the challenge's ID, as it would on lichess.
+func (s *Server) SendChallenge(challenge blitz.Challenge, game blitz.GameFull) {
+	s.lock.Lock()
+	game.ID = challenge.ID
+	s.challenges[challenge.ID] = game
+	s.lock.Unlock()
+	s.sendEvent("challenge", "challenge", challenge)
+}
+
+// StartGame starts a game without a challenge, like one the bot created itself, and tells the bot about it.
+func (s *Server) StartGame(full blitz.GameFull) *Game {
+	game := s.addGame(full)
+	s.sendEvent("gameStart", "game", blitz.GameStart{ID: full.ID})
+	return game
+}
+
+// Game returns the game with the given ID, or nil if it hasn't started.
+func (s *Server) Game(id string) *Game {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.games[id]
+}
+
+// Accepted returns the IDs of the challenges the bot has accepted, in order.
+func (s *Server) Accepted() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.accepted...)
+}
+
+// Declined returns the reason the bot gave for declining each challenge it declined, keyed by challenge ID.
+func (s *Server) Declined() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	declined := make(map[string]string, len(s.declined))
+	for id, reason := range s.declined {
+		declined[id] = reason
+	}
+	return declined
+}
+
+func (s *Server) addGame(full blitz.GameFull) *Game {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	game := newGame(s, full, s.conditions)
+	s.games[full.ID] = game
+	return game
+}
+
+// sendEvent queues an event for the bot's event stream. Lichess wraps the payload of each event in a field named for
+// what it is.
+func (s *Server) sendEvent(ty, field string, payload interface{}) {
+	line, err := json.Marshal(map[string]interface{}{"type": ty, field: payload})
+	if err != nil {
+		panic(err)
+	}
+	select {
+	case s.events <- string(line):
+	default:
+		panic("blitztest: too many events pending, is anyone streaming them?")
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/api/account":
+		s.lock.Lock()
+		account := s.account
+		s.lock.Unlock()
+		writeJSON(w, http.StatusOK, account)
+	case r.URL.Path == "/api/account/playing":
+		s.servePlaying(w)
+	case r.URL.Path == "/api/stream/event":
+		s.serveEvents(w, r)
+	case len(parts) == 4 && parts[1] == "challenge" && parts[3] == "accept":
+		s.serveAccept(w, parts[2])
+	case len(parts) == 4 && parts[1] == "challenge" && parts[3] == "decline":
+		s.lock.Lock()
+		s.declined[parts[2]] = r.FormValue("reason")
+		delete(s.challenges, parts[2])
+		s.lock.Unlock()
+		writeOk(w)
+	case len(parts) == 5 && parts[1] == "bot" && parts[3] == "stream":
+		if game := s.Game(parts[4]); game != nil {
+			game.serveStream(w, r)
+			return
+		}
+		writeError(w, http.StatusNotFound, "No such game")
+	case len(parts) >= 5 && parts[1] == "bot" && parts[2] == "game":
+		if game := s.Game(parts[3]); game != nil {
+			game.serveAction(w, r, parts[4:])
+			return
+		}
+		writeError(w, http.StatusNotFound, "No such game")
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) servePlaying(w http.ResponseWriter) {
+	s.lock.Lock()
+	games := make([]*Game, 0, len(s.games))
+	for _, game := range s.games {
+		games = append(games, game)
+	}
+	s.lock.Unlock()
+
+	playing := make([]blitz.OngoingGame, 0, len(games))
+	for _, game := range games {
+		if !game.finished() {
+			playing = append(playing, blitz.OngoingGame{GameID: game.ID(), FullID: game.ID()})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"nowPlaying": playing})
+}
+
+func (s *Server) serveAccept(w http.ResponseWriter, id string) {
+	s.lock.Lock()
+	full, ok := s.challenges[id]
+	delete(s.challenges, id)
+	if ok {
+		s.accepted = append(s.accepted, id)
+	}
+	s.lock.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such challenge")
+		return
+	}
+	s.addGame(full)
+	s.sendEvent("gameStart", "game", blitz.GameStart{ID: id})
+	writeOk(w)
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case line := <-s.events:
+			if !writeLine(w, line) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Game is a game being played on the fake lichess. The fake keeps the board, so it rejects illegal moves and ends
+// the game on its own once it's over on the board.
+type Game struct {
+	server *Server
+	moves  chan string
+
+	lock        sync.Mutex
+	full        blitz.GameFull
+	board       *chess.Game
+	subscribers []chan string
+	chat        []string
+
+	// conditions, if set, are what the game is played under, with real clocks.
+	conditions *Conditions
+	// turnStarted is when the side to move started thinking, and flag ends the game if they run out of time.
+	turnStarted time.Time
+	flag        *time.Timer
+}
+
+func newGame(server *Server, full blitz.GameFull, conditions *Conditions) *Game {
+	options := []func(*chess.Game){chess.UseNotation(chess.LongAlgebraicNotation{})}
+	if full.InitialFen != "" && full.InitialFen != "startpos" {
+		fen, err := chess.FEN(full.InitialFen)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, fen)
+	}
+	board := chess.NewGame(options...)
+	for _, move := range strings.Fields(full.State.Moves) {
+		if err := board.MoveStr(move); err != nil {
+			panic(fmt.Sprintf("blitztest: illegal move %s in game %s", move, full.ID))
+		}
+	}
+
+	full.Type = "gameFull"
+	full.State.Type = "gameState"
+	if full.State.Status == "" {
+		full.State.Status = "started"
+	}
+	game := &Game{server: server, moves: make(chan string, 512), full: full, board: board, conditions: conditions}
+	if game.timed() {
+		game.startTurn()
+	}
+	return game
+}
+
+// ID returns the game's ID.
+func (g *Game) ID() string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.full.ID
+}
+
+// State returns the game's current state, with the clocks as they really are.
+func (g *Game) State() blitz.GameState {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.full.State
+}
+
+// BotMoves returns a channel of every move the bot plays, in order.
+func (g *Game) BotMoves() <-chan string {
+	return g.moves
+}
+
+// Chat returns everything the bot has said in the game's chat.
+func (g *Game) Chat() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return append([]string(nil), g.chat...)
+}
+
+// Play plays a move for the bot's opponent.
+func (g *Game) Play(move string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.botToMove() {
+		return fmt.Errorf("it's not the opponent's turn to move")
+	}
+	return g.play(move)
+}
+
+// Send sends an arbitrary event down the game's stream, such as a repeat of the current state, without changing the
+// game.
+func (g *Game) Send(event blitz.GameEvent) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.broadcast(event)
+}
+
+// Disconnect ends the game's open streams without ending the game, like lichess occasionally does.
+func (g *Game) Disconnect() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.disconnect()
+}
+
+// End ends the game with the given status and winner ("white", "black", or nothing for a draw), and tells the bot
+// that it's over.
+func (g *Game) End(status, winner string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.end(status, winner)
+}
+
+func (g *Game) finished() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.isOver()
+}
+
+func (g *Game) isOver() bool {
+	switch g.full.State.Status {
+	case "created", "started":
+		return false
+	default:
+		return true
+	}
+}
+
+func (g *Game) botIsWhite() bool {
+	return strings.EqualFold(g.full.White.ID, g.server.account.ID)
+}
+
+func (g *Game) botToMove() bool {
+	return (g.board.Position().Turn() == chess.White) == g.botIsWhite()
+}
+
+// play plays a move for whoever's turn it is, ending the game if that's the end of it on the board.
+func (g *Game) play(move string) error {
+	if g.isOver() {
+		return fmt.Errorf("game %s is already over", g.full.ID)
+	}
+	if g.timed() && !g.chargeClock() {
+		return fmt.Errorf("out of time")
+	}
+	if err := g.board.MoveStr(move); err != nil {
+		return fmt.Errorf("illegal move %s: %v", move, err)
+	}
+	if g.timed() {
+		g.addIncrement()
+		g.startTurn()
+	}
+	g.full.State.Moves = strings.TrimSpace(g.full.State.Moves + " " + move)
+	g.full.State.Wdraw, g.full.State.Bdraw = false, false
+	if g.board.Outcome() == chess.NoOutcome {
+		g.broadcast(g.full.State)
+		return nil
+	}
+
+	winner := ""
+	switch g.board.Outcome() {
+	case chess.WhiteWon:
+		winner = "white"
+	case chess.BlackWon:
+		winner = "black"
+	}
+	switch g.board.Method() {
+	case chess.Checkmate:
+		g.end("mate", winner)
+	case chess.Stalemate:
+		g.end("stalemate", winner)
+	default:
+		g.end("draw", winner)
+	}
+	return nil
+}
+
+// timed returns true if the game's clocks are running.
+func (g *Game) timed() bool {
+	return g.conditions != nil && g.full.Clock.Initial > 0
+}
+
+// whiteToMove returns true if it's white's turn.
+func (g *Game) whiteToMove() bool {
+	return g.board.Position().Turn() == chess.White
+}
+
+// clock returns the clock, in milliseconds, of the side to move.
+func (g *Game) clock() *int {
+	if g.whiteToMove() {
+		return &g.full.State.Wtime
+	}
+	return &g.full.State.Btime
+}
+
+// chargeClock takes the time the side to move spent thinking off their clock, flagging them and returning false if
+// that was more than they had.
+func (g *Game) chargeClock() bool {
+	clock := g.clock()
+	*clock -= int(time.Since(g.turnStarted) / time.Millisecond)
+	if *clock > 0 {
+		return true
+	}
+	g.flagSideToMove()
+	return false
+}
+
+func (g *Game) addIncrement() {
+	// The move has been played, so the side that made it is no longer the side to move.
+	if g.whiteToMove() {
+		g.full.State.Btime += g.full.State.Binc
+	} else {
+		g.full.State.Wtime += g.full.State.Winc
+	}
+}
+
+// startTurn starts the side to move's clock, which flags them if they don't move before it runs out.
+func (g *Game) startTurn() {
+	if g.flag != nil {
+		g.flag.Stop()
+	}
+	g.turnStarted = time.Now()
+	ply := len(g.board.Moves())
+	g.flag = time.AfterFunc(time.Duration(*g.clock())*time.Millisecond, func() {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		if len(g.board.Moves()) == ply && !g.isOver() {
+			g.flagSideToMove()
+		}
+	})
+}
+
+func (g *Game) flagSideToMove() {
+	*g.clock() = 0
+	winner := "white"
+	if g.whiteToMove() {
+		winner = "black"
+	}
+	g.end("outoftime", winner)
+}
+
+// reported returns an event as the bot is told it, with its clock skewed.
+func (g *Game) reported(event blitz.GameEvent) blitz.GameEvent {
+	if g.conditions == nil || g.conditions.ClockSkew == 0 {
+		return event
+	}
+	skew := func(state *blitz.GameState) {
+		ms := int(g.conditions.ClockSkew / time.Millisecond)
+		if g.botIsWhite() {
+			state.Wtime += ms
+		} else {
+			state.Btime += ms
+		}
+	}
+	switch e := event.(type) {
+	case blitz.GameFull:
+		skew(&e.State)
+		return e
+	case blitz.GameState:
+		skew(&e)
+		return e
+	}
+	return event
+}
+
+func (g *Game) end(status, winner string) {
+	if g.isOver() {
+		return
+	}
+	if g.flag != nil {
+		g.flag.Stop()
+	}
+	g.full.State.Status, g.full.State.Winner = status, winner
+	g.broadcast(g.full.State)
+	g.disconnect()
+	g.server.sendEvent("gameFinish", "game", blitz.GameFinish{ID: g.full.ID})
+}
+
+func (g *Game) broadcast(event blitz.GameEvent) {
+	line, err := json.Marshal(g.reported(event))
+	if err != nil {
+		panic(err)
+	}
+	for _, subscriber := range g.subscribers {
+		subscriber <- string(line)
+	}
+}
+
+func (g *Game) disconnect() {
+	for _, subscriber := range g.subscribers {
+		close(subscriber)
+	}
+	g.subscribers = nil
+}
+
+// serveStream streams the game's events. Like lichess, every stream begins with the full game as it stands.
+func (g *Game) serveStream(w http.ResponseWriter, r *http.Request) {
+	g.lock.Lock()
+	full, err := json.Marshal(g.reported(g.full))
+	if err != nil {
+		panic(err)
+	}
+	var events chan string
+	if !g.isOver() {
+		events = make(chan string, 512)
+		g.subscribers = append(g.subscribers, events)
+	}
+	conditions := g.conditions
+	g.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if !writeLine(w, string(full)) || events == nil {
+		return
+	}
+	sent := 1
+	for {
+		select {
+		case line, ok := <-events:
+			if !ok {
+				return
+			}
+			if sent++; conditions != nil && conditions.StallEvery > 0 && sent%conditions.StallEvery == 0 {
+				select {
+				case <-time.After(conditions.StallFor):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if !writeLine(w, line) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-g.server.closed:
+			return
+		}
+	}
+}
+
+// serveAction handles everything the bot can do in a game besides stream it.
+func (g *Game) serveAction(w http.ResponseWriter, r *http.Request, action []string) {
+	if action[0] == "move" {
+		g.delayMove()
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch action[0] {
+	case "move":
+		if len(action) != 2 {
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		if !g.botToMove() {
+			writeError(w, http.StatusBadRequest, "Not your turn, or game already over")
+			return
+		}
+		if err := g.play(action[1]); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		g.moves <- action[1]
+	case "chat":
+		g.chat = append(g.chat, r.FormValue("text"))
+	case "abort":
+		g.end("aborted", "")
+	case "resign":
+		winner := "white"
+		if g.botIsWhite() {
+			winner = "black"
+		}
+		g.end("resign", winner)
+	case "claim-victory":
+		winner := "black"
+		if g.botIsWhite() {
+			winner = "white"
+		}
+		g.end("timeout", winner)
+	case "draw", "takeback":
+		// The opponent never offers either, so there's nothing to answer.
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	writeOk(w)
+}
+
+// delayMove holds up a move the bot sent for as long as the game's conditions say it takes to arrive.
+func (g *Game) delayMove() {
+	g.lock.Lock()
+	conditions := g.conditions
+	g.lock.Unlock()
+	if conditions == nil {
+		return
+	}
+	delay := conditions.Latency
+	if conditions.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(conditions.Jitter)))
+	}
+	time.Sleep(delay)
+}
+
+func writeLine(w http.ResponseWriter, line string) bool {
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return false
+	}
+	w.(http.Flusher).Flush()
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		panic(err)
+	}
+}
+
+func writeOk(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
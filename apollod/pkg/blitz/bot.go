@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 )
 
@@ -16,6 +17,13 @@ type GameState struct {
 	Btime int    `json:"btime"`
 	Winc  int    `json:"winc"`
 	Binc  int    `json:"binc"`
+
+	Status    string `json:"status"`
+	Winner    string `json:"winner"`
+	Wdraw     bool   `json:"wdraw"`
+	Bdraw     bool   `json:"bdraw"`
+	Wtakeback bool   `json:"wtakeback"`
+	Btakeback bool   `json:"btakeback"`
 }
 
 type GameFull struct {
@@ -57,27 +65,62 @@ type ChatLine struct {
 	Room     string `json:"room"`
 }
 
+// OpponentGone is sent when our opponent leaves or returns to a game. Once ClaimWinInSeconds elapses with the
+// opponent still gone, we're allowed to claim victory.
+type OpponentGone struct {
+	Type              string `json:"type"`
+	Gone              bool   `json:"gone"`
+	ClaimWinInSeconds int    `json:"claimWinInSeconds"`
+}
+
 type GameEvent interface {
 	gameEvent()
 }
 
-func (g GameState) gameEvent() {}
-func (g GameFull) gameEvent()  {}
-func (g ChatLine) gameEvent()  {}
+func (g GameState) gameEvent()    {}
+func (g GameFull) gameEvent()     {}
+func (g ChatLine) gameEvent()     {}
+func (o OpponentGone) gameEvent() {}
 
 type BotService interface {
+	OnlineBots(ctx context.Context, limit int) ([]UserResponse, error)
 	StreamGameEvents(ctx context.Context, gameID string) (<-chan GameEvent, error)
 
 	MakeMove(ctx context.Context, gameID, move string, offerDraw bool) error
+	HandleDrawOffer(ctx context.Context, gameID string, accept bool) error
+	HandleTakebackOffer(ctx context.Context, gameID string, accept bool) error
 	WriteChat(ctx context.Context, gameID, room, text string) error
 	AbortGame(ctx context.Context, gameID string) error
 	ResignGame(ctx context.Context, gameID string) error
+	ClaimVictory(ctx context.Context, gameID string) error
 }
 
 type botServiceImpl struct {
 	client *Client
 }
 
+func (b *botServiceImpl) OnlineBots(ctx context.Context, limit int) ([]UserResponse, error) {
+	url := fmt.Sprintf("api/bot/online?nb=%d", limit)
+	stream, err := b.client.stream(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	// Lichess responds with newline-delimited JSON, one bot per line.
+	var bots []UserResponse
+	decoder := json.NewDecoder(stream)
+	for {
+		var bot UserResponse
+		if err := decoder.Decode(&bot); err == io.EOF {
+			return bots, nil
+		} else if err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+}
+
 func (b *botServiceImpl) StreamGameEvents(ctx context.Context, gameID string) (<-chan GameEvent, error) {
 	url := fmt.Sprintf("api/bot/game/stream/%s", url.PathEscape(gameID))
 	stream, err := b.client.stream(ctx, url)
@@ -86,6 +129,15 @@ func (b *botServiceImpl) StreamGameEvents(ctx context.Context, gameID string) (<
 	}
 
 	events := make(chan GameEvent)
+	// Callers may stop reading once the game is over, so give up on sending once they cancel the context.
+	send := func(event GameEvent) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 	go func() {
 		defer close(events)
 		defer stream.Close()
@@ -111,21 +163,36 @@ func (b *botServiceImpl) StreamGameEvents(ctx context.Context, gameID string) (<
 				if err := json.Unmarshal([]byte(scanner.Text()), &game); err != nil {
 					return
 				}
-				events <- game
+				if !send(game) {
+					return
+				}
 			case "gameState":
 				var state GameState
 				if err := json.Unmarshal([]byte(scanner.Text()), &state); err != nil {
 					return
 				}
-				events <- state
+				if !send(state) {
+					return
+				}
 			case "chatLine":
 				var line ChatLine
 				if err := json.Unmarshal([]byte(scanner.Text()), &line); err != nil {
 					return
 				}
-				events <- line
+				if !send(line) {
+					return
+				}
+			case "opponentGone":
+				var gone OpponentGone
+				if err := json.Unmarshal([]byte(scanner.Text()), &gone); err != nil {
+					return
+				}
+				if !send(gone) {
+					return
+				}
 			default:
-				return
+				// Skip events that we don't understand rather than abandoning the game.
+				continue
 			}
 		}
 	}()
@@ -134,6 +201,45 @@ func (b *botServiceImpl) StreamGameEvents(ctx context.Context, gameID string) (<
 
 func (b *botServiceImpl) MakeMove(ctx context.Context, gameID, move string, offerDraw bool) error {
 	target := fmt.Sprintf("api/bot/game/%s/move/%s", url.PathEscape(gameID), url.PathEscape(move))
+	if offerDraw {
+		target += "?offeringDraw=true"
+	}
+	var resp struct {
+		Ok bool `json:"ok"`
+	}
+	if err := b.client.post(ctx, target, nil, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return errors.New("lichess did not respond with 'ok'")
+	}
+	return nil
+}
+
+func (b *botServiceImpl) HandleDrawOffer(ctx context.Context, gameID string, accept bool) error {
+	answer := "no"
+	if accept {
+		answer = "yes"
+	}
+	target := fmt.Sprintf("api/bot/game/%s/draw/%s", url.PathEscape(gameID), answer)
+	var resp struct {
+		Ok bool `json:"ok"`
+	}
+	if err := b.client.post(ctx, target, nil, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return errors.New("lichess did not respond with 'ok'")
+	}
+	return nil
+}
+
+func (b *botServiceImpl) HandleTakebackOffer(ctx context.Context, gameID string, accept bool) error {
+	answer := "no"
+	if accept {
+		answer = "yes"
+	}
+	target := fmt.Sprintf("api/bot/game/%s/takeback/%s", url.PathEscape(gameID), answer)
 	var resp struct {
 		Ok bool `json:"ok"`
 	}
@@ -191,3 +297,17 @@ func (b *botServiceImpl) ResignGame(ctx context.Context, gameID string) error {
 	}
 	return nil
 }
+
+func (b *botServiceImpl) ClaimVictory(ctx context.Context, gameID string) error {
+	target := fmt.Sprintf("api/bot/game/%s/claim-victory", url.PathEscape(gameID))
+	var resp struct {
+		Ok bool `json:"ok"`
+	}
+	if err := b.client.post(ctx, target, nil, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return errors.New("lichess did not respond with 'ok'")
+	}
+	return nil
+}
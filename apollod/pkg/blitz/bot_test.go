@@ -0,0 +1,34 @@
+package blitz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const onlineBotsResult = `{"id":"apollo_bot","username":"apollo_bot","title":"BOT","perfs":{"blitz":{"rating":1400}}}
+{"id":"other_bot","username":"other_bot","title":"BOT","perfs":{"blitz":{"rating":1550}}}
+`
+
+func TestOnlineBots(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, req.URL.String(), defaultBaseURL+"api/bot/online?nb=10")
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(onlineBotsResult)),
+			Header:     make(http.Header),
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	bots, err := client.Bot.OnlineBots(context.Background(), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, bots, 2) {
+		assert.Equal(t, "other_bot", bots[1].Username)
+		assert.Equal(t, 1550, bots[1].Perfs.Blitz.Rating)
+	}
+}
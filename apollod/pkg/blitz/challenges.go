@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 )
 
 type Challenger struct {
@@ -63,17 +64,54 @@ type GameStart struct {
 	ID string `json:"id"`
 }
 
+// GameFinish is sent when one of our games ends, for whatever reason.
+type GameFinish struct {
+	ID string `json:"id"`
+}
+
 type ChallengeEvent interface {
 	challenge()
 }
 
-func (c Challenge) challenge()  {}
-func (gs GameStart) challenge() {}
+func (c Challenge) challenge()   {}
+func (gs GameStart) challenge()  {}
+func (gf GameFinish) challenge() {}
+
+// ChallengeRequest describes a challenge that we'd like to send to another player.
+type ChallengeRequest struct {
+	Rated          bool
+	ClockLimit     int
+	ClockIncrement int
+	Color          string
+	Variant        string
+}
+
+// DeclineReason tells a challenger why we declined their challenge. Lichess shows the challenger a translated
+// explanation for each of these.
+type DeclineReason string
+
+const (
+	DeclineGeneric     DeclineReason = "generic"
+	DeclineLater       DeclineReason = "later"
+	DeclineTooFast     DeclineReason = "tooFast"
+	DeclineTooSlow     DeclineReason = "tooSlow"
+	DeclineTimeControl DeclineReason = "timeControl"
+	DeclineRated       DeclineReason = "rated"
+	DeclineCasual      DeclineReason = "casual"
+	DeclineStandard    DeclineReason = "standard"
+	DeclineVariant     DeclineReason = "variant"
+	DeclineNoBot       DeclineReason = "noBot"
+	DeclineOnlyBot     DeclineReason = "onlyBot"
+)
 
 type ChallengesService interface {
 	StreamEvents(ctx context.Context) (<-chan ChallengeEvent, error)
+	CreateChallenge(ctx context.Context, username string, request ChallengeRequest) (*Challenge, error)
+	// ChallengeAI starts a game against lichess's own AI at the given level, from 1 to 8, and returns the game's ID.
+	// Games against the AI are never rated, so the request's Rated is ignored.
+	ChallengeAI(ctx context.Context, level int, request ChallengeRequest) (string, error)
 	AcceptChallenge(ctx context.Context, challengeID string) error
-	DeclineChallenge(ctx context.Context, challengeID string) error
+	DeclineChallenge(ctx context.Context, challengeID string, reason DeclineReason) error
 }
 
 type challengesServiceImpl struct {
@@ -120,8 +158,16 @@ func (c *challengesServiceImpl) StreamEvents(ctx context.Context) (<-chan Challe
 					return
 				}
 				events <- game
+			case "gameFinish":
+				var game GameFinish
+				if err := json.Unmarshal(payload["game"], &game); err != nil {
+					return
+				}
+				events <- game
 			default:
-				return
+				// Lichess sends other events (e.g. challengeCanceled) that we don't act on yet. Skip them rather
+				// than dropping the whole stream.
+				continue
 			}
 		}
 	}()
@@ -129,6 +175,54 @@ func (c *challengesServiceImpl) StreamEvents(ctx context.Context) (<-chan Challe
 	return events, nil
 }
 
+func (c *challengesServiceImpl) CreateChallenge(ctx context.Context, username string, request ChallengeRequest) (*Challenge, error) {
+	target := fmt.Sprintf("api/challenge/%s", url.PathEscape(username))
+	args := map[string]string{
+		"rated":           strconv.FormatBool(request.Rated),
+		"clock.limit":     strconv.Itoa(request.ClockLimit),
+		"clock.increment": strconv.Itoa(request.ClockIncrement),
+	}
+	if request.Color != "" {
+		args["color"] = request.Color
+	}
+	if request.Variant != "" {
+		args["variant"] = request.Variant
+	}
+
+	var resp struct {
+		Challenge Challenge `json:"challenge"`
+	}
+	if err := c.client.post(ctx, target, args, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Challenge, nil
+}
+
+func (c *challengesServiceImpl) ChallengeAI(ctx context.Context, level int, request ChallengeRequest) (string, error) {
+	args := map[string]string{
+		"level":           strconv.Itoa(level),
+		"clock.limit":     strconv.Itoa(request.ClockLimit),
+		"clock.increment": strconv.Itoa(request.ClockIncrement),
+	}
+	if request.Color != "" {
+		args["color"] = request.Color
+	}
+	if request.Variant != "" {
+		args["variant"] = request.Variant
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := c.client.post(ctx, "api/challenge/ai", args, &resp); err != nil {
+		return "", err
+	}
+	if resp.ID == "" {
+		return "", errors.New("lichess did not respond with a game")
+	}
+	return resp.ID, nil
+}
+
 func (c *challengesServiceImpl) AcceptChallenge(ctx context.Context, challengeID string) error {
 	target := fmt.Sprintf("api/challenge/%s/accept", url.PathEscape(challengeID))
 	var resp struct {
@@ -143,12 +237,16 @@ func (c *challengesServiceImpl) AcceptChallenge(ctx context.Context, challengeID
 	return nil
 }
 
-func (c *challengesServiceImpl) DeclineChallenge(ctx context.Context, challengeID string) error {
+func (c *challengesServiceImpl) DeclineChallenge(ctx context.Context, challengeID string, reason DeclineReason) error {
 	target := fmt.Sprintf("api/challenge/%s/decline", url.PathEscape(challengeID))
+	var args map[string]string
+	if reason != "" {
+		args = map[string]string{"reason": string(reason)}
+	}
 	var resp struct {
 		Ok bool `json:"ok"`
 	}
-	if err := c.client.post(ctx, target, nil, &resp); err != nil {
+	if err := c.client.post(ctx, target, args, &resp); err != nil {
 		return err
 	}
 	if !resp.Ok {
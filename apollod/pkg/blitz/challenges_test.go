@@ -0,0 +1,48 @@
+package blitz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeclineChallengeWithReason(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, defaultBaseURL+"api/challenge/abc123/decline", req.URL.String())
+		assert.NoError(t, req.ParseForm())
+		assert.Equal(t, "tooFast", req.PostForm.Get("reason"))
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"ok":true}`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	assert.NoError(t, client.Challenges.DeclineChallenge(context.Background(), "abc123", DeclineTooFast))
+}
+
+func TestChallengeAI(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, defaultBaseURL+"api/challenge/ai", req.URL.String())
+		assert.NoError(t, req.ParseForm())
+		assert.Equal(t, "3", req.PostForm.Get("level"))
+		assert.Equal(t, "180", req.PostForm.Get("clock.limit"))
+		assert.Equal(t, "white", req.PostForm.Get("color"))
+		assert.Empty(t, req.PostForm.Get("rated"))
+		return &http.Response{
+			StatusCode: 201,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"id":"q7ZvsdUF","rated":false,"status":{"id":20,"name":"started"}}`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	id, err := client.Challenges.ChallengeAI(context.Background(), 3, ChallengeRequest{ClockLimit: 180, ClockIncrement: 2, Color: "white"})
+	assert.NoError(t, err)
+	assert.Equal(t, "q7ZvsdUF", id)
+}
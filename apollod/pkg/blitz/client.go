@@ -20,25 +20,29 @@ const (
 )
 
 type Client struct {
-	baseURL   string
-	token     string
-	userAgent string
-	client    *http.Client
+	endpoints    *endpoints
+	tablebaseURL string
+	token        string
+	userAgent    string
+	client       *http.Client
 
 	Account    AccountService
 	Users      UsersService
 	Challenges ChallengesService
 	Bot        BotService
+	Games      GamesService
+	Tablebase  TablebaseService
 }
 
 type ClientOption func(*Client)
 
 func New(token string, options ...ClientOption) *Client {
 	client := &Client{
-		baseURL:   defaultBaseURL,
-		token:     token,
-		userAgent: "Apollo-Blitz/1.0",
-		client:    &http.Client{},
+		endpoints:    newEndpoints([]string{defaultBaseURL}),
+		tablebaseURL: defaultTablebaseURL,
+		token:        token,
+		userAgent:    "Apollo-Blitz/1.0",
+		client:       &http.Client{},
 	}
 	for _, option := range options {
 		option(client)
@@ -48,6 +52,8 @@ func New(token string, options ...ClientOption) *Client {
 	client.Users = &usersServiceImpl{client}
 	client.Challenges = &challengesServiceImpl{client}
 	client.Bot = &botServiceImpl{client}
+	client.Games = &gamesServiceImpl{client}
+	client.Tablebase = &tablebaseServiceImpl{client}
 	return client
 }
 
@@ -59,7 +65,14 @@ func WithHTTPClient(httpClient *http.Client) ClientOption {
 
 func WithBaseURL(url string) ClientOption {
 	return func(client *Client) {
-		client.baseURL = url
+		client.endpoints = newEndpoints([]string{url})
+	}
+}
+
+// WithTablebaseURL overrides the location of the lichess tablebase server.
+func WithTablebaseURL(url string) ClientOption {
+	return func(client *Client) {
+		client.tablebaseURL = url
 	}
 }
 
@@ -70,21 +83,27 @@ func WithUserAgent(userAgent string) ClientOption {
 }
 
 func (c *Client) urlFor(endpoint string) string {
-	return c.baseURL + endpoint
+	return c.endpoints.current() + endpoint
 }
 
 func (c *Client) get(ctx context.Context, endpoint string, response interface{}) error {
+	_, err := c.getWithHeader(ctx, endpoint, response)
+	return err
+}
+
+// getWithHeader performs a GET request like get, also returning the response's headers.
+func (c *Client) getWithHeader(ctx context.Context, endpoint string, response interface{}) (http.Header, error) {
 	req, err := http.NewRequest(http.MethodGet, c.urlFor(endpoint), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if c.userAgent != "" {
 		req.Header.Add("User-Agent", c.userAgent)
 	}
 	req.Header.Add("Authorization", "Bearer "+c.token)
-	resp, err := c.client.Do(req.WithContext(ctx))
+	resp, err := c.do(req.WithContext(ctx), "get")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
@@ -92,16 +111,46 @@ func (c *Client) get(ctx context.Context, endpoint string, response interface{})
 	if resp.StatusCode >= 400 {
 		var errResponse lichessWireError
 		if err := decoder.Decode(&errResponse); err != nil {
-			return err
+			return nil, err
 		}
 
-		return LichessError{
+		return nil, LichessError{
 			StatusCode: resp.StatusCode,
 			Message:    errResponse.Error,
 		}
 	}
 
-	return decoder.Decode(response)
+	return resp.Header, decoder.Decode(response)
+}
+
+// getText performs a GET request for a non-JSON resource, returning the raw response body.
+func (c *Client) getText(ctx context.Context, endpoint, accept string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.urlFor(endpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.userAgent != "" {
+		req.Header.Add("User-Agent", c.userAgent)
+	}
+	req.Header.Add("Authorization", "Bearer "+c.token)
+	req.Header.Add("Accept", accept)
+	resp, err := c.do(req.WithContext(ctx), "get")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading response")
+	}
+	if resp.StatusCode >= 400 {
+		return nil, LichessError{
+			StatusCode: resp.StatusCode,
+			Message:    string(body),
+		}
+	}
+	return body, nil
 }
 
 func (c *Client) post(ctx context.Context, endpoint string, args map[string]string, response interface{}) error {
@@ -130,7 +179,7 @@ func (c *Client) post(ctx context.Context, endpoint string, args map[string]stri
 		}
 	}
 
-	resp, err := c.client.Do(req.WithContext(ctx))
+	resp, err := c.do(req.WithContext(ctx), "post")
 	if err != nil {
 		return errors.Wrap(err, "while executing request")
 	}
@@ -180,7 +229,7 @@ func (c *Client) stream(ctx context.Context, endpoint string) (io.ReadCloser, er
 		req.Header.Add("User-Agent", c.userAgent)
 	}
 	req.Header.Add("Authorization", "Bearer "+c.token)
-	resp, err := c.client.Do(req.WithContext(ctx))
+	resp, err := c.do(req.WithContext(ctx), "stream")
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,241 @@
+package blitz
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// endpointFailures is the number of failures in a row after which an endpoint is taken out of rotation.
+	endpointFailures = 3
+	// endpointCooldown is how long an endpoint stays out of rotation before it's tried again.
+	endpointCooldown = 30 * time.Second
+	// endpointSwitchRatio is how much faster another endpoint must be than the one in use for us to switch to it, so
+	// that we don't flap between endpoints whose latencies are about the same.
+	endpointSwitchRatio = 0.8
+	// latencySmoothing is the weight of each new measurement in an endpoint's moving average latency.
+	latencySmoothing = 0.2
+	// maxProbeTimeout bounds each health probe.
+	maxProbeTimeout = 10 * time.Second
+)
+
+// EndpointStatus is what we know about one of the base URLs that lichess can be reached through.
+type EndpointStatus struct {
+	URL string `json:"url"`
+	// Latency is a moving average of how long the endpoint takes to respond. Zero means it hasn't been measured yet.
+	Latency time.Duration `json:"latency"`
+	// Healthy is false while the endpoint is out of rotation after failing.
+	Healthy bool `json:"healthy"`
+	// Active is true for the endpoint that requests are being sent to.
+	Active bool `json:"active"`
+}
+
+// endpoint is one way of reaching lichess: lichess itself, or a relay in front of it.
+type endpoint struct {
+	url       string
+	latency   time.Duration
+	failures  int
+	downUntil time.Time
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	return !now.Before(e.downUntil)
+}
+
+// endpoints are every base URL that the client can reach lichess through. Requests go to the active endpoint, which
+// is the fastest healthy one; when it fails repeatedly, the client fails over to the next fastest.
+type endpoints struct {
+	mu     sync.Mutex
+	list   []*endpoint
+	active int
+}
+
+func newEndpoints(urls []string) *endpoints {
+	e := &endpoints{}
+	for _, url := range urls {
+		e.list = append(e.list, &endpoint{url: url})
+	}
+	return e
+}
+
+// current returns the base URL that requests should go to.
+func (e *endpoints) current() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.list[e.active].url
+}
+
+// alternative returns the fastest healthy base URL other than the given one, for retrying a request that failed, or
+// false if there isn't one. Endpoints that haven't been measured yet are tried last.
+func (e *endpoints) alternative(url string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var best *endpoint
+	for _, candidate := range e.list {
+		if candidate.url == url || !candidate.healthy(now) {
+			continue
+		}
+		if best == nil || (candidate.latency > 0 && (best.latency == 0 || candidate.latency < best.latency)) {
+			best = candidate
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.url, true
+}
+
+// find returns the endpoint that a request URL was sent through, if any.
+func (e *endpoints) find(requestURL string) *endpoint {
+	for _, endpoint := range e.list {
+		if strings.HasPrefix(requestURL, endpoint.url) {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+// observe records how a request through the endpoint that requestURL belongs to went. Transport errors and server
+// errors count against the endpoint; anything else, even an error from lichess about the request itself, shows that
+// the endpoint works.
+func (e *endpoints) observe(requestURL string, elapsed time.Duration, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	endpoint := e.find(requestURL)
+	if endpoint == nil {
+		return
+	}
+	now := time.Now()
+	if ok {
+		endpoint.failures = 0
+		endpoint.downUntil = time.Time{}
+		if endpoint.latency == 0 {
+			endpoint.latency = elapsed
+		} else {
+			endpoint.latency += time.Duration(latencySmoothing * float64(elapsed-endpoint.latency))
+		}
+		endpointLatency.Set(endpoint.latency.Seconds(), endpoint.url)
+		endpointUp.Set(1, endpoint.url)
+	} else {
+		endpoint.failures++
+		if endpoint.failures >= endpointFailures && endpoint.healthy(now) {
+			log.WithField("endpoint", endpoint.url).Warn("lichess endpoint is failing, taking it out of rotation")
+			endpoint.downUntil = now.Add(endpointCooldown)
+			endpointUp.Set(0, endpoint.url)
+		}
+	}
+	e.choose(now)
+}
+
+// choose picks the active endpoint: the one in use, unless it's unhealthy or another healthy endpoint is enough faster.
+// If every endpoint is unhealthy, the one that comes back soonest is used, since there's nothing better to try.
+func (e *endpoints) choose(now time.Time) {
+	best := e.active
+	for i, candidate := range e.list {
+		current := e.list[best]
+		switch {
+		case !candidate.healthy(now):
+			if !current.healthy(now) && candidate.downUntil.Before(current.downUntil) {
+				best = i
+			}
+		case !current.healthy(now):
+			best = i
+		case candidate.latency > 0 && current.latency > 0 &&
+			float64(candidate.latency) < endpointSwitchRatio*float64(current.latency):
+			best = i
+		}
+	}
+	if best != e.active {
+		log.WithFields(log.Fields{
+			"from": e.list[e.active].url,
+			"to":   e.list[best].url,
+		}).Warn("switching lichess endpoint")
+		endpointFailovers.Inc()
+		e.active = best
+	}
+}
+
+func (e *endpoints) status() []EndpointStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var statuses []EndpointStatus
+	for i, endpoint := range e.list {
+		statuses = append(statuses, EndpointStatus{
+			URL:     endpoint.url,
+			Latency: endpoint.latency,
+			Healthy: endpoint.healthy(now),
+			Active:  i == e.active,
+		})
+	}
+	return statuses
+}
+
+// WithBaseURLs has the client reach lichess through any of several base URLs, such as lichess itself and relays in
+// front of it, using whichever is fastest and failing over between them when one stops responding. The first is used
+// until the others have been measured.
+func WithBaseURLs(urls ...string) ClientOption {
+	return func(client *Client) {
+		if len(urls) > 0 {
+			client.endpoints = newEndpoints(urls)
+		}
+	}
+}
+
+// Endpoints reports on every base URL the client can reach lichess through.
+func (c *Client) Endpoints() []EndpointStatus {
+	return c.endpoints.status()
+}
+
+// ProbeEndpoints measures how long every base URL takes to respond, every interval until ctx is done, so that the
+// client knows which is fastest and notices when one that isn't in use fails or recovers. With a single base URL
+// there's nothing to choose between, and it returns immediately.
+func (c *Client) ProbeEndpoints(ctx context.Context, interval time.Duration) {
+	if len(c.endpoints.list) < 2 || interval <= 0 {
+		return
+	}
+	timeout := interval / 2
+	if timeout > maxProbeTimeout {
+		timeout = maxProbeTimeout
+	}
+	for {
+		for _, status := range c.Endpoints() {
+			c.probe(ctx, status.URL, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// probe sends a HEAD request for an endpoint's root, which needs no authentication and counts against no rate limit.
+func (c *Client) probe(ctx context.Context, url string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return
+	}
+	if c.userAgent != "" {
+		req.Header.Add("User-Agent", c.userAgent)
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil && ctx.Err() == context.Canceled {
+		// We're shutting down, which says nothing about the endpoint.
+		return
+	}
+	ok := err == nil && resp.StatusCode < 500
+	if err == nil {
+		resp.Body.Close()
+	}
+	c.endpoints.observe(url, time.Since(start), ok)
+}
@@ -0,0 +1,74 @@
+package blitz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	primaryURL = "https://primary.example/"
+	relayURL   = "https://relay.example/"
+)
+
+// transportFunc is a RoundTripFunc that can fail to reach the server at all.
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestEndpointsFailOverAfterRepeatedFailures(t *testing.T) {
+	e := newEndpoints([]string{primaryURL, relayURL})
+	for i := 0; i < endpointFailures-1; i++ {
+		e.observe(primaryURL+"api/account", time.Millisecond, false)
+	}
+	assert.Equal(t, primaryURL, e.current(), "a couple of failures don't take an endpoint out of rotation")
+
+	e.observe(primaryURL+"api/account", time.Millisecond, false)
+	assert.Equal(t, relayURL, e.current())
+	statuses := e.status()
+	assert.False(t, statuses[0].Healthy)
+	assert.True(t, statuses[1].Active)
+}
+
+func TestEndpointsSwitchOnlyWhenMuchFaster(t *testing.T) {
+	e := newEndpoints([]string{primaryURL, relayURL})
+	e.observe(primaryURL, 100*time.Millisecond, true)
+	e.observe(relayURL, 90*time.Millisecond, true)
+	assert.Equal(t, primaryURL, e.current(), "slightly faster isn't worth switching for")
+
+	e.observe(relayURL, 50*time.Millisecond, true)
+	e.observe(relayURL, 10*time.Millisecond, true)
+	assert.Equal(t, relayURL, e.current())
+}
+
+func TestClientRetriesGetThroughAnotherEndpoint(t *testing.T) {
+	var requested []string
+	httpClient := &http.Client{Transport: transportFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		if strings.HasPrefix(req.URL.String(), primaryURL) {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(profileJSONResult)),
+			Header:     make(http.Header),
+		}, nil
+	})}
+
+	client := New("secret", WithHTTPClient(httpClient), WithBaseURLs(primaryURL, relayURL))
+	profile, err := client.Account.GetProfile(context.Background())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "swgillespie", profile.Username)
+	assert.Equal(t, []string{primaryURL + "api/account", relayURL + "api/account"}, requested)
+}
@@ -0,0 +1,132 @@
+package blitz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GameSummary is a finished or ongoing game, as exported in a user's game history.
+type GameSummary struct {
+	ID      string         `json:"id"`
+	Rated   bool           `json:"rated"`
+	Variant string         `json:"variant"`
+	Speed   string         `json:"speed"`
+	Status  string         `json:"status"`
+	Winner  string         `json:"winner"`
+	Players SummaryPlayers `json:"players"`
+	// Moves are the game's moves in SAN, separated by spaces. They're only exported if asked for.
+	Moves string `json:"moves"`
+	// InitialFen is the position the game started from, if it wasn't the standard starting position.
+	InitialFen string `json:"initialFen"`
+	// PGN is the whole game as PGN. It's only exported if asked for.
+	PGN string `json:"pgn"`
+	// CreatedAt and LastMoveAt are Unix times in milliseconds.
+	CreatedAt  int64 `json:"createdAt"`
+	LastMoveAt int64 `json:"lastMoveAt"`
+}
+
+type SummaryPlayers struct {
+	White SummaryPlayer `json:"white"`
+	Black SummaryPlayer `json:"black"`
+}
+
+// SummaryPlayer is one side of a GameSummary. User is empty for anonymous players and the lichess AI.
+type SummaryPlayer struct {
+	User   SummaryUser `json:"user"`
+	Rating int         `json:"rating"`
+}
+
+type SummaryUser struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+type GamesService interface {
+	ExportGame(ctx context.Context, gameID string) (string, error)
+	// UserGames returns up to max of a user's most recent games, newest first.
+	UserGames(ctx context.Context, username string, max int) ([]GameSummary, error)
+	// ExportUserGames returns a user's most recent games, newest first, filtered and detailed as options ask.
+	ExportUserGames(ctx context.Context, username string, options ExportOptions) ([]GameSummary, error)
+}
+
+// ExportOptions selects which of a user's games to export and how much of each to include.
+type ExportOptions struct {
+	// Max is the most games to export. Zero exports every game.
+	Max int
+	// Moves includes every game's moves.
+	Moves bool
+	// Color, if "white" or "black", only exports games the user played as that color.
+	Color string
+	// Since and Until, if set, only export games created at or after Since and at or before Until.
+	Since, Until time.Time
+	// PGN includes every game as PGN, and Clocks includes the clocks after every move in it.
+	PGN    bool
+	Clocks bool
+}
+
+type gamesServiceImpl struct {
+	client *Client
+}
+
+// ExportGame downloads a single game as PGN, including clock comments.
+func (g *gamesServiceImpl) ExportGame(ctx context.Context, gameID string) (string, error) {
+	target := fmt.Sprintf("api/game/export/%s?clocks=true&evals=false", url.PathEscape(gameID))
+	body, err := g.client.getText(ctx, target, "application/x-chess-pgn")
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (g *gamesServiceImpl) UserGames(ctx context.Context, username string, max int) ([]GameSummary, error) {
+	return g.ExportUserGames(ctx, username, ExportOptions{Max: max})
+}
+
+func (g *gamesServiceImpl) ExportUserGames(ctx context.Context, username string, options ExportOptions) ([]GameSummary, error) {
+	query := url.Values{}
+	if options.Max > 0 {
+		query.Set("max", strconv.Itoa(options.Max))
+	}
+	query.Set("moves", strconv.FormatBool(options.Moves))
+	if options.Color != "" {
+		query.Set("color", options.Color)
+	}
+	if !options.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(unixMillis(options.Since), 10))
+	}
+	if !options.Until.IsZero() {
+		query.Set("until", strconv.FormatInt(unixMillis(options.Until), 10))
+	}
+	if options.PGN {
+		query.Set("pgnInJson", "true")
+		query.Set("clocks", strconv.FormatBool(options.Clocks))
+	}
+	target := fmt.Sprintf("api/games/user/%s?%s", url.PathEscape(username), query.Encode())
+	body, err := g.client.getText(ctx, target, "application/x-ndjson")
+	if err != nil {
+		return nil, err
+	}
+
+	var games []GameSummary
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for decoder.More() {
+		var game GameSummary
+		if err := decoder.Decode(&game); err != nil {
+			return nil, errors.Wrap(err, "while decoding game history")
+		}
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,79 @@
+package blitz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const userGamesResult = `{"id":"aaaaaaaa","rated":true,"variant":"standard","speed":"blitz","status":"aborted","players":{"white":{"user":{"name":"Someone","id":"someone"},"rating":1500},"black":{"user":{"name":"my_bot","id":"my_bot"},"rating":1600}}}
+{"id":"bbbbbbbb","rated":true,"variant":"standard","speed":"blitz","status":"timeout","winner":"black","players":{"white":{"user":{"name":"Someone","id":"someone"},"rating":1500},"black":{"aiLevel":3}}}
+`
+
+func TestUserGames(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, defaultBaseURL+"api/games/user/someone?max=2&moves=false", req.URL.String())
+		assert.Equal(t, "application/x-ndjson", req.Header.Get("Accept"))
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(userGamesResult)),
+			Header:     make(http.Header),
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	games, err := client.Games.UserGames(context.Background(), "someone", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, games, 2) {
+		assert.Equal(t, "aborted", games[0].Status)
+		assert.Equal(t, "someone", games[0].Players.White.User.ID)
+		assert.Equal(t, "black", games[1].Winner)
+		assert.Empty(t, games[1].Players.Black.User.ID)
+	}
+}
+
+func TestExportUserGames(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, defaultBaseURL+"api/games/user/someone?color=white&max=20&moves=true", req.URL.String())
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"id":"aaaaaaaa","variant":"standard","status":"mate","moves":"e4 e5 Qh5 Nc6 Bc4 Nf6 Qxf7#"}` + "\n")),
+			Header:     make(http.Header),
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	games, err := client.Games.ExportUserGames(context.Background(), "someone", ExportOptions{Max: 20, Moves: true, Color: "white"})
+	assert.NoError(t, err)
+	if assert.Len(t, games, 1) {
+		assert.Equal(t, "e4 e5 Qh5 Nc6 Bc4 Nf6 Qxf7#", games[0].Moves)
+	}
+}
+
+func TestExportUserGamesPaging(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, defaultBaseURL+"api/games/user/someone?clocks=true&max=100&moves=true&pgnInJson=true&since=1000&until=2000", req.URL.String())
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+			Header:     make(http.Header),
+		}
+	})
+
+	client := New("", WithHTTPClient(httpClient))
+	games, err := client.Games.ExportUserGames(context.Background(), "someone", ExportOptions{
+		Max:    100,
+		Moves:  true,
+		Since:  time.Unix(1, 0),
+		Until:  time.Unix(2, 0),
+		PGN:    true,
+		Clocks: true,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, games)
+}
@@ -0,0 +1,80 @@
+package blitz
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/metrics"
+)
+
+var (
+	lichessMetrics = metrics.NewSubsystem("lichess")
+
+	requestLatency = lichessMetrics.NewHistogram(
+		"request_seconds",
+		"Time lichess took to respond to API requests, by kind of request (get, post, stream, or tablebase).",
+		metrics.DefaultLatencyBuckets,
+		"kind")
+	requestErrors = lichessMetrics.NewCounter(
+		"errors_total",
+		"Number of failed lichess API requests, by status code (0 for transport errors).",
+		"code")
+	endpointLatency = lichessMetrics.NewGauge(
+		"endpoint_latency_seconds",
+		"Moving average of the time each lichess endpoint takes to respond, by base URL.",
+		"endpoint")
+	endpointUp = lichessMetrics.NewGauge(
+		"endpoint_up",
+		"Whether each lichess endpoint is in rotation (1) or out of it after failing (0), by base URL.",
+		"endpoint")
+	endpointFailovers = lichessMetrics.NewCounter(
+		"endpoint_failovers_total",
+		"Number of times requests were switched from one lichess endpoint to another.")
+)
+
+// do sends a request of the given kind, recording how long lichess took to respond and whether the request failed.
+// Streams are timed until their response headers arrive. A request that doesn't reach lichess at all is retried once
+// through another endpoint, if there is one, as long as it's a GET and so safe to send twice.
+func (c *Client) do(req *http.Request, kind string) (*http.Response, error) {
+	resp, err := c.send(req, kind)
+	if err == nil || req.Method != http.MethodGet || req.Context().Err() != nil {
+		return resp, err
+	}
+	endpoint := c.endpoints.find(req.URL.String())
+	if endpoint == nil {
+		return resp, err
+	}
+	alternative, ok := c.endpoints.alternative(endpoint.url)
+	if !ok {
+		return resp, err
+	}
+	retry, parseErr := url.Parse(alternative + strings.TrimPrefix(req.URL.String(), endpoint.url))
+	if parseErr != nil {
+		return resp, err
+	}
+	log.WithError(err).WithField("endpoint", alternative).Warn("lichess request failed, retrying through another endpoint")
+	req = req.Clone(req.Context())
+	req.URL, req.Host = retry, ""
+	return c.send(req, kind)
+}
+
+// send sends a request once, timing it both for metrics and for the endpoint it went through.
+func (c *Client) send(req *http.Request, kind string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	requestLatency.ObserveSince(start, kind)
+	c.endpoints.observe(req.URL.String(), time.Since(start), err == nil && resp.StatusCode < 500)
+	if err != nil {
+		requestErrors.Inc("0")
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		requestErrors.Inc(strconv.Itoa(resp.StatusCode))
+	}
+	return resp, nil
+}
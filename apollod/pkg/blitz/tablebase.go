@@ -0,0 +1,77 @@
+package blitz
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const defaultTablebaseURL = "https://tablebase.lichess.ovh/"
+
+// TablebaseService queries lichess's endgame tablebase server, which is separate from the main lichess API and
+// needs no authentication.
+type TablebaseService interface {
+	// Standard looks up a position, given as FEN, in the standard chess tablebases.
+	Standard(ctx context.Context, fen string) (*TablebaseEntry, error)
+}
+
+// TablebaseEntry is the tablebase's verdict on a position.
+type TablebaseEntry struct {
+	// Category is the outcome for the side to move: win, loss, draw, cursed-win, blessed-loss, or unknown.
+	Category string `json:"category"`
+	DTZ      *int   `json:"dtz"`
+	DTM      *int   `json:"dtm"`
+	// Moves are the legal moves in the position, best first.
+	Moves []TablebaseMove `json:"moves"`
+}
+
+// TablebaseMove is a legal move in a tablebase position. Its category is the outcome for the side to move after it
+// is played, i.e. our opponent.
+type TablebaseMove struct {
+	UCI       string `json:"uci"`
+	SAN       string `json:"san"`
+	Category  string `json:"category"`
+	DTZ       *int   `json:"dtz"`
+	DTM       *int   `json:"dtm"`
+	Zeroing   bool   `json:"zeroing"`
+	Checkmate bool   `json:"checkmate"`
+	Stalemate bool   `json:"stalemate"`
+}
+
+type tablebaseServiceImpl struct {
+	client *Client
+}
+
+func (t *tablebaseServiceImpl) Standard(ctx context.Context, fen string) (*TablebaseEntry, error) {
+	target := t.client.tablebaseURL + "standard?fen=" + url.QueryEscape(fen)
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if t.client.userAgent != "" {
+		req.Header.Add("User-Agent", t.client.userAgent)
+	}
+	resp, err := t.client.do(req.WithContext(ctx), "tablebase")
+	if err != nil {
+		return nil, errors.Wrap(err, "while querying tablebase")
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, LichessError{
+			StatusCode: resp.StatusCode,
+			Message:    string(body),
+		}
+	}
+
+	var entry TablebaseEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return nil, errors.Wrap(err, "while decoding tablebase response")
+	}
+	return &entry, nil
+}
@@ -0,0 +1,39 @@
+package blitz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tablebaseResult = `{"category":"win","dtz":1,"dtm":1,"moves":[
+{"uci":"h7h8q","san":"h8=Q#","category":"loss","dtz":0,"dtm":0,"zeroing":true,"checkmate":true,"stalemate":false},
+{"uci":"h7h8r","san":"h8=R","category":"draw","dtz":0,"dtm":null,"zeroing":true,"checkmate":false,"stalemate":true}]}`
+
+func TestTablebaseStandard(t *testing.T) {
+	httpClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, defaultTablebaseURL+"standard?fen=6k1%2F7P%2F6K1%2F8%2F8%2F8%2F8%2F8+w+-+-+0+1", req.URL.String())
+		assert.Empty(t, req.Header.Get("Authorization"))
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(tablebaseResult)),
+			Header:     make(http.Header),
+		}
+	})
+
+	client := New("secret", WithHTTPClient(httpClient))
+	entry, err := client.Tablebase.Standard(context.Background(), "6k1/7P/6K1/8/8/8/8/8 w - - 0 1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "win", entry.Category)
+	if assert.Len(t, entry.Moves, 2) {
+		assert.Equal(t, "h7h8q", entry.Moves[0].UCI)
+		assert.True(t, entry.Moves[0].Checkmate)
+		assert.Nil(t, entry.Moves[1].DTM)
+	}
+}
@@ -2,6 +2,7 @@ package blitz
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 )
@@ -20,10 +21,15 @@ type UserResponse struct {
 	NbFollowers    int      `json:"nbFollowers"`
 	CompletionRate int      `json:"completionRate"`
 	Count          Count    `json:"count"`
+	Title          string   `json:"title"`
 }
 
 type UsersService interface {
 	GetUser(ctx context.Context, username string) (*UserResponse, error)
+	SendMessage(ctx context.Context, username, text string) error
+	// BlockUser and UnblockUser change whether we block a user on lichess, which stops them challenging or messaging us.
+	BlockUser(ctx context.Context, username string) error
+	UnblockUser(ctx context.Context, username string) error
 }
 
 type usersServiceImpl struct {
@@ -38,3 +44,42 @@ func (u *usersServiceImpl) GetUser(ctx context.Context, username string) (*UserR
 	}
 	return &userResp, nil
 }
+
+func (u *usersServiceImpl) SendMessage(ctx context.Context, username, text string) error {
+	target := fmt.Sprintf("api/inbox/%s", url.PathEscape(username))
+	args := map[string]string{
+		"text": text,
+	}
+	var resp struct {
+		Ok bool `json:"ok"`
+	}
+	if err := u.client.post(ctx, target, args, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return errors.New("lichess did not respond with 'ok'")
+	}
+	return nil
+}
+
+func (u *usersServiceImpl) BlockUser(ctx context.Context, username string) error {
+	return u.relation(ctx, "block", username)
+}
+
+func (u *usersServiceImpl) UnblockUser(ctx context.Context, username string) error {
+	return u.relation(ctx, "unblock", username)
+}
+
+func (u *usersServiceImpl) relation(ctx context.Context, action, username string) error {
+	target := fmt.Sprintf("api/rel/%s/%s", action, url.PathEscape(username))
+	var resp struct {
+		Ok bool `json:"ok"`
+	}
+	if err := u.client.post(ctx, target, nil, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return errors.New("lichess did not respond with 'ok'")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+// Package book implements an opening book, built from a collection of PGN games or read from a Polyglot book.
+package book
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+// Entry is a move out of a book position and its weight, which is how often it is played from the position, relative
+// to the position's other moves.
+type Entry struct {
+	Move   string
+	Weight int
+}
+
+// Book maps positions to the moves that were played from them in the book's games. Positions are identified by their
+// Polyglot keys and moves are kept in the Polyglot encoding, so a book can be read from and written to a Polyglot file
+// as is.
+type Book struct {
+	positions map[uint64][]entry
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+type entry struct {
+	move   uint16
+	weight int
+}
+
+// Weighting is how a book built from games weights the moves played from a position.
+type Weighting string
+
+const (
+	// WeightFrequency weights a move by the number of games that played it.
+	WeightFrequency Weighting = "frequency"
+	// WeightScore weights a move by how well it scored for the side that played it: two for every win and one for
+	// every draw. Moves that only lost are left out.
+	WeightScore Weighting = "score"
+)
+
+// BuildOptions control which moves of a collection of games go into a book, and how they're weighted.
+type BuildOptions struct {
+	// MaxPly is the number of plies of every game to keep. 0 keeps every move.
+	MaxPly int
+	// MinGames leaves out moves played in fewer games than this.
+	MinGames int
+	// Weighting defaults to WeightFrequency.
+	Weighting Weighting
+}
+
+// New returns an empty book.
+func New() *Book {
+	return &Book{
+		positions: make(map[uint64][]entry),
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Open loads a book from a file: a Polyglot book if it has the .bin extension, and otherwise PGN games, of which at
+// most maxPly plies are kept. A maxPly of 0 keeps every move. Polyglot books don't record how deep into a game their
+// positions are, so maxPly doesn't apply to them.
+func Open(path string, maxPly int) (*Book, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open book")
+	}
+	defer file.Close()
+	if strings.EqualFold(filepath.Ext(path), ".bin") {
+		return ReadPolyglot(file)
+	}
+	return LoadPGN(file, maxPly)
+}
+
+// LoadPGN builds a book from a stream of PGN games, keeping at most maxPly plies of every game. A maxPly of 0 keeps
+// every move.
+func LoadPGN(r io.Reader, maxPly int) (*Book, error) {
+	return Build(r, BuildOptions{MaxPly: maxPly})
+}
+
+// Build builds a book from a stream of PGN games.
+func Build(r io.Reader, options BuildOptions) (*Book, error) {
+	switch options.Weighting {
+	case "", WeightFrequency, WeightScore:
+	default:
+		return nil, errors.Errorf("unknown weighting %q, must be frequency or score", options.Weighting)
+	}
+
+	type tally struct {
+		position *chess.Position
+		move     string
+		games    int
+		points   int
+	}
+	var tallies []*tally
+	seen := make(map[string]*tally)
+	games := pgn.NewReader(r)
+	skipped := 0
+	for {
+		game, err := games.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse book")
+		}
+		// A collection as big as a lichess export is bound to have the odd game that can't be replayed, such as one
+		// of another variant, which isn't worth giving up on the rest for.
+		board, err := game.Board()
+		if err != nil {
+			skipped++
+			continue
+		}
+
+		positions := board.Positions()
+		for ply, move := range board.Moves() {
+			if options.MaxPly > 0 && ply >= options.MaxPly {
+				break
+			}
+			position := positions[ply]
+			uci := chess.LongAlgebraicNotation{}.Encode(position, move)
+			id := position.String() + " " + uci
+			t, ok := seen[id]
+			if !ok {
+				t = &tally{position: position, move: uci}
+				seen[id] = t
+				tallies = append(tallies, t)
+			}
+			t.games++
+			switch game.Result {
+			case pgn.Draw:
+				t.points++
+			case pgn.WhiteWins:
+				if position.Turn() == chess.White {
+					t.points += 2
+				}
+			case pgn.BlackWins:
+				if position.Turn() == chess.Black {
+					t.points += 2
+				}
+			}
+		}
+	}
+	if skipped > 0 {
+		log.WithField("games", skipped).Warn("skipped book games with illegal moves")
+	}
+
+	book := New()
+	for _, t := range tallies {
+		if t.games < options.MinGames {
+			continue
+		}
+		weight := t.games
+		if options.Weighting == WeightScore {
+			weight = t.points
+		}
+		if weight > 0 {
+			book.AddWeight(t.position, t.move, weight)
+		}
+	}
+	return book, nil
+}
+
+// Add records that move, in UCI notation, was played from position.
+func (b *Book) Add(position *chess.Position, move string) {
+	b.AddWeight(position, move, 1)
+}
+
+// AddWeight adds weight to a move, in UCI notation, from position.
+func (b *Book) AddWeight(position *chess.Position, move string, weight int) {
+	encoded, ok := encodeMove(position, move)
+	if !ok {
+		return
+	}
+	key := polyglotKey(position)
+	entries := b.positions[key]
+	for i := range entries {
+		if entries[i].move == encoded {
+			entries[i].weight += weight
+			return
+		}
+	}
+	b.positions[key] = append(entries, entry{move: encoded, weight: weight})
+}
+
+// Entries returns the book moves for a position, most popular first.
+func (b *Book) Entries(position *chess.Position) []Entry {
+	var entries []Entry
+	for _, e := range b.positions[polyglotKey(position)] {
+		entries = append(entries, Entry{Move: decodeMove(position, e.move), Weight: e.weight})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Weight > entries[j].Weight
+	})
+	return entries
+}
+
+// Len returns the number of positions in the book.
+func (b *Book) Len() int {
+	return len(b.positions)
+}
+
+// Lookup picks a book move for a position at random, in proportion to its weight. It returns false if the position is
+// not in the book.
+func (b *Book) Lookup(position *chess.Position) (string, bool) {
+	return b.LookupWeighted(position, func(entry Entry) int { return entry.Weight })
+}
+
+// LookupWeighted is Lookup with every move's weight in the book replaced by weight(entry), so that callers can favor or
+// avoid particular moves. Moves weighted zero or less are never picked, and if every move is, it returns false.
+func (b *Book) LookupWeighted(position *chess.Position, weight func(Entry) int) (string, bool) {
+	var entries []Entry
+	var weights []int
+	total := 0
+	for _, e := range b.positions[polyglotKey(position)] {
+		entry := Entry{Move: decodeMove(position, e.move), Weight: e.weight}
+		w := weight(entry)
+		if w <= 0 {
+			continue
+		}
+		entries = append(entries, entry)
+		weights = append(weights, w)
+		total += w
+	}
+	if total == 0 {
+		return "", false
+	}
+
+	b.mu.Lock()
+	pick := b.rng.Intn(total)
+	b.mu.Unlock()
+	for i, entry := range entries {
+		if pick < weights[i] {
+			return entry.Move, true
+		}
+		pick -= weights[i]
+	}
+	panic("unreachable")
+}
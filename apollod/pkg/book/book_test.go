@@ -0,0 +1,74 @@
+package book
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPGN = `[Event "one"]
+
+1. e4 e5 2. Nf3 Nc6 1-0
+
+[Event "two"]
+
+1. e4 c5 2. Nf3 d6 0-1
+
+[Event "three"]
+
+1. d4 d5 1/2-1/2
+`
+
+func TestLoadPGN(t *testing.T) {
+	book, err := LoadPGN(strings.NewReader(testPGN), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	entries := book.Entries(chess.NewGame().Position())
+	assert.Equal(t, []Entry{{Move: "e2e4", Weight: 2}, {Move: "d2d4", Weight: 1}}, entries)
+
+	game := chess.NewGame(chess.UseNotation(chess.LongAlgebraicNotation{}))
+	assert.NoError(t, game.MoveStr("e2e4"))
+	assert.NoError(t, game.MoveStr("e7e5"))
+	move, ok := book.Lookup(game.Position())
+	assert.True(t, ok)
+	assert.Equal(t, "g1f3", move)
+}
+
+func TestLoadPGNMaxPly(t *testing.T) {
+	book, err := LoadPGN(strings.NewReader(testPGN), 1)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, 1, book.Len())
+
+	game := chess.NewGame(chess.UseNotation(chess.LongAlgebraicNotation{}))
+	assert.NoError(t, game.MoveStr("e2e4"))
+	_, ok := book.Lookup(game.Position())
+	assert.False(t, ok)
+}
+
+func TestLookupWeighted(t *testing.T) {
+	book, err := LoadPGN(strings.NewReader(testPGN), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	position := chess.NewGame().Position()
+	avoidE4 := func(entry Entry) int {
+		if entry.Move == "e2e4" {
+			return 0
+		}
+		return entry.Weight
+	}
+	for i := 0; i < 10; i++ {
+		move, ok := book.LookupWeighted(position, avoidE4)
+		assert.True(t, ok)
+		assert.Equal(t, "d2d4", move)
+	}
+	_, ok := book.LookupWeighted(position, func(Entry) int { return 0 })
+	assert.False(t, ok)
+}
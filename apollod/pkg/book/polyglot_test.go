@@ -0,0 +1,86 @@
+package book
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolyglotKey(t *testing.T) {
+	// The test positions from the Polyglot book format's specification.
+	for _, test := range []struct {
+		fen string
+		key uint64
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 0x463b96181691fc9c},
+		{"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", 0x823c9b50fd114196},
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2", 0x0756b94461c50fb0},
+		{"rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 2", 0x662fafb965db29d4},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", 0x22a48b5a8e47ff78},
+		{"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR b kq - 0 3", 0x652a607ca3f242c1},
+		{"rnbq1bnr/ppp1pkpp/8/3pPp2/8/8/PPPPKPPP/RNBQ1BNR w - - 0 4", 0x00fdd303c946bdd9},
+		{"rnbqkbnr/p1pppppp/8/8/PpP4P/8/1P1PPPP1/RNBQKBNR b KQkq c3 0 3", 0x3c8123ea7b067637},
+		{"rnbqkbnr/p1pppppp/8/8/P6P/R1p5/1P1PPPP1/1NBQKBNR b Kkq - 0 4", 0x5c3f9b829b279560},
+	} {
+		fen, err := chess.FEN(test.fen)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, test.key, polyglotKey(chess.NewGame(fen).Position()), test.fen)
+	}
+}
+
+func TestPolyglotRoundTrip(t *testing.T) {
+	fen, err := chess.FEN("r3k2r/pppq1ppp/2n2n2/4p3/4P3/2N2N2/PPPQ1PPP/R3K2R w KQkq - 0 1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	position := chess.NewGame(fen).Position()
+	original := New()
+	original.AddWeight(position, "e1g1", 70000)
+	original.AddWeight(position, "e1c1", 35000)
+	original.Add(position, "h1g1")
+
+	var buf bytes.Buffer
+	assert.NoError(t, original.WritePolyglot(&buf))
+	assert.Equal(t, 3*polyglotEntrySize, buf.Len())
+
+	read, err := ReadPolyglot(&buf)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []Entry{
+		{Move: "e1g1", Weight: 0xffff},
+		{Move: "e1c1", Weight: 32767},
+		{Move: "h1g1", Weight: 1},
+	}, read.Entries(position))
+
+	_, err = ReadPolyglot(strings.NewReader("truncated"))
+	assert.Error(t, err)
+}
+
+func TestBuildScoreWeighting(t *testing.T) {
+	book, err := Build(strings.NewReader(testPGN), BuildOptions{MaxPly: 2, Weighting: WeightScore})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// 1. e4 won one game and lost another, 1. d4 drew.
+	assert.Equal(t, []Entry{{Move: "e2e4", Weight: 2}, {Move: "d2d4", Weight: 1}}, book.Entries(chess.NewGame().Position()))
+
+	game := chess.NewGame(chess.UseNotation(chess.LongAlgebraicNotation{}))
+	assert.NoError(t, game.MoveStr("e2e4"))
+	assert.Equal(t, []Entry{{Move: "c7c5", Weight: 2}}, book.Entries(game.Position()))
+
+	book, err = Build(strings.NewReader(testPGN), BuildOptions{MinGames: 2})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// Only 1. e4 was played in more than one game.
+	assert.Equal(t, 1, book.Len())
+
+	_, err = Build(strings.NewReader(testPGN), BuildOptions{Weighting: "elo"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,498 @@
+// Package cecp drives engines that speak version 2 of the Chess Engine Communication Protocol, better known as the
+// xboard or WinBoard protocol.
+//
+// See https://www.gnu.org/software/xboard/engine-intf.html for details on the protocol itself.
+package cecp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const (
+	// startingFEN is the standard starting position, for engines that can only be put in a position with setboard.
+	startingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+	// mateScore is the score from which engines count up to report a forced mate.
+	mateScore = 100000
+)
+
+var (
+	featureRegex  = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+	thinkingRegex = regexp.MustCompile(`^\s*(\d+)\S*\s+(-?\d+)\s+(\d+)\s+(\d+)\s*(.*)$`)
+	moveRegex     = regexp.MustCompile(`^move (\S+)`)
+	resultRegex   = regexp.MustCompile(`^(1-0|0-1|1/2-1/2)\b`)
+)
+
+func init() {
+	engine.Register("cecp", func(transport engine.Transport) (engine.Engine, error) {
+		client, err := NewClient(transport)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+// Client speaks CECP to an engine over a transport. Engines are kept in force mode, in which they only play along
+// with the moves they are sent, except while searching.
+type Client struct {
+	transport engine.Transport
+
+	name     string
+	features map[string]string
+	options  []engine.Option
+	pings    int
+
+	lastInfo engine.Info
+	onInfo   func(engine.Info)
+
+	// The position the engine's board is in, so that only new moves need to be sent.
+	chess960 bool
+	synced   bool
+	fen      string
+	moves    []string
+}
+
+// NewClient performs the protocol version 2 handshake with the engine, accepting every feature it asks for except
+// SAN moves. Engines that don't finish their feature list with done=1 aren't supported.
+func NewClient(transport engine.Transport) (*Client, error) {
+	client := &Client{
+		transport: transport,
+		features:  make(map[string]string),
+	}
+
+	if err := client.handshake(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *Client) handshake() error {
+	engine.Expect(c.transport, engine.WaitHandshake)
+	if err := c.transport.Send("xboard"); err != nil {
+		return err
+	}
+	if err := c.transport.Send("protover 2"); err != nil {
+		return err
+	}
+
+	// In response, the engine sends one or more "feature" lines, each of which we must accept or reject. The last of
+	// them contains done=1. Engines commonly print a banner or debug output as well, which we ignore.
+	for {
+		line, err := c.transport.Recv()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "feature ") {
+			continue
+		}
+
+		done := false
+		for _, match := range featureRegex.FindAllStringSubmatch(line, -1) {
+			name, value := match[1], strings.Trim(match[2], `"`)
+			switch name {
+			case "san":
+				if value == "1" {
+					// Moves are always sent and received in coordinate notation, same as UCI.
+					if err := c.transport.Send("rejected san"); err != nil {
+						return err
+					}
+					continue
+				}
+			case "myname":
+				c.name = value
+			case "option":
+				c.options = append(c.options, parseOption(value))
+			case "done":
+				done = value == "1"
+			}
+			c.features[name] = value
+			if err := c.transport.Send("accepted " + name); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// parseOption parses an option feature, such as "Hash -spin 64 1 1024", into the UCI terms that other engines use.
+// Sliders are spins, saves and resets are buttons, checks are true or false rather than 1 or 0, and anything else is a
+// string.
+func parseOption(feature string) engine.Option {
+	i := strings.Index(feature, " -")
+	if i < 0 {
+		return engine.Option{Name: feature, Type: engine.OptionString}
+	}
+	option := engine.Option{Name: feature[:i]}
+	kind, rest := feature[i+2:], ""
+	if j := strings.IndexByte(kind, ' '); j >= 0 {
+		kind, rest = kind[:j], strings.TrimSpace(kind[j+1:])
+	}
+	switch kind {
+	case "spin", "slider":
+		option.Type = engine.OptionSpin
+		if values := strings.Fields(rest); len(values) == 3 {
+			option.Default = values[0]
+			option.Min, _ = strconv.Atoi(values[1])
+			option.Max, _ = strconv.Atoi(values[2])
+		}
+	case "check":
+		option.Type, option.Default = engine.OptionCheck, strconv.FormatBool(rest == "1")
+	case "combo":
+		option.Type = engine.OptionCombo
+		for _, v := range strings.Split(rest, "///") {
+			v = strings.TrimSpace(v)
+			if strings.HasPrefix(v, "*") {
+				v = v[1:]
+				option.Default = v
+			}
+			option.Vars = append(option.Vars, v)
+		}
+	case "button", "save", "reset":
+		option.Type = engine.OptionButton
+	default:
+		option.Type, option.Default = engine.OptionString, rest
+	}
+	return option
+}
+
+func (c *Client) Name() string { return c.name }
+
+// Author returns nothing, since CECP engines don't say who wrote them.
+func (c *Client) Author() string { return "" }
+
+// Options returns the options that the engine declared during the handshake.
+func (c *Client) Options() []engine.Option { return c.options }
+
+// HasOption returns true if the engine declared an option with the given name. Option names are case-insensitive.
+func (c *Client) HasOption(name string) bool {
+	_, ok := engine.LookupOption(c.options, name)
+	return ok
+}
+
+// SetOption sets one of the engine's options. Options without a value, such as buttons, are sent without one, and
+// checks are sent as 1 or 0.
+func (c *Client) SetOption(name, value string) error {
+	if option, ok := engine.LookupOption(c.options, name); ok && option.Type == engine.OptionCheck {
+		switch value {
+		case "true":
+			value = "1"
+		case "false":
+			value = "0"
+		}
+	}
+	if value == "" {
+		return c.transport.Send("option " + name)
+	}
+	return c.transport.Send(fmt.Sprintf("option %s=%s", name, value))
+}
+
+// IsReady waits for the engine to answer a ping, if it supports them.
+func (c *Client) IsReady() error {
+	if c.features["ping"] != "1" {
+		return nil
+	}
+
+	c.pings++
+	engine.Expect(c.transport, engine.WaitReply)
+	if err := c.transport.Send(fmt.Sprintf("ping %d", c.pings)); err != nil {
+		return err
+	}
+	pong := fmt.Sprintf("pong %d", c.pings)
+	for {
+		line, err := c.transport.Recv()
+		if err != nil {
+			return err
+		}
+		if line == pong {
+			return nil
+		}
+	}
+}
+
+// NewGame resets the engine for a new game. Chess960 is played as the fischerandom variant, which the engine must
+// have declared.
+func (c *Client) NewGame(chess960 bool) error {
+	if chess960 && !c.supportsVariant("fischerandom") {
+		return errors.Errorf("engine %s does not support chess960", c.name)
+	}
+	c.chess960 = chess960
+	if err := c.reset(); err != nil {
+		return err
+	}
+	// Ask for thinking output, so that we hear about the engine's search, and for no pondering.
+	if err := c.transport.Send("post"); err != nil {
+		return err
+	}
+	return c.transport.Send("easy")
+}
+
+func (c *Client) supportsVariant(variant string) bool {
+	for _, supported := range strings.Split(c.features["variants"], ",") {
+		if supported == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// reset puts the engine's board back in the starting position, in force mode.
+func (c *Client) reset() error {
+	c.synced, c.fen, c.moves = false, "", nil
+	if err := c.transport.Send("new"); err != nil {
+		return err
+	}
+	if err := c.transport.Send("force"); err != nil {
+		return err
+	}
+	if c.chess960 {
+		if err := c.transport.Send("variant fischerandom"); err != nil {
+			return err
+		}
+	}
+	c.synced = true
+	return nil
+}
+
+// SetPosition sets the position to search, given as a FEN (or empty for the standard starting position) and the
+// moves played from there. If the moves extend the position the engine is already in, only the new moves are sent.
+// Positions other than the standard starting position need an engine that supports setboard.
+func (c *Client) SetPosition(fen string, moves []string) error {
+	if fen != "" {
+		if err := engine.ValidateFEN(fen); err != nil {
+			return err
+		}
+	}
+	if !c.synced || c.fen != fen || !extends(moves, c.moves) {
+		if err := c.setBoard(fen); err != nil {
+			return err
+		}
+	}
+
+	for _, move := range moves[len(c.moves):] {
+		command := move
+		if c.features["usermove"] == "1" {
+			command = "usermove " + move
+		}
+		if err := c.transport.Send(command); err != nil {
+			c.synced = false
+			return err
+		}
+		c.moves = append(c.moves, move)
+	}
+	return nil
+}
+
+func (c *Client) setBoard(fen string) error {
+	c.synced, c.fen, c.moves = false, "", nil
+	switch {
+	case c.features["setboard"] == "1":
+		if err := c.transport.Send("force"); err != nil {
+			return err
+		}
+		board := fen
+		if board == "" {
+			board = startingFEN
+		}
+		if err := c.transport.Send("setboard " + board); err != nil {
+			return err
+		}
+	case fen == "":
+		return c.reset()
+	default:
+		return errors.Errorf("engine %s does not support setboard", c.name)
+	}
+	c.synced, c.fen = true, fen
+	return nil
+}
+
+// extends returns true if moves begins with prefix.
+func extends(moves, prefix []string) bool {
+	if len(moves) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if moves[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// whiteToMove returns true if white is to move in the engine's position.
+func (c *Client) whiteToMove() bool {
+	startsWithWhite := true
+	if fields := strings.Fields(c.fen); len(fields) > 1 {
+		startsWithWhite = fields[1] != "b"
+	}
+	return (len(c.moves)%2 == 0) == startsWithWhite
+}
+
+// Go is Search, returning the engine's thinking output about its search along with its move. CECP engines are told not
+// to ponder, so they never say what reply they expect.
+func (c *Client) Go(limits engine.Limits) (engine.SearchResult, error) {
+	move, err := c.Search(limits)
+	if err != nil {
+		return engine.SearchResult{}, err
+	}
+	return engine.SearchResult{Move: move, Info: c.lastInfo}, nil
+}
+
+// Search sets the engine's clocks from limits and lets it move for the side to move, then puts it back in force mode.
+func (c *Client) Search(limits engine.Limits) (string, error) {
+	if err := c.sendLimits(limits); err != nil {
+		return "", err
+	}
+	engine.Expect(c.transport, engine.WaitSearch)
+	if err := c.transport.Send("go"); err != nil {
+		return "", err
+	}
+
+	// The engine sends its thinking output as it searches, then its move. Anything else is chatter, except for the
+	// result of the game, which the engine sends instead of a move when it has none to play.
+	c.lastInfo = engine.Info{}
+	for {
+		line, err := c.transport.Recv()
+		if err != nil {
+			c.synced = false
+			return "", err
+		}
+
+		switch {
+		case moveRegex.MatchString(line):
+			move := moveRegex.FindStringSubmatch(line)[1]
+			c.moves = append(c.moves, move)
+			if err := c.transport.Send("force"); err != nil {
+				c.synced = false
+				return "", err
+			}
+			return move, nil
+		case thinkingRegex.MatchString(line):
+			c.updateInfo(thinkingRegex.FindStringSubmatch(line))
+			if c.onInfo != nil {
+				c.onInfo(c.lastInfo)
+			}
+		case strings.HasPrefix(line, "Illegal move"), strings.HasPrefix(line, "Error"):
+			c.synced = false
+			return "", errors.Errorf("engine rejected its position: %s", line)
+		case resultRegex.MatchString(line):
+			c.synced = false
+			return "", errors.WithMessage(engine.ErrNoMove, line)
+		case line == "resign":
+			c.synced = false
+			return "", errors.New("engine resigned instead of moving")
+		default:
+			log.WithField("line", line).Debug("ignoring engine output")
+		}
+	}
+}
+
+// sendLimits tells the engine how long it has. CECP has no way of giving an engine its opponent's increment, so it
+// is told its own. CECP has no way of lifting a depth limit either, so an engine keeps to one in later searches. Node
+// limits, infinite searches, and restricting the moves searched have no equivalent in a game, so they're refused.
+func (c *Client) sendLimits(limits engine.Limits) error {
+	if limits.Nodes > 0 || limits.Infinite || len(limits.SearchMoves) > 0 {
+		return errors.New("CECP engines can't limit nodes, search infinitely, or restrict the moves they search")
+	}
+	if limits.Depth > 0 {
+		// CECP engines stop at the depth limit or when their clock runs out, so the clock is set to a day.
+		if err := c.transport.Send(fmt.Sprintf("sd %d", limits.Depth)); err != nil {
+			return err
+		}
+		return c.transport.Send("st 86400")
+	}
+	if limits.MoveTime > 0 {
+		seconds := int((limits.MoveTime + time.Second - 1) / time.Second)
+		return c.transport.Send(fmt.Sprintf("st %d", seconds))
+	}
+
+	ours, theirs, increment := limits.WhiteTime, limits.BlackTime, limits.WhiteIncrement
+	if !c.whiteToMove() {
+		ours, theirs, increment = limits.BlackTime, limits.WhiteTime, limits.BlackIncrement
+	}
+	seconds := int(ours / time.Second)
+	level := fmt.Sprintf("level %d %d:%02d %s",
+		limits.MovesToGo, seconds/60, seconds%60, strconv.FormatFloat(increment.Seconds(), 'f', -1, 64))
+	if err := c.transport.Send(level); err != nil {
+		return err
+	}
+	if err := c.transport.Send(fmt.Sprintf("time %d", centiseconds(ours))); err != nil {
+		return err
+	}
+	return c.transport.Send(fmt.Sprintf("otim %d", centiseconds(theirs)))
+}
+
+func centiseconds(d time.Duration) int64 {
+	return int64(d / (10 * time.Millisecond))
+}
+
+// updateInfo records a line of thinking output, "ply score time nodes pv", with the time in centiseconds. Principal
+// variations are recorded as the engine sends them, which may be in SAN.
+func (c *Client) updateInfo(match []string) {
+	depth, _ := strconv.Atoi(match[1])
+	score, _ := strconv.Atoi(match[2])
+	cs, _ := strconv.Atoi(match[3])
+	nodes, _ := strconv.ParseInt(match[4], 10, 64)
+
+	info := &c.lastInfo
+	info.Depth = depth
+	info.Time = cs * 10
+	info.Nodes = nodes
+	if info.Time > 0 {
+		info.NPS = nodes * 1000 / int64(info.Time)
+	}
+	info.HasScore = true
+	switch {
+	case score >= mateScore:
+		info.Score = engine.Score{Mate: max(score-mateScore, 1)}
+	case score <= -mateScore:
+		info.Score = engine.Score{Mate: -max(-score-mateScore, 1)}
+	default:
+		info.Score = engine.Score{Centipawns: score}
+	}
+	info.PV = strings.Fields(match[5])
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Info returns everything that the engine reported about its search during the most recent call to Search.
+func (c *Client) Info() engine.Info { return c.lastInfo }
+
+// OnInfo registers a function to call with everything the engine has reported so far, each time it sends a line of
+// thinking output during a search.
+func (c *Client) OnInfo(f func(engine.Info)) { c.onInfo = f }
+
+// Stop tells the engine to move now.
+func (c *Client) Stop() error {
+	return c.transport.Send("?")
+}
+
+func (c *Client) Quit() error {
+	return c.transport.Send("quit")
+}
+
+func (c *Client) Close() error {
+	return c.transport.Close()
+}
+
+// Kill forcibly terminates an engine that isn't responding to commands. Transports that can't terminate their engine
+// do nothing.
+func (c *Client) Kill() error {
+	return engine.Kill(c.transport)
+}
@@ -0,0 +1,143 @@
+package cecp
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+// newClient handshakes with an engine declaring the given features, then forgets what was sent during the handshake.
+func newClient(t *testing.T, trans *ucitest.Transport, features ...string) *Client {
+	for _, feature := range features {
+		trans.Respond(feature)
+	}
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	trans.Reset()
+	return client
+}
+
+func TestHandshake(t *testing.T) {
+	trans := &ucitest.Transport{}
+	trans.Respond("Crafty v25.2")
+	trans.Respond(`feature myname="Crafty 25.2" san=1 usermove=1 done=0`)
+	trans.Respond(`feature option="Hash -spin 64 1 4096" option="Clear Hash -button" variants="normal,fischerandom"`)
+	trans.Respond("feature done=1")
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Crafty 25.2", client.Name())
+	assert.Equal(t, []engine.Option{
+		{Name: "Hash", Type: engine.OptionSpin, Default: "64", Min: 1, Max: 4096},
+		{Name: "Clear Hash", Type: engine.OptionButton},
+	}, client.Options())
+	assert.True(t, client.HasOption("hash"))
+	assert.Equal(t, []string{
+		"xboard",
+		"protover 2",
+		"accepted myname",
+		"rejected san",
+		"accepted usermove",
+		"accepted done",
+		"accepted option",
+		"accepted option",
+		"accepted variants",
+		"accepted done",
+	}, trans.Sent())
+}
+
+func TestIsReady(t *testing.T) {
+	trans := &ucitest.Transport{Server: func(m *ucitest.Transport, msg string) error {
+		if msg == "ping 1" {
+			m.Respond("1. 30 5 1000 e2e4")
+			m.Respond("pong 1")
+		}
+		return nil
+	}}
+	client := newClient(t, trans, "feature ping=1 done=1")
+	assert.NoError(t, client.IsReady())
+	assert.Equal(t, []string{"ping 1"}, trans.Sent())
+}
+
+func TestSearch(t *testing.T) {
+	trans := &ucitest.Transport{Server: func(m *ucitest.Transport, msg string) error {
+		if msg == "go" {
+			m.Respond("4 25 12 4500 Nf3 d5 d4")
+			m.Respond("5 100003 30 9000 Qxf7#")
+			m.Respond("move d1f7")
+		}
+		return nil
+	}}
+	client := newClient(t, trans, "feature usermove=1 done=1")
+	assert.NoError(t, client.NewGame(false))
+	assert.NoError(t, client.SetPosition("", []string{"e2e4", "e7e5"}))
+
+	var depths []int
+	client.OnInfo(func(info engine.Info) { depths = append(depths, info.Depth) })
+	move, err := client.Search(engine.Limits{
+		WhiteTime:      65 * time.Second,
+		BlackTime:      30 * time.Second,
+		WhiteIncrement: 500 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "d1f7", move)
+	assert.Equal(t, []int{4, 5}, depths)
+	info := client.Info()
+	assert.Equal(t, engine.Score{Mate: 3}, info.Score)
+	assert.Equal(t, 300, info.Time)
+	assert.Equal(t, int64(30000), info.NPS)
+
+	// The engine played its own move, so only the reply needs to be sent.
+	assert.NoError(t, client.SetPosition("", []string{"e2e4", "e7e5", "d1f7", "e8e7"}))
+	assert.Equal(t, []string{
+		"new", "force", "post", "easy",
+		"usermove e2e4", "usermove e7e5",
+		"level 0 1:05 0.5", "time 6500", "otim 3000", "go", "force",
+		"usermove e8e7",
+	}, trans.Sent())
+}
+
+func TestSearchGameOver(t *testing.T) {
+	trans := &ucitest.Transport{Server: func(m *ucitest.Transport, msg string) error {
+		if msg == "go" {
+			m.Respond("0-1 {Black mates}")
+		}
+		return nil
+	}}
+	client := newClient(t, trans, "feature usermove=1 done=1")
+	assert.NoError(t, client.SetPosition("", []string{"f2f3", "e7e5", "g2g4", "d8h4"}))
+	_, err := client.Search(engine.Limits{MoveTime: time.Second})
+	assert.True(t, engine.IsNoMove(err))
+}
+
+func TestSetPositionFEN(t *testing.T) {
+	const fen = "4k3/8/8/8/8/8/8/4K2R b K - 0 1"
+	client := newClient(t, &ucitest.Transport{}, "feature done=1")
+	assert.Error(t, client.SetPosition(fen, nil))
+
+	trans := &ucitest.Transport{}
+	client = newClient(t, trans, "feature setboard=1 done=1")
+	assert.NoError(t, client.SetPosition(fen, []string{"e8d8"}))
+	_, err := client.Search(engine.Limits{MoveTime: 1500 * time.Millisecond})
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"force", "setboard " + fen, "e8d8", "st 2", "go"}, trans.Sent())
+}
+
+func TestNewGameChess960(t *testing.T) {
+	client := newClient(t, &ucitest.Transport{}, "feature done=1")
+	assert.Error(t, client.NewGame(true))
+
+	trans := &ucitest.Transport{}
+	client = newClient(t, trans, `feature variants="normal,fischerandom" done=1`)
+	assert.NoError(t, client.NewGame(true))
+	assert.Equal(t, []string{"new", "force", "variant fischerandom", "post", "easy"}, trans.Sent())
+}
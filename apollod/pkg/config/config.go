@@ -0,0 +1,764 @@
+// Package config defines apollod's configuration file.
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/swgillespie/apollo/apollod/pkg/archive"
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/crash"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/logfile"
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+)
+
+// Config is the root of apollod's YAML configuration file.
+type Config struct {
+	// Token is the lichess API token. The LICHESS_TOKEN environment variable, if set, takes precedence.
+	Token string `yaml:"token"`
+
+	Lichess        server.LichessConfig      `yaml:"lichess"`
+	Engine         EngineConfig              `yaml:"engine"`
+	Games          GamesConfig               `yaml:"games"`
+	Challenges     server.ChallengePolicy    `yaml:"challenges"`
+	TimeManagement TimeManagementConfig      `yaml:"timeManagement"`
+	Matchmaking    MatchmakingConfig         `yaml:"matchmaking"`
+	Availability   server.AvailabilityConfig `yaml:"availability"`
+	Logging        LoggingConfig             `yaml:"logging"`
+	Metrics        MetricsConfig             `yaml:"metrics"`
+	Admin          AdminConfig               `yaml:"admin"`
+	Events         EventsConfig              `yaml:"events"`
+	CrashReports   CrashReportsConfig        `yaml:"crashReports"`
+	Archive        ArchiveConfig             `yaml:"archive"`
+	Results        ResultsConfig             `yaml:"results"`
+	Database       DatabaseConfig            `yaml:"database"`
+	Chat           server.ChatConfig         `yaml:"chat"`
+	Etiquette      server.EtiquetteConfig    `yaml:"etiquette"`
+	Book           BookConfig                `yaml:"book"`
+	Tablebase      server.TablebaseConfig    `yaml:"tablebase"`
+	Blocklist      server.BlocklistConfig    `yaml:"blocklist"`
+	// Accounts are more lichess bot accounts to play for in the same process, alongside the one whose token is Token.
+	Accounts []AccountConfig `yaml:"accounts"`
+}
+
+// AccountConfig is an additional bot account. It shares everything with the main account except for its token and
+// whatever it overrides, which is how one process can field, say, a bullet persona and a classical persona with
+// differently tuned engines.
+type AccountConfig struct {
+	Token string `yaml:"token"`
+	// Engine, if set, replaces the main account's engine configuration.
+	Engine *EngineConfig `yaml:"engine"`
+	// Challenges, if set, replaces the main account's challenge policy. Anything it leaves unset takes the default.
+	Challenges *server.ChallengePolicy `yaml:"challenges"`
+}
+
+// UnmarshalYAML fills in the defaults for an account's challenge policy, if it has one.
+func (a *AccountConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var keys map[string]interface{}
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+
+	type plain AccountConfig
+	account := plain{}
+	if _, ok := keys["challenges"]; ok {
+		policy := server.DefaultChallengePolicy()
+		account.Challenges = &policy
+	}
+	if err := unmarshal(&account); err != nil {
+		return err
+	}
+	*a = AccountConfig(account)
+	return nil
+}
+
+// Account is everything needed to start a server for one bot account.
+type Account struct {
+	Token   string
+	Options []server.ServerOption
+}
+
+type EngineConfig struct {
+	server.EngineProfile `yaml:",inline"`
+	// Profiles override the engine binary or options for games at a particular lichess speed.
+	Profiles map[string]server.EngineProfile `yaml:"profiles"`
+	// Variants route games of lichess variants, such as crazyhouse or atomic, to engines that play them.
+	Variants map[string]server.EngineProfile `yaml:"variants"`
+	// Canary plays a fraction of games with a candidate build of the engine.
+	Canary server.CanaryConfig `yaml:"canary"`
+}
+
+type BookConfig struct {
+	// Path is a PGN file of games to build the opening book from, or a Polyglot book with the .bin extension. Empty
+	// disables the book.
+	Path              string `yaml:"path"`
+	server.BookConfig `yaml:",inline"`
+	// Prep steers the book away from every opponent's favorite lines, as seen in their recent games.
+	Prep server.PrepConfig `yaml:"prep"`
+}
+
+type GamesConfig struct {
+	// MaxConcurrent is the number of games to play at once.
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// Rematch challenges the opponent to a rematch after every finished game.
+	Rematch bool `yaml:"rematch"`
+	// Engines is the number of engines that may run at once across every account. 0 means games.maxConcurrent for
+	// each account.
+	Engines int `yaml:"engines"`
+	// MoveDelay slows down our replies to human opponents.
+	MoveDelay server.MoveDelayConfig `yaml:"moveDelay"`
+}
+
+type TimeManagementConfig struct {
+	// MoveOverhead is the time reserved on our clock for every move to cover network latency.
+	MoveOverhead time.Duration `yaml:"moveOverhead"`
+	// The server's own time manager, which replaces the engine's when enabled.
+	server.TimeManagerConfig `yaml:",inline"`
+	// Clocks translates lichess clocks into search limits the engine can use.
+	Clocks server.ClockConfig `yaml:"clocks"`
+}
+
+type MatchmakingConfig struct {
+	Enabled                  bool `yaml:"enabled"`
+	server.MatchmakingConfig `yaml:",inline"`
+}
+
+type LoggingConfig struct {
+	// Level is a logrus level name, e.g. "info" or "debug".
+	Level string `yaml:"level"`
+	// Format is how log lines are written: "text" for people, or "json" for log aggregators.
+	Format string `yaml:"format"`
+	// GameDir is a directory to write a log and an engine transcript for every game to. Empty disables them.
+	GameDir string `yaml:"gameDir"`
+	// Files configures writing the server's log to rotated files, in addition to stderr.
+	Files logfile.Config `yaml:"files"`
+}
+
+type MetricsConfig struct {
+	// Addr is the address to serve /metrics on. If empty, metrics are not served.
+	Addr string `yaml:"addr"`
+	// Search exports the depth and speed of the engine's searches in live games.
+	Search bool `yaml:"search"`
+}
+
+type AdminConfig struct {
+	// Addr is the address to serve the admin API on. It is unauthenticated, so it must be a loopback address. If
+	// empty, the admin API is not served.
+	Addr string `yaml:"addr"`
+	// Engines are the engine binaries that the admin API may swap the engine to. Without any, it may only swap to
+	// apollo from the PATH.
+	Engines []string `yaml:"engines"`
+}
+
+type EventsConfig struct {
+	// Addr is the address to stream live game events to observers on. If empty, events are not served.
+	Addr string `yaml:"addr"`
+}
+
+type CrashReportsConfig struct {
+	// DSN is a Sentry DSN to report panics and fatal errors to. If empty, crashes are not reported.
+	DSN string `yaml:"dsn"`
+}
+
+type ArchiveConfig struct {
+	// Dir is a local directory to archive games to.
+	Dir string `yaml:"dir"`
+	// S3 configures archiving games to an S3-compatible bucket instead.
+	S3 *S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+}
+
+type ResultsConfig struct {
+	// Path is the file to record game results in. Empty disables result tracking.
+	Path    string              `yaml:"path"`
+	Reports server.ReportConfig `yaml:"reports"`
+}
+
+// DatabaseConfig is a database to record every challenge, game, and move in. It replaces the results file.
+type DatabaseConfig struct {
+	// Driver is "sqlite3" or "postgres". Empty disables the database.
+	Driver string `yaml:"driver"`
+	// DSN is the SQLite file, or the Postgres connection string.
+	DSN string `yaml:"dsn"`
+}
+
+// Default returns the configuration used when no configuration file is given.
+func Default() *Config {
+	return &Config{
+		Games:      GamesConfig{MaxConcurrent: 2, MoveDelay: server.DefaultMoveDelayConfig()},
+		Challenges: server.DefaultChallengePolicy(),
+		TimeManagement: TimeManagementConfig{
+			MoveOverhead:      100 * time.Millisecond,
+			TimeManagerConfig: server.DefaultTimeManagerConfig(),
+			Clocks:            server.DefaultClockConfig(),
+		},
+		Matchmaking: MatchmakingConfig{MatchmakingConfig: server.DefaultMatchmakingConfig()},
+		Logging:     LoggingConfig{Level: "info", Format: "text", Files: logfile.DefaultConfig()},
+		Results:     ResultsConfig{Reports: server.ReportConfig{Interval: 24 * time.Hour}},
+		Lichess:     server.DefaultLichessConfig(),
+		Chat:        server.DefaultChatConfig(),
+		Etiquette:   server.DefaultEtiquetteConfig(),
+		Book:        BookConfig{Prep: server.DefaultPrepConfig()},
+		Tablebase:   server.DefaultTablebaseConfig(),
+		Blocklist:   server.DefaultBlocklistConfig(),
+	}
+}
+
+// Load reads a configuration file, filling in defaults for anything it doesn't mention, and validates it. If path is
+// empty, the default configuration is used.
+func Load(path string) (*Config, error) {
+	config := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read config file")
+		}
+		if err := yaml.UnmarshalStrict(data, config); err != nil {
+			return nil, errors.Wrap(err, "failed to parse config file")
+		}
+	}
+
+	if token := os.Getenv("LICHESS_TOKEN"); token != "" {
+		config.Token = token
+	}
+
+	if err := config.loadOptionsFiles(); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadOptionsFiles reads the options file of every engine profile into its options.
+func (c *Config) loadOptionsFiles() error {
+	if err := c.Engine.loadOptionsFiles(); err != nil {
+		return errors.Wrap(err, "engine")
+	}
+	for i, account := range c.Accounts {
+		if account.Engine != nil {
+			if err := account.Engine.loadOptionsFiles(); err != nil {
+				return errors.Wrapf(err, "accounts[%d].engine", i)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *EngineConfig) loadOptionsFiles() error {
+	if err := e.LoadOptionsFile(); err != nil {
+		return err
+	}
+	for speed, profile := range e.Profiles {
+		if err := profile.LoadOptionsFile(); err != nil {
+			return errors.Wrapf(err, "profiles.%s", speed)
+		}
+		e.Profiles[speed] = profile
+	}
+	for variant, profile := range e.Variants {
+		if err := profile.LoadOptionsFile(); err != nil {
+			return errors.Wrapf(err, "variants.%s", variant)
+		}
+		e.Variants[variant] = profile
+	}
+	return errors.Wrap(e.Canary.Engine.LoadOptionsFile(), "canary.engine")
+}
+
+// isLoopback returns true if addr, a host and port to listen on, only listens on loopback.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Validate checks the configuration for errors.
+func (c *Config) Validate() error {
+	if err := validateOptions(c.Engine.Options); err != nil {
+		return errors.Wrap(err, "invalid engine.options")
+	}
+	if err := validateProtocol(c.Engine.Protocol); err != nil {
+		return errors.Wrap(err, "invalid engine.protocol")
+	}
+	if c.Engine.Restarts < 0 {
+		return errors.New("engine.restarts must not be negative")
+	}
+	if c.Admin.Addr != "" && !isLoopback(c.Admin.Addr) {
+		return errors.Errorf("admin.addr %q must be a loopback address, since the admin API is unauthenticated", c.Admin.Addr)
+	}
+	if c.Engine.EngineGames < 0 {
+		return errors.New("engine.engineGames must not be negative")
+	}
+	if err := c.Engine.Keepalive.Validate(); err != nil {
+		return errors.Wrap(err, "invalid engine.keepalive")
+	}
+	for speed, profile := range c.Engine.Profiles {
+		if err := validateOptions(profile.Options); err != nil {
+			return errors.Wrapf(err, "invalid engine.profiles.%s.options", speed)
+		}
+		if err := profile.Keepalive.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid engine.profiles.%s.keepalive", speed)
+		}
+		if err := validateProtocol(profile.Protocol); err != nil {
+			return errors.Wrapf(err, "invalid engine.profiles.%s.protocol", speed)
+		}
+		switch speed {
+		case "ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence":
+		default:
+			return errors.Errorf("engine.profiles: unknown speed %q", speed)
+		}
+	}
+	if err := validateVariants(c.Engine.Variants); err != nil {
+		return errors.Wrap(err, "invalid engine.variants")
+	}
+	if err := validateCanary(c.Engine.Canary); err != nil {
+		return errors.Wrap(err, "invalid engine.canary")
+	}
+	if c.Games.MaxConcurrent < 1 {
+		return errors.New("games.maxConcurrent must be at least 1")
+	}
+	if c.Games.Engines < 0 {
+		return errors.New("games.engines must not be negative")
+	}
+	for i, account := range c.Accounts {
+		if account.Token == "" {
+			return errors.Errorf("accounts[%d].token must be set", i)
+		}
+		if account.Engine != nil {
+			if err := validateOptions(account.Engine.Options); err != nil {
+				return errors.Wrapf(err, "invalid accounts[%d].engine.options", i)
+			}
+			if err := validateProtocol(account.Engine.Protocol); err != nil {
+				return errors.Wrapf(err, "invalid accounts[%d].engine.protocol", i)
+			}
+			if err := account.Engine.Keepalive.Validate(); err != nil {
+				return errors.Wrapf(err, "invalid accounts[%d].engine.keepalive", i)
+			}
+			if err := validateVariants(account.Engine.Variants); err != nil {
+				return errors.Wrapf(err, "invalid accounts[%d].engine.variants", i)
+			}
+			if err := validateCanary(account.Engine.Canary); err != nil {
+				return errors.Wrapf(err, "invalid accounts[%d].engine.canary", i)
+			}
+		}
+		if account.Challenges != nil {
+			if err := account.Challenges.Validate(); err != nil {
+				return errors.Wrapf(err, "invalid accounts[%d].challenges", i)
+			}
+		}
+		policy, variants := c.Challenges, c.Engine.Variants
+		if account.Challenges != nil {
+			policy = *account.Challenges
+		}
+		if account.Engine != nil {
+			variants = account.Engine.Variants
+		}
+		if err := policy.ValidateEngines(variants); err != nil {
+			return errors.Wrapf(err, "invalid accounts[%d].challenges", i)
+		}
+	}
+	if c.Games.MoveDelay.Min < 0 || c.Games.MoveDelay.Max < 0 {
+		return errors.New("games.moveDelay.min and games.moveDelay.max must not be negative")
+	}
+	if c.Games.MoveDelay.Max != 0 && c.Games.MoveDelay.Max < c.Games.MoveDelay.Min {
+		return errors.New("games.moveDelay.max must not be less than games.moveDelay.min")
+	}
+	for _, speed := range c.Games.MoveDelay.Speeds {
+		switch speed {
+		case "ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence":
+		default:
+			return errors.Errorf("games.moveDelay.speeds: unknown speed %q", speed)
+		}
+	}
+	if err := c.Challenges.Validate(); err != nil {
+		return errors.Wrap(err, "invalid challenges")
+	}
+	if err := c.Challenges.ValidateEngines(c.Engine.Variants); err != nil {
+		return errors.Wrap(err, "invalid challenges")
+	}
+	if c.TimeManagement.MoveOverhead < 0 {
+		return errors.New("timeManagement.moveOverhead must not be negative")
+	}
+	if err := validateTimeManager(c.TimeManagement.TimeManagerConfig); err != nil {
+		return errors.Wrap(err, "invalid timeManagement")
+	}
+	if err := validateClocks(c.TimeManagement.Clocks); err != nil {
+		return errors.Wrap(err, "invalid timeManagement.clocks")
+	}
+	if c.Matchmaking.Enabled && c.Matchmaking.ClockLimit <= 0 {
+		return errors.New("matchmaking.clockLimit must be positive")
+	}
+	if c.Matchmaking.RatingRefresh < 0 {
+		return errors.New("matchmaking.ratingRefresh must not be negative")
+	}
+	if err := c.Availability.Validate(); err != nil {
+		return errors.Wrap(err, "invalid availability")
+	}
+	if c.Archive.Dir != "" && c.Archive.S3 != nil {
+		return errors.New("archive.dir and archive.s3 are mutually exclusive")
+	}
+	if s3 := c.Archive.S3; s3 != nil && (s3.Endpoint == "" || s3.Bucket == "" || s3.Region == "") {
+		return errors.New("archive.s3 requires endpoint, region, and bucket")
+	}
+	if c.Results.Reports.Owner != "" && c.Results.Path == "" && c.Database.Driver == "" {
+		return errors.New("results.reports requires results.path or a database")
+	}
+	if c.Blocklist.AutoBlockAfter < 0 {
+		return errors.New("blocklist.autoBlockAfter must not be negative")
+	}
+	if c.Blocklist.AutoBlockAfter > 0 && c.Blocklist.AutoBlockWindow <= 0 {
+		return errors.New("blocklist.autoBlockWindow must be positive")
+	}
+	switch c.Database.Driver {
+	case "":
+	case "sqlite3", "postgres":
+		if c.Database.DSN == "" {
+			return errors.New("database.dsn is required")
+		}
+		if c.Results.Path != "" {
+			return errors.New("results.path and database are mutually exclusive")
+		}
+	default:
+		return errors.Errorf("database.driver must be sqlite3 or postgres, not %q", c.Database.Driver)
+	}
+	if c.Results.Reports.Interval <= 0 {
+		return errors.New("results.reports.interval must be positive")
+	}
+	for speed := range c.Book.Speeds {
+		switch speed {
+		case "ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence":
+		default:
+			return errors.Errorf("book.speeds: unknown speed %q", speed)
+		}
+	}
+	if err := c.Book.Prep.Validate(); err != nil {
+		return errors.Wrap(err, "invalid book.prep")
+	}
+	if err := c.Lichess.Validate(); err != nil {
+		return errors.Wrap(err, "invalid lichess")
+	}
+	if c.Tablebase.Enabled && (c.Tablebase.MaxPieces < 3 || c.Tablebase.MaxPieces > 7) {
+		return errors.New("tablebase.maxPieces must be between 3 and 7")
+	}
+	if c.Tablebase.Enabled && c.Tablebase.Timeout <= 0 {
+		return errors.New("tablebase.timeout must be positive")
+	}
+	if c.Chat.CommentarySwing < 0 || c.Chat.CommentaryEvery < 0 {
+		return errors.New("chat.commentarySwing and chat.commentaryEvery must not be negative")
+	}
+	if c.Chat.CommandInterval < 0 {
+		return errors.New("chat.commandInterval must not be negative")
+	}
+	if err := c.Etiquette.Validate(); err != nil {
+		return errors.Wrap(err, "invalid etiquette")
+	}
+	for speed := range c.Etiquette.Speeds {
+		switch speed {
+		case "ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence":
+		default:
+			return errors.Errorf("etiquette.speeds: unknown speed %q", speed)
+		}
+	}
+	if _, err := log.ParseLevel(c.Logging.Level); err != nil {
+		return errors.Wrap(err, "invalid logging.level")
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		return errors.Errorf("invalid logging.format %q, expected text or json", c.Logging.Format)
+	}
+	if c.CrashReports.DSN != "" {
+		if _, err := crash.New(c.CrashReports.DSN, ""); err != nil {
+			return errors.Wrap(err, "invalid crashReports.dsn")
+		}
+	}
+	if files := c.Logging.Files; files.MaxSizeMB < 0 || files.MaxAge < 0 || files.MaxArchives < 0 || files.MaxRuns < 0 {
+		return errors.New("logging.files limits must not be negative")
+	}
+	return nil
+}
+
+// validateTimeManager checks that the time manager's budgets make sense.
+func validateTimeManager(config server.TimeManagerConfig) error {
+	if config.MovesToGo < 1 {
+		return errors.New("movesToGo must be at least 1")
+	}
+	fractions := map[string]float64{"maxFraction": config.MaxFraction}
+	for speed, fraction := range config.Speeds {
+		fractions["speeds."+speed] = fraction
+	}
+	for name, fraction := range fractions {
+		if fraction <= 0 || fraction > 1 {
+			return errors.Errorf("%s must be greater than 0 and at most 1", name)
+		}
+	}
+	if config.OutOfBookBonus < 0 || config.SwingBonus < 0 || config.SwingThreshold < 0 {
+		return errors.New("bonuses and swingThreshold must not be negative")
+	}
+	return nil
+}
+
+// validateClocks checks that the clock translation's limits make sense.
+func validateClocks(config server.ClockConfig) error {
+	if config.UntimedMoveTime < 0 {
+		return errors.New("untimedMoveTime must not be negative")
+	}
+	for speed, limit := range config.MaxMoveTime {
+		if limit <= 0 {
+			return errors.Errorf("maxMoveTime.%s must be positive", speed)
+		}
+	}
+	for speed, moves := range config.MovesToGo {
+		if moves < 1 {
+			return errors.Errorf("movesToGo.%s must be at least 1", speed)
+		}
+	}
+	return nil
+}
+
+// validateOptions checks that UCI option names can be sent to an engine intact.
+func validateOptions(options map[string]string) error {
+	for name := range options {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("option names must not be empty")
+		}
+		if strings.Contains(strings.ToLower(" "+name+" "), " value ") {
+			return errors.Errorf("option name %q must not contain the word 'value'", name)
+		}
+	}
+	return nil
+}
+
+// validateVariants checks that every variant engine is for a lichess variant, names its own binary, and has valid
+// options and protocol.
+func validateVariants(variants map[string]server.EngineProfile) error {
+	for variant, profile := range variants {
+		switch variant {
+		case "standard", "fromPosition", "chess960", "crazyhouse", "antichess", "atomic", "horde", "kingOfTheHill",
+			"racingKings", "threeCheck":
+		default:
+			return errors.Errorf("unknown variant %q", variant)
+		}
+		if profile.Path == "" {
+			return errors.Errorf("%s.path must be set", variant)
+		}
+		if err := validateOptions(profile.Options); err != nil {
+			return errors.Wrapf(err, "invalid %s.options", variant)
+		}
+		if err := validateProtocol(profile.Protocol); err != nil {
+			return errors.Wrapf(err, "invalid %s.protocol", variant)
+		}
+	}
+	return nil
+}
+
+// validateCanary checks a canary's settings, and its engine's options and protocol.
+func validateCanary(canary server.CanaryConfig) error {
+	if err := canary.Validate(); err != nil {
+		return err
+	}
+	if err := validateOptions(canary.Engine.Options); err != nil {
+		return errors.Wrap(err, "invalid engine.options")
+	}
+	return validateProtocol(canary.Engine.Protocol)
+}
+
+// validateProtocol checks that engines speaking protocol can be played with. Empty means the default protocol.
+func validateProtocol(protocol string) error {
+	if protocol == "" {
+		return nil
+	}
+	for _, known := range engine.Protocols() {
+		if protocol == known {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown protocol %q, must be one of %s", protocol, strings.Join(engine.Protocols(), ", "))
+}
+
+// ServerOptions translates the configuration into options for server.NewServer, loading anything (like the opening
+// book) that the options refer to.
+func (c *Config) ServerOptions() ([]server.ServerOption, error) {
+	options := []server.ServerOption{
+		server.WithMaxConcurrentGames(c.Games.MaxConcurrent),
+		server.WithLichess(c.Lichess),
+		server.WithMoveOverhead(c.TimeManagement.MoveOverhead),
+		server.WithTimeManager(c.TimeManagement.TimeManagerConfig),
+		server.WithClocks(c.TimeManagement.Clocks),
+		server.WithChallengePolicy(c.Challenges),
+		server.WithEngine(c.Engine.EngineProfile, c.Engine.Profiles),
+		server.WithVariantEngines(c.Engine.Variants),
+		server.WithCanary(c.Engine.Canary),
+		server.WithSwappableEngines(c.Admin.Engines),
+		server.WithChat(c.Chat),
+		server.WithEtiquette(c.Etiquette),
+		server.WithTablebase(c.Tablebase),
+		server.WithRematch(c.Games.Rematch),
+		server.WithMoveDelay(c.Games.MoveDelay),
+		server.WithSearchMetrics(c.Metrics.Search),
+		server.WithGameLogs(c.Logging.GameDir),
+	}
+	// Every account shares the blocklist, so there's only ever one copy of it being written.
+	blocklist, err := server.OpenBlocklist(c.Blocklist)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, server.WithBlocklist(blocklist))
+	availability, err := server.ParseAvailability(c.Availability)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, server.WithAvailability(availability))
+	if c.Matchmaking.Enabled {
+		options = append(options, server.WithMatchmaking(c.Matchmaking.MatchmakingConfig))
+	}
+	if c.Database.Driver != "" {
+		db, err := store.Open(c.Database.Driver, c.Database.DSN)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, server.WithStore(db), server.WithResults(db, c.Results.Reports))
+	}
+	if c.Results.Path != "" {
+		options = append(options, server.WithResults(&results.Store{Path: c.Results.Path}, c.Results.Reports))
+	}
+	if archived := c.ArchiveStore(); archived != nil {
+		options = append(options, server.WithArchive(archived))
+	}
+	if c.Book.Path != "" {
+		// Nothing deeper than the deepest limit will ever be played, so there's no sense keeping it in memory.
+		openings, err := book.Open(c.Book.Path, c.Book.deepestPly())
+		if err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"path":      c.Book.Path,
+			"positions": openings.Len(),
+		}).Info("loaded opening book")
+		options = append(options, server.WithBook(openings, c.Book.BookConfig))
+		if c.Book.Prep.Games > 0 {
+			options = append(options, server.WithOpponentPrep(c.Book.Prep))
+		}
+	}
+	return options, nil
+}
+
+// ArchiveStore returns where games are archived, or nil if they aren't.
+func (c *Config) ArchiveStore() archive.Store {
+	if s3 := c.Archive.S3; s3 != nil {
+		return &archive.S3Store{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    s3.Prefix,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+		}
+	}
+	if c.Archive.Dir != "" {
+		return archive.DirectoryStore{Dir: c.Archive.Dir}
+	}
+	return nil
+}
+
+// AccountOptions returns the options for every account's server: the main account's, followed by those of any additional
+// accounts. They all share a pool of engines and the lichess rate limit.
+func (c *Config) AccountOptions() ([]Account, error) {
+	base, err := c.ServerOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	engines := c.Games.Engines
+	if engines == 0 {
+		engines = c.Games.MaxConcurrent * (1 + len(c.Accounts))
+	}
+	base = append(base, server.WithPool(server.NewPool(engines)))
+
+	accounts := []Account{{Token: c.Token, Options: base}}
+	for _, account := range c.Accounts {
+		// Later options override earlier ones, so overrides go on the end of a copy of the main account's options.
+		options := append([]server.ServerOption(nil), base...)
+		if account.Engine != nil {
+			options = append(options,
+				server.WithEngine(account.Engine.EngineProfile, account.Engine.Profiles),
+				server.WithVariantEngines(account.Engine.Variants),
+				server.WithCanary(account.Engine.Canary))
+		}
+		if account.Challenges != nil {
+			options = append(options, server.WithChallengePolicy(*account.Challenges))
+		}
+		accounts = append(accounts, Account{Token: account.Token, Options: options})
+	}
+	return accounts, nil
+}
+
+// AccountReloads returns the part of every account's configuration that a running server can reload, in the same order
+// as AccountOptions.
+func (c *Config) AccountReloads() []server.Reloadable {
+	main := server.Reloadable{
+		Policy:    c.Challenges,
+		Engine:    c.Engine.EngineProfile,
+		Profiles:  c.Engine.Profiles,
+		Variants:  c.Engine.Variants,
+		Canary:    c.Engine.Canary,
+		Chat:      c.Chat,
+		Etiquette: c.Etiquette,
+		Reports:   c.Results.Reports,
+	}
+	reloads := []server.Reloadable{main}
+	for _, account := range c.Accounts {
+		reload := main
+		if account.Engine != nil {
+			reload.Engine, reload.Profiles = account.Engine.EngineProfile, account.Engine.Profiles
+			reload.Variants = account.Engine.Variants
+			reload.Canary = account.Engine.Canary
+		}
+		if account.Challenges != nil {
+			reload.Policy = *account.Challenges
+		}
+		reloads = append(reloads, reload)
+	}
+	return reloads
+}
+
+// deepestPly returns the deepest book limit across every speed, or 0 if any speed is unlimited.
+func (c BookConfig) deepestPly() int {
+	limits := []int{c.MaxPly}
+	for _, ply := range c.Speeds {
+		limits = append(limits, ply)
+	}
+
+	deepest := 0
+	for _, ply := range limits {
+		if ply == 0 {
+			return 0
+		}
+		if ply > deepest {
+			deepest = ply
+		}
+	}
+	return deepest
+}
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "apollod-config")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer file.Close()
+	file.WriteString(contents)
+	return file.Name()
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, config.Games.MaxConcurrent)
+	assert.Equal(t, 100*time.Millisecond, config.TimeManagement.MoveOverhead)
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+token: secret
+games:
+  maxConcurrent: 4
+challenges:
+  variants: [standard]
+  minInitial: 60
+  allowRated: true
+timeManagement:
+  moveOverhead: 250ms
+matchmaking:
+  enabled: true
+  idleTime: 30m
+`)
+	defer os.Remove(path)
+
+	config, err := Load(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, 4, config.Games.MaxConcurrent)
+	assert.Equal(t, []string{"standard"}, config.Challenges.Variants)
+	assert.Equal(t, 60, config.Challenges.MinInitial)
+	assert.Equal(t, 250*time.Millisecond, config.TimeManagement.MoveOverhead)
+	assert.Equal(t, 30*time.Minute, config.Matchmaking.IdleTime)
+	assert.Equal(t, 180, config.Matchmaking.ClockLimit)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := writeConfig(t, `
+challenges:
+  variants: [atomic]
+`)
+	defer os.Remove(path)
+	_, err := Load(path)
+	assert.Error(t, err)
+
+	path = writeConfig(t, `
+games:
+  maxConcurent: 4
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	path = writeConfig(t, `
+logging:
+  format: xml
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	path = writeConfig(t, `
+admin:
+  addr: ":9091"
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "the admin API is unauthenticated, so it only listens on loopback")
+
+	path = writeConfig(t, `
+engine:
+  protocol: winboard
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	path = writeConfig(t, `
+engine:
+  canary:
+    fraction: 0.1
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "a canary without an engine of its own is the usual engine")
+
+	path = writeConfig(t, `
+book:
+  prep:
+    plies: 0
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "preparing from games without looking at any of their moves")
+
+	path = writeConfig(t, `
+etiquette:
+  speeds:
+    bullet: reckless
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "no such etiquette profile")
+
+	path = writeConfig(t, `
+lichess:
+  urls: [lichess.org]
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "a lichess URL without a scheme")
+
+	path = writeConfig(t, `
+challenges:
+  variants: [standard, atomic]
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "accepting a variant that nothing plays")
+
+	path = writeConfig(t, `
+engine:
+  keepalive:
+    interval: 10s
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "a keepalive that gives engines no time to answer")
+}
+
+func TestIsLoopback(t *testing.T) {
+	assert.True(t, isLoopback("127.0.0.1:9091"))
+	assert.True(t, isLoopback("localhost:9091"))
+	assert.True(t, isLoopback("[::1]:9091"))
+	assert.False(t, isLoopback(":9091"))
+	assert.False(t, isLoopback("0.0.0.0:9091"))
+	assert.False(t, isLoopback("192.168.1.10:9091"))
+	assert.False(t, isLoopback("127.0.0.1"))
+}
+
+func TestLoadEngineOptions(t *testing.T) {
+	path := writeConfig(t, `
+engine:
+  options:
+    Hash: 256
+    SyzygyPath: /tables
+  profiles:
+    bullet:
+      options:
+        Hash: 32
+`)
+	defer os.Remove(path)
+
+	config, err := Load(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "256", config.Engine.Options["Hash"])
+	assert.Equal(t, "32", config.Engine.Profiles["bullet"].Options["Hash"])
+
+	path = writeConfig(t, `
+engine:
+  profiles:
+    hyperbullet: {}
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err)
+}
+
+func TestLoadEngineOptionsFile(t *testing.T) {
+	options := writeConfig(t, `{"Hash": 1024, "Ponder": true, "Clear Hash": null, "SyzygyPath": "/tables"}`)
+	defer os.Remove(options)
+	path := writeConfig(t, `
+engine:
+  optionsFile: `+options+`
+  options:
+    Hash: 256
+  profiles:
+    bullet:
+      optionsFile: `+options+`
+`)
+	defer os.Remove(path)
+
+	config, err := Load(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, map[string]string{"Hash": "256", "Ponder": "true", "Clear Hash": "", "SyzygyPath": "/tables"}, config.Engine.Options)
+	assert.Equal(t, "1024", config.Engine.Profiles["bullet"].Options["Hash"])
+
+	path = writeConfig(t, `
+engine:
+  optionsFile: /nonexistent/options.yaml
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err)
+}
+
+func TestLoadVariantEngines(t *testing.T) {
+	path := writeConfig(t, `
+engine:
+  variants:
+    crazyhouse:
+      path: /opt/fairy-stockfish
+challenges:
+  variants: [standard, crazyhouse]
+`)
+	defer os.Remove(path)
+
+	config, err := Load(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "/opt/fairy-stockfish", config.Engine.Variants["crazyhouse"].Path)
+	assert.Equal(t, config.Engine.Variants, config.AccountReloads()[0].Variants)
+
+	path = writeConfig(t, `
+engine:
+  variants:
+    crazyhouse:
+      options:
+        Threads: 2
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "a variant engine without a binary of its own")
+}
+
+func TestBookDeepestPly(t *testing.T) {
+	assert.Equal(t, 0, BookConfig{}.deepestPly())
+	assert.Equal(t, 0, BookConfig{Path: "book.pgn"}.deepestPly())
+
+	config := BookConfig{Path: "book.pgn"}
+	config.MaxPly = 12
+	config.Speeds = map[string]int{"bullet": 30, "classical": -1}
+	assert.Equal(t, 30, config.deepestPly())
+	config.Speeds["rapid"] = 0
+	assert.Equal(t, 0, config.deepestPly())
+}
+
+func TestLoadAccounts(t *testing.T) {
+	path := writeConfig(t, `
+token: main
+challenges:
+  variants: [standard]
+accounts:
+  - token: bullet
+    engine:
+      options:
+        Hash: 32
+    challenges:
+      maxInitial: 120
+  - token: plain
+`)
+	defer os.Remove(path)
+
+	config, err := Load(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.Len(t, config.Accounts, 2) {
+		t.FailNow()
+	}
+	bullet := config.Accounts[0]
+	assert.Equal(t, "32", bullet.Engine.Options["Hash"])
+	assert.Equal(t, 120, bullet.Challenges.MaxInitial)
+	assert.Equal(t, []string{"standard", "fromPosition"}, bullet.Challenges.Variants, "unset settings take the default")
+	assert.Nil(t, config.Accounts[1].Challenges)
+
+	accounts, err := config.AccountOptions()
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 3)
+	assert.Equal(t, []string{"main", "bullet", "plain"}, []string{accounts[0].Token, accounts[1].Token, accounts[2].Token})
+
+	reloads := config.AccountReloads()
+	if assert.Len(t, reloads, 3) {
+		assert.Equal(t, "32", reloads[1].Engine.Options["Hash"])
+		assert.Equal(t, 120, reloads[1].Policy.MaxInitial)
+		assert.Equal(t, []string{"standard"}, reloads[2].Policy.Variants, "accounts without overrides reload the main account's")
+	}
+
+	path = writeConfig(t, `
+accounts:
+  - engine: {}
+`)
+	defer os.Remove(path)
+	_, err = Load(path)
+	assert.Error(t, err, "accounts need a token")
+}
@@ -0,0 +1,164 @@
+// Package crash reports panics and fatal errors to a Sentry-compatible error tracker, so that failures on deployments
+// we can't see into arrive with enough context to act on.
+package crash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// reportTimeout bounds how long reporting a crash can hold up the process, which is usually on its way down.
+const reportTimeout = 5 * time.Second
+
+// Event is a crash to report.
+type Event struct {
+	Message string
+	// Level is "fatal" for anything that brought the process down, or "error".
+	Level string
+	// Tags are short values to search and group crashes by, such as a game ID.
+	Tags map[string]string
+	// Extra is anything else worth knowing, such as the last few lines of a UCI transcript.
+	Extra map[string]interface{}
+	// Stack is the crashing goroutine's stack trace, if there is one.
+	Stack string
+}
+
+// Reporter sends crashes to the project named by a Sentry DSN, e.g. "https://key@sentry.example.com/42".
+type Reporter struct {
+	endpoint string
+	key      string
+	release  string
+	client   *http.Client
+}
+
+// New creates a reporter for a DSN. Every crash is tagged with release, so that it can be matched with the build it
+// came from.
+func New(dsn, release string) (*Reporter, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid crash reporting DSN")
+	}
+	project := strings.Trim(parsed.Path, "/")
+	if parsed.User == nil || parsed.User.Username() == "" || parsed.Host == "" || project == "" {
+		return nil, errors.New("crash reporting DSN must look like https://key@host/project")
+	}
+	return &Reporter{
+		endpoint: fmt.Sprintf("%s://%s/api/%s/store/", parsed.Scheme, parsed.Host, project),
+		key:      parsed.User.Username(),
+		release:  release,
+		client:   &http.Client{Timeout: reportTimeout},
+	}, nil
+}
+
+// Report sends a crash, waiting until it has been received.
+func (r *Reporter) Report(event Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return errors.Wrap(err, "failed to generate event ID")
+	}
+	extra := map[string]interface{}{}
+	for key, value := range event.Extra {
+		extra[key] = value
+	}
+	if event.Stack != "" {
+		extra["stack"] = event.Stack
+	}
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"level":       event.Level,
+		"logger":      "apollod",
+		"platform":    "go",
+		"release":     r.release,
+		"server_name": hostname,
+		"message":     event.Message,
+		"tags":        event.Tags,
+		"extra":       extra,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode crash")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=apollod/%s, sentry_key=%s", r.release, r.key))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send crash report")
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.Errorf("crash report rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Recover reports a panic, if there is one, and then carries on panicking. It must be deferred directly, and describe,
+// if not nil, adds whatever context the panicking goroutine has to the report.
+func (r *Reporter) Recover(describe func(*Event)) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	event := Event{
+		Message: fmt.Sprintf("panic: %v", p),
+		Level:   "fatal",
+		Stack:   string(debug.Stack()),
+	}
+	if describe != nil {
+		describe(&event)
+	}
+	if err := r.Report(event); err != nil {
+		log.WithError(err).Error("failed to report panic")
+	}
+	panic(p)
+}
+
+// Hook returns a logrus hook that reports everything logged at fatal or panic level, along with its fields.
+func (r *Reporter) Hook() log.Hook {
+	return hook{r}
+}
+
+type hook struct {
+	reporter *Reporter
+}
+
+func (h hook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel}
+}
+
+func (h hook) Fire(entry *log.Entry) error {
+	event := Event{
+		Message: entry.Message,
+		Level:   "fatal",
+		Tags:    map[string]string{},
+		Extra:   map[string]interface{}{},
+	}
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			event.Tags[key] = value
+		case error:
+			event.Extra[key] = value.Error()
+		default:
+			event.Extra[key] = value
+		}
+	}
+	return h.reporter.Report(event)
+}
@@ -0,0 +1,92 @@
+package crash
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// tracker is a fake error tracker that keeps every crash reported to it.
+type tracker struct {
+	*httptest.Server
+	auth   []string
+	events []map[string]interface{}
+}
+
+func newTracker(t *testing.T) *tracker {
+	tr := &tracker{}
+	tr.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/42/store/", r.URL.Path)
+		var event map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		tr.auth = append(tr.auth, r.Header.Get("X-Sentry-Auth"))
+		tr.events = append(tr.events, event)
+	}))
+	return tr
+}
+
+func (tr *tracker) dsn() string {
+	return strings.Replace(tr.URL, "http://", "http://secret@", 1) + "/42"
+}
+
+func TestNewRejectsBadDSN(t *testing.T) {
+	for _, dsn := range []string{"", "https://sentry.example.com/42", "https://key@sentry.example.com/", "::"} {
+		_, err := New(dsn, "dev")
+		assert.Error(t, err, dsn)
+	}
+}
+
+func TestRecoverReportsPanic(t *testing.T) {
+	tr := newTracker(t)
+	defer tr.Close()
+	reporter, err := New(tr.dsn(), "1.2.3")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.PanicsWithValue(t, "boom", func() {
+		defer reporter.Recover(func(event *Event) {
+			event.Tags = map[string]string{"game_id": "abc"}
+		})
+		panic("boom")
+	})
+
+	if assert.Len(t, tr.events, 1) {
+		event := tr.events[0]
+		assert.Equal(t, "panic: boom", event["message"])
+		assert.Equal(t, "fatal", event["level"])
+		assert.Equal(t, "1.2.3", event["release"])
+		assert.Equal(t, map[string]interface{}{"game_id": "abc"}, event["tags"])
+		assert.Contains(t, event["extra"].(map[string]interface{})["stack"], "TestRecoverReportsPanic")
+		assert.Contains(t, tr.auth[0], "sentry_key=secret")
+	}
+}
+
+func TestHookReportsFatalEntries(t *testing.T) {
+	tr := newTracker(t)
+	defer tr.Close()
+	reporter, err := New(tr.dsn(), "dev")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	logger := log.New()
+	logger.AddHook(reporter.Hook())
+	logger.WithField("game_id", "abc").Error("not reported")
+	assert.Panics(t, func() {
+		logger.WithField("game_id", "abc").WithError(errors.New("engine died")).Panic("game crashed")
+	})
+
+	if assert.Len(t, tr.events, 1) {
+		event := tr.events[0]
+		assert.Equal(t, "game crashed", event["message"])
+		assert.Equal(t, map[string]interface{}{"game_id": "abc"}, event["tags"])
+		assert.Equal(t, map[string]interface{}{"error": "engine died"}, event["extra"])
+	}
+}
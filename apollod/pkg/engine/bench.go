@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BenchPositions are the positions that Bench searches, as FENs: the opening, a crowded middlegame, and a few
+// endgames.
+var BenchPositions = []string{
+	"",
+	"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+	"r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10",
+	"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+	"8/8/8/4k3/8/8/4P3/4K3 w - - 0 1",
+	"6k1/5ppp/8/8/8/8/5PPP/3R2K1 w - - 0 1",
+}
+
+// BenchPosition is how an engine searched one of the bench positions.
+type BenchPosition struct {
+	// FEN is the position, or empty for the standard starting position.
+	FEN string
+	// Depth and Nodes are what the engine reported at the end of its search.
+	Depth int
+	Nodes int64
+	// Time is how long the search took, as measured by apollod.
+	Time time.Duration
+}
+
+// BenchResult is how an engine searched every bench position.
+type BenchResult struct {
+	Positions []BenchPosition
+	Nodes     int64
+	Time      time.Duration
+}
+
+// NPS returns the engine's speed over every position, in nodes per second.
+func (r BenchResult) NPS() int64 {
+	return NodesPerSecond(r.Nodes, r.Time)
+}
+
+// NodesPerSecond returns the speed of a search that searched nodes in elapsed.
+func NodesPerSecond(nodes int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(nodes) / elapsed.Seconds())
+}
+
+// Bench measures an engine's raw speed by searching every bench position within limits, each as a new game. Searches
+// to a fixed depth do the same work on every run, which makes them best for comparing two builds of an engine;
+// searches for a fixed time are better for comparing different engines. Engines that don't report the nodes they
+// searched are measured as searching none.
+func Bench(client Engine, limits Limits) (BenchResult, error) {
+	var result BenchResult
+	for _, fen := range BenchPositions {
+		if err := client.NewGame(false); err != nil {
+			return result, errors.Wrap(err, "failed to reset engine")
+		}
+		if err := client.SetPosition(fen, nil); err != nil {
+			return result, errors.Wrap(err, "failed to set engine position")
+		}
+		start := time.Now()
+		if _, err := client.Search(limits); err != nil {
+			return result, errors.Wrap(err, "engine failed to search")
+		}
+		elapsed := time.Since(start)
+
+		info := client.Info()
+		result.Positions = append(result.Positions, BenchPosition{FEN: fen, Depth: info.Depth, Nodes: info.Nodes, Time: elapsed})
+		result.Nodes += info.Nodes
+		result.Time += elapsed
+	}
+	return result, nil
+}
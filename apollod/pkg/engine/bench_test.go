@@ -0,0 +1,31 @@
+package engine_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+func TestBench(t *testing.T) {
+	fake := &ucitest.Engine{Delay: time.Millisecond}
+	client, err := uci.NewClient(fake)
+	require.NoError(t, err)
+	defer client.Close()
+
+	result, err := engine.Bench(client, engine.Limits{Depth: 4})
+	require.NoError(t, err)
+	if assert.Len(t, result.Positions, len(engine.BenchPositions)) {
+		assert.Equal(t, engine.BenchPositions[1], result.Positions[1].FEN)
+		assert.Equal(t, int64(1), result.Positions[1].Nodes)
+	}
+	assert.Equal(t, int64(len(engine.BenchPositions)), result.Nodes)
+	assert.True(t, result.Time >= time.Duration(len(engine.BenchPositions))*time.Millisecond)
+	assert.True(t, result.NPS() > 0)
+	assert.Contains(t, fake.Commands(), "go depth 4")
+}
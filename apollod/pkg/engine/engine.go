@@ -0,0 +1,261 @@
+// Package engine defines what apollod needs from a chess engine, independently of the protocol used to talk to it,
+// so that the server and selfplay can play with any engine that some backend knows how to drive. Backends, such as
+// uci and cecp, register themselves by protocol name; import them for their side effects to make them available.
+package engine
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultProtocol is the protocol spoken by engines that don't say otherwise.
+const DefaultProtocol = "uci"
+
+// Engine is a running chess engine. Search and the methods that read its results must not be called concurrently
+// with each other, but Stop, Kill and Close may be called while a search is in progress.
+type Engine interface {
+	// Name is the name the engine identified itself by, if it did.
+	Name() string
+	// Author is the engine's author, if it said.
+	Author() string
+	// Options returns the options that the engine declared.
+	Options() []Option
+	// HasOption returns true if the engine declared an option with the given name. Option names are
+	// case-insensitive.
+	HasOption(name string) bool
+	// SetOption sets one of the engine's options. Options without a value, such as buttons, are set with an empty
+	// value.
+	SetOption(name, value string) error
+	// IsReady waits until the engine has caught up with everything it has been sent.
+	IsReady() error
+	// NewGame readies the engine for a new game, in chess960 mode if chess960 is set.
+	NewGame(chess960 bool) error
+	// SetPosition sets the position to search, given as the FEN of the starting position (or empty for the standard
+	// starting position) and the moves played from there, in UCI notation.
+	SetPosition(fen string, moves []string) error
+	// Search searches the current position within limits and returns the engine's move, in UCI notation. In a
+	// position with no legal moves, it returns ErrNoMove.
+	Search(limits Limits) (string, error)
+	// Go searches like Search, and returns the reply the engine expects to its move and its final report on the search
+	// along with the move.
+	Go(limits Limits) (SearchResult, error)
+	// Stop tells the engine to finish the search in progress and move as soon as it can.
+	Stop() error
+	// Info returns everything that the engine reported about its search during the most recent call to Search.
+	Info() Info
+	// OnInfo registers a function to call whenever the engine reports something new about a search in progress. It
+	// is called with everything the engine has reported about the search so far, on the searching goroutine.
+	OnInfo(func(Info))
+	// Quit asks the engine to exit.
+	Quit() error
+	// Close releases the engine, waiting for it to exit.
+	Close() error
+	// Kill forcibly terminates an engine that isn't responding to commands.
+	Kill() error
+}
+
+// SearchResult is what an engine said at the end of a search.
+type SearchResult struct {
+	// Move is the engine's move, in UCI notation.
+	Move string
+	// Ponder is the reply that the engine expects to Move, if it said.
+	Ponder string
+	// Info is everything the engine reported about the search, including its final score and depth.
+	Info Info
+}
+
+// ErrNoMove is returned by searches of positions that the engine has no move in, because the game is over. Errors that
+// wrap it are told apart with IsNoMove.
+var ErrNoMove = errors.New("engine has no move in this position")
+
+// IsNoMove returns true if err is, or was caused by, the engine having no move to play.
+func IsNoMove(err error) bool {
+	return err != nil && errors.Cause(err) == ErrNoMove
+}
+
+// Limits bounds a search. If Infinite is set, the engine searches until it's stopped. Otherwise, if Depth or Nodes is
+// set, the engine searches until it reaches either, however long it takes; otherwise, if MoveTime is set, the engine
+// searches for exactly that long. In each of these cases, the clocks are left out.
+type Limits struct {
+	WhiteTime, BlackTime           time.Duration
+	WhiteIncrement, BlackIncrement time.Duration
+	// MovesToGo, if nonzero, is the number of moves until the clocks are next reset.
+	MovesToGo int
+	MoveTime  time.Duration
+	Depth     int
+	Nodes     int64
+	Infinite  bool
+	// SearchMoves, if set, restricts the search to these moves, in UCI notation.
+	SearchMoves []string
+}
+
+// Score is an engine's evaluation of a position, from the perspective of the side to move.
+type Score struct {
+	// Centipawns is the evaluation in hundredths of a pawn. It is only meaningful if Mate is zero.
+	Centipawns int
+	// Mate, if nonzero, is the number of moves until mate. Negative values mean that the side to move is being mated.
+	Mate int
+}
+
+// IsMate returns true if this score is a forced mate.
+func (s Score) IsMate() bool { return s.Mate != 0 }
+
+// Info is what an engine has told us about its search so far. Fields that the engine never reported are left at
+// their zero values.
+type Info struct {
+	Depth    int
+	SelDepth int
+	Nodes    int64
+	NPS      int64
+	// Time is how long the engine has been searching, in milliseconds.
+	Time     int
+	HashFull int
+	TBHits   int64
+	Score    Score
+	HasScore bool
+	PV       []string
+}
+
+// Protocol starts an engine that speaks a particular protocol over transport.
+type Protocol func(transport Transport) (Engine, error)
+
+// Connector connects to an engine that isn't a local program, such as one running on another host. Its address takes
+// the place of the program's path.
+type Connector func(address string, transcript io.Writer) (Engine, error)
+
+var (
+	protocolsLock sync.Mutex
+	protocols     = make(map[string]Protocol)
+	connectors    = make(map[string]Connector)
+)
+
+// Register makes a protocol available by name. It panics if the name is already registered.
+func Register(name string, protocol Protocol) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	checkUnregistered(name)
+	protocols[name] = protocol
+}
+
+// RegisterConnector makes a protocol for engines that aren't local programs available by name. It panics if the name
+// is already registered.
+func RegisterConnector(name string, connector Connector) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	checkUnregistered(name)
+	connectors[name] = connector
+}
+
+func checkUnregistered(name string) {
+	_, isProtocol := protocols[name]
+	_, isConnector := connectors[name]
+	if isProtocol || isConnector {
+		panic("engine: protocol " + name + " registered twice")
+	}
+}
+
+// Protocols returns the names of the registered protocols, sorted.
+func Protocols() []string {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	var names []string
+	for name := range protocols {
+		names = append(names, name)
+	}
+	for name := range connectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New starts an engine speaking the named protocol over transport. An empty protocol means DefaultProtocol.
+func New(protocol string, transport Transport) (Engine, error) {
+	start, err := lookup(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return start(transport)
+}
+
+// Launch runs the engine program at path with the given options and starts talking to it with the named protocol, or
+// for protocols with a connector, connects to the engine at the address given by path. Engines reached through a
+// connector aren't run by apollod, so the options don't apply to them; they run under whatever caps their own host
+// sets. Everything said to and by the engine is written to transcript, if it isn't nil.
+func Launch(path, protocol string, transcript io.Writer, options ProgramOptions) (Engine, error) {
+	protocolsLock.Lock()
+	connect, ok := connectors[protocol]
+	protocolsLock.Unlock()
+	if ok {
+		return connect(path, transcript)
+	}
+
+	// Check the protocol first, so that a typo doesn't leave a process behind waiting for commands.
+	start, err := lookup(protocol)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := NewProgramTransport(path, options)
+	if err != nil {
+		return nil, err
+	}
+	if transcript != nil {
+		transport = NewTranscriptTransport(transport, transcript)
+	}
+	return start(transport)
+}
+
+// SkippedOption is an option that Configure didn't set, and why.
+type SkippedOption struct {
+	Name   string
+	Reason error
+}
+
+// Configure sets options on an engine that has just been started, in order of name so that transcripts are
+// reproducible, and then waits for the engine to be ready, so that the options are in effect before anything else is
+// asked of it. Engines that declare their options complain about anything else, so they are only sent the ones they
+// declared, with values that those take; the rest are skipped and returned. Engines that declare nothing are sent
+// everything.
+func Configure(client Engine, options map[string]string) ([]SkippedOption, error) {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var skipped []SkippedOption
+	for _, name := range names {
+		declared, ok := LookupOption(client.Options(), name)
+		if len(client.Options()) > 0 && !ok {
+			skipped = append(skipped, SkippedOption{Name: name, Reason: errors.New("engine does not declare it")})
+			continue
+		}
+		if ok {
+			if err := declared.Validate(options[name]); err != nil {
+				skipped = append(skipped, SkippedOption{Name: name, Reason: err})
+				continue
+			}
+		}
+		if err := client.SetOption(name, options[name]); err != nil {
+			return skipped, errors.Wrapf(err, "failed to set option %s", name)
+		}
+	}
+	return skipped, client.IsReady()
+}
+
+func lookup(protocol string) (Protocol, error) {
+	if protocol == "" {
+		protocol = DefaultProtocol
+	}
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	start, ok := protocols[protocol]
+	if !ok {
+		return nil, errors.Errorf("unknown engine protocol %q", protocol)
+	}
+	return start, nil
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// castlingRegex matches standard castling rights, and the files of the rooks that can castle in Shredder-FEN,
+	// which chess960 positions use.
+	castlingRegex  = regexp.MustCompile(`^(-|[KQkqA-Ha-h]+)$`)
+	enPassantRegex = regexp.MustCompile(`^(-|[a-h][36])$`)
+	// checksRegex matches the checks given so far in three-check positions, such as "+1+0", or those left, such as
+	// "2+3".
+	checksRegex = regexp.MustCompile(`^\+?\d\+\d$`)
+)
+
+// ValidateFEN checks that a FEN describes a position well enough to send to an engine: a board of eight ranks of eight
+// squares, the side to move, castling rights, and the en passant square, optionally followed by the move clocks.
+// Positions from variants are accepted too, so a crazyhouse pocket may follow the board, in brackets, and a
+// three-check count may come before the clocks; what's on the board isn't checked against any variant's rules.
+func ValidateFEN(fen string) error {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 || len(fields) > 7 {
+		return errors.Errorf("invalid FEN %q: expected between 4 and 7 fields, found %d", fen, len(fields))
+	}
+	if err := validateBoard(fields[0]); err != nil {
+		return errors.Wrapf(err, "invalid FEN %q", fen)
+	}
+	if fields[1] != "w" && fields[1] != "b" {
+		return errors.Errorf("invalid FEN %q: side to move must be w or b, not %q", fen, fields[1])
+	}
+	if !castlingRegex.MatchString(fields[2]) {
+		return errors.Errorf("invalid FEN %q: malformed castling rights %q", fen, fields[2])
+	}
+	if !enPassantRegex.MatchString(fields[3]) {
+		return errors.Errorf("invalid FEN %q: malformed en passant square %q", fen, fields[3])
+	}
+	rest := fields[4:]
+	if len(rest) > 0 && checksRegex.MatchString(rest[0]) {
+		rest = rest[1:]
+	}
+	if len(rest) > 2 {
+		return errors.Errorf("invalid FEN %q: unexpected %q after the move clocks", fen, strings.Join(rest[2:], " "))
+	}
+	for _, clock := range rest {
+		if n, err := strconv.Atoi(clock); err != nil || n < 0 {
+			return errors.Errorf("invalid FEN %q: move clock %q is not a number", fen, clock)
+		}
+	}
+	return nil
+}
+
+// validateBoard checks the board part of a FEN.
+func validateBoard(board string) error {
+	if i := strings.IndexByte(board, '['); i >= 0 {
+		pocket := board[i:]
+		if !strings.HasSuffix(pocket, "]") || strings.Trim(pocket[1:len(pocket)-1], "pnbrqPNBRQ") != "" {
+			return errors.Errorf("malformed pocket %q", pocket)
+		}
+		board = board[:i]
+	}
+	ranks := strings.Split(board, "/")
+	if len(ranks) != 8 {
+		return errors.Errorf("board has %d ranks, not 8", len(ranks))
+	}
+	for i, rank := range ranks {
+		squares := 0
+		for j, c := range rank {
+			switch {
+			case c >= '1' && c <= '8':
+				squares += int(c - '0')
+			case strings.ContainsRune("pnbrqkPNBRQK", c):
+				squares++
+			case c == '~' && j > 0:
+				// Crazyhouse marks pieces that were promoted, which are captured as pawns.
+			default:
+				return errors.Errorf("rank %d has unexpected %q", 8-i, c)
+			}
+		}
+		if squares != 8 {
+			return errors.Errorf("rank %d has %d squares, not 8", 8-i, squares)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+)
+
+// MoveChecker judges whether an engine's best move can be played after moves from fen, which is empty for the
+// standard starting position. It returns an error saying why not, or nil if the move can be played.
+type MoveChecker func(fen string, moves []string, move string) error
+
+// IllegalMove is returned by engines whose best move failed their MoveChecker, so that a move that can't be played
+// never goes any further, along with everything needed to work out why the engine chose it.
+type IllegalMove struct {
+	// Position is the FEN the engine was searching from, or empty for the standard starting position.
+	Position string
+	// Moves are the moves played from Position.
+	Moves []string
+	// Move is the engine's best move.
+	Move string
+	// Output is the line the engine sent its best move in, as it sent it.
+	Output string
+	// Reason is why the move can't be played.
+	Reason error
+}
+
+func (e *IllegalMove) Error() string {
+	position := e.Position
+	if position == "" {
+		position = "startpos"
+	}
+	if len(e.Moves) > 0 {
+		position += " moves " + strings.Join(e.Moves, " ")
+	}
+	return fmt.Sprintf("engine played illegal move %s in position %s (%q): %s", e.Move, position, e.Output, e.Reason)
+}
+
+// AsIllegalMove returns the IllegalMove that err is, or was caused by, if it is one.
+func AsIllegalMove(err error) (*IllegalMove, bool) {
+	illegal, ok := errors.Cause(err).(*IllegalMove)
+	return illegal, ok
+}
+
+// LegalMove is a MoveChecker for standard chess. It doesn't know chess960 castling or the rules of any variant, so it
+// should only check engines playing standard games. Positions that it can't replay aren't judged, since any move in them
+// might be legal.
+func LegalMove(fen string, moves []string, move string) error {
+	options := []func(*chess.Game){chess.UseNotation(chess.LongAlgebraicNotation{})}
+	if fen != "" {
+		position, err := chess.FEN(fen)
+		if err != nil {
+			return nil
+		}
+		options = append(options, position)
+	}
+	game := chess.NewGame(options...)
+	for _, played := range moves {
+		if err := game.MoveStr(played); err != nil {
+			return nil
+		}
+	}
+	for _, valid := range game.ValidMoves() {
+		if valid.String() == move {
+			return nil
+		}
+	}
+	return errors.Errorf("%s is not a legal move", move)
+}
@@ -0,0 +1,18 @@
+package engine
+
+import (
+	"github.com/swgillespie/apollo/apollod/pkg/metrics"
+)
+
+var (
+	engineMetrics = metrics.NewSubsystem("engine")
+
+	poolGets = engineMetrics.NewCounter(
+		"pool_gets_total",
+		"Number of engines handed out by engine pools, by whether they were reused or launched.",
+		"source")
+	poolRecycles = engineMetrics.NewCounter(
+		"pool_recycles_total",
+		"Number of engines that pools stopped keeping, by whether they were retired after working or discarded.",
+		"reason")
+)
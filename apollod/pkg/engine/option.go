@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// The types of option that an engine can declare, as UCI names them. Backends for other protocols translate their own
+// types into these.
+const (
+	OptionCheck  = "check"
+	OptionSpin   = "spin"
+	OptionCombo  = "combo"
+	OptionButton = "button"
+	OptionString = "string"
+)
+
+// Option is an option that an engine declared, and what values it takes.
+type Option struct {
+	Name string `json:"name"`
+	// Type is one of the Option types above. Options of types that a backend doesn't recognize are treated as strings.
+	Type    string `json:"type"`
+	Default string `json:"default,omitempty"`
+	// Min and Max bound the values of spin options. Engines that don't declare a Max leave it at zero, which bounds
+	// nothing; see HasMax.
+	Min int `json:"min,omitempty"`
+	Max int `json:"max,omitempty"`
+	// Vars are the values that a combo option may take.
+	Vars []string `json:"vars,omitempty"`
+}
+
+// Validate checks that value is something the option can be set to. Buttons are pressed by setting them to nothing.
+func (o Option) Validate(value string) error {
+	switch o.Type {
+	case OptionCheck:
+		if value != "true" && value != "false" {
+			return errors.Errorf("option %s must be true or false, not %q", o.Name, value)
+		}
+	case OptionSpin:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Errorf("option %s must be a number, not %q", o.Name, value)
+		}
+		if !o.HasMax() && n < o.Min {
+			return errors.Errorf("option %s must be at least %d, not %d", o.Name, o.Min, n)
+		}
+		if o.HasMax() && (n < o.Min || n > o.Max) {
+			return errors.Errorf("option %s must be between %d and %d, not %d", o.Name, o.Min, o.Max, n)
+		}
+	case OptionCombo:
+		for _, v := range o.Vars {
+			if strings.EqualFold(v, value) {
+				return nil
+			}
+		}
+		return errors.Errorf("option %s must be one of %s, not %q", o.Name, strings.Join(o.Vars, ", "), value)
+	case OptionButton:
+		if value != "" {
+			return errors.Errorf("option %s is a button and takes no value", o.Name)
+		}
+	}
+	return nil
+}
+
+// HasMax returns true if the option declared a Max above its Min. Engines that don't declare one take any value from
+// Min up.
+func (o Option) HasMax() bool {
+	return o.Max > o.Min
+}
+
+// LookupOption finds the option with the given name among options. Option names are case-insensitive.
+func LookupOption(options []Option, name string) (Option, bool) {
+	for _, option := range options {
+		if strings.EqualFold(option.Name, name) {
+			return option, true
+		}
+	}
+	return Option{}, false
+}
+
+// LoadOptions reads engine options from a file that maps option names to values, in YAML or JSON, such as
+// {"Hash": 256, "SyzygyPath": "/tablebases"}. Values are sent to the engine as they're written, and options without a
+// value, such as buttons, are null.
+func LoadOptions(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read engine options file")
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse engine options file %s", path)
+	}
+	options := make(map[string]string, len(values))
+	for name, value := range values {
+		switch value := value.(type) {
+		case nil:
+			options[name] = ""
+		case map[interface{}]interface{}, []interface{}:
+			return nil, errors.Errorf("engine option %s in %s must be a single value", name, path)
+		default:
+			options[name] = fmt.Sprint(value)
+		}
+	}
+	return options, nil
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Pool keeps engines running between games, so that each game doesn't pay for starting an engine program and its
+// handshake. Engines are launched as needed and handed back after each game; an engine that has played MaxGames
+// games, or whose game went wrong, is shut down instead of being kept. Pools may be used from many goroutines at once.
+type Pool struct {
+	launch func() (Engine, error)
+	// MaxGames is the number of games that an engine plays before it's restarted, in case it doesn't clean up after
+	// itself between games. If zero, engines are kept for as long as they work.
+	MaxGames int
+
+	lock   sync.Mutex
+	idle   []Engine
+	games  map[Engine]int
+	closed bool
+}
+
+// NewPool creates a pool of engines started by launch, which also sets any options that they should keep for every
+// game.
+func NewPool(launch func() (Engine, error), maxGames int) *Pool {
+	return &Pool{
+		launch:   launch,
+		MaxGames: maxGames,
+		games:    make(map[Engine]int),
+	}
+}
+
+// Get returns an engine readied for a new game, reusing an idle one if there is any and launching one otherwise. Idle
+// engines that don't answer are shut down and the next one is tried. Options set during a game carry over to the
+// engine's next game, except for chess960 mode, which NewGame switches on and off.
+func (p *Pool) Get(chess960 bool) (Engine, error) {
+	for {
+		p.lock.Lock()
+		if len(p.idle) == 0 {
+			p.lock.Unlock()
+			break
+		}
+		client := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.lock.Unlock()
+
+		if err := newGame(client, chess960); err != nil {
+			log.WithError(err).WithField("engine", client.Name()).Warn("pooled engine failed to start a new game, discarding it")
+			p.discard(client)
+			continue
+		}
+		poolGets.Inc("reused")
+		return client, nil
+	}
+
+	client, err := p.launch()
+	if err != nil {
+		return nil, err
+	}
+	if err := newGame(client, chess960); err != nil {
+		client.Kill()
+		client.Close()
+		return nil, err
+	}
+	poolGets.Inc("launched")
+	return client, nil
+}
+
+// newGame readies an engine for a new game and waits for it to catch up.
+func newGame(client Engine, chess960 bool) error {
+	if err := client.NewGame(chess960); err != nil {
+		return err
+	}
+	return client.IsReady()
+}
+
+// Put hands an engine back to the pool once its game is over. Engines are kept for the next game only if the game
+// went well, which the caller says with ok, and they haven't yet played MaxGames games; the rest are shut down. Put
+// reports whether the engine was kept.
+func (p *Pool) Put(client Engine, ok bool) bool {
+	p.lock.Lock()
+	p.games[client]++
+	recycle := !ok || p.closed || (p.MaxGames > 0 && p.games[client] >= p.MaxGames)
+	if !recycle {
+		p.idle = append(p.idle, client)
+	}
+	p.lock.Unlock()
+
+	if !recycle {
+		return true
+	}
+	if ok {
+		p.shutdown(client)
+	} else {
+		p.discard(client)
+	}
+	return false
+}
+
+// Forget drops an engine that won't be handed back, because the caller has already killed it.
+func (p *Pool) Forget(client Engine) {
+	p.forget(client)
+}
+
+// Close shuts down every idle engine. Engines handed back afterwards are shut down too.
+func (p *Pool) Close() {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.lock.Unlock()
+
+	for _, client := range idle {
+		p.shutdown(client)
+	}
+}
+
+// shutdown asks an engine that's working to exit and waits for it to.
+func (p *Pool) shutdown(client Engine) {
+	p.forget(client)
+	poolRecycles.Inc("retired")
+	if err := client.Quit(); err != nil {
+		log.WithError(err).Warn("failed to send quit to pooled engine")
+	}
+	if err := client.Close(); err != nil {
+		log.WithError(err).Warn("pooled engine did not exit cleanly")
+	}
+}
+
+// discard kills an engine that might not be working, since it might not listen to being asked to exit.
+func (p *Pool) discard(client Engine) {
+	p.forget(client)
+	poolRecycles.Inc("discarded")
+	if err := client.Kill(); err != nil {
+		log.WithError(err).Warn("failed to kill pooled engine")
+	}
+	client.Close()
+}
+
+func (p *Pool) forget(client Engine) {
+	p.lock.Lock()
+	delete(p.games, client)
+	p.lock.Unlock()
+}
@@ -0,0 +1,50 @@
+package engine_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+func TestPoolReusesEngines(t *testing.T) {
+	var launched []*ucitest.Engine
+	pool := engine.NewPool(func() (engine.Engine, error) {
+		fake := &ucitest.Engine{}
+		launched = append(launched, fake)
+		return uci.NewClient(fake)
+	}, 2)
+
+	first, err := pool.Get(false)
+	require.NoError(t, err)
+	pool.Put(first, true)
+	second, err := pool.Get(false)
+	require.NoError(t, err)
+	assert.True(t, first == second)
+	assert.Len(t, launched, 1)
+	assert.Equal(t, []string{"uci", "ucinewgame", "isready", "ucinewgame", "isready"}, launched[0].Commands())
+
+	// The engine has played its two games, so it's retired and the next game gets a new one.
+	pool.Put(second, true)
+	assert.Equal(t, "quit", last(launched[0].Commands()))
+	third, err := pool.Get(false)
+	require.NoError(t, err)
+	assert.Len(t, launched, 2)
+
+	// Engines whose game went wrong aren't kept.
+	pool.Put(third, false)
+	fourth, err := pool.Get(false)
+	require.NoError(t, err)
+	assert.Len(t, launched, 3)
+	pool.Put(fourth, true)
+	pool.Close()
+	assert.Equal(t, "quit", last(launched[2].Commands()))
+}
+
+func last(commands []string) string {
+	return commands[len(commands)-1]
+}
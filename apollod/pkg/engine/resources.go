@@ -0,0 +1,22 @@
+package engine
+
+import "time"
+
+// Resources caps what an engine program may use, so that a runaway engine, or too many of them at once, can't take
+// the machine down with it. Caps are in force before the engine program runs any of its own code, and an engine that
+// exceeds one fails or is killed by the operating system. The zero value caps nothing.
+type Resources struct {
+	// MemoryMB is the most address space the engine may map, in megabytes. Engines map more than they touch, so
+	// this needs room above the engine's hash size.
+	MemoryMB int `yaml:"memoryMB"`
+	// CPUTime is the most processor time the engine may use over its life.
+	CPUTime time.Duration `yaml:"cpuTime"`
+	// Timeouts bound how long the engine may take to answer, after which clients get ErrTimeout rather than waiting
+	// forever on an engine that has hung. Unlike the other caps, they're enforced by apollod, on any platform.
+	Timeouts Timeouts `yaml:"timeouts"`
+}
+
+// IsZero returns true if no caps are set that the operating system enforces.
+func (r Resources) IsZero() bool {
+	return r.MemoryMB == 0 && r.CPUTime == 0
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// command returns the command that runs a program with the caps applied before it starts, so that it can't, for
+// example, allocate its hash table before the memory cap takes hold. A shell sets the caps and then execs the program
+// in its place, so the program keeps the shell's process ID; if a cap can't be set, the shell exits without running
+// the program at all.
+func (r Resources) command(program string, args []string) (*exec.Cmd, error) {
+	if r.IsZero() {
+		return exec.Command(program, args...), nil
+	}
+	var script []string
+	if r.MemoryMB > 0 {
+		// The shell's limit is in kilobytes.
+		script = append(script, fmt.Sprintf("ulimit -v %d", r.MemoryMB<<10))
+	}
+	if r.CPUTime > 0 {
+		// The limit is in whole seconds, and a limit of zero would kill the engine straight away.
+		seconds := (r.CPUTime + time.Second - 1) / time.Second
+		script = append(script, fmt.Sprintf("ulimit -t %d", seconds))
+	}
+	script = append(script, `exec "$0" "$@"`)
+	return exec.Command("/bin/sh", append([]string{"-c", strings.Join(script, " && "), program}, args...)...), nil
+}
+
+// totalMemoryMB returns the machine's physical memory, in megabytes, or zero if it can't be found.
+func totalMemoryMB() int {
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return 0
+	}
+	return int(uint64(info.Totalram) * uint64(info.Unit) >> 20)
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitedProgramTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	// The program reports its limits, in kilobytes and seconds, as soon as it starts, and then its arguments and working
+	// directory, so the caps must already be in force, without getting in the way of anything else it's run with.
+	program := filepath.Join(dir, "engine")
+	script := "#!/bin/sh\nulimit -v\nulimit -t\necho \"$@\"\npwd\nread line\n"
+	if !assert.NoError(t, ioutil.WriteFile(program, []byte(script), 0755)) {
+		t.FailNow()
+	}
+
+	transport, err := NewProgramTransport(program, ProgramOptions{
+		Args:      []string{"--weights", "net pb"},
+		Dir:       dir,
+		Resources: Resources{MemoryMB: 512, CPUTime: 1500 * time.Millisecond},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer transport.Close()
+	var lines []string
+	for i := 0; i < 4; i++ {
+		line, err := transport.Recv()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{"524288", "2", "--weights net pb", dir}, lines)
+	assert.NoError(t, transport.Send("quit"))
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package engine
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// command returns the command that runs a program with the caps applied, which is only supported on Linux.
+func (r Resources) command(program string, args []string) (*exec.Cmd, error) {
+	if !r.IsZero() {
+		return nil, errors.New("engine resource limits are only supported on Linux")
+	}
+	return exec.Command(program, args...), nil
+}
+
+// totalMemoryMB returns the machine's physical memory, which is only found on Linux.
+func totalMemoryMB() int {
+	return 0
+}
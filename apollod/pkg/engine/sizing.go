@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// hashShare is the share of the machine's memory that AutoSize gives to hash tables, leaving the rest for the
+// engines' other needs, apollod, and everything else on the machine.
+const hashShare = 0.5
+
+// Host is what a machine has to share among the engines running on it.
+type Host struct {
+	// MemoryMB is the machine's physical memory, in megabytes, or zero if it isn't known.
+	MemoryMB int
+	// CPUs is the number of logical CPUs that apollod may run on.
+	CPUs int
+}
+
+// DetectHost inspects the machine apollod is running on. Its memory is only found on Linux.
+func DetectHost() Host {
+	return Host{MemoryMB: totalMemoryMB(), CPUs: runtime.NumCPU()}
+}
+
+// Size returns the Hash and Threads that each of engines running at once on the host gets: an equal share of half the
+// host's memory for its hash table, rounded down to a power of two since some engines use no more than that of any
+// other size, and an equal share of its CPUs, with at least one megabyte and one thread each. Hash is left out if the
+// host's memory isn't known.
+func (h Host) Size(engines int) map[string]string {
+	if engines < 1 {
+		engines = 1
+	}
+	threads := h.CPUs / engines
+	if threads < 1 {
+		threads = 1
+	}
+	options := map[string]string{"Threads": strconv.Itoa(threads)}
+	if h.MemoryMB > 0 {
+		hash := 1
+		for share := int(float64(h.MemoryMB) * hashShare / float64(engines)); hash*2 <= share; {
+			hash *= 2
+		}
+		options["Hash"] = strconv.Itoa(hash)
+	}
+	return options
+}
+
+// AutoSize adds the Hash and Threads that Size gives one of engines running at once on host to options, for an engine
+// that has been started. Options that are already set are left alone, so that explicit settings win, as are options
+// that the engine doesn't declare, if it declares any. Values are kept within the bounds the engine declares. options
+// itself isn't changed.
+func AutoSize(client Engine, options map[string]string, host Host, engines int) map[string]string {
+	sized := make(map[string]string, len(options)+2)
+	for name, value := range options {
+		sized[name] = value
+	}
+	for name, value := range host.Size(engines) {
+		if _, ok := LookupValue(options, name); ok {
+			continue
+		}
+		declared, ok := LookupOption(client.Options(), name)
+		if len(client.Options()) > 0 && !ok {
+			continue
+		}
+		if ok && declared.Type == OptionSpin {
+			n, _ := strconv.Atoi(value)
+			if n < declared.Min {
+				n = declared.Min
+			}
+			if declared.HasMax() && n > declared.Max {
+				n = declared.Max
+			}
+			value = strconv.Itoa(n)
+		}
+		sized[name] = value
+	}
+	return sized
+}
+
+// LookupValue finds the value of the option with the given name in options, which map option names to values. Option
+// names are case-insensitive.
+func LookupValue(options map[string]string, name string) (string, bool) {
+	for option, value := range options {
+		if strings.EqualFold(option, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// declaredEngine is an engine that only declares options.
+type declaredEngine struct {
+	Engine
+	options []Option
+}
+
+func (e declaredEngine) Options() []Option { return e.options }
+
+func TestSize(t *testing.T) {
+	host := Host{MemoryMB: 16384, CPUs: 8}
+	assert.Equal(t, map[string]string{"Hash": "2048", "Threads": "2"}, host.Size(4))
+	assert.Equal(t, map[string]string{"Hash": "512", "Threads": "1"}, host.Size(10), "hash rounds down to a power of two")
+	assert.Equal(t, map[string]string{"Threads": "8"}, Host{CPUs: 8}.Size(0))
+}
+
+func TestAutoSize(t *testing.T) {
+	host := Host{MemoryMB: 65536, CPUs: 32}
+	client := declaredEngine{options: []Option{
+		{Name: "hash", Type: OptionSpin, Min: 1, Max: 1024},
+		{Name: "Threads", Type: OptionSpin, Min: 1, Max: 512},
+		{Name: "SyzygyPath", Type: OptionString},
+	}}
+	options := map[string]string{"threads": "3", "SyzygyPath": "/tb"}
+	assert.Equal(t, map[string]string{"threads": "3", "SyzygyPath": "/tb", "Hash": "1024"}, AutoSize(client, options, host, 2),
+		"options that are set win, and hash stays within the engine's bounds")
+	assert.Len(t, options, 2)
+
+	client = declaredEngine{options: []Option{{Name: "Hash", Type: OptionSpin, Min: 1, Max: 65536}}}
+	assert.Equal(t, map[string]string{"Hash": "16384"}, AutoSize(client, nil, host, 2), "undeclared options are left out")
+	client = declaredEngine{options: []Option{{Name: "Hash", Type: OptionSpin, Min: 1}, {Name: "Threads", Type: OptionSpin}}}
+	assert.Equal(t, map[string]string{"Hash": "16384", "Threads": "16"}, AutoSize(client, nil, host, 2),
+		"options without a max aren't clamped to zero")
+	assert.Equal(t, map[string]string{"Hash": "32768", "Threads": "32"}, AutoSize(declaredEngine{}, nil, host, 1),
+		"engines that declare nothing get everything")
+}
@@ -0,0 +1,59 @@
+package engine
+
+// SearchStats sums up what an engine reported at the end of a run of searches, such as the searches of a game, so that
+// how deep and how fast it searched can be told apart from how well it played. Fields that the engine never reported
+// stay at zero.
+type SearchStats struct {
+	// Searches is the number of searches added.
+	Searches int
+	// Depth, Nodes, NPS, HashFull, and TBHits are the sums of what the engine reported for each search.
+	Depth    int
+	Nodes    int64
+	NPS      int64
+	HashFull int
+	TBHits   int64
+}
+
+// Add adds what an engine reported about a search.
+func (s *SearchStats) Add(info Info) {
+	s.Searches++
+	s.Depth += info.Depth
+	s.Nodes += info.Nodes
+	s.NPS += info.NPS
+	s.HashFull += info.HashFull
+	s.TBHits += info.TBHits
+}
+
+// Merge adds every search in other.
+func (s *SearchStats) Merge(other SearchStats) {
+	s.Searches += other.Searches
+	s.Depth += other.Depth
+	s.Nodes += other.Nodes
+	s.NPS += other.NPS
+	s.HashFull += other.HashFull
+	s.TBHits += other.TBHits
+}
+
+// AverageDepth returns the depth that searches reached on average.
+func (s SearchStats) AverageDepth() float64 {
+	if s.Searches == 0 {
+		return 0
+	}
+	return float64(s.Depth) / float64(s.Searches)
+}
+
+// AverageNPS returns the speed that searches ran at on average, in nodes per second.
+func (s SearchStats) AverageNPS() int64 {
+	if s.Searches == 0 {
+		return 0
+	}
+	return s.NPS / int64(s.Searches)
+}
+
+// AverageHashFull returns how full the hash table was at the end of searches on average, in permille.
+func (s SearchStats) AverageHashFull() int {
+	if s.Searches == 0 {
+		return 0
+	}
+	return s.HashFull / s.Searches
+}
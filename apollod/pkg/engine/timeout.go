@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTimeout is returned by transports whose engine didn't answer within the timeout for what it was asked. Errors that
+// wrap it are told apart with IsTimeout.
+var ErrTimeout = errors.New("engine did not answer in time")
+
+// IsTimeout returns true if err is, or was caused by, the engine not answering in time.
+func IsTimeout(err error) bool {
+	return err != nil && errors.Cause(err) == ErrTimeout
+}
+
+// Wait is what a client is waiting for the engine to do, which decides how long a transport waits for each line.
+type Wait int
+
+const (
+	// WaitHandshake waits for the engine to identify itself after starting.
+	WaitHandshake Wait = iota
+	// WaitReply waits for the engine to answer a command that it should answer at once, such as isready.
+	WaitReply
+	// WaitSearch waits for the engine to report on its search or move.
+	WaitSearch
+)
+
+func (w Wait) String() string {
+	switch w {
+	case WaitHandshake:
+		return "handshake"
+	case WaitReply:
+		return "reply"
+	default:
+		return "search"
+	}
+}
+
+// Timeouts bound how long a transport waits for the engine's next line, depending on what it's waiting for. Searches
+// can take as long as their limits allow, so the search timeout applies between lines: engines that report on their
+// search as they go can be held to much less than the length of a search. Zero waits forever.
+type Timeouts struct {
+	Handshake time.Duration `yaml:"handshake"`
+	Reply     time.Duration `yaml:"reply"`
+	Search    time.Duration `yaml:"search"`
+}
+
+func (t Timeouts) of(wait Wait) time.Duration {
+	switch wait {
+	case WaitHandshake:
+		return t.Handshake
+	case WaitReply:
+		return t.Reply
+	default:
+		return t.Search
+	}
+}
+
+// expecter is implemented by transports that time out waiting for an engine.
+type expecter interface {
+	Expect(wait Wait)
+}
+
+// Expect tells a transport what the engine is about to be waited for, so that it waits for the engine's next lines
+// no longer than the timeout for that. Transports without timeouts ignore it.
+func Expect(transport Transport, wait Wait) {
+	if e, ok := transport.(expecter); ok {
+		e.Expect(wait)
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgramTransportTimeouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timeouts")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	// The program answers its first command at once, and then nothing for a while.
+	program := filepath.Join(dir, "engine")
+	if !assert.NoError(t, ioutil.WriteFile(program, []byte("#!/bin/sh\nread line\necho uciok\nread line\nsleep 1\necho bestmove\n"), 0755)) {
+		t.FailNow()
+	}
+
+	transport, err := NewProgramTransport(program, ProgramOptions{Resources: Resources{
+		Timeouts: Timeouts{Handshake: time.Second, Search: 50 * time.Millisecond},
+	}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer transport.Close()
+	defer Kill(transport)
+	assert.NoError(t, transport.Send("uci"))
+	line, err := transport.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "uciok", line)
+
+	Expect(transport, WaitSearch)
+	assert.NoError(t, transport.Send("go"))
+	_, err = transport.Recv()
+	assert.True(t, IsTimeout(err), "the engine took longer than the search timeout")
+
+	// Without a timeout, the line arrives after all.
+	Expect(transport, WaitReply)
+	line, err = transport.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "bestmove", line)
+}
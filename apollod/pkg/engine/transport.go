@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Transport carries lines of text to and from an engine.
+type Transport interface {
+	io.Closer
+
+	Send(msg string) error
+	Recv() (string, error)
+}
+
+// ErrCrashed is returned by transports whose engine exited without being asked to, such as when it crashed. Errors
+// that wrap it are told apart with IsCrash.
+var ErrCrashed = errors.New("engine exited unexpectedly")
+
+// IsCrash returns true if err is, or was caused by, the engine exiting without being asked to.
+func IsCrash(err error) bool {
+	return err != nil && errors.Cause(err) == ErrCrashed
+}
+
+// killer is implemented by transports whose engine can be forcibly terminated.
+type killer interface {
+	Kill() error
+}
+
+// Kill forcibly terminates the engine at the other end of a transport that isn't responding to commands. Transports
+// that can't terminate their engine do nothing.
+func Kill(transport Transport) error {
+	if k, ok := transport.(killer); ok {
+		return k.Kill()
+	}
+	return nil
+}
+
+type popenTransport struct {
+	process *exec.Cmd
+	in      io.Writer
+	// lines are read from the engine's output as they arrive, so that Recv can give up waiting for one. Once the
+	// output ends, lines is closed, after readErr is set to whatever ended it.
+	lines   chan string
+	readErr error
+
+	timeouts Timeouts
+	lock     sync.Mutex
+	wait     Wait
+}
+
+func (p *popenTransport) Close() error {
+	return p.process.Wait()
+}
+
+// Kill forcibly terminates the engine, for when it has stopped responding.
+func (p *popenTransport) Kill() error {
+	return p.process.Process.Kill()
+}
+
+// Send writes a line to the engine. Writes only fail once the engine has exited and closed its end of the pipe.
+func (p *popenTransport) Send(msg string) error {
+	if _, err := p.in.Write([]byte(msg + "\n")); err != nil {
+		return errors.WithMessage(ErrCrashed, err.Error())
+	}
+	return nil
+}
+
+// Recv reads a line from the engine. The engine's output only ends when it exits, and engines that are asked to quit
+// aren't read from again, so the end of its output is a crash. If the engine doesn't send a line within the timeout for
+// what it's being waited for, Recv returns ErrTimeout; the line, if it ever comes, is returned by the next Recv.
+func (p *popenTransport) Recv() (string, error) {
+	p.lock.Lock()
+	wait := p.wait
+	p.lock.Unlock()
+	var expired <-chan time.Time
+	if timeout := p.timeouts.of(wait); timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			if p.readErr != nil {
+				return "", errors.WithMessage(ErrCrashed, p.readErr.Error())
+			}
+			return "", ErrCrashed
+		}
+		return line, nil
+	case <-expired:
+		return "", errors.WithMessage(ErrTimeout, fmt.Sprintf("no %s from engine after %s", wait, p.timeouts.of(wait)))
+	}
+}
+
+// Expect sets the timeout for the lines that Recv reads from now on.
+func (p *popenTransport) Expect(wait Wait) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.wait = wait
+}
+
+// read reads lines from the engine's output until it ends.
+func (p *popenTransport) read(out io.Reader) {
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		p.lines <- scanner.Text()
+	}
+	p.readErr = scanner.Err()
+	close(p.lines)
+}
+
+func (p *popenTransport) LogStderr() error {
+	stderr, err := p.process.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.WithField("source", "apollo").Info(scanner.Text())
+		}
+	}()
+	return nil
+}
+
+// ProgramOptions are how an engine program is run, beyond the path to it. The zero value runs the program with no
+// arguments, in apollod's working directory and environment.
+type ProgramOptions struct {
+	// Args are passed to the program on its command line, such as the weights file that lc0 plays with.
+	Args []string `yaml:"args"`
+	// Env are environment variables set for the program, on top of apollod's own. RUST_LOG is info unless set here.
+	Env map[string]string `yaml:"env"`
+	// Dir is the working directory of the program, for engines that look for their files relative to it. If empty,
+	// the program runs in apollod's working directory.
+	Dir string `yaml:"dir"`
+	// Resources caps what the program may use.
+	Resources Resources `yaml:"resources"`
+}
+
+// environ returns the environment the program runs with.
+func (o ProgramOptions) environ() []string {
+	env := append(os.Environ(), "RUST_LOG=info")
+	names := make([]string, 0, len(o.Env))
+	for name := range o.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Later entries win over earlier ones with the same name.
+	for _, name := range names {
+		env = append(env, name+"="+o.Env[name])
+	}
+	return env
+}
+
+// NewProgramTransport runs the program at programPath with the given arguments, environment, and working directory,
+// capping the resources it may use from its very start, and the time it may take to answer. If the caps can't be
+// applied, the program isn't run, and the transport's engine exits straight away.
+func NewProgramTransport(programPath string, options ProgramOptions) (Transport, error) {
+	log.WithFields(log.Fields{
+		"program": programPath,
+		"args":    options.Args,
+	}).Info("launching new program")
+	cmd, err := options.Resources.command(programPath, options.Args)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = options.environ()
+	cmd.Dir = options.Dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	trans := &popenTransport{
+		process:  cmd,
+		in:       stdin,
+		lines:    make(chan string),
+		timeouts: options.Resources.Timeouts,
+		wait:     WaitHandshake,
+	}
+
+	trans.LogStderr()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go trans.read(stdout)
+	return trans, nil
+}
+
+// transcriptTimeFormat is how timestamped transcripts write the time, to the millisecond.
+const transcriptTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// transcriptTransport records everything sent to and received from an engine.
+type transcriptTransport struct {
+	Transport
+	w io.Writer
+}
+
+// NewTranscriptTransport wraps a transport so that every line sent to the engine is written to w prefixed with "> ",
+// and every line received from it prefixed with "< ".
+func NewTranscriptTransport(inner Transport, w io.Writer) Transport {
+	return &transcriptTransport{Transport: inner, w: w}
+}
+
+// timestampWriter prefixes every line written through it with the time it was written.
+type timestampWriter struct {
+	lock    sync.Mutex
+	w       io.Writer
+	now     func() time.Time
+	midLine bool
+}
+
+// NewTimestampedTranscript wraps a transcript so that every line written to it begins with the time it was written,
+// so that it shows afterwards how long an engine took over everything it was asked.
+func NewTimestampedTranscript(w io.Writer) io.Writer {
+	return &timestampWriter{w: w, now: time.Now}
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var buf bytes.Buffer
+	for rest := p; len(rest) > 0; {
+		if !t.midLine {
+			buf.WriteString(t.now().Format(transcriptTimeFormat) + " ")
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		buf.Write(line)
+		t.midLine = line[len(line)-1] != '\n'
+		rest = rest[len(line):]
+	}
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *transcriptTransport) Kill() error {
+	return Kill(t.Transport)
+}
+
+func (t *transcriptTransport) Expect(wait Wait) {
+	Expect(t.Transport, wait)
+}
+
+func (t *transcriptTransport) Send(msg string) error {
+	fmt.Fprintf(t.w, "> %s\n", msg)
+	return t.Transport.Send(msg)
+}
+
+func (t *transcriptTransport) Recv() (string, error) {
+	line, err := t.Transport.Recv()
+	if err == nil {
+		fmt.Fprintf(t.w, "< %s\n", line)
+	}
+	return line, err
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampedTranscript(t *testing.T) {
+	var buf bytes.Buffer
+	transcript := NewTimestampedTranscript(&buf).(*timestampWriter)
+	now := time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC)
+	transcript.now = func() time.Time { return now }
+
+	fmt.Fprintln(transcript, "> go movetime 100")
+	now = now.Add(100 * time.Millisecond)
+	// Lines written in pieces are stamped once, when they're started.
+	fmt.Fprint(transcript, "< info depth 1\n< best")
+	now = now.Add(time.Millisecond)
+	fmt.Fprint(transcript, "move e2e4\n")
+	assert.Equal(t, "2021-03-04T12:30:00.000Z > go movetime 100\n"+
+		"2021-03-04T12:30:00.100Z < info depth 1\n"+
+		"2021-03-04T12:30:00.100Z < bestmove e2e4\n", buf.String())
+}
+
+func TestProgramTransportOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "options")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// The program reports how it was run.
+	program := filepath.Join(dir, "engine")
+	script := "#!/bin/sh\necho \"$@\"\necho \"$WEIGHTS $RUST_LOG\"\npwd\n"
+	if !assert.NoError(t, ioutil.WriteFile(program, []byte(script), 0755)) {
+		t.FailNow()
+	}
+
+	transport, err := NewProgramTransport(program, ProgramOptions{
+		Args: []string{"--weights", "net.pb.gz"},
+		Env:  map[string]string{"WEIGHTS": "net.pb.gz", "RUST_LOG": "debug"},
+		Dir:  dir,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer transport.Close()
+	for _, expected := range []string{"--weights net.pb.gz", "net.pb.gz debug", dir} {
+		line, err := transport.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, line)
+	}
+}
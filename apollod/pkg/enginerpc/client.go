@@ -0,0 +1,210 @@
+package enginerpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// callTimeout bounds every call except searches, which take as long as they take.
+const callTimeout = 30 * time.Second
+
+func init() {
+	engine.RegisterConnector("grpc", func(address string, transcript io.Writer) (engine.Engine, error) {
+		client, err := Dial(address, transcript)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+// Client is an engine running on an enginerpc server.
+type Client struct {
+	conn       *grpc.ClientConn
+	transcript io.Writer
+	session    OpenReply
+
+	lastInfo engine.Info
+	onInfo   func(engine.Info)
+
+	// cancelSearch abandons the search in progress, if there is one.
+	lock         sync.Mutex
+	cancelSearch context.CancelFunc
+}
+
+// Dial connects to the server at address, such as "engines.example.com:9090", and opens a session on it. Calls made
+// through the client are written to transcript, if it isn't nil.
+func Dial(address string, transcript io.Writer) (*Client, error) {
+	if address == "" {
+		return nil, errors.New("remote engines need the address of an engine server")
+	}
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(contentSubtype)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to engine server %s", address)
+	}
+	if transcript == nil {
+		transcript = ioutil.Discard
+	}
+
+	client := &Client{conn: conn, transcript: transcript}
+	if err := client.call("Open", &Empty{}, &client.session); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "failed to open a session on engine server %s", address)
+	}
+	fmt.Fprintf(transcript, "< session %s: %s\n", client.session.Session, client.session.Name)
+	return client, nil
+}
+
+func (c *Client) call(method string, req, reply interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	return c.conn.Invoke(ctx, "/"+serviceName+"/"+method, req, reply)
+}
+
+// command makes a call that only says whether it worked, writing it to the transcript as description.
+func (c *Client) command(method, description string, req interface{}) error {
+	fmt.Fprintf(c.transcript, "> %s\n", description)
+	return c.call(method, req, &Empty{})
+}
+
+func (c *Client) Name() string             { return c.session.Name }
+func (c *Client) Author() string           { return c.session.Author }
+func (c *Client) Options() []engine.Option { return c.session.Options }
+
+// HasOption returns true if the engine declared an option with the given name. Option names are case-insensitive.
+func (c *Client) HasOption(name string) bool {
+	_, ok := engine.LookupOption(c.session.Options, name)
+	return ok
+}
+
+func (c *Client) SetOption(name, value string) error {
+	return c.command("SetOption", fmt.Sprintf("option %s=%s", name, value),
+		&SetOptionRequest{Session: c.session.Session, Name: name, Value: value})
+}
+
+func (c *Client) IsReady() error {
+	return c.command("IsReady", "isready", &SessionRequest{Session: c.session.Session})
+}
+
+func (c *Client) NewGame(chess960 bool) error {
+	return c.command("NewGame", fmt.Sprintf("newgame chess960=%t", chess960),
+		&NewGameRequest{Session: c.session.Session, Chess960: chess960})
+}
+
+func (c *Client) SetPosition(fen string, moves []string) error {
+	return c.command("SetPosition", fmt.Sprintf("position %q moves %s", fen, strings.Join(moves, " ")),
+		&SetPositionRequest{Session: c.session.Session, FEN: fen, Moves: moves})
+}
+
+// Search streams the engine's reports about its search until it moves. Kill abandons it.
+func (c *Client) Search(limits engine.Limits) (string, error) {
+	result, err := c.Go(limits)
+	return result.Move, err
+}
+
+// Go is Search, returning the reply the engine expects and its final report on the search along with its move.
+func (c *Client) Go(limits engine.Limits) (engine.SearchResult, error) {
+	req := &SearchRequest{
+		Session:        c.session.Session,
+		WhiteTime:      millis(limits.WhiteTime),
+		BlackTime:      millis(limits.BlackTime),
+		WhiteIncrement: millis(limits.WhiteIncrement),
+		BlackIncrement: millis(limits.BlackIncrement),
+		MovesToGo:      limits.MovesToGo,
+		MoveTime:       millis(limits.MoveTime),
+		Depth:          limits.Depth,
+		Nodes:          limits.Nodes,
+		Infinite:       limits.Infinite,
+		SearchMoves:    limits.SearchMoves,
+	}
+	fmt.Fprintf(c.transcript, "> search %+v\n", *req)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.lock.Lock()
+	c.cancelSearch = cancel
+	c.lock.Unlock()
+
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Search")
+	if err != nil {
+		return engine.SearchResult{}, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return engine.SearchResult{}, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return engine.SearchResult{}, err
+	}
+
+	c.lastInfo = engine.Info{}
+	for {
+		var reply SearchReply
+		if err := stream.RecvMsg(&reply); err != nil {
+			if err == io.EOF {
+				err = errors.New("engine server ended the search without a move")
+			}
+			return engine.SearchResult{}, err
+		}
+		c.lastInfo = reply.Info
+		if reply.NoMove {
+			fmt.Fprintln(c.transcript, "< move (none)")
+			return engine.SearchResult{}, engine.ErrNoMove
+		}
+		if reply.Move != "" {
+			fmt.Fprintf(c.transcript, "< move %s\n", reply.Move)
+			return engine.SearchResult{Move: reply.Move, Ponder: reply.Ponder, Info: reply.Info}, nil
+		}
+		if c.onInfo != nil {
+			c.onInfo(c.lastInfo)
+		}
+	}
+}
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func (c *Client) Info() engine.Info { return c.lastInfo }
+
+func (c *Client) OnInfo(f func(engine.Info)) { c.onInfo = f }
+
+func (c *Client) Stop() error {
+	return c.command("Stop", "stop", &SessionRequest{Session: c.session.Session})
+}
+
+func (c *Client) Quit() error {
+	return c.command("Quit", "quit", &SessionRequest{Session: c.session.Session})
+}
+
+// Close ends the session, waiting for the engine to exit, and disconnects from the server.
+func (c *Client) Close() error {
+	err := c.command("Close", "close", &SessionRequest{Session: c.session.Session})
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Kill abandons the search in progress and has the server kill the engine. The server may well be unreachable if the
+// engine isn't responding, so the search is abandoned either way.
+func (c *Client) Kill() error {
+	c.lock.Lock()
+	if c.cancelSearch != nil {
+		c.cancelSearch()
+	}
+	c.lock.Unlock()
+	return c.command("Kill", "kill", &SessionRequest{Session: c.session.Session})
+}
@@ -0,0 +1,204 @@
+package enginerpc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+// serve starts an engine server on a local port that runs fake engines, returning its address.
+func serve(t *testing.T, fakes chan<- *ucitest.Engine, delay time.Duration, options ...ServerOption) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	server := NewServer(func() (engine.Engine, error) {
+		fake := &ucitest.Engine{
+			Name:    "Fake",
+			Options: []string{"Hash type spin default 16 min 1 max 1024", "Debug Log File"},
+			Delay:   delay,
+			Ponder:  true,
+		}
+		fakes <- fake
+		return uci.NewClient(fake)
+	}, options...)
+	grpcServer := grpc.NewServer()
+	server.Register(grpcServer)
+	go grpcServer.Serve(listener)
+	return listener.Addr().String(), func() {
+		grpcServer.Stop()
+		server.Shutdown()
+	}
+}
+
+func TestRemoteEngine(t *testing.T) {
+	fakes := make(chan *ucitest.Engine, 1)
+	address, stop := serve(t, fakes, 0)
+	defer stop()
+
+	var transcript bytes.Buffer
+	client, err := engine.Launch(address, "grpc", &transcript, engine.ProgramOptions{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	fake := <-fakes
+	assert.Equal(t, "Fake", client.Name())
+	assert.True(t, client.HasOption("hash"))
+
+	assert.NoError(t, client.SetOption("Hash", "128"))
+	assert.NoError(t, client.NewGame(false))
+	assert.NoError(t, client.SetPosition("", []string{"e2e4"}))
+	assert.NoError(t, client.IsReady())
+
+	var depths []int
+	client.OnInfo(func(info engine.Info) { depths = append(depths, info.Depth) })
+	move, err := client.Search(engine.Limits{WhiteTime: time.Minute, BlackTime: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, ucitest.FirstLegalMove("startpos", []string{"e2e4"}), move)
+	assert.Equal(t, []int{1}, depths)
+	assert.Equal(t, 1, client.Info().Depth)
+	assert.Equal(t, 1, fake.Searches())
+	assert.Contains(t, fake.Commands(), "setoption name Hash value 128")
+	assert.Contains(t, fake.Commands(), "go wtime 60000 winc 0 btime 60000 binc 0")
+
+	result, err := client.Go(engine.Limits{Depth: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, move, result.Move)
+	assert.Equal(t, ucitest.FirstLegalMove("startpos", []string{"e2e4", move}), result.Ponder)
+	assert.Equal(t, 1, result.Info.Depth)
+	assert.True(t, result.Info.HasScore)
+
+	assert.NoError(t, client.Close())
+	assert.Contains(t, transcript.String(), "< move "+move)
+}
+
+func TestKillAbandonsSearch(t *testing.T) {
+	fakes := make(chan *ucitest.Engine, 1)
+	address, stop := serve(t, fakes, time.Hour)
+	defer stop()
+
+	client, err := Dial(address, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+
+	result := make(chan error)
+	go func() {
+		_, err := client.Search(engine.Limits{MoveTime: time.Hour})
+		result <- err
+	}()
+	fake := <-fakes
+	for fake.Searches() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	client.Kill()
+	select {
+	case err := <-result:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("search was not abandoned")
+	}
+}
+
+func TestUnknownSession(t *testing.T) {
+	address, stop := serve(t, make(chan *ucitest.Engine, 1), 0)
+	defer stop()
+
+	client, err := Dial(address, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.session.Session = "nope"
+	assert.Error(t, client.IsReady())
+	assert.Error(t, client.Close())
+}
+
+func TestSessionIDs(t *testing.T) {
+	address, stop := serve(t, make(chan *ucitest.Engine, 2), 0)
+	defer stop()
+
+	first, err := Dial(address, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer first.Close()
+	second, err := Dial(address, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer second.Close()
+	assert.Len(t, first.session.Session, 32)
+	assert.NotEqual(t, first.session.Session, second.session.Session)
+}
+
+func TestMaxSessions(t *testing.T) {
+	fakes := make(chan *ucitest.Engine, 2)
+	address, stop := serve(t, fakes, 0, WithMaxSessions(1))
+	defer stop()
+
+	client, err := Dial(address, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = Dial(address, nil)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(errors.Cause(err)))
+
+	assert.NoError(t, client.Close())
+	client, err = Dial(address, nil)
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+}
+
+func TestIdleSessionsClosed(t *testing.T) {
+	fakes := make(chan *ucitest.Engine, 1)
+	address, stop := serve(t, fakes, 0, WithIdleTimeout(50*time.Millisecond))
+	defer stop()
+
+	client, err := Dial(address, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	fake := <-fakes
+	assert.NoError(t, client.IsReady())
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, engine.ErrCrashed, fake.Send("isready"), "idle engine should have been killed")
+	assert.Equal(t, codes.NotFound, status.Code(errors.Cause(client.IsReady())))
+}
+
+func TestSetOptionRestricted(t *testing.T) {
+	fakes := make(chan *ucitest.Engine, 1)
+	address, stop := serve(t, fakes, 0)
+	defer stop()
+
+	client, err := Dial(address, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+	fake := <-fakes
+
+	assert.NoError(t, client.SetOption("hash", "64"))
+	err = client.SetOption("Debug Log File", "/etc/passwd")
+	assert.Equal(t, codes.PermissionDenied, status.Code(errors.Cause(err)))
+	err = client.SetOption("Hash", "1000000")
+	assert.Equal(t, codes.InvalidArgument, status.Code(errors.Cause(err)))
+	err = client.SetOption("Threads", "4")
+	assert.Equal(t, codes.NotFound, status.Code(errors.Cause(err)))
+	assert.NoError(t, client.IsReady())
+	for _, command := range fake.Commands() {
+		assert.NotContains(t, command, "Debug Log File")
+	}
+}
@@ -0,0 +1,394 @@
+package enginerpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// Server runs engines for remote clients, one per session. Anyone who can reach the server can open sessions and use
+// their engines, so it must only be reachable from a trusted network.
+type Server struct {
+	launch      func() (engine.Engine, error)
+	maxSessions int
+	idleTimeout time.Duration
+
+	lock     sync.Mutex
+	sessions map[string]*session
+	// opening is the number of sessions whose engines are being started, which count against maxSessions.
+	opening int
+	done    chan struct{}
+}
+
+// session is a client's engine, and when the client last used it.
+type session struct {
+	engine.Engine
+	lastUsed  time.Time
+	searching bool
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithMaxSessions caps the number of sessions open at once, each of which runs an engine, so that clients can't
+// exhaust the host. Zero doesn't cap them.
+func WithMaxSessions(sessions int) ServerOption {
+	return func(server *Server) {
+		server.maxSessions = sessions
+	}
+}
+
+// WithIdleTimeout closes sessions that haven't been used for a while, killing their engines, so that clients that go
+// away without closing theirs don't leave engines behind. Searches in progress keep their sessions open. Zero keeps
+// sessions open until they're closed.
+func WithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(server *Server) {
+		server.idleTimeout = timeout
+	}
+}
+
+// NewServer creates a server that starts a new engine with launch for every session.
+func NewServer(launch func() (engine.Engine, error), options ...ServerOption) *Server {
+	server := &Server{
+		launch:   launch,
+		sessions: make(map[string]*session),
+		done:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(server)
+	}
+	if server.idleTimeout > 0 {
+		go server.expire()
+	}
+	return server
+}
+
+// Register adds the engine service to a gRPC server.
+func (s *Server) Register(server *grpc.Server) {
+	server.RegisterService(&serviceDesc, s)
+}
+
+// Shutdown kills the engine of every session that is still open.
+func (s *Server) Shutdown() {
+	s.lock.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*session)
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.lock.Unlock()
+	for id, client := range sessions {
+		log.WithField("session", id).Info("killing engine of open session")
+		client.Kill()
+		client.Close()
+	}
+}
+
+// expire closes sessions that have been idle for longer than the idle timeout, until the server is shut down.
+func (s *Server) expire() {
+	ticker := time.NewTicker(s.idleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		idle := make(map[string]*session)
+		s.lock.Lock()
+		for id, client := range s.sessions {
+			if !client.searching && time.Since(client.lastUsed) > s.idleTimeout {
+				idle[id] = client
+				delete(s.sessions, id)
+			}
+		}
+		s.lock.Unlock()
+		for id, client := range idle {
+			log.WithField("session", id).Info("closing idle engine session")
+			client.Kill()
+			client.Close()
+		}
+	}
+}
+
+// session looks up a session, counting the lookup as a use of it.
+func (s *Server) session(id string) (*session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	client, ok := s.sessions[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no session %q", id)
+	}
+	client.lastUsed = time.Now()
+	return client, nil
+}
+
+// newSessionID returns an ID for a new session that no other client can guess, since anyone with a session's ID can
+// use its engine.
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (s *Server) open(ctx context.Context, _ *Empty) (*OpenReply, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create session: %v", err)
+	}
+	s.lock.Lock()
+	if s.maxSessions > 0 && len(s.sessions)+s.opening >= s.maxSessions {
+		s.lock.Unlock()
+		return nil, status.Errorf(codes.ResourceExhausted, "all %d engine sessions are in use", s.maxSessions)
+	}
+	s.opening++
+	s.lock.Unlock()
+
+	client, err := s.launch()
+	s.lock.Lock()
+	s.opening--
+	if err == nil {
+		s.sessions[id] = &session{Engine: client, lastUsed: time.Now()}
+	}
+	s.lock.Unlock()
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to start engine: %v", err)
+	}
+	log.WithFields(log.Fields{
+		"session": id,
+		"engine":  client.Name(),
+	}).Info("opened engine session")
+	return &OpenReply{Session: id, Name: client.Name(), Author: client.Author(), Options: client.Options()}, nil
+}
+
+// setOption sets an option of a session's engine. String options are refused, since they're where engines take the
+// paths of files to read and write, which remote clients mustn't choose; the server's own configuration sets them.
+// Engines that declare no options at all, like Apollo, are sent whatever they're given.
+func (s *Server) setOption(ctx context.Context, req *SetOptionRequest) (*Empty, error) {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	if options := client.Options(); len(options) > 0 {
+		option, ok := findOption(options, req.Name)
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "engine has no option %q", req.Name)
+		}
+		if option.Type == engine.OptionString {
+			return nil, status.Errorf(codes.PermissionDenied, "option %q can only be set by the engine server", option.Name)
+		}
+		if err := option.Validate(req.Value); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return &Empty{}, client.SetOption(req.Name, req.Value)
+}
+
+// findOption finds an option by name, which UCI compares without regard to case.
+func findOption(options []engine.Option, name string) (engine.Option, bool) {
+	for _, option := range options {
+		if strings.EqualFold(option.Name, name) {
+			return option, true
+		}
+	}
+	return engine.Option{}, false
+}
+
+func (s *Server) isReady(ctx context.Context, req *SessionRequest) (*Empty, error) {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	return &Empty{}, client.IsReady()
+}
+
+func (s *Server) newGame(ctx context.Context, req *NewGameRequest) (*Empty, error) {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	return &Empty{}, client.NewGame(req.Chess960)
+}
+
+func (s *Server) setPosition(ctx context.Context, req *SetPositionRequest) (*Empty, error) {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	return &Empty{}, client.SetPosition(req.FEN, req.Moves)
+}
+
+// search streams what the engine reports about its search, then its move. If the client goes away, the search is
+// stopped.
+func (s *Server) search(req *SearchRequest, stream grpc.ServerStream) error {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	client.searching = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		client.searching, client.lastUsed = false, time.Now()
+		s.lock.Unlock()
+	}()
+
+	client.OnInfo(func(info engine.Info) {
+		// Info is only a progress report, so a client that can't keep up misses some rather than holding up the search.
+		if err := stream.SendMsg(&SearchReply{Info: info}); err != nil {
+			log.WithError(err).Debug("failed to send search info")
+		}
+	})
+	defer client.OnInfo(nil)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stream.Context().Done():
+			client.Stop()
+		case <-done:
+		}
+	}()
+
+	result, err := client.Go(engine.Limits{
+		WhiteTime:      time.Duration(req.WhiteTime) * time.Millisecond,
+		BlackTime:      time.Duration(req.BlackTime) * time.Millisecond,
+		WhiteIncrement: time.Duration(req.WhiteIncrement) * time.Millisecond,
+		BlackIncrement: time.Duration(req.BlackIncrement) * time.Millisecond,
+		MovesToGo:      req.MovesToGo,
+		MoveTime:       time.Duration(req.MoveTime) * time.Millisecond,
+		Depth:          req.Depth,
+		Nodes:          req.Nodes,
+		Infinite:       req.Infinite,
+		SearchMoves:    req.SearchMoves,
+	})
+	if engine.IsNoMove(err) {
+		return stream.SendMsg(&SearchReply{Info: client.Info(), NoMove: true})
+	}
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(&SearchReply{Info: result.Info, Move: result.Move, Ponder: result.Ponder})
+}
+
+func (s *Server) stop(ctx context.Context, req *SessionRequest) (*Empty, error) {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	return &Empty{}, client.Stop()
+}
+
+func (s *Server) quit(ctx context.Context, req *SessionRequest) (*Empty, error) {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	return &Empty{}, client.Quit()
+}
+
+// close ends a session, waiting for its engine to exit.
+func (s *Server) close(ctx context.Context, req *SessionRequest) (*Empty, error) {
+	s.lock.Lock()
+	client, ok := s.sessions[req.Session]
+	delete(s.sessions, req.Session)
+	s.lock.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no session %q", req.Session)
+	}
+	log.WithField("session", req.Session).Info("closed engine session")
+	return &Empty{}, client.Close()
+}
+
+func (s *Server) kill(ctx context.Context, req *SessionRequest) (*Empty, error) {
+	client, err := s.session(req.Session)
+	if err != nil {
+		return nil, err
+	}
+	return &Empty{}, client.Kill()
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Open", func() interface{} { return &Empty{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.open(ctx, req.(*Empty))
+		}),
+		unary("SetOption", func() interface{} { return &SetOptionRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.setOption(ctx, req.(*SetOptionRequest))
+		}),
+		unary("IsReady", func() interface{} { return &SessionRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.isReady(ctx, req.(*SessionRequest))
+		}),
+		unary("NewGame", func() interface{} { return &NewGameRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.newGame(ctx, req.(*NewGameRequest))
+		}),
+		unary("SetPosition", func() interface{} { return &SetPositionRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.setPosition(ctx, req.(*SetPositionRequest))
+		}),
+		unary("Stop", func() interface{} { return &SessionRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.stop(ctx, req.(*SessionRequest))
+		}),
+		unary("Quit", func() interface{} { return &SessionRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.quit(ctx, req.(*SessionRequest))
+		}),
+		unary("Close", func() interface{} { return &SessionRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.close(ctx, req.(*SessionRequest))
+		}),
+		unary("Kill", func() interface{} { return &SessionRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.kill(ctx, req.(*SessionRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Search",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &SearchRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(*Server).search(req, stream)
+			},
+		},
+	},
+}
+
+// unary describes a unary method, decoding its request with newRequest and handling it with handle.
+func unary(name string, newRequest func() interface{}, handle func(*Server, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, decode func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := decode(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return handle(srv.(*Server), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
@@ -0,0 +1,91 @@
+// Package enginerpc serves engines over gRPC, so that an engine can run in its own container or on a host better
+// suited to it than the one apollod runs on, and plays with apollod and selfplay workers like any other engine.
+//
+// The service mirrors engine.Engine. Each engine a client opens is a session on the server, running its own copy of
+// the server's engine until the client closes it. Messages are encoded as JSON, with the "json" content subtype, so
+// there is no generated code; any gRPC client can call the service by sending the messages below.
+//
+// Clients aren't authenticated: anyone who can reach the server can run its engine. Serve it on loopback or a trusted
+// network only.
+package enginerpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const (
+	serviceName = "apollo.engine.Engine"
+	// contentSubtype selects the JSON codec for every call.
+	contentSubtype = "json"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return contentSubtype }
+
+// Empty is the request or reply of calls that have nothing to say.
+type Empty struct{}
+
+// OpenReply identifies a new session and the engine running in it.
+type OpenReply struct {
+	Session string          `json:"session"`
+	Name    string          `json:"name"`
+	Author  string          `json:"author"`
+	Options []engine.Option `json:"options"`
+}
+
+// SessionRequest is the request of calls that only need to know which session they're for.
+type SessionRequest struct {
+	Session string `json:"session"`
+}
+
+type SetOptionRequest struct {
+	Session string `json:"session"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+}
+
+type NewGameRequest struct {
+	Session  string `json:"session"`
+	Chess960 bool   `json:"chess960"`
+}
+
+type SetPositionRequest struct {
+	Session string   `json:"session"`
+	FEN     string   `json:"fen"`
+	Moves   []string `json:"moves"`
+}
+
+// SearchRequest starts a search. Times are in milliseconds.
+type SearchRequest struct {
+	Session        string   `json:"session"`
+	WhiteTime      int64    `json:"wtime"`
+	BlackTime      int64    `json:"btime"`
+	WhiteIncrement int64    `json:"winc"`
+	BlackIncrement int64    `json:"binc"`
+	MovesToGo      int      `json:"movestogo"`
+	MoveTime       int64    `json:"movetime"`
+	Depth          int      `json:"depth"`
+	Nodes          int64    `json:"nodes,omitempty"`
+	Infinite       bool     `json:"infinite,omitempty"`
+	SearchMoves    []string `json:"searchmoves,omitempty"`
+}
+
+// SearchReply is streamed back while the engine searches: every time the engine reports something new, a reply with
+// everything it has reported so far, and finally a reply with its move, or with NoMove set if it had none to play.
+type SearchReply struct {
+	Info   engine.Info `json:"info"`
+	Move   string      `json:"move,omitempty"`
+	Ponder string      `json:"ponder,omitempty"`
+	NoMove bool        `json:"noMove,omitempty"`
+}
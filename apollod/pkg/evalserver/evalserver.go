@@ -0,0 +1,366 @@
+// Package evalserver evaluates chess positions for other programs over a small HTTP/JSON API, with a pool of engines
+// behind it, so that local tools can reuse apollo's engine without speaking UCI themselves.
+package evalserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// maxRequestBytes bounds the size of an evaluation request, which is only ever a position and some limits.
+const maxRequestBytes = 1 << 16
+
+// Config bounds how much work the server takes on.
+type Config struct {
+	// Engines is the number of engines searching at once.
+	Engines int
+	// Queue is the number of requests that may wait for an engine. Requests beyond it are turned away.
+	Queue int
+	// PerClient is the number of requests, waiting or searching, that any one client may have at once. Zero is no
+	// limit. Clients are told apart by their X-Client-ID header, or else their address.
+	PerClient int
+	// MoveTime is how long to search when a request doesn't say.
+	MoveTime time.Duration
+	// MaxMoveTime is the longest a request may ask to search for.
+	MaxMoveTime time.Duration
+	// MaxDepth is the deepest a request may ask to search to.
+	MaxDepth int
+}
+
+// DefaultConfig runs a single engine, searches for a second by default and ten at most, and lets every client have
+// two requests in at once.
+func DefaultConfig() Config {
+	return Config{
+		Engines:     1,
+		Queue:       16,
+		PerClient:   2,
+		MoveTime:    time.Second,
+		MaxMoveTime: 10 * time.Second,
+		MaxDepth:    30,
+	}
+}
+
+// Validate checks that the server can search at all.
+func (c Config) Validate() error {
+	if c.Engines < 1 {
+		return errors.New("at least one engine is needed")
+	}
+	if c.Queue < 0 || c.PerClient < 0 || c.MaxDepth < 0 {
+		return errors.New("queue, per-client limit and maximum depth must not be negative")
+	}
+	if c.MoveTime <= 0 || c.MaxMoveTime < c.MoveTime {
+		return errors.New("move time must be positive and no more than the maximum move time")
+	}
+	return nil
+}
+
+// Request is a position to evaluate.
+type Request struct {
+	// FEN is the position to start from. Empty is the standard starting position.
+	FEN string `json:"fen"`
+	// Moves are played from FEN before evaluating, in UCI notation.
+	Moves []string `json:"moves"`
+	// MoveTime is how long to search, in milliseconds.
+	MoveTime int `json:"movetime"`
+	// Depth, if set, searches to this depth instead of for a fixed time.
+	Depth int `json:"depth"`
+}
+
+// Score is an evaluation from the perspective of the side to move: either centipawns or moves until mate.
+type Score struct {
+	Centipawns *int `json:"cp,omitempty"`
+	Mate       *int `json:"mate,omitempty"`
+}
+
+// Response is the engine's verdict on a position.
+type Response struct {
+	BestMove string   `json:"bestmove"`
+	Score    *Score   `json:"score,omitempty"`
+	Depth    int      `json:"depth"`
+	Nodes    int64    `json:"nodes"`
+	PV       []string `json:"pv"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server answers evaluation requests with engines that it starts as they're needed and keeps running between
+// requests.
+type Server struct {
+	launch func() (engine.Engine, error)
+	config Config
+
+	// admitted holds a token for every request that is waiting for or using an engine.
+	admitted chan struct{}
+	// engines holds a token for every request using an engine.
+	engines chan struct{}
+
+	lock    sync.Mutex
+	idle    []engine.Engine
+	clients map[string]int
+}
+
+// New creates a server that starts engines with launch.
+func New(launch func() (engine.Engine, error), config Config) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Server{
+		launch:   launch,
+		config:   config,
+		admitted: make(chan struct{}, config.Engines+config.Queue),
+		engines:  make(chan struct{}, config.Engines),
+		clients:  make(map[string]int),
+	}, nil
+}
+
+// Handler returns the HTTP API: POST /eval evaluates a position, and GET /health reports that the server is up.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eval", s.handleEval)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// Shutdown quits every idle engine. Requests still in progress should have finished first.
+func (s *Server) Shutdown() {
+	s.lock.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.lock.Unlock()
+	for _, client := range idle {
+		shutdownEngine(client)
+	}
+}
+
+func (s *Server) handleEval(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var request Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestBytes)).Decode(&request); err != nil {
+		requests.Inc("invalid")
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	limits, err := s.limits(request)
+	if err != nil {
+		requests.Inc("invalid")
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	client := clientID(req)
+	if !s.enterClient(client) {
+		requests.Inc("client_limited")
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, "too many requests in progress for this client")
+		return
+	}
+	defer s.leaveClient(client)
+	select {
+	case s.admitted <- struct{}{}:
+		defer func() { <-s.admitted }()
+	default:
+		requests.Inc("queue_full")
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "too many requests queued")
+		return
+	}
+
+	queued := time.Now()
+	queueDepth.Inc()
+	select {
+	case s.engines <- struct{}{}:
+		queueDepth.Dec()
+		defer func() { <-s.engines }()
+	case <-req.Context().Done():
+		queueDepth.Dec()
+		requests.Inc("canceled")
+		return
+	}
+	queueLatency.ObserveSince(queued)
+
+	start := time.Now()
+	response, err := s.evaluate(req.Context(), request, limits)
+	searchLatency.ObserveSince(start)
+	if err != nil {
+		log.WithError(err).WithField("client", client).Warn("failed to evaluate position")
+		requests.Inc("error")
+		writeError(w, http.StatusInternalServerError, "engine failed to evaluate position")
+		return
+	}
+	requests.Inc("ok")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// limits checks that a request is a legal position with moves left to play, and works out how to search it.
+func (s *Server) limits(request Request) (engine.Limits, error) {
+	options := []func(*chess.Game){chess.UseNotation(chess.LongAlgebraicNotation{})}
+	if request.FEN != "" {
+		fen, err := chess.FEN(request.FEN)
+		if err != nil {
+			return engine.Limits{}, errors.Wrap(err, "invalid fen")
+		}
+		options = append(options, fen)
+	}
+	game := chess.NewGame(options...)
+	for _, move := range request.Moves {
+		if err := game.MoveStr(move); err != nil {
+			return engine.Limits{}, errors.Errorf("illegal move %q", move)
+		}
+	}
+	if game.Outcome() != chess.NoOutcome {
+		return engine.Limits{}, errors.New("the game is already over")
+	}
+
+	if request.MoveTime < 0 || request.Depth < 0 {
+		return engine.Limits{}, errors.New("movetime and depth must not be negative")
+	}
+	if request.Depth > 0 {
+		if s.config.MaxDepth > 0 && request.Depth > s.config.MaxDepth {
+			return engine.Limits{}, errors.Errorf("depth must be at most %d", s.config.MaxDepth)
+		}
+		return engine.Limits{Depth: request.Depth}, nil
+	}
+	moveTime := s.config.MoveTime
+	if request.MoveTime > 0 {
+		moveTime = time.Duration(request.MoveTime) * time.Millisecond
+	}
+	if moveTime > s.config.MaxMoveTime {
+		return engine.Limits{}, errors.Errorf("movetime must be at most %d", s.config.MaxMoveTime/time.Millisecond)
+	}
+	return engine.Limits{MoveTime: moveTime}, nil
+}
+
+// evaluate searches a position with an idle engine, or a new one if none are idle. If the request is canceled, the
+// engine is stopped early.
+func (s *Server) evaluate(ctx context.Context, request Request, limits engine.Limits) (*Response, error) {
+	client, err := s.acquire()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start engine")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Stop()
+		case <-done:
+		}
+	}()
+
+	bestmove, err := search(client, request, limits)
+	if err != nil {
+		// Whatever went wrong, the engine can't be trusted with another position.
+		shutdownEngine(client)
+		return nil, err
+	}
+	info := client.Info()
+	s.release(client)
+
+	response := &Response{BestMove: bestmove, Depth: info.Depth, Nodes: info.Nodes, PV: info.PV}
+	if response.PV == nil {
+		response.PV = []string{}
+	}
+	if info.HasScore {
+		score := info.Score
+		if score.IsMate() {
+			response.Score = &Score{Mate: &score.Mate}
+		} else {
+			response.Score = &Score{Centipawns: &score.Centipawns}
+		}
+	}
+	return response, nil
+}
+
+func search(client engine.Engine, request Request, limits engine.Limits) (string, error) {
+	if err := client.NewGame(false); err != nil {
+		return "", err
+	}
+	if err := client.SetPosition(request.FEN, request.Moves); err != nil {
+		return "", err
+	}
+	return client.Search(limits)
+}
+
+func (s *Server) acquire() (engine.Engine, error) {
+	s.lock.Lock()
+	if n := len(s.idle); n > 0 {
+		client := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.lock.Unlock()
+		return client, nil
+	}
+	s.lock.Unlock()
+	return s.launch()
+}
+
+func (s *Server) release(client engine.Engine) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.idle = append(s.idle, client)
+}
+
+// enterClient counts a request against its client's limit, returning false if the client is already at it.
+func (s *Server) enterClient(client string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.config.PerClient > 0 && s.clients[client] >= s.config.PerClient {
+		return false
+	}
+	s.clients[client]++
+	return true
+}
+
+func (s *Server) leaveClient(client string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.clients[client]--; s.clients[client] <= 0 {
+		delete(s.clients, client)
+	}
+}
+
+// clientID identifies who sent a request: the client's own name for itself, if it gave one, or else its address.
+func clientID(req *http.Request) string {
+	if id := req.Header.Get("X-Client-ID"); id != "" {
+		return id
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
+
+func shutdownEngine(client engine.Engine) {
+	if err := client.Quit(); err != nil {
+		client.Kill()
+	}
+	if err := client.Close(); err != nil {
+		log.WithError(err).Warn("engine did not exit cleanly")
+	}
+}
@@ -0,0 +1,134 @@
+package evalserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+func newTestServer(t *testing.T, config Config, delay time.Duration) (*httptest.Server, *int) {
+	launched := 0
+	var lock sync.Mutex
+	evaluator, err := New(func() (engine.Engine, error) {
+		lock.Lock()
+		launched++
+		lock.Unlock()
+		return uci.NewClient(&ucitest.Engine{
+			Delay: delay,
+			Score: func(string, []string) engine.Score { return engine.Score{Centipawns: 35} },
+		})
+	}, config)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return httptest.NewServer(evaluator.Handler()), &launched
+}
+
+func post(t *testing.T, url, client string, request Request) *http.Response {
+	body, err := json.Marshal(request)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	req, err := http.NewRequest(http.MethodPost, url+"/eval", bytes.NewReader(body))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	req.Header.Set("X-Client-ID", client)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return resp
+}
+
+func TestEvaluate(t *testing.T) {
+	server, launched := newTestServer(t, DefaultConfig(), 0)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp := post(t, server.URL, "tool", Request{Moves: []string{"e2e4"}, MoveTime: 10})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var response Response
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		resp.Body.Close()
+
+		expected := ucitest.FirstLegalMove("startpos", []string{"e2e4"})
+		assert.Equal(t, expected, response.BestMove)
+		assert.Equal(t, []string{expected}, response.PV)
+		if assert.NotNil(t, response.Score) && assert.NotNil(t, response.Score.Centipawns) {
+			assert.Equal(t, 35, *response.Score.Centipawns)
+		}
+	}
+	assert.Equal(t, 1, *launched, "the engine is kept between requests")
+}
+
+func TestEvaluateRejectsBadRequests(t *testing.T) {
+	server, _ := newTestServer(t, DefaultConfig(), 0)
+	defer server.Close()
+
+	for _, request := range []Request{
+		{FEN: "not a position"},
+		{Moves: []string{"e2e5"}},
+		{MoveTime: int(time.Minute / time.Millisecond)},
+		{Depth: 100},
+		{Moves: []string{"f2f3", "e7e5", "g2g4", "d8h4"}},
+	} {
+		resp := post(t, server.URL, "tool", request)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "%+v", request)
+	}
+}
+
+func TestEvaluateLimitsEachClient(t *testing.T) {
+	config := DefaultConfig()
+	config.PerClient = 1
+	server, _ := newTestServer(t, config, 200*time.Millisecond)
+	defer server.Close()
+
+	// The first request occupies the engine while the others arrive.
+	first := make(chan int)
+	go func() {
+		resp := post(t, server.URL, "greedy", Request{MoveTime: 200})
+		resp.Body.Close()
+		first <- resp.StatusCode
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	resp := post(t, server.URL, "greedy", Request{MoveTime: 10})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp = post(t, server.URL, "patient", Request{MoveTime: 10})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "other clients wait their turn")
+	assert.Equal(t, http.StatusOK, <-first)
+}
+
+func TestEvaluateTurnsAwayRequestsBeyondTheQueue(t *testing.T) {
+	config := DefaultConfig()
+	config.Queue = 0
+	server, _ := newTestServer(t, config, 200*time.Millisecond)
+	defer server.Close()
+
+	first := make(chan int)
+	go func() {
+		resp := post(t, server.URL, "one", Request{MoveTime: 200})
+		resp.Body.Close()
+		first <- resp.StatusCode
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	resp := post(t, server.URL, "two", Request{MoveTime: 10})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, <-first)
+}
@@ -0,0 +1,23 @@
+package evalserver
+
+import "github.com/swgillespie/apollo/apollod/pkg/metrics"
+
+var (
+	evalMetrics = metrics.NewSubsystem("evalserver")
+
+	requests = evalMetrics.NewCounter(
+		"requests_total",
+		"Number of evaluation requests, by result (ok, invalid, client_limited, queue_full, canceled, or error).",
+		"result")
+	queueDepth = evalMetrics.NewGauge(
+		"queue_depth",
+		"Number of evaluation requests waiting for an engine.")
+	queueLatency = evalMetrics.NewHistogram(
+		"queue_seconds",
+		"Time evaluation requests waited for an engine.",
+		metrics.DefaultLatencyBuckets)
+	searchLatency = evalMetrics.NewHistogram(
+		"search_seconds",
+		"Time taken to evaluate a position once an engine was free.",
+		metrics.DefaultLatencyBuckets)
+)
@@ -0,0 +1,173 @@
+// Package gamerecord defines apollod's portable game record: a versioned JSON document with a game's moves, the clocks
+// and evaluations after them, who played it and how, and how it ended. Selfplay, the server's archive, and the game
+// database all produce records, and converters translate them to and from PGN and lichess's game export, so that any
+// of apollod's tools can read a game however it was written.
+package gamerecord
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the version of the record format that this package writes. Records of a later version are refused,
+// since they may mean something this package doesn't understand.
+const Version = 1
+
+// The results a game can end with, as in PGN.
+const (
+	WhiteWins  = "1-0"
+	BlackWins  = "0-1"
+	Draw       = "1/2-1/2"
+	Unfinished = "*"
+)
+
+// Record is a single game.
+type Record struct {
+	// Version is the version of the format the record was written in.
+	Version int `json:"version"`
+	// ID is the game's lichess ID, for games played on lichess.
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Site  string `json:"site,omitempty"`
+	// Started is when the game started, if known.
+	Started *time.Time `json:"started,omitempty"`
+	White   Player     `json:"white"`
+	Black   Player     `json:"black"`
+	// Variant is the lichess variant key, such as "standard", "fromPosition", or "chess960". Empty is standard.
+	Variant string `json:"variant,omitempty"`
+	// Speed is the lichess speed, such as "blitz".
+	Speed string `json:"speed,omitempty"`
+	Rated bool   `json:"rated,omitempty"`
+	// TimeControl is the clock the game was played with, if it had one.
+	TimeControl *TimeControl `json:"timeControl,omitempty"`
+	// InitialFEN is the position the game started from, if it wasn't the standard starting position.
+	InitialFEN string `json:"initialFen,omitempty"`
+	Moves      []Move `json:"moves"`
+	// Result is 1-0, 0-1, 1/2-1/2, or * for games that are unfinished or whose result is unknown.
+	Result string `json:"result"`
+	// Termination is how the game ended, as lichess names it: mate, resign, stalemate, draw, outoftime, timeout (for
+	// a player who left), aborted, and so on.
+	Termination string `json:"termination,omitempty"`
+	// Tags are anything else known about the game, such as PGN tags that have no field of their own.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Player is one side of a game.
+type Player struct {
+	Name   string `json:"name,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Rating int    `json:"rating,omitempty"`
+}
+
+// TimeControl is a game's clock, in seconds.
+type TimeControl struct {
+	Initial   int `json:"initial"`
+	Increment int `json:"increment"`
+}
+
+// Move is one move of a game.
+type Move struct {
+	// UCI is the move in UCI notation, which is what the rest of the record is keyed by.
+	UCI string `json:"uci"`
+	// SAN is the move in Standard Algebraic Notation.
+	SAN string `json:"san,omitempty"`
+	// ClockMs is the time, in milliseconds, that the player who moved had left afterwards.
+	ClockMs *int64 `json:"clockMs,omitempty"`
+	// Eval is the evaluation of the position after the move.
+	Eval    *Eval  `json:"eval,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Eval is an evaluation from white's point of view, as in PGN.
+type Eval struct {
+	// Centipawns is the evaluation in hundredths of a pawn. It is only meaningful if Mate is zero.
+	Centipawns int `json:"cp"`
+	// Mate, if nonzero, is the number of moves until mate, negative if black is mating.
+	Mate int `json:"mate,omitempty"`
+	// Depth is how deep the engine searched, if it said.
+	Depth int `json:"depth,omitempty"`
+}
+
+// New creates an unfinished record of the current version.
+func New() *Record {
+	return &Record{Version: Version, Result: Unfinished}
+}
+
+// UCI returns the game's moves in UCI notation.
+func (r *Record) UCI() []string {
+	moves := make([]string, len(r.Moves))
+	for i, move := range r.Moves {
+		moves[i] = move.UCI
+	}
+	return moves
+}
+
+// Validate checks that the record is a version this package understands and that nothing is missing from it.
+func (r *Record) Validate() error {
+	switch {
+	case r.Version == 0:
+		return errors.New("record has no version")
+	case r.Version > Version:
+		return errors.Errorf("record is version %d, newer than the supported version %d", r.Version, Version)
+	}
+	switch r.Result {
+	case WhiteWins, BlackWins, Draw, Unfinished:
+	default:
+		return errors.Errorf("invalid result %q", r.Result)
+	}
+	for i, move := range r.Moves {
+		if move.UCI == "" {
+			return errors.Errorf("move %d has no UCI", i+1)
+		}
+	}
+	return nil
+}
+
+// Write writes the record as a single line of JSON, so that records can be written one after another.
+func (r *Record) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// Read reads a single record.
+func Read(r io.Reader) (*Record, error) {
+	records, err := ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != 1 {
+		return nil, errors.Errorf("expected one record, found %d", len(records))
+	}
+	return records[0], nil
+}
+
+// ReadAll reads every record from a stream of them, such as a file written by selfplay.
+func ReadAll(r io.Reader) ([]*Record, error) {
+	decoder := json.NewDecoder(r)
+	var records []*Record
+	for {
+		var record Record
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read record %d", len(records)+1)
+		}
+		if err := record.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid record %d", len(records)+1)
+		}
+		records = append(records, &record)
+	}
+}
+
+// setTag records something about the game that has no field of its own.
+func (r *Record) setTag(name, value string) {
+	if r.Tags == nil {
+		r.Tags = make(map[string]string)
+	}
+	r.Tags[name] = value
+}
@@ -0,0 +1,130 @@
+package gamerecord
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+const lichessPGN = `[Event "Rated Blitz game"]
+[Site "https://lichess.org/abcd1234"]
+[Date "2021.03.04"]
+[White "alice"]
+[Black "apollo"]
+[Result "0-1"]
+[UTCDate "2021.03.04"]
+[UTCTime "12:30:00"]
+[WhiteElo "1800"]
+[BlackElo "2000"]
+[BlackTitle "BOT"]
+[TimeControl "180+2"]
+[Termination "Normal"]
+[ECO "C20"]
+
+1. f3 { [%eval 0.0] [%clk 0:03:00] } e5 { [%eval 0.3] [%clk 0:03:00] } 2. g4 { [%eval -3.0] [%clk 0:02:58] } Qh4# { [%clk 0:02:59] } 0-1
+`
+
+func TestFromPGN(t *testing.T) {
+	game, err := pgn.Parse(lichessPGN)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	record, err := FromPGN(game)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, record.Validate())
+	assert.Equal(t, "abcd1234", record.ID)
+	assert.True(t, record.Rated)
+	assert.Equal(t, Player{Name: "apollo", Title: "BOT", Rating: 2000}, record.Black)
+	assert.Equal(t, &TimeControl{Initial: 180, Increment: 2}, record.TimeControl)
+	assert.Equal(t, "blitz", record.Speed)
+	assert.Equal(t, time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC), *record.Started)
+	assert.Equal(t, BlackWins, record.Result)
+	assert.Equal(t, "mate", record.Termination)
+	assert.Equal(t, map[string]string{"ECO": "C20"}, record.Tags)
+	assert.Equal(t, []string{"f2f3", "e7e5", "g2g4", "d8h4"}, record.UCI())
+	assert.Equal(t, &Eval{Centipawns: -300}, record.Moves[2].Eval)
+	assert.Nil(t, record.Moves[3].Eval)
+	assert.Equal(t, int64(179000), *record.Moves[3].ClockMs)
+}
+
+func TestPGNRoundTrip(t *testing.T) {
+	game, err := pgn.Parse(lichessPGN)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	record, err := FromPGN(game)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	converted, err := record.PGN()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	reparsed, err := pgn.Parse(converted.String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	again, err := FromPGN(reparsed)
+	if assert.NoError(t, err) {
+		assert.Equal(t, record, again)
+	}
+}
+
+func TestLichessRoundTrip(t *testing.T) {
+	game := blitz.GameSummary{
+		ID:        "abcd1234",
+		Rated:     true,
+		Variant:   "standard",
+		Speed:     "blitz",
+		Status:    "outoftime",
+		Winner:    "white",
+		CreatedAt: 1614861000000,
+		Moves:     "e4 e5 Nf3",
+	}
+	game.Players.White.User = blitz.SummaryUser{ID: "alice", Name: "Alice"}
+	game.Players.White.Rating = 1800
+	record, err := FromLichess(game)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"e2e4", "e7e5", "g1f3"}, record.UCI())
+	assert.Equal(t, WhiteWins, record.Result)
+	assert.Equal(t, "outoftime", record.Termination)
+	assert.Equal(t, Player{Name: "Alice", ID: "alice", Rating: 1800}, record.White)
+
+	exported, err := record.Lichess()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, game.Moves, exported.Moves)
+	assert.Equal(t, game.Winner, exported.Winner)
+	assert.Equal(t, game.CreatedAt, exported.CreatedAt)
+	assert.Contains(t, exported.PGN, `[Termination "Time forfeit"]`)
+}
+
+func TestReadAll(t *testing.T) {
+	var buf bytes.Buffer
+	first, second := New(), New()
+	first.Moves = []Move{{UCI: "e2e4"}}
+	second.Result = Draw
+	assert.NoError(t, first.Write(&buf))
+	assert.NoError(t, second.Write(&buf))
+
+	records, err := ReadAll(&buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*Record{first, second}, records)
+	}
+
+	_, err = ReadAll(strings.NewReader(`{"version": 2, "result": "*"}`))
+	assert.Error(t, err, "newer versions are refused")
+	_, err = ReadAll(strings.NewReader(`{"result": "*"}`))
+	assert.Error(t, err, "records without a version are refused")
+}
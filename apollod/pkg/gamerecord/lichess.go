@@ -0,0 +1,121 @@
+package gamerecord
+
+import (
+	"strings"
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+// FromLichess converts a game from lichess's JSON export into a record. If the export includes the game's PGN, the
+// clocks and evaluations are read from it; otherwise the record only has the moves.
+func FromLichess(game blitz.GameSummary) (*Record, error) {
+	var record *Record
+	if game.PGN != "" {
+		parsed, err := pgn.Parse(game.PGN)
+		if err != nil {
+			return nil, err
+		}
+		if record, err = FromPGN(parsed); err != nil {
+			return nil, err
+		}
+	} else {
+		replayed := &pgn.Game{}
+		if game.InitialFen != "" && game.InitialFen != "startpos" {
+			replayed.Tags.Set("FEN", game.InitialFen)
+		}
+		for _, san := range strings.Fields(game.Moves) {
+			replayed.Moves = append(replayed.Moves, &pgn.Move{SAN: san})
+		}
+		var err error
+		if record, err = FromPGN(replayed); err != nil {
+			return nil, err
+		}
+	}
+
+	// The JSON says more precisely than the PGN who played, how, and how it ended.
+	record.ID = game.ID
+	record.Rated = game.Rated
+	record.Variant = game.Variant
+	record.Speed = game.Speed
+	record.Termination = game.Status
+	record.White = lichessPlayer(game.Players.White)
+	record.Black = lichessPlayer(game.Players.Black)
+	if game.CreatedAt != 0 {
+		started := time.Unix(0, game.CreatedAt*int64(time.Millisecond)).UTC()
+		record.Started = &started
+	}
+	switch {
+	case game.Winner == "white":
+		record.Result = WhiteWins
+	case game.Winner == "black":
+		record.Result = BlackWins
+	case Finished(game.Status):
+		record.Result = Draw
+	default:
+		record.Result = Unfinished
+	}
+	return record, nil
+}
+
+// Lichess converts the record into a game as lichess exports it as JSON, with its PGN included.
+func (r *Record) Lichess() (blitz.GameSummary, error) {
+	game, err := r.PGN()
+	if err != nil {
+		return blitz.GameSummary{}, err
+	}
+	summary := blitz.GameSummary{
+		ID:         r.ID,
+		Rated:      r.Rated,
+		Variant:    r.Variant,
+		Speed:      r.Speed,
+		Status:     r.Termination,
+		InitialFen: r.InitialFEN,
+		PGN:        game.String(),
+	}
+	if summary.Variant == "" {
+		summary.Variant = "standard"
+	}
+	switch r.Result {
+	case WhiteWins:
+		summary.Winner = "white"
+	case BlackWins:
+		summary.Winner = "black"
+	}
+	if summary.Status == "" {
+		summary.Status = "started"
+	}
+	summary.Players.White = summaryPlayer(r.White)
+	summary.Players.Black = summaryPlayer(r.Black)
+	var sans []string
+	for _, move := range game.Moves {
+		sans = append(sans, move.SAN)
+	}
+	summary.Moves = strings.Join(sans, " ")
+	if r.Started != nil {
+		summary.CreatedAt = r.Started.UnixNano() / int64(time.Millisecond)
+	}
+	return summary, nil
+}
+
+func lichessPlayer(player blitz.SummaryPlayer) Player {
+	return Player{Name: player.User.Name, ID: player.User.ID, Title: player.User.Title, Rating: player.Rating}
+}
+
+func summaryPlayer(player Player) blitz.SummaryPlayer {
+	return blitz.SummaryPlayer{
+		User:   blitz.SummaryUser{ID: player.ID, Name: player.Name, Title: player.Title},
+		Rating: player.Rating,
+	}
+}
+
+// Finished returns true if a game with the given lichess status was played to an end, rather than still being played
+// or aborted before it began.
+func Finished(status string) bool {
+	switch status {
+	case "created", "started", "aborted", "noStart", "":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package gamerecord
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+// lichessSite is the start of the Site tag of games played on lichess, which is followed by the game's ID.
+const lichessSite = "https://lichess.org/"
+
+// variantNames are the names that lichess gives variants in the Variant tag of its PGN, by variant key.
+var variantNames = map[string]string{
+	"standard":      "Standard",
+	"fromPosition":  "From Position",
+	"chess960":      "Chess960",
+	"crazyhouse":    "Crazyhouse",
+	"antichess":     "Antichess",
+	"atomic":        "Atomic",
+	"horde":         "Horde",
+	"kingOfTheHill": "King of the Hill",
+	"racingKings":   "Racing Kings",
+	"threeCheck":    "Three-check",
+}
+
+// FromPGN converts a PGN game into a record. Tags that the record has no field for are kept in its Tags.
+func FromPGN(game *pgn.Game) (*Record, error) {
+	uci, err := game.UCI()
+	if err != nil {
+		return nil, err
+	}
+	record := New()
+	record.InitialFEN = game.FEN()
+	record.Result = game.Result
+	if record.Result == "" {
+		record.Result = game.Tags.Get("Result")
+	}
+	if record.Result == "" {
+		record.Result = Unfinished
+	}
+	for i, move := range game.Moves {
+		converted := Move{UCI: uci[i], SAN: move.SAN, Comment: move.Comment}
+		if move.HasClock {
+			clock := int64(move.Clock / time.Millisecond)
+			converted.ClockMs = &clock
+		}
+		if move.HasEval {
+			converted.Eval = &Eval{Centipawns: move.Eval.Centipawns, Mate: move.Eval.Mate}
+		}
+		record.Moves = append(record.Moves, converted)
+	}
+
+	var date, utcDate, utcTime, termination string
+	for _, tag := range game.Tags {
+		value := tag.Value
+		if value == "?" {
+			continue
+		}
+		switch tag.Name {
+		case "Event":
+			record.Event = value
+			record.Rated = strings.HasPrefix(value, "Rated ")
+		case "Site":
+			record.Site = value
+			if record.ID == "" && strings.HasPrefix(value, lichessSite) {
+				record.ID = strings.TrimPrefix(value, lichessSite)
+			}
+		case "GameId":
+			record.ID = value
+		case "White":
+			record.White.Name = value
+		case "Black":
+			record.Black.Name = value
+		case "WhiteElo":
+			record.White.Rating, _ = strconv.Atoi(value)
+		case "BlackElo":
+			record.Black.Rating, _ = strconv.Atoi(value)
+		case "WhiteTitle":
+			record.White.Title = value
+		case "BlackTitle":
+			record.Black.Title = value
+		case "TimeControl":
+			record.TimeControl = parseTimeControl(value)
+		case "Variant":
+			record.Variant = variantKey(value)
+		case "Termination":
+			termination = value
+		case "Date":
+			date = value
+		case "UTCDate":
+			utcDate = value
+		case "UTCTime":
+			utcTime = value
+		case "Result", "SetUp", "FEN":
+			// These are already in the record.
+		default:
+			record.setTag(tag.Name, value)
+		}
+	}
+	if record.Variant == "" && record.InitialFEN != "" {
+		record.Variant = "fromPosition"
+	}
+	if record.TimeControl != nil {
+		record.Speed = speed(*record.TimeControl)
+	}
+	record.Started = parseStart(date, utcDate, utcTime)
+	record.Termination = pgnTermination(game, termination, record.Result)
+	return record, nil
+}
+
+// PGN converts the record into a PGN game. Only variants that play by the rules of chess can be converted, since the
+// moves have to be written in SAN.
+func (r *Record) PGN() (*pgn.Game, error) {
+	game, err := pgn.NewGame(r.InitialFEN, r.UCI())
+	if err != nil {
+		return nil, err
+	}
+	for i, move := range r.Moves {
+		converted := game.Moves[i]
+		converted.Comment = move.Comment
+		if move.ClockMs != nil {
+			converted.Clock, converted.HasClock = time.Duration(*move.ClockMs)*time.Millisecond, true
+		}
+		if move.Eval != nil {
+			converted.Eval, converted.HasEval = engine.Score{Centipawns: move.Eval.Centipawns, Mate: move.Eval.Mate}, true
+		}
+	}
+
+	set := func(name, value string) {
+		if value != "" {
+			game.Tags.Set(name, value)
+		}
+	}
+	set("Event", r.Event)
+	set("Site", r.Site)
+	if r.Site == "" && r.ID != "" {
+		set("Site", lichessSite+r.ID)
+	}
+	if r.Started != nil {
+		started := r.Started.UTC()
+		set("Date", started.Format("2006.01.02"))
+		set("UTCDate", started.Format("2006.01.02"))
+		set("UTCTime", started.Format("15:04:05"))
+	}
+	set("White", r.White.Name)
+	set("Black", r.Black.Name)
+	game.SetResult(r.Result)
+	set("GameId", r.ID)
+	if r.White.Rating > 0 {
+		set("WhiteElo", strconv.Itoa(r.White.Rating))
+	}
+	if r.Black.Rating > 0 {
+		set("BlackElo", strconv.Itoa(r.Black.Rating))
+	}
+	set("WhiteTitle", r.White.Title)
+	set("BlackTitle", r.Black.Title)
+	if r.Variant != "" && r.Variant != "standard" {
+		set("Variant", variantNames[r.Variant])
+	}
+	if r.TimeControl != nil {
+		set("TimeControl", fmt.Sprintf("%d+%d", r.TimeControl.Initial, r.TimeControl.Increment))
+	}
+	set("Termination", terminationTag(r.Termination))
+
+	names := make([]string, 0, len(r.Tags))
+	for name := range r.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		set(name, r.Tags[name])
+	}
+	return game, nil
+}
+
+// parseTimeControl parses a PGN time control of the form initial+increment, in seconds. Anything else, such as "-"
+// for games without a clock, has no time control.
+func parseTimeControl(value string) *TimeControl {
+	parts := strings.SplitN(value, "+", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	initial, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil
+	}
+	increment, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil
+	}
+	return &TimeControl{Initial: initial, Increment: increment}
+}
+
+// speed classifies a time control the way lichess does, by how long a game of forty moves is expected to take.
+func speed(control TimeControl) string {
+	estimate := control.Initial + 40*control.Increment
+	switch {
+	case estimate < 30:
+		return "ultraBullet"
+	case estimate < 180:
+		return "bullet"
+	case estimate < 480:
+		return "blitz"
+	case estimate < 1500:
+		return "rapid"
+	default:
+		return "classical"
+	}
+}
+
+// variantKey returns the variant key for a Variant tag, which may be either lichess's name for the variant or its key.
+func variantKey(name string) string {
+	for key, display := range variantNames {
+		if strings.EqualFold(name, display) || strings.EqualFold(name, key) {
+			return key
+		}
+	}
+	return name
+}
+
+// parseStart works out when a game started from its date tags, preferring the UTC ones that lichess writes.
+func parseStart(date, utcDate, utcTime string) *time.Time {
+	if utcDate != "" {
+		if started, err := time.Parse("2006.01.02 15:04:05", utcDate+" "+utcTime); err == nil {
+			return &started
+		}
+		date = utcDate
+	}
+	if started, err := time.Parse("2006.01.02", date); err == nil {
+		return &started
+	}
+	return nil
+}
+
+// pgnTermination works out how a game ended, as lichess names it, from its Termination tag, which only says whether it
+// ended normally, and failing that from its final position and result.
+func pgnTermination(game *pgn.Game, tag, result string) string {
+	switch strings.ToLower(tag) {
+	case "time forfeit":
+		return "outoftime"
+	case "abandoned":
+		return "timeout"
+	case "rules infraction":
+		return "cheat"
+	}
+	if result == Unfinished {
+		return ""
+	}
+	if board, err := game.Board(); err == nil {
+		switch board.Method() {
+		case chess.Checkmate:
+			return "mate"
+		case chess.Stalemate:
+			return "stalemate"
+		case chess.FivefoldRepetition, chess.SeventyFiveMoveRule, chess.InsufficientMaterial:
+			return "draw"
+		}
+	}
+	if result == Draw {
+		return "draw"
+	}
+	return "resign"
+}
+
+// terminationTag returns the PGN Termination tag for how a game ended.
+func terminationTag(termination string) string {
+	switch termination {
+	case "":
+		return ""
+	case "outoftime":
+		return "Time forfeit"
+	case "timeout":
+		return "Abandoned"
+	case "cheat":
+		return "Rules infraction"
+	case "started", "created":
+		return "Unterminated"
+	default:
+		return "Normal"
+	}
+}
@@ -0,0 +1,211 @@
+// Package logfile writes a long-running process's log to files, in a directory of its own for every run, archiving
+// the current file once it grows too large or too old and pruning archives and runs beyond a limit.
+package logfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// currentName is the file that's being written to in a run's directory. Archives are named for when they were
+	// archived, so they sort oldest first.
+	currentName   = "apollod.log"
+	archivePrefix = "apollod-"
+	archiveSuffix = ".log"
+	// runLayout and archiveLayout name runs and archives by time, without characters that are awkward in file names.
+	runLayout     = "2006-01-02T15-04-05"
+	archiveLayout = "2006-01-02T15-04-05.000"
+)
+
+type Config struct {
+	// Dir is the directory to create a directory of log files in for every run. Empty disables log files.
+	Dir string `yaml:"dir"`
+	// MaxSizeMB is the size in megabytes at which the log file is archived and a new one started. Zero disables
+	// rotating by size.
+	MaxSizeMB int `yaml:"maxSizeMB"`
+	// MaxAge is how long a log file is written to before it's archived and a new one started. Zero disables rotating by
+	// age.
+	MaxAge time.Duration `yaml:"maxAge"`
+	// MaxArchives is the number of archived log files to keep for the current run. Zero keeps all of them.
+	MaxArchives int `yaml:"maxArchives"`
+	// MaxRuns is the number of runs, including the current one, to keep the log directories of. Zero keeps all of them.
+	MaxRuns int `yaml:"maxRuns"`
+}
+
+// DefaultConfig returns a configuration that rotates daily or at 100MB, keeping a week of archives and the last ten
+// runs. It doesn't set a directory, so log files are disabled until one is configured.
+func DefaultConfig() Config {
+	return Config{
+		MaxSizeMB:   100,
+		MaxAge:      24 * time.Hour,
+		MaxArchives: 7,
+		MaxRuns:     10,
+	}
+}
+
+// Writer writes to the current run's log file, rotating it as needed. It's safe to write to from several goroutines.
+type Writer struct {
+	config Config
+	runDir string
+	now    func() time.Time
+
+	lock    sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// Open creates a directory for this run under config.Dir, prunes old runs' directories, and opens a log file in it.
+func Open(config Config) (*Writer, error) {
+	return open(config, time.Now)
+}
+
+func open(config Config, now func() time.Time) (*Writer, error) {
+	if config.Dir == "" {
+		return nil, errors.New("no log directory configured")
+	}
+	w := &Writer{
+		config: config,
+		runDir: filepath.Join(config.Dir, now().Format(runLayout)),
+		now:    now,
+	}
+	if err := os.MkdirAll(w.runDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create log directory")
+	}
+	if err := w.pruneRuns(); err != nil {
+		return nil, err
+	}
+	if err := w.openCurrent(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Dir returns the directory this run's log files are written to.
+func (w *Writer) Dir() string {
+	return w.runDir
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return 0, errors.New("log file is closed")
+	}
+	if w.shouldRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// shouldRotate reports whether the current file should be archived before writing n more bytes to it. A file is
+// never archived empty, so a single write larger than the limit still gets written.
+func (w *Writer) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.config.MaxSizeMB > 0 && w.size+int64(n) > int64(w.config.MaxSizeMB)<<20 {
+		return true
+	}
+	return w.config.MaxAge > 0 && w.now().Sub(w.started) >= w.config.MaxAge
+}
+
+// rotate archives the current file, starts a new one, and prunes the oldest archives.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close log file")
+	}
+	w.file = nil
+	archive := filepath.Join(w.runDir, archivePrefix+w.now().Format(archiveLayout)+archiveSuffix)
+	if err := os.Rename(filepath.Join(w.runDir, currentName), archive); err != nil {
+		return errors.Wrap(err, "failed to archive log file")
+	}
+	if err := w.openCurrent(); err != nil {
+		return err
+	}
+	return w.pruneArchives()
+}
+
+func (w *Writer) openCurrent() error {
+	file, err := os.OpenFile(filepath.Join(w.runDir, currentName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to open log file")
+	}
+	w.file, w.size, w.started = file, info.Size(), w.now()
+	return nil
+}
+
+func (w *Writer) pruneArchives() error {
+	if w.config.MaxArchives <= 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(w.runDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list log files")
+	}
+	var archives []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, archivePrefix) && strings.HasSuffix(name, archiveSuffix) {
+			archives = append(archives, name)
+		}
+	}
+	return removeOldest(w.runDir, archives, w.config.MaxArchives)
+}
+
+func (w *Writer) pruneRuns() error {
+	if w.config.MaxRuns <= 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(w.config.Dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list log directories")
+	}
+	var runs []string
+	for _, entry := range entries {
+		// Leave anything we didn't create alone.
+		if _, err := time.Parse(runLayout, entry.Name()); entry.IsDir() && err == nil {
+			runs = append(runs, entry.Name())
+		}
+	}
+	return removeOldest(w.config.Dir, runs, w.config.MaxRuns)
+}
+
+// removeOldest removes all but the last keep of names, which sort oldest first, from dir.
+func removeOldest(dir string, names []string, keep int) error {
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.RemoveAll(filepath.Join(dir, names[0])); err != nil {
+			return errors.Wrap(err, "failed to remove old logs")
+		}
+		names = names[1:]
+	}
+	return nil
+}
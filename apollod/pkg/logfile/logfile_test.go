@@ -0,0 +1,106 @@
+package logfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is a fake time source that only moves when told to.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "apollod-logfile")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return dir
+}
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRotatesBySize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := &clock{time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	w, err := open(Config{Dir: dir, MaxSizeMB: 1, MaxArchives: 2}, c.now)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer w.Close()
+	assert.Equal(t, filepath.Join(dir, "2020-01-01T12-00-00"), w.Dir())
+
+	line := []byte(strings.Repeat("x", 400<<10) + "\n")
+	for i := 0; i < 8; i++ {
+		c.advance(time.Second)
+		_, err := w.Write(line)
+		assert.NoError(t, err)
+	}
+
+	// Two lines fit in a megabyte, so eight lines make three archives, of which the oldest is pruned.
+	assert.Equal(t, []string{
+		"apollod-2020-01-01T12-00-05.000.log",
+		"apollod-2020-01-01T12-00-07.000.log",
+		"apollod.log",
+	}, listDir(t, w.Dir()))
+	data, err := ioutil.ReadFile(filepath.Join(w.Dir(), "apollod.log"))
+	assert.NoError(t, err)
+	assert.Len(t, data, 2*len(line))
+}
+
+func TestRotatesByAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := &clock{time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	w, err := open(Config{Dir: dir, MaxAge: time.Hour}, c.now)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer w.Close()
+
+	w.Write([]byte("first\n"))
+	c.advance(30 * time.Minute)
+	w.Write([]byte("second\n"))
+	c.advance(30 * time.Minute)
+	w.Write([]byte("third\n"))
+
+	assert.Equal(t, []string{"apollod-2020-01-01T13-00-00.000.log", "apollod.log"}, listDir(t, w.Dir()))
+	data, err := ioutil.ReadFile(filepath.Join(w.Dir(), "apollod.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "third\n", string(data))
+}
+
+func TestPrunesOldRuns(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "keep-me"), 0755))
+
+	c := &clock{time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	for i := 0; i < 4; i++ {
+		w, err := open(Config{Dir: dir, MaxRuns: 2}, c.now)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		w.Close()
+		c.advance(24 * time.Hour)
+	}
+
+	assert.Equal(t, []string{"2020-01-03T12-00-00", "2020-01-04T12-00-00", "keep-me"}, listDir(t, dir))
+}
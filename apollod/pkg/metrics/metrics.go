@@ -0,0 +1,375 @@
+// Package metrics implements a small set of Prometheus-compatible metric types and an HTTP handler that exposes
+// them in the Prometheus text exposition format.
+//
+// Every component of the daemon registers its metrics with the default registry through a Subsystem, so that they
+// are all exposed together and named alike: apollod_<subsystem>_<name>, in base units (seconds, not milliseconds),
+// with counters ending in _total.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Namespace prefixes the name of every metric the daemon exports.
+const Namespace = "apollod"
+
+// DefaultRegistry is the registry that the package-level constructors register metrics with.
+var DefaultRegistry = NewRegistry()
+
+// DefaultLatencyBuckets are histogram buckets, in seconds, suitable for network and engine latencies.
+var DefaultLatencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var (
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry is a collection of metrics that are exposed together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[m.name()]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", m.name()))
+	}
+	r.metrics[m.name()] = m
+}
+
+// Write writes every metric in the registry, in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	var names []string
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Subsystem creates metrics for one component of the daemon in the registry, such as "lichess" or "uci". An empty name
+// places them directly under the namespace.
+func (r *Registry) Subsystem(name string) *Subsystem {
+	prefix := Namespace + "_"
+	if name != "" {
+		prefix += name + "_"
+	}
+	return &Subsystem{registry: r, prefix: prefix}
+}
+
+// ServeHTTP exposes the registry for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// Handler returns an http.Handler serving the default registry.
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+// Subsystem names the metrics of one component of the daemon, prefixing each name with the namespace and the
+// subsystem's name.
+type Subsystem struct {
+	registry *Registry
+	prefix   string
+}
+
+// NewSubsystem creates metrics for one component of the daemon in the default registry.
+func NewSubsystem(name string) *Subsystem {
+	return DefaultRegistry.Subsystem(name)
+}
+
+// NewCounter creates a counter named apollod_<subsystem>_<name>.
+func (s *Subsystem) NewCounter(name, help string, labelNames ...string) *Counter {
+	return s.registry.NewCounter(s.prefix+name, help, labelNames...)
+}
+
+// NewGauge creates a gauge named apollod_<subsystem>_<name>.
+func (s *Subsystem) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return s.registry.NewGauge(s.prefix+name, help, labelNames...)
+}
+
+// NewHistogram creates a histogram named apollod_<subsystem>_<name>.
+func (s *Subsystem) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return s.registry.NewHistogram(s.prefix+name, help, buckets, labelNames...)
+}
+
+// desc holds the parts common to every metric type.
+type desc struct {
+	metricName string
+	help       string
+	labelNames []string
+}
+
+// newDesc describes a metric, panicking if its name or labels aren't valid Prometheus names. Like registering a metric
+// twice, that's a mistake in the program rather than something to handle.
+func newDesc(name, help string, labelNames []string) desc {
+	if !metricNameRegex.MatchString(name) {
+		panic(fmt.Sprintf("invalid metric name %q", name))
+	}
+	for _, label := range labelNames {
+		if !labelNameRegex.MatchString(label) || strings.HasPrefix(label, "__") || label == "le" {
+			panic(fmt.Sprintf("metric %s has invalid label name %q", name, label))
+		}
+	}
+	return desc{metricName: name, help: help, labelNames: labelNames}
+}
+
+func (d *desc) name() string { return d.metricName }
+
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", d.metricName, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (d *desc) writeHeader(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, d.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, kind)
+}
+
+// labels formats a label set, with optional extra label pairs appended.
+func (d *desc) labels(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labelNames) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", d.labelNames[i], value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value, optionally partitioned by labels.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates a counter and registers it with the default registry.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labelNames...)
+}
+
+// NewCounter creates a counter in the registry. Counter names must end in _total.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	if !strings.HasSuffix(name, "_total") {
+		panic(fmt.Sprintf("counter %s must be named *_total", name))
+	}
+	c := &Counter{
+		desc:   newDesc(name, help, labelNames),
+		values: make(map[string]float64),
+	}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by delta, which must not be negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counters cannot decrease")
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labels(key), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge is a value that can go up and down, optionally partitioned by labels.
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge creates a gauge and registers it with the default registry.
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labelNames...)
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{
+		desc:   newDesc(name, help, labelNames),
+		values: make(map[string]float64),
+	}
+	r.register(g)
+	return g
+}
+
+// Set sets the gauge for the given label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+// Add adds delta, which may be negative, to the gauge for the given label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	g.values[key] += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w, "gauge")
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, g.labels(key), formatFloat(g.values[key]))
+	}
+}
+
+// Histogram samples observations into cumulative buckets, optionally partitioned by labels.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with the given upper bucket bounds and registers it with the default registry.
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &Histogram{
+		desc:    newDesc(name, help, labelNames),
+		buckets: sorted,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records a single observation for the given label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// ObserveSince records the time elapsed since start, in seconds, for the given label values.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	var keys []string
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(key, "le", formatFloat(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(key, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labels(key), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labels(key), series.count)
+	}
+}
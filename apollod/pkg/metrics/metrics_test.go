@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("games_total", "Games played.", "result")
+	counter.Inc("win")
+	counter.Inc("win")
+	counter.Inc("loss")
+
+	var buf bytes.Buffer
+	registry.Write(&buf)
+	assert.Equal(t, `# HELP games_total Games played.
+# TYPE games_total counter
+games_total{result="loss"} 1
+games_total{result="win"} 2
+`, buf.String())
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+
+	var buf bytes.Buffer
+	registry.Write(&buf)
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 0.55
+latency_seconds_count 2
+`, buf.String())
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGauge("active_games", "Active games.")
+	assert.Panics(t, func() {
+		registry.NewGauge("active_games", "Active games.")
+	})
+}
+
+func TestSubsystem(t *testing.T) {
+	registry := NewRegistry()
+	registry.Subsystem("uci").NewCounter("searches_total", "Searches.")
+	registry.Subsystem("").NewGauge("games_active", "Active games.")
+
+	var buf bytes.Buffer
+	registry.Write(&buf)
+	assert.Equal(t, `# HELP apollod_games_active Active games.
+# TYPE apollod_games_active gauge
+# HELP apollod_uci_searches_total Searches.
+# TYPE apollod_uci_searches_total counter
+`, buf.String())
+}
+
+func TestInvalidNames(t *testing.T) {
+	registry := NewRegistry()
+	assert.Panics(t, func() { registry.NewGauge("games-active", "Active games.") })
+	assert.Panics(t, func() { registry.NewCounter("games", "Games played.") })
+	assert.Panics(t, func() { registry.NewGauge("latency", "Latency.", "le") })
+	assert.Panics(t, func() { registry.NewGauge("latency", "Latency.", "end point") })
+}
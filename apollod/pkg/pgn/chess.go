@@ -0,0 +1,88 @@
+package pgn
+
+import (
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+)
+
+// NewGame starts a game from a position, or from the standard starting position if fen is empty, and plays moves in
+// UCI notation from it.
+func NewGame(fen string, moves []string) (*Game, error) {
+	board, err := newBoard(fen)
+	if err != nil {
+		return nil, err
+	}
+	game := &Game{Result: Unfinished}
+	if fen != "" {
+		game.Tags.Set("SetUp", "1")
+		game.Tags.Set("FEN", fen)
+	}
+	for _, uci := range moves {
+		position := board.Position()
+		decoded, err := legalMove(position, uci)
+		if err != nil {
+			return nil, err
+		}
+		game.Moves = append(game.Moves, &Move{SAN: chess.AlgebraicNotation{}.Encode(position, decoded)})
+		if err := board.Move(decoded); err != nil {
+			return nil, errors.Wrapf(err, "illegal move %s", uci)
+		}
+	}
+	return game, nil
+}
+
+// Board replays the game's main line, returning the board at its end.
+func (g *Game) Board() (*chess.Game, error) {
+	board, err := newBoard(g.FEN())
+	if err != nil {
+		return nil, err
+	}
+	for i, move := range g.Moves {
+		decoded, err := chess.AlgebraicNotation{}.Decode(board.Position(), move.SAN)
+		if err != nil {
+			return nil, errors.Wrapf(err, "illegal move %d (%s)", i+1, move.SAN)
+		}
+		if err := board.Move(decoded); err != nil {
+			return nil, errors.Wrapf(err, "illegal move %d (%s)", i+1, move.SAN)
+		}
+	}
+	return board, nil
+}
+
+// UCI returns the game's main line in UCI notation.
+func (g *Game) UCI() ([]string, error) {
+	board, err := g.Board()
+	if err != nil {
+		return nil, err
+	}
+	positions := board.Positions()
+	moves := make([]string, len(board.Moves()))
+	for i, move := range board.Moves() {
+		moves[i] = chess.LongAlgebraicNotation{}.Encode(positions[i], move)
+	}
+	return moves, nil
+}
+
+// legalMove finds the legal move from a position that a move in UCI notation stands for. Decoding UCI notation alone
+// doesn't say whether the move gives check, which its SAN needs to know.
+func legalMove(position *chess.Position, uci string) (*chess.Move, error) {
+	notation := chess.LongAlgebraicNotation{}
+	for _, move := range position.ValidMoves() {
+		if notation.Encode(position, move) == uci {
+			return move, nil
+		}
+	}
+	return nil, errors.Errorf("illegal move %s", uci)
+}
+
+// newBoard sets up a board at a position, or at the standard starting position if fen is empty.
+func newBoard(fen string) (*chess.Game, error) {
+	if fen == "" {
+		return chess.NewGame(), nil
+	}
+	position, err := chess.FEN(fen)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid FEN %q", fen)
+	}
+	return chess.NewGame(position), nil
+}
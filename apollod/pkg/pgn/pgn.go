@@ -0,0 +1,103 @@
+// Package pgn reads and writes games in Portable Game Notation: tags, moves in SAN, comments, NAGs, and variations.
+// The [%eval] and [%clk] commands that lichess embeds in comments are parsed out of them into each move's Eval and
+// Clock, and written back the same way, so that a game survives being read and written again unchanged.
+package pgn
+
+import (
+	"strings"
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// The results a game can end with. Unfinished is also used for games whose result is unknown.
+const (
+	WhiteWins  = "1-0"
+	BlackWins  = "0-1"
+	Draw       = "1/2-1/2"
+	Unfinished = "*"
+)
+
+// Game is a single game: its tags, its moves, and its result.
+type Game struct {
+	Tags Tags
+	// Moves is the main line, from the starting position given by the FEN tag or else the standard one.
+	Moves []*Move
+	// Result is the termination marker at the end of the movetext, which should agree with the Result tag.
+	Result string
+}
+
+// Move is a move of a game or variation, with its annotations.
+type Move struct {
+	// SAN is the move in Standard Algebraic Notation, as it was written.
+	SAN string
+	// NAGs are the move's Numeric Annotation Glyphs. Suffixes such as "!?" are read as their NAGs.
+	NAGs []int
+	// Before is a comment that comes before the move, which only happens at the start of a game or variation.
+	Before string
+	// Comment is the comment after the move, less any eval or clock commands.
+	Comment string
+	// Eval is the evaluation of the position after the move, from white's point of view.
+	Eval    engine.Score
+	HasEval bool
+	// Clock is the time the player who moved had left afterwards.
+	Clock    time.Duration
+	HasClock bool
+	// Variations are alternatives to this move, each starting with a move played instead of it.
+	Variations [][]*Move
+}
+
+// Tag is a tag pair, such as [White "apollo"].
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// Tags are a game's tag pairs, in the order they were read or set.
+type Tags []Tag
+
+// Get returns the value of a tag, or "" if the game doesn't have it.
+func (t Tags) Get(name string) string {
+	for _, tag := range t {
+		if tag.Name == name {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// Set sets the value of a tag, adding it if the game doesn't have it yet.
+func (t *Tags) Set(name, value string) {
+	for i := range *t {
+		if (*t)[i].Name == name {
+			(*t)[i].Value = value
+			return
+		}
+	}
+	*t = append(*t, Tag{Name: name, Value: value})
+}
+
+// FEN returns the position the game starts from, or "" for the standard starting position.
+func (g *Game) FEN() string {
+	return g.Tags.Get("FEN")
+}
+
+// SetResult sets both the game's termination marker and its Result tag.
+func (g *Game) SetResult(result string) {
+	g.Result = result
+	g.Tags.Set("Result", result)
+}
+
+// suffixes are the move suffixes that stand for the first six NAGs.
+var suffixes = []string{"", "!", "?", "!!", "??", "!?", "?!"}
+
+// splitSuffix separates a move from its suffix annotation, if it has one.
+func splitSuffix(token string) (string, int) {
+	san := strings.TrimRight(token, "!?")
+	for nag, suffix := range suffixes {
+		if nag > 0 && token[len(san):] == suffix {
+			return san, nag
+		}
+	}
+	return san, 0
+}
@@ -0,0 +1,136 @@
+package pgn
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// lichessExport is a game as lichess exports it, with evals and clocks.
+const lichessExport = `[Event "Rated Blitz game"]
+[Site "https://lichess.org/abcdefgh"]
+[Date "2020.05.01"]
+[Round "-"]
+[White "apollo-bot"]
+[Black "someone"]
+[Result "1-0"]
+[WhiteElo "1900"]
+[BlackElo "1850"]
+[TimeControl "180+2"]
+
+1. e4 { [%eval 0.17] [%clk 0:03:00] } 1... e5 { [%eval 0.2] [%clk 0:03:00] } 2. Qh5 { [%eval -0.5] [%clk 0:02:58.5] } 2... Nc6 { [%eval -0.48] [%clk 0:02:59] } 3. Bc4 { [%eval -0.6] [%clk 0:02:57] } 3... Nf6?? { [%eval #1] [%clk 0:02:55] } 4. Qxf7# { [%clk 0:02:56] } 1-0
+
+`
+
+func TestParseLichessExport(t *testing.T) {
+	game, err := Parse(lichessExport)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "apollo-bot", game.Tags.Get("White"))
+	assert.Equal(t, "180+2", game.Tags.Get("TimeControl"))
+	assert.Equal(t, WhiteWins, game.Result)
+	assert.Len(t, game.Moves, 7)
+
+	nf6 := game.Moves[5]
+	assert.Equal(t, "Nf6", nf6.SAN)
+	assert.Equal(t, []int{4}, nf6.NAGs)
+	assert.True(t, nf6.HasEval)
+	assert.Equal(t, engine.Score{Mate: 1}, nf6.Eval)
+	assert.Equal(t, 175*time.Second, nf6.Clock)
+	assert.Equal(t, 178500*time.Millisecond, game.Moves[2].Clock)
+	assert.Equal(t, engine.Score{Centipawns: -50}, game.Moves[2].Eval)
+	assert.False(t, game.Moves[6].HasEval)
+
+	moves, err := game.UCI()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e2e4", "e7e5", "d1h5", "b8c6", "f1c4", "g8f6", "h5f7"}, moves)
+}
+
+func TestRoundTrip(t *testing.T) {
+	text := `[Event "Casual game"]
+[Site "?"]
+[Date "????.??.??"]
+[Round "?"]
+[White "apollo"]
+[Black "?"]
+[Result "*"]
+[SetUp "1"]
+[FEN "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2"]
+
+{ An open game } 2. Nf3 { [%eval 0.31] the usual } 2... Nc6 $1 (2... d6 {
+Philidor } 3. d4 (3. Bc4) 3... exd4) (2... Nf6 3. Nxe5) 3. Bb5 *
+
+`
+	game, err := Parse(text)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "An open game", game.Moves[0].Before)
+	assert.Equal(t, "the usual", game.Moves[0].Comment)
+	assert.Len(t, game.Moves[1].Variations, 2)
+	assert.Equal(t, "Philidor", game.Moves[1].Variations[0][0].Comment)
+	assert.Len(t, game.Moves[1].Variations[0][1].Variations, 1)
+	assert.Equal(t, text, game.String())
+
+	reparsed, err := Parse(game.String())
+	assert.NoError(t, err)
+	assert.Equal(t, game, reparsed)
+}
+
+func TestReadAll(t *testing.T) {
+	games, err := ReadAll(strings.NewReader(`[Event "one"]
+
+1. e4 e5 1-0
+[Event "two"]
+
+1.d4 d5 2.c4 ; a comment to the end of the line
+dxc4 (2...e6) 0-1
+[Event "three"]
+1. 0-0`))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Len(t, games, 3)
+	assert.Equal(t, "two", games[1].Tags.Get("Event"))
+	assert.Equal(t, "a comment to the end of the line", games[1].Moves[2].Comment)
+	assert.Equal(t, "e6", games[1].Moves[3].Variations[0][0].SAN)
+	assert.Equal(t, BlackWins, games[1].Result)
+	assert.Equal(t, "O-O", games[2].Moves[0].SAN)
+	assert.Equal(t, Unfinished, games[2].Result)
+
+	for _, text := range []string{"1. e4 (e5", "1. e4 { unterminated", "1. e4 ) e5", `[Event "unterminated]`} {
+		_, err := Parse(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestNewGame(t *testing.T) {
+	game, err := NewGame("", []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1b5", "a7a6", "e1g1"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	game.Tags.Set("White", "candidate")
+	game.Moves[0].Eval, game.Moves[0].HasEval = engine.Score{Centipawns: 25}, true
+	game.Moves[0].Clock, game.Moves[0].HasClock = 3*time.Minute+2*time.Second, true
+	game.SetResult(Draw)
+	assert.Equal(t, `[Event "?"]
+[Site "?"]
+[Date "????.??.??"]
+[Round "?"]
+[White "candidate"]
+[Black "?"]
+[Result "1/2-1/2"]
+
+1. e4 { [%eval 0.25] [%clk 0:03:02] } 1... e5 2. Nf3 Nc6 3. Bb5 a6 4. O-O
+1/2-1/2
+
+`, game.String())
+
+	_, err = NewGame("", []string{"e2e5"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,353 @@
+package pgn
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// Reader reads games one at a time from a stream of PGN, so that large collections, such as lichess exports, needn't
+// fit in memory.
+type Reader struct {
+	r    *bufio.Reader
+	line int
+}
+
+// NewReader creates a reader of the games in r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), line: 1}
+}
+
+// Parse parses a single game.
+func Parse(text string) (*Game, error) {
+	game, err := NewReader(strings.NewReader(text)).Read()
+	if err == io.EOF {
+		return nil, errors.New("no game to parse")
+	}
+	return game, err
+}
+
+// ReadAll reads every game in r.
+func ReadAll(r io.Reader) ([]*Game, error) {
+	reader := NewReader(r)
+	var games []*Game
+	for {
+		game, err := reader.Read()
+		if err == io.EOF {
+			return games, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+}
+
+// Read reads the next game. It returns io.EOF when there are no more games.
+func (r *Reader) Read() (*Game, error) {
+	game := &Game{}
+	for {
+		c, err := r.peek()
+		if err == io.EOF {
+			if len(game.Tags) == 0 {
+				return nil, io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == '%' {
+			r.skipLine()
+			continue
+		}
+		if c != '[' {
+			break
+		}
+		r.next()
+		tag, err := r.readTag()
+		if err != nil {
+			return nil, err
+		}
+		game.Tags = append(game.Tags, tag)
+	}
+
+	moves, result, err := r.readLine(0)
+	if err != nil {
+		return nil, err
+	}
+	game.Moves = moves
+	game.Result = result
+	if game.Result == "" {
+		game.Result = Unfinished
+		if tag := game.Tags.Get("Result"); tag != "" {
+			game.Result = tag
+		}
+	}
+	return game, nil
+}
+
+// readLine reads the moves of the main line, or of a variation if depth is greater than zero, up to the result that
+// ends the game or the parenthesis that ends the variation.
+func (r *Reader) readLine(depth int) ([]*Move, string, error) {
+	var moves []*Move
+	var before string
+	comment := func(text string) {
+		if len(moves) == 0 {
+			before = joinComments(before, strings.TrimSpace(text))
+			return
+		}
+		moves[len(moves)-1].annotate(text)
+	}
+
+	for {
+		c, err := r.peek()
+		if err == io.EOF {
+			if depth > 0 {
+				return nil, "", r.errorf("unterminated variation")
+			}
+			return moves, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch c {
+		case '[':
+			// A game without a result runs straight into the tags of the next one.
+			if depth > 0 {
+				return nil, "", r.errorf("unterminated variation")
+			}
+			return moves, "", nil
+		case '%':
+			r.skipLine()
+			continue
+		}
+
+		r.next()
+		switch {
+		case c == '{':
+			text, err := r.readUntil('}')
+			if err != nil {
+				return nil, "", r.errorf("unterminated comment")
+			}
+			comment(text)
+		case c == ';':
+			text, _ := r.readUntil('\n')
+			comment(text)
+		case c == '(':
+			if len(moves) == 0 {
+				return nil, "", r.errorf("variation before any move")
+			}
+			variation, _, err := r.readLine(depth + 1)
+			if err != nil {
+				return nil, "", err
+			}
+			last := moves[len(moves)-1]
+			last.Variations = append(last.Variations, variation)
+		case c == ')':
+			if depth == 0 {
+				return nil, "", r.errorf("unexpected )")
+			}
+			return moves, "", nil
+		case c == '$':
+			digits := r.readWhile(unicode.IsDigit)
+			nag, err := strconv.Atoi(digits)
+			if err != nil || len(moves) == 0 {
+				return nil, "", r.errorf("invalid NAG $%s", digits)
+			}
+			last := moves[len(moves)-1]
+			last.NAGs = append(last.NAGs, nag)
+		case isSymbol(c):
+			token := string(c) + r.readWhile(isSymbol)
+			switch token {
+			case WhiteWins, BlackWins, Draw, Unfinished:
+				if depth > 0 {
+					return nil, "", r.errorf("result inside a variation")
+				}
+				return moves, token, nil
+			}
+			// Castling is sometimes written with zeros, which mustn't be mistaken for a move number.
+			if strings.HasPrefix(token, "0-0") {
+				token = strings.Replace(token, "0", "O", -1)
+			}
+			// Move numbers are implied by the moves' order, and may run straight into the move, as in "1.e4".
+			token = strings.TrimLeft(token, "0123456789")
+			token = strings.TrimLeft(token, ".")
+			if token == "" {
+				continue
+			}
+			san, nag := splitSuffix(token)
+			move := &Move{SAN: san, Before: before}
+			before = ""
+			if nag > 0 {
+				move.NAGs = append(move.NAGs, nag)
+			}
+			moves = append(moves, move)
+		default:
+			return nil, "", r.errorf("unexpected %q", c)
+		}
+	}
+}
+
+func (r *Reader) readTag() (Tag, error) {
+	r.readWhile(unicode.IsSpace)
+	name := r.readWhile(func(c rune) bool { return !unicode.IsSpace(c) && c != '"' && c != ']' })
+	r.readWhile(unicode.IsSpace)
+	if c, err := r.next(); err != nil || c != '"' || name == "" {
+		return Tag{}, r.errorf("malformed tag")
+	}
+	var value strings.Builder
+	for {
+		c, err := r.next()
+		if err != nil {
+			return Tag{}, r.errorf("unterminated tag value")
+		}
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			if c, err = r.next(); err != nil {
+				return Tag{}, r.errorf("unterminated tag value")
+			}
+		}
+		value.WriteRune(c)
+	}
+	if _, err := r.readUntil(']'); err != nil {
+		return Tag{}, r.errorf("unterminated tag")
+	}
+	return Tag{Name: name, Value: value.String()}, nil
+}
+
+func (r *Reader) next() (rune, error) {
+	c, _, err := r.r.ReadRune()
+	if c == '\n' {
+		r.line++
+	}
+	return c, err
+}
+
+func (r *Reader) peek() (rune, error) {
+	for {
+		c, _, err := r.r.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(c) {
+			return c, r.r.UnreadRune()
+		}
+		if c == '\n' {
+			r.line++
+		}
+	}
+}
+
+// readWhile reads runes for as long as they match.
+func (r *Reader) readWhile(match func(rune) bool) string {
+	var text strings.Builder
+	for {
+		c, _, err := r.r.ReadRune()
+		if err != nil {
+			return text.String()
+		}
+		if !match(c) {
+			r.r.UnreadRune()
+			return text.String()
+		}
+		if c == '\n' {
+			r.line++
+		}
+		text.WriteRune(c)
+	}
+}
+
+// readUntil reads up to and including end, returning what came before it.
+func (r *Reader) readUntil(end rune) (string, error) {
+	text := r.readWhile(func(c rune) bool { return c != end })
+	_, err := r.next()
+	return text, err
+}
+
+func (r *Reader) skipLine() {
+	r.readUntil('\n')
+}
+
+func (r *Reader) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("pgn: line %d: %s", r.line, errors.Errorf(format, args...))
+}
+
+func isSymbol(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_+#=:-/.*!?", c)
+}
+
+// commandPattern matches the commands embedded in comments, such as [%clk 0:03:00].
+var commandPattern = regexp.MustCompile(`\[%(\w+)\s+([^\]]*)\]`)
+
+// annotate adds a comment to the move, taking any eval and clock commands out of it.
+func (m *Move) annotate(text string) {
+	text = commandPattern.ReplaceAllStringFunc(text, func(command string) string {
+		parts := commandPattern.FindStringSubmatch(command)
+		switch parts[1] {
+		case "eval":
+			if score, ok := parseEval(parts[2]); ok {
+				m.Eval, m.HasEval = score, true
+				return ""
+			}
+		case "clk":
+			if clock, ok := parseClock(parts[2]); ok {
+				m.Clock, m.HasClock = clock, true
+				return ""
+			}
+		}
+		return command
+	})
+	m.Comment = joinComments(m.Comment, strings.Join(strings.Fields(text), " "))
+}
+
+func joinComments(first, second string) string {
+	if first == "" || second == "" {
+		return first + second
+	}
+	return first + " " + second
+}
+
+// parseEval parses an evaluation in pawns, or a mate in some number of moves such as "#-3". Evaluations that carry
+// the depth they were searched to are left alone, since Score has nowhere to put it.
+func parseEval(text string) (engine.Score, bool) {
+	if strings.HasPrefix(text, "#") {
+		mate, err := strconv.Atoi(text[1:])
+		return engine.Score{Mate: mate}, err == nil && mate != 0
+	}
+	pawns, err := strconv.ParseFloat(text, 64)
+	if err != nil || strings.Contains(text, ",") {
+		return engine.Score{}, false
+	}
+	if pawns < 0 {
+		return engine.Score{Centipawns: int(pawns*100 - 0.5)}, true
+	}
+	return engine.Score{Centipawns: int(pawns*100 + 0.5)}, true
+}
+
+// parseClock parses a clock time as H:MM:SS, with optional fractions of a second.
+func parseClock(text string) (time.Duration, bool) {
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)+0.5), true
+}
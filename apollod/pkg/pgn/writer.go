@@ -0,0 +1,197 @@
+package pgn
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// lineWidth is the width that movetext is wrapped to, as the PGN standard recommends.
+const lineWidth = 80
+
+// rosterTags are the Seven Tag Roster, which come first in every game, in this order, whether or not they're set.
+var rosterTags = []struct{ name, unknown string }{
+	{"Event", "?"},
+	{"Site", "?"},
+	{"Date", "????.??.??"},
+	{"Round", "?"},
+	{"White", "?"},
+	{"Black", "?"},
+	{"Result", Unfinished},
+}
+
+// String returns the game in PGN.
+func (g *Game) String() string {
+	var text strings.Builder
+	g.Write(&text)
+	return text.String()
+}
+
+// Write writes the game in PGN, followed by a blank line so that games can be written one after another.
+func (g *Game) Write(w io.Writer) error {
+	var text strings.Builder
+	for _, roster := range rosterTags {
+		value := g.Tags.Get(roster.name)
+		if value == "" {
+			value = roster.unknown
+			if roster.name == "Result" && g.Result != "" {
+				value = g.Result
+			}
+		}
+		writeTag(&text, roster.name, value)
+	}
+	for _, tag := range g.Tags {
+		if !isRosterTag(tag.Name) {
+			writeTag(&text, tag.Name, tag.Value)
+		}
+	}
+	text.WriteString("\n")
+
+	movetext := &wrapper{}
+	movetext.writeLine(g.Moves, g.startingPly(), true)
+	result := g.Result
+	if result == "" {
+		result = Unfinished
+	}
+	movetext.word(result)
+	text.WriteString(movetext.String())
+	text.WriteString("\n\n")
+
+	_, err := io.WriteString(w, text.String())
+	return err
+}
+
+func writeTag(text *strings.Builder, name, value string) {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	fmt.Fprintf(text, "[%s \"%s\"]\n", name, value)
+}
+
+func isRosterTag(name string) bool {
+	for _, roster := range rosterTags {
+		if roster.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// startingPly is the number of half-moves played before the game's first move, going by its FEN tag.
+func (g *Game) startingPly() int {
+	fields := strings.Fields(g.FEN())
+	if len(fields) < 6 {
+		return 0
+	}
+	moveNumber, err := strconv.Atoi(fields[5])
+	if err != nil || moveNumber < 1 {
+		moveNumber = 1
+	}
+	ply := 2 * (moveNumber - 1)
+	if fields[1] == "b" {
+		ply++
+	}
+	return ply
+}
+
+// wrapper lays out movetext, one word at a time, in lines no wider than lineWidth.
+type wrapper struct {
+	lines   []string
+	current strings.Builder
+	// prefix is written straight before the next word, such as the parenthesis that opens a variation.
+	prefix string
+}
+
+func (w *wrapper) word(word string) {
+	word, w.prefix = w.prefix+word, ""
+	if w.current.Len() > 0 && w.current.Len()+1+len(word) > lineWidth {
+		w.lines = append(w.lines, w.current.String())
+		w.current.Reset()
+	}
+	if w.current.Len() > 0 {
+		w.current.WriteString(" ")
+	}
+	w.current.WriteString(word)
+}
+
+// comment writes a comment, which may have to be split across lines.
+func (w *wrapper) comment(text string) {
+	w.word("{")
+	for _, word := range strings.Fields(text) {
+		w.word(word)
+	}
+	w.word("}")
+}
+
+func (w *wrapper) String() string {
+	return strings.Join(append(w.lines, w.current.String()), "\n")
+}
+
+// writeLine writes a line of moves starting ply half-moves into the game. Black's moves are numbered when they start a
+// line or follow a comment or variation, so that it's clear where they belong.
+func (w *wrapper) writeLine(moves []*Move, ply int, numberBlack bool) {
+	for _, move := range moves {
+		if move.Before != "" {
+			w.comment(move.Before)
+			numberBlack = true
+		}
+		if ply%2 == 0 {
+			w.word(fmt.Sprintf("%d.", ply/2+1))
+		} else if numberBlack {
+			w.word(fmt.Sprintf("%d...", ply/2+1))
+		}
+		w.word(move.SAN)
+		for _, nag := range move.NAGs {
+			w.word("$" + strconv.Itoa(nag))
+		}
+
+		numberBlack = false
+		var commands []string
+		if move.HasEval {
+			commands = append(commands, "[%eval "+formatEval(move.Eval)+"]")
+		}
+		if move.HasClock {
+			commands = append(commands, "[%clk "+formatClock(move.Clock)+"]")
+		}
+		if move.Comment != "" {
+			commands = append(commands, move.Comment)
+		}
+		if len(commands) > 0 {
+			w.comment(strings.Join(commands, " "))
+			numberBlack = true
+		}
+		for _, variation := range move.Variations {
+			if len(variation) == 0 {
+				continue
+			}
+			w.prefix = "("
+			w.writeLine(variation, ply, true)
+			w.current.WriteString(")")
+			numberBlack = true
+		}
+		ply++
+	}
+}
+
+func formatEval(score engine.Score) string {
+	if score.Mate != 0 {
+		return fmt.Sprintf("#%d", score.Mate)
+	}
+	return fmt.Sprintf("%.2f", float64(score.Centipawns)/100)
+}
+
+// formatClock formats a clock time as H:MM:SS, with tenths of a second if there are any.
+func formatClock(clock time.Duration) string {
+	clock = clock.Round(100 * time.Millisecond)
+	hours := int(clock / time.Hour)
+	minutes := int(clock / time.Minute % 60)
+	seconds := clock % time.Minute
+	text := fmt.Sprintf("%d:%02d:%02d", hours, minutes, int(seconds/time.Second))
+	if tenths := int(seconds % time.Second / (100 * time.Millisecond)); tenths > 0 {
+		text += fmt.Sprintf(".%d", tenths)
+	}
+	return text
+}
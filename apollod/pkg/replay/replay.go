@@ -0,0 +1,175 @@
+// Package replay replays finished games through an engine, move by move, and annotates the moves that gave away the
+// most, as an automated post-mortem of the game.
+package replay
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+const (
+	// nagMistake and nagBlunder are the NAGs written as "?" and "??".
+	nagMistake = 2
+	nagBlunder = 4
+
+	// maxCentipawns caps evaluations when judging moves, so that a move can't lose much in a position that was already
+	// won or lost. Mates count as this much.
+	maxCentipawns = 1000
+
+	// variationPly is the number of plies of the engine's line given for a mistake or blunder.
+	variationPly = 6
+)
+
+// Options controls how deep games are replayed and which moves are annotated.
+type Options struct {
+	// Depth is the depth the engine searches every position to.
+	Depth int
+	// Mistake and Blunder are how many centipawns a move must lose, by the engine's evaluation, to be annotated as a
+	// mistake or a blunder.
+	Mistake int
+	Blunder int
+}
+
+// DefaultOptions are the options used by apollod replay unless told otherwise.
+var DefaultOptions = Options{Depth: 14, Mistake: 100, Blunder: 300}
+
+// Summary counts the mistakes and blunders that each side made.
+type Summary struct {
+	WhiteMistakes, WhiteBlunders int
+	BlackMistakes, BlackBlunders int
+}
+
+// Replay searches every position of a game's main line with an engine and annotates the game in place: every move is
+// given the engine's evaluation of the position after it, and mistakes and blunders are given a NAG, a comment, and
+// the line the engine preferred as a variation.
+func Replay(client engine.Engine, game *pgn.Game, options Options) (Summary, error) {
+	var summary Summary
+	if options.Depth <= 0 {
+		return summary, errors.New("replays need a search depth")
+	}
+	board, err := game.Board()
+	if err != nil {
+		return summary, err
+	}
+	positions := board.Positions()
+	moves := make([]string, len(board.Moves()))
+	for i, move := range board.Moves() {
+		moves[i] = chess.LongAlgebraicNotation{}.Encode(positions[i], move)
+	}
+
+	if err := client.NewGame(false); err != nil {
+		return summary, errors.Wrap(err, "failed to reset engine")
+	}
+	evaluations := make([]evaluation, len(positions))
+	for i, position := range positions {
+		if evaluations[i], err = evaluate(client, game.FEN(), moves[:i], position, options.Depth); err != nil {
+			return summary, errors.Wrapf(err, "failed to search position after ply %d", i)
+		}
+	}
+
+	for i, move := range game.Moves {
+		before, after := evaluations[i], evaluations[i+1]
+		if after.searched {
+			move.Eval, move.HasEval = after.score, true
+		}
+
+		// Both evaluations are from white's point of view, so black loses what white gains.
+		lost := before.centipawns() - after.centipawns()
+		white := positions[i].Turn() == chess.White
+		if !white {
+			lost = -lost
+		}
+		if lost < options.Mistake || len(before.line) == 0 || before.line[0] == moves[i] {
+			continue
+		}
+
+		judgment, nag := "Mistake", nagMistake
+		if lost >= options.Blunder {
+			judgment, nag = "Blunder", nagBlunder
+		}
+		switch {
+		case white && nag == nagBlunder:
+			summary.WhiteBlunders++
+		case white:
+			summary.WhiteMistakes++
+		case nag == nagBlunder:
+			summary.BlackBlunders++
+		default:
+			summary.BlackMistakes++
+		}
+
+		move.NAGs = append(move.NAGs, nag)
+		line := before.line
+		if len(line) > variationPly {
+			line = line[:variationPly]
+		}
+		variation, err := pgn.NewGame(positions[i].String(), line)
+		if err != nil {
+			// The engine's line is only advice; a best move that doesn't follow from the position is left out.
+			continue
+		}
+		note := fmt.Sprintf("%s. %s was best.", judgment, variation.Moves[0].SAN)
+		move.Comment = strings.TrimSpace(move.Comment + " " + note)
+		move.Variations = append(move.Variations, variation.Moves)
+	}
+	game.Tags.Set("Annotator", "apollod")
+	return summary, nil
+}
+
+// evaluation is the engine's evaluation of a position, from white's point of view, and the line it would play.
+type evaluation struct {
+	score    engine.Score
+	searched bool
+	line     []string
+}
+
+// centipawns is the evaluation in centipawns, capped at maxCentipawns either way.
+func (e evaluation) centipawns() int {
+	switch {
+	case e.score.Mate > 0:
+		return maxCentipawns
+	case e.score.Mate < 0:
+		return -maxCentipawns
+	case e.score.Centipawns > maxCentipawns:
+		return maxCentipawns
+	case e.score.Centipawns < -maxCentipawns:
+		return -maxCentipawns
+	}
+	return e.score.Centipawns
+}
+
+// evaluate searches a position, which is reached from fen by moves, to depth. Positions that the game ends in are
+// judged without a search.
+func evaluate(client engine.Engine, fen string, moves []string, position *chess.Position, depth int) (evaluation, error) {
+	sign := 1
+	if position.Turn() == chess.Black {
+		sign = -1
+	}
+	switch position.Status() {
+	case chess.Checkmate:
+		return evaluation{score: engine.Score{Centipawns: -sign * maxCentipawns}}, nil
+	case chess.Stalemate:
+		return evaluation{}, nil
+	}
+
+	if err := client.SetPosition(fen, moves); err != nil {
+		return evaluation{}, err
+	}
+	bestmove, err := client.Search(engine.Limits{Depth: depth})
+	if err != nil {
+		return evaluation{}, err
+	}
+	info := client.Info()
+	line := info.PV
+	if len(line) == 0 || line[0] != bestmove {
+		line = []string{bestmove}
+	}
+	score := engine.Score{Centipawns: sign * info.Score.Centipawns, Mate: sign * info.Score.Mate}
+	return evaluation{score: score, searched: info.HasScore, line: line}, nil
+}
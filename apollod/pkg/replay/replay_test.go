@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+func TestReplayFindsBlunder(t *testing.T) {
+	game, err := pgn.NewGame("", []string{"e2e4", "e7e5", "d1h5", "b8c6", "f1c4", "g8f6", "h5f7"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// The engine sees mate once black has played Nf6, and would have defended with Qe7 instead.
+	fake := &ucitest.Engine{
+		BestMove: func(position string, moves []string) string {
+			if len(moves) == 5 {
+				return "d8e7"
+			}
+			return ucitest.FirstLegalMove(position, moves)
+		},
+		Score: func(position string, moves []string) engine.Score {
+			if len(moves) == 6 {
+				return engine.Score{Mate: 1}
+			}
+			return engine.Score{}
+		},
+	}
+	client, err := uci.NewClient(fake)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+
+	summary, err := Replay(client, game, Options{Depth: 8, Mistake: 100, Blunder: 300})
+	assert.NoError(t, err)
+	assert.Equal(t, Summary{BlackBlunders: 1}, summary)
+	assert.Contains(t, fake.Commands(), "go depth 8")
+
+	blunder := game.Moves[5]
+	assert.Equal(t, []int{nagBlunder}, blunder.NAGs)
+	assert.Equal(t, "Blunder. Qe7 was best.", blunder.Comment)
+	if assert.Len(t, blunder.Variations, 1) {
+		assert.Equal(t, "Qe7", blunder.Variations[0][0].SAN)
+	}
+	assert.Equal(t, engine.Score{Mate: 1}, blunder.Eval)
+	assert.True(t, game.Moves[0].HasEval)
+	assert.False(t, game.Moves[6].HasEval, "the game ends in mate, so its last position isn't searched")
+	assert.Empty(t, game.Moves[6].NAGs)
+	assert.Equal(t, "apollod", game.Tags.Get("Annotator"))
+	assert.True(t, strings.Contains(game.String(), "3... Nf6 $4"))
+}
+
+func TestReplayNeedsDepth(t *testing.T) {
+	client, err := uci.NewClient(&ucitest.Engine{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+	_, err = Replay(client, &pgn.Game{}, Options{})
+	assert.Error(t, err)
+}
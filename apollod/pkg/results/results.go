@@ -0,0 +1,192 @@
+// Package results records the outcome of every game apollod plays and summarizes them into performance reports.
+package results
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is the outcome of a single game.
+type Record struct {
+	Time           time.Time `json:"time"`
+	GameID         string    `json:"gameId"`
+	Opponent       string    `json:"opponent"`
+	OpponentRating int       `json:"opponentRating"`
+	OpponentTitle  string    `json:"opponentTitle,omitempty"`
+	Color          string    `json:"color"`
+	Result         string    `json:"result"`
+	Termination    string    `json:"termination"`
+	Speed          string    `json:"speed"`
+	Rated          bool      `json:"rated"`
+	// Build is the engine build that played the game, if a canary was splitting games between two builds.
+	Build string `json:"build,omitempty"`
+}
+
+// IsBot returns true if the opponent in this game was a bot account.
+func (r Record) IsBot() bool {
+	return r.OpponentTitle == "BOT"
+}
+
+// Recorder is somewhere that records can be written and read back.
+type Recorder interface {
+	Append(record Record) error
+	// Since returns every record from at or after since.
+	Since(since time.Time) ([]Record, error)
+}
+
+// Store is an append-only file of records, one JSON object per line.
+type Store struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Append adds a record to the store.
+func (s *Store) Append(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Since returns every record of a game that finished at or after the given time.
+func (s *Store) Since(since time.Time) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Tally counts the results of a set of games.
+type Tally struct {
+	Games  int
+	Wins   int
+	Losses int
+	Draws  int
+
+	opponentRatings int
+}
+
+func (t *Tally) add(record Record) {
+	switch record.Result {
+	case "win":
+		t.Wins++
+	case "loss":
+		t.Losses++
+	case "draw":
+		t.Draws++
+	default:
+		// Aborted and unfinished games don't count.
+		return
+	}
+	t.Games++
+	t.opponentRatings += record.OpponentRating
+}
+
+// Score is the fraction of available points that were won, between 0 and 1.
+func (t Tally) Score() float64 {
+	if t.Games == 0 {
+		return 0
+	}
+	return (float64(t.Wins) + float64(t.Draws)/2) / float64(t.Games)
+}
+
+// PerformanceRating estimates the rating at which these results would be expected, using the linear approximation
+// of average opponent rating plus 400 times the win-loss difference per game.
+func (t Tally) PerformanceRating() int {
+	if t.Games == 0 {
+		return 0
+	}
+	return (t.opponentRatings + 400*(t.Wins-t.Losses)) / t.Games
+}
+
+func (t Tally) String() string {
+	return fmt.Sprintf("%d games, +%d -%d =%d, %.1f%%, performance %d",
+		t.Games, t.Wins, t.Losses, t.Draws, 100*t.Score(), t.PerformanceRating())
+}
+
+// Summary is a performance report over a period of time.
+type Summary struct {
+	Since    time.Time
+	Overall  Tally
+	VsBots   Tally
+	VsHumans Tally
+	// Builds tallies the games played while a canary was running by the engine build that played them.
+	Builds map[string]Tally
+}
+
+// Summarize produces a summary of the given records.
+func Summarize(since time.Time, records []Record) Summary {
+	summary := Summary{Since: since}
+	for _, record := range records {
+		summary.Overall.add(record)
+		if record.IsBot() {
+			summary.VsBots.add(record)
+		} else {
+			summary.VsHumans.add(record)
+		}
+		if record.Build != "" {
+			if summary.Builds == nil {
+				summary.Builds = make(map[string]Tally)
+			}
+			tally := summary.Builds[record.Build]
+			tally.add(record)
+			summary.Builds[record.Build] = tally
+		}
+	}
+	return summary
+}
+
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Results since %s\n", s.Since.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&b, "Overall:   %s\n", s.Overall)
+	fmt.Fprintf(&b, "vs bots:   %s\n", s.VsBots)
+	fmt.Fprintf(&b, "vs humans: %s\n", s.VsHumans)
+	builds := make([]string, 0, len(s.Builds))
+	for build := range s.Builds {
+		builds = append(builds, build)
+	}
+	sort.Strings(builds)
+	for _, build := range builds {
+		fmt.Fprintf(&b, "build %s: %s\n", build, s.Builds[build])
+	}
+	return b.String()
+}
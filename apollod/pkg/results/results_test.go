@@ -0,0 +1,62 @@
+package results
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apollod-results")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	store := &Store{Path: filepath.Join(dir, "results.jsonl")}
+	now := time.Now()
+	assert.NoError(t, store.Append(Record{Time: now.Add(-48 * time.Hour), GameID: "old", Result: "loss"}))
+	assert.NoError(t, store.Append(Record{Time: now, GameID: "new", Result: "win"}))
+
+	records, err := store.Since(now.Add(-24 * time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "new", records[0].GameID)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	records := []Record{
+		{Result: "win", OpponentRating: 1500, OpponentTitle: "BOT"},
+		{Result: "loss", OpponentRating: 1700},
+		{Result: "draw", OpponentRating: 1600},
+		{Result: "aborted", OpponentRating: 2000},
+	}
+
+	summary := Summarize(time.Now(), records)
+	assert.Equal(t, 3, summary.Overall.Games)
+	assert.Equal(t, 0.5, summary.Overall.Score())
+	assert.Equal(t, 1600, summary.Overall.PerformanceRating())
+	assert.Equal(t, 1, summary.VsBots.Games)
+	assert.Equal(t, 2, summary.VsHumans.Games)
+	assert.Nil(t, summary.Builds)
+}
+
+func TestSummarizeBuilds(t *testing.T) {
+	records := []Record{
+		{Result: "win", OpponentRating: 1500, Build: "baseline"},
+		{Result: "loss", OpponentRating: 1500, Build: "baseline"},
+		{Result: "win", OpponentRating: 1500, Build: "v2"},
+		{Result: "win", OpponentRating: 1500},
+	}
+
+	summary := Summarize(time.Now(), records)
+	assert.Equal(t, 4, summary.Overall.Games)
+	assert.Equal(t, 2, summary.Builds["baseline"].Games)
+	assert.Equal(t, 1.0, summary.Builds["v2"].Score())
+	assert.Contains(t, summary.String(), "build baseline: 2 games, +1 -1 =0, 50.0%, performance 1500\nbuild v2: 1 games")
+}
@@ -0,0 +1,93 @@
+package selfplay
+
+import (
+	"github.com/notnil/chess"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// mateCentipawns is what a forced mate counts for when adjudicating, which is more than any score an engine gives
+// short of one.
+const mateCentipawns = 100000
+
+// Adjudication ends games that both engines agree are decided, so that matches don't spend most of their time
+// playing out foregone conclusions. The zero value never adjudicates.
+type Adjudication struct {
+	// ResignMoves, if set, gives the game to a side once both engines have scored it at least ResignScore
+	// centipawns ahead for ResignMoves moves each in a row.
+	ResignScore int
+	ResignMoves int
+	// DrawMoves, if set, draws the game once both engines have scored it within DrawScore centipawns of even for
+	// DrawMoves moves each in a row, from move DrawAfter on.
+	DrawScore int
+	DrawMoves int
+	DrawAfter int
+}
+
+// adjudicate returns the outcome of a game that the engines agree is decided, given their searches so far, or
+// NoOutcome if they don't. Only the engines' latest moves count, and a move that an engine didn't score breaks the run.
+func (a Adjudication) adjudicate(searches []search) chess.Outcome {
+	if a.ResignMoves > 0 {
+		if scores, ok := lastScores(searches, 2*a.ResignMoves); ok {
+			white, black := true, true
+			for _, score := range scores {
+				white = white && score >= a.ResignScore
+				black = black && score <= -a.ResignScore
+			}
+			if white {
+				return chess.WhiteWon
+			}
+			if black {
+				return chess.BlackWon
+			}
+		}
+	}
+	if a.DrawMoves > 0 && len(searches) > 0 && searches[len(searches)-1].ply/2+1 >= a.DrawAfter {
+		if scores, ok := lastScores(searches, 2*a.DrawMoves); ok {
+			even := true
+			for _, score := range scores {
+				even = even && score <= a.DrawScore && score >= -a.DrawScore
+			}
+			if even {
+				return chess.Draw
+			}
+		}
+	}
+	return chess.NoOutcome
+}
+
+// lastScores returns the scores of the last n moves, in centipawns from white's point of view, if the engines scored
+// all of them and they were played one after another.
+func lastScores(searches []search, n int) ([]int, bool) {
+	if len(searches) < n {
+		return nil, false
+	}
+	recent := searches[len(searches)-n:]
+	if recent[n-1].ply-recent[0].ply != n-1 {
+		return nil, false
+	}
+	scores := make([]int, n)
+	for i, search := range recent {
+		if !search.info.HasScore {
+			return nil, false
+		}
+		scores[i] = centipawns(search.info.Score)
+		// Engines score positions for the side that moved.
+		if search.ply%2 == 1 {
+			scores[i] = -scores[i]
+		}
+	}
+	return scores, true
+}
+
+// centipawns is a score in centipawns, counting mates as more than anything else.
+func centipawns(score engine.Score) int {
+	switch {
+	case score.Mate > 0:
+		return mateCentipawns
+	case score.Mate < 0:
+		return -mateCentipawns
+	default:
+		return score.Centipawns
+	}
+}
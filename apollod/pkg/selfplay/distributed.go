@@ -0,0 +1,370 @@
+package selfplay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+)
+
+const defaultLeaseTimeout = 30 * time.Minute
+
+// Assignment is a single game handed out by a Coordinator to a Worker.
+type Assignment struct {
+	Game            int  `json:"game"`
+	BaselineIsWhite bool `json:"baselineIsWhite"`
+	// Opening is the moves that the game starts with, in UCI notation.
+	Opening []string `json:"opening,omitempty"`
+}
+
+// Report is sent by a Worker to a Coordinator once an assigned game completes.
+type Report struct {
+	Game    int     `json:"game"`
+	Outcome Outcome `json:"outcome"`
+}
+
+// Coordinator owns the schedule and the running score of a distributed selfplay run. Workers, possibly on
+// other machines, lease games from the coordinator over HTTP and report their outcomes back.
+//
+// A game that is leased and not reported within LeaseTimeout is handed out again, so a worker that dies mid-game
+// does not stall the run.
+type Coordinator struct {
+	NumGames     int
+	LeaseTimeout time.Duration
+	// Openings, if set, starts games with moves picked from the book, up to OpeningPly plies. Games are played in
+	// pairs from the same opening, with the engines swapping colors, so that neither is favored by the book.
+	Openings   *book.Book
+	OpeningPly int
+	// SPRT, if set, ends the run as soon as the test reaches a decision, with NumGames as the most games it plays.
+	SPRT *SPRT
+
+	mu       sync.Mutex
+	once     sync.Once
+	pending  []int
+	leases   map[int]time.Time
+	finished map[int]bool
+	// openings are the openings picked so far, by pair of games.
+	openings map[int][]string
+	result   Result
+	over     bool
+	done     chan struct{}
+}
+
+func (c *Coordinator) init() {
+	c.once.Do(func() {
+		if c.LeaseTimeout == 0 {
+			c.LeaseTimeout = defaultLeaseTimeout
+		}
+		c.leases = make(map[int]time.Time)
+		c.finished = make(map[int]bool)
+		c.openings = make(map[int][]string)
+		c.done = make(chan struct{})
+		for i := 0; i < c.NumGames; i++ {
+			c.pending = append(c.pending, i)
+		}
+		if c.NumGames == 0 {
+			c.finish()
+		}
+	})
+}
+
+// finish ends the run, so that no more games are handed out.
+func (c *Coordinator) finish() {
+	if !c.over {
+		c.over = true
+		close(c.done)
+	}
+}
+
+// Lease hands out the next game to be played. It returns false if there is nothing to hand out at the moment,
+// either because the run is complete or because every remaining game is leased to a worker.
+func (c *Coordinator) Lease() (Assignment, bool, error) {
+	c.init()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.over {
+		return Assignment{}, false, nil
+	}
+
+	// Reclaim any games whose workers have gone quiet.
+	now := time.Now()
+	for game, leasedAt := range c.leases {
+		if now.Sub(leasedAt) > c.LeaseTimeout {
+			log.WithField("game_id", game).Warn("lease expired, rescheduling game")
+			delete(c.leases, game)
+			c.pending = append(c.pending, game)
+		}
+	}
+
+	for len(c.pending) > 0 {
+		game := c.pending[0]
+		c.pending = c.pending[1:]
+		if c.finished[game] {
+			// A slow worker reported this game after its lease was reclaimed.
+			continue
+		}
+		opening, ok := c.openings[game/2]
+		if !ok {
+			var err error
+			if opening, err = pickOpening(c.Openings, c.OpeningPly); err != nil {
+				c.pending = append([]int{game}, c.pending...)
+				return Assignment{}, false, err
+			}
+			c.openings[game/2] = opening
+		}
+		c.leases[game] = now
+		return Assignment{Game: game, BaselineIsWhite: game%2 == 0, Opening: opening}, true, nil
+	}
+	return Assignment{}, false, nil
+}
+
+// Complete records the outcome of a leased game. Reports for unknown or already-recorded games are ignored.
+func (c *Coordinator) Complete(report Report) error {
+	c.init()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if report.Game < 0 || report.Game >= c.NumGames {
+		return errors.Errorf("unknown game %d", report.Game)
+	}
+	if c.finished[report.Game] {
+		log.WithField("game_id", report.Game).Warn("ignoring duplicate report")
+		return nil
+	}
+
+	switch report.Outcome {
+	case OutcomeWin:
+		c.result.Wins++
+	case OutcomeLoss:
+		c.result.Losses++
+	case OutcomeDraw:
+		c.result.Draws++
+	default:
+		return errors.Errorf("unknown outcome %q", report.Outcome)
+	}
+
+	delete(c.leases, report.Game)
+	c.finished[report.Game] = true
+	log.WithFields(log.Fields{
+		"game_id": report.Game,
+		"outcome": report.Outcome,
+	}).Info("recorded game outcome")
+	if c.SPRT != nil && !c.over {
+		c.result.Decision = c.SPRT.Decide(c.result.Wins, c.result.Losses, c.result.Draws)
+		if c.result.Decision != DecisionContinue {
+			log.WithFields(log.Fields{
+				"decision": c.result.Decision,
+				"games":    len(c.finished),
+			}).Info("SPRT reached a decision, ending run")
+			c.finish()
+		}
+	}
+	if len(c.finished) == c.NumGames {
+		c.finish()
+	}
+	return nil
+}
+
+// Result returns the score so far.
+func (c *Coordinator) Result() Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.result
+}
+
+// Wait blocks until every game has been reported, the SPRT has reached a decision, or the context is canceled.
+func (c *Coordinator) Wait(ctx context.Context) (*Result, error) {
+	c.init()
+	select {
+	case <-c.done:
+		res := c.Result()
+		return &res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ServeHTTP implements the coordinator's side of the worker protocol:
+//   - POST /lease responds with an Assignment, 204 if there is no work right now, or 410 once the run is over
+//   - POST /report accepts a Report
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.init()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/lease":
+		select {
+		case <-c.done:
+			w.WriteHeader(http.StatusGone)
+			return
+		default:
+		}
+
+		assignment, ok, err := c.Lease()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignment)
+	case "/report":
+		var report Report
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.Complete(report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Worker plays games leased from a remote Coordinator using locally-installed engines.
+type Worker struct {
+	// CoordinatorURL is the base URL of the coordinator, e.g. http://host:8080.
+	CoordinatorURL string
+	// Session supplies the engines and the degree of parallelism for this worker. Its games start with the openings
+	// the coordinator assigns, rather than its own.
+	Session *Session
+	// PollInterval is how long to wait before asking again when the coordinator has no work to hand out.
+	PollInterval time.Duration
+
+	client http.Client
+}
+
+var errRunComplete = errors.New("selfplay run is complete")
+
+// Run leases and plays games until the coordinator reports that the run is complete.
+func (w *Worker) Run(ctx context.Context) error {
+	if w.PollInterval == 0 {
+		w.PollInterval = 5 * time.Second
+	}
+	parallel := w.Session.NumParallelGames
+	if parallel == 0 {
+		parallel = 1
+	}
+	defer w.Session.openPools()()
+
+	errs := make(chan error, parallel)
+	for i := 0; i < parallel; i++ {
+		go func(id int) {
+			errs <- w.loop(ctx, id)
+		}(i)
+	}
+
+	var firstErr error
+	for i := 0; i < parallel; i++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (w *Worker) loop(ctx context.Context, id int) error {
+	log.WithField("worker", id).Info("worker coming online")
+	for {
+		assignment, err := w.lease(ctx)
+		if err == errRunComplete {
+			log.WithField("worker", id).Info("worker exiting, run is complete")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if assignment == nil {
+			select {
+			case <-time.After(w.PollInterval):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		log.WithFields(log.Fields{
+			"worker":  id,
+			"game_id": assignment.Game,
+		}).Info("worker playing game")
+		outcome, err := w.Session.playGame(id, assignment.BaselineIsWhite, assignment.Opening)
+		if err != nil {
+			log.WithError(err).Error("failed to play game")
+			return err
+		}
+
+		if err := w.report(ctx, Report{Game: assignment.Game, Outcome: outcome}); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *Worker) lease(ctx context.Context) (*Assignment, error) {
+	resp, err := w.post(ctx, "lease", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var assignment Assignment
+		if err := json.NewDecoder(resp.Body).Decode(&assignment); err != nil {
+			return nil, errors.Wrap(err, "while decoding assignment")
+		}
+		return &assignment, nil
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusGone:
+		return nil, errRunComplete
+	default:
+		return nil, errors.Errorf("[%d] coordinator failed to lease game", resp.StatusCode)
+	}
+}
+
+func (w *Worker) report(ctx context.Context, report Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := w.post(ctx, "report", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.Errorf("[%d] coordinator rejected report", resp.StatusCode)
+	}
+	return nil
+}
+
+func (w *Worker) post(ctx context.Context, endpoint string, body []byte) (*http.Response, error) {
+	url := fmt.Sprintf("%s/%s", w.CoordinatorURL, endpoint)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "while creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "while contacting coordinator")
+	}
+	return resp, nil
+}
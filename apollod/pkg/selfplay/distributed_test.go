@@ -0,0 +1,135 @@
+package selfplay
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+func TestCoordinatorSchedule(t *testing.T) {
+	coordinator := &Coordinator{NumGames: 2}
+
+	first, ok, err := coordinator.Lease()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Assignment{Game: 0, BaselineIsWhite: true}, first)
+
+	second, ok, err := coordinator.Lease()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Assignment{Game: 1, BaselineIsWhite: false}, second)
+
+	_, ok, _ = coordinator.Lease()
+	assert.False(t, ok)
+
+	assert.NoError(t, coordinator.Complete(Report{Game: 0, Outcome: OutcomeWin}))
+	assert.NoError(t, coordinator.Complete(Report{Game: 1, Outcome: OutcomeDraw}))
+	assert.NoError(t, coordinator.Complete(Report{Game: 1, Outcome: OutcomeDraw}))
+	assert.Error(t, coordinator.Complete(Report{Game: 2, Outcome: OutcomeDraw}))
+
+	res, err := coordinator.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Wins: 1, Draws: 1}, *res)
+}
+
+func TestCoordinatorLeaseExpiry(t *testing.T) {
+	coordinator := &Coordinator{NumGames: 1, LeaseTimeout: time.Millisecond}
+
+	first, ok, _ := coordinator.Lease()
+	assert.True(t, ok)
+
+	time.Sleep(5 * time.Millisecond)
+	second, ok, _ := coordinator.Lease()
+	assert.True(t, ok)
+	assert.Equal(t, first, second)
+}
+
+func TestCoordinatorOpenings(t *testing.T) {
+	openings, err := book.LoadPGN(strings.NewReader("1. d4 Nf6 2. c4 e6 1/2-1/2\n"), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	coordinator := &Coordinator{NumGames: 2, Openings: openings, OpeningPly: 2}
+
+	first, ok, err := coordinator.Lease()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	second, ok, err := coordinator.Lease()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"d2d4", "g8f6"}, first.Opening)
+	assert.Equal(t, first.Opening, second.Opening)
+	assert.NotEqual(t, first.BaselineIsWhite, second.BaselineIsWhite)
+}
+
+func TestCoordinatorSPRT(t *testing.T) {
+	coordinator := &Coordinator{NumGames: 1000, SPRT: &SPRT{Elo0: 0, Elo1: 10, Alpha: 0.05, Beta: 0.05}}
+
+	for game := 0; ; game++ {
+		assignment, ok, err := coordinator.Lease()
+		assert.NoError(t, err)
+		if !ok {
+			break
+		}
+		outcome := OutcomeWin
+		if game%3 == 0 {
+			outcome = OutcomeDraw
+		}
+		assert.NoError(t, coordinator.Complete(Report{Game: assignment.Game, Outcome: outcome}))
+	}
+
+	res, err := coordinator.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionAccept, res.Decision)
+	assert.True(t, res.Wins+res.Draws < 1000)
+}
+
+func TestWorkerPlaysAssignedOpening(t *testing.T) {
+	openings, err := book.LoadPGN(strings.NewReader("1. d4 Nf6 1/2-1/2\n"), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	server := httptest.NewServer(&Coordinator{NumGames: 2, Openings: openings, OpeningPly: 2})
+	defer server.Close()
+
+	var fakes []*ucitest.Engine
+	var lock sync.Mutex
+	worker := &Worker{
+		CoordinatorURL: server.URL,
+		PollInterval:   time.Millisecond,
+		Session: &Session{
+			BaselineProgram:  "baseline",
+			CandidateProgram: "candidate",
+			NumParallelGames: 1,
+			Depth:            1,
+			Adjudication:     Adjudication{DrawScore: 10, DrawMoves: 2},
+			launch: func(program, protocol string, options engine.ProgramOptions) (engine.Engine, error) {
+				fake := &ucitest.Engine{Name: program}
+				lock.Lock()
+				fakes = append(fakes, fake)
+				lock.Unlock()
+				return uci.NewClient(fake)
+			},
+		},
+	}
+	assert.NoError(t, worker.Run(context.Background()))
+
+	assert.Len(t, fakes, 4)
+	for _, fake := range fakes {
+		for _, command := range fake.Commands() {
+			if strings.HasPrefix(command, "position") {
+				assert.True(t, strings.HasPrefix(command, "position startpos moves d2d4 g8f6"), command)
+			}
+		}
+	}
+}
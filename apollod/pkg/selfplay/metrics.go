@@ -0,0 +1,24 @@
+package selfplay
+
+import (
+	"github.com/swgillespie/apollo/apollod/pkg/metrics"
+)
+
+var (
+	selfplayMetrics = metrics.NewSubsystem("selfplay")
+
+	gamesPlayed = selfplayMetrics.NewCounter(
+		"games_total",
+		"Number of selfplay games finished, by outcome for the candidate engine.",
+		"outcome")
+	gamesAdjudicated = selfplayMetrics.NewCounter(
+		"games_adjudicated_total",
+		"Number of selfplay games ended early because both engines agreed on the result.")
+	gamesActive = selfplayMetrics.NewGauge(
+		"games_active",
+		"Number of selfplay games currently in progress.")
+	gameDuration = selfplayMetrics.NewHistogram(
+		"game_seconds",
+		"Time taken to play selfplay games, from launching the engines to the end of the game.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 3600})
+)
@@ -2,22 +2,92 @@ package selfplay
 
 import (
 	"context"
+	"io"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
 
 	"github.com/notnil/chess"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+
+	// Engines can speak either of these protocols, or run on an engine server.
+	_ "github.com/swgillespie/apollo/apollod/pkg/cecp"
+	_ "github.com/swgillespie/apollo/apollod/pkg/enginerpc"
+	_ "github.com/swgillespie/apollo/apollod/pkg/uci"
 )
 
 type Session struct {
 	BaselineProgram  string
 	CandidateProgram string
+	// BaselineProtocol and CandidateProtocol are the protocols that each engine speaks. If empty, it is UCI.
+	BaselineProtocol  string
+	CandidateProtocol string
+	// BaselineOptions and CandidateOptions are engine options, such as Hash and Threads, set on each engine before
+	// every game.
+	BaselineOptions  map[string]string
+	CandidateOptions map[string]string
+	// BaselineProgram and CandidateProgram are run with these arguments, environment, and working directory.
+	// Resources caps both programs, whatever their own options say.
+	BaselineProgramOptions  engine.ProgramOptions
+	CandidateProgramOptions engine.ProgramOptions
+	// Resources caps what each engine program may use, so that many games in parallel can't exhaust the machine.
+	Resources engine.Resources
+	// EngineGames, if set, keeps engines running from one game to the next, restarting each after it has played that
+	// many games, instead of starting both engines afresh for every game.
+	EngineGames int
+	// AutoSize sets each engine's Hash and Threads, unless its options set them, to an equal share of the machine for
+	// every engine of the games played in parallel.
+	AutoSize bool
+
 	NumGames         int
 	NumParallelGames int
 
+	// BaselineTime and CandidateTime are the clocks that each engine plays under. They need not be the same;
+	// giving one engine more time than the other plays a time-odds match.
+	BaselineTime  TimeControl
+	CandidateTime TimeControl
+	// Adjudication ends games early that both engines agree are decided.
+	Adjudication Adjudication
+	// Depth and Nodes, if set, fix how far every search goes instead of leaving it to the engines' time management,
+	// so that games don't depend on how busy the machine is. Engines are still charged for the time they take.
+	Depth int
+	Nodes int64
+	// BenchDepth, if set, measures the raw speed of both engines before the match, by searching engine.BenchPositions
+	// to this depth, so that the match score can be read alongside how fast each build is.
+	BenchDepth int
+
+	// Openings, if set, starts every game with moves picked from the book, up to OpeningPly plies, so that the engines
+	// don't play the same few games over and over.
+	Openings   *book.Book
+	OpeningPly int
+
+	// PGN, if set, is where every finished game is written, with each engine's evaluation and clock after its moves.
+	PGN     io.Writer
+	pgnLock sync.Mutex
+	// Records, if set, is where every finished game is written as a game record, one per line, with the same
+	// evaluations and clocks as the PGN.
+	Records     io.Writer
+	recordsLock sync.Mutex
+
+	baselinePool  *engine.Pool
+	candidatePool *engine.Pool
+	// launch starts an engine program, so that tests can substitute engines that don't need one. If nil, the program
+	// is run with its options, within Resources.
+	launch func(program, protocol string, options engine.ProgramOptions) (engine.Engine, error)
+
+	// statsLock guards the search statistics of every finished game.
+	statsLock      sync.Mutex
+	baselineStats  engine.SearchStats
+	candidateStats engine.SearchStats
+
 	remainingGames int32
 	wins           uint32
 	losses         uint32
@@ -28,17 +98,55 @@ type Result struct {
 	Wins   int
 	Losses int
 	Draws  int
+	// BaselineBench and CandidateBench are how fast each engine searched the bench positions, if the session
+	// measured it.
+	BaselineBench  *engine.BenchResult
+	CandidateBench *engine.BenchResult
+	// BaselineStats and CandidateStats sum up what each engine reported about its searches in every game, so that
+	// a change in score can be put down to searching faster or deeper, or to searching better.
+	BaselineStats  engine.SearchStats
+	CandidateStats engine.SearchStats
+	// Decision is what the match's SPRT concluded, if it ran one.
+	Decision Decision
 }
 
+// Outcome is the result of a single selfplay game, from the perspective of the candidate engine.
+type Outcome string
+
+const (
+	OutcomeWin  Outcome = "win"
+	OutcomeLoss Outcome = "loss"
+	OutcomeDraw Outcome = "draw"
+)
+
 func (s *Session) Run(ctx context.Context) (*Result, error) {
 	s.remainingGames = int32(s.NumGames)
 	if s.NumParallelGames == 0 {
 		s.NumParallelGames = 1
 	}
 
-	log.WithField("games", s.remainingGames).Info("beginning selfplay session")
+	defer s.openPools()()
+
+	// Engines are benched before any games, so that they don't compete with games for the machine.
+	var baselineBench, candidateBench *engine.BenchResult
+	if s.BenchDepth > 0 {
+		var err error
+		if baselineBench, err = s.bench("baseline", s.BaselineProgram, s.BaselineProtocol, s.BaselineProgramOptions, s.BaselineOptions); err != nil {
+			return nil, err
+		}
+		if candidateBench, err = s.bench("candidate", s.CandidateProgram, s.CandidateProtocol, s.CandidateProgramOptions, s.CandidateOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"games":         s.remainingGames,
+		"baselineTime":  s.BaselineTime.String(),
+		"candidateTime": s.CandidateTime.String(),
+	}).Info("beginning selfplay session")
 	group, childCtx := errgroup.WithContext(ctx)
 	for i := 0; i < s.NumParallelGames; i++ {
+		i := i
 		group.Go(func() error {
 			return s.worker(i, childCtx)
 		})
@@ -54,43 +162,114 @@ func (s *Session) Run(ctx context.Context) (*Result, error) {
 	wins := atomic.LoadUint32(&s.wins)
 	losses := atomic.LoadUint32(&s.losses)
 	draws := atomic.LoadUint32(&s.draws)
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
 	return &Result{
-		Wins:   int(wins),
-		Losses: int(losses),
-		Draws:  int(draws),
+		Wins:           int(wins),
+		Losses:         int(losses),
+		Draws:          int(draws),
+		BaselineBench:  baselineBench,
+		CandidateBench: candidateBench,
+		BaselineStats:  s.baselineStats,
+		CandidateStats: s.candidateStats,
 	}, nil
 }
 
+// bench measures the raw speed of one of the engines by searching the bench positions to BenchDepth.
+func (s *Session) bench(side, program, protocol string, run engine.ProgramOptions, options map[string]string) (*engine.BenchResult, error) {
+	client, err := s.launchEngine(program, protocol, run, options)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start %s engine for bench", side)
+	}
+	defer shutdownEngine(client)
+	result, err := engine.Bench(client, engine.Limits{Depth: s.BenchDepth})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to bench %s engine", side)
+	}
+	log.WithFields(log.Fields{
+		"engine": side,
+		"nodes":  result.Nodes,
+		"time":   result.Time,
+		"nps":    result.NPS(),
+	}).Info("benched engine")
+	return &result, nil
+}
+
+// openPools starts keeping engines between games, if EngineGames asks for it, and returns a function that shuts down
+// the engines being kept once every game has been played.
+func (s *Session) openPools() func() {
+	if s.EngineGames <= 0 {
+		return func() {}
+	}
+	s.baselinePool = engine.NewPool(func() (engine.Engine, error) {
+		return s.launchEngine(s.BaselineProgram, s.BaselineProtocol, s.BaselineProgramOptions, s.BaselineOptions)
+	}, s.EngineGames)
+	s.candidatePool = engine.NewPool(func() (engine.Engine, error) {
+		return s.launchEngine(s.CandidateProgram, s.CandidateProtocol, s.CandidateProgramOptions, s.CandidateOptions)
+	}, s.EngineGames)
+	return func() {
+		s.baselinePool.Close()
+		s.candidatePool.Close()
+	}
+}
+
 func (s *Session) worker(id int, ctx context.Context) error {
-	log.WithField("id", id).Info("worker coming online")
+	log.WithField("worker", id).Info("worker coming online")
 
 	for {
 		// Are there remaining games left to be played?
 		remainingGames := atomic.AddInt32(&s.remainingGames, -1)
 		if remainingGames < 0 {
 			// No more games left to play - exit.
-			log.WithField("id", id).Info("worker exiting, no remaining games")
+			log.WithField("worker", id).Info("worker exiting, no remaining games")
 			return nil
 		}
 
-		log.WithField("id", id).Info("worker playing game")
+		log.WithField("worker", id).Info("worker playing game")
 
 		// Play a game.
-		if err := s.playGame(id, remainingGames%2 == 0); err != nil {
+		opening, err := pickOpening(s.Openings, s.OpeningPly)
+		if err != nil {
+			return err
+		}
+		outcome, err := s.playGame(id, remainingGames%2 == 0, opening)
+		if err != nil {
 			log.WithError(err).Error("failed to play game")
 			return err
 		}
+		s.record(outcome)
 	}
 }
 
-func (s *Session) playGame(id int, baselineIsWhite bool) error {
+// record tallies the outcome of a completed game.
+func (s *Session) record(outcome Outcome) {
+	switch outcome {
+	case OutcomeWin:
+		atomic.AddUint32(&s.wins, 1)
+	case OutcomeLoss:
+		atomic.AddUint32(&s.losses, 1)
+	case OutcomeDraw:
+		atomic.AddUint32(&s.draws, 1)
+	}
+}
+
+// playGame plays a game between the baseline and the candidate, starting with the opening's moves, in UCI notation.
+func (s *Session) playGame(id int, baselineIsWhite bool, opening []string) (Outcome, error) {
+	start := time.Now()
+	gamesActive.Inc()
+	defer gamesActive.Dec()
+
 	// Load up and initialize our two engines. This launches subprocess for each
-	// of the two engines and does the initial UCI handshake for each of them.
+	// of the two engines and does the initial handshake for each of them.
 	baseline, candidate, err := s.loadEngines()
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer shutdownEngines(baseline, candidate)
+	finished := false
+	defer func() {
+		s.releaseEngine(s.baselinePool, baseline, finished)
+		s.releaseEngine(s.candidatePool, candidate, finished)
+	}()
 
 	if baselineIsWhite {
 		log.Info("beginning game with baseline as white")
@@ -99,138 +278,359 @@ func (s *Session) playGame(id int, baselineIsWhite bool) error {
 	}
 
 	// The baseline and candidate will each play half of their games as black and white.
-	var white *uci.Client
-	var black *uci.Client
+	var white engine.Engine
+	var black engine.Engine
+	var whiteClock *clock
+	var blackClock *clock
 	if baselineIsWhite {
 		white = baseline
 		black = candidate
+		whiteClock = newClock(s.BaselineTime)
+		blackClock = newClock(s.CandidateTime)
 	} else {
 		white = candidate
 		black = baseline
+		whiteClock = newClock(s.CandidateTime)
+		blackClock = newClock(s.BaselineTime)
 	}
 
-	// Drive the game to completion, using each UCI engine to play white and black.
+	// Drive the game to completion, using each engine to play white and black.
 	notation := chess.LongAlgebraicNotation{}
 	game := chess.NewGame(chess.UseNotation(notation))
-	whiteToMove := true
+	for _, move := range opening {
+		if err := game.MoveStr(move); err != nil {
+			return "", errors.Wrapf(err, "opening move %s is illegal", move)
+		}
+	}
+	whiteToMove := game.Position().Turn() == chess.White
+	var searches []search
+	var baselineStats, candidateStats engine.SearchStats
+	// termination is the PGN Termination tag for games that didn't end over the board.
+	termination := ""
 	for game.Outcome() == chess.NoOutcome {
-		var toMove *uci.Client
+		var toMove engine.Engine
+		var toMoveClock *clock
 		if whiteToMove {
 			toMove = white
+			toMoveClock = whiteClock
 		} else {
 			toMove = black
+			toMoveClock = blackClock
 		}
 
-		// The general UCI procedure here is to send "position startpos" followed
-		// by every move that has been played so far, in UCI notation.
+		// Each engine is sent the starting position followed by every move
+		// that has been played so far, in UCI notation.
 		var moves []string
 		for _, move := range game.Moves() {
 			moves = append(moves, notation.Encode(game.Position(), move))
 		}
 
-		if err := toMove.Position("startpos", moves); err != nil {
-			return err
+		if err := toMove.SetPosition("", moves); err != nil {
+			return "", err
 		}
 
-		bestmove, err := toMove.Go(0, 0, 0, 0)
+		start := time.Now()
+		result, err := toMove.Go(engine.Limits{
+			WhiteTime:      whiteClock.remaining,
+			BlackTime:      blackClock.remaining,
+			WhiteIncrement: whiteClock.control.Increment,
+			BlackIncrement: blackClock.control.Increment,
+			Depth:          s.Depth,
+			Nodes:          s.Nodes,
+		})
 		if err != nil {
-			return err
+			return "", err
+		}
+
+		if !toMoveClock.punch(time.Since(start)) {
+			log.WithField("white", strconv.FormatBool(whiteToMove)).Info("engine lost on time")
+			termination = "time forfeit"
+			if whiteToMove {
+				game.Resign(chess.White)
+			} else {
+				game.Resign(chess.Black)
+			}
+			break
 		}
 
-		log.WithField("white", strconv.FormatBool(whiteToMove)).Debug("move: " + bestmove)
-		moveObj, err := notation.Decode(game.Position(), bestmove)
+		log.WithField("white", strconv.FormatBool(whiteToMove)).Debug("move: " + result.Move)
+		moveObj, err := notation.Decode(game.Position(), result.Move)
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		if err := game.Move(moveObj); err != nil {
-			return err
+			return "", err
+		}
+		// Adjudication, the PGN, and the statistics all go by the report the engine ended its search with.
+		if toMove == baseline {
+			baselineStats.Add(result.Info)
+		} else {
+			candidateStats.Add(result.Info)
+		}
+		searches = append(searches, search{
+			ply:       len(game.Moves()) - 1,
+			info:      result.Info,
+			remaining: toMoveClock.remaining,
+			timed:     !toMoveClock.control.IsZero(),
+		})
+		if s.adjudicate(game, searches) {
+			termination = "adjudication"
+			break
 		}
 
 		whiteToMove = !whiteToMove
 	}
 
-	log.WithField("id", id).Info("game completed")
+	log.WithField("worker", id).Info("game completed")
+	s.statsLock.Lock()
+	s.baselineStats.Merge(baselineStats)
+	s.candidateStats.Merge(candidateStats)
+	s.statsLock.Unlock()
+	if s.PGN != nil || s.Records != nil {
+		s.writeGame(game, searches, baselineIsWhite, termination)
+	}
 
+	var outcome Outcome
 	switch game.Outcome() {
-	case chess.Draw:
-		log.WithField("id", id).Info("recording draw")
-		atomic.AddUint32(&s.draws, 1)
 	case chess.WhiteWon:
 		if baselineIsWhite {
-			log.WithField("id", id).Info("recording loss")
-			atomic.AddUint32(&s.losses, 1)
+			outcome = OutcomeLoss
 		} else {
-			log.WithField("id", id).Info("recording win")
-			atomic.AddUint32(&s.wins, 1)
+			outcome = OutcomeWin
 		}
 	case chess.BlackWon:
 		if baselineIsWhite {
-			log.WithField("id", id).Info("recording win")
-			atomic.AddUint32(&s.wins, 1)
+			outcome = OutcomeWin
 		} else {
-			log.WithField("id", id).Info("recording loss")
-			atomic.AddUint32(&s.losses, 1)
+			outcome = OutcomeLoss
 		}
+	default:
+		outcome = OutcomeDraw
 	}
-	return nil
+	log.WithField("worker", id).Info("recording " + string(outcome))
+	gamesPlayed.Inc(string(outcome))
+	gameDuration.ObserveSince(start)
+	finished = true
+	return outcome, nil
 }
 
-func (s *Session) loadEngines() (*uci.Client, *uci.Client, error) {
-	baselineTransport, err := uci.NewProgramTransport(s.BaselineProgram)
-	if err != nil {
-		return nil, nil, err
+// adjudicate ends the game if the engines agree that it's decided, returning true if it did.
+func (s *Session) adjudicate(game *chess.Game, searches []search) bool {
+	switch s.Adjudication.adjudicate(searches) {
+	case chess.WhiteWon:
+		game.Resign(chess.Black)
+	case chess.BlackWon:
+		game.Resign(chess.White)
+	case chess.Draw:
+		if err := game.Draw(chess.DrawOffer); err != nil {
+			return false
+		}
+	default:
+		return false
 	}
+	log.WithField("outcome", game.Outcome().String()).Info("adjudicated game")
+	gamesAdjudicated.Inc()
+	return true
+}
+
+// search is what an engine reported about the search for one of its moves.
+type search struct {
+	ply  int
+	info engine.Info
+	// remaining is the time left on the engine's clock after the move, if it plays with one.
+	remaining time.Duration
+	timed     bool
+}
 
-	candidateTransport, err := uci.NewProgramTransport(s.CandidateProgram)
+// writeGame writes a finished game to the session's PGN and records.
+func (s *Session) writeGame(game *chess.Game, searches []search, baselineIsWhite bool, termination string) {
+	positions := game.Positions()
+	moves := make([]string, len(game.Moves()))
+	for i, move := range game.Moves() {
+		moves[i] = chess.LongAlgebraicNotation{}.Encode(positions[i], move)
+	}
+	record, err := pgn.NewGame("", moves)
 	if err != nil {
-		return nil, nil, err
+		log.WithError(err).Warn("failed to write selfplay game")
+		return
 	}
 
-	baseline, err := uci.NewClient(baselineTransport)
-	if err != nil {
-		return nil, nil, err
+	record.Tags.Set("Event", "apollod selfplay")
+	record.Tags.Set("Date", time.Now().Format("2006.01.02"))
+	if baselineIsWhite {
+		record.Tags.Set("White", s.BaselineProgram)
+		record.Tags.Set("Black", s.CandidateProgram)
+	} else {
+		record.Tags.Set("White", s.CandidateProgram)
+		record.Tags.Set("Black", s.BaselineProgram)
+	}
+	switch game.Outcome() {
+	case chess.WhiteWon:
+		record.SetResult(pgn.WhiteWins)
+	case chess.BlackWon:
+		record.SetResult(pgn.BlackWins)
+	default:
+		record.SetResult(pgn.Draw)
+	}
+	if s.BaselineTime == s.CandidateTime && !s.BaselineTime.IsZero() {
+		record.Tags.Set("TimeControl", s.BaselineTime.String())
+	}
+	if termination != "" {
+		record.Tags.Set("Termination", termination)
+	}
+
+	for _, search := range searches {
+		move := record.Moves[search.ply]
+		if search.info.HasScore {
+			// Engines score positions for the side that moved, but PGN evaluations are from white's point of view.
+			move.Eval, move.HasEval = search.info.Score, true
+			if search.ply%2 == 1 {
+				move.Eval = engine.Score{Centipawns: -move.Eval.Centipawns, Mate: -move.Eval.Mate}
+			}
+		}
+		if search.timed {
+			move.Clock, move.HasClock = search.remaining, true
+		}
+	}
+
+	if s.PGN != nil {
+		s.pgnLock.Lock()
+		err := record.Write(s.PGN)
+		s.pgnLock.Unlock()
+		if err != nil {
+			log.WithError(err).Warn("failed to write selfplay game")
+		}
 	}
+	if s.Records != nil {
+		s.writeRecord(record, searches)
+	}
+}
 
-	candidate, err := uci.NewClient(candidateTransport)
+// writeRecord writes a finished game, already converted to PGN, to the session's records.
+func (s *Session) writeRecord(game *pgn.Game, searches []search) {
+	record, err := gamerecord.FromPGN(game)
 	if err != nil {
-		baseline.Close()
-		return nil, nil, err
+		log.WithError(err).Warn("failed to convert selfplay game into a record")
+		return
+	}
+	for _, search := range searches {
+		if eval := record.Moves[search.ply].Eval; eval != nil {
+			eval.Depth = search.info.Depth
+		}
 	}
 
-	if err := baseline.UCINewGame(); err != nil {
-		baseline.Close()
-		candidate.Close()
-		return nil, nil, err
+	s.recordsLock.Lock()
+	defer s.recordsLock.Unlock()
+	if err := record.Write(s.Records); err != nil {
+		log.WithError(err).Warn("failed to write selfplay game record")
 	}
+}
 
-	if err := candidate.UCINewGame(); err != nil {
-		baseline.Close()
-		candidate.Close()
+// pickOpening picks book moves from the start of a game until the book runs out or the game is maxPly plies deep,
+// returning them in UCI notation. A nil book picks no moves.
+func pickOpening(openings *book.Book, maxPly int) ([]string, error) {
+	if openings == nil {
+		return nil, nil
+	}
+	notation := chess.LongAlgebraicNotation{}
+	game := chess.NewGame(chess.UseNotation(notation))
+	var moves []string
+	for len(moves) < maxPly {
+		move, ok := openings.Lookup(game.Position())
+		if !ok {
+			break
+		}
+		if err := game.MoveStr(move); err != nil {
+			return nil, errors.Wrapf(err, "book move %s is illegal", move)
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
+
+func (s *Session) loadEngines() (engine.Engine, engine.Engine, error) {
+	baseline, err := s.getEngine(s.baselinePool, s.BaselineProgram, s.BaselineProtocol, s.BaselineProgramOptions, s.BaselineOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	candidate, err := s.getEngine(s.candidatePool, s.CandidateProgram, s.CandidateProtocol, s.CandidateProgramOptions, s.CandidateOptions)
+	if err != nil {
+		s.releaseEngine(s.baselinePool, baseline, false)
 		return nil, nil, err
 	}
 	return baseline, candidate, nil
 }
 
-func shutdownEngines(baseline, candidate *uci.Client) error {
-	if err := baseline.Stop(); err != nil {
-		return err
+// getEngine returns an engine ready for a new game, from the pool if engines are being reused.
+func (s *Session) getEngine(pool *engine.Pool, program, protocol string, run engine.ProgramOptions, options map[string]string) (engine.Engine, error) {
+	if pool != nil {
+		return pool.Get(false)
 	}
+	client, err := s.launchEngine(program, protocol, run, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.NewGame(false); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
 
-	if err := baseline.Quit(); err != nil {
-		return err
+// launchEngine starts an engine program, run with the given options, and sets its engine options.
+func (s *Session) launchEngine(program, protocol string, run engine.ProgramOptions, options map[string]string) (engine.Engine, error) {
+	run.Resources = s.Resources
+	launch := s.launch
+	if launch == nil {
+		launch = func(program, protocol string, run engine.ProgramOptions) (engine.Engine, error) {
+			return engine.Launch(program, protocol, nil, run)
+		}
 	}
+	client, err := launch(program, protocol, run)
+	if err != nil {
+		return nil, err
+	}
+	if s.AutoSize {
+		options = engine.AutoSize(client, options, engine.DetectHost(), 2*s.NumParallelGames)
+	}
+	if err := configure(client, options); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
 
-	if err := candidate.Stop(); err != nil {
-		return err
+// releaseEngine is done with an engine once its game is over, handing it back to the pool if engines are being reused
+// and shutting it down otherwise. Engines whose game didn't finish are never reused.
+func (s *Session) releaseEngine(pool *engine.Pool, client engine.Engine, finished bool) {
+	if pool != nil {
+		pool.Put(client, finished)
+		return
+	}
+	if err := shutdownEngine(client); err != nil {
+		log.WithError(err).Warn("failed to shut down engine")
 	}
+}
 
-	if err := candidate.Quit(); err != nil {
-		return err
+// configure applies options to an engine, warning about any that it doesn't take.
+func configure(client engine.Engine, options map[string]string) error {
+	skipped, err := engine.Configure(client, options)
+	for _, skip := range skipped {
+		log.WithError(skip.Reason).WithFields(log.Fields{
+			"engine": client.Name(),
+			"option": skip.Name,
+		}).Warn("skipping engine option")
 	}
+	return err
+}
 
-	if err := baseline.Close(); err != nil {
+func shutdownEngine(client engine.Engine) error {
+	if err := client.Stop(); err != nil {
+		return err
+	}
+	if err := client.Quit(); err != nil {
 		return err
 	}
-	return candidate.Close()
+	return client.Close()
 }
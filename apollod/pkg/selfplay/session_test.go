@@ -0,0 +1,162 @@
+package selfplay
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+func TestPickOpening(t *testing.T) {
+	openings, err := book.LoadPGN(strings.NewReader("1. d4 Nf6 2. c4 e6 3. Nc3 Bb4 1/2-1/2\n"), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	opening, err := pickOpening(openings, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d2d4", "g8f6", "c2c4"}, opening)
+
+	// The book runs out before maxPly.
+	opening, err = pickOpening(openings, 10)
+	assert.NoError(t, err)
+	assert.Len(t, opening, 6)
+
+	opening, err = pickOpening(nil, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, opening)
+}
+
+func TestRun(t *testing.T) {
+	var fakes []*ucitest.Engine
+	runs := make(map[string]engine.ProgramOptions)
+	var lock sync.Mutex
+	session := &Session{
+		BaselineProgram:        "baseline",
+		CandidateProgram:       "candidate",
+		BaselineOptions:        map[string]string{"Hash": "16"},
+		BaselineProgramOptions: engine.ProgramOptions{Args: []string{"--weights=net.pb"}, Dir: "/nets"},
+		Resources:              engine.Resources{MemoryMB: 512},
+		NumGames:               2,
+		NumParallelGames:       2,
+		Depth:                  1,
+		Adjudication:           Adjudication{DrawScore: 10, DrawMoves: 2},
+		launch: func(program, protocol string, options engine.ProgramOptions) (engine.Engine, error) {
+			fake := &ucitest.Engine{Name: program, Options: []string{"Hash"}}
+			lock.Lock()
+			fakes = append(fakes, fake)
+			runs[program] = options
+			lock.Unlock()
+			return uci.NewClient(fake)
+		},
+	}
+	result, err := session.Run(context.Background())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, 2, result.Draws, "both engines score every position as even")
+	assert.Equal(t, 4, result.BaselineStats.Searches)
+	assert.Equal(t, 4, result.CandidateStats.Searches)
+	assert.Equal(t, 1.0, result.CandidateStats.AverageDepth())
+
+	assert.Len(t, fakes, 4, "each game starts its own engines")
+	assert.Equal(t, engine.ProgramOptions{
+		Args:      []string{"--weights=net.pb"},
+		Dir:       "/nets",
+		Resources: engine.Resources{MemoryMB: 512},
+	}, runs["baseline"])
+	assert.Equal(t, engine.ProgramOptions{Resources: engine.Resources{MemoryMB: 512}}, runs["candidate"])
+	for _, fake := range fakes {
+		assert.Contains(t, fake.Commands(), "go depth 1")
+		if fake.Name == "baseline" {
+			assert.Contains(t, fake.Commands(), "setoption name Hash value 16")
+		}
+	}
+}
+
+func TestWriteGame(t *testing.T) {
+	game := chess.NewGame(chess.UseNotation(chess.LongAlgebraicNotation{}))
+	for _, move := range []string{"f2f3", "e7e5", "g2g4", "d8h4"} {
+		assert.NoError(t, game.MoveStr(move))
+	}
+	control := TimeControl{Base: time.Minute, Increment: time.Second}
+	var out bytes.Buffer
+	session := &Session{
+		BaselineProgram:  "./baseline",
+		CandidateProgram: "./candidate",
+		BaselineTime:     control,
+		CandidateTime:    control,
+		PGN:              &out,
+	}
+	session.writeGame(game, []search{
+		{ply: 2, info: engine.Info{Score: engine.Score{Centipawns: -300}, HasScore: true}, remaining: 58 * time.Second, timed: true},
+		{ply: 3, info: engine.Info{Score: engine.Score{Mate: 1}, HasScore: true}, remaining: 59 * time.Second, timed: true},
+	}, false, "")
+
+	record, err := pgn.Parse(out.String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "./candidate", record.Tags.Get("White"))
+	assert.Equal(t, "./baseline", record.Tags.Get("Black"))
+	assert.Equal(t, "60+1", record.Tags.Get("TimeControl"))
+	assert.Equal(t, pgn.BlackWins, record.Result)
+	assert.False(t, record.Moves[0].HasEval)
+	assert.Equal(t, engine.Score{Centipawns: -300}, record.Moves[2].Eval)
+	assert.Equal(t, engine.Score{Mate: -1}, record.Moves[3].Eval)
+	assert.Equal(t, 59*time.Second, record.Moves[3].Clock)
+	assert.Equal(t, "Qh4#", record.Moves[3].SAN)
+}
+
+func TestWriteGameRecord(t *testing.T) {
+	game := chess.NewGame(chess.UseNotation(chess.LongAlgebraicNotation{}))
+	for _, move := range []string{"f2f3", "e7e5", "g2g4", "d8h4"} {
+		assert.NoError(t, game.MoveStr(move))
+	}
+	var out bytes.Buffer
+	session := &Session{BaselineProgram: "./baseline", CandidateProgram: "./candidate", Records: &out}
+	session.writeGame(game, []search{
+		{ply: 3, info: engine.Info{Score: engine.Score{Mate: 1}, HasScore: true, Depth: 5}},
+	}, false, "")
+
+	record, err := gamerecord.Read(&out)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "./candidate", record.White.Name)
+	assert.Equal(t, gamerecord.BlackWins, record.Result)
+	assert.Equal(t, "mate", record.Termination)
+	assert.Equal(t, []string{"f2f3", "e7e5", "g2g4", "d8h4"}, record.UCI())
+	assert.Equal(t, &gamerecord.Eval{Mate: -1, Depth: 5}, record.Moves[3].Eval)
+}
+
+func TestAdjudicate(t *testing.T) {
+	scored := func(ply, centipawns int) search {
+		return search{ply: ply, info: engine.Info{Score: engine.Score{Centipawns: centipawns}, HasScore: true}}
+	}
+	resign := Adjudication{ResignScore: 500, ResignMoves: 2}
+	// Scores are for the side that moved, so black agreeing that white is winning scores its moves negative.
+	winning := []search{scored(10, 600), scored(11, -700), scored(12, 800), scored(13, -900)}
+	assert.Equal(t, chess.WhiteWon, resign.adjudicate(winning))
+	assert.Equal(t, chess.NoOutcome, resign.adjudicate(winning[1:]), "not enough moves")
+	winning[2].info.HasScore = false
+	assert.Equal(t, chess.NoOutcome, resign.adjudicate(winning), "an unscored move breaks the run")
+
+	draw := Adjudication{DrawScore: 10, DrawMoves: 1, DrawAfter: 30}
+	even := []search{scored(60, 5), scored(61, -10)}
+	assert.Equal(t, chess.Draw, draw.adjudicate(even))
+	assert.Equal(t, chess.NoOutcome, draw.adjudicate([]search{scored(20, 0), scored(21, 0)}), "too early to draw")
+	assert.Equal(t, chess.NoOutcome, Adjudication{}.adjudicate(even), "the zero value never adjudicates")
+}
@@ -0,0 +1,68 @@
+package selfplay
+
+import "math"
+
+// SPRT is a sequential probability ratio test of whether the candidate is stronger than the baseline, which ends a
+// match as soon as its score is conclusive instead of after a fixed number of games. It weighs the hypothesis that the
+// candidate is Elo1 stronger against the hypothesis that it is only Elo0 stronger, with false positive rate Alpha and
+// false negative rate Beta.
+type SPRT struct {
+	Elo0  float64
+	Elo1  float64
+	Alpha float64
+	Beta  float64
+}
+
+// Decision is the conclusion of an SPRT.
+type Decision string
+
+const (
+	// DecisionContinue means that the score isn't conclusive yet, and more games are needed.
+	DecisionContinue Decision = ""
+	// DecisionAccept means that the candidate is at least Elo1 stronger.
+	DecisionAccept Decision = "accept"
+	// DecisionReject means that the candidate is no more than Elo0 stronger.
+	DecisionReject Decision = "reject"
+)
+
+// LLR returns the log-likelihood ratio of Elo1 over Elo0 given the candidate's wins, losses and draws, approximating
+// the outcome of each game as normally distributed around the candidate's score. It is zero until both sides have
+// scored something and not everything.
+func (s SPRT) LLR(wins, losses, draws int) float64 {
+	games := float64(wins + losses + draws)
+	if games == 0 {
+		return 0
+	}
+	w, d := float64(wins)/games, float64(draws)/games
+	score := w + d/2
+	variance := w + d/4 - score*score
+	if variance <= 0 {
+		return 0
+	}
+	s0, s1 := expectedScore(s.Elo0), expectedScore(s.Elo1)
+	return games * (s1 - s0) * (2*score - s0 - s1) / (2 * variance)
+}
+
+// Bounds returns the log-likelihood ratios below which the test rejects and above which it accepts.
+func (s SPRT) Bounds() (lower, upper float64) {
+	return math.Log(s.Beta / (1 - s.Alpha)), math.Log((1 - s.Beta) / s.Alpha)
+}
+
+// Decide returns the conclusion of the test given the candidate's wins, losses and draws.
+func (s SPRT) Decide(wins, losses, draws int) Decision {
+	llr := s.LLR(wins, losses, draws)
+	lower, upper := s.Bounds()
+	switch {
+	case llr >= upper:
+		return DecisionAccept
+	case llr <= lower:
+		return DecisionReject
+	default:
+		return DecisionContinue
+	}
+}
+
+// expectedScore returns the expected score per game of a player elo points stronger than their opponent.
+func expectedScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
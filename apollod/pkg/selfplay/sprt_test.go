@@ -0,0 +1,20 @@
+package selfplay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSPRT(t *testing.T) {
+	sprt := SPRT{Elo0: 0, Elo1: 5, Alpha: 0.05, Beta: 0.05}
+	lower, upper := sprt.Bounds()
+	assert.InDelta(t, -2.94, lower, 0.01)
+	assert.InDelta(t, 2.94, upper, 0.01)
+
+	assert.Equal(t, 0.0, sprt.LLR(0, 0, 0))
+	assert.Equal(t, 0.0, sprt.LLR(0, 0, 10))
+	assert.Equal(t, DecisionContinue, sprt.Decide(10, 9, 20))
+	assert.Equal(t, DecisionAccept, sprt.Decide(1300, 1000, 2000))
+	assert.Equal(t, DecisionReject, sprt.Decide(1000, 1300, 2000))
+}
@@ -0,0 +1,80 @@
+package selfplay
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TimeControl is the clock an engine plays under during a selfplay game. The zero TimeControl means that the
+// engine is not given a clock at all and searches for as long as it would like.
+type TimeControl struct {
+	Base      time.Duration
+	Increment time.Duration
+}
+
+// ParseTimeControl parses a time control of the form "base+increment", where both components are in seconds,
+// e.g. "10+0.1". An empty string parses to the zero TimeControl.
+func ParseTimeControl(s string) (TimeControl, error) {
+	if s == "" {
+		return TimeControl{}, nil
+	}
+
+	parts := strings.SplitN(s, "+", 2)
+	base, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || base <= 0 {
+		return TimeControl{}, errors.Errorf("invalid time control base: %q", s)
+	}
+
+	var inc float64
+	if len(parts) == 2 {
+		inc, err = strconv.ParseFloat(parts[1], 64)
+		if err != nil || inc < 0 {
+			return TimeControl{}, errors.Errorf("invalid time control increment: %q", s)
+		}
+	}
+
+	return TimeControl{
+		Base:      time.Duration(base * float64(time.Second)),
+		Increment: time.Duration(inc * float64(time.Second)),
+	}, nil
+}
+
+// IsZero returns true if this time control does not constrain the engine.
+func (t TimeControl) IsZero() bool {
+	return t.Base == 0 && t.Increment == 0
+}
+
+func (t TimeControl) String() string {
+	if t.IsZero() {
+		return "none"
+	}
+	return fmt.Sprintf("%g+%g", t.Base.Seconds(), t.Increment.Seconds())
+}
+
+// clock tracks the time remaining for one side of a game.
+type clock struct {
+	control   TimeControl
+	remaining time.Duration
+}
+
+func newClock(control TimeControl) *clock {
+	return &clock{control: control, remaining: control.Base}
+}
+
+// punch charges the clock for a move that took elapsed time, returning false if the side has flagged.
+func (c *clock) punch(elapsed time.Duration) bool {
+	if c.control.IsZero() {
+		return true
+	}
+
+	c.remaining -= elapsed
+	if c.remaining < 0 {
+		return false
+	}
+	c.remaining += c.control.Increment
+	return true
+}
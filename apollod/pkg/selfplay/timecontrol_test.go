@@ -0,0 +1,34 @@
+package selfplay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeControl(t *testing.T) {
+	tc, err := ParseTimeControl("10+0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeControl{Base: 10 * time.Second, Increment: 100 * time.Millisecond}, tc)
+
+	tc, err = ParseTimeControl("20")
+	assert.NoError(t, err)
+	assert.Equal(t, TimeControl{Base: 20 * time.Second}, tc)
+
+	tc, err = ParseTimeControl("")
+	assert.NoError(t, err)
+	assert.True(t, tc.IsZero())
+
+	_, err = ParseTimeControl("fast")
+	assert.Error(t, err)
+	_, err = ParseTimeControl("10+-1")
+	assert.Error(t, err)
+}
+
+func TestClockFlag(t *testing.T) {
+	c := newClock(TimeControl{Base: time.Second, Increment: 100 * time.Millisecond})
+	assert.True(t, c.punch(500*time.Millisecond))
+	assert.Equal(t, 600*time.Millisecond, c.remaining)
+	assert.False(t, c.punch(time.Second))
+}
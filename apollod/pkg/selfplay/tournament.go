@@ -0,0 +1,120 @@
+package selfplay
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// Tournament plays a round robin between several engines: every engine plays a selfplay session against every other,
+// all under the same clock.
+type Tournament struct {
+	Programs []string
+	// GamesPerPairing is the number of games each pair of engines plays, split between colors.
+	GamesPerPairing  int
+	NumParallelGames int
+	Time             TimeControl
+	// Options are engine options set on every engine before every game.
+	Options map[string]string
+	// Resources caps what each engine program may use.
+	Resources engine.Resources
+	// EngineGames keeps engines running between games, as in a Session.
+	EngineGames int
+	// AutoSize sizes every engine's Hash and Threads for the machine, as in a Session.
+	AutoSize bool
+	// Adjudication ends games early that both engines agree are decided, as in a Session.
+	Adjudication Adjudication
+	// Openings and OpeningPly start every game from the book, as in a Session.
+	Openings   *book.Book
+	OpeningPly int
+	// PGN, if set, is where every game of every pairing is written.
+	PGN io.Writer
+	// Records, if set, is where every game of every pairing is written as a game record.
+	Records io.Writer
+}
+
+// Standing is an engine's overall record in a tournament.
+type Standing struct {
+	Program string
+	Wins    int
+	Losses  int
+	Draws   int
+}
+
+// Score is the engine's points in the tournament, with a draw worth half a win.
+func (s Standing) Score() float64 {
+	return float64(s.Wins) + float64(s.Draws)/2
+}
+
+// Run plays every pairing in turn and returns the final standings, best first.
+func (t *Tournament) Run(ctx context.Context) ([]Standing, error) {
+	if len(t.Programs) < 2 {
+		return nil, errors.New("a tournament needs at least two engines")
+	}
+
+	standings := make([]Standing, len(t.Programs))
+	for i, program := range t.Programs {
+		standings[i].Program = program
+	}
+	for i := range t.Programs {
+		for j := i + 1; j < len(t.Programs); j++ {
+			log.WithFields(log.Fields{
+				"baseline":  t.Programs[i],
+				"candidate": t.Programs[j],
+			}).Info("beginning tournament pairing")
+			session := &Session{
+				BaselineProgram:  t.Programs[i],
+				CandidateProgram: t.Programs[j],
+				NumGames:         t.GamesPerPairing,
+				NumParallelGames: t.NumParallelGames,
+				BaselineTime:     t.Time,
+				CandidateTime:    t.Time,
+				BaselineOptions:  t.Options,
+				CandidateOptions: t.Options,
+				Openings:         t.Openings,
+				OpeningPly:       t.OpeningPly,
+				PGN:              t.PGN,
+				Records:          t.Records,
+				Adjudication:     t.Adjudication,
+				Resources:        t.Resources,
+				EngineGames:      t.EngineGames,
+				AutoSize:         t.AutoSize,
+			}
+			result, err := session.Run(ctx)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to play %s against %s", t.Programs[i], t.Programs[j])
+			}
+			tally(standings, i, j, result)
+		}
+	}
+
+	rank(standings)
+	return standings, nil
+}
+
+// tally adds the result of a session, from the point of view of the candidate, to the standings of its baseline and
+// candidate.
+func tally(standings []Standing, baseline, candidate int, result *Result) {
+	standings[candidate].Wins += result.Wins
+	standings[candidate].Losses += result.Losses
+	standings[candidate].Draws += result.Draws
+	standings[baseline].Wins += result.Losses
+	standings[baseline].Losses += result.Wins
+	standings[baseline].Draws += result.Draws
+}
+
+// rank sorts standings by score, breaking ties by number of wins.
+func rank(standings []Standing) {
+	sort.SliceStable(standings, func(i, j int) bool {
+		if standings[i].Score() != standings[j].Score() {
+			return standings[i].Score() > standings[j].Score()
+		}
+		return standings[i].Wins > standings[j].Wins
+	})
+}
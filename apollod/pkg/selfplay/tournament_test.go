@@ -0,0 +1,28 @@
+package selfplay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTournamentStandings(t *testing.T) {
+	standings := []Standing{{Program: "a"}, {Program: "b"}, {Program: "c"}}
+	tally(standings, 0, 1, &Result{Wins: 3, Losses: 1, Draws: 2})
+	tally(standings, 0, 2, &Result{Wins: 2, Losses: 2, Draws: 2})
+	tally(standings, 1, 2, &Result{Wins: 0, Losses: 6, Draws: 0})
+	rank(standings)
+
+	assert.Equal(t, []Standing{
+		{Program: "b", Wins: 9, Losses: 1, Draws: 2},
+		{Program: "a", Wins: 3, Losses: 5, Draws: 4},
+		{Program: "c", Wins: 2, Losses: 8, Draws: 2},
+	}, standings)
+	assert.Equal(t, 10.0, standings[0].Score())
+}
+
+func TestTournamentNeedsTwoEngines(t *testing.T) {
+	_, err := (&Tournament{Programs: []string{"apollo"}}).Run(context.Background())
+	assert.Error(t, err)
+}
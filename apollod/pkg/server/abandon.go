@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+// victoryClaimer claims victory once an opponent has been gone from a game for as long as lichess requires.
+type victoryClaimer struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	claim func()
+}
+
+func newVictoryClaimer(claim func()) *victoryClaimer {
+	return &victoryClaimer{claim: claim}
+}
+
+// observe updates the claimer with our opponent's latest connectivity.
+func (v *victoryClaimer) observe(event blitz.OpponentGone) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+	if !event.Gone {
+		return
+	}
+
+	// Leave a second of slack so that we don't claim before lichess agrees that we can.
+	wait := time.Duration(event.ClaimWinInSeconds+1) * time.Second
+	v.timer = time.AfterFunc(wait, v.claim)
+}
+
+// stop cancels any pending claim.
+func (v *victoryClaimer) stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+}
+
+func (s *Server) claimVictory(ctx context.Context, gameID string) {
+	log.WithField("game_id", gameID).Info("opponent has abandoned the game, claiming victory")
+	if err := s.client.Bot.ClaimVictory(ctx, gameID); err != nil {
+		log.WithError(err).Warn("failed to claim victory")
+	}
+}
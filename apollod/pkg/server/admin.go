@@ -0,0 +1,397 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const (
+	// drainPollInterval is how often a drain checks whether the last game has finished.
+	drainPollInterval = time.Second
+	// recentGamesKept is the number of finished games the admin API reports.
+	recentGamesKept = 10
+)
+
+// liveGame is the server's handle on a game in progress.
+type liveGame struct {
+	cancel context.CancelFunc
+	status GameStatus
+}
+
+// GameStatus is a snapshot of a game in progress, as reported by the admin API.
+type GameStatus struct {
+	ID          string `json:"id"`
+	Opponent    string `json:"opponent,omitempty"`
+	Color       string `json:"color,omitempty"`
+	Speed       string `json:"speed,omitempty"`
+	Moves       int    `json:"moves"`
+	WhiteTimeMs int    `json:"whiteTimeMs"`
+	BlackTimeMs int    `json:"blackTimeMs"`
+	Depth       int    `json:"depth,omitempty"`
+	Nodes       int64  `json:"nodes,omitempty"`
+	NPS         int64  `json:"nps,omitempty"`
+	Score       string `json:"score,omitempty"`
+	// FEN is the current position, if we could follow the game.
+	FEN string `json:"fen,omitempty"`
+	// Engine is the engine binary the game is played with, which differs from the server's if it was swapped since.
+	Engine string `json:"engine,omitempty"`
+	// Build is the build the game is played with, if a canary is running.
+	Build string `json:"build,omitempty"`
+}
+
+// FinishedGame is a game that has recently ended, as reported by the admin API.
+type FinishedGame struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Opponent string    `json:"opponent,omitempty"`
+	Color    string    `json:"color,omitempty"`
+	Speed    string    `json:"speed,omitempty"`
+	Result   string    `json:"result"`
+	Status   string    `json:"status,omitempty"`
+}
+
+// Status is the server's overall state, as reported by the admin API.
+type Status struct {
+	Username           string       `json:"username"`
+	Paused             bool         `json:"paused"`
+	Available          bool         `json:"available"`
+	Matchmaking        bool         `json:"matchmaking"`
+	RateLimitedFor     string       `json:"rateLimitedFor,omitempty"`
+	MaxConcurrentGames int          `json:"maxConcurrentGames"`
+	IdleSeconds        int          `json:"idleSeconds"`
+	LogLevel           string       `json:"logLevel"`
+	PendingChallenges  int          `json:"pendingChallenges"`
+	Engine             string       `json:"engine,omitempty"`
+	Games              []GameStatus `json:"games"`
+	// Endpoints are the base URLs lichess is reached through, when there's more than one.
+	Endpoints []blitz.EndpointStatus `json:"endpoints,omitempty"`
+	// RecentGames are the last few games to finish, most recent first.
+	RecentGames []FinishedGame `json:"recentGames"`
+}
+
+// Username returns the name of the lichess account the server plays for.
+func (s *Server) Username() string {
+	return s.user.Username
+}
+
+// Pause stops the server from accepting challenges or seeking games. Games in progress are unaffected.
+func (s *Server) Pause() {
+	s.gamesLock.Lock()
+	defer s.gamesLock.Unlock()
+	if !s.paused {
+		log.Info("pausing, no longer accepting challenges")
+	}
+	s.paused = true
+}
+
+// Resume undoes Pause.
+func (s *Server) Resume() {
+	s.gamesLock.Lock()
+	defer s.gamesLock.Unlock()
+	if s.paused {
+		log.Info("resuming, accepting challenges again")
+	}
+	s.paused = false
+}
+
+func (s *Server) isPaused() bool {
+	s.gamesLock.Lock()
+	defer s.gamesLock.Unlock()
+	return s.paused
+}
+
+// Drain pauses the server and waits for every game in progress to finish, so that it can be restarted without
+// abandoning anyone.
+func (s *Server) Drain(ctx context.Context) error {
+	s.Pause()
+	log.WithField("games", s.activeGames()).Info("draining")
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.activeGames() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	log.Info("drained, no games in progress")
+	return nil
+}
+
+// Resign resigns a game in progress, for when a game is stuck. If the game's stream doesn't wind down on its own
+// afterwards, we stop waiting on it.
+func (s *Server) Resign(ctx context.Context, gameID string) (bool, error) {
+	s.gamesLock.Lock()
+	game, ok := s.games[gameID]
+	s.gamesLock.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	log.WithField("game_id", gameID).Warn("resigning game at operator's request")
+	if err := s.client.Bot.ResignGame(ctx, gameID); err != nil {
+		return true, err
+	}
+	time.AfterFunc(gameFinishGrace, game.cancel)
+	return true, nil
+}
+
+// Status returns a snapshot of the server and every game in progress.
+func (s *Server) Status() Status {
+	s.gamesLock.Lock()
+	status := Status{
+		Username:           s.user.Username,
+		Paused:             s.paused,
+		Available:          s.isAvailable(),
+		Matchmaking:        s.matchmaking != nil,
+		MaxConcurrentGames: s.maxConcurrentGames,
+		LogLevel:           log.GetLevel().String(),
+		PendingChallenges:  len(s.challenges),
+		Engine:             s.EnginePath(),
+		Games:              []GameStatus{},
+		RecentGames:        append([]FinishedGame{}, s.recentGames...),
+	}
+	for _, game := range s.games {
+		status.Games = append(status.Games, game.status)
+	}
+	if len(s.games) == 0 {
+		status.IdleSeconds = int(time.Since(s.lastGameEnded).Seconds())
+	}
+	s.gamesLock.Unlock()
+
+	sort.Slice(status.Games, func(i, j int) bool {
+		return status.Games[i].ID < status.Games[j].ID
+	})
+	if left := s.rateLimit.remaining(); left > 0 {
+		status.RateLimitedFor = left.String()
+	}
+	if s.client != nil {
+		if endpoints := s.client.Endpoints(); len(endpoints) > 1 {
+			status.Endpoints = endpoints
+		}
+	}
+	return status
+}
+
+// recordFinished adds a game that has just ended to the admin API's recent games.
+func (s *Server) recordFinished(record *gameRecord) {
+	finished := FinishedGame{
+		ID:     record.id,
+		Time:   time.Now(),
+		Result: record.result(),
+		Status: record.lastState.Status,
+	}
+	if record.hasFull {
+		color := "black"
+		if record.isWhite {
+			color = "white"
+		}
+		finished.Opponent, finished.Color, finished.Speed = record.opponent().Name, color, record.full.Speed
+	}
+
+	s.gamesLock.Lock()
+	defer s.gamesLock.Unlock()
+	s.recentGames = append([]FinishedGame{finished}, s.recentGames...)
+	if len(s.recentGames) > recentGamesKept {
+		s.recentGames = s.recentGames[:recentGamesKept]
+	}
+}
+
+// publishStatus updates the admin API's view of a game in progress.
+func (s *Server) publishStatus(record *gameRecord, info engine.Info) {
+	status := GameStatus{
+		ID:          record.id,
+		Moves:       len(splitMoves(record.lastState.Moves)),
+		WhiteTimeMs: record.lastState.Wtime,
+		BlackTimeMs: record.lastState.Btime,
+		Depth:       info.Depth,
+		Nodes:       info.Nodes,
+		NPS:         info.NPS,
+		Engine:      record.enginePath,
+		Build:       record.build,
+	}
+	if record.hasFull {
+		opponent, color := record.full.Black, "white"
+		if !record.isWhite {
+			opponent, color = record.full.White, "black"
+		}
+		status.Opponent, status.Color, status.Speed = opponent.Name, color, record.full.Speed
+		if game, err := replayGame(record.full.InitialFen, splitMoves(record.lastState.Moves)); err == nil {
+			status.FEN = game.Position().String()
+		}
+	}
+	if info.HasScore {
+		status.Score = formatScore(info.Score)
+	}
+
+	s.gamesLock.Lock()
+	defer s.gamesLock.Unlock()
+	if game, ok := s.games[record.id]; ok {
+		game.status = status
+	}
+}
+
+// AdminHandler serves the admin API for the server's account. It has no authentication, so it must only be exposed
+// locally.
+//   - GET /status describes the server and every game in progress
+//   - POST /pause and POST /resume stop and start accepting challenges
+//   - POST /drain pauses and responds once every game in progress has finished
+//   - POST /games/{id}/resign resigns a game
+//   - GET /loglevel returns the log level and PUT /loglevel?level=debug sets it
+//   - GET /engine returns the engine binary for new games and POST /engine?path=... swaps it
+//   - POST /reload reloads the configuration file, if the server was started from one
+//   - GET /blocklist lists blocked users
+//   - POST /blocklist/{user}?reason=... blocks a user and DELETE /blocklist/{user} unblocks them
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, s.Status())
+	})
+	mux.HandleFunc("/pause", adminAction(func(r *http.Request) error {
+		s.Pause()
+		return nil
+	}))
+	mux.HandleFunc("/resume", adminAction(func(r *http.Request) error {
+		s.Resume()
+		return nil
+	}))
+	mux.HandleFunc("/drain", adminAction(func(r *http.Request) error {
+		return s.Drain(r.Context())
+	}))
+	mux.HandleFunc("/games/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/games/"), "/")
+		if len(parts) != 2 || parts[1] != "resign" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		found, err := s.Resign(r.Context(), parts[0])
+		switch {
+		case !found:
+			http.Error(w, "no such game in progress", http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("/engine", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			if err := s.SwapEngine(r.URL.Query().Get("path")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]string{"path": s.EnginePath()})
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.reload == nil {
+			http.Error(w, "server has no configuration file to reload", http.StatusNotImplemented)
+			return
+		}
+		if err := s.reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/blocklist", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, s.blocklist.List())
+	})
+	mux.HandleFunc("/blocklist/", func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimPrefix(r.URL.Path, "/blocklist/")
+		if user == "" || strings.Contains(user, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			if err := s.BlockUser(r.Context(), user, r.URL.Query().Get("reason"), false); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			found, err := s.UnblockUser(r.Context(), user)
+			switch {
+			case !found:
+				http.Error(w, "user is not blocked", http.StatusNotFound)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			level, err := log.ParseLevel(r.URL.Query().Get("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.WithField("level", level).Info("changing log level")
+			log.SetLevel(level)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]string{"level": log.GetLevel().String()})
+	})
+	return mux
+}
+
+// adminAction adapts a POST-only admin endpoint that has nothing to say beyond whether it worked.
+func adminAction(action func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := action(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.WithError(err).Warn("failed to write admin response")
+	}
+}
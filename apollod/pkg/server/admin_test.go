@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+func TestAdminHandler(t *testing.T) {
+	s := &Server{
+		user:               &blitz.AccountResponse{Username: "my_bot"},
+		rateLimit:          &rateLimiter{},
+		maxConcurrentGames: 2,
+		games:              map[string]*liveGame{"abc": {cancel: func() {}}},
+	}
+	s.publishStatus(&gameRecord{
+		id:        "abc",
+		isWhite:   true,
+		hasFull:   true,
+		full:      blitz.GameFull{Speed: "blitz", Black: blitz.GamePlayer{Name: "human"}},
+		lastState: blitz.GameState{Moves: "e2e4 e7e5", Wtime: 1000, Btime: 2000},
+	}, engine.Info{Depth: 10, Score: engine.Score{Centipawns: 35}, HasScore: true})
+	handler := s.AdminHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/pause", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.True(t, s.isPaused())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status Status
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&status))
+	assert.True(t, status.Paused)
+	assert.Equal(t, "my_bot", status.Username)
+	assert.Equal(t, []GameStatus{{
+		ID:          "abc",
+		Opponent:    "human",
+		Color:       "white",
+		Speed:       "blitz",
+		Moves:       2,
+		WhiteTimeMs: 1000,
+		BlackTimeMs: 2000,
+		Depth:       10,
+		Score:       "+0.35",
+		FEN:         "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2",
+	}}, status.Games)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/games/nope/resign", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/loglevel?level=verbose", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+	reloads := 0
+	s.reload = func() error {
+		reloads++
+		return nil
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, 1, reloads)
+}
+
+func TestDrainCanceled(t *testing.T) {
+	s := &Server{games: map[string]*liveGame{"abc": {cancel: func() {}}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, s.Drain(ctx))
+	assert.True(t, s.isPaused())
+
+	delete(s.games, "abc")
+	assert.NoError(t, s.Drain(context.Background()))
+}
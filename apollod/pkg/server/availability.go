@@ -0,0 +1,204 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AvailabilityConfig restricts the times at which the server takes on new games, so that, say, a bot on a metered
+// machine only plays at night. Games in progress are always played out.
+type AvailabilityConfig struct {
+	// Windows are cron-like schedules, "minute hour day-of-month month day-of-week", of the minutes during which the
+	// server accepts challenges and seeks games. "* 22-23,0-5 * * *" is every night from 10pm to 6am, and
+	// "* * * * sat,sun" is all weekend. Empty means the server is always available.
+	Windows []string `yaml:"windows"`
+	// Timezone is the IANA time zone that windows are in, such as "Europe/London". Empty means the machine's.
+	Timezone string `yaml:"timezone"`
+}
+
+// Validate checks that every window is a valid schedule and that the time zone exists.
+func (c AvailabilityConfig) Validate() error {
+	_, err := ParseAvailability(c)
+	return err
+}
+
+// Availability is a parsed AvailabilityConfig. A nil Availability is always available.
+type Availability struct {
+	windows  []schedule
+	location *time.Location
+}
+
+// ParseAvailability parses the windows of an availability configuration, returning nil if there aren't any.
+func ParseAvailability(config AvailabilityConfig) (*Availability, error) {
+	if len(config.Windows) == 0 {
+		return nil, nil
+	}
+	location := time.Local
+	if config.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, errors.Wrapf(err, "unknown timezone %q", config.Timezone)
+		}
+	}
+	availability := &Availability{location: location}
+	for _, window := range config.Windows {
+		parsed, err := parseSchedule(window)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid window %q", window)
+		}
+		availability.windows = append(availability.windows, parsed)
+	}
+	return availability, nil
+}
+
+// Available returns true if t falls within one of the windows.
+func (a *Availability) Available(t time.Time) bool {
+	if a == nil {
+		return true
+	}
+	t = t.In(a.location)
+	for _, window := range a.windows {
+		if window.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithAvailability restricts the server to accepting challenges and seeking games within the availability's windows.
+// Challenges outside them are declined with "later", and matchmaking waits for the next window.
+func WithAvailability(availability *Availability) ServerOption {
+	return func(server *Server) {
+		server.availability = availability
+	}
+}
+
+// isAvailable returns true if the server is within its availability windows.
+func (s *Server) isAvailable() bool {
+	return s.availability.Available(time.Now())
+}
+
+// schedule is a parsed cron expression. Each field is a bitmask of the values it allows.
+type schedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// anyDayOfMonth and anyDayOfWeek record whether those fields were "*". As in cron, when both are restricted a day
+	// matches if either matches.
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// scheduleField describes the range of values one field of a cron expression can take.
+type scheduleField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField     = scheduleField{name: "minute", min: 0, max: 59}
+	hourField       = scheduleField{name: "hour", min: 0, max: 23}
+	dayOfMonthField = scheduleField{name: "day of month", min: 1, max: 31}
+	monthField      = scheduleField{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// Sunday is both 0 and 7, as in cron.
+	dayOfWeekField = scheduleField{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+func parseSchedule(expr string) (schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return schedule{}, errors.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var s schedule
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return schedule{}, err
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return schedule{}, err
+	}
+	if s.dayOfMonth, err = dayOfMonthField.parse(fields[2]); err != nil {
+		return schedule{}, err
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return schedule{}, err
+	}
+	if s.dayOfWeek, err = dayOfWeekField.parse(fields[4]); err != nil {
+		return schedule{}, err
+	}
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek |= 1
+	}
+	s.anyDayOfMonth = fields[2] == "*"
+	s.anyDayOfWeek = fields[4] == "*"
+	return s, nil
+}
+
+// parse parses a field: "*", or a comma-separated list of values and ranges, any of which may have a step.
+func (f scheduleField) parse(text string) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(text, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %s %q", f.name, part)
+			}
+			part = part[:i]
+		}
+
+		low, high := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "5/15" means every 15 starting at 5.
+				high = f.max
+			}
+			if high < low {
+				return 0, errors.Errorf("%s range %q is backwards", f.name, part)
+			}
+		}
+		for v := low; v <= high; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// value parses a single number or name.
+func (f scheduleField) value(text string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(text, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < f.min || v > f.max {
+		return 0, errors.Errorf("%s must be between %d and %d, got %q", f.name, f.min, f.max, text)
+	}
+	return v, nil
+}
+
+func (s schedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if !s.anyDayOfMonth && !s.anyDayOfWeek {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvailabilityWindows(t *testing.T) {
+	availability, err := ParseAvailability(AvailabilityConfig{
+		Windows:  []string{"* 22-23,0-5 * * mon-fri", "*/30 * * * sat,sun"},
+		Timezone: "UTC",
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	at := func(s string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, s)
+		assert.NoError(t, err)
+		return parsed
+	}
+	// 2021-03-01 was a Monday.
+	assert.True(t, availability.Available(at("2021-03-01T23:15:00Z")))
+	assert.True(t, availability.Available(at("2021-03-02T05:59:00Z")))
+	assert.False(t, availability.Available(at("2021-03-02T06:00:00Z")))
+	assert.False(t, availability.Available(at("2021-03-01T12:00:00Z")))
+	assert.True(t, availability.Available(at("2021-03-06T12:30:00Z")))
+	assert.False(t, availability.Available(at("2021-03-06T12:31:00Z")))
+	assert.True(t, availability.Available(at("2021-03-02T07:00:00+08:00")), "times are compared in the window's zone")
+
+	var always *Availability
+	assert.True(t, always.Available(at("2021-03-01T12:00:00Z")))
+}
+
+func TestAvailabilityDays(t *testing.T) {
+	// As in cron, a day matches if either its day of the month or its day of the week does.
+	availability, err := ParseAvailability(AvailabilityConfig{Windows: []string{"* * 1 * 0"}, Timezone: "UTC"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, availability.Available(time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)))
+	assert.True(t, availability.Available(time.Date(2021, time.March, 7, 12, 0, 0, 0, time.UTC)))
+	assert.False(t, availability.Available(time.Date(2021, time.March, 2, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestInvalidAvailability(t *testing.T) {
+	for _, window := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "* * * foo *", "*/0 * * * *"} {
+		assert.Error(t, AvailabilityConfig{Windows: []string{window}}.Validate(), window)
+	}
+	assert.Error(t, AvailabilityConfig{Windows: []string{"* * * * *"}, Timezone: "Nowhere/Special"}.Validate())
+	assert.NoError(t, AvailabilityConfig{}.Validate())
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// BlocklistConfig controls which users the server refuses to play.
+type BlocklistConfig struct {
+	// Path is the file the blocklist is kept in, so that it survives restarts. Empty keeps it in memory only.
+	Path string `yaml:"path"`
+	// AutoBlockAfter blocks an opponent automatically once they've aborted or abandoned this many games against us
+	// within AutoBlockWindow. Zero never blocks anyone automatically.
+	AutoBlockAfter  int           `yaml:"autoBlockAfter"`
+	AutoBlockWindow time.Duration `yaml:"autoBlockWindow"`
+	// BlockOnLichess also blocks users on lichess itself, which stops them from challenging us at all.
+	BlockOnLichess bool `yaml:"blockOnLichess"`
+}
+
+// DefaultBlocklistConfig blocks opponents who abort or abandon three games in a day, without telling lichess.
+func DefaultBlocklistConfig() BlocklistConfig {
+	return BlocklistConfig{AutoBlockAfter: 3, AutoBlockWindow: 24 * time.Hour}
+}
+
+// BlockedUser is an entry in the blocklist.
+type BlockedUser struct {
+	// ID is the user's lichess ID, which is their username in lowercase.
+	ID     string    `json:"id"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+	// Automatic is true if the server blocked the user itself, rather than its operator.
+	Automatic bool `json:"automatic"`
+}
+
+// Blocklist is the set of users whose challenges we decline and whom we never challenge. Like a Pool, it can be
+// shared by servers playing for several accounts, so that a user blocked by one is blocked by all.
+type Blocklist struct {
+	config BlocklistConfig
+
+	lock     sync.Mutex
+	blocked  map[string]BlockedUser
+	offenses map[string][]time.Time
+}
+
+// OpenBlocklist loads the blocklist from the configured file, if there is one and it exists yet.
+func OpenBlocklist(config BlocklistConfig) (*Blocklist, error) {
+	list := &Blocklist{
+		config:   config,
+		blocked:  make(map[string]BlockedUser),
+		offenses: make(map[string][]time.Time),
+	}
+	if config.Path == "" {
+		return list, nil
+	}
+
+	data, err := ioutil.ReadFile(config.Path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read blocklist")
+	}
+	var users []BlockedUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse blocklist %s", config.Path)
+	}
+	for _, user := range users {
+		list.blocked[strings.ToLower(user.ID)] = user
+	}
+	return list, nil
+}
+
+// WithBlocklist sets the server's blocklist.
+func WithBlocklist(list *Blocklist) ServerOption {
+	return func(server *Server) {
+		server.blocklist = list
+	}
+}
+
+// Blocked returns true if the user is on the blocklist.
+func (b *Blocklist) Blocked(id string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, ok := b.blocked[strings.ToLower(id)]
+	return ok
+}
+
+// List returns everyone on the blocklist, in the order they were blocked.
+func (b *Blocklist) List() []BlockedUser {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	users := make([]BlockedUser, 0, len(b.blocked))
+	for _, user := range b.blocked {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Time.Before(users[j].Time)
+	})
+	return users
+}
+
+// Block adds a user to the blocklist, replacing any existing entry for them.
+func (b *Blocklist) Block(user BlockedUser) error {
+	user.ID = strings.ToLower(user.ID)
+	if user.Time.IsZero() {
+		user.Time = time.Now().UTC()
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.blocked[user.ID] = user
+	return b.save()
+}
+
+// Unblock removes a user from the blocklist and forgives their past offenses. It returns false if they weren't on it.
+func (b *Blocklist) Unblock(id string) (bool, error) {
+	id = strings.ToLower(id)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.blocked[id]; !ok {
+		return false, nil
+	}
+	delete(b.blocked, id)
+	delete(b.offenses, id)
+	return true, b.save()
+}
+
+// offend records that a user has aborted or abandoned a game, returning true if that's enough to block them
+// automatically.
+func (b *Blocklist) offend(id string, now time.Time) bool {
+	id = strings.ToLower(id)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.config.AutoBlockAfter <= 0 {
+		return false
+	}
+	if _, ok := b.blocked[id]; ok {
+		return false
+	}
+
+	recent := []time.Time{now}
+	for _, offense := range b.offenses[id] {
+		if now.Sub(offense) < b.config.AutoBlockWindow {
+			recent = append(recent, offense)
+		}
+	}
+	b.offenses[id] = recent
+	return len(recent) >= b.config.AutoBlockAfter
+}
+
+// save writes the blocklist to its file, if it has one. The caller must hold the lock.
+func (b *Blocklist) save() error {
+	if b.config.Path == "" {
+		return nil
+	}
+	users := make([]BlockedUser, 0, len(b.blocked))
+	for _, user := range b.blocked {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write the new list alongside the old one and swap it in, so that a crash can't leave us with half a list.
+	temp := b.config.Path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to write blocklist")
+	}
+	return errors.Wrap(os.Rename(temp, b.config.Path), "failed to write blocklist")
+}
+
+// BlockUser adds a user to the blocklist and, if configured to, blocks them on lichess too.
+func (s *Server) BlockUser(ctx context.Context, id, reason string, automatic bool) error {
+	log.WithFields(log.Fields{
+		"user":      id,
+		"reason":    reason,
+		"automatic": automatic,
+	}).Warn("blocking user")
+	if err := s.blocklist.Block(BlockedUser{ID: id, Reason: reason, Automatic: automatic}); err != nil {
+		return err
+	}
+	if s.blocklist.config.BlockOnLichess {
+		if err := s.client.Users.BlockUser(ctx, id); err != nil {
+			return errors.Wrap(err, "blocked locally, but failed to block on lichess")
+		}
+	}
+	return nil
+}
+
+// UnblockUser removes a user from the blocklist and, if configured to block on lichess, unblocks them there too. It
+// returns false if they weren't blocked.
+func (s *Server) UnblockUser(ctx context.Context, id string) (bool, error) {
+	found, err := s.blocklist.Unblock(id)
+	if !found || err != nil {
+		return found, err
+	}
+	log.WithField("user", id).Info("unblocking user")
+	if s.blocklist.config.BlockOnLichess {
+		if err := s.client.Users.UnblockUser(ctx, id); err != nil {
+			return true, errors.Wrap(err, "unblocked locally, but failed to unblock on lichess")
+		}
+	}
+	return true, nil
+}
+
+// reportOffense notes that our opponent aborted or abandoned a game, blocking them if they make a habit of it.
+func (s *Server) reportOffense(ctx context.Context, record *gameRecord, offense string) {
+	opponent := record.opponent()
+	if opponent.ID == "" {
+		return
+	}
+	record.logger().WithFields(log.Fields{
+		"opponent": opponent.Name,
+		"offense":  offense,
+	}).Info("opponent did not finish the game")
+	if !s.blocklist.offend(opponent.ID, time.Now()) {
+		return
+	}
+	if err := s.BlockUser(ctx, opponent.ID, "repeatedly aborted or abandoned games", true); err != nil {
+		record.logger().WithError(err).Warn("failed to block opponent")
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+func TestBlocklistPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apollod-blocklist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := BlocklistConfig{Path: filepath.Join(dir, "blocklist.json")}
+
+	list, err := OpenBlocklist(config)
+	require.NoError(t, err)
+	require.NoError(t, list.Block(BlockedUser{ID: "Troll", Reason: "spam"}))
+	require.NoError(t, list.Block(BlockedUser{ID: "aborter", Automatic: true}))
+	found, err := list.Unblock("aborter")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	reopened, err := OpenBlocklist(config)
+	require.NoError(t, err)
+	assert.True(t, reopened.Blocked("troll"))
+	assert.True(t, reopened.Blocked("TROLL"))
+	assert.False(t, reopened.Blocked("aborter"))
+	users := reopened.List()
+	require.Len(t, users, 1)
+	assert.Equal(t, "spam", users[0].Reason)
+}
+
+func TestBlocklistOffenses(t *testing.T) {
+	list, err := OpenBlocklist(BlocklistConfig{AutoBlockAfter: 2, AutoBlockWindow: time.Hour})
+	require.NoError(t, err)
+	now := time.Now()
+
+	assert.False(t, list.offend("aborter", now.Add(-2*time.Hour)))
+	// The first offense has expired by now.
+	assert.False(t, list.offend("aborter", now))
+	assert.True(t, list.offend("Aborter", now.Add(time.Minute)))
+
+	disabled, err := OpenBlocklist(BlocklistConfig{})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.False(t, disabled.offend("aborter", now))
+	}
+}
+
+func TestAdminBlocklist(t *testing.T) {
+	list, err := OpenBlocklist(BlocklistConfig{})
+	require.NoError(t, err)
+	s := &Server{user: &blitz.AccountResponse{Username: "my_bot"}, blocklist: list}
+	handler := s.AdminHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/blocklist/Troll?reason=spam", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.True(t, list.Blocked("troll"))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/blocklist", nil))
+	var users []BlockedUser
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&users))
+	require.Len(t, users, 1)
+	assert.Equal(t, "troll", users[0].ID)
+	assert.False(t, users[0].Automatic)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/blocklist/troll", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.False(t, list.Blocked("troll"))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/blocklist/troll", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
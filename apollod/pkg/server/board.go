@@ -0,0 +1,91 @@
+package server
+
+import (
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// localBoard follows a game move by move, so that we can check the moves we're about to play without trusting the
+// engine. Lichess doesn't take kindly to bots that repeatedly submit illegal moves.
+type localBoard struct {
+	initialFen string
+	moves      []string
+	game       *chess.Game
+	// following is false if we can't follow the game. notnil/chess doesn't understand chess960 castling or the rules
+	// of other variants, so those games are never followed.
+	following bool
+}
+
+func newLocalBoard(initialFen string, follow bool) *localBoard {
+	return &localBoard{initialFen: initialFen, following: follow}
+}
+
+// sync brings the board up to date with the moves played so far. Usually that's a move or two more than last time,
+// but a takeback means starting over.
+func (b *localBoard) sync(moves []string) {
+	if !b.following {
+		return
+	}
+	if b.game == nil || !extends(moves, b.moves) {
+		game, err := replayGame(b.initialFen, nil)
+		if err != nil {
+			b.abandon(err)
+			return
+		}
+		b.game, b.moves = game, nil
+	}
+	for _, move := range moves[len(b.moves):] {
+		if err := b.game.MoveStr(move); err != nil {
+			b.abandon(err)
+			return
+		}
+	}
+	b.moves = append([]string(nil), moves...)
+}
+
+// abandon stops following a game we've failed to keep up with. Lichess is the authority on what's legal, so this only
+// costs us the ability to double-check the engine.
+func (b *localBoard) abandon(err error) {
+	log.WithError(err).Warn("failed to follow game on local board, not validating moves")
+	b.game, b.moves, b.following = nil, nil, false
+}
+
+// legal returns true if move can be played in the current position, or if we aren't following the game and can't tell.
+func (b *localBoard) legal(move string) bool {
+	if b.game == nil {
+		return true
+	}
+	for _, valid := range b.game.ValidMoves() {
+		if valid.String() == move {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackMove picks a legal move in the current position, for when the engine can't. Any legal move beats losing on
+// time or submitting garbage.
+func (b *localBoard) fallbackMove() (string, error) {
+	if b.game == nil {
+		return "", errors.New("not following game, no fallback move available")
+	}
+	valid := b.game.ValidMoves()
+	if len(valid) == 0 {
+		return "", errors.New("no legal moves in position")
+	}
+	return valid[0].String(), nil
+}
+
+// extends returns true if moves begins with every move in prefix.
+func extends(moves, prefix []string) bool {
+	if len(prefix) > len(moves) {
+		return false
+	}
+	for i, move := range prefix {
+		if moves[i] != move {
+			return false
+		}
+	}
+	return true
+}
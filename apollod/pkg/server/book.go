@@ -0,0 +1,55 @@
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+)
+
+// BookConfig limits how deep into a game the server plays from its opening book.
+type BookConfig struct {
+	// MaxPly is the number of plies, counted from the start of the game, for which we'll play book moves. 0 is
+	// unlimited and a negative number disables the book.
+	MaxPly int `yaml:"maxPly"`
+	// Speeds override MaxPly for games at a particular lichess speed. Fast games can afford to lean on the book for
+	// longer, since every move the engine doesn't have to think about is time saved on the clock.
+	Speeds map[string]int `yaml:"speeds"`
+}
+
+// WithBook has the server play moves out of an opening book, instantly, for as long as the game stays in it.
+func WithBook(openings *book.Book, config BookConfig) ServerOption {
+	return func(server *Server) {
+		server.book = openings
+		server.bookConfig = config
+	}
+}
+
+// maxBookPly returns how many plies into a game at the given speed we may play from the book.
+func (c BookConfig) maxBookPly(speed string) int {
+	if ply, ok := c.Speeds[speed]; ok {
+		return ply
+	}
+	return c.MaxPly
+}
+
+// bookMove looks up a book move for the position reached after moves have been played from initialFen. If we prepared
+// for the opponent, moves into the positions they play most are picked less often.
+func (s *Server) bookMove(speed, initialFen string, moves []string, prep *PrepSide) (string, bool) {
+	if s.book == nil {
+		return "", false
+	}
+	limit := s.bookConfig.maxBookPly(speed)
+	if limit < 0 || (limit > 0 && len(moves) >= limit) {
+		return "", false
+	}
+
+	game, err := replayGame(initialFen, moves)
+	if err != nil {
+		log.WithError(err).Warn("failed to replay game for book lookup")
+		return "", false
+	}
+	if prep == nil {
+		return s.book.Lookup(game.Position())
+	}
+	return s.book.LookupWeighted(game.Position(), prep.avoid(game.Position()))
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"math/rand"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// baselineBuild is the build that games not played by the canary are tagged with.
+	baselineBuild = "baseline"
+	// defaultCanaryBuild is the canary's build, unless it is given a name.
+	defaultCanaryBuild = "canary"
+)
+
+// CanaryConfig splits live games between the usual engine and a candidate build of it, so that the candidate can be
+// tried against real opponents before it replaces the usual engine. Results are tagged with the build that played
+// them, so the two can be compared.
+type CanaryConfig struct {
+	// Fraction is the share of new games, between 0 and 1, played with the canary. Zero turns the canary off.
+	Fraction float64 `yaml:"fraction"`
+	// Name is the build that the canary's results are tagged with, such as a version or commit. If empty, it is
+	// "canary".
+	Name string `yaml:"name"`
+	// Engine is the canary's engine. Anything it leaves unset is inherited from the profile the game would otherwise
+	// be played with, so a canary can be a new binary, new options for the usual binary, or both.
+	Engine EngineProfile `yaml:"engine"`
+}
+
+// Validate checks that the canary's fraction is a fraction and that it differs from the usual engine.
+func (c CanaryConfig) Validate() error {
+	if c.Fraction < 0 || c.Fraction > 1 {
+		return errors.New("fraction must be between 0 and 1")
+	}
+	if c.Fraction > 0 && c.Engine.Path == "" && len(c.Engine.Options) == 0 && !c.Engine.IncrementalPosition {
+		return errors.New("engine must set a path or options for the canary to differ from the usual engine")
+	}
+	if c.Name == baselineBuild {
+		return errors.Errorf("name must not be %q, which tags the usual engine's results", baselineBuild)
+	}
+	return nil
+}
+
+// build is the name the canary's results are tagged with.
+func (c CanaryConfig) build() string {
+	if c.Name == "" {
+		return defaultCanaryBuild
+	}
+	return c.Name
+}
+
+// WithCanary plays a fraction of new games with a canary build of the engine.
+func WithCanary(config CanaryConfig) ServerOption {
+	return func(server *Server) {
+		server.canary = config
+	}
+}
+
+// pickBuild decides whether a new game is played with the canary, returning the profile to play it with and the
+// build to tag its results with. Without a canary, games aren't tagged at all.
+func (s *Server) pickBuild(profile EngineProfile) (EngineProfile, string) {
+	s.engineLock.Lock()
+	canary := s.canary
+	s.engineLock.Unlock()
+	if canary.Fraction <= 0 {
+		return profile, ""
+	}
+	if rand.Float64() >= canary.Fraction {
+		return profile, baselineBuild
+	}
+	return overrideProfile(profile, canary.Engine), canary.build()
+}
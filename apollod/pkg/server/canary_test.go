@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickBuild(t *testing.T) {
+	server := &Server{}
+	profile := EngineProfile{Path: "apollo", Options: map[string]string{"Hash": "64", "Threads": "1"}}
+
+	picked, build := server.pickBuild(profile)
+	assert.Equal(t, profile, picked)
+	assert.Equal(t, "", build, "games aren't tagged without a canary")
+
+	server.canary = CanaryConfig{Fraction: 1, Name: "v2", Engine: EngineProfile{Options: map[string]string{"Hash": "128"}}}
+	picked, build = server.pickBuild(profile)
+	assert.Equal(t, "v2", build)
+	assert.Equal(t, "apollo", picked.Path)
+	assert.Equal(t, map[string]string{"Hash": "128", "Threads": "1"}, picked.Options)
+
+	server.canary = CanaryConfig{Fraction: 1e-9, Engine: EngineProfile{Path: "apollo-next"}}
+	picked, build = server.pickBuild(profile)
+	assert.Equal(t, baselineBuild, build)
+	assert.Equal(t, profile, picked)
+}
+
+func TestCanaryValidate(t *testing.T) {
+	assert.NoError(t, CanaryConfig{}.Validate())
+	assert.NoError(t, CanaryConfig{Fraction: 0.1, Engine: EngineProfile{Path: "apollo-next"}}.Validate())
+	assert.Error(t, CanaryConfig{Fraction: 1.5, Engine: EngineProfile{Path: "apollo-next"}}.Validate())
+	assert.Error(t, CanaryConfig{Fraction: 0.1}.Validate())
+	assert.Error(t, CanaryConfig{Fraction: 0.1, Name: "baseline", Engine: EngineProfile{Path: "apollo-next"}}.Validate())
+}
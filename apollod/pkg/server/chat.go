@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const defaultChatCommandInterval = 5 * time.Second
+
+// ChatConfig controls how the server interacts with game chat.
+type ChatConfig struct {
+	// Commands enables responding to chat commands such as !eval.
+	Commands bool `yaml:"commands"`
+	// CommandInterval is the minimum time between two responses to chat commands in the same game.
+	CommandInterval time.Duration `yaml:"commandInterval"`
+	// TakebackMessage is sent to the player room when we decline a takeback. Empty sends nothing.
+	TakebackMessage string `yaml:"takebackMessage"`
+	// GameOverMessage is sent to the player room when a game ends. Empty sends nothing.
+	GameOverMessage string `yaml:"gameOverMessage"`
+	// Commentary posts our evaluation and expected line to the spectator room at interesting moments.
+	Commentary bool `yaml:"commentary"`
+	// CommentarySwing is the change in evaluation, in centipawns, between two of our moves that's worth commenting on.
+	CommentarySwing int `yaml:"commentarySwing"`
+	// CommentaryEvery is the number of our moves between routine comments. Zero comments only on swings.
+	CommentaryEvery int `yaml:"commentaryEvery"`
+	// Owner is a lichess user who may control the server by chatting commands like "apollo pause" in any of our
+	// games. Empty disables owner commands.
+	Owner string `yaml:"owner"`
+}
+
+// DefaultChatConfig answers chat commands, politely declines takebacks, and says good game.
+func DefaultChatConfig() ChatConfig {
+	return ChatConfig{
+		Commands:        true,
+		CommandInterval: defaultChatCommandInterval,
+		TakebackMessage: "Sorry, I don't accept takebacks. Good luck with the rest of the game!",
+		GameOverMessage: "Good game, thanks for playing!",
+		CommentarySwing: defaultCommentarySwing,
+		CommentaryEvery: defaultCommentaryEvery,
+	}
+}
+
+// WithChat sets the server's chat configuration.
+func WithChat(config ChatConfig) ServerOption {
+	return func(server *Server) {
+		server.chat = config
+	}
+}
+
+const chatHelp = "Commands: !eval (my evaluation), !depth (search depth), !pv (expected line), !help"
+
+// chatResponder answers chat commands in a single game, rate-limited so that spectators can't flood the chat.
+type chatResponder struct {
+	interval  time.Duration
+	lastReply time.Time
+}
+
+func newChatResponder(interval time.Duration) *chatResponder {
+	if interval == 0 {
+		interval = defaultChatCommandInterval
+	}
+	return &chatResponder{interval: interval}
+}
+
+// respond returns the reply to a chat line, or the empty string if the line doesn't warrant a reply.
+func (c *chatResponder) respond(line blitz.ChatLine, info engine.Info, now time.Time) string {
+	command := strings.ToLower(strings.TrimSpace(line.Text))
+	if !strings.HasPrefix(command, "!") || now.Sub(c.lastReply) < c.interval {
+		return ""
+	}
+
+	var reply string
+	switch strings.Fields(command)[0] {
+	case "!help":
+		reply = chatHelp
+	case "!eval":
+		if !info.HasScore {
+			reply = "I haven't evaluated a position yet."
+		} else {
+			reply = "Evaluation (from my side): " + formatScore(info.Score)
+		}
+	case "!depth":
+		if info.Depth == 0 {
+			reply = "I haven't searched a position yet."
+		} else {
+			reply = fmt.Sprintf("Depth %d (selective %d), %d nodes at %d nps", info.Depth, info.SelDepth, info.Nodes, info.NPS)
+		}
+	case "!pv":
+		if len(info.PV) == 0 {
+			reply = "I don't have a principal variation yet."
+		} else {
+			reply = "Expected line: " + strings.Join(info.PV, " ")
+		}
+	default:
+		return ""
+	}
+
+	c.lastReply = now
+	return reply
+}
+
+// handleChat answers a chat line in the same room that it was sent in.
+func (s *Server) handleChat(ctx context.Context, gameID string, responder *chatResponder, line blitz.ChatLine, info engine.Info) {
+	if s.isOwner(line.Username) && s.handleOwnerCommand(ctx, gameID, line) {
+		return
+	}
+	if !s.chatConfig().Commands || strings.EqualFold(line.Username, s.user.Username) {
+		return
+	}
+
+	reply := responder.respond(line, info, time.Now())
+	if reply == "" {
+		return
+	}
+	if err := s.writeChat(ctx, gameID, line.Room, reply); err != nil {
+		log.WithError(err).Warn("failed to respond to chat command")
+	}
+}
+
+// declineTakeback declines our opponent's takeback offer and explains why in the player chat.
+func (s *Server) declineTakeback(ctx context.Context, record *gameRecord) {
+	log.WithField("game_id", record.id).Info("declining takeback offer")
+	if err := s.client.Bot.HandleTakebackOffer(ctx, record.id, false); err != nil {
+		log.WithError(err).Warn("failed to decline takeback offer")
+		return
+	}
+	if message := s.gameChat(record).TakebackMessage; message != "" {
+		if err := s.writeChat(ctx, record.id, "player", message); err != nil {
+			log.WithError(err).Warn("failed to explain declined takeback")
+		}
+	}
+}
+
+// formatScore renders an engine score for humans.
+func formatScore(score engine.Score) string {
+	switch {
+	case score.Mate > 0:
+		return fmt.Sprintf("mate in %d", score.Mate)
+	case score.Mate < 0:
+		return fmt.Sprintf("mated in %d", -score.Mate)
+	default:
+		return fmt.Sprintf("%+.2f", float64(score.Centipawns)/100)
+	}
+}
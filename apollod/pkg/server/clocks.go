@@ -0,0 +1,99 @@
+package server
+
+import (
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+const (
+	// maxRealClock is the most time that can be on a real-time lichess clock. Anything more is a correspondence or
+	// unlimited game's placeholder, which doesn't mean anything to an engine.
+	maxRealClock = 24 * time.Hour
+	// defaultUntimedMoveTime is how long to search in games without a usable clock.
+	defaultUntimedMoveTime = 30 * time.Second
+)
+
+// ClockConfig translates lichess clocks into search limits that make sense to the engine. Correspondence and
+// unlimited games report clocks of days or none at all, which engines either spend hours on or can't budget at all,
+// so those games are searched for a fixed time instead.
+type ClockConfig struct {
+	// UntimedMoveTime is how long to search each move in correspondence and unlimited games.
+	UntimedMoveTime time.Duration `yaml:"untimedMoveTime"`
+	// MaxMoveTime caps the search time per move at each lichess speed, whenever the server picks the search time
+	// rather than handing the engine its clocks.
+	MaxMoveTime map[string]time.Duration `yaml:"maxMoveTime"`
+	// MovesToGo, if set for a speed, is passed to the engine as "movestogo" along with the clocks, telling it how many
+	// moves to spread its time over.
+	MovesToGo map[string]int `yaml:"movesToGo"`
+}
+
+// DefaultClockConfig searches untimed games for thirty seconds a move and otherwise leaves the clocks alone.
+func DefaultClockConfig() ClockConfig {
+	return ClockConfig{UntimedMoveTime: defaultUntimedMoveTime}
+}
+
+// WithClocks sets how the server translates lichess clocks for the engine.
+func WithClocks(config ClockConfig) ServerOption {
+	return func(server *Server) {
+		server.clocks = config
+	}
+}
+
+// searchLimits tells the engine how long it may search for a move.
+type searchLimits struct {
+	// moveTime, if nonzero, is exactly how long to search, and the clocks are left out.
+	moveTime     time.Duration
+	wtime, btime int
+	winc, binc   int
+	movesToGo    int
+	// remaining is the time the watchdog treats as left on our clock. Zero disables the watchdog.
+	remaining time.Duration
+}
+
+// limits works out the search limits for our next move at the given lichess speed. budget is how long the time
+// manager wants to search for, or zero to leave it to the engine.
+func (c ClockConfig) limits(speed string, state blitz.GameState, isWhite bool, budget time.Duration) searchLimits {
+	remaining := clockRemaining(state, isWhite)
+	if speed == "correspondence" || remaining <= 0 || remaining > maxRealClock {
+		moveTime := c.untimedMoveTime()
+		if limit := c.MaxMoveTime[speed]; limit > 0 && moveTime > limit {
+			moveTime = limit
+		}
+		// There's no clock to lose on, but the engine still shouldn't be allowed to hang the game forever.
+		return searchLimits{moveTime: moveTime, remaining: 2 * moveTime}
+	}
+
+	if budget > 0 {
+		if limit := c.MaxMoveTime[speed]; limit > 0 && budget > limit {
+			budget = limit
+		}
+		return searchLimits{moveTime: budget, remaining: remaining}
+	}
+	return searchLimits{
+		wtime:     clampClock(state.Wtime),
+		btime:     clampClock(state.Btime),
+		winc:      clampClock(state.Winc),
+		binc:      clampClock(state.Binc),
+		movesToGo: c.MovesToGo[speed],
+		remaining: remaining,
+	}
+}
+
+func (c ClockConfig) untimedMoveTime() time.Duration {
+	if c.UntimedMoveTime <= 0 {
+		return defaultUntimedMoveTime
+	}
+	return c.UntimedMoveTime
+}
+
+// clampClock keeps a clock reading, in milliseconds, within what a real-time game could have.
+func clampClock(ms int) int {
+	if ms < 0 {
+		return 0
+	}
+	if limit := int(maxRealClock / time.Millisecond); ms > limit {
+		return limit
+	}
+	return ms
+}
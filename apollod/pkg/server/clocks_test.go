@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+func TestClockLimits(t *testing.T) {
+	config := DefaultClockConfig()
+	blitzState := blitz.GameState{Wtime: 60000, Btime: 55000, Winc: 1000, Binc: 1000}
+
+	limits := config.limits("blitz", blitzState, true, 0)
+	assert.Equal(t, searchLimits{wtime: 60000, btime: 55000, winc: 1000, binc: 1000, remaining: time.Minute}, limits)
+
+	limits = config.limits("blitz", blitzState, true, 2*time.Second)
+	assert.Equal(t, 2*time.Second, limits.moveTime)
+
+	// Correspondence clocks run in days, and unlimited games have a placeholder or nothing at all.
+	days := blitz.GameState{Wtime: 3 * 24 * 60 * 60 * 1000, Btime: 3 * 24 * 60 * 60 * 1000}
+	limits = config.limits("correspondence", days, false, time.Hour)
+	assert.Equal(t, searchLimits{moveTime: defaultUntimedMoveTime, remaining: 2 * defaultUntimedMoveTime}, limits)
+	unlimited := blitz.GameState{Wtime: 2147483647, Btime: 2147483647}
+	assert.Equal(t, defaultUntimedMoveTime, config.limits("classical", unlimited, true, 0).moveTime)
+	assert.Equal(t, defaultUntimedMoveTime, config.limits("classical", blitz.GameState{}, true, 0).moveTime)
+
+	config.MaxMoveTime = map[string]time.Duration{"blitz": time.Second, "correspondence": 10 * time.Second}
+	config.MovesToGo = map[string]int{"blitz": 40}
+	assert.Equal(t, time.Second, config.limits("blitz", blitzState, true, 2*time.Second).moveTime)
+	assert.Equal(t, 40, config.limits("blitz", blitzState, true, 0).movesToGo)
+	assert.Equal(t, 10*time.Second, config.limits("correspondence", days, true, 0).moveTime)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const (
+	defaultCommentarySwing = 150
+	defaultCommentaryEvery = 10
+	// commentaryPVLength is the number of moves of the principal variation we include in commentary.
+	commentaryPVLength = 6
+	// mateCentipawns stands in for a forced mate when measuring how much an evaluation has changed.
+	mateCentipawns = 10000
+)
+
+// commentator decides when a game is interesting enough to tell spectators about, and what to say.
+type commentator struct {
+	swing int
+	every int
+
+	lastScore          engine.Score
+	hasScore           bool
+	movesSinceComment  int
+	announcedTablebase bool
+}
+
+func newCommentator(config ChatConfig) *commentator {
+	return &commentator{swing: config.CommentarySwing, every: config.CommentaryEvery}
+}
+
+// afterSearch returns commentary on the engine's search for our latest move, or the empty string if there's nothing
+// worth saying. Evaluation swings are always worth mentioning; otherwise we summarize every so often.
+func (c *commentator) afterSearch(info engine.Info) string {
+	if !info.HasScore {
+		return ""
+	}
+	previous, hadScore := c.lastScore, c.hasScore
+	c.lastScore, c.hasScore = info.Score, true
+	c.movesSinceComment++
+
+	var comment string
+	switch {
+	case hadScore && c.swing > 0 && abs(centipawns(info.Score)-centipawns(previous)) >= c.swing:
+		comment = fmt.Sprintf("The evaluation swung from %s to %s.", formatScore(previous), formatScore(info.Score))
+	case c.every > 0 && c.movesSinceComment >= c.every:
+		comment = fmt.Sprintf("I evaluate this position at %s (depth %d).", formatScore(info.Score), info.Depth)
+	default:
+		return ""
+	}
+
+	if len(info.PV) > 0 {
+		pv := info.PV
+		if len(pv) > commentaryPVLength {
+			pv = pv[:commentaryPVLength]
+		}
+		comment += " Expected line: " + strings.Join(pv, " ")
+	}
+	c.movesSinceComment = 0
+	return comment
+}
+
+// enteredTablebase returns commentary the first time the game reaches a position covered by the tablebase.
+func (c *commentator) enteredTablebase() string {
+	if c.announcedTablebase {
+		return ""
+	}
+	c.announcedTablebase = true
+	return "We've reached the endgame tablebase. From here on, I'm playing perfectly."
+}
+
+// comment posts commentary to the spectator room. Players can't see the spectator room, so commentary never gives our
+// opponent any information about what we're thinking.
+func (s *Server) comment(ctx context.Context, record *gameRecord, comment string) {
+	if !s.gameChat(record).Commentary || comment == "" {
+		return
+	}
+	if err := s.writeChat(ctx, record.id, "spectator", comment); err != nil {
+		log.WithError(err).Warn("failed to post commentary")
+	}
+}
+
+// centipawns converts a score to centipawns, treating forced mates as very large evaluations.
+func centipawns(score engine.Score) int {
+	switch {
+	case score.Mate > 0:
+		return mateCentipawns
+	case score.Mate < 0:
+		return -mateCentipawns
+	default:
+		return score.Centipawns
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/crash"
+)
+
+const (
+	// crashTranscriptLines is how much of a game's UCI transcript goes into a crash report.
+	crashTranscriptLines = 20
+	// crashEventLength caps the size of the lichess event in a crash report, since a GameFull can be long.
+	crashEventLength = 2000
+)
+
+// WithCrashReporter reports panics while playing games, along with the game, the end of its UCI transcript, and the
+// last event lichess sent about it.
+func WithCrashReporter(reporter *crash.Reporter) ServerOption {
+	return func(server *Server) {
+		server.crashes = reporter
+	}
+}
+
+// describeCrash adds what we know about the game to a crash report.
+func (g *gameRecord) describeCrash(event *crash.Event) {
+	event.Tags = map[string]string{"game_id": g.id}
+	if g.hasFull {
+		event.Tags["opponent"] = g.opponent().Name
+	}
+	event.Extra = map[string]interface{}{
+		"engine":     g.enginePath,
+		"last_uci":   g.uciTail.String(),
+		"last_event": g.lastEvent,
+	}
+}
+
+// noteEvent remembers the last event lichess sent about the game, for crash reports.
+func (g *gameRecord) noteEvent(event blitz.GameEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if len(data) > crashEventLength {
+		data = data[:crashEventLength]
+	}
+	g.lastEvent = string(data)
+}
+
+// tailWriter keeps the last few lines written to it.
+type tailWriter struct {
+	lock    sync.Mutex
+	max     int
+	lines   []string
+	partial string
+}
+
+func newTailWriter(lines int) *tailWriter {
+	return &tailWriter{max: lines}
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	lines := strings.Split(t.partial+string(p), "\n")
+	t.partial = lines[len(lines)-1]
+	t.lines = append(t.lines, lines[:len(lines)-1]...)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return strings.Join(append(append([]string(nil), t.lines...), t.partial), "\n")
+}
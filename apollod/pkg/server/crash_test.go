@@ -0,0 +1,26 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/crash"
+)
+
+func TestDescribeCrash(t *testing.T) {
+	record := &gameRecord{id: "abc", uciTail: newTailWriter(3), enginePath: "apollo"}
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(record.uciTail, "> go depth %d\n", i)
+	}
+	fmt.Fprint(record.uciTail, "< info ")
+	record.noteEvent(blitz.GameState{Type: "gameState", Moves: "e2e4"})
+
+	var event crash.Event
+	record.describeCrash(&event)
+	assert.Equal(t, map[string]string{"game_id": "abc"}, event.Tags)
+	assert.Equal(t, "> go depth 2\n> go depth 3\n> go depth 4\n< info ", event.Extra["last_uci"])
+	assert.Contains(t, event.Extra["last_event"], `"moves":"e2e4"`)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+// moveDelayClockFraction bounds the delay to this fraction of our remaining clock, so that being friendly never costs
+// us the game.
+const moveDelayClockFraction = 20
+
+// MoveDelayConfig makes the server take at least a little while over every move against human opponents, who tend
+// to find instant replies unsettling. Moves are delayed for a random time between Min and Max, less however long
+// they took to find.
+type MoveDelayConfig struct {
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+	// Speeds are the lichess speeds to delay moves at. Delays only make sense at slower time controls.
+	Speeds []string `yaml:"speeds"`
+}
+
+// DefaultMoveDelayConfig doesn't delay moves at all, but would only delay slow games if it did.
+func DefaultMoveDelayConfig() MoveDelayConfig {
+	return MoveDelayConfig{Speeds: []string{"rapid", "classical", "correspondence"}}
+}
+
+// WithMoveDelay sets the server's move delay configuration.
+func WithMoveDelay(config MoveDelayConfig) ServerOption {
+	return func(server *Server) {
+		server.moveDelay = config
+	}
+}
+
+// appliesTo returns true if moves should be delayed in the given game, in which we play white if isWhite.
+func (c MoveDelayConfig) appliesTo(full blitz.GameFull, isWhite bool) bool {
+	if c.Min <= 0 && c.Max <= 0 {
+		return false
+	}
+	opponent := full.Black
+	if !isWhite {
+		opponent = full.White
+	}
+	if opponent.Title == "BOT" {
+		return false
+	}
+	for _, speed := range c.Speeds {
+		if speed == full.Speed {
+			return true
+		}
+	}
+	return false
+}
+
+// pick chooses how long our next move should take.
+func (c MoveDelayConfig) pick() time.Duration {
+	if c.Max <= c.Min {
+		return c.Min
+	}
+	return c.Min + time.Duration(rand.Int63n(int64(c.Max-c.Min)+1))
+}
+
+// delayMove waits until a move we started thinking about at started has taken as long as the move delay asks for,
+// given the time left on our clock when we started.
+func (s *Server) delayMove(ctx context.Context, started time.Time, remaining time.Duration) {
+	delay := s.moveDelay.pick()
+	if remaining > 0 && delay > remaining/moveDelayClockFraction {
+		delay = remaining / moveDelayClockFraction
+	}
+	wait := delay - time.Since(started)
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
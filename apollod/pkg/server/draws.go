@@ -0,0 +1,123 @@
+package server
+
+import (
+	"github.com/notnil/chess"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const (
+	// drawAcceptThreshold is the largest evaluation, in centipawns, at which the default etiquette accepts our
+	// opponent's draw offer.
+	drawAcceptThreshold = 25
+	// drawOfferThreshold is the largest evaluation, in centipawns, that we consider dead drawn.
+	drawOfferThreshold = 10
+	// drawOfferAfter is the number of consecutive dead drawn evaluations after which we'll offer a draw.
+	drawOfferAfter = 6
+	// drawOfferMaxPieces is the largest number of pieces on the board for which we'll offer a draw.
+	drawOfferMaxPieces = 6
+	// drawOfferInterval is the minimum number of plies between two of our draw offers.
+	drawOfferInterval = 20
+)
+
+// drawTracker decides whether to accept or offer draws over the course of a game, based on the evaluations that the
+// engine reports for our moves.
+type drawTracker struct {
+	etiquette      Etiquette
+	lastScore      engine.Score
+	hasScore       bool
+	drawnEvals     int
+	lastOfferPly   int
+	answeredOffers map[int]bool
+}
+
+func newDrawTracker(etiquette Etiquette) *drawTracker {
+	return &drawTracker{
+		etiquette:      etiquette,
+		lastOfferPly:   -drawOfferInterval,
+		answeredOffers: make(map[int]bool),
+	}
+}
+
+// observe records the engine's evaluation after searching for our move.
+func (d *drawTracker) observe(score engine.Score, ok bool) {
+	d.lastScore, d.hasScore = score, ok
+	if ok && !score.IsMate() && abs(score.Centipawns) <= drawOfferThreshold {
+		d.drawnEvals++
+	} else {
+		d.drawnEvals = 0
+	}
+}
+
+// needsAnswer returns true if our opponent has a pending draw offer at this ply that we haven't responded to yet.
+func (d *drawTracker) needsAnswer(isWhite bool, state blitz.GameState, ply int) bool {
+	offered := state.Wdraw
+	if isWhite {
+		offered = state.Bdraw
+	}
+	return offered && !d.answeredOffers[ply]
+}
+
+// shouldAccept decides whether to accept a draw offer in the given game. We accept only if our etiquette takes draws,
+// the engine thinks the position is level, and nothing is hanging, i.e. the last move was neither a capture nor a check.
+func (d *drawTracker) shouldAccept(game *chess.Game, ply int) bool {
+	d.answeredOffers[ply] = true
+	if !d.etiquette.AcceptDraws {
+		return false
+	}
+	if !d.hasScore || d.lastScore.IsMate() || abs(d.lastScore.Centipawns) > d.etiquette.DrawMargin {
+		return false
+	}
+	return isQuiet(game)
+}
+
+// shouldOffer decides whether to offer a draw alongside the move we're about to play at the given ply.
+func (d *drawTracker) shouldOffer(game *chess.Game, ply int) bool {
+	if !d.etiquette.OfferDraws || d.drawnEvals < drawOfferAfter || ply-d.lastOfferPly < drawOfferInterval {
+		return false
+	}
+	if len(game.Position().Board().SquareMap()) > drawOfferMaxPieces {
+		return false
+	}
+	d.lastOfferPly = ply
+	return true
+}
+
+// isQuiet returns true if the last move played in this game was neither a capture nor a check.
+func isQuiet(game *chess.Game) bool {
+	moves := game.Moves()
+	if len(moves) == 0 {
+		return true
+	}
+	last := moves[len(moves)-1]
+	return !last.HasTag(chess.Capture) && !last.HasTag(chess.Check)
+}
+
+// replayGame reconstructs a game from lichess's initial FEN and UCI move list.
+func replayGame(initialFen string, moves []string) (*chess.Game, error) {
+	notation := chess.LongAlgebraicNotation{}
+	options := []func(*chess.Game){chess.UseNotation(notation)}
+	if initialFen != "" && initialFen != "startpos" {
+		fen, err := chess.FEN(initialFen)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, fen)
+	}
+
+	game := chess.NewGame(options...)
+	for _, move := range moves {
+		if err := game.MoveStr(move); err != nil {
+			return nil, err
+		}
+	}
+	return game, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
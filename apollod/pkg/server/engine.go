@@ -0,0 +1,330 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// EngineProfile describes an engine binary, the protocol it speaks, and the options to play with it.
+type EngineProfile struct {
+	// Path is the engine binary. If empty, apollo is looked up on the PATH.
+	Path string `yaml:"path"`
+	// Protocol is the protocol the engine speaks, "uci" or "cecp". If empty, it is UCI.
+	Protocol string `yaml:"protocol"`
+	// Args are passed to the engine binary on its command line, such as lc0's --weights.
+	Args []string `yaml:"args"`
+	// Env are environment variables set for the engine binary, on top of apollod's own.
+	Env map[string]string `yaml:"env"`
+	// Dir is the working directory of the engine binary. If empty, it is apollod's.
+	Dir string `yaml:"dir"`
+	// Options are engine options applied after the handshake.
+	Options map[string]string `yaml:"options"`
+	// OptionsFile is a YAML or JSON file of more engine options, such as Hash, Threads, and SyzygyPath, read by
+	// LoadOptionsFile. Options set in Options take precedence over it.
+	OptionsFile string `yaml:"optionsFile"`
+	// IncrementalPosition sends the engine only the moves played since its last search, for engines that keep their
+	// position between searches and accept "position moves ...".
+	IncrementalPosition bool `yaml:"incrementalPosition"`
+	// Restarts is how many times in a game a crashed engine is replaced with a new one, which is sent the game's
+	// position and searches again. Zero gives up on the game when the engine crashes.
+	Restarts int `yaml:"restarts"`
+	// Keepalive pings UCI engines while they wait for their next search, so that one that has hung is replaced before
+	// it's asked to search rather than after our clock has run down.
+	Keepalive KeepaliveConfig `yaml:"keepalive"`
+	// AutoSize sets Hash and Threads, unless Options set them, to an equal share of the machine for every engine that
+	// may run at once.
+	AutoSize bool `yaml:"autoSize"`
+	// EngineGames, if set, keeps engines running from one game to the next, restarting each after it has played that
+	// many games, instead of starting one afresh for every game. Engines are only shared by games of the same profile
+	// and variant, and their options are applied again for every game.
+	EngineGames int `yaml:"engineGames"`
+}
+
+// KeepaliveConfig is how often idle engines are pinged, and how long they have to answer.
+type KeepaliveConfig struct {
+	// Interval is the time between pings. Zero disables the keepalive.
+	Interval time.Duration `yaml:"interval"`
+	// Deadline is how long an engine has to answer a ping before it's considered hung.
+	Deadline time.Duration `yaml:"deadline"`
+}
+
+// Validate checks that an enabled keepalive gives engines time to answer.
+func (c KeepaliveConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	if c.Interval > 0 && c.Deadline <= 0 {
+		return errors.New("deadline must be positive")
+	}
+	return nil
+}
+
+// WithEngine sets the engine profile used for games. Profiles, keyed by lichess speed (bullet, blitz, rapid,
+// classical, correspondence), override the default profile for games at that speed.
+func WithEngine(profile EngineProfile, profiles map[string]EngineProfile) ServerOption {
+	return func(server *Server) {
+		server.engine = profile
+		server.engineProfiles = profiles
+	}
+}
+
+// WithSwappableEngines sets the engine binaries that SwapEngine may switch to, such as the builds that have been
+// deployed to the machine. Without any, only apollo from the PATH may be swapped to.
+func WithSwappableEngines(paths []string) ServerOption {
+	return func(server *Server) {
+		server.swappable = make(map[string]bool, len(paths))
+		for _, path := range paths {
+			server.swappable[filepath.Clean(path)] = true
+		}
+	}
+}
+
+// SwapEngine switches the engine binary that new games are played with, so that a new engine can be deployed without
+// restarting the server. Only the binaries given to WithSwappableEngines, or an empty path for apollo from the PATH,
+// may be swapped to, since the admin API that swaps engines is unauthenticated. Games in progress finish on the
+// engines they started with. The new binary has to complete a handshake before anything is switched, so a broken
+// deploy leaves the old engine in place. The new binary must speak the same protocol, and take the same arguments, as
+// the old one. Speed-specific profiles with their own binaries are unaffected.
+func (s *Server) SwapEngine(path string) error {
+	if path != "" && !s.swappable[filepath.Clean(path)] {
+		return errors.Errorf("engine %q is not one of the engines that may be swapped to", path)
+	}
+	s.engineLock.Lock()
+	profile := s.engine
+	s.engineLock.Unlock()
+	profile.Path = path
+	client, err := s.launchEngine(profile, ioutil.Discard)
+	if err != nil {
+		return errors.Wrapf(err, "engine %q failed to start", path)
+	}
+	err = client.IsReady()
+	name := client.Name()
+	shutdownApollo(client)
+	if err != nil {
+		return errors.Wrapf(err, "engine %q is not responding", path)
+	}
+
+	s.engineLock.Lock()
+	old := s.engine.Path
+	s.engine.Path = path
+	s.engineLock.Unlock()
+	log.WithFields(log.Fields{
+		"old":    old,
+		"new":    path,
+		"engine": name,
+	}).Info("swapped engine, new games will be played with it")
+	return nil
+}
+
+// EnginePath returns the engine binary that new games are played with.
+func (s *Server) EnginePath() string {
+	s.engineLock.Lock()
+	defer s.engineLock.Unlock()
+	return s.engine.Path
+}
+
+// uciVariants are the names that engines playing lichess's variants, such as Fairy-Stockfish, know them by in their
+// UCI_Variant option.
+var uciVariants = map[string]string{
+	"antichess":     "antichess",
+	"atomic":        "atomic",
+	"crazyhouse":    "crazyhouse",
+	"horde":         "horde",
+	"kingOfTheHill": "kingofthehill",
+	"racingKings":   "racingkings",
+	"threeCheck":    "3check",
+}
+
+// WithVariantEngines routes games of lichess variants to engines that play them. Profiles, keyed by lichess variant
+// (crazyhouse, atomic, and so on), override the speed's profile for games of that variant, which lets the server
+// accept challenges to variants that apollo doesn't play.
+func WithVariantEngines(profiles map[string]EngineProfile) ServerOption {
+	return func(server *Server) {
+		server.variantEngines = profiles
+	}
+}
+
+// profileFor selects the engine profile for a game at the given speed and of the given lichess variant. Anything a
+// speed-specific profile leaves unset is inherited from the default profile, and anything a variant's profile leaves
+// unset from that.
+func (s *Server) profileFor(speed, variant string) EngineProfile {
+	s.engineLock.Lock()
+	defer s.engineLock.Unlock()
+	profile := s.engine
+	if override, ok := s.engineProfiles[speed]; ok {
+		profile = overrideProfile(profile, override)
+	}
+	if override, ok := s.variantEngines[variant]; ok {
+		profile = overrideProfile(profile, override)
+	}
+	return profile
+}
+
+// routesVariant returns true if games of a lichess variant are played by an engine of their own rather than apollo.
+func (s *Server) routesVariant(variant string) bool {
+	s.engineLock.Lock()
+	defer s.engineLock.Unlock()
+	return s.variantEngines[variant].Path != ""
+}
+
+// LoadOptionsFile adds the options in the profile's options file, if it has one, to those it sets itself.
+func (p *EngineProfile) LoadOptionsFile() error {
+	if p.OptionsFile == "" {
+		return nil
+	}
+	options, err := engine.LoadOptions(p.OptionsFile)
+	if err != nil {
+		return err
+	}
+	for name, value := range p.Options {
+		options[name] = value
+	}
+	p.Options = options
+	return nil
+}
+
+// overrideProfile applies whatever an override sets to a profile. A different binary is played with the protocol the
+// override gives, and options are merged, with the override's taking precedence.
+func overrideProfile(profile, override EngineProfile) EngineProfile {
+	if override.Path != "" {
+		profile.Path = override.Path
+		// A different engine doesn't necessarily speak the same protocol or extensions.
+		profile.Protocol = override.Protocol
+		profile.Args, profile.Env, profile.Dir = override.Args, override.Env, override.Dir
+		profile.IncrementalPosition = false
+	}
+	if override.IncrementalPosition {
+		profile.IncrementalPosition = true
+	}
+	if override.Restarts > 0 {
+		profile.Restarts = override.Restarts
+	}
+	if override.Keepalive.Interval > 0 {
+		profile.Keepalive = override.Keepalive
+	}
+	if override.AutoSize {
+		profile.AutoSize = true
+	}
+	if override.EngineGames > 0 {
+		profile.EngineGames = override.EngineGames
+	}
+	options := make(map[string]string)
+	for name, value := range profile.Options {
+		options[name] = value
+	}
+	for name, value := range override.Options {
+		options[name] = value
+	}
+	profile.Options = options
+	return profile
+}
+
+// Program returns how the profile's engine binary is run.
+func (p EngineProfile) Program() engine.ProgramOptions {
+	return engine.ProgramOptions{Args: p.Args, Env: p.Env, Dir: p.Dir}
+}
+
+// startEngine launches an engine from a profile, applies its options, and readies it for a new game of the given
+// lichess variant. Everything said to and by the engine is written to transcript.
+func (s *Server) startEngine(profile EngineProfile, variant blitz.Variant, transcript io.Writer) (engine.Engine, error) {
+	log.WithFields(log.Fields{
+		"path":    profile.Path,
+		"variant": variant.Key,
+	}).Info("starting engine")
+
+	client, err := s.launchEngine(profile, transcript)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.configureEngine(client, profile, variant); err != nil {
+		shutdownApollo(client)
+		return nil, err
+	}
+	if err := client.NewGame(isChess960(variant)); err != nil {
+		shutdownApollo(client)
+		return nil, err
+	}
+	if keepalive, ok := client.(keepaliveEngine); ok && profile.Keepalive.Interval > 0 {
+		keepalive.Keepalive(profile.Keepalive.Interval, profile.Keepalive.Deadline)
+	}
+	return client, nil
+}
+
+// configureEngine applies a profile's options to an engine for a game of the given lichess variant, setting UCI_Variant
+// for the variants that engines know by that option. The engine's moves in standard games are checked for legality.
+// Chess960 mode is left to NewGame.
+func (s *Server) configureEngine(client engine.Engine, profile EngineProfile, variant blitz.Variant) error {
+	options := profile.Options
+	if profile.AutoSize {
+		options = engine.AutoSize(client, options, s.host, s.pool.engines)
+	}
+	if name, ok := uciVariants[variant.Key]; ok && client.HasOption("UCI_Variant") {
+		base := options
+		options = make(map[string]string, len(base)+1)
+		for option, value := range base {
+			options[option] = value
+		}
+		if _, ok := options["UCI_Variant"]; !ok {
+			options["UCI_Variant"] = name
+		}
+	}
+
+	// Engines that declare nothing (like Apollo) get everything we're configured with.
+	skipped, err := engine.Configure(client, options)
+	for _, skip := range skipped {
+		log.WithError(skip.Reason).WithField("option", skip.Name).Warn("skipping configured engine option")
+	}
+	if err != nil {
+		return err
+	}
+
+	if incremental, ok := client.(incrementalEngine); ok {
+		incremental.SetIncremental(profile.IncrementalPosition)
+	}
+	if checked, ok := client.(checkedEngine); ok && !isChess960(variant) && !hasOwnRules(variant) {
+		checked.SetMoveChecker(engine.LegalMove)
+	}
+	return nil
+}
+
+// checkedEngine is implemented by engines that can judge their own best moves before returning them. Only standard
+// games are checked, since engine.LegalMove doesn't know the rules of the others.
+type checkedEngine interface {
+	SetMoveChecker(check engine.MoveChecker)
+}
+
+// keepaliveEngine is implemented by engines that can be pinged while they're idle to check that they haven't hung.
+type keepaliveEngine interface {
+	Keepalive(interval, deadline time.Duration)
+	Health() error
+}
+
+// engineHealth returns why an engine's keepalive considers it hung, or nil if it doesn't, or the engine has none.
+func engineHealth(client engine.Engine) error {
+	if keepalive, ok := client.(keepaliveEngine); ok {
+		return keepalive.Health()
+	}
+	return nil
+}
+
+// incrementalEngine is implemented by engines that can optionally be sent only the moves played since their last
+// search, which is an extension that they must opt in to.
+type incrementalEngine interface {
+	SetIncremental(enabled bool)
+}
+
+// lastInfo returns what the engine reported about its most recent search, or nothing if no engine is running.
+func lastInfo(client engine.Engine) engine.Info {
+	if client == nil {
+		return engine.Info{}
+	}
+	return client.Info()
+}
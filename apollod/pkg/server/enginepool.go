@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// enginePool keeps the engines of a profile that sets EngineGames running between its games of one variant. Engines
+// aren't shared between variants, since they would carry over UCI_Variant and the move checker.
+type enginePool struct {
+	*engine.Pool
+
+	lock        sync.Mutex
+	transcripts map[engine.Engine]*gameTranscript
+}
+
+// gameTranscript is the transcript of a pooled engine, which goes to the game that it's playing, and nowhere while it
+// waits for its next game.
+type gameTranscript struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (t *gameTranscript) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.writer.Write(p)
+}
+
+func (t *gameTranscript) set(writer io.Writer) {
+	t.lock.Lock()
+	t.writer = writer
+	t.lock.Unlock()
+}
+
+// transcript returns where an engine of the pool writes its transcript.
+func (p *enginePool) transcript(client engine.Engine) *gameTranscript {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.transcripts[client]
+}
+
+// drop forgets an engine that's no longer in the pool.
+func (p *enginePool) drop(client engine.Engine) {
+	p.Forget(client)
+	p.lock.Lock()
+	delete(p.transcripts, client)
+	p.lock.Unlock()
+}
+
+// enginePool returns the pool of engines for games of a variant with a profile, creating it if there isn't one yet.
+func (s *Server) enginePool(profile EngineProfile, variant blitz.Variant) *enginePool {
+	key := fmt.Sprintf("%s %+v", variant.Key, profile)
+	s.enginePoolsLock.Lock()
+	defer s.enginePoolsLock.Unlock()
+	if pool, ok := s.enginePools[key]; ok {
+		return pool
+	}
+	if s.enginePools == nil {
+		s.enginePools = make(map[string]*enginePool)
+	}
+
+	pool := &enginePool{transcripts: make(map[engine.Engine]*gameTranscript)}
+	pool.Pool = engine.NewPool(func() (engine.Engine, error) {
+		// A new engine's handshake isn't part of any game's transcript; its options are, since every game applies them.
+		transcript := &gameTranscript{writer: ioutil.Discard}
+		client, err := s.startEngine(profile, variant, transcript)
+		if err != nil {
+			return nil, err
+		}
+		pool.lock.Lock()
+		pool.transcripts[client] = transcript
+		pool.lock.Unlock()
+		return client, nil
+	}, profile.EngineGames)
+	s.enginePools[key] = pool
+	return pool
+}
+
+// acquireEngine returns an engine from a profile, readied for a new game of the given lichess variant, which writes
+// everything said to and by it to transcript. Profiles that set EngineGames reuse an engine left idle by an earlier
+// game if there is one, applying the profile's options to it again.
+func (s *Server) acquireEngine(profile EngineProfile, variant blitz.Variant, transcript io.Writer) (engine.Engine, error) {
+	if profile.EngineGames <= 0 {
+		return s.startEngine(profile, variant, transcript)
+	}
+	pool := s.enginePool(profile, variant)
+	client, err := pool.Get(isChess960(variant))
+	if err != nil {
+		return nil, err
+	}
+	pool.transcript(client).set(transcript)
+	if err := s.configureEngine(client, profile, variant); err != nil {
+		s.releaseEngine(profile, variant, client, false)
+		return nil, err
+	}
+	return client, nil
+}
+
+// releaseEngine is done with an engine from acquireEngine once its game is over. Pooled engines are kept for another
+// game if ok says that this one went well; the rest are asked to exit.
+func (s *Server) releaseEngine(profile EngineProfile, variant blitz.Variant, client engine.Engine, ok bool) {
+	if profile.EngineGames <= 0 {
+		shutdownApollo(client)
+		return
+	}
+	pool := s.enginePool(profile, variant)
+	pool.transcript(client).set(ioutil.Discard)
+	if !pool.Put(client, ok) {
+		pool.drop(client)
+	}
+}
+
+// dropEngine forgets an engine from acquireEngine that has been killed during its game.
+func (s *Server) dropEngine(profile EngineProfile, variant blitz.Variant, client engine.Engine) {
+	if profile.EngineGames > 0 {
+		s.enginePool(profile, variant).drop(client)
+	}
+}
+
+// closeEnginePools shuts down every engine that's waiting for another game.
+func (s *Server) closeEnginePools() {
+	s.enginePoolsLock.Lock()
+	pools := s.enginePools
+	s.enginePools = nil
+	s.enginePoolsLock.Unlock()
+
+	for _, pool := range pools {
+		pool.Close()
+	}
+}
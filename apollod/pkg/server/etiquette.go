@@ -0,0 +1,202 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// DefaultEtiquetteProfile is the etiquette profile games are played with unless configured otherwise.
+const DefaultEtiquetteProfile = "default"
+
+// ChatVerbosity is how much the server says in game chat, on top of what the chat configuration allows.
+type ChatVerbosity string
+
+const (
+	// ChatNormal chats as the chat configuration says.
+	ChatNormal ChatVerbosity = ""
+	// ChatQuiet only answers chat commands: no greeting, no commentary, and no messages about takebacks or the end of
+	// the game.
+	ChatQuiet ChatVerbosity = "quiet"
+	// ChatChatty also posts commentary to spectators, even if the chat configuration doesn't.
+	ChatChatty ChatVerbosity = "chatty"
+)
+
+// Etiquette is how the server conducts itself in a game: when it gives up, what it does about draws, whether it asks
+// for a rematch, and how much it chats.
+type Etiquette struct {
+	// ResignAt is how far behind, in centipawns, the engine must think we are for us to resign, once it has thought so
+	// for ResignAfter of our moves in a row. Being mated counts as being behind by any amount. Zero never resigns.
+	ResignAt    int `yaml:"resignAt"`
+	ResignAfter int `yaml:"resignAfter"`
+	// AcceptDraws accepts our opponent's draw offers when the engine's evaluation is within DrawMargin centipawns of
+	// level and nothing is hanging.
+	AcceptDraws bool `yaml:"acceptDraws"`
+	DrawMargin  int  `yaml:"drawMargin"`
+	// OfferDraws offers draws in endgames the engine has thought dead drawn for several moves.
+	OfferDraws bool `yaml:"offerDraws"`
+	// Rematch challenges our opponent to a rematch after the game, as games.rematch does for every game.
+	Rematch bool `yaml:"rematch"`
+	// Chat is how much we say in the game's chat.
+	Chat ChatVerbosity `yaml:"chat"`
+}
+
+// Validate checks that the thresholds aren't negative and that the chat verbosity exists.
+func (e Etiquette) Validate() error {
+	if e.ResignAt < 0 || e.ResignAfter < 0 || e.DrawMargin < 0 {
+		return errors.New("resignAt, resignAfter and drawMargin must not be negative")
+	}
+	if e.ResignAt > 0 && e.ResignAfter == 0 {
+		return errors.New("resignAfter must be positive to resign")
+	}
+	switch e.Chat {
+	case ChatNormal, ChatQuiet, ChatChatty:
+	default:
+		return errors.Errorf("chat must be quiet or chatty, not %q", e.Chat)
+	}
+	return nil
+}
+
+// BuiltinEtiquettes are the etiquette profiles that are always available.
+//   - default accepts and offers draws in dead level positions and plays every game to the end.
+//   - sporting resigns lost positions, takes draws more readily, asks for a rematch, and comments on the game.
+//   - relentless never resigns, never draws, and keeps quiet.
+//   - tournament plays every game to the end, only draws dead level positions when asked, and keeps quiet.
+var BuiltinEtiquettes = map[string]Etiquette{
+	DefaultEtiquetteProfile: {AcceptDraws: true, DrawMargin: drawAcceptThreshold, OfferDraws: true},
+	"sporting": {
+		ResignAt:    1000,
+		ResignAfter: 5,
+		AcceptDraws: true,
+		DrawMargin:  50,
+		OfferDraws:  true,
+		Rematch:     true,
+		Chat:        ChatChatty,
+	},
+	"relentless": {Chat: ChatQuiet},
+	"tournament": {AcceptDraws: true, DrawMargin: drawOfferThreshold, Chat: ChatQuiet},
+}
+
+// EtiquetteConfig picks the etiquette profile that every game is played with.
+type EtiquetteConfig struct {
+	// Profile is the profile for every game whose speed doesn't have one of its own.
+	Profile string `yaml:"profile"`
+	// Speeds override Profile for games at a particular lichess speed.
+	Speeds map[string]string `yaml:"speeds"`
+	// Profiles define profiles beyond the builtin ones, or replace builtin ones of the same name.
+	Profiles map[string]Etiquette `yaml:"profiles"`
+}
+
+// DefaultEtiquetteConfig plays every game with the default profile.
+func DefaultEtiquetteConfig() EtiquetteConfig {
+	return EtiquetteConfig{Profile: DefaultEtiquetteProfile}
+}
+
+// Validate checks every custom profile and that every profile picked exists.
+func (c EtiquetteConfig) Validate() error {
+	for name, profile := range c.Profiles {
+		if err := profile.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid profile %q", name)
+		}
+	}
+	if _, ok := c.profile(c.Profile); !ok {
+		return errors.Errorf("unknown profile %q, expected one of %s", c.Profile, strings.Join(c.names(), ", "))
+	}
+	for speed, name := range c.Speeds {
+		if _, ok := c.profile(name); !ok {
+			return errors.Errorf("speeds: unknown profile %q for %s", name, speed)
+		}
+	}
+	return nil
+}
+
+// profile looks up a profile by name, custom profiles first. An empty name is the default profile.
+func (c EtiquetteConfig) profile(name string) (Etiquette, bool) {
+	if name == "" {
+		name = DefaultEtiquetteProfile
+	}
+	if profile, ok := c.Profiles[name]; ok {
+		return profile, true
+	}
+	profile, ok := BuiltinEtiquettes[name]
+	return profile, ok
+}
+
+func (c EtiquetteConfig) names() []string {
+	var names []string
+	for name := range BuiltinEtiquettes {
+		names = append(names, name)
+	}
+	for name := range c.Profiles {
+		if _, ok := BuiltinEtiquettes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forSpeed returns the etiquette for a game at the given lichess speed.
+func (c EtiquetteConfig) forSpeed(speed string) Etiquette {
+	name := c.Profile
+	if override, ok := c.Speeds[speed]; ok {
+		name = override
+	}
+	profile, _ := c.profile(name)
+	return profile
+}
+
+// WithEtiquette sets the etiquette profiles that the server's games are played with.
+func WithEtiquette(config EtiquetteConfig) ServerOption {
+	return func(server *Server) {
+		server.etiquette = config
+	}
+}
+
+// etiquetteFor returns the etiquette for a new game at the given lichess speed.
+func (s *Server) etiquetteFor(speed string) Etiquette {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.etiquette.forSpeed(speed)
+}
+
+// gameChat returns the chat configuration for a game, quieted or livened up by its etiquette.
+func (s *Server) gameChat(record *gameRecord) ChatConfig {
+	chat := s.chatConfig()
+	switch record.etiquette.Chat {
+	case ChatQuiet:
+		chat.TakebackMessage, chat.GameOverMessage, chat.Commentary = "", "", false
+	case ChatChatty:
+		chat.Commentary = true
+	}
+	return chat
+}
+
+// resignTracker decides when to resign over the course of a game, based on the evaluations that the engine reports
+// for our moves.
+type resignTracker struct {
+	etiquette   Etiquette
+	losingMoves int
+}
+
+func newResignTracker(etiquette Etiquette) *resignTracker {
+	return &resignTracker{etiquette: etiquette}
+}
+
+// observe records the engine's evaluation after searching for our move.
+func (r *resignTracker) observe(score engine.Score, ok bool) {
+	losing := ok && (score.Mate < 0 || (!score.IsMate() && score.Centipawns <= -r.etiquette.ResignAt))
+	if losing {
+		r.losingMoves++
+	} else {
+		r.losingMoves = 0
+	}
+}
+
+// shouldResign returns true once the engine has thought the game lost for long enough.
+func (r *resignTracker) shouldResign() bool {
+	return r.etiquette.ResignAt > 0 && r.losingMoves >= r.etiquette.ResignAfter
+}
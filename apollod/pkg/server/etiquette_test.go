@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+func TestEtiquetteForSpeed(t *testing.T) {
+	config := EtiquetteConfig{
+		Profile:  "tournament",
+		Speeds:   map[string]string{"bullet": "relentless", "classical": "gentle"},
+		Profiles: map[string]Etiquette{"gentle": {ResignAt: 500, ResignAfter: 3, Rematch: true}},
+	}
+	if !assert.NoError(t, config.Validate()) {
+		t.FailNow()
+	}
+	assert.Equal(t, BuiltinEtiquettes["tournament"], config.forSpeed("blitz"))
+	assert.Equal(t, BuiltinEtiquettes["relentless"], config.forSpeed("bullet"))
+	assert.True(t, config.forSpeed("classical").Rematch)
+
+	config.Speeds["rapid"] = "unheard-of"
+	assert.Error(t, config.Validate())
+	assert.Error(t, EtiquetteConfig{Profiles: map[string]Etiquette{"chatty": {Chat: "loud"}}}.Validate())
+	assert.NoError(t, EtiquetteConfig{}.Validate(), "no profile is the default profile")
+}
+
+func TestResignTracker(t *testing.T) {
+	resigns := newResignTracker(Etiquette{ResignAt: 800, ResignAfter: 3})
+	resigns.observe(engine.Score{Centipawns: -900}, true)
+	resigns.observe(engine.Score{Centipawns: -900}, true)
+	resigns.observe(engine.Score{Centipawns: -100}, true)
+	resigns.observe(engine.Score{Mate: -5}, true)
+	resigns.observe(engine.Score{Centipawns: -800}, true)
+	assert.False(t, resigns.shouldResign(), "the position recovered in between")
+	resigns.observe(engine.Score{Centipawns: -1200}, true)
+	assert.True(t, resigns.shouldResign())
+
+	never := newResignTracker(BuiltinEtiquettes["relentless"])
+	for i := 0; i < 10; i++ {
+		never.observe(engine.Score{Mate: -1}, true)
+	}
+	assert.False(t, never.shouldResign())
+}
+
+func TestGameChat(t *testing.T) {
+	s := &Server{chat: DefaultChatConfig()}
+	quiet := s.gameChat(&gameRecord{etiquette: BuiltinEtiquettes["relentless"]})
+	assert.Empty(t, quiet.GameOverMessage)
+	assert.Empty(t, quiet.TakebackMessage)
+	assert.True(t, quiet.Commands, "chat commands are still answered")
+	assert.True(t, s.gameChat(&gameRecord{etiquette: BuiltinEtiquettes["sporting"]}).Commentary)
+	assert.Equal(t, DefaultChatConfig(), s.gameChat(&gameRecord{etiquette: BuiltinEtiquettes[DefaultEtiquetteProfile]}))
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// subscriberBuffer is how many events a slow observer may fall behind by before it starts missing events.
+const subscriberBuffer = 64
+
+// LiveEvent is something that happened in one of our games, as broadcast to external observers such as stream
+// overlays. It only carries what any spectator on lichess could see, plus apollo's own evaluation.
+type LiveEvent struct {
+	// Type is "start", "move", or "end".
+	Type string `json:"type"`
+	// Account is the bot account playing the game.
+	Account string    `json:"account"`
+	Game    string    `json:"game"`
+	Time    time.Time `json:"time"`
+
+	// Set for "start".
+	Opponent string `json:"opponent,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Speed    string `json:"speed,omitempty"`
+	Rated    bool   `json:"rated,omitempty"`
+
+	// Set for "move": the move just played, the number of half-moves played including it, and the clocks.
+	Move        string `json:"move,omitempty"`
+	Ply         int    `json:"ply,omitempty"`
+	WhiteTimeMs int    `json:"whiteTimeMs,omitempty"`
+	BlackTimeMs int    `json:"blackTimeMs,omitempty"`
+	// Eval is apollo's latest evaluation, from its own side, and the depth it searched to.
+	Eval  string `json:"eval,omitempty"`
+	Depth int    `json:"depth,omitempty"`
+
+	// Set for "end".
+	Result string `json:"result,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// eventBus broadcasts live events to every subscriber. Subscribers that can't keep up miss events rather than holding
+// up the games. The zero value is ready to use.
+type eventBus struct {
+	lock        sync.Mutex
+	subscribers map[chan LiveEvent]struct{}
+}
+
+// subscribe returns a channel of every event published from now on, and a function to stop receiving them.
+func (b *eventBus) subscribe() (<-chan LiveEvent, func()) {
+	events := make(chan LiveEvent, subscriberBuffer)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan LiveEvent]struct{})
+	}
+	b.subscribers[events] = struct{}{}
+	return events, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		delete(b.subscribers, events)
+	}
+}
+
+func (b *eventBus) publish(event LiveEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// broadcastStart tells observers that a game has begun.
+func (s *Server) broadcastStart(record *gameRecord) {
+	color := "black"
+	if record.isWhite {
+		color = "white"
+	}
+	s.events.publish(LiveEvent{
+		Type:     "start",
+		Account:  s.Username(),
+		Game:     record.id,
+		Opponent: record.opponent().Name,
+		Color:    color,
+		Speed:    record.full.Speed,
+		Rated:    record.full.Rated,
+	})
+}
+
+// broadcastMoves tells observers about any moves played since we last told them, along with the clocks and apollo's
+// latest evaluation.
+func (s *Server) broadcastMoves(record *gameRecord, info engine.Info) {
+	moves := splitMoves(record.lastState.Moves)
+	if len(moves) <= record.broadcastPly {
+		// Either nothing new, or a takeback. Observers hear about the next move either way.
+		record.broadcastPly = len(moves)
+		return
+	}
+	event := LiveEvent{
+		Type:        "move",
+		Account:     s.Username(),
+		Game:        record.id,
+		WhiteTimeMs: record.lastState.Wtime,
+		BlackTimeMs: record.lastState.Btime,
+		Depth:       info.Depth,
+	}
+	if info.HasScore {
+		event.Eval = formatScore(info.Score)
+	}
+	for ply := record.broadcastPly; ply < len(moves); ply++ {
+		event.Move, event.Ply = moves[ply], ply+1
+		s.events.publish(event)
+	}
+	record.broadcastPly = len(moves)
+}
+
+// broadcastEnd tells observers that a game is over.
+func (s *Server) broadcastEnd(record *gameRecord) {
+	s.events.publish(LiveEvent{
+		Type:    "end",
+		Account: s.Username(),
+		Game:    record.id,
+		Result:  record.result(),
+		Status:  record.lastState.Status,
+	})
+}
+
+// EventsHandler streams live events from every game to observers as server-sent events, one JSON-encoded LiveEvent
+// per message. It has no control over the server, so unlike the admin API it's safe to expose more widely.
+func (s *Server) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		events, unsubscribe := s.events.subscribe()
+		defer unsubscribe()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		for {
+			select {
+			case event := <-events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.WithError(err).Warn("failed to encode live event")
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+func TestBroadcastMoves(t *testing.T) {
+	s := &Server{user: &blitz.AccountResponse{Username: "my_bot"}}
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	record := &gameRecord{id: "abc", lastState: blitz.GameState{Moves: "e2e4 e7e5", Wtime: 1000, Btime: 2000}}
+	s.broadcastMoves(record, engine.Info{Depth: 8, Score: engine.Score{Centipawns: 35}, HasScore: true})
+	s.broadcastMoves(record, engine.Info{})
+
+	first, second := <-events, <-events
+	assert.Equal(t, "e2e4", first.Move)
+	assert.Equal(t, 1, first.Ply)
+	assert.Equal(t, "e7e5", second.Move)
+	assert.Equal(t, "+0.35", second.Eval)
+	assert.Equal(t, "my_bot", second.Account)
+	assert.Empty(t, events, "nothing new the second time")
+}
+
+func TestEventsHandler(t *testing.T) {
+	s := &Server{user: &blitz.AccountResponse{Username: "my_bot"}}
+	server := httptest.NewServer(s.EventsHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	s.broadcastEnd(&gameRecord{id: "abc", isWhite: true, lastState: blitz.GameState{Status: "mate", Winner: "white"}})
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: end\n", line)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	var event LiveEvent
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+	assert.Equal(t, "abc", event.Game)
+	assert.Equal(t, "win", event.Result)
+}
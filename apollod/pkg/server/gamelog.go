@@ -0,0 +1,88 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// WithGameLogs has the server write a log and an engine transcript for every game into dir, named after the game.
+// Everything logged about a game still goes to the server's log too; the per-game files just save having to pick one
+// game out of several being played at once.
+func WithGameLogs(dir string) ServerOption {
+	return func(server *Server) {
+		server.gameLogDir = dir
+	}
+}
+
+// gameLog is where everything about a single game is logged.
+type gameLog struct {
+	// entry logs to the server's log, and to the game's own log file if there is one. It carries the game's ID and,
+	// once we know them, our opponent and color.
+	entry *log.Entry
+	// transcript receives the engine's UCI transcript. The game's own transcript file is timestamped, to show how long
+	// the engine took over each command.
+	transcript io.Writer
+	files      []*os.File
+}
+
+// openGameLog sets up logging for a game. If the game's log files can't be created, the game is logged to the
+// server's log alone.
+func (s *Server) openGameLog(gameID string) *gameLog {
+	fallback := &gameLog{entry: log.WithField("game_id", gameID), transcript: ioutil.Discard}
+	if s.gameLogDir == "" {
+		return fallback
+	}
+
+	if err := os.MkdirAll(s.gameLogDir, 0755); err != nil {
+		log.WithError(err).Warn("failed to create game log directory")
+		return fallback
+	}
+	logFile, err := os.Create(filepath.Join(s.gameLogDir, gameID+".log"))
+	if err != nil {
+		log.WithError(err).Warn("failed to create game log")
+		return fallback
+	}
+	transcriptFile, err := os.Create(filepath.Join(s.gameLogDir, gameID+".uci"))
+	if err != nil {
+		log.WithError(err).Warn("failed to create engine transcript")
+		logFile.Close()
+		return fallback
+	}
+
+	standard := log.StandardLogger()
+	logger := log.New()
+	logger.SetOutput(io.MultiWriter(standard.Out, logFile))
+	logger.SetFormatter(standard.Formatter)
+	logger.SetLevel(standard.GetLevel())
+	return &gameLog{
+		entry:      logger.WithField("game_id", gameID),
+		transcript: engine.NewTimestampedTranscript(transcriptFile),
+		files:      []*os.File{logFile, transcriptFile},
+	}
+}
+
+// identify adds what we've learned about the game to everything logged about it from now on.
+func (g *gameLog) identify(opponent string, isWhite bool) {
+	color := "black"
+	if isWhite {
+		color = "white"
+	}
+	g.entry = g.entry.WithFields(log.Fields{
+		"opponent": opponent,
+		"color":    color,
+	})
+}
+
+func (g *gameLog) Close() {
+	for _, file := range g.files {
+		if err := file.Close(); err != nil {
+			log.WithError(err).Warn("failed to close game log")
+		}
+	}
+}
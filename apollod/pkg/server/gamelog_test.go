@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGameLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apollod-gamelog")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{gameLogDir: dir}
+	gameLog := s.openGameLog("abc123")
+	gameLog.identify("human", true)
+	gameLog.entry.Info("hello")
+	fmt.Fprintln(gameLog.transcript, "> uci")
+	gameLog.Close()
+
+	logged, err := ioutil.ReadFile(filepath.Join(dir, "abc123.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(logged), "hello")
+	assert.Contains(t, string(logged), "game_id=abc123")
+	assert.Contains(t, string(logged), "opponent=human")
+	transcript, err := ioutil.ReadFile(filepath.Join(dir, "abc123.uci"))
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S* > uci\n$`, string(transcript))
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+const (
+	// maxGameStreamReconnects is how many times in a row we'll try to re-open a game's stream before giving up on it.
+	maxGameStreamReconnects = 5
+	// gameEventBuffer is how many of a game's events we read ahead of the game loop, so that we notice the game
+	// ending while the engine is busy thinking.
+	gameEventBuffer = 64
+)
+
+// streamGame streams a game's events. Lichess sometimes drops a game's stream while the game is still going on, so
+// if the stream ends before we've seen the game finish, and lichess says we're still playing it, the stream is
+// re-opened. Every new stream begins with a fresh GameFull, which resynchronizes the game from scratch. Events are read
+// ahead of the caller, and ended is called as soon as one shows that the game is over.
+func (s *Server) streamGame(ctx context.Context, record *gameRecord, ended func()) (<-chan blitz.GameEvent, error) {
+	stream, err := s.client.Bot.StreamGameEvents(ctx, record.id)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan blitz.GameEvent, gameEventBuffer)
+	go func() {
+		defer close(events)
+		for stream != nil {
+			finished := false
+			for event := range stream {
+				if !finished && isFinishedEvent(event) {
+					finished = true
+					ended()
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if finished || ctx.Err() != nil {
+				return
+			}
+			stream = s.reopenGameStream(ctx, record)
+		}
+	}()
+	return events, nil
+}
+
+// reopenGameStream re-opens the stream of a game whose stream ended unexpectedly, backing off between attempts. It
+// returns nil if the game turns out to be over, or if lichess won't give us the stream back.
+func (s *Server) reopenGameStream(ctx context.Context, record *gameRecord) <-chan blitz.GameEvent {
+	backoff := minReconnectBackoff
+	for attempt := 1; attempt <= maxGameStreamReconnects; attempt++ {
+		record.logger().WithField("backoff", backoff).Warn("game stream ended while the game is in progress, reconnecting")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff *= 2
+
+		ongoing, err := s.isOngoing(ctx, record.id)
+		if err != nil {
+			record.logger().WithError(err).Warn("failed to check whether game is still in progress")
+			continue
+		}
+		if !ongoing {
+			record.logger().Info("game is no longer in progress, not reconnecting")
+			return nil
+		}
+
+		stream, err := s.client.Bot.StreamGameEvents(ctx, record.id)
+		if err != nil {
+			record.logger().WithError(err).Warn("failed to reconnect to game stream")
+			continue
+		}
+		gameStreamReconnects.Inc()
+		record.logger().Info("reconnected to game stream")
+		return stream
+	}
+	record.logger().Error("giving up on reconnecting to game stream")
+	return nil
+}
+
+// isOngoing asks lichess whether we're still playing a game.
+func (s *Server) isOngoing(ctx context.Context, gameID string) (bool, error) {
+	games, err := s.client.Account.GetOngoingGames(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, game := range games {
+		if game.GameID == gameID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isFinishedEvent returns true if event shows that the game is over.
+func isFinishedEvent(event blitz.GameEvent) bool {
+	switch e := event.(type) {
+	case blitz.GameFull:
+		return isFinished(e.State.Status)
+	case blitz.GameState:
+		return isFinished(e.Status)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+type roundTripFunc func(req *http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+func TestStreamGameReconnects(t *testing.T) {
+	streams := []string{
+		`{"type": "gameFull", "id": "abc", "state": {"type": "gameState", "moves": "e2e4", "status": "started"}}`,
+		`{"type": "gameFull", "id": "abc", "state": {"type": "gameState", "moves": "e2e4 e7e5", "status": "started"}}
+{"type": "gameState", "moves": "e2e4 e7e5 d1h5", "status": "resign"}`,
+	}
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+		body := `{"nowPlaying": [{"gameId": "abc"}]}`
+		if strings.Contains(req.URL.Path, "stream") {
+			body, streams = streams[0]+"\n", streams[1:]
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Header:     make(http.Header),
+		}
+	})}
+	s := &Server{client: blitz.New("", blitz.WithHTTPClient(httpClient))}
+
+	ended := 0
+	events, err := s.streamGame(context.Background(), &gameRecord{id: "abc"}, func() { ended++ })
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var received []blitz.GameEvent
+	for event := range events {
+		received = append(received, event)
+	}
+	if assert.Len(t, received, 3) {
+		assert.Equal(t, "e2e4 e7e5", received[1].(blitz.GameFull).State.Moves)
+		assert.Equal(t, "resign", received[2].(blitz.GameState).Status)
+	}
+	assert.Empty(t, streams, "no reconnecting once the game is over")
+	assert.Equal(t, 1, ended)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+// historyCacheTTL is how long we trust a verdict on a challenger's history before looking it up again.
+const historyCacheTTL = time.Hour
+
+// HistoryPolicy declines challengers whose recent games suggest they won't finish ours, so that a game slot isn't
+// wasted on a no-show.
+type HistoryPolicy struct {
+	// Games is how many of the challenger's most recent games to look at.
+	Games int `yaml:"games"`
+	// MaxAborts is the most of those games that may have been aborted or never started. Zero means no limit.
+	MaxAborts int `yaml:"maxAborts"`
+	// MaxTimeouts is the most of those games that the challenger may have lost by leaving. Zero means no limit.
+	MaxTimeouts int `yaml:"maxTimeouts"`
+	// MinCompletionRate is the lowest completion rate, in percent, that lichess may report for the challenger. Lichess
+	// only reports it for some users. Zero means no limit.
+	MinCompletionRate int `yaml:"minCompletionRate"`
+}
+
+func (p HistoryPolicy) validate() error {
+	if p.Games < 0 || p.MaxAborts < 0 || p.MaxTimeouts < 0 {
+		return errors.New("challenge policy history limits must not be negative")
+	}
+	if p.MinCompletionRate < 0 || p.MinCompletionRate > 100 {
+		return errors.New("challenge policy history minCompletionRate must be between 0 and 100")
+	}
+	if (p.MaxAborts > 0 || p.MaxTimeouts > 0) && p.Games == 0 {
+		return errors.New("challenge policy history games must be positive to limit aborts or timeouts")
+	}
+	return nil
+}
+
+// checksGames returns true if the policy needs the challenger's recent games.
+func (p HistoryPolicy) checksGames() bool {
+	return p.Games > 0 && (p.MaxAborts > 0 || p.MaxTimeouts > 0)
+}
+
+// judge decides whether a user's history is acceptable, returning why not if it isn't. Either of profile and games
+// may be nil if the policy doesn't need them.
+func (p HistoryPolicy) judge(userID string, profile *blitz.UserResponse, games []blitz.GameSummary) (bool, string) {
+	if p.MinCompletionRate > 0 && profile != nil && profile.CompletionRate > 0 && profile.CompletionRate < p.MinCompletionRate {
+		return false, fmt.Sprintf("completion rate of %d%%", profile.CompletionRate)
+	}
+
+	userID = strings.ToLower(userID)
+	aborts, timeouts := 0, 0
+	for _, game := range games {
+		var color string
+		switch userID {
+		case game.Players.White.User.ID:
+			color = "white"
+		case game.Players.Black.User.ID:
+			color = "black"
+		default:
+			continue
+		}
+		switch {
+		case game.Status == "aborted" || game.Status == "noStart":
+			aborts++
+		case game.Status == "timeout" && game.Winner != "" && game.Winner != color:
+			// Lichess ends a game as a timeout when a player leaves and their opponent claims victory.
+			timeouts++
+		}
+	}
+	if p.MaxAborts > 0 && aborts > p.MaxAborts {
+		return false, fmt.Sprintf("%d of their last %d games aborted", aborts, len(games))
+	}
+	if p.MaxTimeouts > 0 && timeouts > p.MaxTimeouts {
+		return false, fmt.Sprintf("left %d of their last %d games", timeouts, len(games))
+	}
+	return true, ""
+}
+
+// historyVerdict is a cached decision about a challenger's history.
+type historyVerdict struct {
+	time time.Time
+	ok   bool
+}
+
+// historyCache remembers recent verdicts, so that a persistent challenger doesn't cost us a lookup every time. The
+// zero value is ready to use.
+type historyCache struct {
+	lock     sync.Mutex
+	verdicts map[string]historyVerdict
+}
+
+func (c *historyCache) get(userID string, now time.Time) (bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	verdict, ok := c.verdicts[strings.ToLower(userID)]
+	if !ok || now.Sub(verdict.time) > historyCacheTTL {
+		return false, false
+	}
+	return verdict.ok, true
+}
+
+func (c *historyCache) put(userID string, now time.Time, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.verdicts == nil {
+		c.verdicts = make(map[string]historyVerdict)
+	}
+	for id, verdict := range c.verdicts {
+		if now.Sub(verdict.time) > historyCacheTTL {
+			delete(c.verdicts, id)
+		}
+	}
+	c.verdicts[strings.ToLower(userID)] = historyVerdict{time: now, ok: ok}
+}
+
+// historyAcceptable returns true if the challenger's history passes the challenge policy's history check. If their
+// history can't be looked up, we give them the benefit of the doubt.
+func (s *Server) historyAcceptable(ctx context.Context, challenger blitz.Challenger) bool {
+	policy := s.challengePolicy().History
+	if (!policy.checksGames() && policy.MinCompletionRate == 0) || challenger.ID == "" {
+		return true
+	}
+	if ok, cached := s.histories.get(challenger.ID, time.Now()); cached {
+		return ok
+	}
+
+	var profile *blitz.UserResponse
+	var games []blitz.GameSummary
+	var err error
+	if policy.MinCompletionRate > 0 {
+		if profile, err = s.client.Users.GetUser(ctx, challenger.ID); err != nil {
+			log.WithError(err).WithField("challenger", challenger.Name).Warn("failed to read challenger's profile")
+			return true
+		}
+	}
+	if policy.checksGames() {
+		if games, err = s.client.Games.UserGames(ctx, challenger.ID, policy.Games); err != nil {
+			log.WithError(err).WithField("challenger", challenger.Name).Warn("failed to read challenger's games")
+			return true
+		}
+	}
+
+	ok, reason := policy.judge(challenger.ID, profile, games)
+	if !ok {
+		log.WithFields(log.Fields{
+			"opponent": challenger.Name,
+			"reason":   reason,
+		}).Info("challenger's history suggests they won't finish the game")
+	}
+	s.histories.put(challenger.ID, time.Now(), ok)
+	return ok
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+func historyGame(white, black, status, winner string) blitz.GameSummary {
+	game := blitz.GameSummary{Status: status, Winner: winner}
+	game.Players.White.User.ID = white
+	game.Players.Black.User.ID = black
+	return game
+}
+
+func TestHistoryPolicyJudge(t *testing.T) {
+	policy := HistoryPolicy{Games: 10, MaxAborts: 1, MaxTimeouts: 1, MinCompletionRate: 80}
+	games := []blitz.GameSummary{
+		historyGame("someone", "other", "aborted", ""),
+		historyGame("other", "someone", "mate", "white"),
+		// Winning when the opponent leaves isn't held against them.
+		historyGame("someone", "other", "timeout", "white"),
+		historyGame("other", "someone", "timeout", "white"),
+	}
+	ok, _ := policy.judge("Someone", nil, games)
+	assert.True(t, ok)
+
+	ok, reason := policy.judge("someone", nil, append(games, historyGame("other", "someone", "noStart", "")))
+	assert.False(t, ok)
+	assert.Contains(t, reason, "aborted")
+
+	ok, reason = policy.judge("someone", nil, append(games, historyGame("someone", "other", "timeout", "black")))
+	assert.False(t, ok)
+	assert.Contains(t, reason, "left")
+
+	ok, _ = policy.judge("someone", &blitz.UserResponse{CompletionRate: 75}, nil)
+	assert.False(t, ok)
+	// Lichess doesn't report a completion rate for everyone.
+	ok, _ = policy.judge("someone", &blitz.UserResponse{}, nil)
+	assert.True(t, ok)
+}
@@ -0,0 +1,530 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz/blitztest"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+// integrationTimeout bounds how long these tests wait for the server to do anything.
+const integrationTimeout = 10 * time.Second
+
+var botAccount = blitz.AccountResponse{ID: "apollo", Username: "apollo", Title: "BOT"}
+
+// foolsMate is the bot's side of the fastest mate there is, played as black against f2f3 and g2g4.
+var foolsMate = map[string]string{
+	"f2f3":           "e7e5",
+	"f2f3 e7e5 g2g4": "d8h4",
+}
+
+// testBot is a server playing on a fake lichess, with scripted engines.
+type testBot struct {
+	lichess *blitztest.Server
+	server  *Server
+	live    <-chan LiveEvent
+
+	lock    sync.Mutex
+	engines []*ucitest.Engine
+	// paths are the binaries the bot's engines were started from, in order.
+	paths []string
+	// engineDelay is how long engines started from now on take to search.
+	engineDelay time.Duration
+	// crashOnSearch makes the next engine started crash when it's asked for that search.
+	crashOnSearch int
+}
+
+// startTestBot starts a server against a fake lichess. Its engines play the bot's side of fool's mate, and otherwise
+// the first legal move. There's no engine binary named "broken". The returned function shuts everything down.
+func startTestBot(t *testing.T, options ...ServerOption) (*testBot, func()) {
+	lichess := blitztest.NewServer(botAccount)
+	options = append([]ServerOption{
+		WithLichessURL(lichess.URL),
+		WithTablebase(TablebaseConfig{}),
+	}, options...)
+	server, err := NewServer("token", options...)
+	if !assert.NoError(t, err) {
+		lichess.Close()
+		t.FailNow()
+	}
+
+	bot := &testBot{lichess: lichess, server: server}
+	server.launchEngine = func(profile EngineProfile, transcript io.Writer) (engine.Engine, error) {
+		if profile.Path == "broken" {
+			return nil, errors.New("no such file or directory")
+		}
+		fake := &ucitest.Engine{BestMove: func(position string, moves []string) string {
+			if move := foolsMate[strings.Join(moves, " ")]; move != "" {
+				return move
+			}
+			return ucitest.FirstLegalMove(position, moves)
+		}}
+		bot.lock.Lock()
+		fake.Delay = bot.engineDelay
+		fake.CrashOnSearch, bot.crashOnSearch = bot.crashOnSearch, 0
+		bot.engines = append(bot.engines, fake)
+		bot.paths = append(bot.paths, profile.Path)
+		bot.lock.Unlock()
+		return engine.New(profile.Protocol, engine.NewTranscriptTransport(fake, transcript))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := server.client.Challenges.StreamEvents(ctx)
+	if !assert.NoError(t, err) {
+		cancel()
+		lichess.Close()
+		t.FailNow()
+	}
+	live, unsubscribe := server.events.subscribe()
+	bot.live = live
+	go server.challengeLoop()
+	go server.serveEvents(ctx, events)
+
+	return bot, func() {
+		cancel()
+		server.gamesLock.Lock()
+		for _, game := range server.games {
+			game.cancel()
+		}
+		server.gamesLock.Unlock()
+		server.gamesWaitGroup.Wait()
+		server.closeEnginePools()
+		unsubscribe()
+		close(server.challenges)
+		lichess.Close()
+	}
+}
+
+// searches returns the number of searches done by every engine the bot has started.
+func (b *testBot) searches() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	searches := 0
+	for _, fake := range b.engines {
+		searches += fake.Searches()
+	}
+	return searches
+}
+
+// waitForGame waits for the bot to start the game with the given ID on the fake lichess.
+func (b *testBot) waitForGame(t *testing.T, id string) *blitztest.Game {
+	deadline := time.Now().Add(integrationTimeout)
+	for time.Now().Before(deadline) {
+		if game := b.lichess.Game(id); game != nil {
+			return game
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("game %s never started", id)
+	return nil
+}
+
+// waitForEvent waits for the server to publish a live event of the given type for a game.
+func (b *testBot) waitForEvent(t *testing.T, ty, gameID string) LiveEvent {
+	timeout := time.After(integrationTimeout)
+	for {
+		select {
+		case event := <-b.live:
+			if event.Type == ty && event.Game == gameID {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("server never published %q for game %s", ty, gameID)
+			return LiveEvent{}
+		}
+	}
+}
+
+// nextMove waits for the bot to play a move in a game.
+func nextMove(t *testing.T, game *blitztest.Game) string {
+	select {
+	case played := <-game.BotMoves():
+		return played
+	case <-time.After(integrationTimeout):
+		t.Fatal("bot never moved")
+		return ""
+	}
+}
+
+// expectNoMove checks that the bot doesn't play a move in a game for a little while.
+func expectNoMove(t *testing.T, game *blitztest.Game) {
+	select {
+	case played := <-game.BotMoves():
+		t.Errorf("bot unexpectedly played %s", played)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func blitzGame(white, black string) blitz.GameFull {
+	return blitz.GameFull{
+		Rated:   true,
+		Variant: blitz.Variant{Key: "standard", Name: "Standard"},
+		Clock:   blitz.Clock{Initial: 180000, Increment: 2000},
+		Speed:   "blitz",
+		White:   blitz.GamePlayer{ID: white, Name: white, Rating: 1500},
+		Black:   blitz.GamePlayer{ID: black, Name: black, Rating: 1500},
+		State:   blitz.GameState{Wtime: 180000, Btime: 180000, Winc: 2000, Binc: 2000},
+	}
+}
+
+func TestPlaysChallengeToFinish(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+
+	bot.lichess.SendChallenge(blitz.Challenge{
+		ID:          "game1",
+		Challenger:  blitz.Challenger{ID: "someone", Name: "Someone", Rating: 1500},
+		Variant:     blitz.Variant{Key: "standard"},
+		Rated:       true,
+		TimeControl: blitz.TimeControl{Type: "clock", Limit: 180, Increment: 2},
+		Perf:        blitz.Perf{Name: "blitz"},
+	}, blitzGame("someone", "apollo"))
+
+	game := bot.waitForGame(t, "game1")
+	start := bot.waitForEvent(t, "start", "game1")
+	assert.Equal(t, "black", start.Color)
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+	assert.NoError(t, game.Play("g2g4"))
+	assert.Equal(t, "d8h4", nextMove(t, game))
+
+	end := bot.waitForEvent(t, "end", "game1")
+	assert.Equal(t, "win", end.Result)
+	assert.Equal(t, "mate", end.Status)
+	assert.Equal(t, []string{"game1"}, bot.lichess.Accepted())
+	assert.Equal(t, 2, bot.searches())
+	if chat := game.Chat(); assert.NotEmpty(t, chat) {
+		assert.Contains(t, chat[0], "Good Luck, Have Fun!")
+	}
+}
+
+func TestDeclinesChallengeAgainstPolicy(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+
+	bot.lichess.SendChallenge(blitz.Challenge{
+		ID:          "game1",
+		Challenger:  blitz.Challenger{ID: "someone", Name: "Someone", Rating: 1500},
+		Variant:     blitz.Variant{Key: "atomic"},
+		TimeControl: blitz.TimeControl{Type: "clock", Limit: 180, Increment: 2},
+	}, blitzGame("someone", "apollo"))
+
+	deadline := time.Now().Add(integrationTimeout)
+	for len(bot.lichess.Declined()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, map[string]string{"game1": string(blitz.DeclineVariant)}, bot.lichess.Declined())
+	assert.Empty(t, bot.lichess.Accepted())
+}
+
+func TestOpponentMovesBeforeGameFull(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+
+	// The opponent moves as soon as the game starts, which may well be before the bot has even opened the game's
+	// stream. Either way, the bot answers the move exactly once.
+	full := blitzGame("someone", "apollo")
+	full.ID = "game1"
+	game := bot.lichess.StartGame(full)
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+	expectNoMove(t, game)
+	assert.Equal(t, 1, bot.searches())
+}
+
+func TestIgnoresRepeatedGameStates(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+
+	full := blitzGame("apollo", "someone")
+	full.ID = "game1"
+	game := bot.lichess.StartGame(full)
+	nextMove(t, game)
+
+	// Lichess sometimes repeats the state after the bot's own move; playing again would be an illegal move.
+	game.Send(game.State())
+	game.Send(game.State())
+	expectNoMove(t, game)
+	assert.Equal(t, "started", game.State().Status)
+
+	assert.NoError(t, game.Play("e7e5"))
+	nextMove(t, game)
+	assert.Equal(t, 2, bot.searches())
+}
+
+func TestResumesAfterGameStreamDrops(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+
+	full := blitzGame("someone", "apollo")
+	full.ID = "game1"
+	game := bot.lichess.StartGame(full)
+	bot.waitForEvent(t, "start", "game1")
+
+	// The opponent moves while the bot is disconnected, so the bot only finds out from the GameFull that starts its
+	// new stream.
+	game.Disconnect()
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+	assert.NoError(t, game.Play("g2g4"))
+	assert.Equal(t, "d8h4", nextMove(t, game))
+	assert.Equal(t, "win", bot.waitForEvent(t, "end", "game1").Result)
+}
+
+func TestAbortsGameItCannotPlay(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+
+	// The bot isn't playing in this game, which it can't recover from, so it gives up on the game.
+	full := blitzGame("someone", "someone_else")
+	full.ID = "game1"
+	game := bot.lichess.StartGame(full)
+	deadline := time.Now().Add(integrationTimeout)
+	for game.State().Status == "started" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "aborted", game.State().Status)
+}
+
+func TestSwapEngineLeavesGamesInProgress(t *testing.T) {
+	bot, stop := startTestBot(t,
+		WithEngine(EngineProfile{Path: "old"}, nil),
+		WithSwappableEngines([]string{"broken", "new"}))
+	defer stop()
+
+	first := blitzGame("someone", "apollo")
+	first.ID = "game1"
+	game := bot.lichess.StartGame(first)
+	bot.waitForEvent(t, "start", "game1")
+
+	// Engines that aren't allowed aren't even started.
+	assert.EqualError(t, bot.server.SwapEngine("/tmp/evil"), `engine "/tmp/evil" is not one of the engines that may be swapped to`)
+	assert.Error(t, bot.server.SwapEngine("broken"))
+	assert.Equal(t, "old", bot.server.EnginePath())
+	assert.NoError(t, bot.server.SwapEngine("new"))
+	assert.Equal(t, "new", bot.server.EnginePath())
+
+	// The game in progress carries on with the engine it started with.
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+	if status := bot.server.Status(); assert.Len(t, status.Games, 1) {
+		assert.Equal(t, "old", status.Games[0].Engine)
+		assert.Equal(t, "new", status.Engine)
+	}
+
+	second := blitzGame("someone", "apollo")
+	second.ID = "game2"
+	bot.lichess.StartGame(second)
+	bot.waitForEvent(t, "start", "game2")
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	// The swap itself starts the new engine once, to make sure it works.
+	assert.Equal(t, []string{"old", "new", "new"}, bot.paths)
+}
+
+func TestReusesEngineBetweenGames(t *testing.T) {
+	bot, stop := startTestBot(t, WithEngine(EngineProfile{Path: "pooled", EngineGames: 2}, nil))
+	defer stop()
+
+	for _, id := range []string{"game1", "game2", "game3"} {
+		full := blitzGame("someone", "apollo")
+		full.ID = id
+		game := bot.lichess.StartGame(full)
+		bot.waitForEvent(t, "start", id)
+		assert.NoError(t, game.Play("f2f3"))
+		assert.Equal(t, "e7e5", nextMove(t, game))
+		assert.NoError(t, game.Play("g2g4"))
+		assert.Equal(t, "d8h4", nextMove(t, game))
+		bot.waitForEvent(t, "end", id)
+		// The engine is handed back once the game has wrapped up.
+		bot.server.gamesWaitGroup.Wait()
+	}
+
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	// The first engine retires after its second game, so the third game starts another.
+	assert.Equal(t, []string{"pooled", "pooled"}, bot.paths)
+	if assert.Len(t, bot.engines, 2) {
+		assert.Equal(t, 4, bot.engines[0].Searches())
+		assert.Equal(t, 2, bot.engines[1].Searches())
+	}
+}
+
+func TestRunShutsDownPooledEngines(t *testing.T) {
+	lichess := blitztest.NewServer(botAccount)
+	defer lichess.Close()
+	server, err := NewServer("token",
+		WithLichessURL(lichess.URL),
+		WithTablebase(TablebaseConfig{}),
+		WithEngine(EngineProfile{Path: "pooled", EngineGames: 5}, nil))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var fakes []*ucitest.Engine
+	server.launchEngine = func(profile EngineProfile, transcript io.Writer) (engine.Engine, error) {
+		fake := &ucitest.Engine{BestMove: func(position string, moves []string) string {
+			return foolsMate[strings.Join(moves, " ")]
+		}}
+		fakes = append(fakes, fake)
+		return engine.New(profile.Protocol, engine.NewTranscriptTransport(fake, transcript))
+	}
+	live, unsubscribe := server.events.subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Run(ctx)
+	}()
+
+	full := blitzGame("someone", "apollo")
+	full.ID = "game1"
+	game := lichess.StartGame(full)
+	bot := &testBot{lichess: lichess, server: server, live: live}
+	bot.waitForEvent(t, "start", "game1")
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+	assert.NoError(t, game.Play("g2g4"))
+	assert.Equal(t, "d8h4", nextMove(t, game))
+	bot.waitForEvent(t, "end", "game1")
+
+	cancel()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(integrationTimeout):
+		t.Fatal("server never stopped")
+	}
+	// The engine outlived its game, waiting for another, until the server stopped.
+	if assert.Len(t, fakes, 1) {
+		commands := fakes[0].Commands()
+		assert.Equal(t, "quit", commands[len(commands)-1])
+	}
+}
+
+func TestStopsSearchWhenGameEnds(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+	bot.engineDelay = time.Hour
+
+	full := blitzGame("apollo", "someone")
+	full.ID = "game1"
+	game := bot.lichess.StartGame(full)
+	deadline := time.Now().Add(integrationTimeout)
+	for bot.searches() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The opponent resigns while the engine is thinking, which it would otherwise do for an hour.
+	game.End("resign", "white")
+	assert.Equal(t, "win", bot.waitForEvent(t, "end", "game1").Result)
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	if assert.Len(t, bot.engines, 1) {
+		assert.Contains(t, bot.engines[0].Commands(), "stop")
+	}
+}
+
+func TestRestartsCrashedEngine(t *testing.T) {
+	bot, stop := startTestBot(t, WithEngine(EngineProfile{Restarts: 1}, nil))
+	defer stop()
+	bot.crashOnSearch = 2
+
+	full := blitzGame("someone", "apollo")
+	full.ID = "game1"
+	game := bot.lichess.StartGame(full)
+	bot.waitForEvent(t, "start", "game1")
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+
+	// The engine dies searching for mate, and a new one is sent the game so far to find it.
+	assert.NoError(t, game.Play("g2g4"))
+	assert.Equal(t, "d8h4", nextMove(t, game))
+	assert.Equal(t, "win", bot.waitForEvent(t, "end", "game1").Result)
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	if assert.Len(t, bot.engines, 2) {
+		assert.Contains(t, bot.engines[1].Commands(), "position startpos moves f2f3 e7e5 g2g4")
+	}
+}
+
+func TestGivesUpOnCrashedEngineWithoutRestarts(t *testing.T) {
+	bot, stop := startTestBot(t)
+	defer stop()
+	bot.crashOnSearch = 1
+
+	full := blitzGame("apollo", "someone")
+	full.ID = "game1"
+	bot.lichess.StartGame(full)
+	bot.waitForEvent(t, "end", "game1")
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	assert.Len(t, bot.engines, 1, "the engine isn't restarted")
+}
+
+func TestReplacesHungEngineBeforeSearching(t *testing.T) {
+	keepalive := KeepaliveConfig{Interval: 5 * time.Millisecond, Deadline: 20 * time.Millisecond}
+	bot, stop := startTestBot(t, WithEngine(EngineProfile{Keepalive: keepalive}, nil))
+	defer stop()
+
+	full := blitzGame("someone", "apollo")
+	full.ID = "game1"
+	game := bot.lichess.StartGame(full)
+	bot.waitForEvent(t, "start", "game1")
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+
+	// The engine hangs while the opponent thinks, so it's replaced before it's asked for mate.
+	bot.lock.Lock()
+	bot.engines[0].Hang()
+	bot.lock.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, game.Play("g2g4"))
+	assert.Equal(t, "d8h4", nextMove(t, game))
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	assert.Len(t, bot.engines, 2)
+}
+
+func TestReloadLeavesGamesInProgress(t *testing.T) {
+	bot, stop := startTestBot(t, WithEngine(EngineProfile{Path: "old"}, nil))
+	defer stop()
+
+	first := blitzGame("someone", "apollo")
+	first.ID = "game1"
+	game := bot.lichess.StartGame(first)
+	bot.waitForEvent(t, "start", "game1")
+
+	policy := DefaultChallengePolicy()
+	policy.Variants = []string{"chess960"}
+	assert.Error(t, bot.server.Reload(Reloadable{}), "an empty policy is invalid")
+	assert.NoError(t, bot.server.Reload(Reloadable{Policy: policy, Engine: EngineProfile{Path: "new"}}))
+	assert.Equal(t, "new", bot.server.EnginePath())
+
+	// The game in progress carries on with the engine it started with, while challenges follow the new policy.
+	assert.NoError(t, game.Play("f2f3"))
+	assert.Equal(t, "e7e5", nextMove(t, game))
+	bot.lichess.SendChallenge(blitz.Challenge{
+		ID:          "game2",
+		Challenger:  blitz.Challenger{ID: "someone", Name: "Someone", Rating: 1500},
+		Variant:     blitz.Variant{Key: "standard"},
+		TimeControl: blitz.TimeControl{Type: "clock", Limit: 180, Increment: 2},
+	}, blitzGame("someone", "apollo"))
+	deadline := time.Now().Add(integrationTimeout)
+	for len(bot.lichess.Declined()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, map[string]string{"game2": string(blitz.DeclineVariant)}, bot.lichess.Declined())
+	assert.NoError(t, game.Play("g2g4"))
+	assert.Equal(t, "d8h4", nextMove(t, game))
+}
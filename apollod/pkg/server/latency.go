@@ -0,0 +1,73 @@
+package server
+
+import (
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+const (
+	defaultMoveOverhead = 100 * time.Millisecond
+	// minimumThinkTime is the least amount of time we'll ever tell the engine it has left on its clock.
+	minimumThinkTime = 10 * time.Millisecond
+	// latencySmoothing is the weight given to the newest measurement in the moving average of move latency.
+	latencySmoothing = 0.25
+)
+
+// WithMoveOverhead sets the minimum amount of time to reserve on our clock for every move, to account for the
+// latency between the engine choosing a move and lichess receiving it.
+func WithMoveOverhead(overhead time.Duration) ServerOption {
+	return func(server *Server) {
+		server.moveOverhead = overhead
+	}
+}
+
+// latencyTracker measures how long it takes for our moves to reach lichess over the course of a game.
+type latencyTracker struct {
+	configured time.Duration
+	average    time.Duration
+}
+
+func newLatencyTracker(configured time.Duration) *latencyTracker {
+	return &latencyTracker{configured: configured}
+}
+
+// observe records the round-trip time of a single move submission.
+func (l *latencyTracker) observe(rtt time.Duration) {
+	if l.average == 0 {
+		l.average = rtt
+		return
+	}
+	l.average = time.Duration(latencySmoothing*float64(rtt) + (1-latencySmoothing)*float64(l.average))
+}
+
+// overhead is the amount of time to reserve per move: the configured overhead, or the measured latency if lichess
+// is being slower than that.
+func (l *latencyTracker) overhead() time.Duration {
+	if l.average > l.configured {
+		return l.average
+	}
+	return l.configured
+}
+
+// compensate returns a copy of the game state with the overhead deducted from our own clock.
+func (l *latencyTracker) compensate(state blitz.GameState, isWhite bool) blitz.GameState {
+	deduct := func(remaining int) int {
+		if remaining == 0 {
+			// Games without a clock don't need any compensation.
+			return remaining
+		}
+		adjusted := time.Duration(remaining)*time.Millisecond - l.overhead()
+		if adjusted < minimumThinkTime {
+			adjusted = minimumThinkTime
+		}
+		return int(adjusted / time.Millisecond)
+	}
+
+	if isWhite {
+		state.Wtime = deduct(state.Wtime)
+	} else {
+		state.Btime = deduct(state.Btime)
+	}
+	return state
+}
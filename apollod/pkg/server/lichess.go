@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultProbeInterval = 30 * time.Second
+
+// LichessConfig says how the server reaches lichess.
+type LichessConfig struct {
+	// URLs are the base URLs that lichess can be reached through, such as lichess itself and relays in front of it in
+	// other regions. Requests go to whichever is fastest, failing over to another when it stops responding. Empty means
+	// lichess.org.
+	URLs []string `yaml:"urls"`
+	// ProbeInterval is how often every URL is probed for its latency and health, when there's more than one. Zero
+	// only measures them by the requests sent through them.
+	ProbeInterval time.Duration `yaml:"probeInterval"`
+}
+
+// DefaultLichessConfig reaches lichess at lichess.org.
+func DefaultLichessConfig() LichessConfig {
+	return LichessConfig{ProbeInterval: defaultProbeInterval}
+}
+
+// Validate checks that every URL is an absolute http or https URL.
+func (c LichessConfig) Validate() error {
+	for _, raw := range c.URLs {
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return errors.Wrapf(err, "invalid url %q", raw)
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.Errorf("url %q must be an absolute http or https URL", raw)
+		}
+	}
+	if c.ProbeInterval < 0 {
+		return errors.New("probeInterval must not be negative")
+	}
+	return nil
+}
+
+// baseURLs returns the URLs in the form the lichess client expects, with a trailing slash.
+func (c LichessConfig) baseURLs() []string {
+	var urls []string
+	for _, url := range c.URLs {
+		urls = append(urls, strings.TrimSuffix(url, "/")+"/")
+	}
+	return urls
+}
+
+// WithLichess sets how the server reaches lichess.
+func WithLichess(config LichessConfig) ServerOption {
+	return func(server *Server) {
+		server.lichess = config
+	}
+}
+
+// WithLichessURL points the server at a lichess other than lichess.org, such as a development instance.
+func WithLichessURL(url string) ServerOption {
+	return func(server *Server) {
+		server.lichess.URLs = []string{url}
+	}
+}
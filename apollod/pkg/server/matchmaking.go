@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+const (
+	matchmakingPollInterval = time.Minute
+	onlineBotsToConsider    = 100
+)
+
+// MatchmakingConfig controls how the server seeks out games when nobody is challenging it.
+type MatchmakingConfig struct {
+	// IdleTime is how long the server must go without playing before it issues a challenge.
+	IdleTime time.Duration `yaml:"idleTime"`
+	// Interval is the minimum amount of time between two challenges.
+	Interval time.Duration `yaml:"interval"`
+	// DailyLimit is the maximum number of challenges issued per day.
+	DailyLimit int `yaml:"dailyLimit"`
+	// RatingBand is the maximum difference between our rating and an opponent's rating.
+	RatingBand int `yaml:"ratingBand"`
+	// RatingRefresh is how often our rating is read again from our profile, so that the band of opponents we
+	// challenge follows our rating as it changes. Zero only reads it when the server starts.
+	RatingRefresh time.Duration `yaml:"ratingRefresh"`
+	// ClockLimit and ClockIncrement are the time control of issued challenges, in seconds.
+	ClockLimit     int `yaml:"clockLimit"`
+	ClockIncrement int `yaml:"clockIncrement"`
+	// Rated controls whether or not issued challenges are rated.
+	Rated bool `yaml:"rated"`
+}
+
+// DefaultMatchmakingConfig seeks a rated 3+2 game after fifteen idle minutes.
+func DefaultMatchmakingConfig() MatchmakingConfig {
+	return MatchmakingConfig{
+		IdleTime:       15 * time.Minute,
+		Interval:       5 * time.Minute,
+		DailyLimit:     50,
+		RatingBand:     200,
+		RatingRefresh:  time.Hour,
+		ClockLimit:     180,
+		ClockIncrement: 2,
+		Rated:          true,
+	}
+}
+
+// WithMatchmaking enables automatically challenging other bots when the server is idle.
+func WithMatchmaking(config MatchmakingConfig) ServerOption {
+	return func(server *Server) {
+		server.matchmaking = &config
+	}
+}
+
+func (s *Server) matchmakingLoop(ctx context.Context) {
+	config := s.matchmaking
+	log.WithField("idleTime", config.IdleTime).Info("matchmaking loop starting")
+
+	var lastChallenge time.Time
+	var day time.Time
+	sentToday := 0
+	speed := speedOf(config.ClockLimit, config.ClockIncrement)
+	rating, ratedAt := ratingFor(s.user.Perfs, speed), time.Now()
+	ticker := time.NewTicker(matchmakingPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		today := time.Now().Truncate(24 * time.Hour)
+		if !today.Equal(day) {
+			day = today
+			sentToday = 0
+		}
+
+		if s.idleTime() < config.IdleTime || time.Since(lastChallenge) < config.Interval {
+			continue
+		}
+		if s.isPaused() || s.pool.full() || !s.isAvailable() {
+			continue
+		}
+		if s.rateLimit.remaining() > 0 {
+			log.Debug("rate limited, skipping matchmaking")
+			continue
+		}
+		if config.DailyLimit > 0 && sentToday >= config.DailyLimit {
+			continue
+		}
+
+		if config.RatingRefresh > 0 && time.Since(ratedAt) >= config.RatingRefresh {
+			rating, ratedAt = s.refreshRating(ctx, speed, rating), time.Now()
+		}
+		opponent, err := s.pickOpponent(ctx, rating)
+		if err != nil {
+			log.WithError(err).Warn("failed to find an opponent")
+			continue
+		}
+		if opponent == "" {
+			log.Info("no online bots within our rating band")
+			continue
+		}
+
+		lastChallenge = time.Now()
+		sentToday++
+		log.WithField("opponent", opponent).Info("server is idle, challenging bot")
+		request := blitz.ChallengeRequest{
+			Rated:          config.Rated,
+			ClockLimit:     config.ClockLimit,
+			ClockIncrement: config.ClockIncrement,
+			Color:          "random",
+		}
+		if _, err := s.client.Challenges.CreateChallenge(ctx, opponent, request); err != nil {
+			log.WithError(err).Warn("failed to challenge bot")
+		}
+	}
+}
+
+// refreshRating reads our rating at a speed from our profile again. If the profile can't be read, the rating we had
+// is kept.
+func (s *Server) refreshRating(ctx context.Context, speed string, rating int) int {
+	profile, err := s.client.Account.GetProfile(ctx)
+	if err != nil {
+		log.WithError(err).Warn("failed to refresh our rating, keeping the last one")
+		return rating
+	}
+	updated := ratingFor(profile.Perfs, speed)
+	if updated != rating {
+		band := s.matchmaking.RatingBand
+		log.WithFields(log.Fields{
+			"speed": speed,
+			"from":  rating,
+			"to":    updated,
+			"band":  fmt.Sprintf("%d-%d", updated-band, updated+band),
+		}).Info("rating changed, moving matchmaking rating band")
+	}
+	return updated
+}
+
+// pickOpponent selects a random online bot whose rating in our time control is within the rating band of ourRating.
+// It returns the empty string if there are no suitable bots online.
+func (s *Server) pickOpponent(ctx context.Context, ourRating int) (string, error) {
+	config := s.matchmaking
+	speed := speedOf(config.ClockLimit, config.ClockIncrement)
+
+	bots, err := s.client.Bot.OnlineBots(ctx, onlineBotsToConsider)
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	for _, bot := range bots {
+		if bot.ID == s.user.ID || s.blocklist.Blocked(bot.ID) {
+			continue
+		}
+		diff := ratingFor(bot.Perfs, speed) - ourRating
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= config.RatingBand {
+			candidates = append(candidates, bot.Username)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+// speedOf classifies a time control the same way lichess does, by its estimated game duration.
+func speedOf(limit, increment int) string {
+	estimate := limit + 40*increment
+	switch {
+	case estimate < 180:
+		return "bullet"
+	case estimate < 480:
+		return "blitz"
+	case estimate < 1500:
+		return "rapid"
+	default:
+		return "classical"
+	}
+}
+
+func ratingFor(perfs blitz.Perfs, speed string) int {
+	switch speed {
+	case "bullet":
+		return perfs.Bullet.Rating
+	case "blitz":
+		return perfs.Blitz.Rating
+	case "rapid":
+		return perfs.Rapid.Rating
+	default:
+		return perfs.Classical.Rating
+	}
+}
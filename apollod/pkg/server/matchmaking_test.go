@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz/blitztest"
+)
+
+func TestRefreshRating(t *testing.T) {
+	account := botAccount
+	account.Perfs.Blitz.Rating = 1500
+	lichess := blitztest.NewServer(account)
+	config := DefaultMatchmakingConfig()
+	server := &Server{client: lichess.Client(), matchmaking: &config}
+
+	account.Perfs.Blitz.Rating = 1650
+	lichess.SetAccount(account)
+	assert.Equal(t, 1650, server.refreshRating(context.Background(), "blitz", 1500))
+
+	// Without a profile, the rating we had is kept.
+	lichess.Close()
+	assert.Equal(t, 1650, server.refreshRating(context.Background(), "blitz", 1650))
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/metrics"
+)
+
+var (
+	// The server's metrics were the daemon's first, so they're named directly under the namespace rather than in a
+	// subsystem of their own.
+	serverMetrics = metrics.NewSubsystem("")
+
+	gamesStarted = serverMetrics.NewCounter(
+		"games_started_total",
+		"Number of lichess games that the server has started playing.")
+	gamesFinished = serverMetrics.NewCounter(
+		"games_finished_total",
+		"Number of lichess games that the server has finished, by result.",
+		"result")
+	gamesActive = serverMetrics.NewGauge(
+		"games_active",
+		"Number of lichess games currently in progress.")
+	buildGamesFinished = serverMetrics.NewCounter(
+		"build_games_finished_total",
+		"Number of lichess games finished while a canary was running, by engine build (baseline or the canary's) and result.",
+		"build", "result")
+	challengesHandled = serverMetrics.NewCounter(
+		"challenges_total",
+		"Number of incoming challenges, by decision and reason.",
+		"decision", "reason")
+	moveLatency = serverMetrics.NewHistogram(
+		"move_latency_seconds",
+		"Round-trip time of submitting a move to lichess.",
+		metrics.DefaultLatencyBuckets)
+	engineThinkTime = serverMetrics.NewHistogram(
+		"engine_think_seconds",
+		"Time the engine spent searching for a move.",
+		metrics.DefaultLatencyBuckets)
+	engineDepth = serverMetrics.NewHistogram(
+		"engine_depth",
+		"Depth the engine reached when searching for a move, by lichess speed.",
+		[]float64{4, 6, 8, 10, 12, 14, 16, 18, 20, 25, 30},
+		"speed")
+	engineNPS = serverMetrics.NewHistogram(
+		"engine_nps",
+		"Nodes per second the engine searched at, by lichess speed.",
+		[]float64{1e4, 5e4, 1e5, 2.5e5, 5e5, 1e6, 2.5e6, 5e6, 1e7},
+		"speed")
+	engineTimeouts = serverMetrics.NewCounter(
+		"engine_timeouts_total",
+		"Number of searches where the engine ran out of time, by outcome (stopped, or fallback if it had to be killed).",
+		"outcome")
+	searchesCanceled = serverMetrics.NewCounter(
+		"searches_canceled_total",
+		"Number of searches abandoned because the game ended while the engine was thinking.")
+	illegalMoves = serverMetrics.NewCounter(
+		"illegal_moves_total",
+		"Number of illegal moves proposed for play, which were replaced with a legal move before reaching lichess.")
+	bookMoves = serverMetrics.NewCounter(
+		"book_moves_total",
+		"Number of moves played from the opening book instead of the engine.")
+	opponentPreps = serverMetrics.NewCounter(
+		"opponent_preps_total",
+		"Number of times we prepared for an opponent, by source (cached, prepared from their games, or error).",
+		"source")
+	tablebaseProbes = serverMetrics.NewCounter(
+		"tablebase_probes_total",
+		"Number of endgame tablebase lookups, by result (hit, miss, or error).",
+		"result")
+	rateLimited = serverMetrics.NewCounter(
+		"rate_limited_total",
+		"Number of lichess API requests rejected for exceeding the rate limit.")
+	streamReconnects = serverMetrics.NewCounter(
+		"stream_reconnects_total",
+		"Number of times the lichess event stream was re-established.")
+	gameStreamReconnects = serverMetrics.NewCounter(
+		"game_stream_reconnects_total",
+		"Number of times a game's event stream was re-established while the game was in progress.")
+	unhealthyEngines = serverMetrics.NewCounter(
+		"unhealthy_engines_total",
+		"Number of engines replaced before a search because they stopped answering keepalive pings.")
+	engineCrashes = serverMetrics.NewCounter(
+		"engine_crashes_total",
+		"Number of times an engine exited in the middle of a search, by what happened next (restarted, or lost).",
+		"outcome")
+)
+
+// WithSearchMetrics has the server export the depth and speed of the engine's searches in live games as metrics.
+func WithSearchMetrics(enabled bool) ServerOption {
+	return func(server *Server) {
+		server.searchMetrics = enabled
+	}
+}
+
+// observeSearch exports the engine's search for a move in a game at the given speed, if search metrics are enabled.
+func (s *Server) observeSearch(speed string, info engine.Info) {
+	if !s.searchMetrics || info.Depth == 0 {
+		return
+	}
+	engineDepth.Observe(float64(info.Depth), speed)
+	if info.NPS > 0 {
+		engineNPS.Observe(float64(info.NPS), speed)
+	}
+}
+
+// gameResult classifies the final state of a game from our point of view.
+func gameResult(isWhite bool, state blitz.GameState) string {
+	switch {
+	case state.Status == "aborted" || state.Status == "noStart":
+		return "aborted"
+	case state.Winner == "":
+		if state.Status == "started" || state.Status == "" {
+			return "unknown"
+		}
+		return "draw"
+	case (state.Winner == "white") == isWhite:
+		return "win"
+	default:
+		return "loss"
+	}
+}
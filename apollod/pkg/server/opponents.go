@@ -0,0 +1,39 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// opponentCounter counts the games played against each opponent today, so that no one opponent can monopolize us.
+// Days are UTC days. The zero value is ready to use.
+type opponentCounter struct {
+	lock  sync.Mutex
+	day   time.Time
+	games map[string]int
+}
+
+// played records that a game against opponent has started.
+func (c *opponentCounter) played(opponent string, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rollover(now)
+	c.games[strings.ToLower(opponent)]++
+}
+
+// today returns the number of games against opponent that have started today.
+func (c *opponentCounter) today(opponent string, now time.Time) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rollover(now)
+	return c.games[strings.ToLower(opponent)]
+}
+
+// rollover starts counting from scratch at the start of every day.
+func (c *opponentCounter) rollover(now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	if c.games == nil || !day.Equal(c.day) {
+		c.day, c.games = day, make(map[string]int)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+// ownerCommandPrefix starts every owner command, e.g. "apollo pause".
+const ownerCommandPrefix = "apollo"
+
+// isOwner returns true if username is the configured owner. Lichess only lets logged-in users chat, so a chat line's
+// username is as trustworthy as lichess's authentication.
+func (s *Server) isOwner(username string) bool {
+	owner := s.chatConfig().Owner
+	return owner != "" && strings.EqualFold(username, owner)
+}
+
+// handleOwnerCommand carries out a control command sent by the owner in a game's chat, returning false if the line
+// isn't an owner command at all. Commands are:
+//   - apollo pause, apollo resume: stop and start accepting challenges
+//   - apollo resign [game]: resign this game, or the given game
+//   - apollo status: summarize the server's state
+func (s *Server) handleOwnerCommand(ctx context.Context, gameID string, line blitz.ChatLine) bool {
+	fields := strings.Fields(strings.ToLower(line.Text))
+	if len(fields) < 2 || fields[0] != ownerCommandPrefix {
+		return false
+	}
+
+	logger := log.WithFields(log.Fields{
+		"game_id": gameID,
+		"owner":   line.Username,
+		"command": fields[1],
+	})
+	var reply string
+	switch fields[1] {
+	case "pause":
+		s.Pause()
+		reply = "Paused, no longer accepting challenges."
+	case "resume":
+		s.Resume()
+		reply = "Resumed, accepting challenges again."
+	case "resign":
+		target := gameID
+		if len(fields) > 2 {
+			target = fields[2]
+		}
+		switch found, err := s.Resign(ctx, target); {
+		case !found:
+			reply = fmt.Sprintf("I'm not playing a game %s.", target)
+		case err != nil:
+			logger.WithError(err).Warn("failed to resign game at owner's request")
+			reply = fmt.Sprintf("Failed to resign %s.", target)
+		default:
+			reply = fmt.Sprintf("Resigned %s.", target)
+		}
+	case "status":
+		reply = summarizeStatus(s.Status())
+	default:
+		reply = "Owner commands: apollo pause, apollo resume, apollo resign [game], apollo status"
+	}
+
+	logger.Info("carrying out owner command")
+	if err := s.writeChat(ctx, gameID, line.Room, reply); err != nil {
+		logger.WithError(err).Warn("failed to reply to owner command")
+	}
+	return true
+}
+
+// summarizeStatus describes the server's state in a line short enough for chat.
+func summarizeStatus(status Status) string {
+	var ids []string
+	for _, game := range status.Games {
+		ids = append(ids, game.ID)
+	}
+	summary := fmt.Sprintf("Playing %d of %d games", len(status.Games), status.MaxConcurrentGames)
+	if len(ids) > 0 {
+		summary += " (" + strings.Join(ids, ", ") + ")"
+	}
+	if status.Paused {
+		summary += ", paused"
+	}
+	if !status.Available {
+		summary += ", outside availability windows"
+	}
+	if status.RateLimitedFor != "" {
+		summary += ", rate limited for " + status.RateLimitedFor
+	}
+	return summary + "."
+}
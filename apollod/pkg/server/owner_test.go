@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+func TestOwnerCommands(t *testing.T) {
+	var sent []string
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+		req.ParseForm()
+		sent = append(sent, req.PostForm.Get("room")+": "+req.PostForm.Get("text"))
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("{}")), Header: make(http.Header)}
+	})}
+	s := &Server{
+		client:    blitz.New("", blitz.WithHTTPClient(httpClient)),
+		rateLimit: &rateLimiter{},
+		user:      &blitz.AccountResponse{Username: "my_bot"},
+		chat:      ChatConfig{Owner: "Boss"},
+		games:     map[string]*liveGame{},
+	}
+	ctx := context.Background()
+
+	assert.False(t, s.isOwner("someone"))
+	assert.False(t, s.handleOwnerCommand(ctx, "abc", blitz.ChatLine{Username: "boss", Text: "good move"}))
+	assert.True(t, s.handleOwnerCommand(ctx, "abc", blitz.ChatLine{Username: "boss", Room: "spectator", Text: "Apollo pause"}))
+	assert.True(t, s.isPaused())
+	assert.True(t, s.handleOwnerCommand(ctx, "abc", blitz.ChatLine{Username: "boss", Room: "spectator", Text: "apollo resign xyz"}))
+	assert.Equal(t, []string{
+		"spectator: Paused, no longer accepting challenges.",
+		"spectator: I'm not playing a game xyz.",
+	}, sent)
+}
+
+func TestSummarizeStatus(t *testing.T) {
+	assert.Equal(t, "Playing 1 of 2 games (abc), paused, rate limited for 30s.", summarizeStatus(Status{
+		MaxConcurrentGames: 2,
+		Paused:             true,
+		Available:          true,
+		RateLimitedFor:     "30s",
+		Games:              []GameStatus{{ID: "abc"}},
+	}))
+	assert.Equal(t, "Playing 0 of 2 games, outside availability windows.", summarizeStatus(Status{
+		MaxConcurrentGames: 2,
+	}))
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+// ChallengePolicy decides which incoming challenges the server accepts.
+type ChallengePolicy struct {
+	// Variants lists the variant keys that we accept. Every entry must be a variant that Apollo can play, or one that
+	// is routed to an engine of its own.
+	Variants []string `yaml:"variants"`
+	// MinInitial and MaxInitial bound the initial clock time, in seconds. A zero MaxInitial means no upper bound.
+	MinInitial int `yaml:"minInitial"`
+	MaxInitial int `yaml:"maxInitial"`
+	// MaxIncrement bounds the clock increment, in seconds. Zero means no upper bound.
+	MaxIncrement int `yaml:"maxIncrement"`
+	// AllowUnlimited controls whether we accept correspondence and unlimited games.
+	AllowUnlimited bool `yaml:"allowUnlimited"`
+	AllowRated     bool `yaml:"allowRated"`
+	AllowCasual    bool `yaml:"allowCasual"`
+	// MaxGamesPerOpponent limits the games we'll play against any one opponent each day. Zero means no limit.
+	MaxGamesPerOpponent int `yaml:"maxGamesPerOpponent"`
+	// History declines challengers with a habit of aborting or abandoning games.
+	History HistoryPolicy `yaml:"history"`
+}
+
+// DefaultChallengePolicy accepts any rated or casual real-time game of chess that Apollo can play.
+func DefaultChallengePolicy() ChallengePolicy {
+	return ChallengePolicy{
+		Variants:    []string{"standard", "fromPosition"},
+		AllowRated:  true,
+		AllowCasual: true,
+		History:     HistoryPolicy{Games: 20},
+	}
+}
+
+// Validate checks that the policy is internally consistent.
+func (p ChallengePolicy) Validate() error {
+	if len(p.Variants) == 0 {
+		return errors.New("challenge policy must accept at least one variant")
+	}
+	for _, variant := range p.Variants {
+		if !knownVariant(variant) {
+			return errors.Errorf("unknown variant %q", variant)
+		}
+	}
+	if p.MaxGamesPerOpponent < 0 {
+		return errors.New("challenge policy maxGamesPerOpponent must not be negative")
+	}
+	if err := p.History.validate(); err != nil {
+		return err
+	}
+	if p.MinInitial < 0 || p.MaxInitial < 0 || p.MaxIncrement < 0 {
+		return errors.New("challenge policy clock bounds must not be negative")
+	}
+	if p.MaxInitial != 0 && p.MinInitial > p.MaxInitial {
+		return errors.New("challenge policy minInitial exceeds maxInitial")
+	}
+	if !p.AllowRated && !p.AllowCasual {
+		return errors.New("challenge policy must allow rated or casual games")
+	}
+	return nil
+}
+
+// ValidateEngines checks that every variant the policy accepts can be played, by apollo or by the engine that the
+// variant is routed to.
+func (p ChallengePolicy) ValidateEngines(variants map[string]EngineProfile) error {
+	for _, variant := range p.Variants {
+		if hasOwnRules(blitz.Variant{Key: variant}) && variants[variant].Path == "" {
+			return errors.Errorf("apollo cannot play variant %q, route it to an engine that can", variant)
+		}
+	}
+	return nil
+}
+
+// knownVariant returns true if lichess has a variant with the given key.
+func knownVariant(variant string) bool {
+	if apolloPlaysVariant(blitz.Variant{Key: variant}) {
+		return true
+	}
+	_, ok := uciVariants[variant]
+	return ok
+}
+
+// WithChallengePolicy sets the policy used to decide which challenges to accept.
+func WithChallengePolicy(policy ChallengePolicy) ServerOption {
+	return func(server *Server) {
+		server.policy = policy
+	}
+}
+
+// evaluate decides whether to accept a challenge. If not, it also returns the reason to give the challenger.
+func (p ChallengePolicy) evaluate(challenge blitz.Challenge) (bool, blitz.DeclineReason) {
+	if !contains(p.Variants, challenge.Variant.Key) {
+		return false, blitz.DeclineVariant
+	}
+
+	if challenge.Rated && !p.AllowRated {
+		return false, blitz.DeclineCasual
+	}
+	if !challenge.Rated && !p.AllowCasual {
+		return false, blitz.DeclineRated
+	}
+
+	if challenge.TimeControl.Type != "clock" {
+		if !p.AllowUnlimited {
+			return false, blitz.DeclineTimeControl
+		}
+		return true, ""
+	}
+
+	if challenge.TimeControl.Limit < p.MinInitial {
+		return false, blitz.DeclineTooFast
+	}
+	if p.MaxInitial != 0 && challenge.TimeControl.Limit > p.MaxInitial {
+		return false, blitz.DeclineTooSlow
+	}
+	if p.MaxIncrement != 0 && challenge.TimeControl.Increment > p.MaxIncrement {
+		return false, blitz.DeclineTimeControl
+	}
+	return true, ""
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
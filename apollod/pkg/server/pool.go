@@ -0,0 +1,52 @@
+package server
+
+import (
+	"sync"
+)
+
+// Pool is shared by servers playing for several lichess accounts in the same process. They draw from the same pool of
+// engines, so that the machine isn't asked to run more searches than it has room for, and back off together when
+// lichess rate limits any of them, since lichess limits the whole process's address rather than one token.
+type Pool struct {
+	rateLimit *rateLimiter
+
+	lock    sync.Mutex
+	engines int
+	inUse   int
+}
+
+// NewPool creates a pool that runs at most engines engines at once.
+func NewPool(engines int) *Pool {
+	return &Pool{rateLimit: &rateLimiter{}, engines: engines}
+}
+
+// WithPool has the server share engines and rate limiting with every other server using the same pool.
+func WithPool(pool *Pool) ServerOption {
+	return func(server *Server) {
+		server.pool = pool
+	}
+}
+
+// tryAcquire claims an engine for a game, returning false if they're all in use.
+func (p *Pool) tryAcquire() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.inUse >= p.engines {
+		return false
+	}
+	p.inUse++
+	return true
+}
+
+func (p *Pool) release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inUse--
+}
+
+// full returns true if every engine is in use.
+func (p *Pool) full() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.inUse >= p.engines
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+)
+
+const (
+	defaultPrepGames = 50
+	defaultPrepPlies = 12
+	defaultPrepTTL   = 7 * 24 * time.Hour
+	// prepTimeout bounds how long the start of a game waits on lichess for an opponent's games.
+	prepTimeout = 10 * time.Second
+	// prepOpeningPlies is how long the lines reported as an opponent's favorite openings are.
+	prepOpeningPlies = 6
+	// prepOpenings is how many favorite openings are kept for each color.
+	prepOpenings = 5
+)
+
+// PrepConfig controls preparing for opponents. Before a game, we look through the opponent's recent games for the
+// positions they like to reach with the color they're playing and steer our book away from them, so that they're taken
+// out of their preparation instead of us walking into it.
+type PrepConfig struct {
+	// Games is how many of the opponent's most recent games to prepare from. Zero disables preparation.
+	Games int `yaml:"games"`
+	// Plies is how deep into each of the opponent's games to look.
+	Plies int `yaml:"plies"`
+	// Dir is the directory that every opponent's preparation is kept in, so that it survives restarts. Empty keeps it
+	// in memory only.
+	Dir string `yaml:"dir"`
+	// TTL is how long an opponent's preparation is used before it is redone from their latest games.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// DefaultPrepConfig prepares from an opponent's last fifty games and redoes it once a week. Preparation is off unless the
+// book is.
+func DefaultPrepConfig() PrepConfig {
+	return PrepConfig{Games: defaultPrepGames, Plies: defaultPrepPlies, TTL: defaultPrepTTL}
+}
+
+// Validate checks that the limits aren't negative and that there are plies to prepare from.
+func (c PrepConfig) Validate() error {
+	if c.Games < 0 || c.Plies < 0 || c.TTL < 0 {
+		return errors.New("games, plies and ttl must not be negative")
+	}
+	if c.Games > 0 && c.Plies == 0 {
+		return errors.New("plies must be positive to prepare from games")
+	}
+	return nil
+}
+
+// OpponentPrep is what we learned about an opponent's openings from their recent games.
+type OpponentPrep struct {
+	// UserID is the opponent's lichess ID.
+	UserID   string    `json:"userId"`
+	Prepared time.Time `json:"prepared"`
+	White    PrepSide  `json:"white"`
+	Black    PrepSide  `json:"black"`
+}
+
+// PrepSide is an opponent's openings with one color.
+type PrepSide struct {
+	// Games is the number of games the opponent played with this color.
+	Games int `json:"games"`
+	// Positions counts how many of those games reached each position, keyed by FEN without the move counters.
+	Positions map[string]int `json:"positions,omitempty"`
+	// Openings are the opponent's most common first moves with this color, most common first.
+	Openings []PrepOpening `json:"openings,omitempty"`
+}
+
+// PrepOpening is an opening line and the number of games the opponent played it in.
+type PrepOpening struct {
+	Moves string `json:"moves"`
+	Games int    `json:"games"`
+}
+
+// side returns the opponent's openings with a color.
+func (p *OpponentPrep) side(white bool) *PrepSide {
+	if white {
+		return &p.White
+	}
+	return &p.Black
+}
+
+// analyzeOpponent tallies the positions an opponent reached in the first plies of their games. Games from another
+// variant or starting position are skipped, since our book can't lead into them.
+func analyzeOpponent(userID string, games []blitz.GameSummary, plies int, now time.Time) *OpponentPrep {
+	userID = strings.ToLower(userID)
+	prep := &OpponentPrep{UserID: userID, Prepared: now}
+	openings := map[*PrepSide]map[string]int{&prep.White: {}, &prep.Black: {}}
+	for _, game := range games {
+		if (game.Variant != "" && game.Variant != "standard") || game.InitialFen != "" || game.Moves == "" {
+			continue
+		}
+		var side *PrepSide
+		switch userID {
+		case strings.ToLower(game.Players.White.User.ID):
+			side = &prep.White
+		case strings.ToLower(game.Players.Black.User.ID):
+			side = &prep.Black
+		default:
+			continue
+		}
+
+		board := chess.NewGame()
+		var line []string
+		reached := make(map[string]bool)
+		for i, move := range strings.Fields(game.Moves) {
+			if i >= plies || board.MoveStr(move) != nil {
+				break
+			}
+			reached[positionKey(board.Position())] = true
+			if i < prepOpeningPlies {
+				line = append(line, move)
+			}
+		}
+		if len(reached) == 0 {
+			continue
+		}
+		side.Games++
+		if side.Positions == nil {
+			side.Positions = make(map[string]int)
+		}
+		for key := range reached {
+			side.Positions[key]++
+		}
+		openings[side][strings.Join(line, " ")]++
+	}
+
+	for side, lines := range openings {
+		for moves, count := range lines {
+			side.Openings = append(side.Openings, PrepOpening{Moves: moves, Games: count})
+		}
+		sort.Slice(side.Openings, func(i, j int) bool {
+			if side.Openings[i].Games != side.Openings[j].Games {
+				return side.Openings[i].Games > side.Openings[j].Games
+			}
+			return side.Openings[i].Moves < side.Openings[j].Moves
+		})
+		if len(side.Openings) > prepOpenings {
+			side.Openings = side.Openings[:prepOpenings]
+		}
+	}
+	return prep
+}
+
+// positionKey identifies a position regardless of how many moves it took to reach it.
+func positionKey(position *chess.Position) string {
+	fields := strings.Fields(position.String())
+	if len(fields) > 4 {
+		fields = fields[:4]
+	}
+	return strings.Join(fields, " ")
+}
+
+// avoid weighs a book move from position by how rarely the opponent reaches the position it leads to. A move into a
+// position they've never reached keeps games+1 times its weight, and one into a position they reach in every game
+// keeps its weight as is, so their pet lines are still played now and then, just far less often.
+func (p *PrepSide) avoid(position *chess.Position) func(book.Entry) int {
+	return func(entry book.Entry) int {
+		move, err := chess.LongAlgebraicNotation{}.Decode(position, entry.Move)
+		if err != nil {
+			return entry.Weight
+		}
+		reached := p.Positions[positionKey(position.Update(move))]
+		return entry.Weight * (p.Games + 1 - reached)
+	}
+}
+
+// WithOpponentPrep has the server prepare for every opponent from their recent games, steering its book away from the
+// opponent's favorite lines. It has no effect without a book.
+func WithOpponentPrep(config PrepConfig) ServerOption {
+	return func(server *Server) {
+		server.preps = &prepCache{config: config, preps: make(map[string]*OpponentPrep)}
+	}
+}
+
+// prepCache keeps every opponent's preparation in memory and, if it has a directory, on disk, until it expires.
+type prepCache struct {
+	config PrepConfig
+	lock   sync.Mutex
+	preps  map[string]*OpponentPrep
+}
+
+// get returns an opponent's preparation, if it is recent enough to use.
+func (c *prepCache) get(userID string, now time.Time) (*OpponentPrep, bool) {
+	userID = strings.ToLower(userID)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	prep, ok := c.preps[userID]
+	if !ok && c.config.Dir != "" {
+		prep, ok = c.load(userID)
+		if ok {
+			c.preps[userID] = prep
+		}
+	}
+	if !ok || (c.config.TTL > 0 && now.Sub(prep.Prepared) > c.config.TTL) {
+		return nil, false
+	}
+	return prep, true
+}
+
+// put remembers an opponent's preparation, saving it to disk if the cache has a directory.
+func (c *prepCache) put(prep *OpponentPrep) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.preps[prep.UserID] = prep
+	if c.config.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.config.Dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create preparation directory")
+	}
+	data, err := json.MarshalIndent(prep, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename, so that a crash never leaves a torn file behind.
+	path := c.path(prep.UserID)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return errors.Wrap(err, "failed to write preparation")
+	}
+	return errors.Wrap(os.Rename(path+".tmp", path), "failed to write preparation")
+}
+
+// load reads an opponent's preparation from disk. A missing or unreadable file is as good as no preparation.
+func (c *prepCache) load(userID string) (*OpponentPrep, bool) {
+	data, err := ioutil.ReadFile(c.path(userID))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("opponent", userID).Warn("failed to read preparation")
+		}
+		return nil, false
+	}
+	var prep OpponentPrep
+	if err := json.Unmarshal(data, &prep); err != nil {
+		log.WithError(err).WithField("opponent", userID).Warn("failed to parse preparation")
+		return nil, false
+	}
+	return &prep, true
+}
+
+func (c *prepCache) path(userID string) string {
+	return filepath.Join(c.config.Dir, filepath.Base(userID)+".json")
+}
+
+// prepare returns our preparation for an opponent who is playing white or black, redoing it from their latest games if
+// we have none or it has expired. It returns nil if the opponent can't be prepared for, in which case the book is used
+// as is.
+func (s *Server) prepare(ctx context.Context, record *gameRecord) *PrepSide {
+	opponent := record.opponent()
+	if s.preps == nil || s.preps.config.Games <= 0 || s.book == nil || opponent.ID == "" {
+		return nil
+	}
+	logger := record.logger().WithField("opponent", opponent.ID)
+	prep, ok := s.preps.get(opponent.ID, time.Now())
+	if ok {
+		opponentPreps.Inc("cached")
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, prepTimeout)
+		defer cancel()
+		games, err := s.client.Games.ExportUserGames(ctx, opponent.ID, blitz.ExportOptions{
+			Max:   s.preps.config.Games,
+			Moves: true,
+		})
+		if err != nil {
+			opponentPreps.Inc("error")
+			logger.WithError(err).Warn("failed to fetch opponent's games to prepare for them")
+			return nil
+		}
+		opponentPreps.Inc("prepared")
+		prep = analyzeOpponent(opponent.ID, games, s.preps.config.Plies, time.Now())
+		if err := s.preps.put(prep); err != nil {
+			logger.WithError(err).Warn("failed to save preparation")
+		}
+	}
+
+	side := prep.side(!record.isWhite)
+	if side.Games == 0 {
+		return nil
+	}
+	fields := log.Fields{"games": side.Games}
+	if len(side.Openings) > 0 {
+		fields["favorite"] = side.Openings[0].Moves
+	}
+	logger.WithFields(fields).Info("prepared for opponent")
+	return side
+}
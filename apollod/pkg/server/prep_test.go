@@ -0,0 +1,88 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+)
+
+func prepGame(white, black, moves string) blitz.GameSummary {
+	game := blitz.GameSummary{Variant: "standard", Moves: moves}
+	game.Players.White.User.ID = white
+	game.Players.Black.User.ID = black
+	return game
+}
+
+func TestAnalyzeOpponent(t *testing.T) {
+	games := []blitz.GameSummary{
+		prepGame("other", "someone", "e4 c5 Nf3 d6 d4 cxd4 Nxd4 Nf6"),
+		prepGame("other", "someone", "e4 c5 Nc3 Nc6"),
+		prepGame("other", "someone", "d4 d5 c4 e6"),
+		prepGame("someone", "other", "e4 e5 Nf3 Nc6"),
+	}
+	fromPosition := prepGame("other", "someone", "e4 e5")
+	fromPosition.InitialFen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+	games = append(games, fromPosition)
+
+	prep := analyzeOpponent("Someone", games, 2, time.Now())
+	assert.Equal(t, "someone", prep.UserID)
+	assert.Equal(t, 1, prep.White.Games)
+	assert.Equal(t, 3, prep.Black.Games)
+	assert.Equal(t, []PrepOpening{{Moves: "e4 c5", Games: 2}, {Moves: "d4 d5", Games: 1}}, prep.Black.Openings)
+}
+
+func TestBookMoveAvoidsPrep(t *testing.T) {
+	openings, err := book.LoadPGN(strings.NewReader("1. e4 c5 1-0\n\n1. d4 d5 1-0\n"), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s := &Server{book: openings}
+
+	// Someone who answers 1. e4 with the Sicilian every time is only met with 1. e4 one time in eleven.
+	games := make([]blitz.GameSummary, 10)
+	for i := range games {
+		games[i] = prepGame("other", "someone", "e4 c5")
+	}
+	prep := analyzeOpponent("someone", games, 4, time.Now())
+	e4 := 0
+	for i := 0; i < 200; i++ {
+		move, ok := s.bookMove("blitz", "", nil, &prep.Black)
+		assert.True(t, ok)
+		if move == "e2e4" {
+			e4++
+		}
+	}
+	assert.True(t, e4 < 50, "played 1. e4 %d times in 200", e4)
+}
+
+func TestPrepCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prep")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	config := PrepConfig{Games: 10, Plies: 4, Dir: dir, TTL: time.Hour}
+	cache := &prepCache{config: config, preps: make(map[string]*OpponentPrep)}
+	now := time.Now()
+	prep := analyzeOpponent("someone", []blitz.GameSummary{prepGame("other", "someone", "e4 c5")}, 4, now)
+	assert.NoError(t, cache.put(prep))
+
+	// A new cache, as after a restart, finds it on disk.
+	reopened := &prepCache{config: config, preps: make(map[string]*OpponentPrep)}
+	loaded, ok := reopened.get("Someone", now)
+	if assert.True(t, ok) {
+		assert.Equal(t, prep.Black.Positions, loaded.Black.Positions)
+	}
+	_, ok = reopened.get("someone", now.Add(2*time.Hour))
+	assert.False(t, ok, "expired")
+	_, ok = reopened.get("nobody", now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRateLimitCooldown is how long to back off after lichess rate limits us without saying for how long. Lichess
+// asks API clients to wait a full minute after a 429.
+const defaultRateLimitCooldown = time.Minute
+
+// rateLimiter tracks whether lichess has recently rate limited us. While it's cooling down, the server holds off on
+// anything that isn't playing moves in games already in progress: accepting challenges, matchmaking, and chat.
+type rateLimiter struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// limited records that lichess rate limited us, asking us to wait for the given duration.
+func (r *rateLimiter) limited(retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = defaultRateLimitCooldown
+	}
+	until := time.Now().Add(retryAfter)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if until.After(r.until) {
+		log.WithField("cooldown", retryAfter).Warn("rate limited by lichess, cooling down")
+		r.until = until
+	}
+}
+
+// remaining returns how much longer we're cooling down for, or zero if we aren't.
+func (r *rateLimiter) remaining() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if left := time.Until(r.until); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// wait blocks until the cool-down, including any extensions of it while waiting, is over.
+func (r *rateLimiter) wait(ctx context.Context) error {
+	for {
+		left := r.remaining()
+		if left == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(left):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// rateLimitTransport notices lichess rate limiting us.
+type rateLimitTransport struct {
+	inner   http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.inner.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		rateLimited.Inc()
+		t.limiter.limited(retryAfter(resp.Header))
+	}
+	return resp, err
+}
+
+func rateLimitedHTTPClient(limiter *rateLimiter) *http.Client {
+	return &http.Client{Transport: rateLimitTransport{http.DefaultTransport, limiter}}
+}
+
+// retryAfter parses the delay-seconds form of a Retry-After header, returning zero if there isn't one.
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// writeChat sends a chat message, which is never urgent. If we're cooling down, the message is sent once the cool-down
+// is over instead.
+func (s *Server) writeChat(ctx context.Context, gameID, room, text string) error {
+	if s.rateLimit.remaining() == 0 {
+		return s.client.Bot.WriteChat(ctx, gameID, room, text)
+	}
+
+	go func() {
+		if err := s.rateLimit.wait(ctx); err != nil {
+			return
+		}
+		if err := s.client.Bot.WriteChat(ctx, gameID, room, text); err != nil {
+			log.WithError(err).Warn("failed to send delayed chat message")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/archive"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+)
+
+// archiveDelay gives lichess a moment to finalize a game before we export it.
+const archiveDelay = 5 * time.Second
+
+// gameRecord accumulates what we learn about a game while playing it.
+type gameRecord struct {
+	id        string
+	full      blitz.GameFull
+	hasFull   bool
+	isWhite   bool
+	lastState blitz.GameState
+	log       *gameLog
+	searches  []archive.Search
+	// broadcastPly is the number of moves we've told observers about.
+	broadcastPly int
+	// storedPly is the number of moves we've stored.
+	storedPly int
+	// enginePath is the engine binary the game is played with, and build is the build it's tagged with when a canary
+	// is running.
+	enginePath string
+	build      string
+	// etiquette is how we conduct ourselves in the game.
+	etiquette Etiquette
+	// prep is what we know of our opponent's openings with the color they're playing, if we prepared for them.
+	prep *PrepSide
+	// abortedByUs is true if we gave up on the game ourselves, so an abort isn't our opponent's fault.
+	abortedByUs bool
+	// uciTail keeps the end of the engine's UCI transcript, and lastEvent the last event lichess sent about the game,
+	// for crash reports.
+	uciTail   *tailWriter
+	lastEvent string
+}
+
+// logger returns the log entry for everything about this game.
+func (g *gameRecord) logger() *log.Entry {
+	if g.log == nil {
+		return log.WithField("game_id", g.id)
+	}
+	return g.log.entry
+}
+
+// transcript returns the writer for the game's engine UCI transcript.
+func (g *gameRecord) transcript() io.Writer {
+	if g.uciTail == nil {
+		return g.log.transcript
+	}
+	return io.MultiWriter(g.log.transcript, g.uciTail)
+}
+
+// opponent returns the player we're playing against, once we know who that is.
+func (g *gameRecord) opponent() blitz.GamePlayer {
+	if g.isWhite {
+		return g.full.Black
+	}
+	return g.full.White
+}
+
+// identify tags the game's log with our opponent and color, once we know them.
+func (g *gameRecord) identify() {
+	if g.log == nil {
+		return
+	}
+	g.log.identify(g.opponent().Name, g.isWhite)
+}
+
+// recordSearch records the engine's search for the move it played after ply half-moves.
+func (g *gameRecord) recordSearch(ply int, move string, info engine.Info, elapsed time.Duration) {
+	search := archive.Search{
+		Ply:      ply,
+		Move:     move,
+		Depth:    info.Depth,
+		SelDepth: info.SelDepth,
+		Nodes:    info.Nodes,
+		NPS:      info.NPS,
+		TimeMs:   int(elapsed / time.Millisecond),
+	}
+	if info.HasScore {
+		search.Centipawns, search.Mate = info.Score.Centipawns, info.Score.Mate
+	}
+	g.searches = append(g.searches, search)
+
+	fields := log.Fields{
+		"move":    move,
+		"depth":   info.Depth,
+		"nodes":   info.Nodes,
+		"nps":     info.NPS,
+		"elapsed": elapsed,
+	}
+	if info.HasScore {
+		fields["score"] = formatScore(info.Score)
+	}
+	g.logger().WithFields(fields).Info("engine search finished")
+}
+
+// result classifies the outcome of the game from our point of view.
+func (g *gameRecord) result() string {
+	return gameResult(g.isWhite, g.lastState)
+}
+
+func (g *gameRecord) metadata() archive.Metadata {
+	color := "black"
+	if g.isWhite {
+		color = "white"
+	}
+	return archive.Metadata{
+		ID:          g.id,
+		White:       g.full.White.Name,
+		Black:       g.full.Black.Name,
+		WhiteRating: g.full.White.Rating,
+		BlackRating: g.full.Black.Rating,
+		ApolloColor: color,
+		Result:      g.result(),
+		Status:      g.lastState.Status,
+		Variant:     g.full.Variant.Key,
+		Speed:       g.full.Speed,
+		Rated:       g.full.Rated,
+		Build:       g.build,
+		Searches:    g.searches,
+	}
+}
+
+// WithArchive enables archiving the PGN of every game the server plays.
+func WithArchive(store archive.Store) ServerOption {
+	return func(server *Server) {
+		server.archive = &archive.Archive{Store: store}
+	}
+}
+
+// archiveGame exports a finished game from lichess and writes it to the archive.
+func (s *Server) archiveGame(ctx context.Context, record *gameRecord) {
+	if !record.hasFull || record.result() == "aborted" {
+		return
+	}
+
+	time.Sleep(archiveDelay)
+	export, err := s.client.Games.ExportGame(ctx, record.id)
+	if err != nil {
+		log.WithError(err).WithField("game_id", record.id).Warn("failed to export game for archival")
+		return
+	}
+	if err := s.archive.Save(ctx, record.metadata(), record.annotate(export)); err != nil {
+		log.WithError(err).WithField("game_id", record.id).Warn("failed to archive game")
+		return
+	}
+	log.WithField("game_id", record.id).Info("archived game")
+}
+
+// annotate adds the engine's evaluation after each of our moves to the game as lichess exported it, unless lichess
+// analyzed the game and evaluated the move itself. An export that can't be read is archived as it is.
+func (g *gameRecord) annotate(export string) string {
+	game, err := pgn.Parse(export)
+	if err != nil {
+		g.logger().WithError(err).Warn("failed to parse exported game, archiving it without evaluations")
+		return export
+	}
+	moves, err := game.UCI()
+	if err != nil {
+		g.logger().WithError(err).Warn("failed to replay exported game, archiving it without evaluations")
+		return export
+	}
+
+	annotated := false
+	for _, search := range g.searches {
+		if search.Ply >= len(moves) || moves[search.Ply] != search.Move || game.Moves[search.Ply].HasEval {
+			continue
+		}
+		// Searches are scored from our side, but PGN evaluations are from white's.
+		eval := engine.Score{Centipawns: search.Centipawns, Mate: search.Mate}
+		if !g.isWhite {
+			eval = engine.Score{Centipawns: -eval.Centipawns, Mate: -eval.Mate}
+		}
+		move := game.Moves[search.Ply]
+		move.Eval, move.HasEval = eval, true
+		annotated = true
+	}
+	if !annotated {
+		return export
+	}
+	game.Tags.Set("Annotator", "apollod")
+	return game.String()
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Reloadable is the part of a server's configuration that can change while it runs.
+type Reloadable struct {
+	Policy    ChallengePolicy
+	Engine    EngineProfile
+	Profiles  map[string]EngineProfile
+	Variants  map[string]EngineProfile
+	Canary    CanaryConfig
+	Chat      ChatConfig
+	Etiquette EtiquetteConfig
+	Reports   ReportConfig
+}
+
+// WithReload lets the admin API reload the configuration by calling reload, which is usually shared by every account's
+// server because they share a configuration file.
+func WithReload(reload func() error) ServerOption {
+	return func(server *Server) {
+		server.reload = reload
+	}
+}
+
+// Reload applies a new configuration without interrupting anything. Challenges, chat, and reports follow it from now
+// on, and new games are played with its engine, but games in progress keep the engine they started with.
+func (s *Server) Reload(config Reloadable) error {
+	if err := config.Policy.Validate(); err != nil {
+		return errors.Wrap(err, "invalid challenge policy")
+	}
+	if err := config.Policy.ValidateEngines(config.Variants); err != nil {
+		return errors.Wrap(err, "invalid challenge policy")
+	}
+	if err := config.Canary.Validate(); err != nil {
+		return errors.Wrap(err, "invalid canary")
+	}
+	if err := config.Etiquette.Validate(); err != nil {
+		return errors.Wrap(err, "invalid etiquette")
+	}
+
+	s.engineLock.Lock()
+	s.engine = config.Engine
+	s.engineProfiles = config.Profiles
+	s.variantEngines = config.Variants
+	s.canary = config.Canary
+	s.engineLock.Unlock()
+
+	s.configLock.Lock()
+	s.policy = config.Policy
+	s.chat = config.Chat
+	s.etiquette = config.Etiquette
+	s.reports = config.Reports
+	s.configLock.Unlock()
+	log.WithField("username", s.user.Username).Info("reloaded configuration")
+	return nil
+}
+
+func (s *Server) challengePolicy() ChallengePolicy {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.policy
+}
+
+func (s *Server) chatConfig() ChatConfig {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.chat
+}
+
+func (s *Server) reportConfig() ReportConfig {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.reports
+}
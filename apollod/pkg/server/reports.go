@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+)
+
+// reportIdleCheck is how often the report loop checks whether reports have been turned on, while they're off.
+const reportIdleCheck = time.Minute
+
+// ReportConfig controls periodic performance reports sent to the bot's owner.
+type ReportConfig struct {
+	// Owner is the lichess user to send reports to. If empty, no reports are sent.
+	Owner string `yaml:"owner"`
+	// Interval is both how often reports are sent and the period that each report covers.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// WithResults records the outcome of every game to the given store and, if the report configuration names an
+// owner, periodically messages them a summary.
+func WithResults(store results.Recorder, reports ReportConfig) ServerOption {
+	return func(server *Server) {
+		server.results = store
+		server.reports = reports
+	}
+}
+
+// recordResult appends a finished game to the results store.
+func (s *Server) recordResult(record *gameRecord) {
+	if s.results == nil || !record.hasFull {
+		return
+	}
+
+	opponent, color := record.full.Black, "white"
+	if !record.isWhite {
+		opponent, color = record.full.White, "black"
+	}
+	err := s.results.Append(results.Record{
+		Time:           time.Now().UTC(),
+		GameID:         record.id,
+		Opponent:       opponent.Name,
+		OpponentRating: opponent.Rating,
+		OpponentTitle:  opponent.Title,
+		Color:          color,
+		Result:         record.result(),
+		Termination:    record.lastState.Status,
+		Speed:          record.full.Speed,
+		Rated:          record.full.Rated,
+		Build:          record.build,
+	})
+	if err != nil {
+		log.WithError(err).WithField("game_id", record.id).Warn("failed to record game result")
+	}
+}
+
+func (s *Server) reportLoop(ctx context.Context) {
+	log.Info("report loop starting")
+	for {
+		// Reports can be turned on, off, or retimed by a reload, so the configuration is read afresh every time.
+		wait := reportIdleCheck
+		if reports := s.reportConfig(); reports.Owner != "" && reports.Interval > 0 {
+			wait = reports.Interval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		reports := s.reportConfig()
+		if reports.Owner == "" || reports.Interval <= 0 {
+			continue
+		}
+		since := time.Now().Add(-reports.Interval)
+		records, err := s.results.Since(since)
+		if err != nil {
+			log.WithError(err).Warn("failed to read results for report")
+			continue
+		}
+
+		summary := results.Summarize(since, records)
+		if err := s.rateLimit.wait(ctx); err != nil {
+			return
+		}
+		if err := s.client.Users.SendMessage(ctx, reports.Owner, summary.String()); err != nil {
+			log.WithError(err).Warn("failed to send report to owner")
+		}
+	}
+}
@@ -2,32 +2,165 @@ package server
 
 import (
 	"context"
+	"io"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
 
+	"github.com/swgillespie/apollo/apollod/pkg/archive"
 	"github.com/swgillespie/apollo/apollod/pkg/blitz"
-	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/crash"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+
+	// Engines can speak either of these protocols, or run on an engine server.
+	_ "github.com/swgillespie/apollo/apollod/pkg/cecp"
+	_ "github.com/swgillespie/apollo/apollod/pkg/enginerpc"
+	_ "github.com/swgillespie/apollo/apollod/pkg/uci"
 )
 
 const (
-	maxPendingChallenges = 3
-	maxConcurrentGames   = 1
+	maxPendingChallenges      = 3
+	defaultMaxConcurrentGames = 2
+	minReconnectBackoff       = time.Second
+	maxReconnectBackoff       = 2 * time.Minute
 )
 
 type Server struct {
 	client        *blitz.Client
+	rateLimit     *rateLimiter
+	pool          *Pool
+	user          *blitz.AccountResponse
 	challenges    chan blitz.Challenge
 	gameSemaphore *semaphore.Weighted
+	gameLogDir    string
+	lichess       LichessConfig
+	// launchEngine starts an engine process, so that tests can substitute engines that don't need one.
+	launchEngine func(profile EngineProfile, transcript io.Writer) (engine.Engine, error)
+	// host is the machine that engines are sized for, when their profile asks for it.
+	host engine.Host
+	// enginePools keep engines running between games for the profiles that ask for it, keyed by profile and variant.
+	enginePoolsLock sync.Mutex
+	enginePools     map[string]*enginePool
+
+	maxConcurrentGames int
+	gamesLock          sync.Mutex
+	games              map[string]*liveGame
+	paused             bool
+	opponents          opponentCounter
+	blocklist          *Blocklist
+	histories          historyCache
+	events             eventBus
+	gamesWaitGroup     sync.WaitGroup
+	lastGameEnded      time.Time
+	recentGames        []FinishedGame
+	moveOverhead       time.Duration
+
+	canary       CanaryConfig
+	matchmaking  *MatchmakingConfig
+	availability *Availability
+	// configLock guards policy, chat, etiquette, and reports, which Reload replaces while the server runs.
+	configLock     sync.Mutex
+	policy         ChallengePolicy
+	engineLock     sync.Mutex
+	engine         EngineProfile
+	engineProfiles map[string]EngineProfile
+	// swappable are the engine binaries that SwapEngine may switch to.
+	swappable      map[string]bool
+	variantEngines map[string]EngineProfile
+	archive        *archive.Archive
+	results        results.Recorder
+	store          store.Repository
+	reports        ReportConfig
+	chat           ChatConfig
+	etiquette      EtiquetteConfig
+	book           *book.Book
+	bookConfig     BookConfig
+	preps          *prepCache
+	tablebase      TablebaseConfig
+	timeManager    TimeManagerConfig
+	moveDelay      MoveDelayConfig
+	clocks         ClockConfig
+	searchMetrics  bool
+	rematch        bool
+	// reload reloads the configuration for the admin API, if the server was started from a configuration file.
+	reload  func() error
+	crashes *crash.Reporter
 }
 
-func NewServer(token string) (*Server, error) {
-	client := blitz.New(token)
-	user, err := client.Account.GetProfile(context.Background())
+type ServerOption func(*Server)
+
+// WithMaxConcurrentGames sets the number of lichess games that the server will play at once.
+func WithMaxConcurrentGames(games int) ServerOption {
+	return func(server *Server) {
+		server.maxConcurrentGames = games
+	}
+}
+
+func NewServer(token string, options ...ServerOption) (*Server, error) {
+	server := &Server{
+		challenges:         make(chan blitz.Challenge, maxPendingChallenges),
+		maxConcurrentGames: defaultMaxConcurrentGames,
+		games:              make(map[string]*liveGame),
+		lastGameEnded:      time.Now(),
+		moveOverhead:       defaultMoveOverhead,
+		policy:             DefaultChallengePolicy(),
+		chat:               DefaultChatConfig(),
+		etiquette:          DefaultEtiquetteConfig(),
+		tablebase:          DefaultTablebaseConfig(),
+		timeManager:        DefaultTimeManagerConfig(),
+		moveDelay:          DefaultMoveDelayConfig(),
+		clocks:             DefaultClockConfig(),
+		lichess:            DefaultLichessConfig(),
+		launchEngine:       loadAndInitializeApollo,
+		host:               engine.DetectHost(),
+	}
+	for _, option := range options {
+		option(server)
+	}
+	if server.maxConcurrentGames < 1 {
+		return nil, errors.New("server must be allowed to play at least one game")
+	}
+	if err := server.policy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := server.policy.ValidateEngines(server.variantEngines); err != nil {
+		return nil, err
+	}
+	if err := server.etiquette.Validate(); err != nil {
+		return nil, err
+	}
+	if err := server.lichess.Validate(); err != nil {
+		return nil, err
+	}
+	if server.blocklist == nil {
+		// Without a file to keep it in, the blocklist can't fail to open.
+		server.blocklist, _ = OpenBlocklist(DefaultBlocklistConfig())
+	}
+	if server.results == nil && server.store != nil {
+		server.results = server.store
+	}
+	if server.pool == nil {
+		// Playing for a single account, there's nobody to share with.
+		server.pool = NewPool(server.maxConcurrentGames)
+	}
+	server.rateLimit = server.pool.rateLimit
+	server.gameSemaphore = semaphore.NewWeighted(int64(server.maxConcurrentGames))
+
+	clientOptions := []blitz.ClientOption{blitz.WithHTTPClient(rateLimitedHTTPClient(server.rateLimit))}
+	if urls := server.lichess.baseURLs(); len(urls) > 0 {
+		clientOptions = append(clientOptions, blitz.WithBaseURLs(urls...))
+	}
+	server.client = blitz.New(token, clientOptions...)
+	user, err := server.client.Account.GetProfile(context.Background())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read lichess profile")
 	}
@@ -38,23 +171,77 @@ func NewServer(token string) (*Server, error) {
 		log.WithField("username", user.Username).Warningln("user is not a BOT")
 		return nil, errors.New("specified user is not a bot")
 	}
-
-	return &Server{
-		client:        client,
-		challenges:    make(chan blitz.Challenge, maxPendingChallenges),
-		gameSemaphore: semaphore.NewWeighted(maxConcurrentGames),
-	}, nil
+	server.user = user
+	return server, nil
 }
 
-func (s *Server) Run() error {
-	ctx := context.Background()
+// Run connects to the lichess event stream and serves challenges and games until ctx ends. If the stream drops, Run
+// reconnects with exponential backoff; it only returns an error if the very first connection fails, since that almost
+// always means that the server is misconfigured. Once ctx ends, which also ends any games still in progress, Run waits
+// for them to wrap up and shuts down the engines kept between games before returning.
+func (s *Server) Run(ctx context.Context) error {
 	events, err := s.client.Challenges.StreamEvents(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to read lichess event stream")
 	}
 
 	go s.challengeLoop()
-	log.Infoln("server waiting for incoming events")
+	go s.client.ProbeEndpoints(ctx, s.lichess.ProbeInterval)
+	if s.matchmaking != nil {
+		go s.matchmakingLoop(ctx)
+	}
+	if s.results != nil {
+		go s.reportLoop(ctx)
+	}
+
+	backoff := minReconnectBackoff
+	for {
+		connectedAt := time.Now()
+		log.Infoln("server waiting for incoming events")
+		s.serveEvents(ctx, events)
+		if ctx.Err() != nil {
+			s.stop()
+			return nil
+		}
+
+		// A stream that stayed up for a while was healthy, so start backing off from scratch.
+		if time.Since(connectedAt) > maxReconnectBackoff {
+			backoff = minReconnectBackoff
+		}
+
+		for {
+			log.WithField("backoff", backoff).Warn("event stream has ended, reconnecting")
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				s.stop()
+				return nil
+			}
+
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+
+			events, err = s.client.Challenges.StreamEvents(ctx)
+			if err == nil {
+				streamReconnects.Inc()
+				log.Info("reconnected to lichess event stream")
+				break
+			}
+			log.WithError(err).Warn("failed to reconnect to lichess event stream")
+		}
+	}
+}
+
+// stop waits for the games in progress to wrap up, then shuts down the engines kept between games.
+func (s *Server) stop() {
+	log.Info("waiting for games in progress")
+	s.gamesWaitGroup.Wait()
+	s.closeEnginePools()
+}
+
+func (s *Server) serveEvents(ctx context.Context, events <-chan blitz.ChallengeEvent) {
 	for event := range events {
 		switch e := event.(type) {
 		case blitz.Challenge:
@@ -63,45 +250,150 @@ func (s *Server) Run() error {
 			}
 		case blitz.GameStart:
 			s.HandleGameStart(ctx, e)
+		case blitz.GameFinish:
+			s.HandleGameFinish(e)
 		}
 	}
+}
 
-	return nil
+// activeGames returns the number of games currently being played.
+func (s *Server) activeGames() int {
+	s.gamesLock.Lock()
+	defer s.gamesLock.Unlock()
+	return len(s.games)
+}
+
+// idleTime returns how long the server has gone without playing any games, or zero if games are in progress.
+func (s *Server) idleTime() time.Duration {
+	s.gamesLock.Lock()
+	defer s.gamesLock.Unlock()
+	if len(s.games) > 0 {
+		return 0
+	}
+	return time.Since(s.lastGameEnded)
 }
 
 func (s *Server) HandleChallenge(ctx context.Context, challenge blitz.Challenge) error {
 	log.WithFields(log.Fields{
-		"challenger": challenge.Challenger.Name,
-		"rating":     challenge.Challenger.Rating,
-		"game":       challenge.Variant,
-		"id":         challenge.ID,
+		"opponent": challenge.Challenger.Name,
+		"rating":   challenge.Challenger.Rating,
+		"variant":  challenge.Variant,
+		"game_id":  challenge.ID,
 	}).Infoln("received challenge")
 
 	select {
 	case s.challenges <- challenge:
-		log.WithField("id", challenge.ID).
+		log.WithField("game_id", challenge.ID).
 			Infoln("enqueued challenge")
 	default:
-		log.WithField("id", challenge.ID).
+		log.WithField("game_id", challenge.ID).
 			Infoln("too many pending challenges, declining challenge")
-		return s.client.Challenges.DeclineChallenge(ctx, challenge.ID)
+		challengesHandled.Inc("declined", "queue_full")
+		s.storeChallenge(ctx, challenge, "declined", "queue_full")
+		return s.client.Challenges.DeclineChallenge(ctx, challenge.ID, blitz.DeclineLater)
 	}
 	return nil
 }
 
 func (s *Server) challengeLoop() {
+	if s.crashes != nil {
+		defer s.crashes.Recover(nil)
+	}
 	ctx := context.Background()
 	log.Info("challenge loop starting")
 	for challenge := range s.challenges {
-		if !apolloPlaysVariant(challenge.Variant) {
-			log.WithField("variant", challenge.Variant.Key).Info("declining challenge, apollo does not play this variant")
-			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID); err != nil {
+		// Hold on to challenges while lichess is rate limiting us, rather than making things worse.
+		if left := s.rateLimit.remaining(); left > 0 {
+			log.WithFields(log.Fields{
+				"game_id":  challenge.ID,
+				"cooldown": left,
+			}).Info("rate limited, delaying challenge")
+			s.rateLimit.wait(ctx)
+		}
+
+		if s.isPaused() {
+			log.WithField("game_id", challenge.ID).Info("declining challenge, server is paused")
+			challengesHandled.Inc("declined", "paused")
+			s.storeChallenge(ctx, challenge, "declined", "paused")
+			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID, blitz.DeclineLater); err != nil {
+				log.WithError(err).Info("failed to decline challenge")
+			}
+			continue
+		}
+
+		if !s.isAvailable() {
+			log.WithField("game_id", challenge.ID).Info("declining challenge, outside availability windows")
+			challengesHandled.Inc("declined", "unavailable")
+			s.storeChallenge(ctx, challenge, "declined", "unavailable")
+			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID, blitz.DeclineLater); err != nil {
+				log.WithError(err).Info("failed to decline challenge")
+			}
+			continue
+		}
+
+		if s.blocklist.Blocked(challenge.Challenger.ID) {
+			log.WithFields(log.Fields{
+				"game_id":  challenge.ID,
+				"opponent": challenge.Challenger.Name,
+			}).Info("declining challenge, challenger is blocked")
+			challengesHandled.Inc("declined", "blocked")
+			s.storeChallenge(ctx, challenge, "declined", "blocked")
+			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID, blitz.DeclineGeneric); err != nil {
+				log.WithError(err).Info("failed to decline challenge")
+			}
+			continue
+		}
+
+		policy := s.challengePolicy()
+		if ok, reason := policy.evaluate(challenge); !ok {
+			log.WithFields(log.Fields{
+				"game_id": challenge.ID,
+				"reason":  reason,
+			}).Info("declining challenge, challenge policy does not allow it")
+			challengesHandled.Inc("declined", string(reason))
+			s.storeChallenge(ctx, challenge, "declined", string(reason))
+			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID, reason); err != nil {
+				log.WithError(err).Info("failed to decline challenge")
+			}
+			continue
+		}
+
+		if limit := policy.MaxGamesPerOpponent; limit > 0 && s.gamesToday(ctx, challenge.Challenger.ID) >= limit {
+			log.WithFields(log.Fields{
+				"game_id":  challenge.ID,
+				"opponent": challenge.Challenger.Name,
+			}).Info("declining challenge, already played the most games allowed against this opponent today")
+			challengesHandled.Inc("declined", "opponent_limit")
+			s.storeChallenge(ctx, challenge, "declined", "opponent_limit")
+			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID, blitz.DeclineLater); err != nil {
 				log.WithError(err).Info("failed to decline challenge")
 			}
 			continue
 		}
 
-		log.WithField("id", challenge.ID).Info("accepting challenge")
+		if !s.historyAcceptable(ctx, challenge.Challenger) {
+			log.WithField("game_id", challenge.ID).Info("declining challenge, challenger often aborts or abandons games")
+			challengesHandled.Inc("declined", "history")
+			s.storeChallenge(ctx, challenge, "declined", "history")
+			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID, blitz.DeclineGeneric); err != nil {
+				log.WithError(err).Info("failed to decline challenge")
+			}
+			continue
+		}
+
+		if s.activeGames() >= s.maxConcurrentGames || s.pool.full() {
+			log.WithField("game_id", challenge.ID).Info("declining challenge, already playing the maximum number of games")
+			challengesHandled.Inc("declined", "capacity")
+			s.storeChallenge(ctx, challenge, "declined", "capacity")
+			if err := s.client.Challenges.DeclineChallenge(ctx, challenge.ID, blitz.DeclineLater); err != nil {
+				log.WithError(err).Info("failed to decline challenge")
+			}
+			continue
+		}
+
+		log.WithField("game_id", challenge.ID).Info("accepting challenge")
+		challengesHandled.Inc("accepted", "none")
+		s.storeChallenge(ctx, challenge, "accepted", "")
 		if err := s.client.Challenges.AcceptChallenge(ctx, challenge.ID); err != nil {
 			log.WithError(err).Info("failed to accept challenge")
 			continue
@@ -109,160 +401,551 @@ func (s *Server) challengeLoop() {
 	}
 }
 
+// HandleGameStart begins playing a game in its own goroutine, returning immediately. Each game holds a slot in the
+// game semaphore and an engine from the pool for as long as it runs; if either has run out, the game is aborted.
 func (s *Server) HandleGameStart(ctx context.Context, gameStart blitz.GameStart) {
-	// defer s.gameSemaphore.Release(1)
-	log.WithField("id", gameStart.ID).Info("beginning game")
-	if err := s.playGame(ctx, gameStart); err != nil {
-		log.WithError(err).Error("fatal error while playing game")
+	if !s.gameSemaphore.TryAcquire(1) {
+		log.WithField("game_id", gameStart.ID).Warn("too many concurrent games, aborting game")
 		if err := s.client.Bot.AbortGame(ctx, gameStart.ID); err != nil {
 			log.WithError(err).Info("failed to abort game")
-			if err := s.client.Bot.ResignGame(ctx, gameStart.ID); err != nil {
-				log.WithError(err).Error("failed to resign game")
-			}
 		}
+		return
+	}
+	if !s.pool.tryAcquire() {
+		s.gameSemaphore.Release(1)
+		log.WithField("game_id", gameStart.ID).Warn("no engines available, aborting game")
+		if err := s.client.Bot.AbortGame(ctx, gameStart.ID); err != nil {
+			log.WithError(err).Info("failed to abort game")
+		}
+		return
 	}
-}
 
-func (s *Server) playGame(ctx context.Context, gameStart blitz.GameStart) error {
-	// Lichess directs us to switch APIs as soon as we get GameStart. We'll now start streaming
-	// events for that particular game.
-	//
-	// First, though, we need to fire up Apollo.
-	client, err := loadAndInitializeApollo()
-	if err != nil {
-		return err
+	gameCtx, cancel := context.WithCancel(ctx)
+	s.gamesLock.Lock()
+	if _, ok := s.games[gameStart.ID]; ok {
+		// Lichess can tell us about a game more than once; only play it once.
+		s.gamesLock.Unlock()
+		cancel()
+		s.pool.release()
+		s.gameSemaphore.Release(1)
+		return
 	}
+	s.games[gameStart.ID] = &liveGame{cancel: cancel, status: GameStatus{ID: gameStart.ID}}
+	s.gamesLock.Unlock()
+
+	s.gamesWaitGroup.Add(1)
+	gamesStarted.Inc()
+	gamesActive.Inc()
+	go func() {
+		defer s.gamesWaitGroup.Done()
+		defer gamesActive.Dec()
+		defer s.gameSemaphore.Release(1)
+		defer s.pool.release()
+		defer func() {
+			s.gamesLock.Lock()
+			delete(s.games, gameStart.ID)
+			s.lastGameEnded = time.Now()
+			s.gamesLock.Unlock()
+			cancel()
+		}()
+
+		s.runGame(gameCtx, gameStart)
+	}()
+}
 
-	// Next, we need to do tell Apollo to start a new game.
-	if err := client.UCINewGame(); err != nil {
-		return err
+func (s *Server) runGame(ctx context.Context, gameStart blitz.GameStart) {
+	record := &gameRecord{id: gameStart.ID, log: s.openGameLog(gameStart.ID), uciTail: newTailWriter(crashTranscriptLines)}
+	defer record.log.Close()
+	defer s.wrapUpGame(record)
+	if s.crashes != nil {
+		defer s.crashes.Recover(record.describeCrash)
 	}
+	record.logger().Info("beginning game")
 
-	// Be friendly?
-	if err := s.client.Bot.WriteChat(ctx, gameStart.ID, "player", "Good Luck, Have Fun! Check me out on GitHub at https://github.com/swgillespie/apollo"); err != nil {
-		log.WithError(err).Warning("failed to send friendly chat message")
+	if err := s.playGame(ctx, gameStart, record); err != nil {
+		if ctx.Err() != nil {
+			// We stopped waiting on a game that lichess told us was over; there's nothing left to abort.
+			record.logger().WithError(err).Info("game canceled while playing")
+			return
+		}
+		record.logger().WithError(err).Error("fatal error while playing game")
+		record.abortedByUs = true
+		if err := s.client.Bot.AbortGame(ctx, gameStart.ID); err != nil {
+			record.logger().WithError(err).Info("failed to abort game")
+			if err := s.client.Bot.ResignGame(ctx, gameStart.ID); err != nil {
+				record.logger().WithError(err).Error("failed to resign game")
+			}
+		}
 	}
+}
+
+func (s *Server) playGame(ctx context.Context, gameStart blitz.GameStart, record *gameRecord) (err error) {
+	// Lichess directs us to switch APIs as soon as we get GameStart. We'll now start streaming
+	// events for that particular game. We'll fire up Apollo once the first event tells us what sort of game this is.
+	var client engine.Engine
+	var profile EngineProfile
+	var variant blitz.Variant
+	defer func() {
+		if client != nil {
+			// An engine kept for another game mustn't be left in whatever state a game that went wrong left it in.
+			s.releaseEngine(profile, variant, client, err == nil)
+		}
+	}()
 
-	// Lichess is going to stream us events for this game. Get the stream and iterate over it.
-	stream, err := s.client.Bot.StreamGameEvents(ctx, gameStart.ID)
+	// Lichess is going to stream us events for this game. Get the stream and iterate over it. As soon as the stream
+	// shows that the game is over, however it ended, any search in progress is abandoned.
+	searchCtx, gameOver := context.WithCancel(ctx)
+	defer gameOver()
+	stream, err := s.streamGame(ctx, record, gameOver)
 	if err != nil {
 		return err
 	}
 
-	// Keep track of who's turn it is. Lichess will slap us if we play out of turn and it's our
-	// job to figure out when to play.
-	ourTurn := false
-
-	// Lichess also sends us a GameState event for our own moves, so we need to skip those too.
-	nextIsOurOwnMove := false
-
+	// Whose turn it is follows entirely from our color and the number of moves played so far. Lichess sends us a
+	// GameState for every move, including our own, and sometimes more than one for the same position, so we also
+	// remember how far into the game we've already moved in order to never play the same turn twice.
+	// Games that notnil/chess can't replay, because of chess960 castling or a variant's own rules, are left entirely
+	// to the engine: no book, tablebase, or preparation, and no judging positions for draws.
+	isWhite, replayable, delayMoves := false, false, false
+	position, startsWithWhite := "", true
+	initialFen := ""
+	var board *localBoard
+	playedPly := -1
+	var draws *drawTracker
+	var resigns *resignTracker
+	latency := newLatencyTracker(s.moveOverhead)
+	chatConfig := s.chatConfig()
+	chat := newChatResponder(chatConfig.CommandInterval)
+	declinedTakebacks := make(map[int]bool)
+	commentary := newCommentator(chatConfig)
+	var clock *timeManager
+	claimer := newVictoryClaimer(func() {
+		s.claimVictory(ctx, gameStart.ID)
+		s.reportOffense(ctx, record, "abandoned")
+	})
+	defer claimer.stop()
 	for event := range stream {
-		var bestmove string
+		if s.crashes != nil {
+			record.noteEvent(event)
+		}
+		var state blitz.GameState
 		switch e := event.(type) {
 		case blitz.GameFull:
-			log.Info("received GameFull event")
-			ourTurn = apolloIsWhite(e)
-			log.WithField("isWhite", strconv.FormatBool(ourTurn)).Info("determining which side apollo play on")
-			log.WithField("moves", e.State.Moves).Debug("incoming moves")
-
-			// Sometimes, if our opponent is VERY fast, the first GameFull contains the first move
-			// that our opponent did. If it's not our turn (i.e. we're black), it may be the case
-			// that our opponent has already played a move.
-			if e.State.Moves != "" {
-				log.Info("our opponent was super fast and played a move already, it is our turn despite being black")
-				ourTurn = !ourTurn
+			record.logger().Info("received GameFull event")
+			variant = e.Variant
+			replayable = !isChess960(variant) && !hasOwnRules(variant)
+			clock = newTimeManager(s.timeManager, e.Speed)
+			if client == nil {
+				// The game sticks with this engine to the end, even if a new one is swapped in meanwhile.
+				profile = s.profileFor(e.Speed, variant.Key)
+				if !s.routesVariant(variant.Key) {
+					// The canary is a build of our own engine, which doesn't play the variants routed elsewhere.
+					profile, record.build = s.pickBuild(profile)
+				}
+				record.enginePath = profile.Path
+				if record.build != "" {
+					record.logger().WithField("build", record.build).Info("canary is running, playing game with build")
+				}
+				if client, err = s.acquireEngine(profile, variant, record.transcript()); err != nil {
+					return err
+				}
 			}
-
-			if !ourTurn {
-				log.Info("skipping state and not playing, not our turn")
-				ourTurn = !ourTurn
-				continue
+			if isWhite, err = apolloIsWhite(s.user, e); err != nil {
+				return err
 			}
-
-			nextIsOurOwnMove = true
-			move, err := engineEvaluate(client, e.State)
+			// A reconnected stream starts over with another GameFull for the same game.
+			resynced := record.hasFull
+			record.full, record.hasFull, record.isWhite = e, true, isWhite
+			delayMoves = s.moveDelay.appliesTo(e, isWhite)
+			if !resynced {
+				record.etiquette = s.etiquetteFor(e.Speed)
+				draws, resigns = newDrawTracker(record.etiquette), newResignTracker(record.etiquette)
+				// Be friendly?
+				if record.etiquette.Chat != ChatQuiet {
+					if err := s.writeChat(ctx, gameStart.ID, "player", "Good Luck, Have Fun! Check me out on GitHub at https://github.com/swgillespie/apollo"); err != nil {
+						record.logger().WithError(err).Warning("failed to send friendly chat message")
+					}
+				}
+				record.identify()
+				s.broadcastStart(record)
+				if opponent := record.opponent(); opponent.ID != "" {
+					s.opponents.played(opponent.ID, time.Now())
+				}
+				s.storeGameStart(ctx, record)
+				if replayable {
+					record.prep = s.prepare(ctx, record)
+				}
+			}
+			record.logger().WithField("isWhite", strconv.FormatBool(isWhite)).Info("determining which side apollo play on")
+			initialFen = e.InitialFen
+			position, startsWithWhite, err = startingPosition(e.InitialFen)
 			if err != nil {
 				return err
 			}
-			bestmove = move
+			record.logger().WithField("position", position).Info("determining starting position")
+			board = newLocalBoard(initialFen, replayable)
+			state = e.State
 		case blitz.GameState:
-			log.Info("received GameState event")
-			if nextIsOurOwnMove {
-				log.Info("skipping state and not playing, this is our own move")
-				nextIsOurOwnMove = !nextIsOurOwnMove
-				continue
+			record.logger().Info("received GameState event")
+			if client == nil {
+				return errors.New("received game state before the full game")
 			}
+			state = e
+		case blitz.ChatLine:
+			s.handleChat(ctx, gameStart.ID, chat, e, lastInfo(client))
+			continue
+		case blitz.OpponentGone:
+			record.logger().WithFields(log.Fields{
+				"gone":              e.Gone,
+				"claimWinInSeconds": e.ClaimWinInSeconds,
+			}).Info("opponent connectivity changed")
+			claimer.observe(e)
+			continue
+		default:
+			continue
+		}
 
-			if !ourTurn {
-				log.Info("skipping state and not playing, not our turn")
-				ourTurn = !ourTurn
-				continue
+		record.lastState = state
+		s.publishStatus(record, lastInfo(client))
+		s.broadcastMoves(record, lastInfo(client))
+		s.storeMoves(ctx, record)
+		if isFinished(state.Status) {
+			record.logger().WithField("status", state.Status).Info("game has finished")
+			break
+		}
+
+		moves := splitMoves(state.Moves)
+		record.logger().WithField("moves", state.Moves).Debug("incoming moves")
+		board.sync(moves)
+		if draws.needsAnswer(isWhite, state, len(moves)) {
+			game, err := replayGame(initialFen, moves)
+			accept := replayable && err == nil && draws.shouldAccept(game, len(moves))
+			record.logger().WithField("accept", strconv.FormatBool(accept)).Info("responding to draw offer")
+			if err := s.client.Bot.HandleDrawOffer(ctx, gameStart.ID, accept); err != nil {
+				record.logger().WithError(err).Warn("failed to respond to draw offer")
 			}
+		}
 
-			nextIsOurOwnMove = true
-			move, err := engineEvaluate(client, e)
+		// We never accept takebacks, but we answer the offer so that it doesn't sit pending forever.
+		takebackOffered := state.Wtakeback
+		if isWhite {
+			takebackOffered = state.Btakeback
+		}
+		if takebackOffered && !declinedTakebacks[len(moves)] {
+			declinedTakebacks[len(moves)] = true
+			s.declineTakeback(ctx, record)
+		}
+
+		if !isOurTurn(isWhite, startsWithWhite, moves) {
+			record.logger().Info("skipping state and not playing, not our turn")
+			continue
+		}
+		if len(moves) <= playedPly {
+			record.logger().Info("skipping state and not playing, already moved in this position")
+			continue
+		}
+		if searchCtx.Err() != nil {
+			record.logger().Info("skipping state and not playing, game is over")
+			continue
+		}
+		turnStart := time.Now()
+
+		// Book and tablebase moves are played instantly, saving our clock for when we need the engine. Both need
+		// to replay the game, so unreplayable games are left to the engine.
+		bestmove, inBook, inTablebase, engineHung := "", false, false, false
+		if replayable {
+			bestmove, inBook = s.bookMove(record.full.Speed, initialFen, moves, record.prep)
+		}
+		if replayable && !inBook {
+			bestmove, inTablebase = s.tablebaseMove(ctx, initialFen, moves, clockRemaining(state, isWhite))
+		}
+		switch {
+		case inBook:
+			bookMoves.Inc()
+			clock.playedInstantly(true)
+			record.logger().WithField("move", bestmove).Info("playing book move")
+		case inTablebase:
+			clock.playedInstantly(false)
+			record.logger().WithField("move", bestmove).Info("playing tablebase move")
+			s.comment(ctx, record, commentary.enteredTablebase())
+		default:
+			if err := engineHealth(client); err != nil {
+				// Replacing the engine now costs a handshake, which is less than a search it would never finish.
+				unhealthyEngines.Inc()
+				record.logger().WithError(err).Warn("engine stopped answering while idle, restarting it")
+				killEngine(client)
+				s.dropEngine(profile, variant, client)
+				client = nil
+				if client, err = s.acquireEngine(profile, variant, record.transcript()); err != nil {
+					return err
+				}
+			}
+			compensated := latency.compensate(state, isWhite)
+			searchStart := time.Now()
+			for restarts := 0; ; restarts++ {
+				moveTime := clock.budget(clockRemaining(compensated, isWhite), clockIncrement(compensated, isWhite))
+				if moveTime > 0 {
+					record.logger().WithField("moveTime", moveTime).Debug("budgeted time for move")
+				}
+				limits := s.clocks.limits(record.full.Speed, compensated, isWhite, moveTime)
+				attemptStart := time.Now()
+				bestmove, engineHung, err = watchedEvaluate(searchCtx, client, board, position, compensated, limits)
+				if engineHung {
+					// The engine has been killed, so don't shut it down again if we bail out.
+					s.dropEngine(profile, variant, client)
+					client = nil
+				}
+				if !engine.IsCrash(err) || searchCtx.Err() != nil {
+					break
+				}
+				// The engine is gone, so reap it rather than asking it to quit.
+				killEngine(client)
+				s.dropEngine(profile, variant, client)
+				client = nil
+				if restarts >= profile.Restarts {
+					engineCrashes.Inc("lost")
+					break
+				}
+				// A new engine is sent the position when it's asked to search, and searches with what's left of our
+				// clock.
+				engineCrashes.Inc("restarted")
+				record.logger().WithError(err).Warn("engine crashed, restarting it")
+				if client, err = s.acquireEngine(profile, variant, record.transcript()); err != nil {
+					return err
+				}
+				compensated = spendClock(compensated, isWhite, time.Since(attemptStart))
+			}
+			if engine.IsNoMove(err) {
+				// Lichess ends games as soon as nobody can move, so the engine is mistaken, or the game's final state is
+				// still on its way. Any legal move beats leaving our clock running.
+				if bestmove, err = board.fallbackMove(); err != nil {
+					record.logger().WithError(err).Warn("engine has no move and there is no fallback, waiting for the game to end")
+					playedPly = len(moves)
+					continue
+				}
+				record.logger().WithField("move", bestmove).Warn("engine has no move in a position that has one, playing fallback move")
+			}
+			if illegal, ok := engine.AsIllegalMove(err); ok {
+				illegalMoves.Inc()
+				record.logger().WithError(err).WithFields(log.Fields{
+					"move":   illegal.Move,
+					"output": illegal.Output,
+				}).Error("engine played an illegal move, playing fallback move")
+				if bestmove, err = board.fallbackMove(); err != nil {
+					return err
+				}
+			}
+			if err != nil && searchCtx.Err() != nil && ctx.Err() == nil {
+				// The game's final state is waiting for us on the stream.
+				record.logger().Info("game ended while the engine was searching, search abandoned")
+				continue
+			}
 			if err != nil {
 				return err
 			}
-			bestmove = move
-		case blitz.ChatLine:
-			// Ignore, don't care.
+			engineThinkTime.ObserveSince(searchStart)
+			if !engineHung {
+				info := client.Info()
+				record.recordSearch(len(moves), bestmove, info, time.Since(searchStart))
+				s.observeSearch(record.full.Speed, info)
+				draws.observe(info.Score, info.HasScore)
+				resigns.observe(info.Score, info.HasScore)
+				clock.observe(info.Score, info.HasScore)
+				s.comment(ctx, record, commentary.afterSearch(info))
+			}
+		}
+
+		if resigns.shouldResign() {
+			record.logger().WithField("losingMoves", resigns.losingMoves).Info("resigning lost game")
+			if err := s.client.Bot.ResignGame(ctx, gameStart.ID); err != nil {
+				return err
+			}
+			// The game's final state is on its way down the stream.
+			playedPly = len(moves)
 			continue
 		}
 
-		log.WithField("move", bestmove).Info("sending move to lichess")
-		if err := s.client.Bot.MakeMove(ctx, gameStart.ID, bestmove, false); err != nil {
+		if !board.legal(bestmove) {
+			illegalMoves.Inc()
+			record.logger().WithField("move", bestmove).Error("refusing to play illegal move")
+			if bestmove, err = board.fallbackMove(); err != nil {
+				return err
+			}
+		}
+
+		offerDraw := false
+		if replayable {
+			if game, err := replayGame(initialFen, append(moves, bestmove)); err == nil {
+				offerDraw = draws.shouldOffer(game, len(moves))
+			}
+		}
+
+		if delayMoves {
+			s.delayMove(ctx, turnStart, clockRemaining(state, isWhite))
+		}
+
+		record.logger().WithFields(log.Fields{
+			"move":      bestmove,
+			"offerDraw": offerDraw,
+		}).Info("sending move to lichess")
+		sentAt := time.Now()
+		if err := s.client.Bot.MakeMove(ctx, gameStart.ID, bestmove, offerDraw); err != nil {
 			return err
 		}
+		latency.observe(time.Since(sentAt))
+		moveLatency.ObserveSince(sentAt)
+		record.logger().WithFields(log.Fields{
+			"rtt":      time.Since(sentAt),
+			"overhead": latency.overhead(),
+		}).Debug("measured move latency")
+		playedPly = len(moves)
+		if engineHung {
+			// Replace the engine we gave up on while our opponent thinks.
+			record.logger().Warn("restarting unresponsive engine")
+			if client, err = s.acquireEngine(profile, variant, record.transcript()); err != nil {
+				return err
+			}
+		}
+		s.publishStatus(record, client.Info())
 	}
 
-	log.Info("stream has ended, completing game")
 	return nil
 }
 
-func engineEvaluate(client *uci.Client, state blitz.GameState) (string, error) {
-	moves := strings.Split(state.Moves, " ")
-	if err := client.Position("startpos", moves); err != nil {
-		return "", err
+// spendClock takes elapsed off our clock in a game state. A clock that would run out is left with a moment, since an
+// empty clock means an untimed game.
+func spendClock(state blitz.GameState, isWhite bool, elapsed time.Duration) blitz.GameState {
+	remaining := &state.Btime
+	if isWhite {
+		remaining = &state.Wtime
 	}
+	if *remaining > 0 {
+		*remaining -= int(elapsed / time.Millisecond)
+		if *remaining < 1 {
+			*remaining = 1
+		}
+	}
+	return state
+}
 
-	bestmove, err := client.Go(state.Wtime, state.Btime, state.Winc, state.Binc)
-	if err != nil {
+// splitMoves splits lichess's space-separated move list into individual UCI moves.
+func splitMoves(moves string) []string {
+	return strings.Fields(moves)
+}
+
+// isOurTurn returns true if it is our turn to move after the given moves have been played from a starting position
+// with the given side to move.
+func isOurTurn(isWhite, startsWithWhite bool, moves []string) bool {
+	whiteToMove := (len(moves)%2 == 0) == startsWithWhite
+	return whiteToMove == isWhite
+}
+
+// startingPosition translates lichess's initialFen into the FEN to give the engine, which is empty for the standard
+// position, additionally returning whether or not white moves first from that position. Lichess uses "startpos" (or
+// nothing at all) for the standard position.
+func startingPosition(initialFen string) (string, bool, error) {
+	if initialFen == "" || initialFen == "startpos" {
+		return "", true, nil
+	}
+
+	if err := engine.ValidateFEN(initialFen); err != nil {
+		return "", false, err
+	}
+	return initialFen, strings.Fields(initialFen)[1] == "w", nil
+}
+
+// engineEvaluate asks the engine for its move in the game's current position, within the given search limits.
+func engineEvaluate(client engine.Engine, position string, state blitz.GameState, limits searchLimits) (string, error) {
+	moves := splitMoves(state.Moves)
+	if err := client.SetPosition(position, moves); err != nil {
 		return "", err
 	}
-	return bestmove, nil
+
+	if limits.moveTime > 0 {
+		return client.Search(engine.Limits{MoveTime: limits.moveTime})
+	}
+	return client.Search(engine.Limits{
+		WhiteTime:      time.Duration(limits.wtime) * time.Millisecond,
+		BlackTime:      time.Duration(limits.btime) * time.Millisecond,
+		WhiteIncrement: time.Duration(limits.winc) * time.Millisecond,
+		BlackIncrement: time.Duration(limits.binc) * time.Millisecond,
+		MovesToGo:      limits.movesToGo,
+	})
 }
 
-func loadAndInitializeApollo() (*uci.Client, error) {
+func loadAndInitializeApollo(profile EngineProfile, transcript io.Writer) (engine.Engine, error) {
 	// Loading up Apollo entails launching apollo as a subprocess, hooking up our stdin and
-	// stdout accordingly, and then performing the base UCI handshake.
+	// stdout accordingly, and then performing the protocol's handshake.
 	//
-	// If there's an apollo on the path, use that, otherwise use an adjacent apollo.
-	apolloFromPath, err := exec.LookPath("apollo")
-	if err != nil {
-		apolloFromPath = "./apollo"
+	// Unless we've been told otherwise, if there's an apollo on the path, use that, otherwise use an adjacent apollo.
+	enginePath := profile.Path
+	if enginePath == "" {
+		apolloFromPath, err := exec.LookPath("apollo")
+		if err != nil {
+			apolloFromPath = "./apollo"
+		}
+		enginePath = apolloFromPath
 	}
 
-	transport, err := uci.NewProgramTransport(apolloFromPath)
-	if err != nil {
-		return nil, err
+	return engine.Launch(enginePath, profile.Protocol, transcript, profile.Program())
+}
+
+// shutdownApollo asks Apollo to exit and waits for the process to do so, so that concurrent games don't leave engine
+// processes behind once they finish.
+func shutdownApollo(client engine.Engine) {
+	if err := client.Quit(); err != nil {
+		log.WithError(err).Warn("failed to send quit to apollo")
+	}
+	if err := client.Close(); err != nil {
+		log.WithError(err).Warn("apollo did not exit cleanly")
 	}
+}
 
-	return uci.NewClient(transport)
+// apolloIsWhite returns true if the given account is the white player in this game and false if it is the black
+// player. It's an error for the account to be neither.
+func apolloIsWhite(user *blitz.AccountResponse, fullGame blitz.GameFull) (bool, error) {
+	switch {
+	case isPlayer(user, fullGame.White):
+		return true, nil
+	case isPlayer(user, fullGame.Black):
+		return false, nil
+	default:
+		return false, errors.Errorf("%s is not playing in game %s", user.ID, fullGame.ID)
+	}
 }
 
-// apolloIsWhite returns true if Apollo is the white player in this game, false otherwise.
-func apolloIsWhite(fullGame blitz.GameFull) bool {
-	return fullGame.White.ID == "apollo_bot"
+// isPlayer returns true if the player is the given account. Lichess IDs are lowercased usernames, so compare loosely
+// in case we only know one of them.
+func isPlayer(user *blitz.AccountResponse, player blitz.GamePlayer) bool {
+	if player.ID == "" {
+		// Anonymous players and the AI have no ID.
+		return false
+	}
+	return strings.EqualFold(player.ID, user.ID) || strings.EqualFold(player.ID, user.Username)
 }
 
 // apolloPlaysVariant returns true if Apollo can play the requested chess variant. Lichess supports a bunch of variants
-// that Apollo doesn't know how to play.
+// that Apollo doesn't know how to play. Games from a custom position are ordinary chess from a non-standard starting
+// position, which Apollo is happy to play. Chess960 needs an engine that supports UCI_Chess960, so it is only played
+// if the challenge policy opts in to it.
 func apolloPlaysVariant(variant blitz.Variant) bool {
 	switch variant.Key {
-	case "standard", "ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence":
+	case "standard", "fromPosition", "chess960", "ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence":
 		return true
 	default:
 		return false
 	}
 }
+
+// hasOwnRules returns true for variants whose rules differ from chess's in more than the starting position, such as
+// crazyhouse or atomic, which only an engine of their own can play.
+func hasOwnRules(variant blitz.Variant) bool {
+	return !apolloPlaysVariant(variant)
+}
+
+// isChess960 returns true if the game is Fischer random chess. Lichess sends and expects castling in these games as
+// the king capturing its own rook (e.g. e1h1), which is also how engines in UCI_Chess960 mode encode it, so moves
+// pass between the two untranslated.
+func isChess960(variant blitz.Variant) bool {
+	return variant.Key == "chess960"
+}
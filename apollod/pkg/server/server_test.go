@@ -0,0 +1,527 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+func TestIsOurTurn(t *testing.T) {
+	assert.True(t, isOurTurn(true, true, splitMoves("")))
+	assert.False(t, isOurTurn(false, true, splitMoves("")))
+	assert.False(t, isOurTurn(true, true, splitMoves("e2e4")))
+	assert.True(t, isOurTurn(false, true, splitMoves("e2e4")))
+	assert.True(t, isOurTurn(true, true, splitMoves("e2e4 e7e5")))
+}
+
+func TestIsOurTurnBlackToMove(t *testing.T) {
+	assert.True(t, isOurTurn(false, false, splitMoves("")))
+	assert.True(t, isOurTurn(true, false, splitMoves("e7e5")))
+}
+
+func TestStartingPosition(t *testing.T) {
+	position, white, err := startingPosition("startpos")
+	assert.NoError(t, err)
+	assert.Empty(t, position)
+	assert.True(t, white)
+
+	fen := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"
+	position, white, err = startingPosition(fen)
+	assert.NoError(t, err)
+	assert.Equal(t, fen, position)
+	assert.False(t, white)
+
+	_, _, err = startingPosition("garbage")
+	assert.Error(t, err)
+}
+
+func TestSpeedOf(t *testing.T) {
+	assert.Equal(t, "bullet", speedOf(60, 0))
+	assert.Equal(t, "blitz", speedOf(180, 2))
+	assert.Equal(t, "rapid", speedOf(600, 5))
+	assert.Equal(t, "classical", speedOf(1800, 0))
+}
+
+func TestDrawAcceptance(t *testing.T) {
+	draws := newDrawTracker(BuiltinEtiquettes[DefaultEtiquetteProfile])
+	draws.observe(engine.Score{Centipawns: 5}, true)
+
+	quiet, err := replayGame("", splitMoves("e2e4 e7e5 g1f3"))
+	assert.NoError(t, err)
+	assert.True(t, draws.shouldAccept(quiet, 3))
+
+	capture, err := replayGame("", splitMoves("e2e4 d7d5 e4d5"))
+	assert.NoError(t, err)
+	assert.False(t, draws.shouldAccept(capture, 3))
+
+	draws.observe(engine.Score{Centipawns: 150}, true)
+	assert.False(t, draws.shouldAccept(quiet, 3))
+}
+
+func TestDrawOffer(t *testing.T) {
+	draws := newDrawTracker(BuiltinEtiquettes[DefaultEtiquetteProfile])
+	endgame, err := replayGame("8/8/4k3/8/8/4K3/4P3/8 w - - 0 60", nil)
+	assert.NoError(t, err)
+
+	for i := 0; i < drawOfferAfter-1; i++ {
+		draws.observe(engine.Score{Centipawns: 0}, true)
+	}
+	assert.False(t, draws.shouldOffer(endgame, 100))
+	draws.observe(engine.Score{Centipawns: 0}, true)
+	assert.True(t, draws.shouldOffer(endgame, 100))
+	assert.False(t, draws.shouldOffer(endgame, 102))
+}
+
+func TestLatencyCompensation(t *testing.T) {
+	latency := newLatencyTracker(100 * time.Millisecond)
+	state := latency.compensate(blitz.GameState{Wtime: 1000, Btime: 1000}, true)
+	assert.Equal(t, 900, state.Wtime)
+	assert.Equal(t, 1000, state.Btime)
+
+	latency.observe(300 * time.Millisecond)
+	assert.Equal(t, 300*time.Millisecond, latency.overhead())
+	state = latency.compensate(blitz.GameState{Wtime: 1000, Btime: 200}, false)
+	assert.Equal(t, 1000, state.Wtime)
+	assert.Equal(t, 10, state.Btime)
+}
+
+func TestGameResult(t *testing.T) {
+	assert.Equal(t, "win", gameResult(true, blitz.GameState{Status: "mate", Winner: "white"}))
+	assert.Equal(t, "loss", gameResult(false, blitz.GameState{Status: "resign", Winner: "white"}))
+	assert.Equal(t, "draw", gameResult(false, blitz.GameState{Status: "draw"}))
+	assert.Equal(t, "aborted", gameResult(true, blitz.GameState{Status: "aborted"}))
+	assert.Equal(t, "unknown", gameResult(true, blitz.GameState{Status: "started"}))
+}
+
+func TestChallengePolicy(t *testing.T) {
+	policy := DefaultChallengePolicy()
+	policy.MinInitial = 60
+	assert.NoError(t, policy.Validate())
+
+	challenge := blitz.Challenge{
+		Variant:     blitz.Variant{Key: "standard"},
+		Rated:       true,
+		TimeControl: blitz.TimeControl{Type: "clock", Limit: 180, Increment: 2},
+	}
+	ok, _ := policy.evaluate(challenge)
+	assert.True(t, ok)
+
+	challenge.TimeControl.Limit = 30
+	ok, reason := policy.evaluate(challenge)
+	assert.False(t, ok)
+	assert.Equal(t, blitz.DeclineTooFast, reason)
+
+	challenge.Variant.Key = "atomic"
+	ok, reason = policy.evaluate(challenge)
+	assert.False(t, ok)
+	assert.Equal(t, blitz.DeclineVariant, reason)
+
+	challenge = blitz.Challenge{Variant: blitz.Variant{Key: "standard"}, TimeControl: blitz.TimeControl{Type: "unlimited"}}
+	ok, reason = policy.evaluate(challenge)
+	assert.False(t, ok)
+	assert.Equal(t, blitz.DeclineTimeControl, reason)
+}
+
+func TestChatCommands(t *testing.T) {
+	responder := newChatResponder(5 * time.Second)
+	info := engine.Info{Depth: 6, HasScore: true, Score: engine.Score{Centipawns: 35}, PV: []string{"e2e4", "e7e5"}}
+	now := time.Now()
+
+	assert.Equal(t, "Evaluation (from my side): +0.35", responder.respond(blitz.ChatLine{Text: "!eval"}, info, now))
+	assert.Equal(t, "", responder.respond(blitz.ChatLine{Text: "!pv"}, info, now.Add(time.Second)))
+	assert.Equal(t, "Expected line: e2e4 e7e5", responder.respond(blitz.ChatLine{Text: "!PV"}, info, now.Add(10*time.Second)))
+	assert.Equal(t, "", responder.respond(blitz.ChatLine{Text: "good game"}, info, now.Add(time.Minute)))
+	assert.Equal(t, "mated in 2", formatScore(engine.Score{Mate: -2}))
+}
+
+func TestVictoryClaimer(t *testing.T) {
+	claimed := make(chan struct{}, 1)
+	claimer := newVictoryClaimer(func() { claimed <- struct{}{} })
+
+	claimer.observe(blitz.OpponentGone{Gone: true, ClaimWinInSeconds: 0})
+	claimer.observe(blitz.OpponentGone{Gone: false})
+	select {
+	case <-claimed:
+		t.Fatal("claimed victory after opponent returned")
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	claimer.observe(blitz.OpponentGone{Gone: true, ClaimWinInSeconds: 0})
+	select {
+	case <-claimed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("never claimed victory")
+	}
+}
+
+func TestProfileFor(t *testing.T) {
+	s := &Server{
+		engine: EngineProfile{Path: "lc0", Args: []string{"--weights=net.pb.gz"}, Options: map[string]string{"Hash": "128", "Threads": "1"}},
+		engineProfiles: map[string]EngineProfile{
+			"bullet":         {Options: map[string]string{"Hash": "16"}},
+			"correspondence": {Path: "crafty", Protocol: "cecp"},
+		},
+	}
+
+	bullet := s.profileFor("bullet", "standard")
+	assert.Equal(t, "lc0", bullet.Path)
+	assert.Equal(t, []string{"--weights=net.pb.gz"}, bullet.Args)
+	assert.Equal(t, map[string]string{"Hash": "16", "Threads": "1"}, bullet.Options)
+	assert.Equal(t, "128", s.profileFor("classical", "standard").Options["Hash"])
+	assert.Equal(t, "cecp", s.profileFor("correspondence", "standard").Protocol)
+	assert.Empty(t, s.profileFor("correspondence", "standard").Args, "crafty doesn't take lc0's arguments")
+}
+
+func TestProfileForVariant(t *testing.T) {
+	s := &Server{
+		engine: EngineProfile{Path: "apollo", Protocol: "uci", Options: map[string]string{"Hash": "128"}},
+		engineProfiles: map[string]EngineProfile{
+			"bullet": {Options: map[string]string{"Hash": "16"}},
+		},
+		variantEngines: map[string]EngineProfile{
+			"atomic": {Path: "fairy-stockfish", Options: map[string]string{"Threads": "2"}},
+		},
+	}
+
+	atomic := s.profileFor("bullet", "atomic")
+	assert.Equal(t, "fairy-stockfish", atomic.Path)
+	assert.Equal(t, map[string]string{"Hash": "16", "Threads": "2"}, atomic.Options)
+	assert.True(t, s.routesVariant("atomic"))
+	assert.False(t, s.routesVariant("standard"))
+	assert.Equal(t, "apollo", s.profileFor("bullet", "standard").Path)
+
+	policy := DefaultChallengePolicy()
+	policy.Variants = append(policy.Variants, "atomic")
+	assert.NoError(t, policy.Validate())
+	assert.NoError(t, policy.ValidateEngines(s.variantEngines))
+	assert.Error(t, policy.ValidateEngines(nil), "nothing plays atomic")
+	ok, _ := policy.evaluate(blitz.Challenge{
+		Variant:     blitz.Variant{Key: "atomic"},
+		Rated:       true,
+		TimeControl: blitz.TimeControl{Type: "clock", Limit: 60},
+	})
+	assert.True(t, ok)
+}
+
+func TestStartEngineSetsVariant(t *testing.T) {
+	fake := &ucitest.Engine{Options: []string{"UCI_Variant", "Threads"}}
+	s := &Server{launchEngine: func(profile EngineProfile, transcript io.Writer) (engine.Engine, error) {
+		return engine.New(profile.Protocol, fake)
+	}}
+
+	client, err := s.startEngine(EngineProfile{Path: "fairy-stockfish"}, blitz.Variant{Key: "threeCheck"}, ioutil.Discard)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer shutdownApollo(client)
+	assert.Contains(t, fake.Commands(), "setoption name UCI_Variant value 3check")
+}
+
+func TestStartEngineSizesVariantEngine(t *testing.T) {
+	fake := &ucitest.Engine{Options: []string{"UCI_Variant", "Hash", "Threads", "SyzygyPath"}}
+	var transcript strings.Builder
+	s := &Server{
+		launchEngine: func(profile EngineProfile, transcript io.Writer) (engine.Engine, error) {
+			return engine.New(profile.Protocol, engine.NewTranscriptTransport(fake, transcript))
+		},
+		host: engine.Host{MemoryMB: 1024, CPUs: 4},
+		pool: NewPool(2),
+	}
+
+	profile := EngineProfile{Path: "fairy-stockfish", Options: map[string]string{"SyzygyPath": "/tb"}, AutoSize: true}
+	client, err := s.startEngine(profile, blitz.Variant{Key: "atomic"}, &transcript)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer shutdownApollo(client)
+	for _, line := range []string{
+		"> setoption name Hash value 256",
+		"> setoption name SyzygyPath value /tb",
+		"> setoption name Threads value 2",
+		"> setoption name UCI_Variant value atomic",
+	} {
+		assert.Contains(t, transcript.String(), line+"\n")
+	}
+}
+
+func TestBookMove(t *testing.T) {
+	openings, err := book.LoadPGN(strings.NewReader("1. e4 e5 2. Nf3 Nc6 1-0\n"), 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s := &Server{
+		book:       openings,
+		bookConfig: BookConfig{MaxPly: 2, Speeds: map[string]int{"bullet": 0, "classical": -1}},
+	}
+
+	move, ok := s.bookMove("blitz", "", []string{"e2e4"}, nil)
+	assert.True(t, ok)
+	assert.Equal(t, "e7e5", move)
+	_, ok = s.bookMove("blitz", "", []string{"e2e4", "e7e5"}, nil)
+	assert.False(t, ok, "past the book depth limit")
+	move, ok = s.bookMove("bullet", "", []string{"e2e4", "e7e5"}, nil)
+	assert.True(t, ok)
+	assert.Equal(t, "g1f3", move)
+	_, ok = s.bookMove("classical", "", nil, nil)
+	assert.False(t, ok, "book disabled for classical")
+}
+
+func TestBestTablebaseMove(t *testing.T) {
+	_, ok := bestTablebaseMove(&blitz.TablebaseEntry{Category: "unknown", Moves: []blitz.TablebaseMove{{UCI: "a1a2"}}})
+	assert.False(t, ok)
+	_, ok = bestTablebaseMove(&blitz.TablebaseEntry{Category: "loss"})
+	assert.False(t, ok, "no legal moves")
+
+	move, ok := bestTablebaseMove(&blitz.TablebaseEntry{
+		Category: "win",
+		Moves:    []blitz.TablebaseMove{{UCI: "h7h8q", Category: "loss"}, {UCI: "h7h8r", Category: "draw"}},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "h7h8q", move)
+}
+
+func TestApolloIsWhite(t *testing.T) {
+	user := &blitz.AccountResponse{ID: "my_bot", Username: "My_Bot"}
+
+	isWhite, err := apolloIsWhite(user, blitz.GameFull{White: blitz.GamePlayer{ID: "my_bot"}, Black: blitz.GamePlayer{ID: "human"}})
+	assert.NoError(t, err)
+	assert.True(t, isWhite)
+
+	isWhite, err = apolloIsWhite(user, blitz.GameFull{White: blitz.GamePlayer{ID: "human"}, Black: blitz.GamePlayer{ID: "my_bot"}})
+	assert.NoError(t, err)
+	assert.False(t, isWhite)
+
+	_, err = apolloIsWhite(user, blitz.GameFull{White: blitz.GamePlayer{ID: "apollo_bot"}, Black: blitz.GamePlayer{ID: "human"}})
+	assert.Error(t, err)
+}
+
+func TestChess960Policy(t *testing.T) {
+	challenge := blitz.Challenge{
+		Variant:     blitz.Variant{Key: "chess960"},
+		TimeControl: blitz.TimeControl{Type: "clock", Limit: 180, Increment: 2},
+	}
+
+	policy := DefaultChallengePolicy()
+	ok, reason := policy.evaluate(challenge)
+	assert.False(t, ok, "chess960 is opt-in")
+	assert.Equal(t, blitz.DeclineVariant, reason)
+
+	policy.Variants = append(policy.Variants, "chess960")
+	assert.NoError(t, policy.Validate())
+	ok, _ = policy.evaluate(challenge)
+	assert.True(t, ok)
+	assert.True(t, isChess960(challenge.Variant))
+}
+
+func TestIsFinished(t *testing.T) {
+	assert.False(t, isFinished("started"))
+	assert.False(t, isFinished(""))
+	assert.True(t, isFinished("mate"))
+	assert.True(t, isFinished("aborted"))
+}
+
+func TestRematchRequest(t *testing.T) {
+	record := &gameRecord{
+		isWhite: true,
+		full: blitz.GameFull{
+			Rated:   true,
+			Variant: blitz.Variant{Key: "standard"},
+			Clock:   blitz.Clock{Initial: 180000, Increment: 2000},
+			White:   blitz.GamePlayer{ID: "my_bot"},
+			Black:   blitz.GamePlayer{ID: "human"},
+		},
+	}
+	request, ok := rematchRequest(record)
+	assert.True(t, ok)
+	assert.Equal(t, blitz.ChallengeRequest{
+		Rated:          true,
+		ClockLimit:     180,
+		ClockIncrement: 2,
+		Color:          "black",
+		Variant:        "standard",
+	}, request)
+
+	record.full.Black.ID = ""
+	_, ok = rematchRequest(record)
+	assert.False(t, ok, "anonymous opponents can't be challenged")
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{}
+	assert.Equal(t, time.Duration(0), limiter.remaining())
+
+	limiter.limited(30 * time.Second)
+	assert.True(t, limiter.remaining() > 25*time.Second)
+	limiter.limited(time.Second)
+	assert.True(t, limiter.remaining() > 25*time.Second, "a shorter cool-down doesn't cut short a longer one")
+
+	header := make(http.Header)
+	assert.Equal(t, time.Duration(0), retryAfter(header))
+	header.Set("Retry-After", "90")
+	assert.Equal(t, 90*time.Second, retryAfter(header))
+}
+
+func TestCommentary(t *testing.T) {
+	commentary := newCommentator(ChatConfig{CommentarySwing: 100, CommentaryEvery: 3})
+
+	assert.Empty(t, commentary.afterSearch(engine.Info{}))
+	assert.Empty(t, commentary.afterSearch(engine.Info{Score: engine.Score{Centipawns: 20}, HasScore: true}))
+	assert.Equal(t, "The evaluation swung from +0.20 to +1.50. Expected line: e2e4",
+		commentary.afterSearch(engine.Info{Score: engine.Score{Centipawns: 150}, HasScore: true, PV: []string{"e2e4"}}))
+	assert.Empty(t, commentary.afterSearch(engine.Info{Score: engine.Score{Centipawns: 140}, HasScore: true}))
+	assert.Empty(t, commentary.afterSearch(engine.Info{Score: engine.Score{Centipawns: 130}, HasScore: true}))
+	assert.Equal(t, "I evaluate this position at +1.20 (depth 12).",
+		commentary.afterSearch(engine.Info{Score: engine.Score{Centipawns: 120}, HasScore: true, Depth: 12}))
+
+	assert.NotEmpty(t, commentary.enteredTablebase())
+	assert.Empty(t, commentary.enteredTablebase())
+}
+
+func TestTimeManagerBudget(t *testing.T) {
+	config := DefaultTimeManagerConfig()
+	assert.Equal(t, time.Duration(0), newTimeManager(config, "blitz").budget(time.Minute, 0), "disabled by default")
+
+	config.Enabled = true
+	clock := newTimeManager(config, "blitz")
+	assert.Equal(t, time.Duration(0), clock.budget(0, 0), "untimed games are left to the engine")
+	assert.Equal(t, 2*time.Second+800*time.Millisecond, clock.budget(60*time.Second, time.Second))
+
+	clock.playedInstantly(true)
+	assert.Equal(t, 3*time.Second, clock.budget(60*time.Second, 0), "extra time out of book")
+	clock.observe(engine.Score{Centipawns: 20}, true)
+	clock.observe(engine.Score{Centipawns: 250}, true)
+	assert.Equal(t, 3*time.Second, clock.budget(60*time.Second, 0), "extra time after a swing")
+
+	bullet := newTimeManager(config, "bullet")
+	assert.Equal(t, time.Second, bullet.budget(5*time.Second, 5*time.Second), "capped at 20% in bullet")
+}
+
+func TestWatchdogDeadline(t *testing.T) {
+	assert.Equal(t, time.Duration(0), watchdogDeadline(0), "no watchdog in untimed games")
+	assert.Equal(t, 59*time.Second, watchdogDeadline(time.Minute))
+	assert.Equal(t, 500*time.Millisecond, watchdogDeadline(time.Second), "margin is at most half the clock")
+}
+
+func TestLocalBoard(t *testing.T) {
+	board := newLocalBoard("startpos", true)
+	board.sync([]string{"e2e4"})
+	assert.True(t, board.legal("e7e5"))
+	assert.False(t, board.legal("e2e4"), "not black's pawn")
+	assert.False(t, board.legal("e8g8"))
+	move, err := board.fallbackMove()
+	assert.NoError(t, err)
+	assert.True(t, board.legal(move))
+
+	// A takeback replays the game from the start.
+	board.sync(nil)
+	assert.True(t, board.legal("e2e4"))
+
+	board.sync([]string{"f2f3", "e7e5", "g2g4", "d8h4"})
+	_, err = board.fallbackMove()
+	assert.Error(t, err, "white is checkmated and has no move to play")
+}
+
+func TestLocalBoardChess960(t *testing.T) {
+	board := newLocalBoard("", false)
+	board.sync([]string{"e2e4"})
+	assert.True(t, board.legal("e1h1"), "chess960 games aren't validated")
+	_, err := board.fallbackMove()
+	assert.Error(t, err)
+}
+
+func TestMoveDelay(t *testing.T) {
+	full := blitz.GameFull{
+		Speed: "rapid",
+		White: blitz.GamePlayer{ID: "apollo", Title: "BOT"},
+		Black: blitz.GamePlayer{ID: "someone"},
+	}
+	config := DefaultMoveDelayConfig()
+	assert.False(t, config.appliesTo(full, true), "disabled by default")
+
+	config.Min, config.Max = time.Second, 3*time.Second
+	assert.True(t, config.appliesTo(full, true))
+	assert.False(t, config.appliesTo(full, false), "bots don't need a delay")
+	full.Speed = "bullet"
+	assert.False(t, config.appliesTo(full, true), "not at fast time controls")
+
+	for i := 0; i < 10; i++ {
+		delay := config.pick()
+		assert.True(t, delay >= time.Second && delay <= 3*time.Second)
+	}
+	config.Max = 0
+	assert.Equal(t, time.Second, config.pick())
+}
+
+func TestPool(t *testing.T) {
+	pool := NewPool(2)
+	assert.True(t, pool.tryAcquire())
+	assert.False(t, pool.full())
+	assert.True(t, pool.tryAcquire())
+	assert.True(t, pool.full())
+	assert.False(t, pool.tryAcquire())
+	pool.release()
+	assert.False(t, pool.full())
+}
+
+func TestOpponentCounter(t *testing.T) {
+	var counter opponentCounter
+	morning := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+	counter.played("Someone", morning)
+	counter.played("someone", morning.Add(time.Hour))
+	counter.played("other", morning)
+	assert.Equal(t, 2, counter.today("SOMEONE", morning.Add(2*time.Hour)))
+	assert.Equal(t, 0, counter.today("nobody", morning))
+	assert.Equal(t, 0, counter.today("someone", morning.Add(24*time.Hour)), "counts reset every day")
+}
+
+func TestRecordSearch(t *testing.T) {
+	record := &gameRecord{id: "abc", isWhite: true, hasFull: true}
+	record.recordSearch(4, "g1f3", engine.Info{Depth: 12, Nodes: 50000, NPS: 100000, Score: engine.Score{Mate: 3}, HasScore: true},
+		500*time.Millisecond)
+	record.recordSearch(6, "f1c4", engine.Info{Depth: 10}, time.Second)
+
+	searches := record.metadata().Searches
+	if !assert.Len(t, searches, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, 4, searches[0].Ply)
+	assert.Equal(t, 3, searches[0].Mate)
+	assert.Equal(t, 500, searches[0].TimeMs)
+	assert.Equal(t, "f1c4", searches[1].Move)
+}
+
+func TestAnnotateExport(t *testing.T) {
+	const export = `[Event "Rated Blitz game"]
+[White "someone"]
+[Black "apollo-bot"]
+[Result "*"]
+
+1. e4 { [%clk 0:03:00] } 1... c5 { [%clk 0:03:00] } 2. Nf3 { [%clk 0:02:59] } 2... d6 { [%clk 0:02:58] } *
+`
+	record := &gameRecord{id: "abc", isWhite: false}
+	record.recordSearch(1, "c7c5", engine.Info{Score: engine.Score{Centipawns: -20}, HasScore: true}, time.Second)
+	record.recordSearch(3, "d7d6", engine.Info{Score: engine.Score{Centipawns: 15}, HasScore: true}, time.Second)
+
+	game, err := pgn.Parse(record.annotate(export))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "apollod", game.Tags.Get("Annotator"))
+	assert.False(t, game.Moves[0].HasEval)
+	assert.Equal(t, engine.Score{Centipawns: 20}, game.Moves[1].Eval)
+	assert.Equal(t, engine.Score{Centipawns: -15}, game.Moves[3].Eval)
+	assert.Equal(t, 178*time.Second, game.Moves[3].Clock)
+
+	assert.Equal(t, "not pgn (", record.annotate("not pgn ("))
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+// SmokeTestConfig describes the game played by a smoke test.
+type SmokeTestConfig struct {
+	// Level is the strength of the lichess AI to play, from 1 to 8.
+	Level          int
+	ClockLimit     int
+	ClockIncrement int
+}
+
+// SmokeTest plays a single game against the lichess AI, through the same path as any other game, and returns its
+// result once it's over. It's an error for the game not to be played to a finish, which makes it a good check that a
+// deployment works before the bot is exposed to the public. Challenges from anyone else are declined meanwhile.
+func (s *Server) SmokeTest(ctx context.Context, config SmokeTestConfig) (string, error) {
+	if config.Level < 1 || config.Level > 8 {
+		return "", errors.New("lichess AI level must be between 1 and 8")
+	}
+	s.Pause()
+	defer s.closeEnginePools()
+	events, err := s.client.Challenges.StreamEvents(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read lichess event stream")
+	}
+	go s.challengeLoop()
+
+	// Listen for the end of the game before it can possibly start, so that we can't miss it.
+	live, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	gameID, err := s.client.Challenges.ChallengeAI(ctx, config.Level, blitz.ChallengeRequest{
+		ClockLimit:     config.ClockLimit,
+		ClockIncrement: config.ClockIncrement,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to challenge the lichess AI")
+	}
+	log.WithFields(log.Fields{
+		"game_id": gameID,
+		"level":   config.Level,
+	}).Info("smoke test game created")
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return "", errors.New("lichess event stream ended before the game did")
+			}
+			switch e := event.(type) {
+			case blitz.Challenge:
+				if err := s.HandleChallenge(ctx, e); err != nil {
+					log.WithError(err).Error("failed to decline challenge")
+				}
+			case blitz.GameStart:
+				if e.ID == gameID {
+					s.HandleGameStart(ctx, e)
+				}
+			case blitz.GameFinish:
+				s.HandleGameFinish(e)
+			}
+		case event := <-live:
+			if event.Type != "end" || event.Game != gameID {
+				continue
+			}
+			// Let the game finish wrapping up, so that everything it records is recorded.
+			s.gamesWaitGroup.Wait()
+			switch event.Result {
+			case "win", "loss", "draw":
+				return event.Result, nil
+			default:
+				return event.Result, errors.Errorf("smoke test game ended without being played out (%s)", event.Status)
+			}
+		case <-ctx.Done():
+			return "", errors.Wrap(ctx.Err(), "smoke test game did not finish in time")
+		}
+	}
+}
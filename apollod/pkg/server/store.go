@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+)
+
+// WithStore records every challenge decision, game, and move to the given repository, and counts games against each
+// opponent from it rather than from memory, so that limits survive restarts. Unless WithResults says otherwise, the
+// result of every game is recorded there too.
+func WithStore(repository store.Repository) ServerOption {
+	return func(server *Server) {
+		server.store = repository
+	}
+}
+
+// storeChallenge records what we decided to do about a challenge, and why.
+func (s *Server) storeChallenge(ctx context.Context, challenge blitz.Challenge, decision, reason string) {
+	if s.store == nil {
+		return
+	}
+	err := s.store.RecordChallenge(ctx, store.Challenge{
+		ID:               challenge.ID,
+		Time:             time.Now().UTC(),
+		Challenger:       challenge.Challenger.Name,
+		ChallengerRating: challenge.Challenger.Rating,
+		Variant:          challenge.Variant.Key,
+		Perf:             challenge.Perf.Name,
+		Rated:            challenge.Rated,
+		Decision:         decision,
+		Reason:           reason,
+	})
+	if err != nil {
+		log.WithError(err).WithField("game_id", challenge.ID).Warn("failed to store challenge")
+	}
+}
+
+// storeGameStart records a game we've just started playing.
+func (s *Server) storeGameStart(ctx context.Context, record *gameRecord) {
+	if s.store == nil {
+		return
+	}
+	opponent, color := record.opponent(), "white"
+	if !record.isWhite {
+		color = "black"
+	}
+	err := s.store.StartGame(ctx, store.Game{
+		ID:             record.id,
+		Account:        s.Username(),
+		Started:        time.Now().UTC(),
+		Opponent:       opponent.Name,
+		OpponentID:     opponent.ID,
+		OpponentRating: opponent.Rating,
+		OpponentTitle:  opponent.Title,
+		Color:          color,
+		Speed:          record.full.Speed,
+		Variant:        record.full.Variant.Key,
+		Rated:          record.full.Rated,
+	})
+	if err != nil {
+		record.logger().WithError(err).Warn("failed to store game")
+	}
+}
+
+// storeMoves records any moves played since we last stored them, with the clocks and, for our own moves, the
+// engine's search.
+func (s *Server) storeMoves(ctx context.Context, record *gameRecord) {
+	if s.store == nil {
+		return
+	}
+	moves := splitMoves(record.lastState.Moves)
+	for ply := record.storedPly; ply < len(moves); ply++ {
+		move := store.Move{
+			GameID:      record.id,
+			Ply:         ply,
+			Move:        moves[ply],
+			WhiteTimeMs: record.lastState.Wtime,
+			BlackTimeMs: record.lastState.Btime,
+		}
+		for _, search := range record.searches {
+			if search.Ply == ply && search.Move == moves[ply] {
+				move.HasEval = true
+				move.Depth, move.Nodes, move.NPS = search.Depth, search.Nodes, search.NPS
+				move.Centipawns, move.Mate = search.Centipawns, search.Mate
+			}
+		}
+		if err := s.store.RecordMove(ctx, move); err != nil {
+			record.logger().WithError(err).Warn("failed to store move")
+			return
+		}
+	}
+	// After a takeback, the moves from here on are stored again as they're replayed.
+	record.storedPly = len(moves)
+}
+
+// gamesToday returns the number of games started against an opponent, identified by lichess user ID, today.
+func (s *Server) gamesToday(ctx context.Context, opponentID string) int {
+	now := time.Now()
+	if s.store == nil {
+		return s.opponents.today(opponentID, now)
+	}
+	count, err := s.store.GamesAgainst(ctx, opponentID, now.UTC().Truncate(24*time.Hour))
+	if err != nil {
+		log.WithError(err).Warn("failed to count games against opponent, falling back to this session's count")
+		return s.opponents.today(opponentID, now)
+	}
+	return count
+}
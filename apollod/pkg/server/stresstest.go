@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz/blitztest"
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+)
+
+// defaultStressClock is the clock that stress test games are played with when neither the test nor the recorded game
+// says otherwise: one minute, the fastest that most bots play rated.
+var defaultStressClock = blitz.Clock{Initial: 60000}
+
+// StressTestConfig describes the games played by a stress test.
+type StressTestConfig struct {
+	// Games are the recorded games to replay. The engine takes the bot's side of each, told by its BOT title, or
+	// alternates sides in games that have no bot or two.
+	Games []*gamerecord.Record
+	// Clock is the clock every game is played with. If unset, each game is played with the clock it was recorded
+	// with, or a minute if it had none.
+	Clock blitz.Clock
+	// OpponentDelay is how long the opponent thinks before each of its moves.
+	OpponentDelay time.Duration
+}
+
+// StressGame is how one stress test game went.
+type StressGame struct {
+	ID string
+	// Source is the recorded game it replayed.
+	Source string
+	Color  string
+	// Result and Status are how the game ended, from the bot's side, as lichess saw it.
+	Result string
+	Status string
+	// Moves is the number of moves the bot played.
+	Moves int
+	// MinClock is the least time the bot really had left after any of its moves.
+	MinClock time.Duration
+	// Stopped and Fallbacks count the times the watchdog had to stop the engine, and the times it had to give up on
+	// it and play a move of its own.
+	Stopped   int
+	Fallbacks int
+	// Reconnects counts the times the game's stream had to be re-opened.
+	Reconnects int
+}
+
+// Flagged returns true if the bot lost the game on time.
+func (g StressGame) Flagged() bool {
+	return g.Result == "loss" && g.Status == "outoftime"
+}
+
+func (g StressGame) String() string {
+	return fmt.Sprintf("%s (%s, %s): %s by %s after %d moves, min clock %s, %d stopped, %d fallbacks, %d reconnects",
+		g.ID, g.Source, g.Color, g.Result, g.Status, g.Moves, g.MinClock, g.Stopped, g.Fallbacks, g.Reconnects)
+}
+
+// StressTest replays recorded games against the engine, one at a time and through the same path as any other game,
+// on a fake lichess that makes time harder to keep than it usually is: it keeps real clocks and plays under whatever
+// conditions it was told to simulate, such as latency, clock skew, and stalls in the game stream. The server must have
+// been created to play on lichess. The opponent replays the recorded moves while they're legal, and otherwise the
+// first legal move, and resigns once the recorded game runs out. It's not an error for the bot to lose a game on
+// time; that's what the test is looking for.
+func (s *Server) StressTest(ctx context.Context, lichess *blitztest.Server, config StressTestConfig) ([]StressGame, error) {
+	if len(config.Games) == 0 {
+		return nil, errors.New("no games to replay")
+	}
+	s.Pause()
+	defer s.closeEnginePools()
+	events, err := s.client.Challenges.StreamEvents(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read lichess event stream")
+	}
+	go s.challengeLoop()
+	go func() {
+		for event := range events {
+			switch e := event.(type) {
+			case blitz.Challenge:
+				if err := s.HandleChallenge(ctx, e); err != nil {
+					log.WithError(err).Error("failed to decline challenge")
+				}
+			case blitz.GameStart:
+				s.HandleGameStart(ctx, e)
+			case blitz.GameFinish:
+				s.HandleGameFinish(e)
+			}
+		}
+	}()
+
+	var played []StressGame
+	for i, record := range config.Games {
+		game, err := s.stressGame(ctx, lichess, config, i, record)
+		if err != nil {
+			return played, err
+		}
+		log.WithField("game_id", game.ID).Info("stress test game over: " + game.String())
+		played = append(played, game)
+	}
+	return played, nil
+}
+
+// stressGame plays a single stress test game.
+func (s *Server) stressGame(ctx context.Context, lichess *blitztest.Server, config StressTestConfig, index int, record *gamerecord.Record) (StressGame, error) {
+	result := StressGame{ID: fmt.Sprintf("stress%04d", index+1), Source: record.ID, Color: stressColor(record, index)}
+	if result.Source == "" {
+		result.Source = fmt.Sprintf("game %d", index+1)
+	}
+	clock := config.Clock
+	if clock.Initial == 0 && record.TimeControl != nil {
+		clock = blitz.Clock{Initial: record.TimeControl.Initial * 1000, Increment: record.TimeControl.Increment * 1000}
+	}
+	if clock.Initial == 0 {
+		clock = defaultStressClock
+	}
+	opponent := blitz.GamePlayer{ID: "stress-opponent", Name: "stress-opponent", Rating: 1500}
+	bot := blitz.GamePlayer{ID: s.user.ID, Name: s.user.Username, Title: "BOT", Rating: 1500}
+	full := blitz.GameFull{
+		ID:         result.ID,
+		Rated:      true,
+		Variant:    blitz.Variant{Key: "standard", Name: "Standard"},
+		Clock:      clock,
+		Speed:      stressSpeed(clock),
+		InitialFen: record.InitialFEN,
+		White:      opponent,
+		Black:      bot,
+		State:      blitz.GameState{Wtime: clock.Initial, Btime: clock.Initial, Winc: clock.Increment, Binc: clock.Increment},
+	}
+	if result.Color == "white" {
+		full.White, full.Black = bot, opponent
+	}
+	if full.InitialFen == "" {
+		full.InitialFen = "startpos"
+	}
+
+	live, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+	stopped, fallbacks := engineTimeouts.Value("stopped"), engineTimeouts.Value("fallback")
+	reconnects := gameStreamReconnects.Value()
+	game := lichess.StartGame(full)
+	result.MinClock = time.Duration(clock.Initial) * time.Millisecond
+
+	recorded := record.UCI()
+	for {
+		state := game.State()
+		if state.Status != "started" {
+			break
+		}
+		board, err := stressBoard(full.InitialFen, state.Moves)
+		if err != nil {
+			return result, err
+		}
+		botToMove := (board.Position().Turn() == chess.White) == (result.Color == "white")
+		if botToMove {
+			if !s.awaitStressMove(ctx, game) {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				continue
+			}
+			result.Moves++
+			remaining := game.State().Btime
+			if result.Color == "white" {
+				remaining = game.State().Wtime
+			}
+			if clock := time.Duration(remaining) * time.Millisecond; clock < result.MinClock {
+				result.MinClock = clock
+			}
+			continue
+		}
+
+		played := len(strings.Fields(state.Moves))
+		if played >= len(recorded) {
+			winner := "black"
+			if result.Color == "white" {
+				winner = "white"
+			}
+			game.End("resign", winner)
+			break
+		}
+		select {
+		case <-time.After(config.OpponentDelay):
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+		if err := game.Play(stressReply(board, recorded[played])); err != nil && game.State().Status == "started" {
+			return result, errors.Wrapf(err, "opponent failed to move in %s", result.ID)
+		}
+	}
+
+	// Let the server finish with the game, so that everything it counts is counted.
+	timeout := time.After(time.Minute)
+	for waiting := true; waiting; {
+		select {
+		case event := <-live:
+			waiting = event.Type != "end" || event.Game != result.ID
+		case <-timeout:
+			return result, errors.Errorf("server never finished %s", result.ID)
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+	s.gamesWaitGroup.Wait()
+	// The fake knows how the game really ended, even if the server gave up on it before hearing.
+	final := game.State()
+	result.Result, result.Status = gameResult(result.Color == "white", final), final.Status
+	result.Stopped = int(engineTimeouts.Value("stopped") - stopped)
+	result.Fallbacks = int(engineTimeouts.Value("fallback") - fallbacks)
+	result.Reconnects = int(gameStreamReconnects.Value() - reconnects)
+	return result, nil
+}
+
+// awaitStressMove waits for the bot to move, returning false if the game ended first.
+func (s *Server) awaitStressMove(ctx context.Context, game *blitztest.Game) bool {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-game.BotMoves():
+			return true
+		case <-ticker.C:
+			if game.State().Status != "started" {
+				return false
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// stressColor picks the side the bot plays in a recorded game.
+func stressColor(record *gamerecord.Record, index int) string {
+	whiteBot, blackBot := record.White.Title == "BOT", record.Black.Title == "BOT"
+	switch {
+	case whiteBot && !blackBot:
+		return "white"
+	case blackBot && !whiteBot:
+		return "black"
+	case index%2 == 0:
+		return "white"
+	default:
+		return "black"
+	}
+}
+
+// stressSpeed classifies a clock the way lichess does, by how long a game of forty moves is expected to take.
+func stressSpeed(clock blitz.Clock) string {
+	switch estimate := clock.Initial/1000 + 40*clock.Increment/1000; {
+	case estimate < 30:
+		return "ultraBullet"
+	case estimate < 180:
+		return "bullet"
+	case estimate < 480:
+		return "blitz"
+	case estimate < 1500:
+		return "rapid"
+	default:
+		return "classical"
+	}
+}
+
+func stressBoard(fen, moves string) (*chess.Game, error) {
+	options := []func(*chess.Game){chess.UseNotation(chess.LongAlgebraicNotation{})}
+	if fen != "startpos" {
+		position, err := chess.FEN(fen)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, position)
+	}
+	board := chess.NewGame(options...)
+	for _, move := range strings.Fields(moves) {
+		if err := board.MoveStr(move); err != nil {
+			return nil, err
+		}
+	}
+	return board, nil
+}
+
+// stressReply is the opponent's move: the recorded one if it's legal, and otherwise the first legal move.
+func stressReply(board *chess.Game, recorded string) string {
+	notation, moves := chess.LongAlgebraicNotation{}, board.ValidMoves()
+	for _, move := range moves {
+		if notation.Encode(board.Position(), move) == recorded {
+			return recorded
+		}
+	}
+	return notation.Encode(board.Position(), moves[0])
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz/blitztest"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+// foolsMateRecord is fool's mate as recorded in a game that the bot played as black.
+func foolsMateRecord() *gamerecord.Record {
+	record := gamerecord.New()
+	record.ID = "recorded"
+	record.Black.Title = "BOT"
+	for _, move := range []string{"f2f3", "e7e5", "g2g4", "d8h4"} {
+		record.Moves = append(record.Moves, gamerecord.Move{UCI: move})
+	}
+	return record
+}
+
+// stressTest runs a stress test on a fake lichess with the given conditions, with an engine that plays fool's mate
+// and takes delay over every search.
+func stressTest(t *testing.T, conditions blitztest.Conditions, delay time.Duration, config StressTestConfig) []StressGame {
+	lichess := blitztest.NewServer(botAccount)
+	defer lichess.Close()
+	lichess.Simulate(conditions)
+	server, err := NewServer("token", WithLichessURL(lichess.URL), WithTablebase(TablebaseConfig{}))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	server.launchEngine = func(profile EngineProfile, transcript io.Writer) (engine.Engine, error) {
+		fake := &ucitest.Engine{Delay: delay, BestMove: func(position string, moves []string) string {
+			if move := foolsMate[strings.Join(moves, " ")]; move != "" {
+				return move
+			}
+			return ucitest.FirstLegalMove(position, moves)
+		}}
+		return engine.New(profile.Protocol, engine.NewTranscriptTransport(fake, transcript))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+	games, err := server.StressTest(ctx, lichess, config)
+	assert.NoError(t, err)
+	return games
+}
+
+func TestStressTestReplaysRecordedGames(t *testing.T) {
+	games := stressTest(t, blitztest.Conditions{Latency: 20 * time.Millisecond, StallEvery: 2, StallFor: 50 * time.Millisecond}, 0,
+		StressTestConfig{Games: []*gamerecord.Record{foolsMateRecord()}})
+	if assert.Len(t, games, 1) {
+		assert.Equal(t, "black", games[0].Color)
+		assert.Equal(t, "win", games[0].Result)
+		assert.Equal(t, "mate", games[0].Status)
+		assert.Equal(t, 2, games[0].Moves)
+		assert.False(t, games[0].Flagged())
+	}
+}
+
+func TestStressTestCatchesFlagging(t *testing.T) {
+	// The watchdog stops the engine with half a second to spare, but the move takes longer than that to arrive.
+	games := stressTest(t, blitztest.Conditions{Latency: 800 * time.Millisecond}, 5*time.Second,
+		StressTestConfig{Games: []*gamerecord.Record{foolsMateRecord()}, Clock: blitz.Clock{Initial: 1000}})
+	if assert.Len(t, games, 1) {
+		assert.True(t, games[0].Flagged(), "%s", games[0])
+		assert.Equal(t, 1, games[0].Stopped)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+const (
+	defaultTablebaseMaxPieces = 7
+	defaultTablebaseTimeout   = 2 * time.Second
+	// tablebaseClockFraction bounds a tablebase probe to this fraction of our remaining clock, so that a slow
+	// tablebase server can't flag us in a fast game.
+	tablebaseClockFraction = 10
+)
+
+// TablebaseConfig controls probing the lichess endgame tablebase during games.
+type TablebaseConfig struct {
+	// Enabled turns on tablebase probing.
+	Enabled bool `yaml:"enabled"`
+	// MaxPieces is the largest number of pieces, kings included, for which we'll probe the tablebase.
+	MaxPieces int `yaml:"maxPieces"`
+	// Timeout is the longest we'll wait for the tablebase before falling back to the engine.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// DefaultTablebaseConfig probes the tablebase for every position it covers.
+func DefaultTablebaseConfig() TablebaseConfig {
+	return TablebaseConfig{
+		Enabled:   true,
+		MaxPieces: defaultTablebaseMaxPieces,
+		Timeout:   defaultTablebaseTimeout,
+	}
+}
+
+// WithTablebase sets the server's tablebase configuration.
+func WithTablebase(config TablebaseConfig) ServerOption {
+	return func(server *Server) {
+		server.tablebase = config
+	}
+}
+
+// tablebaseMove probes the tablebase for the position reached after moves have been played from initialFen, returning
+// the tablebase's best move. Any failure returns false, leaving the move to the engine.
+func (s *Server) tablebaseMove(ctx context.Context, initialFen string, moves []string, remaining time.Duration) (string, bool) {
+	if !s.tablebase.Enabled {
+		return "", false
+	}
+	game, err := replayGame(initialFen, moves)
+	if err != nil {
+		log.WithError(err).Warn("failed to replay game for tablebase lookup")
+		return "", false
+	}
+	if len(game.Position().Board().SquareMap()) > s.tablebase.MaxPieces {
+		return "", false
+	}
+
+	timeout := s.tablebase.Timeout
+	if remaining > 0 && remaining/tablebaseClockFraction < timeout {
+		timeout = remaining / tablebaseClockFraction
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	entry, err := s.client.Tablebase.Standard(ctx, game.Position().String())
+	if err != nil {
+		tablebaseProbes.Inc("error")
+		log.WithError(err).Warn("failed to probe tablebase, falling back to engine")
+		return "", false
+	}
+	move, ok := bestTablebaseMove(entry)
+	if !ok {
+		tablebaseProbes.Inc("miss")
+		return "", false
+	}
+	tablebaseProbes.Inc("hit")
+	log.WithFields(log.Fields{
+		"category": entry.Category,
+		"move":     move,
+	}).Info("found position in tablebase")
+	return move, true
+}
+
+// bestTablebaseMove picks the move to play from a tablebase entry. The tablebase already orders moves best first, but
+// we only trust it for positions it actually knows the outcome of.
+func bestTablebaseMove(entry *blitz.TablebaseEntry) (string, bool) {
+	if entry.Category == "" || entry.Category == "unknown" || len(entry.Moves) == 0 {
+		return "", false
+	}
+	return entry.Moves[0].UCI, true
+}
+
+// clockRemaining returns the time left on our clock, or zero if the game is untimed.
+func clockRemaining(state blitz.GameState, isWhite bool) time.Duration {
+	if isWhite {
+		return time.Duration(state.Wtime) * time.Millisecond
+	}
+	return time.Duration(state.Btime) * time.Millisecond
+}
+
+// clockIncrement returns our increment, or zero if the game has none.
+func clockIncrement(state blitz.GameState, isWhite bool) time.Duration {
+	if isWhite {
+		return time.Duration(state.Winc) * time.Millisecond
+	}
+	return time.Duration(state.Binc) * time.Millisecond
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"time"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const (
+	defaultMovesToGo      = 30
+	defaultMaxFraction    = 0.25
+	defaultOutOfBookBonus = 1.5
+	defaultSwingBonus     = 1.5
+	defaultSwingThreshold = 100
+	// incrementUsage is the fraction of the increment we plan to spend on every move.
+	incrementUsage = 0.8
+)
+
+// TimeManagerConfig controls the server's own time management. When it is enabled, the server decides how long the
+// engine may think about each move and tells it with "go movetime", rather than handing the engine the clocks and
+// trusting it to budget them.
+type TimeManagerConfig struct {
+	// Enabled turns on server-side time management for timed games.
+	Enabled bool `yaml:"enabled"`
+	// MovesToGo is the number of moves we expect to have to make with the time on our clock.
+	MovesToGo int `yaml:"movesToGo"`
+	// MaxFraction caps the time spent on any one move, as a fraction of our remaining time.
+	MaxFraction float64 `yaml:"maxFraction"`
+	// Speeds override MaxFraction for games at a particular lichess speed. If unset, the fastest games are held to
+	// tighter limits.
+	Speeds map[string]float64 `yaml:"speeds"`
+	// OutOfBookBonus multiplies the time spent on our first move after leaving the opening book, which is often the
+	// most important decision of the game.
+	OutOfBookBonus float64 `yaml:"outOfBookBonus"`
+	// SwingBonus multiplies the time spent on the move after the evaluation swings by at least SwingThreshold
+	// centipawns, since something has gone differently than expected.
+	SwingBonus     float64 `yaml:"swingBonus"`
+	SwingThreshold int     `yaml:"swingThreshold"`
+}
+
+// DefaultTimeManagerConfig leaves time management to the engine, but has sensible settings for when it's enabled.
+func DefaultTimeManagerConfig() TimeManagerConfig {
+	return TimeManagerConfig{
+		MovesToGo:      defaultMovesToGo,
+		MaxFraction:    defaultMaxFraction,
+		OutOfBookBonus: defaultOutOfBookBonus,
+		SwingBonus:     defaultSwingBonus,
+		SwingThreshold: defaultSwingThreshold,
+	}
+}
+
+// defaultSpeedFractions are the per-speed limits used when none are configured.
+var defaultSpeedFractions = map[string]float64{"ultraBullet": 0.1, "bullet": 0.2}
+
+// WithTimeManager sets the server's time management configuration.
+func WithTimeManager(config TimeManagerConfig) ServerOption {
+	return func(server *Server) {
+		server.timeManager = config
+	}
+}
+
+// timeManager budgets our thinking time over the course of a single game.
+type timeManager struct {
+	config      TimeManagerConfig
+	maxFraction float64
+
+	inBook    bool
+	lastScore engine.Score
+	hasScore  bool
+	swung     bool
+}
+
+func newTimeManager(config TimeManagerConfig, speed string) *timeManager {
+	maxFraction, speeds := config.MaxFraction, config.Speeds
+	if speeds == nil {
+		speeds = defaultSpeedFractions
+	}
+	if fraction, ok := speeds[speed]; ok {
+		maxFraction = fraction
+	}
+	return &timeManager{config: config, maxFraction: maxFraction}
+}
+
+// playedInstantly records that we played a move without searching, from the book or the tablebase.
+func (t *timeManager) playedInstantly(book bool) {
+	t.inBook = book
+}
+
+// observe records the engine's evaluation after searching for our move.
+func (t *timeManager) observe(score engine.Score, ok bool) {
+	t.swung = ok && t.hasScore && abs(centipawns(score)-centipawns(t.lastScore)) >= t.config.SwingThreshold
+	t.lastScore, t.hasScore = score, ok
+	t.inBook = false
+}
+
+// budget returns how long to search for our next move, given the time left on our clock and our increment. It returns
+// zero if the game is untimed, or if server-side time management is disabled, leaving it to the engine.
+func (t *timeManager) budget(remaining, increment time.Duration) time.Duration {
+	if !t.config.Enabled || remaining <= 0 {
+		return 0
+	}
+
+	movesToGo := t.config.MovesToGo
+	if movesToGo < 1 {
+		movesToGo = defaultMovesToGo
+	}
+	budget := remaining/time.Duration(movesToGo) + time.Duration(incrementUsage*float64(increment))
+	if t.inBook && t.config.OutOfBookBonus > 0 {
+		budget = time.Duration(float64(budget) * t.config.OutOfBookBonus)
+	}
+	if t.swung && t.config.SwingBonus > 0 {
+		budget = time.Duration(float64(budget) * t.config.SwingBonus)
+	}
+
+	if limit := time.Duration(t.maxFraction * float64(remaining)); t.maxFraction > 0 && budget > limit {
+		budget = limit
+	}
+	if budget < minimumThinkTime {
+		budget = minimumThinkTime
+	}
+	return budget
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+const (
+	// watchdogMargin is how much of our clock we keep in reserve for a fallback move if the engine doesn't answer.
+	// It never exceeds half of the time we have left.
+	watchdogMargin = time.Second
+	// stopGrace is how long we give the engine to answer "stop" before giving up on it.
+	stopGrace = 250 * time.Millisecond
+)
+
+// searchResult is the outcome of an engine search running in the background.
+type searchResult struct {
+	move string
+	err  error
+}
+
+// watchdogDeadline returns how long the engine may search before we intervene, given the time left on our clock. It
+// returns zero for untimed games, where there's no clock to lose on.
+func watchdogDeadline(remaining time.Duration) time.Duration {
+	if remaining <= 0 {
+		return 0
+	}
+	margin := watchdogMargin
+	if margin > remaining/2 {
+		margin = remaining / 2
+	}
+	return remaining - margin
+}
+
+// watchedEvaluate runs engineEvaluate, but doesn't let a misbehaving engine flag us. If the engine hasn't answered
+// by the watchdog deadline we tell it to stop, and if it still doesn't answer we give up on it and return a legal
+// move of our own instead. The returned bool is true if the engine was given up on, in which case it has been killed
+// and the caller must start a new one before searching again. If ctx is canceled because the game is over, the search
+// is stopped straight away and the context's error is returned.
+func watchedEvaluate(ctx context.Context, client engine.Engine, board *localBoard, position string, state blitz.GameState, limits searchLimits) (string, bool, error) {
+	results := make(chan searchResult, 1)
+	go func() {
+		move, err := engineEvaluate(client, position, state, limits)
+		results <- searchResult{move, err}
+	}()
+
+	// Untimed games have no clock to lose on, so the watchdog never fires.
+	deadline := watchdogDeadline(limits.remaining)
+	var expired <-chan time.Time
+	if deadline > 0 {
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case result := <-results:
+		return result.move, false, result.err
+	case <-ctx.Done():
+		return "", cancelSearch(client, results), ctx.Err()
+	case <-expired:
+	}
+
+	log.WithField("deadline", deadline).Warn("engine did not move in time, telling it to stop")
+	if err := client.Stop(); err != nil {
+		log.WithError(err).Warn("failed to send stop to engine")
+	}
+	select {
+	case result := <-results:
+		engineTimeouts.Inc("stopped")
+		return result.move, false, result.err
+	case <-time.After(stopGrace):
+	}
+
+	engineTimeouts.Inc("fallback")
+	killEngine(client)
+	move, err := board.fallbackMove()
+	if err != nil {
+		return "", true, errors.Wrap(err, "engine did not move in time and no fallback move was available")
+	}
+	log.WithField("move", move).Warn("engine did not respond to stop, playing fallback move")
+	return move, true, nil
+}
+
+// cancelSearch stops a search whose result nobody wants anymore, so that it doesn't keep burning CPU until the engine
+// decides on a move. It returns true if the engine didn't stop and had to be killed.
+func cancelSearch(client engine.Engine, results <-chan searchResult) bool {
+	searchesCanceled.Inc()
+	if err := client.Stop(); err != nil {
+		log.WithError(err).Warn("failed to send stop to engine")
+	}
+	select {
+	case <-results:
+		return false
+	case <-time.After(stopGrace):
+	}
+	log.Warn("engine did not respond to stop, killing it")
+	killEngine(client)
+	return true
+}
+
+// killEngine terminates an engine that has stopped responding. Waiting for it to exit could take as long as it's
+// stuck, so that happens in the background.
+func killEngine(client engine.Engine) {
+	if err := client.Kill(); err != nil {
+		log.WithError(err).Warn("failed to kill unresponsive engine")
+	}
+	go func() {
+		if err := client.Close(); err != nil {
+			log.WithError(err).Debug("unresponsive engine exited")
+		}
+	}()
+}
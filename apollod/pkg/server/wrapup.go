@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+)
+
+const (
+	// wrapUpTimeout bounds the lichess requests we make after a game ends.
+	wrapUpTimeout = 10 * time.Second
+	// gameFinishGrace is how long we let a game's stream wind down on its own after lichess tells us the game
+	// finished, before we stop waiting on it.
+	gameFinishGrace = 10 * time.Second
+)
+
+// WithRematch has the server challenge its opponent to a rematch, with colors reversed, after every finished game.
+func WithRematch(enabled bool) ServerOption {
+	return func(server *Server) {
+		server.rematch = enabled
+	}
+}
+
+// isFinished returns true if a game with the given status is over.
+func isFinished(status string) bool {
+	switch status {
+	case "", "created", "started":
+		return false
+	default:
+		return true
+	}
+}
+
+// HandleGameFinish makes sure that a game lichess says is over doesn't keep holding its slot. Normally the game's own
+// stream reports the end of the game first and this is a no-op.
+func (s *Server) HandleGameFinish(gameFinish blitz.GameFinish) {
+	s.gamesLock.Lock()
+	game, ok := s.games[gameFinish.ID]
+	s.gamesLock.Unlock()
+	if !ok {
+		return
+	}
+
+	log.WithField("game_id", gameFinish.ID).Info("lichess reports game finished")
+	time.AfterFunc(gameFinishGrace, game.cancel)
+}
+
+// wrapUpGame does everything that needs doing once a game is over: it records and logs the result, says good game,
+// archives the game, and offers a rematch.
+func (s *Server) wrapUpGame(record *gameRecord) {
+	result := record.result()
+	gamesFinished.Inc(result)
+	if record.build != "" {
+		buildGamesFinished.Inc(record.build, result)
+	}
+	record.logger().WithFields(log.Fields{
+		"result": result,
+		"status": record.lastState.Status,
+	}).Info("game over")
+	s.recordResult(record)
+	s.recordFinished(record)
+	if record.hasFull {
+		s.broadcastEnd(record)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wrapUpTimeout)
+	defer cancel()
+	if record.hasFull && result == "aborted" && !record.abortedByUs {
+		s.reportOffense(ctx, record, "aborted")
+	}
+	if !record.hasFull || result == "aborted" || result == "unknown" {
+		return
+	}
+
+	if message := s.gameChat(record).GameOverMessage; message != "" {
+		if err := s.writeChat(ctx, record.id, "player", message); err != nil {
+			record.logger().WithError(err).Warn("failed to send game over chat message")
+		}
+	}
+
+	if s.archive != nil {
+		// Archival doesn't need the game's slot, so let it happen in the background.
+		s.gamesWaitGroup.Add(1)
+		go func() {
+			defer s.gamesWaitGroup.Done()
+			s.archiveGame(context.Background(), record)
+		}()
+	}
+
+	if (s.rematch || record.etiquette.Rematch) && s.rateLimit.remaining() == 0 {
+		s.offerRematch(ctx, record)
+	}
+}
+
+// offerRematch challenges our opponent to another game at the same time control, with colors reversed.
+func (s *Server) offerRematch(ctx context.Context, record *gameRecord) {
+	request, ok := rematchRequest(record)
+	if !ok {
+		return
+	}
+
+	opponent := record.full.Black
+	if !record.isWhite {
+		opponent = record.full.White
+	}
+	record.logger().WithFields(log.Fields{
+		"opponent": opponent.ID,
+	}).Info("offering rematch")
+	if _, err := s.client.Challenges.CreateChallenge(ctx, opponent.ID, request); err != nil {
+		record.logger().WithError(err).Warn("failed to offer rematch")
+	}
+}
+
+// rematchRequest builds the challenge for a rematch of a game. Games against anonymous players, untimed games, and
+// games from custom positions can't be rematched this way.
+func rematchRequest(record *gameRecord) (blitz.ChallengeRequest, bool) {
+	full := record.full
+	opponent, color := full.Black, "black"
+	if !record.isWhite {
+		opponent, color = full.White, "white"
+	}
+	if opponent.ID == "" || full.Clock.Initial == 0 || full.Variant.Key == "fromPosition" {
+		return blitz.ChallengeRequest{}, false
+	}
+
+	// Lichess reports the clock of a running game in milliseconds, but takes challenge clocks in seconds.
+	return blitz.ChallengeRequest{
+		Rated:          full.Rated,
+		ClockLimit:     full.Clock.Initial / 1000,
+		ClockIncrement: full.Clock.Increment / 1000,
+		Color:          color,
+		Variant:        full.Variant.Key,
+	}, true
+}
@@ -0,0 +1,7 @@
+package store
+
+import (
+	// Database drivers for Open.
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
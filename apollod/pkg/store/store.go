@@ -0,0 +1,325 @@
+// Package store keeps a database of every challenge apollod has decided on and every game it has played, move by move,
+// so that reporting, per-opponent limits, and anything else that looks back at past games share one source of truth.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+)
+
+// Challenge is a challenge we received and what we decided to do about it.
+type Challenge struct {
+	ID               string
+	Time             time.Time
+	Challenger       string
+	ChallengerRating int
+	Variant          string
+	// Perf is the rating category the challenge would be played in, such as "Blitz" or "Chess960".
+	Perf  string
+	Rated bool
+	// Decision is "accepted" or "declined", and Reason is why we declined.
+	Decision string
+	Reason   string
+}
+
+// Game is a game we've started playing. Its result is recorded separately, as a results.Record, once it's over.
+type Game struct {
+	ID             string
+	Account        string
+	Started        time.Time
+	Opponent       string
+	OpponentID     string
+	OpponentRating int
+	OpponentTitle  string
+	Color          string
+	Speed          string
+	Variant        string
+	Rated          bool
+}
+
+// Move is a single move of a game, by either player, and the clocks after it was played. Our own moves also carry the
+// engine's evaluation, if the engine found them.
+type Move struct {
+	GameID      string
+	Ply         int
+	Move        string
+	WhiteTimeMs int
+	BlackTimeMs int
+	HasEval     bool
+	Depth       int
+	Nodes       int64
+	NPS         int64
+	Centipawns  int
+	Mate        int
+}
+
+// Repository is where everything is stored. It also records and reads back results, so it can stand in for a
+// results.Store.
+type Repository interface {
+	results.Recorder
+
+	RecordChallenge(ctx context.Context, challenge Challenge) error
+	StartGame(ctx context.Context, game Game) error
+	RecordMove(ctx context.Context, move Move) error
+	// GamesAgainst counts the games started against an opponent, identified by lichess user ID, since the given time.
+	GamesAgainst(ctx context.Context, opponentID string, since time.Time) (int, error)
+	Close() error
+}
+
+// schema creates the tables, in SQL that both SQLite and Postgres understand. Times are Unix milliseconds and
+// booleans are 0 or 1, which avoids the two disagreeing about either.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS challenges (
+		id TEXT PRIMARY KEY,
+		time BIGINT NOT NULL,
+		challenger TEXT NOT NULL,
+		challenger_rating INTEGER NOT NULL,
+		variant TEXT NOT NULL,
+		perf TEXT NOT NULL,
+		rated INTEGER NOT NULL,
+		decision TEXT NOT NULL,
+		reason TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS games (
+		id TEXT PRIMARY KEY,
+		account TEXT NOT NULL DEFAULT '',
+		started BIGINT NOT NULL DEFAULT 0,
+		finished BIGINT NOT NULL DEFAULT 0,
+		opponent TEXT NOT NULL DEFAULT '',
+		opponent_id TEXT NOT NULL DEFAULT '',
+		opponent_rating INTEGER NOT NULL DEFAULT 0,
+		opponent_title TEXT NOT NULL DEFAULT '',
+		color TEXT NOT NULL DEFAULT '',
+		speed TEXT NOT NULL DEFAULT '',
+		variant TEXT NOT NULL DEFAULT '',
+		rated INTEGER NOT NULL DEFAULT 0,
+		result TEXT NOT NULL DEFAULT '',
+		termination TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS games_by_opponent ON games (opponent_id, started)`,
+	`CREATE INDEX IF NOT EXISTS games_by_finish ON games (finished)`,
+	`CREATE TABLE IF NOT EXISTS moves (
+		game_id TEXT NOT NULL,
+		ply INTEGER NOT NULL,
+		move TEXT NOT NULL,
+		white_time_ms INTEGER NOT NULL,
+		black_time_ms INTEGER NOT NULL,
+		has_eval INTEGER NOT NULL,
+		depth INTEGER NOT NULL,
+		nodes BIGINT NOT NULL,
+		nps BIGINT NOT NULL,
+		cp INTEGER NOT NULL,
+		mate INTEGER NOT NULL,
+		PRIMARY KEY (game_id, ply)
+	)`,
+}
+
+// DB is a Repository backed by a SQL database.
+type DB struct {
+	db *sql.DB
+}
+
+// Open connects to a database and creates any tables that don't exist yet. driver is "sqlite3", in which case dsn is
+// a file name, or "postgres", in which case dsn is a connection string.
+func Open(driver, dsn string) (*DB, error) {
+	switch driver {
+	case "sqlite3", "postgres":
+	default:
+		return nil, errors.Errorf("unsupported database driver %q", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+	if driver == "sqlite3" {
+		// SQLite only allows one writer at a time; take turns rather than failing with "database is locked".
+		db.SetMaxOpenConns(1)
+	}
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "failed to create database schema")
+		}
+	}
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+func (d *DB) RecordChallenge(ctx context.Context, challenge Challenge) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO challenges (id, time, challenger, challenger_rating, variant, perf, rated, decision, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET time = excluded.time, decision = excluded.decision, reason = excluded.reason`,
+		challenge.ID, millis(challenge.Time), challenge.Challenger, challenge.ChallengerRating, challenge.Variant,
+		challenge.Perf, boolean(challenge.Rated), challenge.Decision, challenge.Reason)
+	return errors.Wrap(err, "failed to record challenge")
+}
+
+func (d *DB) StartGame(ctx context.Context, game Game) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO games (id, account, started, opponent, opponent_id, opponent_rating, opponent_title, color, speed,
+			variant, rated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO NOTHING`,
+		game.ID, game.Account, millis(game.Started), game.Opponent, game.OpponentID, game.OpponentRating,
+		game.OpponentTitle, game.Color, game.Speed, game.Variant, boolean(game.Rated))
+	return errors.Wrap(err, "failed to record game")
+}
+
+func (d *DB) RecordMove(ctx context.Context, move Move) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO moves (game_id, ply, move, white_time_ms, black_time_ms, has_eval, depth, nodes, nps, cp, mate)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (game_id, ply) DO UPDATE SET move = excluded.move, white_time_ms = excluded.white_time_ms,
+			black_time_ms = excluded.black_time_ms, has_eval = excluded.has_eval, depth = excluded.depth,
+			nodes = excluded.nodes, nps = excluded.nps, cp = excluded.cp, mate = excluded.mate`,
+		move.GameID, move.Ply, move.Move, move.WhiteTimeMs, move.BlackTimeMs, boolean(move.HasEval), move.Depth,
+		move.Nodes, move.NPS, move.Centipawns, move.Mate)
+	return errors.Wrap(err, "failed to record move")
+}
+
+func (d *DB) GamesAgainst(ctx context.Context, opponentID string, since time.Time) (int, error) {
+	var count int
+	err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM games WHERE opponent_id = $1 AND started >= $2`,
+		opponentID, millis(since)).Scan(&count)
+	return count, errors.Wrap(err, "failed to count games against opponent")
+}
+
+// Append records the result of a finished game, filling in anything we didn't learn about the game when it started.
+func (d *DB) Append(record results.Record) error {
+	_, err := d.db.Exec(`
+		INSERT INTO games (id, finished, opponent, opponent_rating, opponent_title, color, speed, rated, result,
+			termination)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET finished = excluded.finished, result = excluded.result,
+			termination = excluded.termination`,
+		record.GameID, millis(record.Time), record.Opponent, record.OpponentRating, record.OpponentTitle, record.Color,
+		record.Speed, boolean(record.Rated), record.Result, record.Termination)
+	return errors.Wrap(err, "failed to record result")
+}
+
+// Since returns the results of every game that finished at or after since, oldest first.
+func (d *DB) Since(since time.Time) ([]results.Record, error) {
+	rows, err := d.db.Query(`
+		SELECT id, finished, opponent, opponent_rating, opponent_title, color, result, termination, speed, rated
+		FROM games WHERE finished >= $1 AND result != '' ORDER BY finished`, millis(since))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read results")
+	}
+	defer rows.Close()
+
+	var records []results.Record
+	for rows.Next() {
+		var record results.Record
+		var finished int64
+		var rated int
+		if err := rows.Scan(&record.GameID, &finished, &record.Opponent, &record.OpponentRating, &record.OpponentTitle,
+			&record.Color, &record.Result, &record.Termination, &record.Speed, &rated); err != nil {
+			return nil, errors.Wrap(err, "failed to read results")
+		}
+		record.Time = time.Unix(0, finished*int64(time.Millisecond)).UTC()
+		record.Rated = rated != 0
+		records = append(records, record)
+	}
+	return records, errors.Wrap(rows.Err(), "failed to read results")
+}
+
+// GameRecord reads a game and its moves back as a record. Evaluations are stored from our side of the board, and are
+// turned around for records, which are from white's.
+func (d *DB) GameRecord(ctx context.Context, id string) (*gamerecord.Record, error) {
+	var account, opponent, opponentID, opponentTitle, color, speed, variant, result, termination string
+	var started int64
+	var opponentRating, rated int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT account, started, opponent, opponent_id, opponent_rating, opponent_title, color, speed, variant, rated,
+			result, termination
+		FROM games WHERE id = $1`, id).Scan(&account, &started, &opponent, &opponentID, &opponentRating,
+		&opponentTitle, &color, &speed, &variant, &rated, &result, &termination)
+	if err == sql.ErrNoRows {
+		return nil, errors.Errorf("no game %q", id)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read game")
+	}
+
+	record := gamerecord.New()
+	record.ID = id
+	record.Speed = speed
+	record.Variant = variant
+	record.Rated = rated != 0
+	record.Termination = termination
+	if started != 0 {
+		start := time.Unix(0, started*int64(time.Millisecond)).UTC()
+		record.Started = &start
+	}
+	us := gamerecord.Player{Name: account, ID: account}
+	them := gamerecord.Player{Name: opponent, ID: opponentID, Title: opponentTitle, Rating: opponentRating}
+	record.White, record.Black = us, them
+	sign := 1
+	if color == "black" {
+		record.White, record.Black = them, us
+		sign = -1
+	}
+	switch {
+	case result == "draw":
+		record.Result = gamerecord.Draw
+	case (result == "win") == (color == "white") && result != "":
+		record.Result = gamerecord.WhiteWins
+	case result != "":
+		record.Result = gamerecord.BlackWins
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT ply, move, white_time_ms, black_time_ms, has_eval, depth, cp, mate
+		FROM moves WHERE game_id = $1 ORDER BY ply`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read moves")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ply, whiteTime, blackTime, hasEval, depth, centipawns, mate int
+		var uci string
+		if err := rows.Scan(&ply, &uci, &whiteTime, &blackTime, &hasEval, &depth, &centipawns, &mate); err != nil {
+			return nil, errors.Wrap(err, "failed to read moves")
+		}
+		if ply != len(record.Moves) {
+			// A move failed to be stored, and the ones after it can't be replayed without it.
+			break
+		}
+		// The clock that matters is the one of the player who just moved, which is white's on even plies.
+		clock := int64(whiteTime)
+		if ply%2 == 1 {
+			clock = int64(blackTime)
+		}
+		move := gamerecord.Move{UCI: uci, ClockMs: &clock}
+		if hasEval != 0 {
+			move.Eval = &gamerecord.Eval{Centipawns: sign * centipawns, Mate: sign * mate, Depth: depth}
+		}
+		record.Moves = append(record.Moves, move)
+	}
+	return record, errors.Wrap(rows.Err(), "failed to read moves")
+}
+
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func boolean(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+)
+
+// openTestDB opens a fresh SQLite database, returning it and a function that closes and removes it.
+func openTestDB(t *testing.T) (*DB, func()) {
+	dir, err := ioutil.TempDir("", "apollod-store")
+	require.NoError(t, err)
+
+	db, err := Open("sqlite3", filepath.Join(dir, "apollod.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		require.NoError(t, err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGamesAgainst(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	now := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, db.StartGame(ctx, Game{ID: "old", OpponentID: "alice", Started: now.Add(-24 * time.Hour)}))
+	require.NoError(t, db.StartGame(ctx, Game{ID: "a", OpponentID: "alice", Started: now}))
+	require.NoError(t, db.StartGame(ctx, Game{ID: "b", OpponentID: "alice", Started: now.Add(time.Hour)}))
+	require.NoError(t, db.StartGame(ctx, Game{ID: "c", OpponentID: "bob", Started: now}))
+	// Starting the same game again, as after a reconnect, doesn't count twice.
+	require.NoError(t, db.StartGame(ctx, Game{ID: "a", OpponentID: "alice", Started: now}))
+
+	count, err := db.GamesAgainst(ctx, "alice", now.Truncate(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestResultsRoundTrip(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	started := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, db.StartGame(ctx, Game{ID: "a", Opponent: "alice", OpponentID: "alice", Started: started}))
+	require.NoError(t, db.StartGame(ctx, Game{ID: "unfinished", Opponent: "bob", Started: started}))
+	record := results.Record{
+		Time:           started.Add(10 * time.Minute),
+		GameID:         "a",
+		Opponent:       "alice",
+		OpponentRating: 1800,
+		Color:          "white",
+		Result:         "win",
+		Termination:    "mate",
+		Speed:          "blitz",
+		Rated:          true,
+	}
+	require.NoError(t, db.Append(record))
+
+	records, err := db.Since(started)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "a", records[0].GameID)
+	assert.Equal(t, "win", records[0].Result)
+	assert.Equal(t, "mate", records[0].Termination)
+	assert.True(t, records[0].Time.Equal(record.Time))
+
+	records, err = db.Since(record.Time.Add(time.Second))
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestRecordMoveReplaces(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	require.NoError(t, db.RecordMove(ctx, Move{GameID: "a", Ply: 0, Move: "e2e4"}))
+	// A takeback replays the ply with a different move.
+	require.NoError(t, db.RecordMove(ctx, Move{GameID: "a", Ply: 0, Move: "d2d4", HasEval: true, Depth: 12, Centipawns: 20}))
+
+	var move string
+	var depth, hasEval int
+	require.NoError(t, db.db.QueryRow(`SELECT move, depth, has_eval FROM moves WHERE game_id = 'a' AND ply = 0`).
+		Scan(&move, &depth, &hasEval))
+	assert.Equal(t, "d2d4", move)
+	assert.Equal(t, 12, depth)
+	assert.Equal(t, 1, hasEval)
+}
+
+func TestOpenUnsupportedDriver(t *testing.T) {
+	_, err := Open("mysql", "")
+	assert.Error(t, err)
+}
+
+func TestGameRecord(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	started := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, db.StartGame(ctx, Game{ID: "a", Account: "apollo", Opponent: "Alice", OpponentID: "alice",
+		OpponentRating: 1800, Color: "black", Speed: "blitz", Variant: "standard", Started: started}))
+	require.NoError(t, db.RecordMove(ctx, Move{GameID: "a", Ply: 0, Move: "e2e4", WhiteTimeMs: 179000, BlackTimeMs: 180000}))
+	require.NoError(t, db.RecordMove(ctx, Move{GameID: "a", Ply: 1, Move: "e7e5", WhiteTimeMs: 179000,
+		BlackTimeMs: 178000, HasEval: true, Depth: 14, Centipawns: 30}))
+	require.NoError(t, db.Append(results.Record{GameID: "a", Time: started.Add(time.Minute), Color: "black",
+		Result: "win", Termination: "resign"}))
+
+	record, err := db.GameRecord(ctx, "a")
+	require.NoError(t, err)
+	assert.NoError(t, record.Validate())
+	assert.Equal(t, "Alice", record.White.Name)
+	assert.Equal(t, 1800, record.White.Rating)
+	assert.Equal(t, "apollo", record.Black.Name)
+	assert.Equal(t, "0-1", record.Result)
+	assert.Equal(t, "resign", record.Termination)
+	assert.Equal(t, []string{"e2e4", "e7e5"}, record.UCI())
+	assert.Equal(t, int64(178000), *record.Moves[1].ClockMs)
+	assert.Equal(t, -30, record.Moves[1].Eval.Centipawns, "evals are from white's side")
+
+	_, err = db.GameRecord(ctx, "missing")
+	assert.Error(t, err)
+}
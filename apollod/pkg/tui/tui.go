@@ -0,0 +1,225 @@
+// Package tui renders a live dashboard of apollod's status in the terminal, for when someone is watching it play.
+package tui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+)
+
+const (
+	// refreshInterval is how often the dashboard is redrawn.
+	refreshInterval = time.Second
+	// logLinesKept is the number of recent log lines shown at the bottom of the dashboard.
+	logLinesKept = 8
+
+	clearScreen = "\x1b[H\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+	bold        = "\x1b[1m"
+	reset       = "\x1b[0m"
+)
+
+// LogBuffer is a logrus hook that keeps the most recent log lines, so that the dashboard can show them in place of
+// the log output it replaces.
+type LogBuffer struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (b *LogBuffer) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (b *LogBuffer) Fire(entry *log.Entry) error {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s %-7s %s", entry.Time.Format("15:04:05"), strings.ToUpper(entry.Level.String()), entry.Message)
+	for _, key := range sortedKeys(entry.Data) {
+		fmt.Fprintf(&line, " %s=%v", key, entry.Data[key])
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.lines = append(b.lines, line.String())
+	if len(b.lines) > logLinesKept {
+		b.lines = b.lines[len(b.lines)-logLinesKept:]
+	}
+	return nil
+}
+
+// Lines returns the most recent log lines, oldest first.
+func (b *LogBuffer) Lines() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]string(nil), b.lines...)
+}
+
+// Run redraws the dashboard on out every second until the context is canceled. statuses returns the status of every
+// account being played for.
+func Run(ctx context.Context, out io.Writer, statuses func() []server.Status, logs *LogBuffer) {
+	fmt.Fprint(out, hideCursor)
+	defer fmt.Fprint(out, showCursor)
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		// Render the whole frame before writing it, so that the terminal never shows half of one.
+		var frame bytes.Buffer
+		frame.WriteString(clearScreen)
+		Render(&frame, statuses(), logs.Lines())
+		if _, err := out.Write(frame.Bytes()); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Render draws a single frame of the dashboard.
+func Render(w io.Writer, statuses []server.Status, logs []string) {
+	for _, status := range statuses {
+		renderAccount(w, status)
+	}
+	if len(logs) > 0 {
+		fmt.Fprintf(w, "%sRecent log%s\n", bold, reset)
+		for _, line := range logs {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+}
+
+func renderAccount(w io.Writer, status server.Status) {
+	state := "accepting challenges"
+	switch {
+	case status.RateLimitedFor != "":
+		state = "rate limited for " + status.RateLimitedFor
+	case status.Paused:
+		state = "paused"
+	}
+	fmt.Fprintf(w, "%s%s%s  %s  games %d/%d  pending challenges %d",
+		bold, status.Username, reset, state, len(status.Games), status.MaxConcurrentGames, status.PendingChallenges)
+	if len(status.Games) == 0 {
+		fmt.Fprintf(w, "  idle %s", time.Duration(status.IdleSeconds)*time.Second)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
+
+	for _, game := range status.Games {
+		renderGame(w, game)
+		fmt.Fprintln(w)
+	}
+
+	if len(status.RecentGames) > 0 {
+		fmt.Fprintf(w, "%sRecent games%s\n", bold, reset)
+		for _, game := range status.RecentGames {
+			fmt.Fprintf(w, "  %s  %-8s %-5s vs %-20s %-6s %s\n",
+				game.Time.Format("15:04"), game.ID, game.Color, game.Opponent, game.Result, game.Status)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// renderGame draws a game's board with its details alongside.
+func renderGame(w io.Writer, game server.GameStatus) {
+	details := []string{
+		fmt.Sprintf("%s%s%s  %s vs %s (%s)", bold, game.ID, reset, game.Color, game.Opponent, game.Speed),
+		fmt.Sprintf("moves  %d", game.Moves),
+		fmt.Sprintf("white  %s", formatClock(game.WhiteTimeMs)),
+		fmt.Sprintf("black  %s", formatClock(game.BlackTimeMs)),
+	}
+	if game.Score != "" {
+		details = append(details, fmt.Sprintf("eval   %s (depth %d)", game.Score, game.Depth))
+	}
+	if game.NPS > 0 {
+		details = append(details, fmt.Sprintf("speed  %d knps", game.NPS/1000))
+	}
+
+	board := miniBoard(game.FEN, game.Color == "black")
+	for i := 0; i < len(board) || i < len(details); i++ {
+		left := strings.Repeat(" ", 15)
+		if i < len(board) {
+			left = board[i]
+		}
+		right := ""
+		if i < len(details) {
+			right = details[i]
+		}
+		fmt.Fprintf(w, "  %s   %s\n", left, right)
+	}
+}
+
+// miniBoard draws the board of a FEN as eight rows of text, from the given side's point of view. It returns nothing
+// if the FEN is missing or malformed.
+func miniBoard(fen string, flip bool) []string {
+	placement := strings.Fields(fen)
+	if len(placement) == 0 {
+		return nil
+	}
+	ranks := strings.Split(placement[0], "/")
+	if len(ranks) != 8 {
+		return nil
+	}
+
+	rows := make([]string, 0, 8)
+	for _, rank := range ranks {
+		var squares []string
+		for _, piece := range rank {
+			if piece >= '1' && piece <= '8' {
+				for i := 0; i < int(piece-'0'); i++ {
+					squares = append(squares, ".")
+				}
+				continue
+			}
+			squares = append(squares, string(piece))
+		}
+		if len(squares) != 8 {
+			return nil
+		}
+		if flip {
+			for i, j := 0, len(squares)-1; i < j; i, j = i+1, j-1 {
+				squares[i], squares[j] = squares[j], squares[i]
+			}
+		}
+		rows = append(rows, strings.Join(squares, " "))
+	}
+	if flip {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows
+}
+
+// formatClock renders a clock in milliseconds as minutes and seconds.
+func formatClock(ms int) string {
+	if ms <= 0 {
+		return "-"
+	}
+	seconds := ms / 1000
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func sortedKeys(fields log.Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
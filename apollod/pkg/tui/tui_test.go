@@ -0,0 +1,77 @@
+package tui
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+)
+
+func TestMiniBoard(t *testing.T) {
+	fen := "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2"
+	board := miniBoard(fen, false)
+	if assert.Len(t, board, 8) {
+		assert.Equal(t, "r n b q k b n r", board[0])
+		assert.Equal(t, ". . . . p . . .", board[3])
+		assert.Equal(t, "R N B Q K B N R", board[7])
+	}
+	flipped := miniBoard(fen, true)
+	if assert.Len(t, flipped, 8) {
+		assert.Equal(t, "R N B K Q B N R", flipped[0])
+		assert.Equal(t, ". . . p . . . .", flipped[4])
+	}
+	assert.Nil(t, miniBoard("", false))
+	assert.Nil(t, miniBoard("8/8/8 w - - 0 1", false))
+}
+
+func TestFormatClock(t *testing.T) {
+	assert.Equal(t, "-", formatClock(0))
+	assert.Equal(t, "1:05", formatClock(65400))
+	assert.Equal(t, "2:00:00", formatClock(7200000))
+}
+
+func TestRender(t *testing.T) {
+	var out bytes.Buffer
+	Render(&out, []server.Status{{
+		Username:           "my_bot",
+		MaxConcurrentGames: 2,
+		PendingChallenges:  1,
+		Games: []server.GameStatus{{
+			ID:          "abc",
+			Opponent:    "human",
+			Color:       "white",
+			Speed:       "blitz",
+			Moves:       2,
+			WhiteTimeMs: 60000,
+			BlackTimeMs: 59000,
+			Score:       "+0.35",
+			Depth:       10,
+			FEN:         "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2",
+		}},
+		RecentGames: []server.FinishedGame{{ID: "def", Opponent: "other", Color: "black", Result: "win", Status: "mate"}},
+	}}, []string{"12:00:00 INFO    hello"})
+
+	rendered := out.String()
+	assert.Contains(t, rendered, "games 1/2  pending challenges 1")
+	assert.Contains(t, rendered, "r n b q k b n r   \x1b[1mabc\x1b[0m  white vs human (blitz)")
+	assert.Contains(t, rendered, "eval   +0.35 (depth 10)")
+	assert.Contains(t, rendered, "vs other")
+	assert.Contains(t, rendered, "12:00:00 INFO    hello")
+}
+
+func TestLogBuffer(t *testing.T) {
+	buffer := &LogBuffer{}
+	logger := log.New()
+	logger.AddHook(buffer)
+	logger.Out = &bytes.Buffer{}
+	for i := 0; i < logLinesKept+2; i++ {
+		logger.WithField("n", i).Info("line")
+	}
+	lines := buffer.Lines()
+	if assert.Len(t, lines, logLinesKept) {
+		assert.Contains(t, lines[len(lines)-1], "INFO    line n=9")
+	}
+}
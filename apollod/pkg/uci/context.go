@@ -0,0 +1,83 @@
+package uci
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// The methods in this file are the same as the ones they're named after, except that they give up on an engine that
+// hasn't answered by the time their context is done. A blocked read can't be interrupted any other way, so the engine
+// is killed, which ends the read, and the context's error is returned at once. Transports that can't kill their engine,
+// such as remote ones, leave the read blocked until the engine answers or the client is closed. Either way, a client
+// whose engine was killed can only be closed.
+
+// NewClientContext is NewClient, giving up on an engine that doesn't finish the handshake before ctx is done.
+func NewClientContext(ctx context.Context, transport engine.Transport) (*Client, error) {
+	client := newClient(transport)
+	if err := client.withContext(ctx, client.uci); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (u *Client) IsReadyContext(ctx context.Context) error {
+	return u.withContext(ctx, u.IsReady)
+}
+
+func (u *Client) NewGameContext(ctx context.Context, chess960 bool) error {
+	return u.withContext(ctx, func() error {
+		return u.NewGame(chess960)
+	})
+}
+
+func (u *Client) SetPositionContext(ctx context.Context, fen string, moves []string) error {
+	return u.withContext(ctx, func() error {
+		return u.SetPosition(fen, moves)
+	})
+}
+
+func (u *Client) SearchContext(ctx context.Context, limits engine.Limits) (string, error) {
+	var move string
+	err := u.withContext(ctx, func() (err error) {
+		move, err = u.Search(limits)
+		return err
+	})
+	return move, err
+}
+
+func (u *Client) PonderContext(ctx context.Context, limits engine.Limits) (string, error) {
+	var move string
+	err := u.withContext(ctx, func() (err error) {
+		move, err = u.Ponder(limits)
+		return err
+	})
+	return move, err
+}
+
+// withContext runs f, which talks to the engine, killing the engine if ctx is done before f returns. It doesn't wait for
+// f to return after that, since an engine that can't be killed might never answer.
+func (u *Client) withContext(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.WithError(ctx.Err()).Warn("gave up waiting for engine, killing it")
+	canceledCalls.Inc()
+	if err := u.Kill(); err != nil {
+		log.WithError(err).Warn("failed to kill engine")
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,106 @@
+package uci
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// updateInfo folds a single "info" line into the accumulated search info. Lines that aren't "info" lines are ignored.
+// With MultiPV, only the best line (multipv 1) updates the score and principal variation, since the others are
+// worse moves that the engine reports alongside it.
+func updateInfo(i *engine.Info, line string) {
+	tokens := strings.Fields(line)
+	if len(tokens) == 0 || tokens[0] != "info" {
+		return
+	}
+
+	multipv := 1
+	var score engine.Score
+	var hasScore bool
+	var pv []string
+tokens:
+	for j := 1; j < len(tokens); j++ {
+		next := func() string {
+			if j+1 < len(tokens) {
+				j++
+				return tokens[j]
+			}
+			return ""
+		}
+
+		switch tokens[j] {
+		case "depth":
+			i.Depth = atoi(next())
+		case "seldepth":
+			i.SelDepth = atoi(next())
+		case "multipv":
+			multipv = atoi(next())
+		case "nodes":
+			i.Nodes = atoi64(next())
+		case "nps":
+			i.NPS = atoi64(next())
+		case "time":
+			i.Time = atoi(next())
+		case "hashfull":
+			i.HashFull = atoi(next())
+		case "tbhits":
+			i.TBHits = atoi64(next())
+		case "score":
+			kind, value := next(), atoi(next())
+			switch kind {
+			case "cp":
+				score, hasScore = engine.Score{Centipawns: value}, true
+			case "mate":
+				score, hasScore = engine.Score{Mate: value}, true
+			}
+		case "pv":
+			// The principal variation runs to the end of the line.
+			pv = append([]string(nil), tokens[j+1:]...)
+			break tokens
+		case "string":
+			// Free-form text runs to the end of the line.
+			break tokens
+		}
+	}
+
+	if multipv > 1 {
+		return
+	}
+	if hasScore {
+		i.Score, i.HasScore = score, true
+	}
+	if pv != nil {
+		i.PV = pv
+	}
+}
+
+// infoString returns the free-form text of an "info string" line, which runs from "string" to the end of the line,
+// and whether the line had any.
+func infoString(line string) (string, bool) {
+	tokens := strings.Fields(line)
+	if len(tokens) == 0 || tokens[0] != "info" {
+		return "", false
+	}
+	for j := 1; j < len(tokens); j++ {
+		switch tokens[j] {
+		case "pv":
+			// Nothing after the principal variation is free-form text.
+			return "", false
+		case "string":
+			return strings.Join(tokens[j+1:], " "), true
+		}
+	}
+	return "", false
+}
+
+func atoi(s string) int {
+	value, _ := strconv.Atoi(s)
+	return value
+}
+
+func atoi64(s string) int64 {
+	value, _ := strconv.ParseInt(s, 10, 64)
+	return value
+}
@@ -0,0 +1,112 @@
+package uci
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Keepalive pings the engine with isready every interval while it's idle, so that an engine that has hung is noticed
+// before anything is asked of it, rather than with a clock running. An engine that doesn't answer within deadline is
+// unhealthy, as Health reports, until it does. Engines busy with a command aren't pinged, since the command finds out
+// for itself. Calling Keepalive again replaces the previous keepalive, and an interval of zero stops it; it also stops
+// when the engine is asked to quit or is killed.
+func (u *Client) Keepalive(interval, deadline time.Duration) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.stopKeepaliveLocked()
+	u.health = nil
+	if interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	u.keepalive = stop
+	go u.keepPinging(interval, deadline, stop)
+}
+
+// Health returns nil unless the engine failed to answer the keepalive's most recent ping within its deadline, in
+// which case it says why.
+func (u *Client) Health() error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.health
+}
+
+// stopKeepaliveLocked stops the keepalive, if there is one. The caller holds the lock.
+func (u *Client) stopKeepaliveLocked() {
+	if u.keepalive != nil {
+		close(u.keepalive)
+		u.keepalive = nil
+	}
+}
+
+func (u *Client) stopKeepalive() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.stopKeepaliveLocked()
+}
+
+func (u *Client) keepPinging(interval, deadline time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := u.ping(deadline, stop); err != nil {
+			// The engine isn't going to answer any more pings.
+			return
+		}
+	}
+}
+
+// ping asks an idle engine whether it's ready, and records whether it answered in time. An answer that comes after
+// the deadline makes the engine healthy again. It returns the error that the engine answered with, if any.
+func (u *Client) ping(deadline time.Duration, stop <-chan struct{}) error {
+	select {
+	case u.turn <- struct{}{}:
+	default:
+		// Something is waiting on the engine already.
+		return nil
+	}
+	answered := make(chan error, 1)
+	go func() {
+		defer u.endTurn()
+		answered <- u.isReady()
+	}()
+
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	select {
+	case err := <-answered:
+		if err != nil {
+			keepalivePings.Inc("error")
+		} else {
+			keepalivePings.Inc("ok")
+		}
+		u.setHealth(err, stop)
+		return err
+	case <-timer.C:
+	}
+	keepalivePings.Inc("late")
+	log.WithFields(log.Fields{
+		"engine":   u.name,
+		"deadline": deadline,
+	}).Warn("engine did not answer keepalive in time, flagging it unhealthy")
+	u.setHealth(errors.Errorf("engine %s did not answer isready within %s", u.name, deadline), stop)
+	err := <-answered
+	u.setHealth(err, stop)
+	return err
+}
+
+// setHealth records the result of a ping, unless the keepalive that sent it has been stopped since.
+func (u *Client) setHealth(err error, stop <-chan struct{}) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.keepalive == stop {
+		u.health = err
+	}
+}
@@ -0,0 +1,29 @@
+package uci
+
+import (
+	"github.com/swgillespie/apollo/apollod/pkg/metrics"
+)
+
+var (
+	uciMetrics = metrics.NewSubsystem("uci")
+
+	handshakeLatency = uciMetrics.NewHistogram(
+		"handshake_seconds",
+		"Time engines took to identify themselves and send uciok.",
+		metrics.DefaultLatencyBuckets)
+	searches = uciMetrics.NewCounter(
+		"searches_total",
+		"Number of searches sent to engines, by result (move, none if the engine had no move to play, illegal if its move failed the client's move checker, or error if it never sent a best move).",
+		"result")
+	unexpectedResponses = uciMetrics.NewCounter(
+		"unexpected_responses_total",
+		"Number of responses from engines that don't follow the protocol, by the command they answered.",
+		"command")
+	keepalivePings = uciMetrics.NewCounter(
+		"keepalive_pings_total",
+		"Number of keepalive pings sent to idle engines, by result (ok, late if the engine missed the deadline, or error).",
+		"result")
+	canceledCalls = uciMetrics.NewCounter(
+		"canceled_calls_total",
+		"Number of times an engine was killed because it hadn't answered by the time the caller gave up on it.")
+)
@@ -1,128 +1,223 @@
 package uci
 
 import (
-	"bufio"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
 )
 
+// chess960Option is the standard UCI option that switches an engine into chess960 mode.
+const chess960Option = "UCI_Chess960"
+
+// maxInfoStrings is the number of info strings that a client keeps until they're read; older ones are dropped.
+const maxInfoStrings = 100
+
 var (
 	idNameRegex   = regexp.MustCompile(`id name (.*)`)
 	idAuthorRegex = regexp.MustCompile(`id author (.*)`)
 	optionRegex   = regexp.MustCompile(`option (.*)`)
+	optionName    = regexp.MustCompile(`^option name (.*?) type (.*)$`)
 	uciOkRegex    = regexp.MustCompile(`uciok`)
-	readyOkRegex  = regexp.MustCompile(`readyok`)
-	bestmoveRegex = regexp.MustCompile(`bestmove (.*)`)
+	bestmoveRegex = regexp.MustCompile(`bestmove (\S+)(?: ponder (\S+))?`)
 )
 
-type Transport interface {
-	io.Closer
+// noMoves are what engines send as their best move in positions without any legal moves.
+var noMoves = map[string]bool{"(none)": true, "0000": true}
 
-	Send(msg string) error
-	Recv() (string, error)
+// Client is a wrapper over an input and output stream that speaks the UCI protocol.
+// The intention is to use UCI client alongside a UCI-compliant server to instruct the server to search for moves and
+// otherwise play the game of chess.
+//
+// See http://wbec-ridderkerk.nl/html/UCIProtocol.html for details on the protocol itself.
+//
+// Clients may be used from many goroutines at once. Commands that wait for an answer, such as IsReady and Search, take
+// turns in the order they were made, so that only one goroutine reads from the engine at a time; commands that don't,
+// such as Stop and PonderHit, are sent right away. IsReady during a search is answered without waiting for the search
+// to end, so that a ponder search can be checked on.
+type Client struct {
+	transport engine.Transport
+
+	name    string
+	author  string
+	options []engine.Option
+
+	// turn is held by the command that's waiting for the engine's answer. Goroutines waiting for their turn are let
+	// through in the order they arrived.
+	turn chan struct{}
+	// lock serializes writes to the engine and guards everything below.
+	lock sync.Mutex
+	// searching is set while a search is reading from the engine, which hands any readyok it reads to the oldest of
+	// readyWaiters, or the error that ended the search to all of them.
+	searching    bool
+	readyWaiters []chan error
+
+	lastInfo engine.Info
+	onInfo   func(engine.Info)
+	// gameStats sums up the searches since the last NewGame.
+	gameStats engine.SearchStats
+	// infoStrings are the engine's diagnostics, from "info string" lines, that haven't been read yet.
+	infoStrings  []string
+	onInfoString func(string)
+	// The reply that the engine expected to its most recent move, if it said.
+	ponderMove string
+	// chess960 is set once the engine has been switched into chess960 mode.
+	chess960 bool
+	// The name and code that the engine is registered with when it asks, if it was given any, and whether they've
+	// been sent since.
+	registrationName string
+	registrationCode string
+	registered       bool
+
+	// checkMove, if set, judges every best move before it's returned.
+	checkMove engine.MoveChecker
+
+	// keepalive is closed to stop the keepalive, if there is one. health is the error that its most recent ping ended
+	// with.
+	keepalive chan struct{}
+	health    error
+
+	// The position most recently sent to the engine, for incremental position updates.
+	incremental  bool
+	sentPosition string
+	sentMoves    []string
 }
 
-type popenTransport struct {
-	process *exec.Cmd
-	in      io.Writer
-	out     *bufio.Scanner
+func NewClient(transport engine.Transport) (*Client, error) {
+	client := newClient(transport)
+
+	if err := client.uci(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
 }
 
-func (p *popenTransport) Close() error {
-	return p.process.Wait()
+func newClient(transport engine.Transport) *Client {
+	return &Client{
+		transport: transport,
+		turn:      make(chan struct{}, 1),
+	}
 }
 
-func (p *popenTransport) Send(msg string) error {
-	_, err := p.in.Write([]byte(msg + "\n"))
-	return err
+func init() {
+	engine.Register("uci", func(transport engine.Transport) (engine.Engine, error) {
+		client, err := NewClient(transport)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
 }
 
-func (p *popenTransport) Recv() (string, error) {
-	if !p.out.Scan() {
-		return "", io.EOF
-	}
+func (u *Client) Name() string   { return u.name }
+func (u *Client) Author() string { return u.author }
 
-	return p.out.Text(), nil
-}
+// Options returns the options that the engine declared during the handshake.
+func (u *Client) Options() []engine.Option { return u.options }
 
-func (p *popenTransport) LogStderr() error {
-	stderr, err := p.process.StderrPipe()
-	if err != nil {
-		return err
-	}
+// HasOption returns true if the engine declared an option with the given name. Option names are case-insensitive.
+func (u *Client) HasOption(name string) bool {
+	_, ok := engine.LookupOption(u.options, name)
+	return ok
+}
 
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			log.WithField("source", "apollo").Info(scanner.Text())
-		}
-	}()
-	return nil
+// Info returns everything that the engine reported about its search during the most recent call to Search. During a
+// search, it's what the engine has reported so far.
+func (u *Client) Info() engine.Info {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.lastInfo
 }
 
-func NewProgramTransport(programPath string) (Transport, error) {
-	log.WithField("program", programPath).Info("launching new program")
-	cmd := exec.Command(programPath)
-	cmd.Env = append(os.Environ(), "RUST_LOG=info")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
+// GameStats sums up what the engine reported at the end of every search that found a move since the last call to
+// NewGame, so that its average depth, speed, hash usage, and tablebase hits over a game can be told apart.
+func (u *Client) GameStats() engine.SearchStats {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.gameStats
+}
 
-	trans := &popenTransport{
-		process: cmd,
-		in:      stdin,
-		out:     bufio.NewScanner(stdout),
-	}
+// OnInfo registers a function to call with everything the engine has reported so far, each time it sends an "info"
+// line during a search.
+func (u *Client) OnInfo(f func(engine.Info)) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.onInfo = f
+}
 
-	trans.LogStderr()
-	if err := cmd.Start(); err != nil {
-		return nil, err
+// SetDebug switches the engine's debug mode on or off. Engines in debug mode send more diagnostics, as info strings.
+func (u *Client) SetDebug(enabled bool) error {
+	if enabled {
+		return u.send("debug on")
 	}
-	return trans, nil
+	return u.send("debug off")
 }
 
-// Client is a wrapper over an input and output stream that speaks the UCI protocol.
-// The intention is to use UCI client alongside a UCI-compliant server to instruct the server to search for moves and
-// otherwise play the game of chess.
-//
-// See http://wbec-ridderkerk.nl/html/UCIProtocol.html for details on the protocol itself.
-type Client struct {
-	transport Transport
+// InfoStrings returns the diagnostics that the engine has sent as "info string" lines since the last call, up to the
+// most recent hundred.
+func (u *Client) InfoStrings() []string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	texts := u.infoStrings
+	u.infoStrings = nil
+	return texts
+}
 
-	name   string
-	author string
+// OnInfoString registers a function to call with the text of every "info string" line the engine sends, whenever it
+// sends one, on the goroutine reading from the engine.
+func (u *Client) OnInfoString(f func(string)) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.onInfoString = f
 }
 
-func NewClient(transport Transport) (*Client, error) {
-	client := &Client{
-		transport: transport,
-		name:      "",
-		author:    "",
+// captureInfoString keeps the text of an "info string" line, returning false if the line isn't one.
+func (u *Client) captureInfoString(line string) bool {
+	text, ok := infoString(line)
+	if !ok {
+		return false
 	}
-
-	if err := client.uci(); err != nil {
-		client.Close()
-		return nil, err
+	log.WithField("engine", u.name).Debug("info string: " + text)
+	u.lock.Lock()
+	if len(u.infoStrings) == maxInfoStrings {
+		u.infoStrings = u.infoStrings[1:]
 	}
-	return client, nil
+	u.infoStrings = append(u.infoStrings, text)
+	onInfoString := u.onInfoString
+	u.lock.Unlock()
+	if onInfoString != nil {
+		onInfoString(text)
+	}
+	return true
 }
 
-func (u *Client) Name() string   { return u.name }
-func (u *Client) Author() string { return u.author }
+// waitTurn waits until every command that was made before this one has had its answer.
+func (u *Client) waitTurn() {
+	u.turn <- struct{}{}
+}
+
+func (u *Client) endTurn() {
+	<-u.turn
+}
+
+// send writes a line to the engine, between whole lines written by other goroutines.
+func (u *Client) send(msg string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.transport.Send(msg)
+}
 
 func (u *Client) uci() error {
+	start := time.Now()
+	engine.Expect(u.transport, engine.WaitHandshake)
 	if err := u.transport.Send("uci"); err != nil {
 		return err
 	}
@@ -138,44 +233,292 @@ func (u *Client) uci() error {
 			return err
 		}
 
+		if ok, err := u.checkStatus(line); ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		switch {
+		case u.captureInfoString(line):
+			// Engines say what they're loading, such as network files, before they're done.
 		case idNameRegex.MatchString(line):
 			u.name = idNameRegex.FindStringSubmatch(line)[1]
 		case idAuthorRegex.MatchString(line):
 			u.author = idAuthorRegex.FindStringSubmatch(line)[1]
 		case optionRegex.MatchString(line):
-			// pass, nothing interesting to do here for now. Apollo doesn't
-			// send this.
+			// Apollo doesn't send this, but other engines do; remember which options the engine supports.
+			if option, ok := parseOption(line); ok {
+				u.options = append(u.options, option)
+			}
 		case uciOkRegex.MatchString(line):
+			handshakeLatency.ObserveSince(start)
 			return nil
 		default:
 			// Apollo doesn't send anything other than these.
+			unexpectedResponses.Inc("uci")
 			return errors.Errorf("unexpected 'uci' response: %s", line)
 		}
 	}
 }
 
+// optionFields are the words that start each part of an option declaration after its type.
+var optionFields = map[string]bool{"default": true, "min": true, "max": true, "var": true}
+
+// parseOption parses an option declaration, such as "option name Hash type spin default 16 min 1 max 1024". Values
+// may contain spaces, so each runs up to the next field's name; "<empty>" is the empty string. Spin bounds that
+// aren't numbers are ignored.
+func parseOption(line string) (engine.Option, bool) {
+	matches := optionName.FindStringSubmatch(line)
+	if matches == nil {
+		return engine.Option{}, false
+	}
+	words := strings.Fields(matches[2])
+	if len(words) == 0 {
+		return engine.Option{}, false
+	}
+	option := engine.Option{Name: matches[1], Type: words[0]}
+	for i := 1; i < len(words); {
+		field := words[i]
+		end := i + 1
+		for end < len(words) && !optionFields[words[end]] {
+			end++
+		}
+		value := strings.Join(words[i+1:end], " ")
+		if value == "<empty>" {
+			value = ""
+		}
+		switch field {
+		case "default":
+			option.Default = value
+		case "min":
+			option.Min, _ = strconv.Atoi(value)
+		case "max":
+			option.Max, _ = strconv.Atoi(value)
+		case "var":
+			option.Vars = append(option.Vars, value)
+		}
+		i = end
+	}
+	return option, true
+}
+
+// IsReady waits until the engine has caught up with everything it has been sent. During a search, the search hands
+// over the engine's answer.
 func (u *Client) IsReady() error {
-	if err := u.transport.Send("isready"); err != nil {
-		return err
+	u.lock.Lock()
+	if u.searching {
+		ready := make(chan error, 1)
+		if err := u.transport.Send("isready"); err != nil {
+			u.lock.Unlock()
+			return err
+		}
+		u.readyWaiters = append(u.readyWaiters, ready)
+		u.lock.Unlock()
+		return <-ready
 	}
+	u.lock.Unlock()
 
-	line, err := u.transport.Recv()
-	if err != nil {
+	u.waitTurn()
+	defer u.endTurn()
+	return u.isReady()
+}
+
+// isReady sends isready and waits for the answer. The caller holds the turn.
+func (u *Client) isReady() error {
+	engine.Expect(u.transport, engine.WaitReply)
+	if err := u.send("isready"); err != nil {
 		return err
 	}
 
-	if line != "readyok" {
-		return errors.Errorf("unexpected 'isready' response: %s", line)
+	for {
+		line, err := u.transport.Recv()
+		if err != nil {
+			return err
+		}
+
+		if ok, err := u.checkStatus(line); ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		switch {
+		case line == "readyok":
+			return nil
+		case u.captureInfoString(line):
+			// Engines often report on the options they've just been sent before they're ready.
+		default:
+			unexpectedResponses.Inc("isready")
+			return errors.Errorf("unexpected 'isready' response: %s", line)
+		}
 	}
-	return nil
 }
 
-func (u *Client) UCINewGame() error {
+// Raw sends a command that UCI doesn't define, such as the "d", "eval", or "perft" that many engines add, and returns
+// every line the engine sends in answer, up to and including the first that matches until. The engine is given as long
+// to answer as it is to search, since commands such as perft can run for a while. Commands with an answer wait for
+// their turn, like IsReady and Search, so that no other command reads the answer. A nil until is for commands that the
+// engine doesn't answer, which, like Stop, are sent right away, even during a search, since there's nothing to read.
+func (u *Client) Raw(cmd string, until *regexp.Regexp) ([]string, error) {
+	if until == nil {
+		return nil, u.send(cmd)
+	}
+	u.waitTurn()
+	defer u.endTurn()
+
+	engine.Expect(u.transport, engine.WaitSearch)
+	if err := u.send(cmd); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := u.transport.Recv()
+		if err != nil {
+			return lines, errors.Wrapf(err, "engine stopped answering %q", cmd)
+		}
+		lines = append(lines, line)
+		if until.MatchString(line) {
+			return lines, nil
+		}
+	}
+}
+
+// SetRegistration sets the name and code that the engine is registered with if it says that it isn't registered.
+// Without them, engines that ask are told that they'll be registered later, which they may answer by playing weaker
+// or limiting what they do.
+func (u *Client) SetRegistration(name, code string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.registrationName, u.registrationCode, u.registered = name, code, false
+}
+
+// checkStatus follows the copy protection and registration checks that commercial engines run after the handshake,
+// returning true if the line was part of one. An engine that fails its copy protection check won't play properly, so
+// it's an error, as is one that rejects its registration. An engine that isn't registered is registered, if it can be,
+// or told that it will be later.
+func (u *Client) checkStatus(line string) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || (fields[0] != "copyprotection" && fields[0] != "registration") {
+		return false, nil
+	}
+	switch {
+	case fields[1] != "error":
+		log.WithField("engine", u.name).Debug(line)
+		return true, nil
+	case fields[0] == "copyprotection":
+		return true, errors.Errorf("engine %s failed its copy protection check", u.name)
+	}
+
+	u.lock.Lock()
+	name, code, registered := u.registrationName, u.registrationCode, u.registered
+	u.registered = name != ""
+	u.lock.Unlock()
+	switch {
+	case registered:
+		return true, errors.Errorf("engine %s rejected its registration", u.name)
+	case name == "":
+		log.WithField("engine", u.name).Warn("engine is not registered, registering it later")
+		return true, u.send("register later")
+	default:
+		return true, u.send(fmt.Sprintf("register name %s code %s", name, code))
+	}
+}
+
+// SetOption sets one of the engine's options. Options without a value, such as buttons, are sent without one.
+func (u *Client) SetOption(name, value string) error {
+	if value == "" {
+		return u.send(fmt.Sprintf("setoption name %s", name))
+	}
+	return u.send(fmt.Sprintf("setoption name %s value %s", name, value))
+}
+
+// NewGame tells the engine that the next search is from a different game. Engines for chess960 games are first
+// switched into chess960 mode with the standard UCI_Chess960 option, which engines that declare their options must
+// support, and switched back out of it for the next game that isn't. In chess960 mode, engines castle by moving the
+// king onto its own rook, such as e1h1, as lichess does in chess960 games.
+func (u *Client) NewGame(chess960 bool) error {
+	if chess960 && len(u.options) > 0 && !u.HasOption(chess960Option) {
+		return errors.Errorf("engine %s does not support chess960", u.name)
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if chess960 != u.chess960 {
+		if err := u.transport.Send(fmt.Sprintf("setoption name %s value %t", chess960Option, chess960)); err != nil {
+			return err
+		}
+		u.chess960 = chess960
+	}
+	u.sentPosition, u.sentMoves = "", nil
+	u.gameStats = engine.SearchStats{}
 	return u.transport.Send("ucinewgame")
 }
 
-func (u *Client) Position(position string, moves []string) error {
+// SetMoveChecker sets a function to judge every best move against the position it was searched in, so that a move that
+// can't be played is returned as an error rather than as the engine's move. Nil checks nothing.
+func (u *Client) SetMoveChecker(check engine.MoveChecker) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.checkMove = check
+}
+
+// SetIncremental enables incremental position updates. Engines that keep their position between searches can be
+// told about just the moves played since the last position command, as "position moves ...", instead of being sent
+// the entire game every move. This isn't part of UCI, so engines must opt in to it.
+func (u *Client) SetIncremental(enabled bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.incremental = enabled
+}
+
+// Position sets the position to search to a FEN, with no moves played from it. A malformed FEN, including an empty
+// one, is refused with a descriptive error without sending anything.
+func (u *Client) Position(fen string) error {
+	if err := engine.ValidateFEN(fen); err != nil {
+		return err
+	}
+	return u.SetPosition(fen, nil)
+}
+
+// PositionStart sets the position to search to the standard starting position.
+func (u *Client) PositionStart() error {
+	return u.SetPosition("", nil)
+}
+
+// SetPosition sets the position to search, given as a FEN (or empty for the standard starting position) and the
+// moves played from there. A malformed FEN is refused without sending anything. With incremental updates enabled,
+// only moves that extend the position last sent are sent; if the game doesn't extend it, for example after a takeback,
+// the whole position is sent again.
+func (u *Client) SetPosition(fen string, moves []string) error {
+	position := "startpos"
+	if fen != "" {
+		if err := engine.ValidateFEN(fen); err != nil {
+			return err
+		}
+		position = "fen " + fen
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.incremental && u.sentPosition == position && extends(moves, u.sentMoves) {
+		added := moves[len(u.sentMoves):]
+		if len(added) == 0 {
+			// The engine is already in this position; searching doesn't change it.
+			return nil
+		}
+		if err := u.transport.Send("position moves " + strings.Join(added, " ")); err != nil {
+			u.sentPosition, u.sentMoves = "", nil
+			return err
+		}
+		u.sentMoves = append(u.sentMoves, added...)
+		return nil
+	}
+	if u.incremental && u.sentPosition != "" {
+		log.WithFields(log.Fields{
+			"sent": strings.Join(u.sentMoves, " "),
+			"game": strings.Join(moves, " "),
+		}).Warn("game does not extend the engine's position, resending it")
+	}
+
 	var command string
 	if len(moves) > 0 {
 		command = fmt.Sprintf("position %s moves %s", position, strings.Join(moves, " "))
@@ -183,41 +526,237 @@ func (u *Client) Position(position string, moves []string) error {
 		command = fmt.Sprintf("position %s", position)
 	}
 
-	return u.transport.Send(command)
+	u.sentPosition, u.sentMoves = "", nil
+	if err := u.transport.Send(command); err != nil {
+		return err
+	}
+	u.sentPosition, u.sentMoves = position, append([]string(nil), moves...)
+	return nil
+}
+
+// extends returns true if moves begins with prefix.
+func extends(moves, prefix []string) bool {
+	if len(moves) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if moves[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Search sends a go command with the given limits and waits for the engine's best move. Engines answer a search of a
+// position without legal moves with "bestmove (none)" or "bestmove 0000", for which it returns engine.ErrNoMove. A best
+// move that fails the client's move checker is returned as an *engine.IllegalMove.
+func (u *Client) Search(limits engine.Limits) (string, error) {
+	result, err := u.Go(limits)
+	return result.Move, err
 }
 
-func (u *Client) Go(wtime, btime, winc, binc int) (string, error) {
-	command := fmt.Sprintf("go wtime %d winc %d btime %d binc %d", wtime, winc, btime, binc)
+// Go is Search, returning the engine's ponder move and its final report on the search along with its move.
+func (u *Client) Go(limits engine.Limits) (engine.SearchResult, error) {
+	return u.search(goCommand("go", limits))
+}
+
+// Ponder searches on the opponent's time, in a position that ends with the reply the engine expects, as told by
+// PonderMove. It sends a go ponder command with the limits that the engine will have if the opponent plays that
+// reply, and waits for the engine's best move. The engine doesn't move until it's told either that the reply was
+// played, by PonderHit, after which it keeps searching as though it had been sent the limits all along, or that it
+// wasn't, by Stop, after which its move should be thrown away.
+func (u *Client) Ponder(limits engine.Limits) (string, error) {
+	result, err := u.search(goCommand("go ponder", limits))
+	return result.Move, err
+}
+
+// PonderHit tells a pondering engine that the opponent played the reply it expected.
+func (u *Client) PonderHit() error {
+	return u.send("ponderhit")
+}
+
+// PonderMove returns the reply that the engine expected to the move it found in its most recent search, if it said.
+func (u *Client) PonderMove() string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.ponderMove
+}
+
+// goCommand appends limits to a go command.
+func goCommand(command string, limits engine.Limits) string {
+	if len(limits.SearchMoves) > 0 {
+		command += " searchmoves " + strings.Join(limits.SearchMoves, " ")
+	}
+	switch {
+	case limits.Infinite:
+		// The engine searches until it's told to stop, and only then sends its best move.
+		command += " infinite"
+	case limits.Depth > 0 || limits.Nodes > 0:
+		if limits.Depth > 0 {
+			command += fmt.Sprintf(" depth %d", limits.Depth)
+		}
+		if limits.Nodes > 0 {
+			command += fmt.Sprintf(" nodes %d", limits.Nodes)
+		}
+	case limits.MoveTime > 0:
+		// A fixed move time leaves time management entirely to us.
+		command += fmt.Sprintf(" movetime %d", millis(limits.MoveTime))
+	default:
+		command += fmt.Sprintf(" wtime %d winc %d btime %d binc %d",
+			millis(limits.WhiteTime), millis(limits.WhiteIncrement), millis(limits.BlackTime), millis(limits.BlackIncrement))
+		if limits.MovesToGo > 0 {
+			// The clock resets after movestogo more moves, or the engine should plan to spread its time over that many.
+			command += fmt.Sprintf(" movestogo %d", limits.MovesToGo)
+		}
+	}
+	return command
+}
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// search sends a go command and waits for the engine's best move.
+func (u *Client) search(command string) (engine.SearchResult, error) {
+	u.waitTurn()
+	defer u.endTurn()
+
+	engine.Expect(u.transport, engine.WaitSearch)
+	u.lock.Lock()
 	if err := u.transport.Send(command); err != nil {
-		return "", err
+		u.lock.Unlock()
+		return engine.SearchResult{}, err
+	}
+	u.lastInfo, u.ponderMove = engine.Info{}, ""
+	u.searching = true
+	fen, moves, check := strings.TrimPrefix(u.sentPosition, "fen "), u.sentMoves, u.checkMove
+	u.lock.Unlock()
+	if fen == "startpos" {
+		fen = ""
 	}
 
 	// In response, the server will begin sending a BUNCH of stuff, most of which we don't care about.
-	// We care about "bestmove", since this is the engine telling us what move it makes.
+	// We care about "bestmove", since this is the engine telling us what move it makes, and the "info" lines, which
+	// tell us about the engine's search and its evaluation of the position.
+	move, ponder, output := "", "", ""
 	for {
+		if move != "" && u.doneSearching() {
+			if noMoves[move] {
+				searches.Inc("none")
+				return engine.SearchResult{}, engine.ErrNoMove
+			}
+			if check != nil {
+				if err := check(fen, moves, move); err != nil {
+					searches.Inc("illegal")
+					illegal := &engine.IllegalMove{Position: fen, Moves: moves, Move: move, Output: output, Reason: err}
+					return engine.SearchResult{}, illegal
+				}
+			}
+			searches.Inc("move")
+			u.lock.Lock()
+			info := u.lastInfo
+			u.gameStats.Add(info)
+			u.lock.Unlock()
+			return engine.SearchResult{Move: move, Ponder: ponder, Info: info}, nil
+		}
 		line, err := u.transport.Recv()
 		if err != nil {
-			return "", err
+			u.failSearch(err)
+			searches.Inc("error")
+			return engine.SearchResult{}, err
 		}
 
+		if ok, err := u.checkStatus(line); ok {
+			if err != nil {
+				u.failSearch(err)
+				searches.Inc("error")
+				return engine.SearchResult{}, err
+			}
+			continue
+		}
 		switch {
+		case move != "":
+			// The best move is in, and the search is only waiting for the engine to answer isready.
+			if line == "readyok" {
+				u.ready()
+			}
 		case bestmoveRegex.MatchString(line):
-			move := bestmoveRegex.FindStringSubmatch(line)[1]
-			return move, nil
+			matches := bestmoveRegex.FindStringSubmatch(line)
+			u.lock.Lock()
+			u.ponderMove = matches[2]
+			u.lock.Unlock()
+			move, ponder, output = matches[1], matches[2], line
+		case strings.HasPrefix(line, "info "):
+			if u.captureInfoString(line) && strings.HasPrefix(line, "info string ") {
+				// The line has nothing to say about the search.
+				continue
+			}
+			u.lock.Lock()
+			updateInfo(&u.lastInfo, line)
+			info, onInfo := u.lastInfo, u.onInfo
+			u.lock.Unlock()
+			if onInfo != nil {
+				onInfo(info)
+			}
+		case line == "readyok":
+			u.ready()
 		default:
 			// Roll with anything that's not bestmove.
 		}
 	}
 }
 
+// ready hands a readyok read during a search to the IsReady that has waited longest for it.
+func (u *Client) ready() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if len(u.readyWaiters) == 0 {
+		unexpectedResponses.Inc("go")
+		return
+	}
+	u.readyWaiters[0] <- nil
+	u.readyWaiters = u.readyWaiters[1:]
+}
+
+// doneSearching ends a search that has its best move, unless an IsReady made during it is still waiting for its
+// answer.
+func (u *Client) doneSearching() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if len(u.readyWaiters) > 0 {
+		return false
+	}
+	u.searching = false
+	return true
+}
+
+// failSearch ends a search that the engine stopped answering, along with any IsReady waiting on it.
+func (u *Client) failSearch(err error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, ready := range u.readyWaiters {
+		ready <- err
+	}
+	u.searching, u.readyWaiters = false, nil
+}
+
 func (u *Client) Stop() error {
-	return u.transport.Send("stop")
+	return u.send("stop")
 }
 
 func (u *Client) Quit() error {
-	return u.transport.Send("quit")
+	u.stopKeepalive()
+	return u.send("quit")
 }
 
 func (u *Client) Close() error {
+	u.stopKeepalive()
 	return u.transport.Close()
 }
+
+// Kill forcibly terminates an engine that isn't responding to commands. Transports that can't terminate their engine
+// do nothing.
+func (u *Client) Kill() error {
+	u.stopKeepalive()
+	return engine.Kill(u.transport)
+}
@@ -1,41 +1,22 @@
 package uci
 
 import (
-	"io"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
-)
-
-type MockTransport struct {
-	Server func(m *MockTransport, msg string) error
-
-	responses []string
-}
 
-func (m *MockTransport) Send(msg string) error {
-	return m.Server(m, msg)
-}
-
-func (m *MockTransport) Recv() (string, error) {
-	if len(m.responses) == 0 {
-		return "", io.EOF
-	}
-
-	first, rest := m.responses[0], m.responses[1:]
-	m.responses = rest
-	return first, nil
-}
-
-func (m *MockTransport) Respond(msg string) {
-	m.responses = append(m.responses, msg)
-}
-
-func (m *MockTransport) Close() error { return nil }
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
 
 func TestUCIHandshake(t *testing.T) {
-	trans := &MockTransport{
-		Server: func(m *MockTransport, msg string) error {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
 			assert.Equal(t, msg, "uci")
 			m.Respond("id name apollo 0.3.0")
 			m.Respond("id author Sean Gillespie <sean@swgillespie.me>")
@@ -53,8 +34,8 @@ func TestUCIHandshake(t *testing.T) {
 }
 
 func TestIsReady(t *testing.T) {
-	trans := &MockTransport{
-		Server: func(m *MockTransport, msg string) error {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
 			if msg == "uci" {
 				assert.Equal(t, msg, "uci")
 				m.Respond("id name apollo 0.3.0")
@@ -78,8 +59,8 @@ func TestIsReady(t *testing.T) {
 }
 
 func TestUciNewgame(t *testing.T) {
-	trans := &MockTransport{
-		Server: func(m *MockTransport, msg string) error {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
 			if msg == "uci" {
 				assert.Equal(t, msg, "uci")
 				m.Respond("id name apollo 0.3.0")
@@ -97,13 +78,13 @@ func TestUciNewgame(t *testing.T) {
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
-	err = client.UCINewGame()
+	err = client.NewGame(false)
 	assert.NoError(t, err)
 }
 
 func TestPosition(t *testing.T) {
-	trans := &MockTransport{
-		Server: func(m *MockTransport, msg string) error {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
 			if msg == "uci" {
 				assert.Equal(t, msg, "uci")
 				m.Respond("id name apollo 0.3.0")
@@ -121,13 +102,13 @@ func TestPosition(t *testing.T) {
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
-	err = client.Position("startpos", nil)
+	err = client.SetPosition("", nil)
 	assert.NoError(t, err)
 }
 
 func TestPositionMoves(t *testing.T) {
-	trans := &MockTransport{
-		Server: func(m *MockTransport, msg string) error {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
 			if msg == "uci" {
 				assert.Equal(t, msg, "uci")
 				m.Respond("id name apollo 0.3.0")
@@ -145,13 +126,59 @@ func TestPositionMoves(t *testing.T) {
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
-	err = client.Position("startpos", []string{"e2e4", "e7e6"})
+	err = client.SetPosition("", []string{"e2e4", "e7e6"})
 	assert.NoError(t, err)
 }
 
+func TestPositionInvalidFEN(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+				return nil
+			}
+			t.Errorf("unexpected message %q", msg)
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	err = client.SetPosition("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - 0 1", nil)
+	assert.EqualError(t, err, `invalid FEN "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - 0 1": side to move must be w or b, not "x"`)
+}
+
+func TestPositionFEN(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+				return nil
+			}
+			sent = append(sent, msg)
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, client.Position("8/8/8/4k3/8/8/4P3/4K3 w - - 0 1"))
+	assert.NoError(t, client.PositionStart())
+	assert.EqualError(t, client.Position(""), `invalid FEN "": expected between 4 and 7 fields, found 0`)
+	assert.EqualError(t, client.Position("8/8/8/4k3/8/8/4P3/4K3 w - e9 0 1"), `invalid FEN "8/8/8/4k3/8/8/4P3/4K3 w - e9 0 1": malformed en passant square "e9"`)
+	assert.Equal(t, []string{"position fen 8/8/8/4k3/8/8/4P3/4K3 w - - 0 1", "position startpos"}, sent)
+}
+
 func TestGo(t *testing.T) {
-	trans := &MockTransport{
-		Server: func(m *MockTransport, msg string) error {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
 			if msg == "uci" {
 				assert.Equal(t, msg, "uci")
 				m.Respond("id name apollo 0.3.0")
@@ -171,7 +198,792 @@ func TestGo(t *testing.T) {
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
-	bestmove, err := client.Go(5, 5, 0, 0)
+	bestmove, err := client.Search(engine.Limits{WhiteTime: 5 * time.Millisecond, BlackTime: 5 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e4", bestmove)
+}
+
+func TestGoMoveTime(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+				return nil
+			}
+
+			assert.Equal(t, "go movetime 1500", msg)
+			m.Respond("bestmove e2e4")
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	bestmove, err := client.Search(engine.Limits{MoveTime: 1500 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e4", bestmove)
+}
+
+func TestGoDepth(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+				return nil
+			}
+
+			assert.Equal(t, "go depth 12", msg)
+			m.Respond("bestmove e2e4")
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	bestmove, err := client.Search(engine.Limits{Depth: 12, MoveTime: time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e4", bestmove)
+}
+
+func TestGoNodesSearchMoves(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+				return nil
+			}
+
+			assert.Equal(t, "go searchmoves d2d4 c2c4 depth 20 nodes 100000", msg)
+			m.Respond("bestmove d2d4")
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	bestmove, err := client.Search(engine.Limits{Depth: 20, Nodes: 100000, SearchMoves: []string{"d2d4", "c2c4"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "d2d4", bestmove)
+}
+
+func TestGoNoMove(t *testing.T) {
+	for _, reply := range []string{"bestmove (none)", "bestmove 0000"} {
+		trans := &ucitest.Transport{
+			Server: func(m *ucitest.Transport, msg string) error {
+				if msg == "uci" {
+					m.Respond("id name apollo 0.3.0")
+					m.Respond("uciok")
+					return nil
+				}
+				m.Respond("info depth 0 score mate 0")
+				m.Respond(reply)
+				return nil
+			},
+		}
+
+		client, err := NewClient(trans)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		bestmove, err := client.Search(engine.Limits{MoveTime: time.Second})
+		assert.Equal(t, engine.ErrNoMove, err, reply)
+		assert.Equal(t, "", bestmove)
+	}
+}
+
+func TestIllegalMove(t *testing.T) {
+	fake := &ucitest.Engine{BestMove: func(position string, moves []string) string {
+		if len(moves) == 1 {
+			return "e7e4"
+		}
+		return ucitest.FirstLegalMove(position, moves)
+	}}
+	client, err := NewClient(fake)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+	client.SetMoveChecker(engine.LegalMove)
+
+	assert.NoError(t, client.SetPosition("", nil))
+	move, err := client.Search(engine.Limits{MoveTime: time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, ucitest.FirstLegalMove("startpos", nil), move)
+
+	assert.NoError(t, client.SetPosition("", []string{"e2e4"}))
+	_, err = client.Search(engine.Limits{MoveTime: time.Millisecond})
+	illegal, ok := engine.AsIllegalMove(err)
+	if assert.True(t, ok, "%v", err) {
+		assert.Equal(t, "", illegal.Position)
+		assert.Equal(t, []string{"e2e4"}, illegal.Moves)
+		assert.Equal(t, "e7e4", illegal.Move)
+		assert.Equal(t, "bestmove e7e4", illegal.Output)
+	}
+	assert.EqualError(t, err, `engine played illegal move e7e4 in position startpos moves e2e4 ("bestmove e7e4"): e7e4 is not a legal move`)
+}
+
+func TestInfoStrings(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			sent = append(sent, msg)
+			switch msg {
+			case "uci":
+				m.Respond("id name stockfish")
+				m.Respond("info string loading network")
+				m.Respond("uciok")
+			case "isready":
+				m.Respond("info string NNUE evaluation enabled")
+				m.Respond("readyok")
+			case "go depth 2":
+				m.Respond("info string searching")
+				m.Respond("info depth 2 score cp 15 string aspiration window widened")
+				m.Respond("bestmove e2e4")
+			}
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, client.IsReady())
+	assert.Equal(t, []string{"loading network", "NNUE evaluation enabled"}, client.InfoStrings())
+	assert.Empty(t, client.InfoStrings())
+
+	var reported []string
+	client.OnInfoString(func(text string) { reported = append(reported, text) })
+	assert.NoError(t, client.SetDebug(true))
+	_, err = client.Search(engine.Limits{Depth: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"searching", "aspiration window widened"}, reported)
+	assert.Equal(t, 2, client.Info().Depth)
+	assert.Equal(t, []string{"uci", "isready", "debug on", "go depth 2"}, sent)
+}
+
+func TestRegistration(t *testing.T) {
+	newTransport := func(sent *[]string, accept string) *ucitest.Transport {
+		checked := false
+		return &ucitest.Transport{
+			Server: func(m *ucitest.Transport, msg string) error {
+				*sent = append(*sent, msg)
+				switch {
+				case msg == "uci":
+					m.Respond("id name commercial")
+					m.Respond("uciok")
+					m.Respond("copyprotection checking")
+					m.Respond("copyprotection ok")
+				case msg == "isready":
+					// Engines check their registration once, after the handshake.
+					if !checked {
+						checked = true
+						m.Respond("registration checking")
+						m.Respond("registration error")
+					}
+					m.Respond("readyok")
+				case strings.HasPrefix(msg, "register name"):
+					m.Respond("registration checking")
+					m.Respond("registration " + accept)
+				}
+				return nil
+			},
+		}
+	}
+
+	// Unregistered engines are told that they'll be registered later.
+	var sent []string
+	client, err := NewClient(newTransport(&sent, "ok"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, client.IsReady())
+	assert.Equal(t, []string{"uci", "isready", "register later"}, sent)
+
+	sent = nil
+	client, err = NewClient(newTransport(&sent, "ok"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.SetRegistration("Sean Gillespie", "1234")
+	assert.NoError(t, client.IsReady())
+	assert.NoError(t, client.IsReady())
+	assert.Equal(t, []string{"uci", "isready", "register name Sean Gillespie code 1234", "isready"}, sent)
+
+	sent = nil
+	client, err = NewClient(newTransport(&sent, "error"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.SetRegistration("Sean Gillespie", "0000")
+	assert.NoError(t, client.IsReady())
+	assert.EqualError(t, client.IsReady(), "engine commercial rejected its registration")
+}
+
+func TestCopyProtectionError(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			m.Respond("id name commercial")
+			m.Respond("copyprotection checking")
+			m.Respond("copyprotection error")
+			m.Respond("uciok")
+			return nil
+		},
+	}
+	_, err := NewClient(trans)
+	assert.EqualError(t, err, "engine commercial failed its copy protection check")
+}
+
+func TestKeepalive(t *testing.T) {
+	fake := &ucitest.Engine{}
+	client, err := NewClient(fake)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer client.Close()
+	client.Keepalive(5*time.Millisecond, 20*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, client.Health())
+	assert.Contains(t, fake.Commands(), "isready", "idle engines are pinged")
+
+	// Searches aren't interrupted by pings.
+	move, err := client.Search(engine.Limits{MoveTime: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, ucitest.FirstLegalMove("startpos", nil), move)
+
+	fake.Hang()
+	deadline := time.Now().Add(time.Second)
+	for client.Health() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.EqualError(t, client.Health(), "engine ucitest did not answer isready within 20ms")
+	client.Kill()
+}
+
+func TestPonder(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			sent = append(sent, msg)
+			switch msg {
+			case "uci":
+				m.Respond("id name stockfish")
+				m.Respond("uciok")
+			case "go movetime 100":
+				m.Respond("bestmove e2e4 ponder e7e5")
+			case "go ponder wtime 60000 winc 0 btime 60000 binc 0":
+				m.Respond("info depth 10 score cp 30 pv g1f3 b8c6")
+			case "ponderhit":
+				m.Respond("bestmove g1f3 ponder b8c6")
+			}
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	bestmove, err := client.Search(engine.Limits{MoveTime: 100 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e4", bestmove)
+	assert.Equal(t, "e7e5", client.PonderMove())
+
+	client.OnInfo(func(info engine.Info) {
+		// The opponent plays the expected reply while the engine is pondering on it.
+		assert.NoError(t, client.PonderHit())
+	})
+	bestmove, err = client.Ponder(engine.Limits{WhiteTime: time.Minute, BlackTime: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, "g1f3", bestmove)
+	assert.Equal(t, "b8c6", client.PonderMove())
+	assert.Equal(t, []string{"uci", "go movetime 100", "go ponder wtime 60000 winc 0 btime 60000 binc 0", "ponderhit"}, sent)
+}
+
+func TestGoResult(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("uciok")
+				return nil
+			}
+			m.Respond("info depth 12 score cp -40 pv d7d5 c2c4")
+			m.Respond("bestmove d7d5 ponder c2c4")
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	result, err := client.Go(engine.Limits{Depth: 12})
+	assert.NoError(t, err)
+	assert.Equal(t, "d7d5", result.Move)
+	assert.Equal(t, "c2c4", result.Ponder)
+	assert.Equal(t, 12, result.Info.Depth)
+	assert.Equal(t, engine.Score{Centipawns: -40}, result.Info.Score)
+	assert.Equal(t, client.Info(), result.Info)
+}
+
+func TestSearchContext(t *testing.T) {
+	fake := &ucitest.Engine{Delay: time.Minute}
+	client, err := NewClientContext(context.Background(), fake)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.SearchContext(ctx, engine.Limits{MoveTime: time.Minute})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second, "the search is abandoned when the context is done")
+	assert.Error(t, client.IsReady(), "the engine was killed")
+}
+
+// unkillableTransport is an engine that identifies itself and then never says anything again, and that can't be killed.
+type unkillableTransport struct {
+	lines chan string
+}
+
+func (u *unkillableTransport) Send(msg string) error {
+	if msg == "uci" {
+		u.lines <- "uciok"
+	}
+	return nil
+}
+
+func (u *unkillableTransport) Recv() (string, error) {
+	return <-u.lines, nil
+}
+
+func (u *unkillableTransport) Close() error { return nil }
+
+func TestSearchContextUnkillable(t *testing.T) {
+	client, err := NewClient(&unkillableTransport{lines: make(chan string, 1)})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	returned := make(chan error, 1)
+	go func() {
+		_, err := client.SearchContext(ctx, engine.Limits{MoveTime: time.Minute})
+		returned <- err
+	}()
+	select {
+	case err := <-returned:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(time.Second):
+		t.Fatal("the search waited for an engine that can't be killed")
+	}
+}
+
+func TestConcurrentPonder(t *testing.T) {
+	fake := &ucitest.Engine{Delay: time.Minute}
+	client, err := NewClient(fake)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	moves := make(chan string)
+	go func() {
+		move, err := client.Ponder(engine.Limits{WhiteTime: time.Minute, BlackTime: time.Minute})
+		assert.NoError(t, err)
+		moves <- move
+	}()
+	for fake.Searches() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The engine is checked on from other goroutines while it ponders, and its answer is handed over by the search.
+	ready := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ready <- client.IsReady()
+		}()
+	}
+	assert.NoError(t, <-ready)
+	assert.NoError(t, <-ready)
+	assert.NoError(t, client.Stop())
+	assert.Equal(t, ucitest.FirstLegalMove("startpos", nil), <-moves)
+	assert.NoError(t, client.IsReady())
+}
+
+func TestGoInfinite(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			switch msg {
+			case "uci":
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+			case "go infinite":
+				m.Respond("info depth 30 score cp 20 pv e2e4")
+			case "stop":
+				m.Respond("bestmove e2e4")
+			default:
+				t.Errorf("unexpected message %q", msg)
+			}
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.OnInfo(func(info engine.Info) {
+		assert.NoError(t, client.Stop())
+	})
+	bestmove, err := client.Search(engine.Limits{Infinite: true, WhiteTime: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e4", bestmove)
+}
+
+func TestGoMovesToGo(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+				return nil
+			}
+
+			assert.Equal(t, "go wtime 5 winc 0 btime 5 binc 0 movestogo 40", msg)
+			m.Respond("bestmove e2e4")
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	bestmove, err := client.Search(engine.Limits{
+		WhiteTime: 5 * time.Millisecond, BlackTime: 5 * time.Millisecond, MovesToGo: 40})
 	assert.NoError(t, err)
 	assert.Equal(t, "e2e4", bestmove)
 }
+
+func TestGoScore(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+				return nil
+			}
+
+			m.Respond("info depth 1 score cp 40 pv e2e4")
+			m.Respond("info depth 2 score cp -15 pv d2d4")
+			m.Respond("bestmove d2d4")
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.False(t, client.Info().HasScore)
+
+	var depths []int
+	client.OnInfo(func(info engine.Info) { depths = append(depths, info.Depth) })
+	_, err = client.Search(engine.Limits{WhiteTime: 5 * time.Millisecond, BlackTime: 5 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, depths)
+	info := client.Info()
+	assert.True(t, info.HasScore)
+	assert.Equal(t, engine.Score{Centipawns: -15}, info.Score)
+}
+
+func TestGameStats(t *testing.T) {
+	depth := 0
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			switch {
+			case msg == "uci":
+				m.Respond("id name apollo 0.3.0")
+				m.Respond("uciok")
+			case strings.HasPrefix(msg, "go"):
+				depth += 10
+				m.Respond(fmt.Sprintf("info depth %d nodes 5000 nps 100000 hashfull 200 tbhits 3 pv e2e4", depth))
+				m.Respond("bestmove e2e4")
+			}
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for i := 0; i < 2; i++ {
+		_, err = client.Search(engine.Limits{Depth: 10})
+		assert.NoError(t, err)
+	}
+	stats := client.GameStats()
+	assert.Equal(t, 2, stats.Searches)
+	assert.Equal(t, 15.0, stats.AverageDepth())
+	assert.Equal(t, int64(100000), stats.AverageNPS())
+	assert.Equal(t, 200, stats.AverageHashFull())
+	assert.Equal(t, int64(6), stats.TBHits)
+
+	assert.NoError(t, client.NewGame(false))
+	assert.Equal(t, engine.SearchStats{}, client.GameStats())
+}
+
+func TestRaw(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			sent = append(sent, msg)
+			switch msg {
+			case "uci":
+				m.Respond("id name stockfish")
+				m.Respond("uciok")
+			case "go perft 2":
+				m.Respond("a2a3: 20")
+				m.Respond("b2b3: 20")
+				m.Respond("")
+				m.Respond("Nodes searched: 400")
+			}
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	lines, err := client.Raw("go perft 2", regexp.MustCompile(`^Nodes searched`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a2a3: 20", "b2b3: 20", "", "Nodes searched: 400"}, lines)
+
+	lines, err = client.Raw("flip", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+	assert.Equal(t, []string{"uci", "go perft 2", "flip"}, sent)
+
+	lines, err = client.Raw("eval", regexp.MustCompile(`^Final evaluation`))
+	assert.Error(t, err, "the engine never answers")
+	assert.Empty(t, lines)
+}
+
+func TestInfoUpdate(t *testing.T) {
+	var info engine.Info
+	updateInfo(&info, "info depth 7 seldepth 12 score mate -3 nodes 12345 nps 99000 hashfull 12 pv e2e4 e7e5")
+	updateInfo(&info, "info nps 100000")
+	assert.Equal(t, 7, info.Depth)
+	assert.Equal(t, 12, info.SelDepth)
+	assert.Equal(t, int64(12345), info.Nodes)
+	assert.Equal(t, int64(100000), info.NPS)
+	assert.Equal(t, 12, info.HashFull)
+	assert.True(t, info.HasScore)
+	assert.Equal(t, engine.Score{Mate: -3}, info.Score)
+	assert.Equal(t, []string{"e2e4", "e7e5"}, info.PV)
+}
+
+func TestInfoUpdateMultiPV(t *testing.T) {
+	var info engine.Info
+	updateInfo(&info, "info depth 12 seldepth 18 multipv 1 score cp 35 nodes 50000 pv e2e4 e7e5")
+	updateInfo(&info, "info depth 12 seldepth 16 multipv 2 score cp -40 nodes 52000 pv f2f3 e7e5")
+	assert.Equal(t, 12, info.Depth)
+	assert.Equal(t, int64(52000), info.Nodes)
+	assert.Equal(t, engine.Score{Centipawns: 35}, info.Score)
+	assert.Equal(t, []string{"e2e4", "e7e5"}, info.PV)
+}
+
+func TestSetOption(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("uciok")
+				return nil
+			}
+			sent = append(sent, msg)
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, client.SetOption("Hash", "128"))
+	assert.NoError(t, client.SetOption("Clear Hash", ""))
+	assert.Equal(t, []string{"setoption name Hash value 128", "setoption name Clear Hash"}, sent)
+}
+
+func TestHandshakeOptions(t *testing.T) {
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			m.Respond("id name stockfish")
+			m.Respond("option name Hash type spin default 16 min 1 max 33554432")
+			m.Respond("option name Clear Hash type button")
+			m.Respond("option name Play Style type combo default Normal var Solid var Normal var Really Risky")
+			m.Respond("option name SyzygyPath type string default <empty>")
+			m.Respond("uciok")
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []engine.Option{
+		{Name: "Hash", Type: engine.OptionSpin, Default: "16", Min: 1, Max: 33554432},
+		{Name: "Clear Hash", Type: engine.OptionButton},
+		{Name: "Play Style", Type: engine.OptionCombo, Default: "Normal", Vars: []string{"Solid", "Normal", "Really Risky"}},
+		{Name: "SyzygyPath", Type: engine.OptionString},
+	}, client.Options())
+	assert.True(t, client.HasOption("hash"))
+	assert.False(t, client.HasOption("Threads"))
+}
+
+func TestOptionValidate(t *testing.T) {
+	hash := engine.Option{Name: "Hash", Type: engine.OptionSpin, Min: 1, Max: 1024}
+	assert.NoError(t, hash.Validate("128"))
+	assert.Error(t, hash.Validate("2048"))
+	assert.Error(t, hash.Validate("lots"))
+	unbounded := engine.Option{Name: "Threads", Type: engine.OptionSpin, Min: 1}
+	assert.NoError(t, unbounded.Validate("64"), "no max was declared")
+	assert.Error(t, unbounded.Validate("0"))
+	style := engine.Option{Name: "Style", Type: engine.OptionCombo, Vars: []string{"Solid", "Risky"}}
+	assert.NoError(t, style.Validate("risky"))
+	assert.Error(t, style.Validate("Normal"))
+	ponder := engine.Option{Name: "Ponder", Type: engine.OptionCheck}
+	assert.NoError(t, ponder.Validate("false"))
+	assert.Error(t, ponder.Validate("1"))
+	button := engine.Option{Name: "Clear Hash", Type: engine.OptionButton}
+	assert.NoError(t, button.Validate(""))
+	assert.Error(t, button.Validate("now"))
+}
+
+func TestConfigure(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			switch msg {
+			case "uci":
+				m.Respond("option name Hash type spin default 16 min 1 max 33554432")
+				m.Respond("option name Threads type spin default 1 min 1 max 512")
+				m.Respond("uciok")
+				return nil
+			case "isready":
+				m.Respond("readyok")
+			}
+			sent = append(sent, msg)
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	skipped, err := engine.Configure(client, map[string]string{"Threads": "4", "Hash": "256", "Ponder": "false"})
+	assert.NoError(t, err)
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, "Ponder", skipped[0].Name)
+	}
+	assert.Equal(t, []string{"setoption name Hash value 256", "setoption name Threads value 4", "isready"}, sent,
+		"options are set in order, before isready")
+
+	sent = nil
+	skipped, err = engine.Configure(client, map[string]string{"Threads": "1024", "Hash": "64"})
+	assert.NoError(t, err)
+	if assert.Len(t, skipped, 1, "values outside the declared range are skipped") {
+		assert.Equal(t, "Threads", skipped[0].Name)
+		assert.Error(t, skipped[0].Reason)
+	}
+	assert.Equal(t, []string{"setoption name Hash value 64", "isready"}, sent)
+}
+
+func TestTranscriptTransport(t *testing.T) {
+	inner := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			m.Respond("id name apollo 0.3.0")
+			m.Respond("uciok")
+			return nil
+		},
+	}
+
+	var transcript strings.Builder
+	_, err := NewClient(engine.NewTranscriptTransport(inner, &transcript))
+	assert.NoError(t, err)
+	assert.Equal(t, "> uci\n< id name apollo 0.3.0\n< uciok\n", transcript.String())
+}
+
+func TestIncrementalPosition(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("uciok")
+				return nil
+			}
+			sent = append(sent, msg)
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.SetIncremental(true)
+	assert.NoError(t, client.SetPosition("", []string{"e2e4"}))
+	assert.NoError(t, client.SetPosition("", []string{"e2e4", "e7e5", "g1f3"}))
+	assert.NoError(t, client.SetPosition("", []string{"e2e4", "c7c5"}))
+	assert.Equal(t, []string{
+		"position startpos moves e2e4",
+		"position moves e7e5 g1f3",
+		"position startpos moves e2e4 c7c5",
+	}, sent)
+}
+
+func TestNewGameChess960(t *testing.T) {
+	var sent []string
+	trans := &ucitest.Transport{
+		Server: func(m *ucitest.Transport, msg string) error {
+			if msg == "uci" {
+				m.Respond("id name stockfish")
+				m.Respond("option name UCI_Chess960 type check default false")
+				m.Respond("uciok")
+				return nil
+			}
+			sent = append(sent, msg)
+			return nil
+		},
+	}
+
+	client, err := NewClient(trans)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, client.NewGame(true))
+	assert.Equal(t, []string{"setoption name UCI_Chess960 value true", "ucinewgame"}, sent)
+	assert.NoError(t, client.SetPosition("bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", nil))
+	assert.Equal(t, "position fen bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", sent[2])
+
+	// The engine stays in chess960 mode until a game that isn't chess960.
+	sent = nil
+	assert.NoError(t, client.NewGame(true))
+	assert.NoError(t, client.NewGame(false))
+	assert.NoError(t, client.NewGame(false))
+	assert.Equal(t, []string{"ucinewgame", "setoption name UCI_Chess960 value false", "ucinewgame", "ucinewgame"}, sent)
+}
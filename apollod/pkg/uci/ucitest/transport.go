@@ -0,0 +1,48 @@
+package ucitest
+
+import "io"
+
+// Transport is an engine transport scripted line by line, for tests that need to control exactly what an engine says,
+// in UCI or any other protocol. Server, if set, is called with every line sent to the engine, and answers it with
+// Respond. Recv returns io.EOF once every response has been read. Transports aren't safe for concurrent use.
+type Transport struct {
+	Server func(t *Transport, msg string) error
+
+	sent      []string
+	responses []string
+}
+
+func (t *Transport) Send(msg string) error {
+	t.sent = append(t.sent, msg)
+	if t.Server == nil {
+		return nil
+	}
+	return t.Server(t, msg)
+}
+
+func (t *Transport) Recv() (string, error) {
+	if len(t.responses) == 0 {
+		return "", io.EOF
+	}
+
+	first, rest := t.responses[0], t.responses[1:]
+	t.responses = rest
+	return first, nil
+}
+
+// Respond queues a line for the engine to say.
+func (t *Transport) Respond(msg string) {
+	t.responses = append(t.responses, msg)
+}
+
+// Sent returns every line sent to the engine since the transport was created or last reset.
+func (t *Transport) Sent() []string {
+	return t.sent
+}
+
+// Reset forgets the lines sent to the engine so far.
+func (t *Transport) Reset() {
+	t.sent = nil
+}
+
+func (t *Transport) Close() error { return nil }
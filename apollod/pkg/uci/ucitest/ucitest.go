@@ -0,0 +1,271 @@
+// Package ucitest provides a scriptable UCI engine that runs in memory, for testing code that drives engines through
+// uci without launching a real one, and a transport scripted line by line for testing protocol clients themselves.
+package ucitest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// Engine is a scriptable engine. It speaks enough UCI for a uci.Client, and is itself the client's transport. The
+// zero value plays the first legal move in every position, instantly.
+type Engine struct {
+	// Name is the name the engine identifies itself by during the handshake.
+	Name string
+	// Options are the names of the options the engine declares during the handshake, which are string options unless
+	// the name is followed by the rest of a declaration, as in "Hash type spin default 16 min 1 max 1024".
+	Options []string
+	// BestMove picks the engine's move, given the position ("startpos" or "fen ...") and the moves played from it. If
+	// nil, the engine plays the first legal move.
+	BestMove func(position string, moves []string) string
+	// Score evaluates the position, for the side to move, in the engine's report at the end of every search. If nil,
+	// the engine reports an even position.
+	Score func(position string, moves []string) engine.Score
+	// Ponder, if set, makes the engine say that it expects the first legal reply to its move.
+	Ponder bool
+	// Delay is how long each search takes, unless the engine is told to stop sooner.
+	Delay time.Duration
+	// CrashOnSearch, if nonzero, makes the engine exit without a word when it's asked for this search, counting from
+	// one, as though it had crashed.
+	CrashOnSearch int
+
+	once      sync.Once
+	responses chan string
+	done      chan struct{}
+
+	lock     sync.Mutex
+	commands []string
+	position string
+	moves    []string
+	stop     chan struct{}
+	searches int
+	closed   bool
+	quit     bool
+	hung     bool
+}
+
+func (e *Engine) init() {
+	e.once.Do(func() {
+		e.responses = make(chan string, 64)
+		e.done = make(chan struct{})
+	})
+}
+
+// Commands returns every command the engine has been sent, in order.
+func (e *Engine) Commands() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string(nil), e.commands...)
+}
+
+// Searches returns the number of searches the engine has been asked to do.
+func (e *Engine) Searches() int {
+	searches := 0
+	for _, command := range e.Commands() {
+		if strings.HasPrefix(command, "go") {
+			searches++
+		}
+	}
+	return searches
+}
+
+func (e *Engine) Send(msg string) error {
+	e.init()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed && !e.quit {
+		return engine.ErrCrashed
+	}
+	if e.closed {
+		return io.ErrClosedPipe
+	}
+	e.commands = append(e.commands, msg)
+	if e.hung {
+		return nil
+	}
+
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case "uci":
+		name := e.Name
+		if name == "" {
+			name = "ucitest"
+		}
+		e.respond("id name " + name)
+		e.respond("id author nobody")
+		for _, option := range e.Options {
+			if strings.Contains(option, " type ") {
+				e.respond("option name " + option)
+			} else {
+				e.respond(fmt.Sprintf("option name %s type string default", option))
+			}
+		}
+		e.respond("uciok")
+	case "isready":
+		e.respond("readyok")
+	case "ucinewgame":
+		e.position, e.moves = "startpos", nil
+	case "position":
+		e.setPosition(fields[1:])
+	case "go":
+		if e.searches++; e.searches == e.CrashOnSearch {
+			e.close()
+			return nil
+		}
+		position, moves := e.position, append([]string(nil), e.moves...)
+		e.stop = make(chan struct{})
+		go e.search(position, moves, fields[len(fields)-1] == "infinite", e.stop)
+	case "stop":
+		if e.stop != nil {
+			close(e.stop)
+			e.stop = nil
+		}
+	case "quit":
+		e.quit = true
+		e.close()
+	}
+	return nil
+}
+
+func (e *Engine) Recv() (string, error) {
+	e.init()
+	select {
+	case line := <-e.responses:
+		return line, nil
+	case <-e.done:
+		// Like a real engine's output, this only ends cleanly when the engine was asked to quit.
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		if e.quit {
+			return "", io.EOF
+		}
+		return "", engine.ErrCrashed
+	}
+}
+
+func (e *Engine) Close() error {
+	e.init()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.close()
+	return nil
+}
+
+// Hang makes the engine ignore everything it's sent from now on, as though it were stuck in a loop, until it's killed.
+func (e *Engine) Hang() {
+	e.init()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.hung = true
+}
+
+// Kill stops the engine in the middle of whatever it's doing, like killing a real engine's process would.
+func (e *Engine) Kill() error {
+	return e.Close()
+}
+
+func (e *Engine) close() {
+	if !e.closed {
+		e.closed = true
+		close(e.done)
+	}
+}
+
+func (e *Engine) respond(line string) {
+	e.responses <- line
+}
+
+// setPosition follows a position command, including the incremental "position moves ..." that some clients send.
+func (e *Engine) setPosition(args []string) {
+	var moves []string
+	for i, arg := range args {
+		if arg == "moves" {
+			moves = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+	if len(args) == 0 {
+		e.moves = append(e.moves, moves...)
+		return
+	}
+	e.position, e.moves = strings.Join(args, " "), append([]string(nil), moves...)
+}
+
+// search plays a move after Delay, or when it's stopped. Infinite searches only end when they're stopped.
+func (e *Engine) search(position string, moves []string, infinite bool, stop <-chan struct{}) {
+	timer := time.NewTimer(e.Delay)
+	defer timer.Stop()
+	var delayed <-chan time.Time
+	if !infinite {
+		delayed = timer.C
+	}
+	select {
+	case <-delayed:
+	case <-stop:
+	case <-e.done:
+		return
+	}
+
+	pick := e.BestMove
+	if pick == nil {
+		pick = FirstLegalMove
+	}
+	move := pick(position, moves)
+	score := "cp 0"
+	if e.Score != nil {
+		if evaluated := e.Score(position, moves); evaluated.Mate != 0 {
+			score = fmt.Sprintf("mate %d", evaluated.Mate)
+		} else {
+			score = fmt.Sprintf("cp %d", evaluated.Centipawns)
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	if e.stop == stop {
+		e.stop = nil
+	}
+	e.respond("info depth 1 score " + score + " nodes 1 pv " + move)
+	if reply := FirstLegalMove(position, append(moves, move)); e.Ponder && reply != "0000" {
+		e.respond("bestmove " + move + " ponder " + reply)
+		return
+	}
+	e.respond("bestmove " + move)
+}
+
+// FirstLegalMove picks the first legal move in a position, or "0000" if there aren't any.
+func FirstLegalMove(position string, moves []string) string {
+	options := []func(*chess.Game){chess.UseNotation(chess.LongAlgebraicNotation{})}
+	if strings.HasPrefix(position, "fen ") {
+		fen, err := chess.FEN(strings.TrimPrefix(position, "fen "))
+		if err != nil {
+			return "0000"
+		}
+		options = append(options, fen)
+	}
+	game := chess.NewGame(options...)
+	for _, move := range moves {
+		if err := game.MoveStr(move); err != nil {
+			return "0000"
+		}
+	}
+	valid := game.ValidMoves()
+	if len(valid) == 0 {
+		return "0000"
+	}
+	return valid[0].String()
+}
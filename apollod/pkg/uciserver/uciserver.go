@@ -0,0 +1,390 @@
+// Package uciserver speaks the engine's side of UCI, passing everything it's asked on to a backend engine. It lets
+// apollod stand in for an engine in front of anything that speaks UCI, such as a GUI, while the engine itself runs
+// elsewhere or speaks another protocol, and lets apollod log what's asked or answer from an opening book on the way.
+package uciserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+)
+
+// chess960Option is the standard UCI option that switches an engine into chess960 mode.
+const chess960Option = "UCI_Chess960"
+
+// Book picks a move to play instantly in a position, given as a FEN (empty for the standard starting position) and the
+// moves played from there, returning false if it has none.
+type Book func(fen string, moves []string) (string, bool)
+
+// Server answers UCI commands with a backend engine. Commands are read and answered one at a time, except for
+// searches, which run in the background so that the server can still be told to stop.
+type Server struct {
+	backend engine.Engine
+	book    Book
+
+	// lock serializes writes to the output.
+	lock sync.Mutex
+	out  io.Writer
+
+	// The position most recently set, and whether the next game is chess960, for the book and for NewGame.
+	fen      string
+	moves    []string
+	chess960 bool
+	// searching is closed once the search in progress, if any, has sent its best move.
+	searching chan struct{}
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithBook answers searches from an opening book before they reach the backend. Infinite searches, which are for
+// analysis, always reach the backend.
+func WithBook(book Book) ServerOption {
+	return func(server *Server) {
+		server.book = book
+	}
+}
+
+// NewServer creates a server that passes commands on to backend, which has already been started.
+func NewServer(backend engine.Engine, options ...ServerOption) *Server {
+	server := &Server{backend: backend}
+	for _, option := range options {
+		option(server)
+	}
+	return server
+}
+
+// Serve reads commands from in and writes the answers to out until it's sent quit or in ends. The backend is left
+// running for the caller to shut down. Commands that the backend fails are reported as info strings rather than
+// ending the session, as an engine would.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	defer s.wait()
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		log.WithField("command", line).Debug("received uci command")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" {
+			s.stop()
+			return nil
+		}
+		if err := s.handle(fields); err != nil {
+			log.WithError(err).WithField("command", line).Warn("backend failed uci command")
+			s.send("info string " + err.Error())
+		}
+	}
+	s.stop()
+	return scanner.Err()
+}
+
+// handle answers a single command, split into fields.
+func (s *Server) handle(fields []string) error {
+	switch fields[0] {
+	case "uci":
+		s.identify()
+	case "debug":
+		if debug, ok := s.backend.(debugEngine); ok && len(fields) > 1 {
+			return debug.SetDebug(fields[1] == "on")
+		}
+	case "isready":
+		// A search in progress is answered for by the server reading this, so only an idle backend is asked.
+		if !s.isSearching() {
+			if err := s.backend.IsReady(); err != nil {
+				return err
+			}
+		}
+		s.send("readyok")
+	case "setoption":
+		name, value := parseSetOption(fields[1:])
+		if strings.EqualFold(name, chess960Option) {
+			// The backend is switched in and out of chess960 mode by NewGame, at the start of the next game.
+			s.chess960 = value == "true"
+			return nil
+		}
+		return s.backend.SetOption(name, value)
+	case "register":
+		// Registration is between the backend and whoever runs it.
+	case "ucinewgame":
+		s.wait()
+		return s.backend.NewGame(s.chess960)
+	case "position":
+		fen, moves, err := parsePosition(fields[1:])
+		if err != nil {
+			return err
+		}
+		s.wait()
+		s.fen, s.moves = fen, moves
+		return s.backend.SetPosition(fen, moves)
+	case "go":
+		limits := parseGo(fields[1:])
+		s.wait()
+		s.search(limits)
+	case "stop", "ponderhit":
+		// Ponder searches are run as infinite searches, so the reply that was expected is searched no further.
+		s.stop()
+	default:
+		return errors.Errorf("unknown command %q", strings.Join(fields, " "))
+	}
+	return nil
+}
+
+// debugEngine is implemented by engines that have a debug mode.
+type debugEngine interface {
+	SetDebug(enabled bool) error
+}
+
+// identify answers uci with the backend's name, author, and options. UCI_Chess960 is always declared, since the
+// server handles it itself.
+func (s *Server) identify() {
+	name := s.backend.Name()
+	if name == "" {
+		name = "apollod"
+	}
+	s.send("id name " + name)
+	if author := s.backend.Author(); author != "" {
+		s.send("id author " + author)
+	}
+	declared := false
+	for _, option := range s.backend.Options() {
+		declared = declared || strings.EqualFold(option.Name, chess960Option)
+		s.send(formatOption(option))
+	}
+	if !declared {
+		s.send(formatOption(engine.Option{Name: chess960Option, Type: engine.OptionCheck, Default: "false"}))
+	}
+	s.send("uciok")
+}
+
+// search starts a search in the background, which sends its best move when it's done. A book move is sent at once.
+func (s *Server) search(limits engine.Limits) {
+	if s.book != nil && !limits.Infinite {
+		if move, ok := s.book(s.fen, s.moves); ok {
+			log.WithField("move", move).Debug("answering search from book")
+			s.send("bestmove " + move)
+			return
+		}
+	}
+
+	done := make(chan struct{})
+	s.lock.Lock()
+	s.searching = done
+	s.lock.Unlock()
+	s.backend.OnInfo(func(info engine.Info) {
+		s.send(formatInfo(info))
+	})
+	go func() {
+		defer close(done)
+		result, err := s.backend.Go(limits)
+		s.backend.OnInfo(nil)
+		switch {
+		case engine.IsNoMove(err):
+			s.send("bestmove (none)")
+		case err != nil:
+			log.WithError(err).Warn("backend failed search")
+			s.send("info string " + err.Error())
+			s.send("bestmove (none)")
+		default:
+			if result.Ponder != "" {
+				s.send(fmt.Sprintf("bestmove %s ponder %s", result.Move, result.Ponder))
+				return
+			}
+			s.send("bestmove " + result.Move)
+		}
+	}()
+}
+
+// isSearching returns true if a search is in progress.
+func (s *Server) isSearching() bool {
+	s.lock.Lock()
+	searching := s.searching
+	s.lock.Unlock()
+	if searching == nil {
+		return false
+	}
+	select {
+	case <-searching:
+		return false
+	default:
+		return true
+	}
+}
+
+// stop tells the search in progress, if any, to move now, and waits for it to.
+func (s *Server) stop() {
+	if s.isSearching() {
+		if err := s.backend.Stop(); err != nil {
+			log.WithError(err).Warn("failed to stop backend search")
+		}
+	}
+	s.wait()
+}
+
+// wait waits for the search in progress, if any, to send its best move. UCI leaves commands that change the
+// position during a search undefined, so they wait for it.
+func (s *Server) wait() {
+	s.lock.Lock()
+	searching := s.searching
+	s.lock.Unlock()
+	if searching != nil {
+		<-searching
+	}
+}
+
+// send writes a line to the output, between whole lines written by other goroutines.
+func (s *Server) send(line string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	log.WithField("line", line).Debug("sending uci response")
+	fmt.Fprintln(s.out, line)
+}
+
+// parseSetOption parses the arguments of setoption, "name <name> [value <value>]", where both may contain spaces.
+func parseSetOption(args []string) (string, string) {
+	var name, value []string
+	target := &name
+	for i, arg := range args {
+		switch {
+		case i == 0 && arg == "name":
+		case arg == "value" && target == &name:
+			target = &value
+		default:
+			*target = append(*target, arg)
+		}
+	}
+	return strings.Join(name, " "), strings.Join(value, " ")
+}
+
+// parsePosition parses the arguments of position, "startpos" or "fen <fen>", optionally followed by "moves" and the
+// moves played from there.
+func parsePosition(args []string) (string, []string, error) {
+	var moves []string
+	for i, arg := range args {
+		if arg == "moves" {
+			args, moves = args[:i], append([]string(nil), args[i+1:]...)
+			break
+		}
+	}
+	switch {
+	case len(args) == 1 && args[0] == "startpos":
+		return "", moves, nil
+	case len(args) > 1 && args[0] == "fen":
+		return strings.Join(args[1:], " "), moves, nil
+	default:
+		return "", nil, errors.Errorf("malformed position %q", strings.Join(args, " "))
+	}
+}
+
+// goFields are the words that start each argument of go.
+var goFields = map[string]bool{
+	"searchmoves": true, "ponder": true, "wtime": true, "btime": true, "winc": true, "binc": true, "movestogo": true,
+	"depth": true, "nodes": true, "mate": true, "movetime": true, "infinite": true,
+}
+
+// parseGo parses the arguments of go into search limits. Ponder searches become infinite searches, which the client
+// ends with ponderhit or stop. Arguments that aren't numbers are ignored.
+func parseGo(args []string) engine.Limits {
+	var limits engine.Limits
+	millis := func(value string) time.Duration {
+		n, _ := strconv.Atoi(value)
+		return time.Duration(n) * time.Millisecond
+	}
+	for i := 0; i < len(args); i++ {
+		value := ""
+		if i+1 < len(args) {
+			value = args[i+1]
+		}
+		switch args[i] {
+		case "searchmoves":
+			for i+1 < len(args) && !goFields[args[i+1]] {
+				i++
+				limits.SearchMoves = append(limits.SearchMoves, args[i])
+			}
+			continue
+		case "ponder", "infinite":
+			limits.Infinite = true
+			continue
+		case "wtime":
+			limits.WhiteTime = millis(value)
+		case "btime":
+			limits.BlackTime = millis(value)
+		case "winc":
+			limits.WhiteIncrement = millis(value)
+		case "binc":
+			limits.BlackIncrement = millis(value)
+		case "movestogo":
+			limits.MovesToGo, _ = strconv.Atoi(value)
+		case "depth":
+			limits.Depth, _ = strconv.Atoi(value)
+		case "nodes":
+			limits.Nodes, _ = strconv.ParseInt(value, 10, 64)
+		case "movetime":
+			limits.MoveTime = millis(value)
+		default:
+			continue
+		}
+		i++
+	}
+	return limits
+}
+
+// formatOption declares an option as UCI does, such as "option name Hash type spin default 16 min 1 max 1024".
+func formatOption(option engine.Option) string {
+	parts := []string{"option name", option.Name, "type", option.Type}
+	if option.Type != engine.OptionButton {
+		value := option.Default
+		if value == "" {
+			value = "<empty>"
+		}
+		parts = append(parts, "default", value)
+	}
+	if option.Type == engine.OptionSpin {
+		parts = append(parts, "min", strconv.Itoa(option.Min), "max", strconv.Itoa(option.Max))
+	}
+	for _, v := range option.Vars {
+		parts = append(parts, "var", v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatInfo reports everything the backend has said about its search so far as an info line. Fields the backend
+// never reported are left out.
+func formatInfo(info engine.Info) string {
+	parts := []string{"info"}
+	add := func(name string, value int64) {
+		if value != 0 {
+			parts = append(parts, name, strconv.FormatInt(value, 10))
+		}
+	}
+	add("depth", int64(info.Depth))
+	add("seldepth", int64(info.SelDepth))
+	if info.HasScore {
+		if info.Score.IsMate() {
+			parts = append(parts, "score", "mate", strconv.Itoa(info.Score.Mate))
+		} else {
+			parts = append(parts, "score", "cp", strconv.Itoa(info.Score.Centipawns))
+		}
+	}
+	add("nodes", info.Nodes)
+	add("nps", info.NPS)
+	add("time", int64(info.Time))
+	add("hashfull", int64(info.HashFull))
+	add("tbhits", info.TBHits)
+	if len(info.PV) > 0 {
+		parts = append(parts, "pv", strings.Join(info.PV, " "))
+	}
+	return strings.Join(parts, " ")
+}
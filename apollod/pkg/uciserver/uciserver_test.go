@@ -0,0 +1,96 @@
+package uciserver
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/uci"
+	"github.com/swgillespie/apollo/apollod/pkg/uci/ucitest"
+)
+
+// pipeTransport connects a client to a server's input and output.
+type pipeTransport struct {
+	in  *io.PipeWriter
+	out *bufio.Scanner
+}
+
+func (p *pipeTransport) Send(msg string) error {
+	_, err := io.WriteString(p.in, msg+"\n")
+	return err
+}
+
+func (p *pipeTransport) Recv() (string, error) {
+	if !p.out.Scan() {
+		return "", io.EOF
+	}
+	return p.out.Text(), nil
+}
+
+func (p *pipeTransport) Close() error { return p.in.Close() }
+
+func TestProxy(t *testing.T) {
+	fake := &ucitest.Engine{Name: "backend", Options: []string{"Hash", "UCI_Chess960"}}
+	backend, err := uci.NewClient(fake)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer backend.Close()
+	server := NewServer(backend, WithBook(func(fen string, moves []string) (string, bool) {
+		return "e2e4", fen == "" && len(moves) == 0
+	}))
+
+	commands, in := io.Pipe()
+	out, answers := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(commands, answers)
+		answers.Close()
+	}()
+	client, err := uci.NewClient(&pipeTransport{in: in, out: bufio.NewScanner(out)})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "backend", client.Name())
+	assert.True(t, client.HasOption("Hash"))
+
+	assert.NoError(t, client.SetOption("Hash", "64"))
+	assert.NoError(t, client.NewGame(true))
+	assert.NoError(t, client.SetPosition("", nil))
+	move, err := client.Search(engine.Limits{MoveTime: time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, "e2e4", move, "the book answers")
+
+	assert.NoError(t, client.SetPosition("", []string{"e2e4"}))
+	move, err = client.Search(engine.Limits{MoveTime: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, ucitest.FirstLegalMove("startpos", []string{"e2e4"}), move)
+	assert.NoError(t, client.IsReady())
+	assert.Equal(t, []string{
+		"uci",
+		"setoption name Hash value 64",
+		"setoption name UCI_Chess960 value true",
+		"ucinewgame",
+		"position startpos",
+		"position startpos moves e2e4",
+		"go movetime 10",
+		"isready",
+	}, fake.Commands())
+
+	assert.NoError(t, client.Quit())
+	assert.NoError(t, <-served)
+}
+
+func TestParseGo(t *testing.T) {
+	assert.Equal(t, engine.Limits{
+		WhiteTime:   time.Minute,
+		BlackTime:   30 * time.Second,
+		MovesToGo:   20,
+		SearchMoves: []string{"e2e4", "d2d4"},
+	}, parseGo([]string{"searchmoves", "e2e4", "d2d4", "wtime", "60000", "btime", "30000", "movestogo", "20"}))
+	assert.Equal(t, engine.Limits{Infinite: true}, parseGo([]string{"ponder", "wtime", "x"}))
+}
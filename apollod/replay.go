@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/config"
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+	"github.com/swgillespie/apollo/apollod/pkg/replay"
+)
+
+// runReplay replays a game through the engine and writes it back out with the engine's evaluation of every move and
+// its mistakes and blunders annotated, as a post-mortem of a loss.
+func runReplay(args []string) {
+	flags := newCommandFlags("replay", "game.pgn|gameID")
+	loadConfig := flags.config()
+	enginePath := flags.String("engine", "", "Path to the engine to replay with, instead of the configured engine")
+	depth := flags.Int("depth", replay.DefaultOptions.Depth, "Depth to search every position to")
+	mistake := flags.Int("mistake", replay.DefaultOptions.Mistake, "Centipawns a move must lose to be annotated as a mistake")
+	blunder := flags.Int("blunder", replay.DefaultOptions.Blunder, "Centipawns a move must lose to be annotated as a blunder")
+	out := flags.String("out", "", "File to write the annotated game to, instead of standard output")
+	flags.parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := loadConfig()
+	game, err := findGame(cfg, flags.Arg(0))
+	if err != nil {
+		log.WithError(err).Fatalln("failed to load game")
+	}
+
+	client := launchConfiguredEngine(cfg, *enginePath)
+	summary, err := replay.Replay(client, game, replay.Options{Depth: *depth, Mistake: *mistake, Blunder: *blunder})
+	shutdownEngine(client)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to replay game")
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to create annotated game")
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := game.Write(w); err != nil {
+		log.WithError(err).Fatalln("failed to write annotated game")
+	}
+	log.WithFields(log.Fields{
+		"whiteMistakes": summary.WhiteMistakes,
+		"whiteBlunders": summary.WhiteBlunders,
+		"blackMistakes": summary.BlackMistakes,
+		"blackBlunders": summary.BlackBlunders,
+	}).Info("replayed game")
+}
+
+// findGame loads the game to replay, which is either a PGN file or the ID of a game. Games are looked for in the
+// archive directory, if there is one, and otherwise exported from lichess.
+func findGame(cfg *config.Config, game string) (*pgn.Game, error) {
+	if _, err := os.Stat(game); err == nil {
+		return readGame(game)
+	}
+	if cfg.Archive.Dir != "" {
+		archived := filepath.Join(cfg.Archive.Dir, filepath.Base(game)+".pgn")
+		if _, err := os.Stat(archived); err == nil {
+			return readGame(archived)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	export, err := blitz.New(cfg.Token).Games.ExportGame(ctx, game)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is neither a PGN file nor a game that lichess could export", game)
+	}
+	return pgn.Parse(export)
+}
+
+// readGame reads the first game in a PGN file.
+func readGame(path string) (*pgn.Game, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	game, err := pgn.NewReader(file).Read()
+	if err == io.EOF {
+		return nil, errors.Errorf("%s has no games", path)
+	}
+	return game, err
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/book"
+	"github.com/swgillespie/apollo/apollod/pkg/engine"
+	"github.com/swgillespie/apollo/apollod/pkg/selfplay"
+)
+
+// selfplayFlags are the flags describing a selfplay match. The coordinator only needs its book; the rest are for the
+// workers.
+type selfplayFlags struct {
+	baseline          *string
+	candidate         *string
+	baselineProtocol  *string
+	candidateProtocol *string
+	baselineTime      *string
+	candidateTime     *string
+	depth             *int
+	nodes             *int64
+	benchDepth        *int
+	parallel          *int
+	book              *string
+	bookPly           *int
+	pgn               *string
+	records           *string
+	baselineOptions   engineOptions
+	candidateOptions  engineOptions
+	baselineFile      *string
+	candidateFile     *string
+	baselineProgram   *engine.ProgramOptions
+	candidateProgram  *engine.ProgramOptions
+	adjudication      *selfplay.Adjudication
+	resources         *engine.Resources
+	engineGames       *int
+	autoSize          *bool
+}
+
+func runSelfplay(args []string) {
+	flags := newCommandFlags("selfplay", "")
+	match := selfplayFlags{
+		baseline:          flags.String("baseline", "", "Path to baseline selfplay engine"),
+		candidate:         flags.String("candidate", "", "Path to candidate selfplay engine"),
+		baselineProtocol:  flags.String("baselineProtocol", "uci", "Protocol spoken by the baseline engine: uci, cecp, or grpc for an engine server"),
+		candidateProtocol: flags.String("candidateProtocol", "uci", "Protocol spoken by the candidate engine: uci, cecp, or grpc for an engine server"),
+		baselineTime:      flags.String("baselineTime", "", "Time control for the baseline engine, as base+increment in seconds (e.g. 20+0.2)"),
+		candidateTime:     flags.String("candidateTime", "", "Time control for the candidate engine, as base+increment in seconds (e.g. 10+0.1)"),
+		depth:             flags.Int("depth", 0, "Search every move to this depth, instead of by the clock"),
+		nodes:             flags.Int64("nodes", 0, "Search this many nodes for every move, instead of by the clock"),
+		benchDepth:        flags.Int("benchDepth", 0, "Measure each engine's speed before the match by searching the bench positions to this depth; zero doesn't"),
+		adjudication:      adjudicationFlags(flags),
+		resources:         resourceFlags(flags),
+		engineGames:       engineGamesFlag(flags),
+		autoSize:          autoSizeFlag(flags),
+		parallel:          flags.Int("parallel", runtime.NumCPU(), "Number of games to play in parallel"),
+		book:              flags.String("book", "", "Opening book to start games from, as PGN games or a Polyglot .bin book"),
+		bookPly:           flags.Int("bookPly", 8, "Number of plies to play from the opening book"),
+		pgn:               flags.String("pgn", "", "File to write every game to, as PGN"),
+		records:           flags.String("records", "", "File to write every game to, as JSON game records"),
+	}
+	match.baselineOptions, match.candidateOptions = engineOptions{}, engineOptions{}
+	flags.Var(match.baselineOptions, "baselineOption", "Engine option for the baseline engine, as name=value (e.g. Hash=64); may be repeated")
+	flags.Var(match.candidateOptions, "candidateOption", "Engine option for the candidate engine, as name=value (e.g. Threads=4); may be repeated")
+	match.baselineFile = flags.String("baselineOptionsFile", "", "YAML or JSON file of engine options for the baseline engine, beneath any -baselineOption")
+	match.candidateFile = flags.String("candidateOptionsFile", "", "YAML or JSON file of engine options for the candidate engine, beneath any -candidateOption")
+	match.baselineProgram = programFlags(flags, "baseline")
+	match.candidateProgram = programFlags(flags, "candidate")
+	numGames := flags.Int("games", 40, "Number of games to play")
+	coordinatorAddr := flags.String("coordinator", "", "Run as a distributed selfplay coordinator listening on this address")
+	workerOf := flags.String("worker", "", "Run as a distributed selfplay worker for the coordinator at this URL")
+	sprt := &selfplay.SPRT{}
+	flags.Float64Var(&sprt.Elo0, "sprtElo0", 0, "Elo gain the coordinator's SPRT rejects the candidate at or below")
+	flags.Float64Var(&sprt.Elo1, "sprtElo1", 0, "Elo gain the coordinator's SPRT accepts the candidate at or above; the SPRT runs only if this is above -sprtElo0, ending the run once it decides, with -games as the most it plays")
+	flags.Float64Var(&sprt.Alpha, "sprtAlpha", 0.05, "False positive rate of the coordinator's SPRT")
+	flags.Float64Var(&sprt.Beta, "sprtBeta", 0.05, "False negative rate of the coordinator's SPRT")
+	metricsAddr := flags.String("metrics", "", "Address to serve Prometheus metrics on while playing, such as :9091")
+	flags.parse(args)
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	switch {
+	case *coordinatorAddr != "":
+		coordinator := &selfplay.Coordinator{
+			NumGames:   *numGames,
+			Openings:   loadOpenings(*match.book, *match.bookPly),
+			OpeningPly: *match.bookPly,
+		}
+		if sprt.Elo1 > sprt.Elo0 {
+			coordinator.SPRT = sprt
+		}
+		runCoordinator(*coordinatorAddr, coordinator)
+	case *workerOf != "":
+		runWorker(*workerOf, match)
+	default:
+		session := newSelfplaySession(match)
+		session.NumGames = *numGames
+		res, err := session.Run(context.Background())
+		if err != nil {
+			log.WithError(err).Fatalln("failed to run selfplay")
+		}
+		printScore(res)
+	}
+}
+
+// adjudicationFlags adds the flags that end decided games early to a command.
+func adjudicationFlags(flags *commandFlags) *selfplay.Adjudication {
+	adjudication := &selfplay.Adjudication{}
+	flags.IntVar(&adjudication.ResignScore, "resignScore", 1000, "Score in centipawns at which -resignMoves gives the game to the side ahead")
+	flags.IntVar(&adjudication.ResignMoves, "resignMoves", 0, "Give the game to a side once both engines score it -resignScore ahead for this many moves each; zero never does")
+	flags.IntVar(&adjudication.DrawScore, "drawScore", 10, "Score in centipawns within which -drawMoves counts the game as even")
+	flags.IntVar(&adjudication.DrawMoves, "drawMoves", 0, "Draw the game once both engines score it within -drawScore of even for this many moves each; zero never does")
+	flags.IntVar(&adjudication.DrawAfter, "drawAfter", 40, "Move number before which -drawMoves doesn't draw games")
+	return adjudication
+}
+
+// resourceFlags adds the flags that cap what each engine program may use to a command.
+func resourceFlags(flags *commandFlags) *engine.Resources {
+	resources := &engine.Resources{}
+	flags.IntVar(&resources.MemoryMB, "engineMemory", 0, "Most memory each engine may map, in megabytes (Linux only); zero is unlimited")
+	flags.DurationVar(&resources.CPUTime, "engineCPU", 0, "Most CPU time each engine may use before it's killed (Linux only); zero is unlimited")
+	flags.DurationVar(&resources.Timeouts.Handshake, "engineHandshakeTimeout", 0, "How long each engine may take to identify itself; zero waits forever")
+	flags.DurationVar(&resources.Timeouts.Reply, "engineReplyTimeout", 0, "How long each engine may take to answer isready; zero waits forever")
+	flags.DurationVar(&resources.Timeouts.Search, "engineSearchTimeout", 0, "How long each engine may go without a word while it searches; zero waits forever")
+	return resources
+}
+
+// programFlags adds the flags that say how one side's engine program is run to a command.
+func programFlags(flags *commandFlags, side string) *engine.ProgramOptions {
+	options := &engine.ProgramOptions{Env: engineOptions{}}
+	flags.Var((*programArgs)(&options.Args), side+"Arg", "Command-line argument for the "+side+" engine program (e.g. --weights=net.pb); may be repeated")
+	flags.Var(engineOptions(options.Env), side+"Env", "Environment variable for the "+side+" engine program, as name=value; may be repeated")
+	flags.StringVar(&options.Dir, side+"Dir", "", "Working directory of the "+side+" engine program; empty is apollod's")
+	return options
+}
+
+// engineGamesFlag adds the flag that keeps engines running between games to a command.
+func engineGamesFlag(flags *commandFlags) *int {
+	return flags.Int("engineGames", 0, "Keep each engine running for this many games instead of restarting it every game; zero restarts it every game")
+}
+
+// autoSizeFlag adds the flag that sizes engines for the machine to a command.
+func autoSizeFlag(flags *commandFlags) *bool {
+	return flags.Bool("autoSize", false, "Set each engine's Hash and Threads, unless an option sets them, to an equal share of the machine's memory and CPUs for every engine playing at once")
+}
+
+func newSelfplaySession(match selfplayFlags) *selfplay.Session {
+	session := &selfplay.Session{
+		BaselineProgram:         *match.baseline,
+		CandidateProgram:        *match.candidate,
+		BaselineProtocol:        *match.baselineProtocol,
+		CandidateProtocol:       *match.candidateProtocol,
+		BaselineOptions:         withOptionsFile(match.baselineOptions, *match.baselineFile),
+		CandidateOptions:        withOptionsFile(match.candidateOptions, *match.candidateFile),
+		BaselineProgramOptions:  *match.baselineProgram,
+		CandidateProgramOptions: *match.candidateProgram,
+		Depth:                   *match.depth,
+		Nodes:                   *match.nodes,
+		BenchDepth:              *match.benchDepth,
+		Adjudication:            *match.adjudication,
+		Resources:               *match.resources,
+		EngineGames:             *match.engineGames,
+		AutoSize:                *match.autoSize,
+		NumParallelGames:        *match.parallel,
+		Openings:                loadOpenings(*match.book, *match.bookPly),
+		OpeningPly:              *match.bookPly,
+		PGN:                     createPGN(*match.pgn),
+		Records:                 createRecords(*match.records),
+	}
+
+	if session.BaselineProgram == "" {
+		log.Fatalln("baseline engine not provided")
+	}
+	if session.CandidateProgram == "" {
+		log.Fatalln("candidate engine not provided")
+	}
+
+	var err error
+	if session.BaselineTime, err = selfplay.ParseTimeControl(*match.baselineTime); err != nil {
+		log.WithError(err).Fatalln("failed to parse baseline time control")
+	}
+	if session.CandidateTime, err = selfplay.ParseTimeControl(*match.candidateTime); err != nil {
+		log.WithError(err).Fatalln("failed to parse candidate time control")
+	}
+	return session
+}
+
+func runCoordinator(addr string, coordinator *selfplay.Coordinator) {
+	svr := &http.Server{Addr: addr, Handler: coordinator}
+	go func() {
+		log.WithField("addr", addr).Info("coordinator waiting for workers")
+		if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Fatalln("failed to launch coordinator")
+		}
+	}()
+
+	res, err := coordinator.Wait(context.Background())
+	if err != nil {
+		log.WithError(err).Fatalln("failed to run selfplay")
+	}
+
+	// Leave the server up briefly so that idle workers observe that the run is over.
+	time.Sleep(10 * time.Second)
+	svr.Close()
+	printScore(res)
+}
+
+func runWorker(coordinatorURL string, match selfplayFlags) {
+	worker := &selfplay.Worker{
+		CoordinatorURL: strings.TrimSuffix(coordinatorURL, "/"),
+		Session:        newSelfplaySession(match),
+	}
+	if err := worker.Run(context.Background()); err != nil {
+		log.WithError(err).Fatalln("failed to run selfplay worker")
+	}
+}
+
+// loadOpenings loads the opening book for selfplay games, if there is one.
+func loadOpenings(path string, maxPly int) *book.Book {
+	if path == "" {
+		return nil
+	}
+	openings, err := book.Open(path, maxPly)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to load opening book")
+	}
+	log.WithFields(log.Fields{
+		"path":      path,
+		"positions": openings.Len(),
+	}).Info("loaded opening book")
+	return openings
+}
+
+// engineOptions are engine options given on the command line, each as a name=value flag.
+// programArgs are command-line arguments for an engine program, given one per flag.
+type programArgs []string
+
+func (a *programArgs) String() string {
+	return strings.Join(*a, " ")
+}
+
+func (a *programArgs) Set(arg string) error {
+	*a = append(*a, arg)
+	return nil
+}
+
+type engineOptions map[string]string
+
+func (o engineOptions) String() string {
+	var options []string
+	for name, value := range o {
+		options = append(options, name+"="+value)
+	}
+	sort.Strings(options)
+	return strings.Join(options, ",")
+}
+
+func (o engineOptions) Set(option string) error {
+	parts := strings.SplitN(option, "=", 2)
+	if strings.TrimSpace(parts[0]) == "" {
+		return errors.Errorf("invalid engine option %q, expected name=value", option)
+	}
+	if len(parts) == 1 {
+		// Buttons have no value.
+		parts = append(parts, "")
+	}
+	o[strings.TrimSpace(parts[0])] = parts[1]
+	return nil
+}
+
+// withOptionsFile adds the options in a file, if there is one, to those given on the command line, which take
+// precedence.
+func withOptionsFile(options engineOptions, path string) map[string]string {
+	if path == "" {
+		return options
+	}
+	merged, err := engine.LoadOptions(path)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to load engine options")
+	}
+	for name, value := range options {
+		merged[name] = value
+	}
+	return merged
+}
+
+// createPGN creates the file that selfplay games are written to, if there is one. It is left for the process's exit
+// to close.
+func createPGN(path string) io.Writer {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to create PGN file")
+	}
+	return file
+}
+
+// createRecords creates the file that selfplay game records are written to, if there is one. Like the PGN file, it is
+// left for the process's exit to close.
+func createRecords(path string) io.Writer {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to create game record file")
+	}
+	return file
+}
+
+func printScore(res *selfplay.Result) {
+	candidateScore := float64(res.Wins)
+	baselineScore := float64(res.Losses)
+	candidateScore += float64(res.Draws) / float64(2)
+	baselineScore += float64(res.Draws) / float64(2)
+	fmt.Printf("final score: %f-%f\n", candidateScore, baselineScore)
+	if res.BaselineBench != nil && res.CandidateBench != nil {
+		fmt.Printf("baseline speed: %d nodes in %s, %d knps\n", res.BaselineBench.Nodes, res.BaselineBench.Time, res.BaselineBench.NPS()/1000)
+		fmt.Printf("candidate speed: %d nodes in %s, %d knps\n", res.CandidateBench.Nodes, res.CandidateBench.Time, res.CandidateBench.NPS()/1000)
+	}
+	printSearchStats("baseline", res.BaselineStats)
+	printSearchStats("candidate", res.CandidateStats)
+	if res.Decision != selfplay.DecisionContinue {
+		fmt.Printf("SPRT: %s\n", res.Decision)
+	}
+}
+
+// printSearchStats prints how deep and how fast an engine searched over a match, if it reported either.
+func printSearchStats(side string, stats engine.SearchStats) {
+	if stats.Depth == 0 && stats.NPS == 0 {
+		return
+	}
+	fmt.Printf("%s searches: %d moves, average depth %.1f, %d knps, hash %d%% full, %d tbhits\n", side, stats.Searches,
+		stats.AverageDepth(), stats.AverageNPS()/1000, stats.AverageHashFull()/10, stats.TBHits)
+}
+
+func runTournament(args []string) {
+	flags := newCommandFlags("tournament", "engine engine [engine...]")
+	games := flags.Int("games", 20, "Number of games each pair of engines plays")
+	parallel := flags.Int("parallel", runtime.NumCPU(), "Number of games to play in parallel")
+	timeControl := flags.String("time", "", "Time control for every engine, as base+increment in seconds (e.g. 10+0.1)")
+	bookPath := flags.String("book", "", "Opening book to start games from, as PGN games or a Polyglot .bin book")
+	bookPly := flags.Int("bookPly", 8, "Number of plies to play from the opening book")
+	pgnPath := flags.String("pgn", "", "File to write every game to, as PGN")
+	recordsPath := flags.String("records", "", "File to write every game to, as JSON game records")
+	adjudication := adjudicationFlags(flags)
+	resources := resourceFlags(flags)
+	engineGames := engineGamesFlag(flags)
+	autoSize := autoSizeFlag(flags)
+	options := engineOptions{}
+	flags.Var(options, "option", "Engine option for every engine, as name=value (e.g. Hash=64); may be repeated")
+	optionsFile := flags.String("optionsFile", "", "YAML or JSON file of engine options for every engine, beneath any -option")
+	metricsAddr := flags.String("metrics", "", "Address to serve Prometheus metrics on while playing, such as :9091")
+	flags.parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	tournament := &selfplay.Tournament{
+		Programs:         flags.Args(),
+		GamesPerPairing:  *games,
+		NumParallelGames: *parallel,
+		Openings:         loadOpenings(*bookPath, *bookPly),
+		OpeningPly:       *bookPly,
+		PGN:              createPGN(*pgnPath),
+		Records:          createRecords(*recordsPath),
+		Options:          withOptionsFile(options, *optionsFile),
+		Adjudication:     *adjudication,
+		Resources:        *resources,
+		EngineGames:      *engineGames,
+		AutoSize:         *autoSize,
+	}
+	var err error
+	if tournament.Time, err = selfplay.ParseTimeControl(*timeControl); err != nil {
+		log.WithError(err).Fatalln("failed to parse time control")
+	}
+	standings, err := tournament.Run(context.Background())
+	if err != nil {
+		log.WithError(err).Fatalln("failed to run tournament")
+	}
+
+	fmt.Printf("%-4s %-40s %7s %5s %5s %5s\n", "#", "engine", "score", "won", "lost", "drawn")
+	for i, standing := range standings {
+		fmt.Printf("%-4d %-40s %7.1f %5d %5d %5d\n",
+			i+1, standing.Program, standing.Score(), standing.Wins, standing.Losses, standing.Draws)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/crash"
+	"github.com/swgillespie/apollo/apollod/pkg/logfile"
+	"github.com/swgillespie/apollo/apollod/pkg/metrics"
+	"github.com/swgillespie/apollo/apollod/pkg/results"
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+	"github.com/swgillespie/apollo/apollod/pkg/store"
+	"github.com/swgillespie/apollo/apollod/pkg/tui"
+)
+
+// The smoke test plays a blitz game, long enough to exercise the engine but short enough to wait for.
+const (
+	smokeTestClockLimit     = 180
+	smokeTestClockIncrement = 2
+)
+
+func runServe(args []string) {
+	flags := newCommandFlags("serve", "")
+	loadConfig := flags.config()
+	dashboard := flags.Bool("tui", false, "Show a live status dashboard in the terminal instead of log output")
+	flags.parse(args)
+
+	cfg := loadConfig()
+	if cfg.Token == "" {
+		log.Fatalln("no lichess token configured, set token or LICHESS_TOKEN")
+	}
+	var logFiles io.Writer = ioutil.Discard
+	if cfg.Logging.Files.Dir != "" {
+		files, err := logfile.Open(cfg.Logging.Files)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to open log files")
+		}
+		defer files.Close()
+		logFiles = files
+		log.SetOutput(io.MultiWriter(os.Stderr, files))
+		log.WithField("dir", files.Dir()).Info("writing logs to files")
+	}
+	if cfg.Metrics.Addr != "" {
+		go serveMetrics(cfg.Metrics.Addr)
+	}
+
+	accounts, err := cfg.AccountOptions()
+	if err != nil {
+		log.WithError(err).Fatalln("failed to configure server")
+	}
+	var reporter *crash.Reporter
+	if cfg.CrashReports.DSN != "" {
+		if reporter, err = crash.New(cfg.CrashReports.DSN, version); err != nil {
+			log.WithError(err).Fatalln("failed to configure crash reporting")
+		}
+		log.AddHook(reporter.Hook())
+	}
+
+	var servers []*server.Server
+	reload := reloader(flags, &servers)
+	for _, account := range accounts {
+		options := append(account.Options, server.WithReload(reload), server.WithCrashReporter(reporter))
+		svr, err := server.NewServer(account.Token, options...)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to assume lichess account role")
+		}
+		servers = append(servers, svr)
+	}
+	if cfg.Admin.Addr != "" {
+		go serveAdmin(cfg.Admin.Addr, servers)
+	}
+	if cfg.Events.Addr != "" {
+		go serveEvents(cfg.Events.Addr, servers)
+	}
+
+	// SIGHUP reloads the configuration, as is traditional for daemons.
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			log.Info("received SIGHUP, reloading configuration")
+			if err := reload(); err != nil {
+				log.WithError(err).Error("failed to reload configuration, keeping the current one")
+			}
+		}
+	}()
+
+	stopDashboard := func() {}
+	if *dashboard {
+		stopDashboard = startDashboard(servers, logFiles)
+	}
+
+	// Each account's server runs until the process is told to stop; any of them failing to start is fatal.
+	ctx, stop := context.WithCancel(context.Background())
+	go stopOnSignal(servers, stop)
+	errs := make(chan error, len(servers))
+	for _, svr := range servers {
+		go func(svr *server.Server) {
+			if reporter != nil {
+				defer reporter.Recover(nil)
+			}
+			errs <- svr.Run(ctx)
+		}(svr)
+	}
+	for range servers {
+		if err := <-errs; err != nil {
+			stopDashboard()
+			log.WithError(err).Fatalln("failed to launch server")
+		}
+	}
+	stopDashboard()
+	log.Info("every server has stopped")
+}
+
+// reloader returns a function that reloads the configuration file into every account's server. Adding or removing
+// accounts, and changing anything outside of server.Reloadable, still takes a restart.
+func reloader(flags *commandFlags, servers *[]*server.Server) func() error {
+	var lock sync.Mutex
+	return func() error {
+		lock.Lock()
+		defer lock.Unlock()
+		cfg, err := flags.loadConfig()
+		if err != nil {
+			return err
+		}
+		reloads := cfg.AccountReloads()
+		if len(reloads) != len(*servers) {
+			log.Warn("accounts were added or removed, which takes a restart, so only the existing accounts are reloaded")
+		}
+		for i, svr := range *servers {
+			if i >= len(reloads) {
+				break
+			}
+			if err := svr.Reload(reloads[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// runSmokeTest plays the main account against the lichess AI and exits with a nonzero status unless the game is
+// played to a finish.
+func runSmokeTest(args []string) {
+	flags := newCommandFlags("smoketest", "")
+	loadConfig := flags.config()
+	aiLevel := flags.Int("aiLevel", 1, "Strength of the lichess AI to play, from 1 to 8")
+	timeout := flags.Duration("timeout", 30*time.Minute, "Longest to wait for the game to finish")
+	flags.parse(args)
+
+	cfg := loadConfig()
+	if cfg.Token == "" {
+		log.Fatalln("no lichess token configured, set token or LICHESS_TOKEN")
+	}
+	options, err := cfg.ServerOptions()
+	if err != nil {
+		log.WithError(err).Fatalln("failed to configure server")
+	}
+	svr, err := server.NewServer(cfg.Token, options...)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to assume lichess account role")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	result, err := svr.SmokeTest(ctx, server.SmokeTestConfig{
+		Level:          *aiLevel,
+		ClockLimit:     smokeTestClockLimit,
+		ClockIncrement: smokeTestClockIncrement,
+	})
+	if err != nil {
+		log.WithError(err).Fatalln("smoke test failed")
+	}
+	log.WithField("result", result).Info("smoke test passed")
+}
+
+// runReport prints a performance report over the configured results file or database.
+func runReport(args []string) {
+	flags := newCommandFlags("report", "daily|weekly")
+	loadConfig := flags.config()
+	flags.parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := loadConfig()
+	var recorder results.Recorder = &results.Store{Path: cfg.Results.Path}
+	switch {
+	case cfg.Database.Driver != "":
+		db, err := store.Open(cfg.Database.Driver, cfg.Database.DSN)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to open database")
+		}
+		defer db.Close()
+		recorder = db
+	case cfg.Results.Path == "":
+		log.Fatalln("no results file or database configured")
+	}
+
+	var since time.Time
+	switch period := flags.Arg(0); period {
+	case "daily":
+		since = time.Now().Add(-24 * time.Hour)
+	case "weekly":
+		since = time.Now().Add(-7 * 24 * time.Hour)
+	default:
+		log.Fatalf("unknown report period %q, expected daily or weekly", period)
+	}
+
+	records, err := recorder.Since(since)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to read results")
+	}
+	fmt.Print(results.Summarize(since, records))
+}
+
+// startDashboard replaces log output on the terminal with a live dashboard of every server, leaving logs written to
+// logFiles alone. It returns a function that takes the dashboard down and restores log output.
+func startDashboard(servers []*server.Server, logFiles io.Writer) func() {
+	logs := &tui.LogBuffer{}
+	log.AddHook(logs)
+	previous := log.StandardLogger().Out
+	log.SetOutput(logFiles)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tui.Run(ctx, os.Stdout, func() []server.Status {
+			statuses := make([]server.Status, 0, len(servers))
+			for _, svr := range servers {
+				statuses = append(statuses, svr.Status())
+			}
+			return statuses
+		}, logs)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+			log.SetOutput(previous)
+		})
+	}
+}
+
+// stopOnSignal drains every server when the process is interrupted or terminated, so that no game is abandoned, and
+// then calls stop to stop them. A second signal stops them without waiting for their games to finish.
+func stopOnSignal(servers []*server.Server, stop context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Info("draining games in progress before exiting, signal again to abandon them")
+	ctx, abandon := context.WithCancel(context.Background())
+	go func() {
+		<-signals
+		abandon()
+	}()
+
+	var wg sync.WaitGroup
+	for _, svr := range servers {
+		wg.Add(1)
+		go func(svr *server.Server) {
+			defer wg.Done()
+			if err := svr.Drain(ctx); err != nil {
+				log.WithError(err).WithField("account", svr.Username()).Warn("abandoning games in progress")
+			}
+		}(svr)
+	}
+	wg.Wait()
+	stop()
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	log.WithField("addr", addr).Info("serving metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.WithError(err).Error("failed to serve metrics")
+	}
+}
+
+// serveAdmin serves the admin API. The main account's is served at the root, and every account's, including the main
+// account's, is also served under /accounts/{username}/.
+func serveAdmin(addr string, servers []*server.Server) {
+	mux := http.NewServeMux()
+	for _, svr := range servers {
+		prefix := "/accounts/" + strings.ToLower(svr.Username())
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, svr.AdminHandler()))
+	}
+	mux.Handle("/", servers[0].AdminHandler())
+	log.WithField("addr", addr).Info("serving admin API")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.WithError(err).Error("failed to serve admin API")
+	}
+}
+
+// serveEvents streams live game events. The main account's are served at /events, and every account's, including the
+// main account's, under /accounts/{username}/events.
+func serveEvents(addr string, servers []*server.Server) {
+	mux := http.NewServeMux()
+	for _, svr := range servers {
+		mux.Handle("/accounts/"+strings.ToLower(svr.Username())+"/events", svr.EventsHandler())
+	}
+	mux.Handle("/events", servers[0].EventsHandler())
+	log.WithField("addr", addr).Info("serving live game events")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.WithError(err).Error("failed to serve live game events")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/blitz"
+	"github.com/swgillespie/apollo/apollod/pkg/blitz/blitztest"
+	"github.com/swgillespie/apollo/apollod/pkg/config"
+	"github.com/swgillespie/apollo/apollod/pkg/gamerecord"
+	"github.com/swgillespie/apollo/apollod/pkg/selfplay"
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+)
+
+// stressTestAccount is the bot account on the fake lichess that stress tests are played on.
+var stressTestAccount = blitz.AccountResponse{ID: "apollo", Username: "apollo", Title: "BOT"}
+
+// runStressTest replays recorded games against the configured engine on a simulated lichess whose clocks run in real
+// time and whose connection misbehaves as asked, to check that time management and the watchdog hold up before
+// they're trusted with rated bullet. It exits with an error if the bot lost any game on time.
+func runStressTest(args []string) {
+	flags := newCommandFlags("stresstest", "games.pgn|games.json...")
+	loadConfig := flags.config()
+	clock := flags.String("clock", "", "Time control for every game, as base+increment in seconds (e.g. 60+0), instead of each game's own")
+	latency := flags.Duration("latency", 100*time.Millisecond, "Time every move takes to reach lichess")
+	jitter := flags.Duration("jitter", 100*time.Millisecond, "Up to this much more latency for each move, at random")
+	skew := flags.Duration("skew", 0, "Added to the bot's clock as lichess reports it, so that it thinks it has more time than it does")
+	stallEvery := flags.Int("stallEvery", 0, "Stall the game stream before every this many events; zero never stalls")
+	stallFor := flags.Duration("stallFor", time.Second, "How long each game stream stall lasts")
+	opponentDelay := flags.Duration("opponentDelay", 500*time.Millisecond, "How long the opponent thinks before each move")
+	flags.parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var games []*gamerecord.Record
+	for _, path := range flags.Args() {
+		input, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to read games")
+		}
+		records, err := readRecords(input)
+		if err != nil {
+			log.WithError(err).WithField("path", path).Fatalln("failed to read games")
+		}
+		games = append(games, records...)
+	}
+	stressConfig := server.StressTestConfig{Games: games, OpponentDelay: *opponentDelay}
+	if *clock != "" {
+		control, err := selfplay.ParseTimeControl(*clock)
+		if err != nil {
+			log.WithError(err).Fatalln("failed to parse clock")
+		}
+		stressConfig.Clock = blitz.Clock{
+			Initial:   int(control.Base / time.Millisecond),
+			Increment: int(control.Increment / time.Millisecond),
+		}
+	}
+
+	lichess := blitztest.NewServer(stressTestAccount)
+	defer lichess.Close()
+	lichess.Simulate(blitztest.Conditions{
+		Latency:    *latency,
+		Jitter:     *jitter,
+		ClockSkew:  *skew,
+		StallEvery: *stallEvery,
+		StallFor:   *stallFor,
+	})
+	options, err := stressTestOptions(loadConfig())
+	if err != nil {
+		log.WithError(err).Fatalln("failed to configure server")
+	}
+	svr, err := server.NewServer("token", append(options, server.WithLichessURL(lichess.URL))...)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to start server")
+	}
+
+	played, err := svr.StressTest(context.Background(), lichess, stressConfig)
+	flagged := 0
+	for _, game := range played {
+		fmt.Println(game)
+		if game.Flagged() {
+			flagged++
+		}
+	}
+	if err != nil {
+		log.WithError(err).Fatalln("stress test failed")
+	}
+	if flagged > 0 {
+		log.WithField("flagged", flagged).Fatalln("bot lost on time")
+	}
+	log.WithField("games", len(played)).Info("stress test passed")
+}
+
+// stressTestOptions configures a server from the configuration as it would play on lichess, except that nothing it
+// plays is recorded anywhere, and it doesn't go looking for games of its own.
+func stressTestOptions(cfg *config.Config) ([]server.ServerOption, error) {
+	cfg.Archive = config.ArchiveConfig{}
+	cfg.Results.Path = ""
+	cfg.Database = config.DatabaseConfig{}
+	cfg.Matchmaking.Enabled = false
+	cfg.Blocklist = server.DefaultBlocklistConfig()
+	return cfg.ServerOptions()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/swgillespie/apollo/apollod/pkg/pgn"
+	"github.com/swgillespie/apollo/apollod/pkg/uciserver"
+)
+
+// runUCIProxy speaks UCI on stdin and stdout, passing everything on to the configured engine, so that a GUI can play
+// with an engine that runs elsewhere, such as behind an engine server, or through an opening book. Logs go to stderr,
+// out of the GUI's way.
+func runUCIProxy(args []string) {
+	flags := newCommandFlags("uci", "")
+	loadConfig := flags.config()
+	enginePath := flags.String("engine", "", "Path or address of the engine to pass commands on to, instead of the configured engine")
+	protocol := flags.String("protocol", "", "Protocol the engine speaks, instead of the configured protocol")
+	bookPath := flags.String("book", "", "Opening book (PGN, or Polyglot with the .bin extension) to answer searches from before they reach the engine")
+	flags.parse(args)
+
+	cfg := loadConfig()
+	profile := cfg.Engine.EngineProfile
+	if *enginePath != "" {
+		profile.Path = *enginePath
+	}
+	if *protocol != "" {
+		profile.Protocol = *protocol
+	}
+	var options []uciserver.ServerOption
+	if openings := loadOpenings(*bookPath, 0); openings != nil {
+		options = append(options, uciserver.WithBook(func(fen string, moves []string) (string, bool) {
+			game, err := pgn.NewGame(fen, moves)
+			if err != nil {
+				return "", false
+			}
+			board, err := game.Board()
+			if err != nil {
+				return "", false
+			}
+			return openings.Lookup(board.Position())
+		}))
+	}
+
+	client, err := launchEngine(profile)
+	if err != nil {
+		log.WithError(err).Fatalln("failed to start engine")
+	}
+	defer shutdownEngine(client)
+	if err := uciserver.NewServer(client, options...).Serve(os.Stdin, os.Stdout); err != nil {
+		log.WithError(err).Error("failed to read uci commands")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/swgillespie/apollo/apollod/pkg/server"
+)
+
+// version and commit identify the build. Releases set them with -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// runVersion prints apollod's version along with the name the configured engine reports in its handshake, so that a
+// bug report identifies both.
+func runVersion(args []string) {
+	flags := newCommandFlags("version", "")
+	loadConfig := flags.config()
+	enginePath := flags.String("engine", "", "Path to the engine to identify, instead of the configured engine")
+	flags.parse(args)
+
+	fmt.Printf("apollod %s (commit %s, %s %s/%s)\n", version, commit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	profile := server.EngineProfile{Path: *enginePath}
+	if *enginePath == "" {
+		profile = loadConfig().Engine.EngineProfile
+	}
+	// Options don't change the engine's identity, so the probe doesn't bother applying them.
+	profile.Options = nil
+	client, err := launchEngine(profile)
+	if err != nil {
+		fmt.Printf("engine unavailable: %v\n", err)
+		return
+	}
+	defer shutdownEngine(client)
+	fmt.Printf("engine %s", client.Name())
+	if client.Author() != "" {
+		fmt.Printf(" by %s", client.Author())
+	}
+	fmt.Println()
+}